@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//adoptionRow is how a foundation's or a space's apps were updated in the window, by
+//deployment without downtime or by a restart that stopped them. Org and Space are empty for
+//a foundation's totals
+type adoptionRow struct {
+	Foundation          string  `json:"foundation"`
+	Org                 string  `json:"org,omitempty"`
+	Space               string  `json:"space,omitempty"`
+	AppUpdates          int     `json:"app_updates"`
+	Deployments         int     `json:"deployments"`
+	RestartUpdates      int     `json:"restart_updates"`
+	ZeroDowntimePercent float64 `json:"zero_downtime_percent"`
+}
+
+type adoptionReport struct {
+	Foundations []adoptionRow `json:"foundations"`
+	Spaces      []adoptionRow `json:"spaces"`
+}
+
+func newAdoptionRow(foundation string, org string, space string, summary cfclient.DeploymentSummary) adoptionRow {
+	return adoptionRow{Foundation: foundation, Org: org, Space: space, AppUpdates: summary.AppUpdates(), Deployments: summary.Deployments,
+		RestartUpdates: summary.RestartUpdates, ZeroDowntimePercent: summary.ZeroDowntimePercent()}
+}
+
+//add adds a foundation's totals and every space that had an app updated
+func (report *adoptionReport) add(target string, result cfclient.CollectionResult) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	var total cfclient.DeploymentSummary
+	for _, space := range result.Spaces {
+		summary := cfclient.SummarizeDeployments(space, result.EventsSince)
+		total.Deployments += summary.Deployments
+		total.RestartUpdates += summary.RestartUpdates
+		if summary.AppUpdates() > 0 {
+			report.Spaces = append(report.Spaces, newAdoptionRow(target, orgNames[space.OrganizationGUID], space.Name, summary))
+		}
+	}
+	report.Foundations = append(report.Foundations, newAdoptionRow(target, "", "", total))
+}
+
+//sort puts the spaces with the most restart updates first, the ones to go and talk to
+func (report *adoptionReport) sort() {
+	sort.SliceStable(report.Spaces, func(i, j int) bool {
+		return report.Spaces[i].RestartUpdates > report.Spaces[j].RestartUpdates
+	})
+}
+
+func (report adoptionReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "kind", "org", "space", "app_updates", "deployments", "restart_updates", "zero_downtime_percent"}}
+	for _, kind := range []struct {
+		name string
+		rows []adoptionRow
+	}{{"foundation", report.Foundations}, {"space", report.Spaces}} {
+		for _, row := range kind.rows {
+			rows = append(rows, []string{row.Foundation, kind.name, row.Org, row.Space, strconv.Itoa(row.AppUpdates), strconv.Itoa(row.Deployments),
+				strconv.Itoa(row.RestartUpdates), strconv.FormatFloat(row.ZeroDowntimePercent, 'f', 1, 64)})
+		}
+	}
+	if report.Spaces == nil {
+		report.Spaces = []adoptionRow{}
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//alertRulePattern is a threshold rule like org.memory_used_percent>90 or
+//app.crashes_per_hour>=10
+var alertRulePattern = regexp.MustCompile(`^(foundation|org|space|app|service)\.([a-z_]+)\s*(>=|<=|>|<)\s*(-?[0-9.]+)$`)
+
+//alertMetrics are the metrics each rule scope can use. the used_percent ones are against the
+//org or space quota and never fire for one without a quota
+var alertMetrics = map[string][]string{
+	"foundation": {"orgs", "spaces", "apps", "instances", "reserved_memory_mb", "app_crashes", "crashes_per_hour", "collection_errors"},
+	"org":        {"apps", "instances", "reserved_memory_mb", "app_crashes", "crashes_per_hour", "memory_used_percent", "instances_used_percent", "routes_used_percent", "services_used_percent"},
+	"space":      {"apps", "instances", "reserved_memory_mb", "app_crashes", "crashes_per_hour", "memory_used_percent", "instances_used_percent", "routes_used_percent", "services_used_percent", "routes", "orphaned_routes"},
+	"app":        {"crashes", "crashes_per_hour", "crash_anomaly"},
+	//a service instance only has operation_minutes while its last operation is in progress
+	"service": {"operation_minutes"},
+}
+
+type alertRule struct {
+	//text is the rule as it was given, which is also how alerts name it
+	text      string
+	scope     string
+	metric    string
+	operator  string
+	threshold float64
+}
+
+func parseAlertRule(text string) (alertRule, error) {
+	match := alertRulePattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return alertRule{}, fmt.Errorf("can't read alert rule %q, it should look like org.memory_used_percent>90", text)
+	}
+	rule := alertRule{text: strings.TrimSpace(text), scope: match[1], metric: match[2], operator: match[3]}
+	if !contains(alertMetrics[rule.scope], rule.metric) {
+		return alertRule{}, fmt.Errorf("alert rule %q: %s has no %s, use one of %s", text, rule.scope, rule.metric, strings.Join(alertMetrics[rule.scope], ", "))
+	}
+	var err error
+	rule.threshold, err = strconv.ParseFloat(match[4], 64)
+	if err != nil {
+		return alertRule{}, fmt.Errorf("alert rule %q: bad threshold %s", text, match[4])
+	}
+	return rule, nil
+}
+
+//alertRulesFlag is -alert, threshold rules parsed as they're given so a bad one fails
+//before anything is collected (comma separated or repeated)
+type alertRulesFlag []alertRule
+
+func (rules *alertRulesFlag) String() string {
+	var texts []string
+	for _, rule := range *rules {
+		texts = append(texts, rule.text)
+	}
+	return strings.Join(texts, ",")
+}
+
+func (rules *alertRulesFlag) Set(value string) error {
+	var texts listFlag
+	texts.Set(value)
+	for _, text := range texts {
+		rule, err := parseAlertRule(text)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, rule)
+	}
+	return nil
+}
+
+func (rule alertRule) fires(value float64) bool {
+	switch rule.operator {
+	case ">":
+		return value > rule.threshold
+	case ">=":
+		return value >= rule.threshold
+	case "<":
+		return value < rule.threshold
+	}
+	return value <= rule.threshold
+}
+
+//alert is the json POSTed to the webhook when a rule fires. text is a sentence for chat
+//webhooks that show it as the message
+type alert struct {
+	Text       string `json:"text"`
+	Foundation string `json:"foundation"`
+	Rule       string `json:"rule"`
+	Org        string `json:"org,omitempty"`
+	Space      string `json:"space,omitempty"`
+	App        string `json:"app,omitempty"`
+	//ServiceInstance is the instance a service rule fired for
+	ServiceInstance string    `json:"service_instance,omitempty"`
+	Metric          string    `json:"metric"`
+	Value           float64   `json:"value"`
+	Threshold       float64   `json:"threshold"`
+	FiredAt         time.Time `json:"fired_at"`
+}
+
+//key is what deduplicates an alert, the rule and what it fired for
+func (a alert) key() string {
+	key := a.Foundation + "|" + a.Rule + "|" + a.Org + "|" + a.Space + "|" + a.App
+	if a.ServiceInstance != "" {
+		key += "|" + a.ServiceInstance
+	}
+	return key
+}
+
+//alertDestination is somewhere alerts are sent, the -alert-webhook or slack
+type alertDestination interface {
+	name() string
+	send(fired alert) error
+}
+
+//alertSink checks every run against the threshold rules and sends each alert that fires to
+//its destination. an alert that already went out isn't sent again until cooldown has passed,
+//even if it keeps firing, which is what keeps a daemon on a short -interval from spamming
+type alertSink struct {
+	destination alertDestination
+	rules       []alertRule
+	cooldown    time.Duration
+	anomalies   crashAnomalies
+	foundation  string
+	//sent is when each alert key last went to this destination, shared by the runs of a daemon
+	sent *sync.Map
+}
+
+func (alerts alertSink) name() string {
+	return "alerts to " + alerts.destination.name()
+}
+
+func (alerts alertSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	for _, fired := range evaluateAlerts(alerts.foundation, alerts.rules, alerts.anomalies, result, timestamp) {
+		if last, sent := alerts.sent.Load(fired.key()); sent && timestamp.Sub(last.(time.Time)) < alerts.cooldown {
+			slog.Debug("alert still firing, in its cooldown", "rule", fired.Rule, "org", fired.Org, "space", fired.Space, "app", fired.App)
+			continue
+		}
+		err := alerts.destination.send(fired)
+		if err != nil {
+			return err
+		}
+		alerts.sent.Store(fired.key(), timestamp)
+		slog.Warn("alert fired", "rule", fired.Rule, "org", fired.Org, "space", fired.Space, "app", fired.App, "value", fired.Value)
+	}
+	return nil
+}
+
+//alertWebhook POSTs the alert as it is to -alert-webhook
+type alertWebhook struct {
+	url string
+}
+
+func (webhook alertWebhook) name() string {
+	return "the alert webhook"
+}
+
+func (webhook alertWebhook) send(fired alert) error {
+	payload, err := json.Marshal(fired)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(webhook.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("alert webhook returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+//evaluateAlerts is every alert the rules fire for in a run, in a stable order. the history
+//store is only read for app.crash_anomaly rules
+func evaluateAlerts(foundation string, rules []alertRule, anomalies crashAnomalies, result cfclient.CollectionResult, timestamp time.Time) []alert {
+	var fired []alert
+	var scores map[string]float64
+	for _, rule := range rules {
+		if rule.metric == "crash_anomaly" && scores == nil {
+			var err error
+			scores, err = anomalies.scores(foundation, result, timestamp)
+			if err != nil {
+				slog.Warn("can't read the crash history, skipping app.crash_anomaly", "foundation", foundation, "error", err)
+				scores = map[string]float64{}
+			}
+		}
+	}
+	//name is the app of an app rule and the service instance of a service one
+	check := func(scope string, org string, space string, name string, values map[string]float64) {
+		for _, rule := range rules {
+			value, has := values[rule.metric]
+			if rule.scope != scope || !has || !rule.fires(value) {
+				continue
+			}
+			subject := foundation
+			if scope != "foundation" {
+				subject += " " + scope + " " + org
+				for _, part := range []string{space, name} {
+					if part != "" {
+						subject += "/" + part
+					}
+				}
+			}
+			firing := alert{
+				Text:       fmt.Sprintf("%s has %s %s, alerting on %s", subject, rule.metric, strconv.FormatFloat(value, 'f', -1, 64), rule.text),
+				Foundation: foundation,
+				Rule:       rule.text,
+				Org:        org,
+				Space:      space,
+				Metric:     rule.metric,
+				Value:      value,
+				Threshold:  rule.threshold,
+				FiredAt:    timestamp,
+			}
+			if scope == "service" {
+				firing.ServiceInstance = name
+			} else {
+				firing.App = name
+			}
+			fired = append(fired, firing)
+		}
+	}
+
+	summary := cfclient.SummarizeFoundation(result.Orgs, result.Spaces)
+	crashes := 0
+	for _, org := range result.Orgs {
+		crashes += len(org.AppCrashes)
+	}
+	check("foundation", "", "", "", map[string]float64{
+		"orgs":               float64(summary.Orgs),
+		"spaces":             float64(summary.Spaces),
+		"apps":               float64(summary.Apps),
+		"instances":          float64(summary.DesiredInstances),
+		"reserved_memory_mb": float64(summary.ReservedMemoryMB),
+		"app_crashes":        float64(crashes),
+		"crashes_per_hour":   result.CrashesPerHour(crashes),
+		"collection_errors":  float64(len(result.Failures)),
+	})
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		check("org", org.Name, "", "", alertValues(org, result))
+	}
+	for _, space := range result.Spaces {
+		orgName := orgNames[space.OrganizationGUID]
+		values := alertValues(space, result)
+		values["routes"] = float64(len(space.Routes))
+		values["orphaned_routes"] = float64(len(cfclient.OrphanedRoutes(space.Routes)))
+		check("space", orgName, space.Name, "", values)
+		for _, crash := range spaceAppCrashes(space) {
+			values := map[string]float64{
+				"crashes":          float64(crash.crashes),
+				"crashes_per_hour": result.CrashesPerHour(crash.crashes),
+			}
+			if score, anomalous := scores[crash.guid]; anomalous {
+				values["crash_anomaly"] = score
+			}
+			check("app", orgName, space.Name, crash.name, values)
+		}
+		for _, instance := range space.ServiceInstances {
+			if instance.LastOperation.State == "in progress" {
+				check("service", orgName, space.Name, instance.Name, map[string]float64{"operation_minutes": instance.OperationDuration(timestamp).Minutes()})
+			}
+		}
+	}
+	sort.SliceStable(fired, func(i, j int) bool {
+		return fired[i].key() < fired[j].key()
+	})
+	return fired
+}
+
+//alertValues are the metrics an org or space has in common
+func alertValues(datapoint cfclient.Data, result cfclient.CollectionResult) map[string]float64 {
+	instances, memory := cfclient.AppTotals(datapoint.Apps)
+	values := map[string]float64{
+		"apps":               float64(len(datapoint.Apps)),
+		"instances":          float64(instances),
+		"reserved_memory_mb": float64(memory),
+		"app_crashes":        float64(len(datapoint.AppCrashes)),
+		"crashes_per_hour":   result.CrashesPerHour(len(datapoint.AppCrashes)),
+	}
+	if datapoint.Quota != nil {
+		values["memory_used_percent"] = datapoint.Quota.MemoryPercent
+		values["instances_used_percent"] = datapoint.Quota.InstancesPercent
+		values["routes_used_percent"] = datapoint.Quota.RoutesPercent
+		values["services_used_percent"] = datapoint.Quota.ServiceInstancesPercent
+	}
+	return values
+}
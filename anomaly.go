@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//crashAnomalies finds apps crashing far more than they usually do, for app.crash_anomaly
+//alerts. an app's usual is its crash rate between the -history snapshots of the last window:
+//a static threshold is too low for a tenant whose workers always crash a bit and too high
+//for one whose never do
+type crashAnomalies struct {
+	historyPath string
+	window      time.Duration
+	//minSamples is how many of the app's snapshots the baseline needs before it's trusted,
+	//minCrashes how many new crashes it takes to be an anomaly at all
+	minSamples int
+	minCrashes int
+}
+
+//crashBaseline is an app's crashes per hour between the snapshots of the window
+type crashBaseline struct {
+	samples int
+	mean    float64
+	stddev  float64
+}
+
+//appCrashesSince counts each app's crash events after since, by app guid
+func appCrashesSince(spaces []cfclient.Data, since time.Time) map[string]int {
+	crashes := map[string]int{}
+	for _, space := range spaces {
+		for _, event := range space.Events {
+			if cfclient.IsCrashEvent(event.Type) && event.Timestamp.After(since) {
+				crashes[event.Actee]++
+			}
+		}
+	}
+	return crashes
+}
+
+//crashBaselines is the baseline of every app in the foundation's snapshots taken within the
+//window before until. each snapshot after the first is a sample of the app's new crashes
+//over the hours since the one before it
+func crashBaselines(snapshots []snapshot.Record, foundation string, until time.Time, window time.Duration) map[string]crashBaseline {
+	var picked []snapshot.Record
+	for _, snap := range snapshots {
+		if snap.Foundation == foundation && snap.Timestamp.Before(until) && !snap.Timestamp.Before(until.Add(-window)) {
+			picked = append(picked, snap)
+		}
+	}
+	sort.SliceStable(picked, func(i, j int) bool { return picked[i].Timestamp.Before(picked[j].Timestamp) })
+
+	//snapshots only have the apps that crashed, and every app once a day, so every app seen
+	//in the window gets a sample of each snapshot and one it isn't in had no crashes
+	known := map[string]bool{}
+	for _, snap := range picked {
+		for _, app := range append(append([]snapshot.App{}, snap.Apps...), snap.CrashedApps...) {
+			known[app.GUID] = true
+		}
+	}
+	rates := map[string][]float64{}
+	for index := 1; index < len(picked); index++ {
+		hours := picked[index].Timestamp.Sub(picked[index-1].Timestamp).Hours()
+		if hours <= 0 {
+			continue
+		}
+		crashes := map[string]int{}
+		for _, app := range picked[index].CrashedApps {
+			crashes[app.GUID] = app.NewCrashes
+		}
+		for guid := range known {
+			rates[guid] = append(rates[guid], float64(crashes[guid])/hours)
+		}
+	}
+	baselines := map[string]crashBaseline{}
+	for guid, samples := range rates {
+		baseline := crashBaseline{samples: len(samples)}
+		for _, rate := range samples {
+			baseline.mean += rate
+		}
+		baseline.mean /= float64(len(samples))
+		for _, rate := range samples {
+			baseline.stddev += (rate - baseline.mean) * (rate - baseline.mean)
+		}
+		if len(samples) > 1 {
+			baseline.stddev = math.Sqrt(baseline.stddev / float64(len(samples)-1))
+		}
+		baselines[guid] = baseline
+	}
+	return baselines
+}
+
+//scores is how many standard deviations over its baseline each app's crash rate since the
+//last snapshot is, for the apps with a trusted baseline and at least minCrashes new crashes
+func (anomalies crashAnomalies) scores(foundation string, result cfclient.CollectionResult, timestamp time.Time) (map[string]float64, error) {
+	snapshots, _, err := readHistoryTail(anomalies.historyPath, foundation, timestamp.Add(-anomalies.window))
+	if err != nil {
+		return nil, err
+	}
+	var previous *snapshot.Record
+	for index := range snapshots {
+		snap := &snapshots[index]
+		if snap.Foundation == foundation && snap.Timestamp.Before(timestamp) && (previous == nil || snap.Timestamp.After(previous.Timestamp)) {
+			previous = snap
+		}
+	}
+	scores := map[string]float64{}
+	if previous == nil {
+		return scores, nil
+	}
+	hours := timestamp.Sub(previous.Timestamp).Hours()
+	baselines := crashBaselines(snapshots, foundation, timestamp, anomalies.window)
+	for guid, crashes := range appCrashesSince(result.Spaces, previous.Timestamp) {
+		baseline, found := baselines[guid]
+		if !found || baseline.samples < anomalies.minSamples || crashes < anomalies.minCrashes || hours <= 0 {
+			continue
+		}
+		rate := float64(crashes) / hours
+		if rate <= baseline.mean {
+			continue
+		}
+		spread := baseline.stddev
+		if spread == 0 {
+			//an app that always crashed at the same rate has no deviation to divide by, any
+			//crash an hour over it counts as one
+			spread = 1
+		}
+		scores[guid] = (rate - baseline.mean) / spread
+	}
+	return scores, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//apiStore holds the latest collection of every foundation for the api to serve, in the order
+//the foundations are configured
+type apiStore struct {
+	mu          sync.RWMutex
+	foundations []string
+	latest      map[string]apiCollection
+}
+
+type apiCollection struct {
+	timestamp time.Time
+	result    cfclient.CollectionResult
+}
+
+//apiSink is the sink collect adds per foundation so every run updates the store
+type apiSink struct {
+	store      *apiStore
+	foundation string
+}
+
+func (api apiSink) name() string {
+	return "api"
+}
+
+func (api apiSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	api.store.mu.Lock()
+	defer api.store.mu.Unlock()
+	api.store.latest[api.foundation] = apiCollection{timestamp: timestamp, result: result}
+	return nil
+}
+
+func newAPIStore(foundations []string) *apiStore {
+	return &apiStore{foundations: foundations, latest: map[string]apiCollection{}}
+}
+
+//apiRow is an org or space with its foundation, crashes and quota. the orgs and spaces
+//endpoints list them, a space's metrics are one
+type apiRow struct {
+	Foundation  string    `json:"foundation"`
+	CollectedAt time.Time `json:"collected_at"`
+	snapshot.Row
+	AppCrashes int                  `json:"app_crashes"`
+	Quota      *cfclient.QuotaUsage `json:"quota,omitempty"`
+}
+
+//each calls fn with every foundation collected so far, skipping those not matching a
+//?foundation= when there is one
+func (store *apiStore) each(r *http.Request, fn func(foundation string, collection apiCollection)) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	only := r.URL.Query().Get("foundation")
+	for _, foundation := range store.foundations {
+		collection, collected := store.latest[foundation]
+		if collected && (only == "" || only == foundation) {
+			fn(foundation, collection)
+		}
+	}
+}
+
+func (store *apiStore) snapshot(w http.ResponseWriter, r *http.Request) {
+	snapshots := []snapshot.Timed{}
+	store.each(r, func(foundation string, collection apiCollection) {
+		snapshots = append(snapshots, newTimedReport(foundation, collection.result, collection.timestamp))
+	})
+	writeAPIJSON(w, http.StatusOK, snapshots)
+}
+
+func (store *apiStore) orgs(w http.ResponseWriter, r *http.Request) {
+	orgs := []apiRow{}
+	store.each(r, func(foundation string, collection apiCollection) {
+		for _, org := range collection.result.Orgs {
+			orgs = append(orgs, newAPIRow(foundation, collection.timestamp, org.Name, "", org))
+		}
+	})
+	writeAPIJSON(w, http.StatusOK, orgs)
+}
+
+func (store *apiStore) spaces(w http.ResponseWriter, r *http.Request) {
+	spaces := []apiRow{}
+	store.each(r, func(foundation string, collection apiCollection) {
+		orgNames := apiOrgNames(collection.result)
+		for _, space := range collection.result.Spaces {
+			spaces = append(spaces, newAPIRow(foundation, collection.timestamp, orgNames[space.OrganizationGUID], space.Name, space))
+		}
+	})
+	writeAPIJSON(w, http.StatusOK, spaces)
+}
+
+//spaceMetrics answers /api/v1/spaces/<guid>/metrics
+func (store *apiStore) spaceMetrics(w http.ResponseWriter, r *http.Request) {
+	guid, metrics := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/spaces/"), "/metrics")
+	if !metrics || guid == "" || strings.Contains(guid, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	var found *apiRow
+	store.each(r, func(foundation string, collection apiCollection) {
+		orgNames := apiOrgNames(collection.result)
+		for _, space := range collection.result.Spaces {
+			if found == nil && space.GUID == guid {
+				row := newAPIRow(foundation, collection.timestamp, orgNames[space.OrganizationGUID], space.Name, space)
+				found = &row
+			}
+		}
+	})
+	if found == nil {
+		writeAPIJSON(w, http.StatusNotFound, map[string]string{"error": "no space " + guid + " in the latest collection"})
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, found)
+}
+
+func newAPIRow(foundation string, collectedAt time.Time, org string, space string, datapoint cfclient.Data) apiRow {
+	row := apiRow{Foundation: foundation, CollectedAt: collectedAt, Row: newReportRow(org, space, datapoint), Quota: datapoint.Quota}
+	for _, count := range cfclient.AppCrashes(datapoint.Events) {
+		row.AppCrashes += count
+	}
+	return row
+}
+
+func apiOrgNames(result cfclient.CollectionResult) map[string]string {
+	names := map[string]string{}
+	for _, org := range result.Orgs {
+		names[org.GUID] = org.Name
+	}
+	return names
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(body)
+}
+
+//apiAuth wants an Authorization: Bearer <token> on every request when token isn't empty
+func apiAuth(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cf-metrics"`)
+			writeAPIJSON(w, http.StatusUnauthorized, map[string]string{"error": "a bearer token is required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//apiGetOnly turns away anything but GET and HEAD, the api is read only
+func apiGetOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "the api is read only"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//serveAPI starts serving the store's api on address in the background. it only returns an
+//error when it can't listen, so a bad -api-listen stops collect before the first run. POST
+///api/v1/collect is the one thing that isn't read only, it hands runs to triggers
+func serveAPI(address string, token string, store *apiStore, triggers *collectTriggers) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/snapshot", store.snapshot)
+	mux.HandleFunc("/api/v1/orgs", store.orgs)
+	mux.HandleFunc("/api/v1/spaces", store.spaces)
+	mux.HandleFunc("/api/v1/spaces/", store.spaceMetrics)
+	routes := http.NewServeMux()
+	routes.Handle("/api/v1/collect", collectHandler(token, store.foundations, triggers))
+	routes.Handle("/", apiGetOnly(mux))
+	server := &http.Server{Handler: apiAuth(token, routes), ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving the api", "address", listener.Addr().String(), "auth", token != "")
+	go func() {
+		err := server.Serve(listener)
+		slog.Error("the api server stopped", "err", err)
+	}()
+	return nil
+}
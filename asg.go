@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//asgRow is a security group bound to a space, or to every space for a default group, that
+//report asg prints
+type asgRow struct {
+	Foundation string `json:"foundation"`
+	Group      string `json:"group"`
+	//Lifecycle is running or staging
+	Lifecycle string `json:"lifecycle"`
+	//Org and Space are empty for a default group, which applies everywhere
+	Org     string `json:"org,omitempty"`
+	Space   string `json:"space,omitempty"`
+	Default bool   `json:"default"`
+	Rules   int    `json:"rules"`
+	//WideOpen are the group's rules allowing 0.0.0.0/0 on every port
+	WideOpen []string `json:"wide_open"`
+}
+
+type asgReport struct {
+	Bindings []asgRow `json:"bindings"`
+}
+
+//add adds a run's security groups, only the wide open ones when wideOpenOnly is set. bound
+//spaces outside what the run collected, e.g. filtered out, are left out
+func (report *asgReport) add(foundation string, result cfclient.CollectionResult, wideOpenOnly bool) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	spaces := map[string]cfclient.Data{}
+	for _, space := range result.Spaces {
+		spaces[space.GUID] = space
+	}
+	for _, group := range result.SecurityGroups {
+		var wideOpen []string
+		for _, rule := range group.WideOpenRules() {
+			wideOpen = append(wideOpen, rule.Protocol+" "+rule.Destination+" "+rule.Ports)
+		}
+		if wideOpenOnly && len(wideOpen) == 0 {
+			continue
+		}
+		row := asgRow{Foundation: foundation, Group: group.Name, Rules: len(group.Rules), WideOpen: wideOpen}
+		if row.WideOpen == nil {
+			row.WideOpen = []string{}
+		}
+		bind := func(lifecycle string, isDefault bool, spaceGUIDs []string) {
+			if isDefault {
+				binding := row
+				binding.Lifecycle, binding.Default = lifecycle, true
+				report.Bindings = append(report.Bindings, binding)
+			}
+			for _, guid := range spaceGUIDs {
+				space, collected := spaces[guid]
+				if !collected {
+					continue
+				}
+				binding := row
+				binding.Lifecycle = lifecycle
+				binding.Org, binding.Space = orgNames[space.OrganizationGUID], space.Name
+				report.Bindings = append(report.Bindings, binding)
+			}
+		}
+		bind("running", group.RunningDefault, group.RunningSpaceGUIDs)
+		bind("staging", group.StagingDefault, group.StagingSpaceGUIDs)
+	}
+}
+
+//sort lists wide open groups first, default bindings before space ones, then by name
+func (report *asgReport) sort() {
+	sort.SliceStable(report.Bindings, func(i, j int) bool {
+		a, b := report.Bindings[i], report.Bindings[j]
+		if (len(a.WideOpen) > 0) != (len(b.WideOpen) > 0) {
+			return len(a.WideOpen) > 0
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Default != b.Default {
+			return a.Default
+		}
+		return a.Lifecycle+"/"+a.Org+"/"+a.Space < b.Lifecycle+"/"+b.Org+"/"+b.Space
+	})
+	if report.Bindings == nil {
+		report.Bindings = []asgRow{}
+	}
+}
+
+func (report asgReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "group", "lifecycle", "org", "space", "default", "rules", "wide_open"}}
+	for _, binding := range report.Bindings {
+		rows = append(rows, []string{binding.Foundation, binding.Group, binding.Lifecycle, binding.Org, binding.Space, strconv.FormatBool(binding.Default),
+			strconv.Itoa(binding.Rules), strings.Join(binding.WideOpen, "; ")})
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//breakerMaxBackoff caps how many cooldowns a breaker waits between trial runs
+const breakerMaxBackoff = 16
+
+//circuitBreaker stops collecting from a foundation after failures runs in a row fail, so a
+//dead one doesn't hold up every cycle. once open it lets a trial run through after cooldown,
+//doubling the wait every time the trial fails too, and closes again when one works. a nil
+//breaker always lets runs through
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	//failures is how many runs in a row have failed, trips how many times in a row the
+	//breaker has opened since it was last closed
+	failures  int
+	trips     int
+	openUntil time.Time
+	skipped   int
+}
+
+//breakerStatus is a breaker's state for the health checks: closed, open or half-open
+type breakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	Skipped             int        `json:"skipped"`
+}
+
+//newCircuitBreaker opens after threshold failed runs in a row, nil when threshold isn't
+//positive so there's no breaker at all
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+//allow is whether to run a collection now. a run it turns away is counted as skipped
+func (breaker *circuitBreaker) allow(now time.Time) bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.failures < breaker.threshold || !now.Before(breaker.openUntil) {
+		return true
+	}
+	breaker.skipped++
+	return false
+}
+
+//record is how a run the breaker allowed went
+func (breaker *circuitBreaker) record(err error, now time.Time) {
+	if breaker == nil {
+		return
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if err == nil {
+		breaker.failures, breaker.trips = 0, 0
+		return
+	}
+	breaker.failures++
+	if breaker.failures >= breaker.threshold {
+		backoff := breaker.cooldown * time.Duration(min(1<<breaker.trips, breakerMaxBackoff))
+		breaker.openUntil = now.Add(backoff)
+		breaker.trips++
+	}
+}
+
+func (breaker *circuitBreaker) status(now time.Time) breakerStatus {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	status := breakerStatus{State: "closed", ConsecutiveFailures: breaker.failures, Skipped: breaker.skipped}
+	if breaker.failures >= breaker.threshold {
+		status.State = "half-open"
+		if now.Before(breaker.openUntil) {
+			status.State = "open"
+		}
+		retryAt := breaker.openUntil
+		status.RetryAt = &retryAt
+	}
+	return status
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//buildpackApp is an app report buildpacks prints, with what it was staged with against
+//what's installed now
+type buildpackApp struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+	Stack      string `json:"stack"`
+	Buildpack  string `json:"buildpack"`
+	//StagedVersion is the version the app was staged with, InstalledVersion the one that's
+	//installed now, either is empty when nothing says
+	StagedVersion    string `json:"staged_version"`
+	InstalledVersion string `json:"installed_version"`
+	Outdated         bool   `json:"outdated"`
+}
+
+type buildpackReport struct {
+	Buildpacks []buildpackInventory `json:"buildpacks"`
+	Apps       []buildpackApp       `json:"apps"`
+}
+
+//buildpackInventory is an installed buildpack and how many apps use it
+type buildpackInventory struct {
+	Foundation string `json:"foundation"`
+	cfclient.Buildpack
+	Apps     int `json:"apps"`
+	Outdated int `json:"outdated_apps"`
+}
+
+//add adds a run's buildpacks and its apps on admin buildpacks, or with all false only the
+//outdated ones. when the detected buildpack doesn't say which version an app was staged
+//with it's outdated if it was staged before the buildpack was last updated, on v2 that's
+//going by when the app was last pushed and on v3 by its droplet, a call per app
+func (report *buildpackReport) add(ctx context.Context, target foundation, result cfclient.CollectionResult, all bool) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	inventory := map[string]*buildpackInventory{}
+	var guids []string
+	for _, buildpack := range result.Buildpacks {
+		inventory[buildpack.GUID] = &buildpackInventory{Foundation: target.name, Buildpack: buildpack}
+		guids = append(guids, buildpack.GUID)
+	}
+	for _, space := range result.Spaces {
+		for _, app := range space.Apps {
+			stagedAt, _ := time.Parse(time.RFC3339, cfclient.EntityString(app, "package_updated_at"))
+			if stagedAt.IsZero() {
+				var err error
+				stagedAt, err = target.client.AppLastStaged(ctx, app.Metadata.GUID)
+				if err != nil {
+					slog.Warn("couldn't get an app's droplet, going by its buildpack version only", "foundation", target.name, "app", cfclient.EntityString(app, "name"), "err", err)
+				}
+			}
+			stack := target.client.AppStack(ctx, app)
+			match := cfclient.MatchAppBuildpack(app, stack, stagedAt, result.Buildpacks)
+			if match.Installed == nil {
+				continue
+			}
+			inventory[match.Installed.GUID].Apps++
+			if match.Outdated {
+				inventory[match.Installed.GUID].Outdated++
+			}
+			if !match.Outdated && !all {
+				continue
+			}
+			report.Apps = append(report.Apps, buildpackApp{
+				Foundation:       target.name,
+				Org:              orgNames[space.OrganizationGUID],
+				Space:            space.Name,
+				Name:             cfclient.EntityString(app, "name"),
+				GUID:             app.Metadata.GUID,
+				Stack:            stack,
+				Buildpack:        match.Installed.Name,
+				StagedVersion:    match.Version,
+				InstalledVersion: match.Installed.Version,
+				Outdated:         match.Outdated,
+			})
+		}
+	}
+	for _, guid := range guids {
+		report.Buildpacks = append(report.Buildpacks, *inventory[guid])
+	}
+}
+
+//sort lists buildpacks in their detection order and apps by buildpack
+func (report *buildpackReport) sort() {
+	sort.SliceStable(report.Buildpacks, func(i, j int) bool {
+		a, b := report.Buildpacks[i], report.Buildpacks[j]
+		if a.Foundation != b.Foundation {
+			return a.Foundation < b.Foundation
+		}
+		return a.Position < b.Position
+	})
+	sort.SliceStable(report.Apps, func(i, j int) bool {
+		a, b := report.Apps[i], report.Apps[j]
+		if a.Buildpack != b.Buildpack {
+			return a.Buildpack < b.Buildpack
+		}
+		return a.Org+"/"+a.Space+"/"+a.Name < b.Org+"/"+b.Space+"/"+b.Name
+	})
+	if report.Buildpacks == nil {
+		report.Buildpacks = []buildpackInventory{}
+	}
+	if report.Apps == nil {
+		report.Apps = []buildpackApp{}
+	}
+}
+
+//write prints the report as json, or as one table of buildpacks then apps
+func (report buildpackReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"kind", "foundation", "org", "space", "app", "buildpack", "stack", "staged_version", "installed_version", "outdated", "apps", "outdated_apps"}}
+	for _, buildpack := range report.Buildpacks {
+		rows = append(rows, []string{"buildpack", buildpack.Foundation, "", "", "", buildpack.Name, buildpack.Stack, "", buildpack.Version, "",
+			strconv.Itoa(buildpack.Apps), strconv.Itoa(buildpack.Outdated)})
+	}
+	for _, app := range report.Apps {
+		rows = append(rows, []string{"app", app.Foundation, app.Org, app.Space, app.Name, app.Buildpack, app.Stack, app.StagedVersion, app.InstalledVersion,
+			strconv.FormatBool(app.Outdated), "", ""})
+	}
+	return writeListing(out, format, report, rows)
+}
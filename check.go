@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+//runCheck verifies that each foundation's api is reachable and that we can get a token from
+//its uaa without collecting anything, then exits 0 if everything worked and 1 otherwise
+func runCheck(foundations []foundation) {
+	ok := true
+	ctx := context.Background()
+
+	for _, target := range foundations {
+		client := target.client
+		prefix := ""
+		if len(foundations) > 1 {
+			prefix = target.name + ": "
+		}
+
+		info, err := client.Info(ctx)
+		if err != nil {
+			fmt.Printf("%sapi %s: unreachable (%s)\n", prefix, client.APIURL(), err)
+			ok = false
+		} else {
+			fmt.Printf("%sapi %s: reachable, api version %s\n", prefix, client.APIURL(), info.APIVersion)
+		}
+
+		//as a cf cli plugin the tokens come from the cli, we never see uaa
+		uaa := client.UAAURL()
+		if uaa == "" {
+			uaa = "the cf cli"
+		}
+		err = client.RefreshAccessToken(ctx)
+		if err != nil {
+			fmt.Printf("%sauth against %s: failed (%s)\n", prefix, uaa, err)
+			ok = false
+		} else {
+			fmt.Printf("%sauth against %s: ok\n", prefix, uaa)
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//hoursPerMonth is the average month, 365 days * 24 hours / 12
+const hoursPerMonth = 730
+
+//costRates are the prices usage is estimated with, in whatever currency they're given in.
+//ServicePlans are monthly prices per instance keyed service/plan, or just the service for
+//every plan of it that hasn't got its own
+type costRates struct {
+	Currency     string             `json:"currency" yaml:"currency"`
+	MemoryGBHour float64            `json:"memory_gb_hour" yaml:"memory_gb_hour"`
+	DiskGBHour   float64            `json:"disk_gb_hour" yaml:"disk_gb_hour"`
+	ServicePlans map[string]float64 `json:"service_plans" yaml:"service_plans"`
+}
+
+//costModel is a -pricing file: the rates, and rates of their own for the foundations that
+//cost something else, e.g. one on another iaas
+type costModel struct {
+	costRates   `yaml:",inline"`
+	Foundations map[string]costRates `json:"foundations" yaml:"foundations"`
+}
+
+//loadCostModel reads a -pricing file. files ending in .json are json, anything else is read
+//as yaml
+func loadCostModel(path string) (costModel, error) {
+	var model costModel
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return model, fmt.Errorf("could not read pricing file %s: %s", path, err)
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		err = json.Unmarshal(raw, &model)
+	} else {
+		err = yaml.Unmarshal(raw, &model)
+	}
+	if err != nil {
+		return model, fmt.Errorf("could not parse pricing file %s: %s", path, err)
+	}
+	for name, rates := range model.Foundations {
+		if rates.Currency != "" && model.Currency != "" && rates.Currency != model.Currency {
+			return model, fmt.Errorf("foundation %s in %s is priced in %s, the rest in %s", name, path, rates.Currency, model.Currency)
+		}
+	}
+	return model, nil
+}
+
+//rates are what a foundation is priced at
+func (model costModel) rates(foundation string) costRates {
+	if rates, own := model.Foundations[foundation]; own {
+		if rates.Currency == "" {
+			rates.Currency = model.Currency
+		}
+		return rates
+	}
+	return model.costRates
+}
+
+//planPrice is an instance of a plan's monthly price, false for a plan the rates don't price
+func (rates costRates) planPrice(service string, plan string) (float64, bool) {
+	if price, priced := rates.ServicePlans[service+"/"+plan]; priced {
+		return price, true
+	}
+	price, priced := rates.ServicePlans[service]
+	return price, priced
+}
+
+//costRow is an org's or a space's estimated monthly spend at the rates, if its started apps
+//kept running and its service instances stayed as they are for the month. Space is empty
+//for an org
+type costRow struct {
+	Foundation      string  `json:"foundation"`
+	Org             string  `json:"org"`
+	Space           string  `json:"space,omitempty"`
+	GUID            string  `json:"guid"`
+	RunningMemoryMB int     `json:"running_memory_mb"`
+	MemoryCost      float64 `json:"memory_cost"`
+	RunningDiskMB   int     `json:"running_disk_mb"`
+	DiskCost        float64 `json:"disk_cost"`
+	//ServiceInstances are the brokered ones, and Unpriced those of plans with no price
+	ServiceInstances int     `json:"service_instances"`
+	Unpriced         int     `json:"unpriced_service_instances"`
+	ServiceCost      float64 `json:"service_cost"`
+	Total            float64 `json:"total"`
+}
+
+type costReport struct {
+	Currency string    `json:"currency"`
+	Orgs     []costRow `json:"orgs"`
+	Spaces   []costRow `json:"spaces"`
+	//UnpricedPlans are the service/plans that had instances but no price, left out of the totals
+	UnpricedPlans []string `json:"unpriced_plans"`
+	unpriced      map[string]bool
+}
+
+//add estimates a foundation's spaces, and its orgs by adding up their spaces
+func (report *costReport) add(target string, rates costRates, result cfclient.CollectionResult) {
+	if report.Currency == "" {
+		report.Currency = rates.Currency
+	}
+	if report.unpriced == nil {
+		report.unpriced = map[string]bool{}
+	}
+	orgRows := map[string]*costRow{}
+	var orgs []costRow
+	for _, org := range result.Orgs {
+		orgs = append(orgs, costRow{Foundation: target, Org: org.Name, GUID: org.GUID})
+	}
+	for index := range orgs {
+		orgRows[orgs[index].GUID] = &orgs[index]
+	}
+	for _, space := range result.Spaces {
+		row := costRow{Foundation: target, Space: space.Name, GUID: space.GUID,
+			RunningMemoryMB: space.Usage.RunningMemoryMB, RunningDiskMB: space.Usage.RunningDiskMB}
+		row.MemoryCost = float64(row.RunningMemoryMB) / 1024 * rates.MemoryGBHour * hoursPerMonth
+		row.DiskCost = float64(row.RunningDiskMB) / 1024 * rates.DiskGBHour * hoursPerMonth
+		for _, instance := range space.ServiceInstances {
+			//user provided instances aren't brokered and cost nothing
+			if instance.ServicePlanGUID == "" {
+				continue
+			}
+			row.ServiceInstances++
+			price, priced := rates.planPrice(instance.ServiceName, instance.PlanName)
+			if !priced {
+				row.Unpriced++
+				report.unpriced[instance.ServiceName+"/"+instance.PlanName] = true
+				continue
+			}
+			row.ServiceCost += price
+		}
+		row.Total = row.MemoryCost + row.DiskCost + row.ServiceCost
+		if org := orgRows[space.OrganizationGUID]; org != nil {
+			row.Org = org.Org
+			org.RunningMemoryMB += row.RunningMemoryMB
+			org.MemoryCost += row.MemoryCost
+			org.RunningDiskMB += row.RunningDiskMB
+			org.DiskCost += row.DiskCost
+			org.ServiceInstances += row.ServiceInstances
+			org.Unpriced += row.Unpriced
+			org.ServiceCost += row.ServiceCost
+			org.Total += row.Total
+		}
+		report.Spaces = append(report.Spaces, row)
+	}
+	report.Orgs = append(report.Orgs, orgs...)
+}
+
+//sort puts the orgs and spaces that cost the most first and lists the unpriced plans, with a
+//warning so they get a price
+func (report *costReport) sort() {
+	for _, rows := range [][]costRow{report.Orgs, report.Spaces} {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Total > rows[j].Total })
+	}
+	report.UnpricedPlans = []string{}
+	for plan := range report.unpriced {
+		report.UnpricedPlans = append(report.UnpricedPlans, plan)
+	}
+	sort.Strings(report.UnpricedPlans)
+	if len(report.UnpricedPlans) > 0 {
+		slog.Warn("service plans without a price in the pricing file, their instances aren't in the totals", "plans", strings.Join(report.UnpricedPlans, ", "))
+	}
+}
+
+//write prints the estimates rounded to cents
+func (report costReport) write(out io.Writer, format string) error {
+	cents := func(amount float64) float64 {
+		return math.Round(amount*100) / 100
+	}
+	money := func(amount float64) string {
+		return strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+	for _, rows := range [][]costRow{report.Orgs, report.Spaces} {
+		for index := range rows {
+			row := &rows[index]
+			row.MemoryCost, row.DiskCost, row.ServiceCost, row.Total = cents(row.MemoryCost), cents(row.DiskCost), cents(row.ServiceCost), cents(row.Total)
+		}
+	}
+	rows := [][]string{{"foundation", "kind", "org", "space", "running_memory_mb", "memory_cost", "running_disk_mb", "disk_cost", "service_instances", "unpriced", "service_cost", "total"}}
+	for _, kind := range []struct {
+		name string
+		rows []costRow
+	}{{"org", report.Orgs}, {"space", report.Spaces}} {
+		for _, row := range kind.rows {
+			rows = append(rows, []string{row.Foundation, kind.name, row.Org, row.Space, strconv.Itoa(row.RunningMemoryMB), money(row.MemoryCost),
+				strconv.Itoa(row.RunningDiskMB), money(row.DiskCost), strconv.Itoa(row.ServiceInstances), strconv.Itoa(row.Unpriced), money(row.ServiceCost), money(row.Total)})
+		}
+	}
+	if report.Orgs == nil {
+		report.Orgs = []costRow{}
+	}
+	if report.Spaces == nil {
+		report.Spaces = []costRow{}
+	}
+	return writeListing(out, format, report, rows)
+}
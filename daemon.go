@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//runDaemon collects from every foundation and writes everything out on the schedule until
+//SIGINT or SIGTERM. the same clients are reused for every cycle so the token it refreshed last time carries over
+//instead of going back to the cf cli config. a signal during a cycle lets that cycle finish,
+//a second one cancels it and waits for its requests to wind down. a cycle never overlaps the
+//last one, the times it ran past are skipped. a trigger from triggers runs a cycle while it's
+//waiting, and the next scheduled one still runs when it's due
+func runDaemon(runs []foundationRun, schedule daemonSchedule, triggers <-chan collectTrigger) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	due := schedule.first(time.Now())
+	for {
+		var trigger *collectTrigger
+		if wait := time.Until(due); wait > 0 {
+			slog.Info("waiting for the next collection", "schedule", schedule.String(), "at", due)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case triggered := <-triggers:
+				timer.Stop()
+				trigger = &triggered
+			case sig := <-signals:
+				timer.Stop()
+				slog.Info("shutting down", "signal", sig)
+				return
+			}
+		}
+
+		cycleRuns, ctx := runs, context.Background()
+		if trigger != nil {
+			cycleRuns = trigger.runs(runs)
+			if len(cycleRuns) == 0 {
+				slog.Warn("nothing to collect for the triggered collection", "foundation", trigger.foundation, "collectors", trigger.scope.Collectors)
+				continue
+			}
+			slog.Info("collecting on request", "foundation", trigger.foundation, "org", trigger.scope.Org, "space", trigger.scope.Space, "collectors", trigger.scope.Collectors)
+			ctx = cfclient.WithRunScope(ctx, trigger.scope)
+		}
+		if !runCycle(ctx, cycleRuns, signals) {
+			return
+		}
+		if trigger != nil {
+			continue
+		}
+
+		var skipped int
+		due, skipped = nextDue(schedule, due, time.Now())
+		if skipped > 0 {
+			slog.Warn("the collection ran past the next ones, skipping them", "schedule", schedule.String(), "skipped", skipped)
+		}
+	}
+}
+
+//runCycle collects once, false when a signal came in and the daemon should stop
+func runCycle(ctx context.Context, runs []foundationRun, signals chan os.Signal) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- collectAll(ctx, runs)
+	}()
+
+	select {
+	case err := <-done:
+		if partial(err) {
+			slog.Warn("collection only partly done", "err", err)
+		} else if err != nil {
+			slog.Error("collection failed", "err", err)
+		}
+		return true
+	case sig := <-signals:
+		slog.Info("finishing the collection in progress, signal again to cancel it", "signal", sig)
+		select {
+		case <-done:
+		case <-signals:
+			cancel()
+			slog.Info("cancelling the collection in progress")
+			<-done
+		}
+		return false
+	}
+}
+
+//runProfileDaemons runs a daemon for every profile's runs at once, each on the profile's
+//schedule. they all see the signals, so they wind down together, and every one gets the
+//triggered runs
+func runProfileDaemons(profiles []collectionProfile, profileRuns [][]foundationRun, triggers *collectTriggers) {
+	if len(profiles) == 1 {
+		runDaemon(profileRuns[0], profiles[0].schedule, triggers.queue(0))
+		return
+	}
+	var wg sync.WaitGroup
+	for index, profile := range profiles {
+		wg.Add(1)
+		go func(profile collectionProfile, runs []foundationRun, queue <-chan collectTrigger) {
+			defer wg.Done()
+			slog.Info("collecting the profile on its schedule", "profile", profile.name, "schedule", profile.schedule.String())
+			runDaemon(runs, profile.schedule, queue)
+		}(profile, profileRuns[index], triggers.queue(index))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+//debugListenFlag is -debug-listen for the long running commands
+func debugListenFlag(flags *flag.FlagSet) *string {
+	return flags.String("debug-listen", "", "serve pprof on /debug/pprof/ and expvar on /debug/vars at this address, e.g. localhost:6060 (there's no auth, keep it off public interfaces)")
+}
+
+//publishClientStats adds every foundation's request counters and per endpoint stats to
+///debug/vars as cf_metrics_clients, read fresh on every request
+func publishClientStats(foundations []foundation) {
+	if expvar.Get("cf_metrics_clients") != nil {
+		return
+	}
+	expvar.Publish("cf_metrics_clients", expvar.Func(func() interface{} {
+		clients := map[string]interface{}{}
+		for _, target := range foundations {
+			clients[target.name] = map[string]interface{}{
+				"stats":     target.client.Stats(),
+				"endpoints": target.client.EndpointStats(),
+			}
+		}
+		return clients
+	}))
+}
+
+//serveDebug starts serving pprof and expvar on address in the background. it only returns an
+//error when it can't listen. pprof and expvar also register themselves on
+//http.DefaultServeMux, which none of the other listeners use, so only this one exposes them
+func serveDebug(address string, foundations []foundation) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	publishClientStats(foundations)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	//profiles and traces stream for as long as ?seconds= asks, so there's no write timeout
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving pprof and expvar", "address", listener.Addr().String())
+	go func() {
+		err := server.Serve(listener)
+		slog.Error("the debug server stopped", "err", err)
+	}()
+	return nil
+}
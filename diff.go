@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//diffRow is one thing that changed between two snapshots: an org, space or app created or
+//deleted, or one of an org's or space's counts going up or down
+type diffRow struct {
+	//Kind is org, space or app, Change is created, deleted or changed
+	Kind   string `json:"kind"`
+	Change string `json:"change"`
+	Org    string `json:"org"`
+	Space  string `json:"space,omitempty"`
+	App    string `json:"app,omitempty"`
+	GUID   string `json:"guid"`
+	//Field, Old and New are only set for changed counts
+	Field string `json:"field,omitempty"`
+	Old   int    `json:"old"`
+	New   int    `json:"new"`
+}
+
+type diffReport struct {
+	Foundation string    `json:"foundation"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Changes    []diffRow `json:"changes"`
+}
+
+//loadSnapshot reads a snapshot from a file, either a history store line or what
+//collect -output json prints. the latter has no apps, so app changes can't be told from it
+func loadSnapshot(path string) (snapshot.Record, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snapshot.Record{}, err
+	}
+	var snap struct {
+		snapshot.Record
+		//Name is what -output json calls the foundation
+		Name string `json:"name"`
+	}
+	err = snapshot.Decode(raw, &snap)
+	if err != nil {
+		return snapshot.Record{}, fmt.Errorf("error reading the snapshot in %s: %s", path, err)
+	}
+	if snap.Foundation == "" {
+		snap.Foundation = snap.Name
+	}
+	return snap.Record, nil
+}
+
+//lastTwoSnapshots picks the foundation's two latest snapshots out of a history store. the
+//foundation can be left empty when the store only has one
+func lastTwoSnapshots(snapshots []snapshot.Record, foundation string) (snapshot.Record, snapshot.Record, error) {
+	var matching []snapshot.Record
+	foundations := map[string]bool{}
+	for _, snap := range snapshots {
+		foundations[snap.Foundation] = true
+		if foundation == "" || snap.Foundation == foundation {
+			matching = append(matching, snap)
+		}
+	}
+	if foundation == "" && len(foundations) > 1 {
+		return snapshot.Record{}, snapshot.Record{}, fmt.Errorf("it has several foundations, pick one with -foundation")
+	}
+	if len(matching) < 2 {
+		return snapshot.Record{}, snapshot.Record{}, fmt.Errorf("it needs at least two snapshots, it has %d", len(matching))
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].Timestamp.Before(matching[j].Timestamp)
+	})
+	return matching[len(matching)-2], matching[len(matching)-1], nil
+}
+
+//diffSnapshots lists what changed from before to after. orgs and spaces are matched by
+//guid, so a rename isn't a delete and a create
+func diffSnapshots(before snapshot.Record, after snapshot.Record) []diffRow {
+	var changes []diffRow
+	compare := func(kind string, beforeRows []snapshot.Row, afterRows []snapshot.Row) {
+		old := map[string]snapshot.Row{}
+		for _, row := range beforeRows {
+			old[row.GUID] = row
+		}
+		seen := map[string]bool{}
+		for _, row := range afterRows {
+			seen[row.GUID] = true
+			previous, existed := old[row.GUID]
+			if !existed {
+				changes = append(changes, diffRow{Kind: kind, Change: "created", Org: row.Org, Space: row.Space, GUID: row.GUID})
+				continue
+			}
+			fields := []struct {
+				name          string
+				before, after int
+			}{
+				{"apps", previous.Apps, row.Apps},
+				{"instances", previous.Instances, row.Instances},
+				{"reserved_memory_mb", previous.ReservedMemoryMB, row.ReservedMemoryMB},
+				{"service_instances", previous.ServiceInstances, row.ServiceInstances},
+				{"routes", previous.Routes, row.Routes},
+			}
+			for _, field := range fields {
+				if field.before != field.after {
+					changes = append(changes, diffRow{Kind: kind, Change: "changed", Org: row.Org, Space: row.Space, GUID: row.GUID,
+						Field: field.name, Old: field.before, New: field.after})
+				}
+			}
+		}
+		for _, row := range beforeRows {
+			if !seen[row.GUID] {
+				changes = append(changes, diffRow{Kind: kind, Change: "deleted", Org: row.Org, Space: row.Space, GUID: row.GUID})
+			}
+		}
+	}
+	compare("org", before.Orgs, after.Orgs)
+	compare("space", before.Spaces, after.Spaces)
+
+	if before.Apps != nil && after.Apps != nil {
+		oldApps := map[string]snapshot.App{}
+		for _, app := range before.Apps {
+			oldApps[app.GUID] = app
+		}
+		newApps := map[string]bool{}
+		for _, app := range after.Apps {
+			newApps[app.GUID] = true
+			if _, existed := oldApps[app.GUID]; !existed {
+				changes = append(changes, diffRow{Kind: "app", Change: "created", Org: app.Org, Space: app.Space, App: app.Name, GUID: app.GUID})
+			}
+		}
+		for _, app := range before.Apps {
+			if !newApps[app.GUID] {
+				changes = append(changes, diffRow{Kind: "app", Change: "deleted", Org: app.Org, Space: app.Space, App: app.Name, GUID: app.GUID})
+			}
+		}
+	}
+	return changes
+}
+
+//sort lists creates and deletes before count changes, then by org, space and app
+func (report *diffReport) sort() {
+	kinds := map[string]int{"org": 0, "space": 1, "app": 2}
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		a, b := report.Changes[i], report.Changes[j]
+		if (a.Change == "changed") != (b.Change == "changed") {
+			return b.Change == "changed"
+		}
+		if a.Kind != b.Kind {
+			return kinds[a.Kind] < kinds[b.Kind]
+		}
+		return a.Org+"/"+a.Space+"/"+a.App+"/"+a.Field < b.Org+"/"+b.Space+"/"+b.App+"/"+b.Field
+	})
+	if report.Changes == nil {
+		report.Changes = []diffRow{}
+	}
+}
+
+func (report diffReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"kind", "change", "org", "space", "app", "field", "old", "new"}}
+	for _, change := range report.Changes {
+		before, after := "", ""
+		if change.Change == "changed" {
+			before, after = strconv.Itoa(change.Old), strconv.Itoa(change.New)
+		}
+		rows = append(rows, []string{change.Kind, change.Change, change.Org, change.Space, change.App, change.Field, before, after})
+	}
+	return writeListing(out, format, report, rows)
+}
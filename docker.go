@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//dockerRow is a docker app report docker prints
+type dockerRow struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	App        string `json:"app"`
+	AppGUID    string `json:"app_guid"`
+	Image      string `json:"image"`
+	Registry   string `json:"registry"`
+	//External is true when the registry isn't one of -allowed-registry
+	External bool `json:"external"`
+}
+
+type dockerReport struct {
+	AllowedRegistries []string    `json:"allowed_registries"`
+	Apps              []dockerRow `json:"apps"`
+}
+
+//allowed is true for registries in AllowedRegistries, or anything when there are none.
+//registries are host names so they're compared case insensitively
+func (report dockerReport) allowed(registry string) bool {
+	if len(report.AllowedRegistries) == 0 {
+		return true
+	}
+	for _, allowed := range report.AllowedRegistries {
+		if strings.EqualFold(allowed, registry) {
+			return true
+		}
+	}
+	return false
+}
+
+//add adds a run's docker apps, only the external ones when externalOnly is set
+func (report *dockerReport) add(foundation string, result cfclient.CollectionResult, externalOnly bool) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, dockerApp := range space.DockerApps {
+			external := dockerApp.Registry != "" && !report.allowed(dockerApp.Registry)
+			if externalOnly && !external {
+				continue
+			}
+			report.Apps = append(report.Apps, dockerRow{
+				Foundation: foundation,
+				Org:        orgNames[space.OrganizationGUID],
+				Space:      space.Name,
+				App:        dockerApp.AppName,
+				AppGUID:    dockerApp.AppGUID,
+				Image:      dockerApp.Image,
+				Registry:   dockerApp.Registry,
+				External:   external,
+			})
+		}
+	}
+}
+
+//sort lists external images first, then by registry and image
+func (report *dockerReport) sort() {
+	sort.SliceStable(report.Apps, func(i, j int) bool {
+		a, b := report.Apps[i], report.Apps[j]
+		if a.External != b.External {
+			return a.External
+		}
+		if a.Registry != b.Registry {
+			return a.Registry < b.Registry
+		}
+		return a.Image+"/"+a.Org+"/"+a.Space+"/"+a.App < b.Image+"/"+b.Org+"/"+b.Space+"/"+b.App
+	})
+	if report.Apps == nil {
+		report.Apps = []dockerRow{}
+	}
+}
+
+func (report dockerReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "app", "image", "registry", "external"}}
+	for _, app := range report.Apps {
+		rows = append(rows, []string{app.Foundation, app.Org, app.Space, app.App, app.Image, app.Registry, strconv.FormatBool(app.External)})
+	}
+	return writeListing(out, format, report, rows)
+}
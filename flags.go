@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//listFlag is a flag that can be repeated and/or given a comma separated list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+//contains is true when value is one of options
+func contains(options []string, value string) bool {
+	for _, option := range options {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
+//timeFlag is a point in time given either as an RFC3339 timestamp or a duration ago, e.g.
+//24h. the zero value means not set
+type timeFlag time.Time
+
+func (t *timeFlag) String() string {
+	if t == nil || time.Time(*t).IsZero() {
+		return ""
+	}
+	return time.Time(*t).Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(value string) error {
+	if ago, err := time.ParseDuration(value); err == nil {
+		*t = timeFlag(time.Now().Add(-ago))
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("%s is neither a duration like 24h nor an RFC3339 time", value)
+	}
+	*t = timeFlag(parsed)
+	return nil
+}
+
+//clockFlag is a time of day, e.g. 09:00, in the local time zone. the zero value means not set
+type clockFlag struct {
+	set    bool
+	hour   int
+	minute int
+}
+
+func (c *clockFlag) String() string {
+	if c == nil || !c.set {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d", c.hour, c.minute)
+}
+
+func (c *clockFlag) Set(value string) error {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return fmt.Errorf("%s isn't a time of day like 09:00", value)
+	}
+	*c = clockFlag{set: true, hour: parsed.Hour(), minute: parsed.Minute()}
+	return nil
+}
+
+//on is the time of day on the day of t
+func (c clockFlag) on(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), c.hour, c.minute, 0, 0, t.Location())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//foundationTarget is one foundation in a -foundations file. anything left out comes from
+//the flags, so e.g. -since or -org apply to every foundation
+type foundationTarget struct {
+	//Name tags everything collected from the foundation, the api host by default
+	Name string `json:"name" yaml:"name"`
+	API  string `json:"api" yaml:"api"`
+	UAA  string `json:"uaa" yaml:"uaa"`
+	//ClientID and ClientSecret authenticate with the client_credentials grant. the secret
+	//can be given as ${SOME_VAR} to keep it out of the file
+	ClientID     string `json:"client_id" yaml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret"`
+	//Config is a -config style file for foundations that use a user's refresh token instead
+	Config            string `json:"config" yaml:"config"`
+	SkipSSLValidation bool   `json:"skip_ssl_validation" yaml:"skip_ssl_validation"`
+	CACert            string `json:"ca_cert" yaml:"ca_cert"`
+	ClientCert        string `json:"client_cert" yaml:"client_cert"`
+	ClientKey         string `json:"client_key" yaml:"client_key"`
+	//Proxy and NoProxy are the foundation's own -proxy and -no-proxy, Proxy can be direct
+	Proxy   string   `json:"proxy" yaml:"proxy"`
+	NoProxy []string `json:"no_proxy" yaml:"no_proxy"`
+}
+
+type foundationsFile struct {
+	Foundations []foundationTarget `json:"foundations" yaml:"foundations"`
+}
+
+//foundation is a client and the name everything it collects gets tagged with
+type foundation struct {
+	name   string
+	client *cfclient.Client
+}
+
+//loadFoundations reads a -foundations file. files ending in .json are json, anything else
+//is read as yaml
+func loadFoundations(path string) ([]foundationTarget, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read foundations file %s: %s", path, err)
+	}
+	var file foundationsFile
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		err = json.Unmarshal(raw, &file)
+	} else {
+		err = yaml.Unmarshal(raw, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse foundations file %s: %s", path, err)
+	}
+	if len(file.Foundations) == 0 {
+		return nil, fmt.Errorf("no foundations listed in %s", path)
+	}
+
+	names := map[string]bool{}
+	for index := range file.Foundations {
+		target := &file.Foundations[index]
+		if target.API == "" && target.Config == "" {
+			return nil, fmt.Errorf("foundation %d in %s needs an api or a config", index+1, path)
+		}
+		if target.Name == "" && target.API != "" {
+			target.Name = foundationName(target.API)
+		}
+		if target.Name == "" {
+			return nil, fmt.Errorf("foundation %d in %s needs a name", index+1, path)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("foundation %s is listed twice in %s", target.Name, path)
+		}
+		names[target.Name] = true
+		target.ClientSecret = os.ExpandEnv(target.ClientSecret)
+	}
+	return file.Foundations, nil
+}
+
+//newFoundations sets up a client per target on top of base. files the clients write to
+//between runs get the foundation name added so they don't overwrite each other
+func newFoundations(base cfclient.Config, targets []foundationTarget) ([]foundation, error) {
+	var foundations []foundation
+	for _, target := range targets {
+		config := base
+		config.API = target.API
+		config.UAA = target.UAA
+		config.ClientID = target.ClientID
+		config.ClientSecret = target.ClientSecret
+		config.ConfigPath = target.Config
+		config.SkipSSLValidation = base.SkipSSLValidation || target.SkipSSLValidation
+		if target.CACert != "" {
+			config.CACertPath = target.CACert
+		}
+		if target.ClientCert != "" {
+			config.ClientCertPath = target.ClientCert
+			config.ClientKeyPath = target.ClientKey
+		}
+		//a foundation's own proxy doesn't take the -no-proxy hosts meant for -proxy
+		if target.Proxy != "" {
+			config.ProxyURL = target.Proxy
+			config.NoProxy = target.NoProxy
+		}
+		if len(target.NoProxy) > 0 {
+			config.NoProxy = target.NoProxy
+		}
+		config.TokenCachePath = foundationPath(base.TokenCachePath, target.Name)
+		config.UsageCursorPath = foundationPath(base.UsageCursorPath, target.Name)
+		config.EventCursorPath = foundationPath(base.EventCursorPath, target.Name)
+		logger := base.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		config.Logger = logger.With("foundation", target.Name)
+
+		client, err := cfclient.NewClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up foundation %s: %s", target.Name, err)
+		}
+		foundations = append(foundations, foundation{name: target.Name, client: client})
+	}
+	return foundations, nil
+}
+
+//foundationPath puts the foundation name in front of path's extension, e.g. summary.csv
+//becomes summary-prod.csv. empty paths and - (stdout) are left alone
+func foundationPath(path string, name string) string {
+	if path == "" || path == "-" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
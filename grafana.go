@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+var dashboardSources = []string{"prometheus", "influx"}
+
+//grafanaPanelSpec is one panel of the generated dashboard, with its query for each source.
+//panels with no query for a source are left off that source's dashboard, e.g. quotas which
+//only the prometheus exporter has
+type grafanaPanelSpec struct {
+	title  string
+	kind   string
+	unit   string
+	width  int
+	height int
+	//legend is the prometheus legendFormat, influx names series by their group by tags
+	legend string
+	prom   string
+	influx string
+}
+
+//grafanaPanels use $foundation and $org, the dashboard's variables. prometheus queries match
+//the serve exporter's gauges, influx ones the cf_org, cf_space, cf_events and cf_app_crashes
+//measurements as InfluxQL
+var grafanaPanels = []grafanaPanelSpec{
+	{
+		title: "apps", kind: "stat", width: 6, height: 4,
+		prom:   `sum(cf_org_apps{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT sum("apps") FROM (SELECT last("apps") AS "apps" FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY "foundation", "org")`,
+	},
+	{
+		title: "instances", kind: "stat", width: 6, height: 4,
+		prom:   `sum(cf_org_instances{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT sum("instances") FROM (SELECT last("instances") AS "instances" FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY "foundation", "org")`,
+	},
+	{
+		title: "reserved memory", kind: "stat", unit: "decmbytes", width: 6, height: 4,
+		prom:   `sum(cf_org_reserved_memory_mb{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT sum("reserved_memory_mb") FROM (SELECT last("reserved_memory_mb") AS "reserved_memory_mb" FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY "foundation", "org")`,
+	},
+	{
+		title: "collection errors", kind: "stat", width: 6, height: 4,
+		prom: `sum(cf_collection_errors{foundation=~"$foundation"})`,
+	},
+	{
+		title: "reserved memory by org", kind: "timeseries", unit: "decmbytes", width: 12, height: 8, legend: "{{org}}",
+		prom:   `sum by (org) (cf_org_reserved_memory_mb{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT last("reserved_memory_mb") FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY time($__interval), "org" fill(previous)`,
+	},
+	{
+		title: "instances by org", kind: "timeseries", width: 12, height: 8, legend: "{{org}}",
+		prom:   `sum by (org) (cf_org_instances{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT last("instances") FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY time($__interval), "org" fill(previous)`,
+	},
+	{
+		title: "org memory quota used", kind: "timeseries", unit: "percent", width: 12, height: 8, legend: "{{org}}",
+		prom: `max by (org) (cf_quota_used_percent{foundation=~"$foundation",org=~"$org",space="",resource="memory_mb"})`,
+	},
+	{
+		title: "audit events by type", kind: "timeseries", width: 12, height: 8, legend: "{{type}}",
+		prom:   `sum by (type) (cf_org_events{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT sum("count") FROM (SELECT last("count") AS "count" FROM "cf_events" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND "space" = '' AND $timeFilter GROUP BY time($__interval), "org", "type") GROUP BY time($__interval), "type"`,
+	},
+	{
+		title: "crashing apps", kind: "timeseries", width: 12, height: 8, legend: "{{org}}/{{space}}/{{app}}",
+		prom:   `topk(10, cf_app_crashes{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT last("crashes") FROM "cf_app_crashes" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY time($__interval), "org", "space", "app"`,
+	},
+	{
+		title: "apps by org", kind: "timeseries", width: 12, height: 8, legend: "{{org}}",
+		prom:   `sum by (org) (cf_org_apps{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT last("apps") FROM "cf_org" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY time($__interval), "org" fill(previous)`,
+	},
+	{
+		title: "spaces by reserved memory", kind: "table", unit: "decmbytes", width: 24, height: 10,
+		prom:   `sort_desc(cf_space_reserved_memory_mb{foundation=~"$foundation",org=~"$org"})`,
+		influx: `SELECT last("reserved_memory_mb") AS "reserved_memory_mb", last("apps") AS "apps", last("instances") AS "instances" FROM "cf_space" WHERE "foundation" =~ /^$foundation$/ AND "org" =~ /^$org$/ AND $timeFilter GROUP BY "org", "space"`,
+	},
+	{
+		title: "collection duration", kind: "timeseries", unit: "s", width: 24, height: 6, legend: "{{foundation}}",
+		prom: `cf_collection_duration_seconds{foundation=~"$foundation"}`,
+	},
+}
+
+//grafanaDashboard builds a dashboard in grafana's export format for source. the datasource is
+//an import input, so grafana asks which prometheus or influxdb to use when it's imported
+func grafanaDashboard(source string, title string) map[string]interface{} {
+	pluginID := "prometheus"
+	if source == "influx" {
+		pluginID = "influxdb"
+	}
+	datasource := map[string]string{"type": pluginID, "uid": "${DS_CF_METRICS}"}
+
+	var panels []interface{}
+	x, y, rowHeight := 0, 0, 0
+	for _, spec := range grafanaPanels {
+		query := spec.prom
+		if source == "influx" {
+			query = spec.influx
+		}
+		if query == "" {
+			continue
+		}
+		if x+spec.width > 24 {
+			x, y, rowHeight = 0, y+rowHeight, 0
+		}
+		panels = append(panels, grafanaPanel(len(panels)+1, spec, source, query, datasource, x, y))
+		x += spec.width
+		rowHeight = max(rowHeight, spec.height)
+	}
+
+	variables := []interface{}{
+		grafanaVariable("foundation", source, datasource, `label_values(cf_org_apps, foundation)`, `SHOW TAG VALUES FROM "cf_org" WITH KEY = "foundation"`),
+		grafanaVariable("org", source, datasource, `label_values(cf_org_apps{foundation=~"$foundation"}, org)`, `SHOW TAG VALUES FROM "cf_org" WITH KEY = "org" WHERE "foundation" =~ /^$foundation$/`),
+	}
+	return map[string]interface{}{
+		"__inputs": []interface{}{map[string]string{
+			"name":     "DS_CF_METRICS",
+			"label":    "cf-metrics " + pluginID,
+			"type":     "datasource",
+			"pluginId": pluginID,
+		}},
+		"title":         title,
+		"uid":           "cf-metrics-" + source,
+		"tags":          []string{"cf-metrics", "cloudfoundry"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"editable":      true,
+		"refresh":       "5m",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"templating":    map[string]interface{}{"list": variables},
+		"panels":        panels,
+	}
+}
+
+func grafanaPanel(id int, spec grafanaPanelSpec, source string, query string, datasource map[string]string, x int, y int) map[string]interface{} {
+	target := map[string]interface{}{"refId": "A", "datasource": datasource}
+	if source == "influx" {
+		target["query"], target["rawQuery"], target["resultFormat"] = query, true, "time_series"
+		if spec.kind == "table" {
+			target["resultFormat"] = "table"
+		}
+	} else {
+		target["expr"] = query
+		if spec.legend != "" {
+			target["legendFormat"] = spec.legend
+		}
+		if spec.kind == "table" {
+			target["instant"], target["format"] = true, "table"
+		}
+	}
+	panel := map[string]interface{}{
+		"id":          id,
+		"type":        spec.kind,
+		"title":       spec.title,
+		"datasource":  datasource,
+		"gridPos":     map[string]int{"x": x, "y": y, "w": spec.width, "h": spec.height},
+		"targets":     []interface{}{target},
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": spec.unit}, "overrides": []interface{}{}},
+	}
+	if spec.kind == "stat" {
+		panel["options"] = map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}}}
+	}
+	if spec.kind == "table" && source != "influx" {
+		//the instant query's table has a Time and a __name__ column nobody wants
+		panel["transformations"] = []interface{}{map[string]interface{}{
+			"id":      "organize",
+			"options": map[string]interface{}{"excludeByName": map[string]bool{"Time": true, "__name__": true, "job": true, "instance": true}},
+		}}
+	}
+	return panel
+}
+
+func grafanaVariable(name string, source string, datasource map[string]string, prom string, influx string) map[string]interface{} {
+	query := prom
+	if source == "influx" {
+		query = influx
+	}
+	return map[string]interface{}{
+		"name":       name,
+		"label":      name,
+		"type":       "query",
+		"datasource": datasource,
+		"query":      query,
+		"definition": query,
+		"refresh":    2,
+		"sort":       1,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{},
+	}
+}
+
+//writeDashboard prints the dashboard as indented json, ready to paste or upload into grafana's
+//import page
+func writeDashboard(out io.Writer, source string, title string) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(grafanaDashboard(source, title))
+}
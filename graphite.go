@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//graphiteSink sends every run over graphite's plaintext tcp protocol, one
+//<path> <value> <timestamp> line per metric. paths are e.g.
+//cf_metrics.<foundation>.space.<org>.<space>.apps, with the same counts the postgres sink stores
+type graphiteSink struct {
+	address    string
+	prefix     string
+	foundation string
+	//flushInterval resends the latest run's values this often, stamped with the time they're
+	//sent, so graphite retentions finer than the collection interval don't have gaps. 0
+	//only sends each run once
+	flushInterval time.Duration
+	//latest is this foundation's last run, shared with the goroutine that flushes it
+	latest *graphiteLatest
+}
+
+type graphiteLatest struct {
+	sync.Mutex
+	//metrics are the paths and values without a timestamp
+	metrics  [][2]string
+	flushing bool
+	//stop ends the flushing when the sink is closed
+	stop   chan struct{}
+	closed bool
+}
+
+func (graphite graphiteSink) name() string {
+	return "graphite"
+}
+
+func (graphite graphiteSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	metrics := graphite.metrics(result, timestamp)
+	graphite.latest.Lock()
+	graphite.latest.metrics = metrics
+	startFlushing := graphite.flushInterval > 0 && !graphite.latest.flushing && !graphite.latest.closed
+	if startFlushing {
+		graphite.latest.flushing = true
+		graphite.latest.stop = make(chan struct{})
+	}
+	graphite.latest.Unlock()
+	if startFlushing {
+		go graphite.flushLatest()
+	}
+	return graphite.send(metrics, timestamp)
+}
+
+//flushLatest resends the latest values every flush until the sink is closed
+func (graphite graphiteSink) flushLatest() {
+	ticker := time.NewTicker(graphite.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-graphite.latest.stop:
+			return
+		case now := <-ticker.C:
+			err := graphite.resend(now)
+			if err != nil {
+				slog.Warn("error flushing to graphite", "foundation", graphite.foundation, "error", err)
+			}
+		}
+	}
+}
+
+//resend sends the latest run's values again stamped with now, if there's been a run
+func (graphite graphiteSink) resend(now time.Time) error {
+	graphite.latest.Lock()
+	metrics := graphite.latest.metrics
+	graphite.latest.Unlock()
+	if len(metrics) == 0 {
+		return nil
+	}
+	return graphite.send(metrics, now)
+}
+
+//flush resends the latest run's values one last time, so the series doesn't stop short of
+//when cf-metrics did. without -graphite-flush-interval every run is sent once and that's it
+func (graphite graphiteSink) flush() error {
+	if graphite.flushInterval == 0 {
+		return nil
+	}
+	return graphite.resend(time.Now())
+}
+
+//close stops the flushing
+func (graphite graphiteSink) close() error {
+	graphite.latest.Lock()
+	defer graphite.latest.Unlock()
+	if graphite.latest.flushing {
+		close(graphite.latest.stop)
+		graphite.latest.flushing = false
+	}
+	graphite.latest.closed = true
+	return nil
+}
+
+func (graphite graphiteSink) send(metrics [][2]string, timestamp time.Time) error {
+	var lines strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&lines, "%s %s %d\n", metric[0], metric[1], timestamp.Unix())
+	}
+	conn, err := net.DialTimeout("tcp", graphite.address, time.Minute)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	_, err = conn.Write([]byte(lines.String()))
+	return err
+}
+
+//metrics names every count. audit events, stacks and buildpacks get a segment each, e.g.
+//cf_metrics.prod.org.acme.events.audit_app_create. tags that only describe the org or space,
+//like isolation_segment, are left out
+func (graphite graphiteSink) metrics(result cfclient.CollectionResult, timestamp time.Time) [][2]string {
+	var metrics [][2]string
+	for _, point := range metricPoints(graphite.foundation, result, timestamp) {
+		path := []string{graphite.prefix, graphite.foundation, point.scope()}
+		switch point.scope() {
+		case "space":
+			path = append(path, point.tags["org"], point.tags["space"])
+		case "org":
+			path = append(path, point.tags["org"])
+		}
+		path = append(append(path, point.name), point.series()...)
+		for index := range path[1:] {
+			path[index+1] = statsdNameUnsafe.ReplaceAllString(path[index+1], "_")
+		}
+		metrics = append(metrics, [2]string{strings.Join(path, "."), strconv.FormatFloat(point.value, 'f', -1, 64)})
+	}
+	return metrics
+}
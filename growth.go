@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//growthEvents are the audit events that create and delete orgs, spaces and apps. between
+//two snapshots something can be created and deleted again, or come and go out of view of a
+//filtered run, so a period counts whichever of the events and the snapshot diffs is more
+var growthEvents = map[string][2]string{
+	"org":   {"audit.organization.create", "audit.organization.delete-request"},
+	"space": {"audit.space.create", "audit.space.delete-request"},
+	"app":   {"audit.app.create", "audit.app.delete-request"},
+}
+
+//growthRow is how many orgs, spaces and apps were created and deleted in one day or week
+type growthRow struct {
+	Foundation    string    `json:"foundation"`
+	Period        time.Time `json:"period"`
+	OrgsCreated   int       `json:"orgs_created"`
+	OrgsDeleted   int       `json:"orgs_deleted"`
+	SpacesCreated int       `json:"spaces_created"`
+	SpacesDeleted int       `json:"spaces_deleted"`
+	AppsCreated   int       `json:"apps_created"`
+	AppsDeleted   int       `json:"apps_deleted"`
+}
+
+//count adds what changed between two snapshots
+func (row *growthRow) count(before snapshot.Record, after snapshot.Record) {
+	changes := map[string]int{}
+	for _, change := range diffSnapshots(before, after) {
+		changes[change.Kind+" "+change.Change]++
+	}
+	//only the first snapshot of each day has every app, the rest were counted against the
+	//apps of the one before when they were written
+	if before.Apps == nil || after.Apps == nil {
+		changes["app created"], changes["app deleted"] = after.AppsAdded, after.AppsRemoved
+	}
+	counted := func(kind string, change string, eventType string) int {
+		if after.NewEventTypes[eventType] > changes[kind+" "+change] {
+			return after.NewEventTypes[eventType]
+		}
+		return changes[kind+" "+change]
+	}
+	row.OrgsCreated += counted("org", "created", growthEvents["org"][0])
+	row.OrgsDeleted += counted("org", "deleted", growthEvents["org"][1])
+	row.SpacesCreated += counted("space", "created", growthEvents["space"][0])
+	row.SpacesDeleted += counted("space", "deleted", growthEvents["space"][1])
+	row.AppsCreated += counted("app", "created", growthEvents["app"][0])
+	row.AppsDeleted += counted("app", "deleted", growthEvents["app"][1])
+}
+
+//periodStart is the utc day, or the monday starting the week, a time falls in
+func periodStart(at time.Time, period string) time.Time {
+	day := time.Date(at.UTC().Year(), at.UTC().Month(), at.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	if period == "week" {
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return day
+}
+
+//foundationSnapshots groups the snapshots by foundation, each oldest first
+func foundationSnapshots(snapshots []snapshot.Record) map[string][]snapshot.Record {
+	grouped := map[string][]snapshot.Record{}
+	for _, snap := range snapshots {
+		grouped[snap.Foundation] = append(grouped[snap.Foundation], snap)
+	}
+	for _, list := range grouped {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Timestamp.Before(list[j].Timestamp) })
+	}
+	return grouped
+}
+
+type growthReport struct {
+	Period string      `json:"period"`
+	Rows   []growthRow `json:"rows"`
+}
+
+//add buckets what changed between each foundation's consecutive snapshots into the day or
+//week of the later one, only the foundation's when it isn't empty. a foundation's first
+//snapshot has nothing to compare to, so it starts the history rather than counting as growth
+func (report *growthReport) add(snapshots []snapshot.Record, foundation string) {
+	for name, list := range foundationSnapshots(snapshots) {
+		if foundation != "" && name != foundation {
+			continue
+		}
+		byPeriod := map[time.Time]*growthRow{}
+		for index := 1; index < len(list); index++ {
+			start := periodStart(list[index].Timestamp, report.Period)
+			if byPeriod[start] == nil {
+				byPeriod[start] = &growthRow{Foundation: name, Period: start}
+			}
+			byPeriod[start].count(list[index-1], list[index])
+		}
+		for _, row := range byPeriod {
+			report.Rows = append(report.Rows, *row)
+		}
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		if !report.Rows[i].Period.Equal(report.Rows[j].Period) {
+			return report.Rows[i].Period.Before(report.Rows[j].Period)
+		}
+		return report.Rows[i].Foundation < report.Rows[j].Foundation
+	})
+	if report.Rows == nil {
+		report.Rows = []growthRow{}
+	}
+}
+
+func (report growthReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", report.Period, "orgs_created", "orgs_deleted", "spaces_created", "spaces_deleted", "apps_created", "apps_deleted"}}
+	for _, row := range report.Rows {
+		rows = append(rows, []string{row.Foundation, row.Period.Format("2006-01-02"), strconv.Itoa(row.OrgsCreated), strconv.Itoa(row.OrgsDeleted),
+			strconv.Itoa(row.SpacesCreated), strconv.Itoa(row.SpacesDeleted), strconv.Itoa(row.AppsCreated), strconv.Itoa(row.AppsDeleted)})
+	}
+	return writeListing(out, format, report, rows)
+}
+
+//growthTrend is what was created and deleted in the day and the week up to a run, the trend
+//metrics the history sink hands the trend sinks
+type growthTrend struct {
+	Day  growthRow
+	Week growthRow
+}
+
+//newGrowthTrend counts the changes between the foundation's snapshots in the 24 hours and 7
+//days before now
+func newGrowthTrend(snapshots []snapshot.Record, foundation string, now time.Time) growthTrend {
+	trend := growthTrend{Day: growthRow{Foundation: foundation}, Week: growthRow{Foundation: foundation}}
+	list := foundationSnapshots(snapshots)[foundation]
+	for index := 1; index < len(list); index++ {
+		age := now.Sub(list[index].Timestamp)
+		if age < 7*24*time.Hour {
+			trend.Week.count(list[index-1], list[index])
+		}
+		if age < 24*time.Hour {
+			trend.Day.count(list[index-1], list[index])
+		}
+	}
+	return trend
+}
+
+//growthWindows are the names the sinks give the trend's rows
+var growthWindows = []string{"day", "week"}
+
+//window is the trend's row by its name in growthWindows
+func (trend growthTrend) window(name string) growthRow {
+	if name == "day" {
+		return trend.Day
+	}
+	return trend.Week
+}
+
+//fields are a row's counts by metric name
+func (row growthRow) fields() map[string]int {
+	return map[string]int{
+		"orgs_created":   row.OrgsCreated,
+		"orgs_deleted":   row.OrgsDeleted,
+		"spaces_created": row.SpacesCreated,
+		"spaces_deleted": row.SpacesDeleted,
+		"apps_created":   row.AppsCreated,
+		"apps_deleted":   row.AppsDeleted,
+	}
+}
+
+//trendSink is a sink that can take the history store's growth trend besides the runs
+type trendSink interface {
+	name() string
+	writeTrend(trend growthTrend, timestamp time.Time) error
+}
+
+//trendSinks picks out the sinks that can take the growth trend
+func trendSinks(sinks []sink) []trendSink {
+	var found []trendSink
+	for _, candidate := range sinks {
+		if destination, ok := candidate.(trendSink); ok {
+			found = append(found, destination)
+		}
+	}
+	return found
+}
+
+//writeTrends sends the trend to every trend sink, logging the ones that fail
+func writeTrends(sinks []trendSink, foundation string, trend growthTrend, timestamp time.Time) {
+	for _, destination := range sinks {
+		err := destination.writeTrend(trend, timestamp)
+		if err != nil {
+			slog.Error("error writing the growth trend to "+destination.name(), "foundation", foundation, "err", err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//snapshotsSubscribePath is the Subscribe method of the cfmetrics.v1.Snapshots service in
+//proto/cfmetrics/v1/snapshots.proto
+const snapshotsSubscribePath = "/cfmetrics.v1.Snapshots/Subscribe"
+
+//grpc status codes the subscribe handler answers with
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcUnauthenticated = 16
+)
+
+//snapshotHub passes every run's snapshot on to the grpc subscribers, and keeps the latest one
+//of each foundation for those who subscribe between runs
+type snapshotHub struct {
+	mu          sync.Mutex
+	foundations []string
+	latest      map[string]snapshot.Timed
+	subscribers map[chan snapshot.Timed]bool
+	token       string
+}
+
+func newSnapshotHub(foundations []string, token string) *snapshotHub {
+	return &snapshotHub{foundations: foundations, latest: map[string]snapshot.Timed{}, subscribers: map[chan snapshot.Timed]bool{}, token: token}
+}
+
+//grpcSink is the sink collect adds per foundation so every run reaches the subscribers
+type grpcSink struct {
+	hub        *snapshotHub
+	foundation string
+}
+
+func (grpc grpcSink) name() string {
+	return "grpc subscribers"
+}
+
+func (grpc grpcSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	grpc.hub.publish(newTimedReport(grpc.foundation, result, timestamp))
+	return nil
+}
+
+//publish never waits on a subscriber, one that's still a few snapshots behind misses this one
+func (hub *snapshotHub) publish(report snapshot.Timed) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.latest[report.Name] = report
+	for subscriber := range hub.subscribers {
+		select {
+		case subscriber <- report:
+		default:
+			slog.Warn("a grpc subscriber is behind, it misses this snapshot", "foundation", report.Name)
+		}
+	}
+}
+
+//subscribe is a channel of the snapshots to come, and the latest ones so far
+func (hub *snapshotHub) subscribe() (chan snapshot.Timed, []snapshot.Timed) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	subscriber := make(chan snapshot.Timed, 4)
+	hub.subscribers[subscriber] = true
+	var latest []snapshot.Timed
+	for _, foundation := range hub.foundations {
+		if report, collected := hub.latest[foundation]; collected {
+			latest = append(latest, report)
+		}
+	}
+	return subscriber, latest
+}
+
+func (hub *snapshotHub) unsubscribe(subscriber chan snapshot.Timed) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.subscribers, subscriber)
+}
+
+//ServeHTTP is the grpc server side of Subscribe: a SubscribeRequest in, a stream of Snapshot
+//messages out until the client goes away. there's no grpc vendored, so it's spoken over
+//net/http's http/2 like the otlp sink does
+func (hub *snapshotHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "cf-metrics only speaks grpc here", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.Method != http.MethodPost || r.URL.Path != snapshotsSubscribePath {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	if hub.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+hub.token)) != 1 {
+		grpcStatus(w, grpcUnauthenticated, "a bearer token is required")
+		return
+	}
+	foundation, deltas, err := readSubscribeRequest(r.Body)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	subscriber, latest := hub.subscribe()
+	defer hub.unsubscribe(subscriber)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	//the stream only ends when the client goes away, but it ends cleanly
+	defer w.Header().Set("Grpc-Status", fmt.Sprint(grpcOK))
+	flusher, _ := w.(http.Flusher)
+	sent := map[string]map[string]string{}
+	send := func(report snapshot.Timed) error {
+		if foundation != "" && report.Name != foundation {
+			return nil
+		}
+		message := encodeSnapshot(report, deltas, sent)
+		frame := make([]byte, 5, 5+len(message))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+		_, err := w.Write(append(frame, message...))
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	}
+
+	slog.Info("grpc subscriber connected", "remote", r.RemoteAddr, "foundation", foundation, "deltas", deltas)
+	defer slog.Info("grpc subscriber went away", "remote", r.RemoteAddr)
+	for _, report := range latest {
+		if send(report) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case report := <-subscriber:
+			if send(report) != nil {
+				return
+			}
+		}
+	}
+}
+
+//grpcStatus ends a call with a status and no messages, grpc's trailers only response
+func grpcStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", fmt.Sprint(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+//readSubscribeRequest reads the one length prefixed SubscribeRequest a call starts with
+func readSubscribeRequest(body io.Reader) (string, bool, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(body, header)
+	if err != nil {
+		return "", false, fmt.Errorf("no SubscribeRequest: %s", err)
+	}
+	if header[0] != 0 {
+		return "", false, fmt.Errorf("compressed requests aren't supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > 64*1024 {
+		return "", false, fmt.Errorf("the SubscribeRequest is too big")
+	}
+	message := make([]byte, size)
+	_, err = io.ReadFull(body, message)
+	if err != nil {
+		return "", false, fmt.Errorf("short SubscribeRequest: %s", err)
+	}
+	fields, err := protoFields(message)
+	if err != nil {
+		return "", false, err
+	}
+	var foundation string
+	var deltas bool
+	for _, field := range fields {
+		switch field.field {
+		case 1:
+			foundation = string(field.bytes)
+		case 2:
+			deltas = field.number != 0
+		}
+	}
+	return foundation, deltas, nil
+}
+
+//encodeSnapshot is a Snapshot message. with deltas, after the first one of a foundation only
+//the orgs and spaces that changed since the last sent are in it, and the guids of those gone.
+//sent is the encoded rows last sent per foundation, keyed by guid
+func encodeSnapshot(report snapshot.Timed, deltas bool, sent map[string]map[string]string) protoMessage {
+	var message protoMessage
+	message.string(1, report.Name)
+	message.varint(2, uint64(report.Timestamp.UnixNano()))
+
+	var summary protoMessage
+	summary.varint(1, uint64(report.Foundation.Orgs))
+	summary.varint(2, uint64(report.Foundation.Spaces))
+	summary.varint(3, uint64(report.Foundation.Apps))
+	summary.varint(4, uint64(report.Foundation.DesiredInstances))
+	summary.varint(5, uint64(report.Foundation.RunningInstances))
+	summary.varint(6, uint64(report.Foundation.ReservedMemoryMB))
+	summary.varint(7, uint64(report.Foundation.ServiceInstances))
+	summary = append(summary, protoCounts(8, report.Foundation.EventsByType)...)
+	message.message(3, summary)
+
+	previous, delta := sent[report.Name]
+	delta = delta && deltas
+	current := map[string]string{}
+	for _, rows := range []struct {
+		field int
+		rows  []snapshot.Row
+	}{{4, report.Orgs}, {5, report.Spaces}} {
+		for _, row := range rows.rows {
+			encoded := encodeReportRow(row)
+			current[row.GUID] = string(encoded)
+			if !delta || previous[row.GUID] != string(encoded) {
+				message.message(rows.field, encoded)
+			}
+		}
+	}
+	for _, failure := range report.Failures {
+		message.string(6, failure)
+	}
+	if delta {
+		message.varint(7, 1)
+		var removed []string
+		for guid := range previous {
+			if _, kept := current[guid]; !kept {
+				removed = append(removed, guid)
+			}
+		}
+		sort.Strings(removed)
+		for _, guid := range removed {
+			message.string(8, guid)
+		}
+	}
+	sent[report.Name] = current
+	return message
+}
+
+func encodeReportRow(row snapshot.Row) protoMessage {
+	var encoded protoMessage
+	encoded.string(1, row.Org)
+	if row.Space != "" {
+		encoded.string(2, row.Space)
+	}
+	encoded.string(3, row.GUID)
+	encoded.varint(4, uint64(row.Apps))
+	encoded.varint(5, uint64(row.Instances))
+	encoded.varint(6, uint64(row.ReservedMemoryMB))
+	encoded.varint(7, uint64(row.ServiceInstances))
+	encoded.varint(8, uint64(row.Routes))
+	return append(encoded, protoCounts(9, row.Events)...)
+}
+
+//protoCounts is a map<string, int64> field, sorted so the same counts encode the same way
+func protoCounts(field int, counts map[string]int) protoMessage {
+	var encoded protoMessage
+	for _, key := range sortedKeys(counts) {
+		var entry protoMessage
+		entry.string(1, key)
+		entry.varint(2, uint64(counts[key]))
+		encoded.message(field, entry)
+	}
+	return encoded
+}
+
+//serveGRPC starts serving Subscribe over h2c on address in the background. it only returns
+//an error when it can't listen, so a bad -grpc-listen stops collect before the first run
+func serveGRPC(address string, hub *snapshotHub) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Handler: hub, Protocols: &protocols, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving grpc", "address", listener.Addr().String(), "method", snapshotsSubscribePath, "auth", hub.token != "")
+	go func() {
+		err := server.Serve(listener)
+		slog.Error("the grpc server stopped", "err", err)
+	}()
+	return nil
+}
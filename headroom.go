@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//headroomRow is how much of the diego cells' memory or disk a foundation's started apps
+//reserve, and when they'll have reserved all of it if they keep growing as they have
+type headroomRow struct {
+	Foundation string `json:"foundation"`
+	Resource   string `json:"resource"`
+	//Source is where the capacity came from, static for -cells or log-cache for the reps
+	Source          string  `json:"source"`
+	Cells           int     `json:"cells"`
+	CapacityMB      int     `json:"capacity_mb"`
+	ReservedMB      int     `json:"reserved_mb"`
+	HeadroomPercent float64 `json:"headroom_percent"`
+	//GrowthMBPerDay is the trend of the reservations over the -history snapshots of the
+	//-trend window, and ExhaustedBy when that trend reaches the capacity. neither is set
+	//without enough history or while the reservations aren't growing
+	GrowthMBPerDay *float64   `json:"growth_mb_per_day,omitempty"`
+	ExhaustedBy    *time.Time `json:"exhausted_by,omitempty"`
+}
+
+type headroomReport struct {
+	Rows []headroomRow `json:"rows"`
+}
+
+//add adds a foundation's memory and disk rows, the trends out of its snapshots
+func (report *headroomReport) add(target string, source string, capacity cfclient.CellCapacity, result cfclient.CollectionResult, snapshots []snapshot.Record, now time.Time) {
+	var usage cfclient.UsageReport
+	for _, space := range result.Spaces {
+		usage.RunningMemoryMB += space.Usage.RunningMemoryMB
+		usage.RunningDiskMB += space.Usage.RunningDiskMB
+	}
+	resources := []struct {
+		name     string
+		capacity int
+		reserved int
+		history  func(snap snapshot.Record) int
+	}{
+		{"memory", capacity.MemoryMB, usage.RunningMemoryMB, func(snap snapshot.Record) int { return snap.RunningMemoryMB }},
+		{"disk", capacity.DiskMB, usage.RunningDiskMB, func(snap snapshot.Record) int { return snap.RunningDiskMB }},
+	}
+	for _, resource := range resources {
+		row := headroomRow{Foundation: target, Resource: resource.name, Source: source, Cells: capacity.Cells, CapacityMB: resource.capacity, ReservedMB: resource.reserved}
+		if resource.capacity > 0 {
+			row.HeadroomPercent = float64(resource.capacity-resource.reserved) / float64(resource.capacity) * 100
+		}
+		var times []time.Time
+		var values []float64
+		for _, snap := range snapshots {
+			//snapshots from before they had the running totals have nothing to go on
+			if snap.Foundation == target && resource.history(snap) > 0 {
+				times = append(times, snap.Timestamp)
+				values = append(values, float64(resource.history(snap)))
+			}
+		}
+		times = append(times, now)
+		values = append(values, float64(resource.reserved))
+		if growth, trending := dailyTrend(times, values); trending {
+			row.GrowthMBPerDay = &growth
+			if growth > 0 {
+				exhausted := now
+				if resource.reserved < resource.capacity {
+					exhausted = now.Add(time.Duration(float64(resource.capacity-resource.reserved) / growth * float64(24*time.Hour)))
+				}
+				row.ExhaustedBy = &exhausted
+			}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+}
+
+//dailyTrend is the least squares slope of values over times, per day. it takes at least a
+//day between the first and the last to call anything a trend
+func dailyTrend(times []time.Time, values []float64) (float64, bool) {
+	if len(times) < 2 || times[len(times)-1].Sub(times[0]) < 24*time.Hour {
+		return 0, false
+	}
+	var meanDay, meanValue float64
+	days := make([]float64, len(times))
+	for index, at := range times {
+		days[index] = at.Sub(times[0]).Hours() / 24
+		meanDay += days[index]
+		meanValue += values[index]
+	}
+	meanDay /= float64(len(times))
+	meanValue /= float64(len(times))
+	var covariance, variance float64
+	for index := range days {
+		covariance += (days[index] - meanDay) * (values[index] - meanValue)
+		variance += (days[index] - meanDay) * (days[index] - meanDay)
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
+
+func (report headroomReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "resource", "source", "cells", "capacity_mb", "reserved_mb", "headroom_percent", "growth_mb_per_day", "exhausted_by"}}
+	for _, row := range report.Rows {
+		growth, exhausted := "", ""
+		if row.GrowthMBPerDay != nil {
+			growth = strconv.FormatFloat(*row.GrowthMBPerDay, 'f', 1, 64)
+		}
+		if row.ExhaustedBy != nil {
+			exhausted = row.ExhaustedBy.UTC().Format("2006-01-02")
+		}
+		rows = append(rows, []string{row.Foundation, row.Resource, row.Source, strconv.Itoa(row.Cells), strconv.Itoa(row.CapacityMB), strconv.Itoa(row.ReservedMB),
+			strconv.FormatFloat(row.HeadroomPercent, 'f', 1, 64), growth, exhausted})
+	}
+	if report.Rows == nil {
+		report.Rows = []headroomRow{}
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//collectorHealth is what /healthz and /readyz report: how every foundation's collections
+//have been going and whether its token is still good
+type collectorHealth struct {
+	mu          sync.Mutex
+	started     time.Time
+	maxAge      time.Duration
+	foundations []foundation
+	status      map[string]*foundationHealth
+	breakers    map[string]*circuitBreaker
+}
+
+type foundationHealth struct {
+	Name        string     `json:"name"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	//TokenExpiresAt is empty for tokens that don't say, TokenValid is false once it's passed
+	//and there's no way to get a new one
+	TokenExpiresAt   *time.Time `json:"token_expires_at,omitempty"`
+	TokenRefreshable bool       `json:"token_refreshable"`
+	TokenValid       bool       `json:"token_valid"`
+	Healthy          bool       `json:"healthy"`
+	Ready            bool       `json:"ready"`
+	//Breaker is the foundation's circuit breaker, when it has one
+	Breaker *breakerStatus `json:"breaker,omitempty"`
+}
+
+//newCollectorHealth tracks foundations, which are unhealthy once nothing's been collected
+//from them in maxAge, counting from the start for the first run
+func newCollectorHealth(foundations []foundation, maxAge time.Duration) *collectorHealth {
+	health := &collectorHealth{started: time.Now(), maxAge: maxAge, foundations: foundations, status: map[string]*foundationHealth{}, breakers: map[string]*circuitBreaker{}}
+	for _, target := range foundations {
+		health.status[target.name] = &foundationHealth{Name: target.name}
+	}
+	return health
+}
+
+//watchBreaker adds a foundation's circuit breaker state to its health, nil health or
+//breakers are left out
+func (health *collectorHealth) watchBreaker(foundation string, breaker *circuitBreaker) {
+	if health == nil || breaker == nil {
+		return
+	}
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.breakers[foundation] = breaker
+}
+
+//record is how a collection of foundation went, nil health records nothing
+func (health *collectorHealth) record(foundation string, err error) {
+	if health == nil {
+		return
+	}
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	status := health.status[foundation]
+	if status == nil {
+		return
+	}
+	now := time.Now()
+	status.LastAttempt = &now
+	if err != nil {
+		status.LastError, status.LastErrorAt = err.Error(), &now
+		return
+	}
+	status.LastSuccess = &now
+}
+
+//check is every foundation's health now, and whether they're all healthy and all ready
+func (health *collectorHealth) check(now time.Time) ([]foundationHealth, bool, bool) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	var statuses []foundationHealth
+	healthy, ready := true, true
+	for _, target := range health.foundations {
+		status := *health.status[target.name]
+		expiresAt, known, refreshable := target.client.TokenStatus()
+		status.TokenExpiresAt, status.TokenRefreshable = nil, refreshable
+		if known {
+			status.TokenExpiresAt = &expiresAt
+		}
+		status.TokenValid = refreshable || !known || now.Before(expiresAt)
+
+		since := health.started
+		if status.LastSuccess != nil {
+			since = *status.LastSuccess
+		}
+		status.Healthy = now.Sub(since) <= health.maxAge && status.TokenValid
+		status.Ready = status.LastSuccess != nil && status.TokenValid
+		if breaker := health.breakers[target.name]; breaker != nil {
+			breakerStatus := breaker.status(now)
+			status.Breaker = &breakerStatus
+		}
+		healthy = healthy && status.Healthy
+		ready = ready && status.Ready
+		statuses = append(statuses, status)
+	}
+	return statuses, healthy, ready
+}
+
+//healthz is for liveness probes: 503 once a foundation hasn't been collected in -health-max-age
+//or its token can't be used any more, which a restart might fix
+func (health *collectorHealth) healthz(w http.ResponseWriter, r *http.Request) {
+	statuses, healthy, _ := health.check(time.Now())
+	writeHealth(w, healthy, statuses)
+}
+
+//readyz is for readiness probes: 503 until every foundation has been collected once
+func (health *collectorHealth) readyz(w http.ResponseWriter, r *http.Request) {
+	statuses, _, ready := health.check(time.Now())
+	writeHealth(w, ready, statuses)
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, statuses []foundationHealth) {
+	status, code := "ok", http.StatusOK
+	if !ok {
+		status, code = "failing", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(map[string]interface{}{"status": status, "foundations": statuses})
+}
+
+func (health *collectorHealth) handle(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", health.healthz)
+	mux.HandleFunc("/readyz", health.readyz)
+}
+
+//serveHealth starts serving /healthz and /readyz on address in the background. it only
+//returns an error when it can't listen
+func serveHealth(address string, health *collectorHealth) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	health.handle(mux)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("serving health checks", "address", listener.Addr().String())
+	go func() {
+		err := server.Serve(listener)
+		slog.Error("the health check server stopped", "err", err)
+	}()
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//defaultHealthCheckTimeout is the health check timeout a process gets when it doesn't set one
+const defaultHealthCheckTimeout = 60 * time.Second
+
+//healthCheckRow is a process report health-checks prints, with what's wrong with its check
+type healthCheckRow struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	App        string `json:"app"`
+	AppGUID    string `json:"app_guid"`
+	Process    string `json:"process"`
+	Instances  int    `json:"instances"`
+	cfclient.HealthCheck
+	//Problems are the guidelines the check breaks, none for a compliant one
+	Problems []string `json:"problems"`
+}
+
+//healthCheckRules are what report health-checks holds processes to
+type healthCheckRules struct {
+	//RequireHTTP flags web processes with port checks as well as process and none ones
+	RequireHTTP bool `json:"require_http"`
+	//MaxTimeout and MaxInvocationTimeout flag checks allowed to take longer, 0 for no limit
+	MaxTimeout           time.Duration `json:"max_timeout"`
+	MaxInvocationTimeout time.Duration `json:"max_invocation_timeout"`
+}
+
+type healthCheckReport struct {
+	Rules     healthCheckRules `json:"rules"`
+	Processes []healthCheckRow `json:"processes"`
+	//Checked and Failing count every process looked at and the ones with problems
+	Checked int `json:"checked"`
+	Failing int `json:"failing"`
+}
+
+//problems are the rules process breaks. a process with no check at all breaks them whatever
+//its type, but only web processes are expected to answer http, workers have no port to check
+func (rules healthCheckRules) problems(process cfclient.Process) []string {
+	var problems []string
+	kind := process.HealthCheckType
+	switch {
+	case kind == cfclient.HealthCheckNone:
+		problems = append(problems, "no health check")
+	case process.Type == cfclient.WebProcess && kind == cfclient.HealthCheckProcess:
+		problems = append(problems, "process health check")
+	case process.Type == cfclient.WebProcess && kind == cfclient.HealthCheckPort && rules.RequireHTTP:
+		problems = append(problems, "port health check")
+	}
+	timeout := time.Duration(process.HealthCheckTimeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	if rules.MaxTimeout > 0 && timeout > rules.MaxTimeout {
+		problems = append(problems, "timeout "+timeout.String())
+	}
+	invocation := time.Duration(process.InvocationTimeout) * time.Second
+	if rules.MaxInvocationTimeout > 0 && invocation > rules.MaxInvocationTimeout {
+		problems = append(problems, "invocation timeout "+invocation.String())
+	}
+	return problems
+}
+
+//add checks a run's processes, keeping the failing ones or every one with all
+func (report *healthCheckReport) add(foundation string, result cfclient.CollectionResult, all bool) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, process := range cfclient.HealthChecks(space) {
+			report.Checked++
+			problems := report.Rules.problems(process)
+			if len(problems) > 0 {
+				report.Failing++
+			} else if !all {
+				continue
+			}
+			report.Processes = append(report.Processes, healthCheckRow{
+				Foundation:  foundation,
+				Org:         orgNames[space.OrganizationGUID],
+				Space:       space.Name,
+				App:         process.AppName,
+				AppGUID:     process.AppGUID,
+				Process:     process.Type,
+				Instances:   process.Instances,
+				HealthCheck: process.HealthCheck,
+				Problems:    problems,
+			})
+		}
+	}
+}
+
+//sort lists the failing processes first, then by foundation, org, space, app and process
+func (report *healthCheckReport) sort() {
+	sort.SliceStable(report.Processes, func(i, j int) bool {
+		a, b := report.Processes[i], report.Processes[j]
+		if (len(a.Problems) > 0) != (len(b.Problems) > 0) {
+			return len(a.Problems) > 0
+		}
+		return a.Foundation+"/"+a.Org+"/"+a.Space+"/"+a.App+"/"+a.Process < b.Foundation+"/"+b.Org+"/"+b.Space+"/"+b.App+"/"+b.Process
+	})
+	if report.Processes == nil {
+		report.Processes = []healthCheckRow{}
+	}
+}
+
+func (report healthCheckReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "app", "process", "instances", "type", "timeout", "invocation_timeout", "endpoint", "problems"}}
+	for _, process := range report.Processes {
+		rows = append(rows, []string{process.Foundation, process.Org, process.Space, process.App, process.Process, strconv.Itoa(process.Instances),
+			process.HealthCheckType, strconv.Itoa(process.HealthCheckTimeout), strconv.Itoa(process.InvocationTimeout), process.HealthCheckEndpoint,
+			strings.Join(process.Problems, ", ")})
+	}
+	return writeListing(out, format, report, rows)
+}
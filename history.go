@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+func newSnapshot(foundation string, result cfclient.CollectionResult, timestamp time.Time) snapshot.Record {
+	report := newRunReport(foundation, result)
+	snap := snapshot.Record{SchemaVersion: snapshot.SchemaVersion, Foundation: foundation, Timestamp: timestamp, Orgs: report.Orgs, Spaces: report.Spaces}
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		snap.RunningMemoryMB += space.Usage.RunningMemoryMB
+		snap.RunningDiskMB += space.Usage.RunningDiskMB
+		for _, app := range space.Apps {
+			snap.Apps = append(snap.Apps, snapshot.App{
+				GUID:  app.Metadata.GUID,
+				Name:  cfclient.EntityString(app, "name"),
+				Org:   orgNames[space.OrganizationGUID],
+				Space: space.Name,
+			})
+		}
+	}
+	return snap
+}
+
+//historySink appends every run's snapshot to a local json lines file, so runs can be
+//compared without any infrastructure. each foundation's snapshots are told apart by name.
+//the growth trend of the store goes to trends after every run. snapshots older than
+//retention are dropped when the file is rewritten, 0 keeps them all
+type historySink struct {
+	path       string
+	foundation string
+	retention  time.Duration
+	trends     []trendSink
+}
+
+//historyLocks has a mutex per history file, so the sinks of several -foundations writing to
+//the same one take turns reading and appending to it
+var historyLocks sync.Map
+
+//historyTrendWindow is how far back a run reads the history for the growth trend, the week
+//of growthTrend
+const historyTrendWindow = 7 * 24 * time.Hour
+
+func (history historySink) name() string {
+	return "history store"
+}
+
+func (history historySink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	lock, _ := historyLocks.LoadOrStore(history.path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	//only the end of the file is read, back to the previous snapshot or the trend's week
+	since := timestamp
+	if len(history.trends) > 0 {
+		since = timestamp.Add(-historyTrendWindow)
+	}
+	recent, damaged, err := readHistoryTail(history.path, history.foundation, since)
+	if err != nil {
+		return err
+	}
+	latestApps, err := readLatestApps(history.appsPath())
+	if err != nil {
+		return err
+	}
+	snap := newSnapshot(history.foundation, result, timestamp)
+	apps := snap.Apps
+	if len(recent) > 0 {
+		previous := recent[len(recent)-1]
+		//a store written before the apps file has every app in each snapshot
+		before, known := latestApps[history.foundation]
+		if !known {
+			before = previous.Apps
+		}
+		if known || previous.Apps != nil {
+			snap.AppsAdded, snap.AppsRemoved = appChanges(before, apps)
+		}
+		crashes := appCrashesSince(result.Spaces, previous.Timestamp)
+		for _, app := range apps {
+			if crashes[app.GUID] > 0 {
+				app.NewCrashes = crashes[app.GUID]
+				snap.CrashedApps = append(snap.CrashedApps, app)
+			}
+		}
+		//org event queries cover their spaces' events too
+		for _, org := range result.Orgs {
+			for _, event := range org.Events {
+				if event.Timestamp.After(previous.Timestamp) {
+					snap.NewEvents++
+					if snap.NewEventTypes == nil {
+						snap.NewEventTypes = map[string]int{}
+					}
+					snap.NewEventTypes[event.Type]++
+				}
+			}
+		}
+		slog.Info("changes since the last run", "foundation", history.foundation, "last_run", previous.Timestamp.Format(time.RFC3339),
+			"apps_added", snap.AppsAdded, "apps_removed", snap.AppsRemoved, "new_events", snap.NewEvents)
+		//every app only goes in the foundation's first snapshot of the day
+		if periodStart(previous.Timestamp, "day").Equal(periodStart(timestamp, "day")) {
+			snap.Apps = nil
+		}
+	}
+	encoded, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	prune, err := history.pruneDue(timestamp)
+	if err != nil {
+		return err
+	}
+	if damaged || prune {
+		//rewriting the file is what drops the expired snapshots and the lines that couldn't be
+		//read, and a torn last line would run into the one appended after it otherwise
+		err = history.rewrite(timestamp, encoded)
+	} else {
+		err = appendHistory(history.path, encoded)
+	}
+	if err != nil {
+		return err
+	}
+	latestApps[history.foundation] = apps
+	err = writeLatestApps(history.appsPath(), latestApps)
+	if err != nil {
+		return err
+	}
+	if len(history.trends) > 0 {
+		writeTrends(history.trends, history.foundation, newGrowthTrend(append(recent, snap), history.foundation, timestamp), timestamp)
+	}
+	return nil
+}
+
+//pruneDue is whether the file's first snapshot is more than a day past the retention, so
+//the expired ones are dropped about once a day rather than by rewriting the file every run
+func (history historySink) pruneDue(now time.Time) (bool, error) {
+	if history.retention <= 0 {
+		return false, nil
+	}
+	file, err := os.Open(history.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	first, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	var header historyHeader
+	if json.Unmarshal(first, &header) != nil {
+		return true, nil
+	}
+	return header.Timestamp.Before(now.Add(-history.retention - 24*time.Hour)), nil
+}
+
+//rewrite replaces the file with the snapshots within the retention of now and line on the
+//end, leaving out the lines that can't be read. each foundation's latest snapshot is kept
+//however old it is, so its next run still has something to compare itself with, and goes
+//just before line so the file doesn't keep starting with it
+func (history historySink) rewrite(now time.Time, line []byte) error {
+	latest := map[string]time.Time{history.foundation: now}
+	err := eachHistoryLine(history.path, func(raw []byte, header historyHeader) {
+		if header.Timestamp.After(latest[header.Foundation]) {
+			latest[header.Foundation] = header.Timestamp
+		}
+	})
+	if err != nil {
+		return err
+	}
+	var cutoff time.Time
+	if history.retention > 0 {
+		cutoff = now.Add(-history.retention)
+	}
+	return replaceFile(history.path, func(writer *bufio.Writer) error {
+		var stale [][]byte
+		err := eachHistoryLine(history.path, func(raw []byte, header historyHeader) {
+			if !header.Timestamp.Before(cutoff) {
+				writer.Write(raw)
+				writer.WriteByte('\n')
+			} else if header.Timestamp.Equal(latest[header.Foundation]) {
+				stale = append(stale, raw)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for _, raw := range append(stale, line) {
+			writer.Write(raw)
+			writer.WriteByte('\n')
+		}
+		return nil
+	})
+}
+
+//historyHeader is what every line of a history file says about itself, whatever its schema
+//version
+type historyHeader struct {
+	Foundation string    `json:"foundation"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+//eachHistoryLine calls each with every line of a history file that reads as json, none when
+//the file doesn't exist yet. a line that doesn't, e.g. the half written last one of a run that
+//was killed, is logged and skipped rather than making the whole history unreadable
+func eachHistoryLine(path string, each func(raw []byte, header historyHeader)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		raw, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 {
+			var header historyHeader
+			headerErr := json.Unmarshal(raw, &header)
+			if headerErr != nil {
+				slog.Warn("skipping a history line that isn't a snapshot", "path", path, "line", number, "error", headerErr)
+			} else {
+				each(raw, header)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+//readSnapshots reads every snapshot in a history file, none when it doesn't exist yet. older
+//lines are migrated to the current schema version as they're read, the file is left as it is
+func readSnapshots(path string) ([]snapshot.Record, error) {
+	var snapshots []snapshot.Record
+	err := eachHistoryLine(path, func(raw []byte, header historyHeader) {
+		var snap snapshot.Record
+		err := snapshot.Decode(raw, &snap)
+		if err != nil {
+			slog.Warn("skipping a history line that isn't a snapshot", "path", path, "foundation", header.Foundation, "timestamp", header.Timestamp, "error", err)
+			return
+		}
+		snapshots = append(snapshots, snap)
+	})
+	return snapshots, err
+}
+
+//historyChunk is how much of a history file readHistoryTail reads at a time
+const historyChunk = 1 << 20
+
+//readHistoryTail reads a history file back from its end as far as the foundation's first
+//snapshot from before since, so a run reads what it compares itself with rather than the
+//whole file. they're the foundation's snapshots oldest first, and damaged says a line that
+//was read isn't a snapshot or the last one was cut off
+func readHistoryTail(path string, foundation string, since time.Time) (snapshots []snapshot.Record, damaged bool, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	//take reads one line, and is true once it's gone back far enough
+	take := func(raw []byte) bool {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			return false
+		}
+		var header historyHeader
+		err := json.Unmarshal(raw, &header)
+		if err == nil && header.Foundation != foundation {
+			return false
+		}
+		var snap snapshot.Record
+		if err == nil {
+			err = snapshot.Decode(raw, &snap)
+		}
+		if err != nil {
+			slog.Warn("skipping a history line that isn't a snapshot", "path", path, "error", err)
+			damaged = true
+			return false
+		}
+		snapshots = append(snapshots, snap)
+		return snap.Timestamp.Before(since)
+	}
+
+	offset := info.Size()
+	//partial is the end of the file not gone through yet, from the start of a line it may be
+	//in the middle of
+	var partial []byte
+	chunk := make([]byte, historyChunk)
+	done := false
+	for !done && offset > 0 {
+		size := int64(len(chunk))
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		_, err = file.ReadAt(chunk[:size], offset)
+		if err != nil {
+			return nil, false, err
+		}
+		if offset+size == info.Size() && chunk[size-1] != '\n' {
+			damaged = true
+		}
+		partial = append(append([]byte{}, chunk[:size]...), partial...)
+		for !done {
+			newline := bytes.LastIndexByte(partial, '\n')
+			if newline < 0 {
+				break
+			}
+			done = take(partial[newline+1:])
+			partial = partial[:newline]
+		}
+	}
+	if !done && offset == 0 {
+		take(partial)
+	}
+	for left, right := 0, len(snapshots)-1; left < right; left, right = left+1, right-1 {
+		snapshots[left], snapshots[right] = snapshots[right], snapshots[left]
+	}
+	return snapshots, damaged, nil
+}
+
+//appendHistory adds a line to the end of a history file
+func appendHistory(path string, line []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//replaceFile replaces path with what write writes, through a temporary file next to it so a
+//run killed halfway through leaves the old one in place
+func replaceFile(path string, write func(writer *bufio.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	writer := bufio.NewWriter(file)
+	err = write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+//appsPath is the file next to the history with each foundation's apps as of its latest run,
+//what the next run counts the apps added and removed against
+func (history historySink) appsPath() string {
+	return history.path + ".apps"
+}
+
+//readLatestApps reads an apps file, empty when there isn't one yet
+func readLatestApps(path string) (map[string][]snapshot.App, error) {
+	apps := map[string][]snapshot.App{}
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return apps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &apps)
+	if err != nil {
+		//it's only the last run's apps, this run starts it again
+		slog.Warn("ignoring a history apps file that can't be read", "path", path, "error", err)
+		return map[string][]snapshot.App{}, nil
+	}
+	return apps, nil
+}
+
+func writeLatestApps(path string, apps map[string][]snapshot.App) error {
+	return replaceFile(path, func(writer *bufio.Writer) error {
+		return json.NewEncoder(writer).Encode(apps)
+	})
+}
+
+//appChanges counts the apps in after that weren't in before, and the other way round
+func appChanges(before []snapshot.App, after []snapshot.App) (added int, removed int) {
+	beforeApps := map[string]bool{}
+	for _, app := range before {
+		beforeApps[app.GUID] = true
+	}
+	afterApps := map[string]bool{}
+	for _, app := range after {
+		afterApps[app.GUID] = true
+		if !beforeApps[app.GUID] {
+			added++
+		}
+	}
+	for guid := range beforeApps {
+		if !afterApps[guid] {
+			removed++
+		}
+	}
+	return added, removed
+}
+
+//historyRow is one snapshot's totals, what report history prints
+type historyRow struct {
+	Foundation       string    `json:"foundation"`
+	Timestamp        time.Time `json:"timestamp"`
+	Orgs             int       `json:"orgs"`
+	Spaces           int       `json:"spaces"`
+	Apps             int       `json:"apps"`
+	Instances        int       `json:"instances"`
+	ReservedMemoryMB int       `json:"reserved_memory_mb"`
+	AppsAdded        int       `json:"apps_added"`
+	AppsRemoved      int       `json:"apps_removed"`
+	NewEvents        int       `json:"new_events"`
+}
+
+type historyReport struct {
+	Snapshots []historyRow `json:"snapshots"`
+}
+
+//add adds every snapshot, only the foundation's when it isn't empty, oldest first
+func (report *historyReport) add(snapshots []snapshot.Record, foundation string) {
+	for _, snap := range snapshots {
+		if foundation != "" && snap.Foundation != foundation {
+			continue
+		}
+		apps, instances, memory := snap.Totals()
+		report.Snapshots = append(report.Snapshots, historyRow{
+			Foundation:       snap.Foundation,
+			Timestamp:        snap.Timestamp,
+			Orgs:             len(snap.Orgs),
+			Spaces:           len(snap.Spaces),
+			Apps:             apps,
+			Instances:        instances,
+			ReservedMemoryMB: memory,
+			AppsAdded:        snap.AppsAdded,
+			AppsRemoved:      snap.AppsRemoved,
+			NewEvents:        snap.NewEvents,
+		})
+	}
+	sort.SliceStable(report.Snapshots, func(i, j int) bool {
+		return report.Snapshots[i].Timestamp.Before(report.Snapshots[j].Timestamp)
+	})
+	if report.Snapshots == nil {
+		report.Snapshots = []historyRow{}
+	}
+}
+
+func (report historyReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "timestamp", "orgs", "spaces", "apps", "instances", "reserved_memory_mb", "apps_added", "apps_removed", "new_events"}}
+	for _, row := range report.Snapshots {
+		rows = append(rows, []string{row.Foundation, row.Timestamp.UTC().Format(time.RFC3339), strconv.Itoa(row.Orgs), strconv.Itoa(row.Spaces),
+			strconv.Itoa(row.Apps), strconv.Itoa(row.Instances), strconv.Itoa(row.ReservedMemoryMB),
+			strconv.Itoa(row.AppsAdded), strconv.Itoa(row.AppsRemoved), strconv.Itoa(row.NewEvents)})
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+func historyResult(apps ...string) cfclient.CollectionResult {
+	space := cfclient.Data{Name: "prod", GUID: "space-a", OrganizationGUID: "org-a"}
+	for _, name := range apps {
+		space.Apps = append(space.Apps, cfclient.Resource{Metadata: cfclient.ResourceMetadata{GUID: name + "-guid"}, Entity: map[string]interface{}{"name": name}})
+	}
+	return cfclient.CollectionResult{Orgs: []cfclient.Data{{Name: "payments", GUID: "org-a"}}, Spaces: []cfclient.Data{space}}
+}
+
+func TestHistoryKeepsEveryAppOnceADay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history := historySink{path: path, foundation: "prod", retention: 90 * 24 * time.Hour}
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	for _, run := range []struct {
+		at   time.Time
+		apps []string
+	}{
+		{start, []string{"api", "worker"}},
+		{start.Add(time.Hour), []string{"api", "worker", "batch"}},
+		{start.Add(24 * time.Hour), []string{"api", "batch"}},
+	} {
+		err := history.write(historyResult(run.apps...), run.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := readSnapshots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("there should be 3 snapshots, got %d", len(snapshots))
+	}
+	for index, want := range []struct {
+		apps           int
+		added, removed int
+	}{{2, 0, 0}, {0, 1, 0}, {2, 0, 1}} {
+		snap := snapshots[index]
+		if len(snap.Apps) != want.apps || snap.AppsAdded != want.added || snap.AppsRemoved != want.removed {
+			t.Errorf("snapshot %d should have %d apps, %d added and %d removed, got %d, %d and %d", index, want.apps, want.added, want.removed, len(snap.Apps), snap.AppsAdded, snap.AppsRemoved)
+		}
+	}
+}
+
+func TestHistoryTailStopsAtThePreviousSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	var lines []string
+	for index := 0; index < 5; index++ {
+		for _, foundation := range []string{"prod", "dev"} {
+			lines = append(lines, fmt.Sprintf(`{"schema_version":3,"foundation":%q,"timestamp":%q,"orgs":[],"spaces":[]}`, foundation, start.Add(time.Duration(index)*time.Hour).Format(time.RFC3339)))
+		}
+	}
+	//a run killed halfway through its line
+	err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n{\"schema_version\":3,\"foun"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, damaged, err := readHistoryTail(path, "prod", start.Add(5*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !damaged {
+		t.Error("the torn last line should make the file damaged")
+	}
+	if len(snapshots) != 1 || !snapshots[0].Timestamp.Equal(start.Add(4*time.Hour)) {
+		t.Fatalf("only prod's latest snapshot should be read, got %v", snapshots)
+	}
+
+	snapshots, _, err = readHistoryTail(path, "dev", start.Add(90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 4 || !snapshots[0].Timestamp.Equal(start.Add(time.Hour)) {
+		t.Errorf("dev should go back to its 1 hour snapshot, oldest first, got %v", snapshots)
+	}
+}
+
+func TestHistoryPrunesOnceADayPastTheRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	prod := historySink{path: path, foundation: "prod", retention: 48 * time.Hour}
+	dev := historySink{path: path, foundation: "dev", retention: 48 * time.Hour}
+	for _, run := range []struct {
+		sink historySink
+		at   time.Time
+	}{
+		{dev, start},
+		{prod, start},
+		{prod, start.Add(24 * time.Hour)},
+		//dev's snapshot is past the retention, but not by a day yet
+		{prod, start.Add(60 * time.Hour)},
+		{prod, start.Add(80 * time.Hour)},
+	} {
+		err := run.sink.write(historyResult("api"), run.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := readSnapshots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, snap := range snapshots {
+		got = append(got, fmt.Sprintf("%s %.0fh", snap.Foundation, snap.Timestamp.Sub(start).Hours()))
+	}
+	//dev's only snapshot is kept however old it is, after the ones that are in the retention
+	want := "prod 60h, dev 0h, prod 80h"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("the history should be %s, got %s", want, strings.Join(got, ", "))
+	}
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//influxTagEscaper escapes tag keys and values per the line protocol rules
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+//influxSink writes org and space counts to influxdb as line protocol. with a bucket it uses
+//the 2.x /api/v2/write endpoint and token auth, otherwise the 1.x /write endpoint and a
+//database. an address of "-" prints the lines to stdout instead
+type influxSink struct {
+	address    string
+	database   string
+	bucket     string
+	org        string
+	token      string
+	foundation string
+}
+
+func (influx influxSink) name() string {
+	return "influxdb"
+}
+
+func (influx influxSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	lines := influxLines(influx.foundation, result.Orgs, result.Spaces, timestamp)
+	lines = append(lines, influxCrashLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxDomainLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxPlanLines(influx.foundation, result.Orgs, timestamp)...)
+	lines = append(lines, influxStackLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxRoleLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxEventLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxSegmentLines(influx.foundation, result.Spaces, timestamp)...)
+	lines = append(lines, influxTaskLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxBuildLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxBlobLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxDeploymentLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxProcessLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxSSHLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxUserProvidedLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxEnvLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxPlatformLines(influx.foundation, result.Platform, timestamp)...)
+	lines = append(lines, influxSecurityGroupLines(influx.foundation, result.SecurityGroups, timestamp)...)
+	lines = append(lines, influxCatalogLines(influx.foundation, result, timestamp)...)
+	lines = append(lines, influxRequestLines(influx.foundation, result.Requests, timestamp)...)
+	return writeInflux(influx, lines)
+}
+
+//writeTrend writes a cf_growth point per window, tagged day or week, with the orgs, spaces
+//and apps created and deleted in it
+func (influx influxSink) writeTrend(trend growthTrend, timestamp time.Time) error {
+	var lines []string
+	for _, window := range growthWindows {
+		fields := trend.window(window).fields()
+		var values []string
+		for _, name := range sortedKeys(fields) {
+			values = append(values, fmt.Sprintf("%s=%di", name, fields[name]))
+		}
+		lines = append(lines, fmt.Sprintf("cf_growth,foundation=%s,window=%s %s %d",
+			influxTagEscaper.Replace(tagValue(influx.foundation)), window, strings.Join(values, ","), timestamp.UnixNano()))
+	}
+	return writeInflux(influx, lines)
+}
+
+//influxRequestLines writes a cf_api_requests point per cloud controller endpoint the run
+//called, with how many requests failed or were retried and how long they took
+func influxRequestLines(foundation string, endpoints []cfclient.EndpointStats, timestamp time.Time) []string {
+	var lines []string
+	for _, endpoint := range endpoints {
+		lines = append(lines, fmt.Sprintf("cf_api_requests,foundation=%s,endpoint=%s requests=%di,errors=%di,retries=%di,error_rate=%s,latency_mean_seconds=%s,latency_p50_seconds=%s,latency_p95_seconds=%s %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(endpoint.Endpoint),
+			endpoint.Requests, endpoint.Errors, endpoint.Retries,
+			strconv.FormatFloat(endpoint.ErrorRate(), 'f', -1, 64),
+			strconv.FormatFloat(endpoint.MeanLatency().Seconds(), 'f', -1, 64),
+			strconv.FormatFloat(endpoint.Quantile(0.5).Seconds(), 'f', -1, 64),
+			strconv.FormatFloat(endpoint.Quantile(0.95).Seconds(), 'f', -1, 64),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxCrashLines writes a cf_app_crashes point for every app that crashed during the run's
+//event window, with crashes_per_hour when the window has a start
+func influxCrashLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, crash := range spaceAppCrashes(space) {
+			lines = append(lines, fmt.Sprintf("cf_app_crashes,foundation=%s,org=%s,space=%s,app=%s,app_guid=%s crashes=%di,crashes_per_hour=%s %d",
+				influxTagEscaper.Replace(tagValue(foundation)),
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				influxTagEscaper.Replace(tagValue(crash.name)),
+				tagValue(crash.guid),
+				crash.crashes,
+				strconv.FormatFloat(result.CrashesPerHour(crash.crashes), 'f', -1, 64),
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//influxDomainLines writes a cf_domain point per domain with how many routes are on it
+func influxDomainLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	routes := cfclient.RoutesPerDomain(result.Spaces, result.Domains)
+	for _, domain := range result.Domains {
+		lines = append(lines, fmt.Sprintf("cf_domain,foundation=%s,domain=%s,shared=%t routes=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(domain.Name)),
+			domain.Shared,
+			routes[domain.Name],
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxPlanLines writes a cf_service_plan point per service plan in use in each org
+func influxPlanLines(foundation string, orgs []cfclient.Data, timestamp time.Time) []string {
+	var lines []string
+	for _, org := range orgs {
+		for _, plan := range cfclient.CountByPlan(org.ServiceInstances, timestamp) {
+			lines = append(lines, fmt.Sprintf("cf_service_plan,foundation=%s,org=%s,service=%s,plan=%s,broker=%s instances=%di,bindings=%di,stuck=%di %d",
+				influxTagEscaper.Replace(tagValue(foundation)),
+				influxTagEscaper.Replace(tagValue(org.Name)),
+				influxTagEscaper.Replace(tagValue(plan.ServiceName)),
+				influxTagEscaper.Replace(tagValue(plan.PlanName)),
+				influxTagEscaper.Replace(tagValue(plan.BrokerName)),
+				plan.Instances, plan.Bindings, plan.Stuck,
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//influxStackLines writes a cf_stack point for every stack apps run on in each org, and in
+//each space tagged with it
+func influxStackLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		for _, stack := range sortedKeys(org.StackCounts) {
+			lines = append(lines, fmt.Sprintf("cf_stack,foundation=%s,org=%s,stack=%s stack_apps=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(org.Name)),
+				influxTagEscaper.Replace(tagValue(stack)),
+				org.StackCounts[stack],
+				timestamp.UnixNano()))
+		}
+	}
+	for _, space := range result.Spaces {
+		for _, stack := range sortedKeys(space.StackCounts) {
+			lines = append(lines, fmt.Sprintf("cf_stack,foundation=%s,org=%s,space=%s,stack=%s stack_apps=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				influxTagEscaper.Replace(tagValue(stack)),
+				space.StackCounts[stack],
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//influxSegmentLines writes a cf_isolation_segment point per segment apps run in, shared
+//included
+func influxSegmentLines(foundation string, spaces []cfclient.Data, timestamp time.Time) []string {
+	var lines []string
+	for _, total := range cfclient.TotalsBySegment(spaces) {
+		lines = append(lines, fmt.Sprintf("cf_isolation_segment,foundation=%s,segment=%s orgs=%di,spaces=%di,apps=%di,instances=%di,reserved_memory_mb=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(total.Segment)),
+			total.Orgs, total.Spaces, total.Apps, total.Instances, total.ReservedMemoryMB,
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxTaskLines writes a cf_app_tasks point for every app that ran tasks during the event
+//window, a field per state and the mean and longest run of its finished tasks
+func influxTaskLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, summary := range cfclient.SummarizeTasks(space) {
+			var fields []string
+			for _, state := range cfclient.TaskStateList {
+				fields = append(fields, fmt.Sprintf("%s=%di", strings.ToLower(state), summary.States[state]))
+			}
+			lines = append(lines, fmt.Sprintf("cf_app_tasks,foundation=%s,org=%s,space=%s,app=%s,app_guid=%s %s,mean_duration_seconds=%s,max_duration_seconds=%s %d",
+				influxTagEscaper.Replace(tagValue(foundation)),
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				influxTagEscaper.Replace(tagValue(summary.AppName)),
+				tagValue(summary.AppGUID),
+				strings.Join(fields, ","),
+				strconv.FormatFloat(summary.MeanDuration.Seconds(), 'f', -1, 64),
+				strconv.FormatFloat(summary.MaxDuration.Seconds(), 'f', -1, 64),
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//influxBuildLines writes a cf_builds point per space and a cf_buildpack_builds point per
+//buildpack when builds were collected, a field per state and the p50 and p95 staging times
+func influxBuildLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !contains(result.Collectors, "builds") {
+		return nil
+	}
+	fields := func(summary cfclient.BuildSummary) string {
+		var fields []string
+		for _, state := range cfclient.BuildStateList {
+			fields = append(fields, fmt.Sprintf("%s=%di", strings.ToLower(state), summary.States[state]))
+		}
+		return fmt.Sprintf("%s,p50_seconds=%s,p95_seconds=%s", strings.Join(fields, ","),
+			strconv.FormatFloat(summary.P50.Seconds(), 'f', -1, 64), strconv.FormatFloat(summary.P95.Seconds(), 'f', -1, 64))
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		lines = append(lines, fmt.Sprintf("cf_builds,foundation=%s,org=%s,space=%s %s %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			fields(cfclient.SummarizeBuilds(space.Builds)),
+			timestamp.UnixNano()))
+	}
+	byBuildpack := cfclient.SummarizeBuildsByBuildpack(result.Spaces)
+	for _, buildpack := range sortedBuildpacks(byBuildpack) {
+		lines = append(lines, fmt.Sprintf("cf_buildpack_builds,foundation=%s,buildpack=%s %s %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(buildpack)),
+			fields(byBuildpack[buildpack]),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxBlobLines writes a cf_org_blobstore point per org with its apps' droplet and package
+//bytes when blobs were sized, and a cf_app_blobs point per app
+func influxBlobLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !contains(result.Collectors, "blobs") {
+		return nil
+	}
+	var lines []string
+	footprints := cfclient.OrgBlobstoreFootprints(result.Spaces)
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		footprint := footprints[org.GUID]
+		lines = append(lines, fmt.Sprintf("cf_org_blobstore,foundation=%s,org=%s droplet_bytes=%di,package_bytes=%di,large_droplet_apps=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(org.Name)),
+			footprint.DropletBytes, footprint.PackageBytes, footprint.LargeApps,
+			timestamp.UnixNano()))
+	}
+	for _, space := range result.Spaces {
+		for _, blobs := range space.Blobs {
+			lines = append(lines, fmt.Sprintf("cf_app_blobs,foundation=%s,org=%s,space=%s,app=%s,app_guid=%s droplets=%di,droplet_bytes=%di,largest_droplet_bytes=%di,packages=%di,package_bytes=%di,large=%t %d",
+				influxTagEscaper.Replace(tagValue(foundation)),
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				influxTagEscaper.Replace(tagValue(blobs.AppName)),
+				tagValue(blobs.AppGUID),
+				blobs.Droplets, blobs.DropletBytes, blobs.LargestDropletBytes, blobs.Packages, blobs.PackageBytes, blobs.Large,
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//sortedBuildpacks are the buildpacks of SummarizeBuildsByBuildpack in order
+func sortedBuildpacks(summaries map[string]cfclient.BuildSummary) []string {
+	var buildpacks []string
+	for buildpack := range summaries {
+		buildpacks = append(buildpacks, buildpack)
+	}
+	sort.Strings(buildpacks)
+	return buildpacks
+}
+
+//influxDeploymentLines writes a cf_deployments point per space when deployments were
+//collected, with deployments_per_hour over the event window like crashes_per_hour
+func influxDeploymentLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !result.DeploymentsCollected {
+		return nil
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		summary := cfclient.SummarizeDeployments(space, result.EventsSince)
+		lines = append(lines, fmt.Sprintf("cf_deployments,foundation=%s,org=%s,space=%s deployments=%di,rolling=%di,canceled=%di,active=%di,pinned_apps=%di,restart_updates=%di,zero_downtime_percent=%s,deployments_per_hour=%s %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			summary.Deployments, summary.Rolling, summary.Canceled, summary.Active, summary.PinnedApps, summary.RestartUpdates,
+			strconv.FormatFloat(summary.ZeroDowntimePercent(), 'f', -1, 64),
+			strconv.FormatFloat(result.PerHour(summary.Deployments), 'f', -1, 64),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxProcessLines writes a cf_process point per space and process type, and a cf_sidecars
+//point per space, when processes were collected
+func influxProcessLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !result.ProcessesCollected {
+		return nil
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		tags := fmt.Sprintf("foundation=%s,org=%s,space=%s",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)))
+		for _, summary := range cfclient.SummarizeProcesses(space) {
+			lines = append(lines, fmt.Sprintf("cf_process,%s,type=%s apps=%di,instances=%di,memory_mb=%di %d",
+				tags, influxTagEscaper.Replace(tagValue(summary.Type)), summary.Apps, summary.Instances, summary.MemoryMB, timestamp.UnixNano()))
+		}
+		lines = append(lines, fmt.Sprintf("cf_sidecars,%s sidecars=%di %d", tags, len(space.Sidecars), timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxSSHLines writes a cf_ssh point per space when ssh settings were collected
+func influxSSHLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !result.SSHCollected {
+		return nil
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		if space.SSHAllowed == nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("cf_ssh,foundation=%s,org=%s,space=%s allowed=%di,apps=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			boolInt(*space.SSHAllowed), len(space.SSHApps),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxUserProvidedLines writes a cf_user_provided_services point per space when the
+//user provided services were collected
+func influxUserProvidedLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !contains(result.Collectors, "user-provided-services") {
+		return nil
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		summary := cfclient.SummarizeUserProvided(space)
+		lines = append(lines, fmt.Sprintf("cf_user_provided_services,foundation=%s,org=%s,space=%s instances=%di,syslog_drains=%di,route_services=%di,syslog_drain_apps=%di,route_service_apps=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			summary.Instances, summary.SyslogDrains, summary.RouteServices, summary.DrainedApps, summary.RouteServiceApps,
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxEnvLines writes a cf_env point per space when env var names were scanned
+func influxEnvLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !result.EnvScanned {
+		return nil
+	}
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		apps, suspicious := cfclient.SuspiciousEnvApps(space)
+		lines = append(lines, fmt.Sprintf("cf_env,foundation=%s,org=%s,space=%s env_vars=%di,suspicious_env_vars=%di,suspicious_env_apps=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			envVarCount(space), suspicious, apps,
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxPlatformLines writes a cf_platform point tagged with the api version and build, and a
+//cf_feature_flag point per flag with enabled as 1 or 0
+func influxPlatformLines(foundation string, platform cfclient.Platform, timestamp time.Time) []string {
+	if platform.Info.APIVersion == "" && len(platform.FeatureFlags) == 0 {
+		return nil
+	}
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	lines := []string{fmt.Sprintf("cf_platform,foundation=%s,api_version=%s,build=%s feature_flags_enabled=%di %d",
+		foundationTag,
+		influxTagEscaper.Replace(tagValue(platform.Info.APIVersion)),
+		influxTagEscaper.Replace(tagValue(platform.Info.Build)),
+		enabledFlags(platform),
+		timestamp.UnixNano())}
+	for _, flag := range platform.FeatureFlags {
+		lines = append(lines, fmt.Sprintf("cf_feature_flag,foundation=%s,flag=%s enabled=%di %d",
+			foundationTag,
+			influxTagEscaper.Replace(tagValue(flag.Name)),
+			boolInt(flag.Enabled),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//enabledFlags counts the platform's feature flags that are on
+func enabledFlags(platform cfclient.Platform) int {
+	enabled := 0
+	for _, flag := range platform.FeatureFlags {
+		enabled += boolInt(flag.Enabled)
+	}
+	return enabled
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//influxSecurityGroupLines writes a cf_security_group point per group with its rules, wide
+//open rules and the spaces it's bound to
+func influxSecurityGroupLines(foundation string, groups []cfclient.SecurityGroup, timestamp time.Time) []string {
+	var lines []string
+	for _, group := range groups {
+		lines = append(lines, fmt.Sprintf("cf_security_group,foundation=%s,group=%s,running_default=%t,staging_default=%t rules=%di,wide_open_rules=%di,running_spaces=%di,staging_spaces=%di %d",
+			influxTagEscaper.Replace(tagValue(foundation)),
+			influxTagEscaper.Replace(tagValue(group.Name)),
+			group.RunningDefault, group.StagingDefault,
+			len(group.Rules), len(group.WideOpenRules()), len(group.RunningSpaceGUIDs), len(group.StagingSpaceGUIDs),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//influxCatalogLines writes a cf_catalog_plan point per plan in the marketplace, the unused
+//ones too, and a cf_service_catalog point with the totals
+func influxCatalogLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	if !contains(result.Collectors, "catalog") {
+		return nil
+	}
+	catalog := result.ServiceCatalog
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	var lines []string
+	for _, plan := range catalog.Plans {
+		lines = append(lines, fmt.Sprintf("cf_catalog_plan,foundation=%s,broker=%s,service=%s,plan=%s,public=%t,active=%t,free=%t instances=%di %d",
+			foundationTag,
+			influxTagEscaper.Replace(tagValue(plan.BrokerName)),
+			influxTagEscaper.Replace(tagValue(plan.ServiceName)),
+			influxTagEscaper.Replace(tagValue(plan.Name)),
+			plan.Public, plan.Active, plan.Free, plan.Instances,
+			timestamp.UnixNano()))
+	}
+	lines = append(lines, fmt.Sprintf("cf_service_catalog,foundation=%s plans=%di,public_plans=%di,unused_plans=%di,service_brokers=%di %d",
+		foundationTag, len(catalog.Plans), len(catalog.PublicPlans()), len(catalog.UnusedPlans()), len(catalog.Brokers), timestamp.UnixNano()))
+	return lines
+}
+
+//influxEventLines writes a cf_events point for every audit event type seen in each org and
+//space, whichever types -event-types collected
+func influxEventLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		counts := eventCounts(org.Events)
+		for _, eventType := range sortedKeys(counts) {
+			lines = append(lines, fmt.Sprintf("cf_events,foundation=%s,org=%s,type=%s count=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(org.Name)),
+				influxTagEscaper.Replace(eventType),
+				counts[eventType],
+				timestamp.UnixNano()))
+		}
+	}
+	for _, space := range result.Spaces {
+		counts := eventCounts(space.Events)
+		for _, eventType := range sortedKeys(counts) {
+			lines = append(lines, fmt.Sprintf("cf_events,foundation=%s,org=%s,space=%s,type=%s count=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				influxTagEscaper.Replace(eventType),
+				counts[eventType],
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//influxRoleLines writes a cf_role point for every role in each org and space, when roles
+//were counted
+func influxRoleLines(foundation string, result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		for _, role := range sortedKeys(org.RoleCounts) {
+			lines = append(lines, fmt.Sprintf("cf_role,foundation=%s,org=%s,role=%s users=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(org.Name)),
+				role,
+				org.RoleCounts[role],
+				timestamp.UnixNano()))
+		}
+	}
+	for _, space := range result.Spaces {
+		for _, role := range sortedKeys(space.RoleCounts) {
+			lines = append(lines, fmt.Sprintf("cf_role,foundation=%s,org=%s,space=%s,role=%s users=%di %d",
+				foundationTag,
+				influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+				influxTagEscaper.Replace(tagValue(space.Name)),
+				role,
+				space.RoleCounts[role],
+				timestamp.UnixNano()))
+		}
+	}
+	return lines
+}
+
+//writeInstances writes what the nozzle heard to the cf_app_instance measurement, a point per
+//app instance with its gauges as float fields and its counters as integer fields
+func (influx influxSink) writeInstances(samples []instanceSample, timestamp time.Time) error {
+	foundationTag := influxTagEscaper.Replace(tagValue(influx.foundation))
+	var lines []string
+	for _, sample := range samples {
+		var fields []string
+		for _, name := range sortedKeys(sample.gauges) {
+			fields = append(fields, fmt.Sprintf("%s=%s", influxTagEscaper.Replace(name), strconv.FormatFloat(sample.gauges[name], 'f', -1, 64)))
+		}
+		for _, name := range sortedKeys(sample.counters) {
+			fields = append(fields, fmt.Sprintf("%s=%di", influxTagEscaper.Replace(name), sample.counters[name]))
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("cf_app_instance,foundation=%s,org=%s,space=%s,app=%s,app_guid=%s,instance=%s %s %d",
+			foundationTag,
+			influxTagEscaper.Replace(tagValue(sample.org)),
+			influxTagEscaper.Replace(tagValue(sample.space)),
+			influxTagEscaper.Replace(tagValue(sample.app)),
+			sample.appGUID,
+			tagValue(sample.instance),
+			strings.Join(fields, ","),
+			timestamp.UnixNano()))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return writeInflux(influx, lines)
+}
+
+//influxLines formats the collected data as influxdb line protocol. spaces are written to the
+//cf_space measurement and orgs to cf_org, both tagged by foundation and name
+func influxLines(foundation string, orgs []cfclient.Data, spaces []cfclient.Data, timestamp time.Time) []string {
+	var lines []string
+	foundationTag := influxTagEscaper.Replace(tagValue(foundation))
+	orgNames := map[string]string{}
+	for _, org := range orgs {
+		orgNames[org.GUID] = org.Name
+		instances, memory := cfclient.AppTotals(org.Apps)
+		lines = append(lines, fmt.Sprintf("cf_org,foundation=%s,org=%s%s apps=%di,instances=%di,reserved_memory_mb=%di,reserved_disk_mb=%di,running_instances=%di,running_memory_mb=%di,app_creates=%di,app_starts=%di,app_updates=%di,space_creates=%di,app_crashes=%di %d",
+			foundationTag,
+			influxTagEscaper.Replace(tagValue(org.Name)),
+			influxLabelTags(org.Labels),
+			len(org.Apps), instances, memory, org.Usage.ReservedDiskMB, org.Usage.RunningInstances, org.Usage.RunningMemoryMB,
+			len(org.AppCreates), len(org.AppStarts), len(org.AppUpdates), len(org.SpaceCreates), len(org.AppCrashes),
+			timestamp.UnixNano()))
+	}
+	for _, space := range spaces {
+		instances, memory := cfclient.AppTotals(space.Apps)
+		lines = append(lines, fmt.Sprintf("cf_space,foundation=%s,org=%s,space=%s%s apps=%di,instances=%di,reserved_memory_mb=%di,reserved_disk_mb=%di,running_instances=%di,running_memory_mb=%di,app_creates=%di,app_starts=%di,app_updates=%di,service_bindings=%di,app_crashes=%di,routes=%di,orphaned_routes=%di %d",
+			foundationTag,
+			influxTagEscaper.Replace(tagValue(orgNames[space.OrganizationGUID])),
+			influxTagEscaper.Replace(tagValue(space.Name)),
+			influxLabelTags(space.Labels),
+			len(space.Apps), instances, memory, space.Usage.ReservedDiskMB, space.Usage.RunningInstances, space.Usage.RunningMemoryMB,
+			len(space.AppCreates), len(space.AppStarts), len(space.AppUpdates), serviceBindings(space), len(space.AppCrashes),
+			len(space.Routes), len(cfclient.OrphanedRoutes(space.Routes)),
+			timestamp.UnixNano()))
+	}
+	return lines
+}
+
+//serviceBindings counts the bindings across a space's service instances
+func serviceBindings(space cfclient.Data) int {
+	bindings := 0
+	for _, instance := range space.ServiceInstances {
+		bindings += instance.Bindings
+	}
+	return bindings
+}
+
+//sortedKeys is a sample's gauge or counter names, or a count's keys, in order, so points
+//come out the same way every time
+func sortedKeys[V any](values map[string]V) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//influxLabelTags are an org's or space's -label-tags labels as label_<key> tags, sorted the
+//way influx wants them
+func influxLabelTags(labels map[string]string) string {
+	tags := ""
+	for _, key := range sortedKeys(labels) {
+		tags += ",label_" + influxTagEscaper.Replace(key) + "=" + influxTagEscaper.Replace(tagValue(labels[key]))
+	}
+	return tags
+}
+
+//tagValue fills in empty tags, influx won't accept a tag without a value
+func tagValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+//writeInflux posts the lines to influxdb, or prints them when the address is "-"
+func writeInflux(influx influxSink, lines []string) error {
+	var relabeled []string
+	for _, line := range lines {
+		if line, keep := metricRules.relabelInfluxLine(line); keep {
+			relabeled = append(relabeled, line)
+		}
+	}
+	if len(relabeled) == 0 {
+		return nil
+	}
+	payload := strings.Join(relabeled, "\n") + "\n"
+	if influx.address == "-" {
+		_, err := fmt.Fprint(os.Stdout, payload)
+		return err
+	}
+
+	writeURL := strings.TrimSuffix(influx.address, "/") + "/write?db=" + url.QueryEscape(influx.database) + "&precision=ns"
+	if influx.bucket != "" {
+		writeURL = strings.TrimSuffix(influx.address, "/") + "/api/v2/write?org=" + url.QueryEscape(influx.org) + "&bucket=" + url.QueryEscape(influx.bucket) + "&precision=ns"
+	}
+	req, err := http.NewRequest("POST", writeURL, bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if influx.token != "" {
+		req.Header.Set("Authorization", "Token "+influx.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influxdb returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
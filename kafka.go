@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//kafkaBatchBytes keeps record batches well under kafka's default 1MB message.max.bytes
+const kafkaBatchBytes = 512 * 1024
+
+var kafkaMessageKinds = []string{"snapshot", "points"}
+
+//kafkaSink publishes every run to a topic as json, either one message with the whole
+//snapshot (what -output json prints, plus a timestamp) keyed by the foundation, or one
+//message per metric point keyed by foundation, org and space
+type kafkaSink struct {
+	brokers    []string
+	topic      string
+	messages   string
+	auth       kafkaAuth
+	foundation string
+}
+
+//kafkaPoint is a points message, same as a row of the postgres sink
+type kafkaPoint struct {
+	Foundation string    `json:"foundation"`
+	Timestamp  time.Time `json:"timestamp"`
+	Org        string    `json:"org,omitempty"`
+	Space      string    `json:"space,omitempty"`
+	Metric     string    `json:"metric"`
+	Value      float64   `json:"value"`
+	//Tags are the point's other tags, e.g. event_type or isolation_segment
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func (kafka kafkaSink) name() string {
+	return "kafka"
+}
+
+func (kafka kafkaSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	records, err := kafka.records(result, timestamp)
+	if err != nil {
+		return err
+	}
+
+	bootstrap, brokers, partitions, err := kafka.connect()
+	if err != nil {
+		return err
+	}
+	defer bootstrap.close()
+
+	byLeader := map[int32]map[int32][]kafkaRecord{}
+	for _, record := range records {
+		partition := partitions[kafkaPartitionFor(record.key, len(partitions))]
+		if partition.leader < 0 {
+			return fmt.Errorf("partition %d of %s has no leader", partition.id, kafka.topic)
+		}
+		if byLeader[partition.leader] == nil {
+			byLeader[partition.leader] = map[int32][]kafkaRecord{}
+		}
+		byLeader[partition.leader][partition.id] = append(byLeader[partition.leader][partition.id], record)
+	}
+	for leader, batches := range byLeader {
+		broker, known := brokers[leader]
+		if !known {
+			return fmt.Errorf("kafka named broker %d as a leader of %s but not among its brokers", leader, kafka.topic)
+		}
+		conn, err := dialKafka(broker.address, kafka.auth, time.Minute)
+		if err != nil {
+			return err
+		}
+		err = produceInBatches(conn, kafka.topic, batches, timestamp)
+		conn.close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//connect asks the first broker that answers for the topic's partitions, in order. a topic
+//kafka is still creating has no leaders for a moment, so that's retried a few times
+func (kafka kafkaSink) connect() (*kafkaConn, map[int32]kafkaBroker, []kafkaPartition, error) {
+	var lastErr error
+	for _, address := range kafka.brokers {
+		conn, err := dialKafka(address, kafka.auth, time.Minute)
+		if err != nil {
+			slog.Debug("kafka broker didn't answer", "broker", address, "error", err)
+			lastErr = err
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			brokers, partitions, err := conn.metadata(kafka.topic)
+			if errors.Is(err, kafkaError(5)) && attempt < 5 {
+				time.Sleep(time.Second)
+				continue
+			}
+			if err == nil && len(partitions) == 0 {
+				err = fmt.Errorf("kafka has no partitions for %s", kafka.topic)
+			}
+			if err != nil {
+				conn.close()
+				return nil, nil, nil, fmt.Errorf("error getting the metadata of %s: %s", kafka.topic, err)
+			}
+			sort.Slice(partitions, func(i, j int) bool {
+				return partitions[i].id < partitions[j].id
+			})
+			return conn, brokers, partitions, nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("none of the kafka brokers answered, the last said %s", lastErr)
+}
+
+//produceInBatches splits each partition's records into batches of at most kafkaBatchBytes
+//and produces them a batch per partition at a time
+func produceInBatches(conn *kafkaConn, topic string, batches map[int32][]kafkaRecord, timestamp time.Time) error {
+	for len(batches) > 0 {
+		request := map[int32][]kafkaRecord{}
+		for partition, records := range batches {
+			size, count := 0, 0
+			for count < len(records) && (count == 0 || size+len(records[count].key)+len(records[count].value) <= kafkaBatchBytes) {
+				size += len(records[count].key) + len(records[count].value)
+				count++
+			}
+			request[partition] = records[:count]
+			if count == len(records) {
+				delete(batches, partition)
+			} else {
+				batches[partition] = records[count:]
+			}
+		}
+		err := conn.produce(topic, request, timestamp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kafka kafkaSink) records(result cfclient.CollectionResult, timestamp time.Time) ([]kafkaRecord, error) {
+	if kafka.messages == "snapshot" {
+		value, err := json.Marshal(newTimedReport(kafka.foundation, result, timestamp))
+		if err != nil {
+			return nil, err
+		}
+		return []kafkaRecord{{key: []byte(kafka.foundation), value: value}}, nil
+	}
+
+	var records []kafkaRecord
+	for _, point := range metricPoints(kafka.foundation, result, timestamp) {
+		message := kafkaPoint{
+			Foundation: kafka.foundation,
+			Timestamp:  point.timestamp,
+			Org:        point.tags["org"],
+			Space:      point.tags["space"],
+			Metric:     point.flatName(),
+			Value:      point.value,
+		}
+		for _, dimension := range point.dimensions() {
+			if message.Tags == nil {
+				message.Tags = map[string]string{}
+			}
+			message.Tags[dimension[0]] = dimension[1]
+		}
+		value, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, kafkaRecord{key: []byte(kafka.foundation + "/" + message.Org + "/" + message.Space), value: value})
+	}
+	return records, nil
+}
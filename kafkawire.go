@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+//kafka api keys and the versions of them spoken here. produce v3 and metadata v1 are the
+//first with record batches, so this needs kafka 0.11 or later, and sasl authenticate 1.0
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+)
+
+var kafkaSaslMechanisms = []string{"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512"}
+
+//kafkaErrors names the error codes a producer is likely to see
+var kafkaErrors = map[int16]string{
+	2:  "corrupt message",
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	19: "not enough replicas",
+	29: "topic authorization failed",
+	33: "unsupported sasl mechanism",
+	58: "sasl authentication failed",
+}
+
+type kafkaError int16
+
+func (code kafkaError) Error() string {
+	if name, known := kafkaErrors[int16(code)]; known {
+		return "kafka error: " + name
+	}
+	return fmt.Sprintf("kafka error code %d", int16(code))
+}
+
+//kafkaAuth is how to connect to every broker
+type kafkaAuth struct {
+	tls           bool
+	saslMechanism string
+	username      string
+	password      string
+}
+
+//kafkaConn is just enough of the kafka protocol for the kafka sink: tls, sasl plain and
+//scram, metadata and produce. there's no client vendored, same as for postgres
+type kafkaConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	correlation int32
+}
+
+//kafkaBroker is a broker out of a metadata response
+type kafkaBroker struct {
+	id      int32
+	address string
+}
+
+//kafkaPartition is a topic partition and the broker that leads it, -1 when there's no leader
+type kafkaPartition struct {
+	id     int32
+	leader int32
+}
+
+//kafkaRecord is one message to produce
+type kafkaRecord struct {
+	key   []byte
+	value []byte
+}
+
+func dialKafka(address string, auth kafkaAuth, timeout time.Duration) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if auth.tls {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake with kafka broker %s failed: %s", address, err)
+		}
+		conn = tlsConn
+	}
+	kafka := &kafkaConn{conn: conn, reader: bufio.NewReader(conn)}
+	if auth.saslMechanism != "" {
+		err = kafka.authenticate(auth)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error authenticating with kafka broker %s: %s", address, err)
+		}
+	}
+	return kafka, nil
+}
+
+func (kafka *kafkaConn) close() error {
+	return kafka.conn.Close()
+}
+
+//authenticate does a sasl handshake and then however many sasl authenticate round trips the
+//mechanism takes
+func (kafka *kafkaConn) authenticate(auth kafkaAuth) error {
+	var request kafkaEncoder
+	request.string(auth.saslMechanism)
+	response, err := kafka.roundTrip(kafkaSaslHandshake, 1, request)
+	if err != nil {
+		return err
+	}
+	if code := response.int16(); code != 0 {
+		return kafkaError(code)
+	}
+
+	var messages []func(challenge []byte) ([]byte, error)
+	switch auth.saslMechanism {
+	case "PLAIN":
+		messages = append(messages, func([]byte) ([]byte, error) {
+			return []byte("\x00" + auth.username + "\x00" + auth.password), nil
+		})
+	default:
+		hash := sha256.New
+		if auth.saslMechanism == "SCRAM-SHA-512" {
+			hash = sha512.New
+		}
+		scram, err := newScramClient(auth.username, auth.password, hash)
+		if err != nil {
+			return err
+		}
+		messages = append(messages,
+			func([]byte) ([]byte, error) {
+				return []byte(scram.clientFirst()), nil
+			},
+			func(serverFirst []byte) ([]byte, error) {
+				final, err := scram.clientFinal(string(serverFirst))
+				return []byte(final), err
+			},
+			func(serverFinal []byte) ([]byte, error) {
+				return nil, scram.verifyServer(string(serverFinal))
+			})
+	}
+
+	var challenge []byte
+	for _, message := range messages {
+		answer, err := message(challenge)
+		if err != nil || answer == nil {
+			return err
+		}
+		var request kafkaEncoder
+		request.bytes(answer)
+		response, err := kafka.roundTrip(kafkaSaslAuthenticate, 0, request)
+		if err != nil {
+			return err
+		}
+		code, errorMessage := response.int16(), response.nullableString()
+		if code != 0 {
+			return fmt.Errorf("%s: %s", kafkaError(code), errorMessage)
+		}
+		challenge = response.bytes()
+		if response.err != nil {
+			return response.err
+		}
+	}
+	return nil
+}
+
+//metadata is the brokers and the topic's partitions
+func (kafka *kafkaConn) metadata(topic string) (map[int32]kafkaBroker, []kafkaPartition, error) {
+	var request kafkaEncoder
+	request.int32(1)
+	request.string(topic)
+	response, err := kafka.roundTrip(kafkaMetadata, 1, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	brokers := map[int32]kafkaBroker{}
+	for count := response.int32(); count > 0 && response.err == nil; count-- {
+		id, host, port := response.int32(), response.string(), response.int32()
+		response.nullableString() //rack
+		brokers[id] = kafkaBroker{id: id, address: net.JoinHostPort(host, strconv.Itoa(int(port)))}
+	}
+	response.int32() //controller
+	var partitions []kafkaPartition
+	var topicErr error
+	for count := response.int32(); count > 0 && response.err == nil; count-- {
+		if code := response.int16(); code != 0 {
+			topicErr = kafkaError(code)
+		}
+		response.string()
+		response.int8() //is internal
+		for partitionCount := response.int32(); partitionCount > 0 && response.err == nil; partitionCount-- {
+			response.int16()
+			partition := kafkaPartition{id: response.int32(), leader: response.int32()}
+			for replicas := response.int32(); replicas > 0; replicas-- {
+				response.int32()
+			}
+			for inSync := response.int32(); inSync > 0; inSync-- {
+				response.int32()
+			}
+			partitions = append(partitions, partition)
+		}
+	}
+	if response.err != nil {
+		return nil, nil, response.err
+	}
+	if topicErr != nil {
+		return nil, nil, topicErr
+	}
+	return brokers, partitions, nil
+}
+
+//produce writes each partition's records as one batch and waits for every in sync replica
+//to have them
+func (kafka *kafkaConn) produce(topic string, batches map[int32][]kafkaRecord, timestamp time.Time) error {
+	var request kafkaEncoder
+	request.int16(-1) //no transactional id
+	request.int16(-1) //acks from all in sync replicas
+	request.int32(30000)
+	request.int32(1)
+	request.string(topic)
+	request.int32(int32(len(batches)))
+	for partition, records := range batches {
+		request.int32(partition)
+		request.bytes(kafkaRecordBatch(records, timestamp))
+	}
+	response, err := kafka.roundTrip(kafkaProduce, 3, request)
+	if err != nil {
+		return err
+	}
+	for count := response.int32(); count > 0 && response.err == nil; count-- {
+		response.string()
+		for partitionCount := response.int32(); partitionCount > 0 && response.err == nil; partitionCount-- {
+			partition, code := response.int32(), response.int16()
+			response.int64() //base offset
+			response.int64() //log append time
+			if code != 0 {
+				return fmt.Errorf("error producing to partition %d of %s: %s", partition, topic, kafkaError(code))
+			}
+		}
+	}
+	return response.err
+}
+
+//roundTrip sends a request with a v1 header and reads its response
+func (kafka *kafkaConn) roundTrip(apiKey int16, version int16, body kafkaEncoder) (*kafkaDecoder, error) {
+	kafka.correlation++
+	var header kafkaEncoder
+	header.int16(apiKey)
+	header.int16(version)
+	header.int32(kafka.correlation)
+	header.string("cf-metrics")
+	message := binary.BigEndian.AppendUint32(nil, uint32(len(header)+len(body)))
+	message = append(append(message, header...), body...)
+	_, err := kafka.conn.Write(message)
+	if err != nil {
+		return nil, err
+	}
+
+	size := make([]byte, 4)
+	_, err = io.ReadFull(kafka.reader, size)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint32(size))
+	_, err = io.ReadFull(kafka.reader, response)
+	if err != nil {
+		return nil, err
+	}
+	decoder := &kafkaDecoder{data: response}
+	if correlation := decoder.int32(); correlation != kafka.correlation {
+		return nil, fmt.Errorf("kafka answered request %d with %d", kafka.correlation, correlation)
+	}
+	return decoder, nil
+}
+
+//kafkaRecordBatch is a v2 record batch, uncompressed and without a producer id
+func kafkaRecordBatch(records []kafkaRecord, timestamp time.Time) []byte {
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, 0) //attributes
+	body = binary.BigEndian.AppendUint32(body, uint32(len(records)-1))
+	body = binary.BigEndian.AppendUint64(body, uint64(timestamp.UnixMilli()))
+	body = binary.BigEndian.AppendUint64(body, uint64(timestamp.UnixMilli()))
+	body = binary.BigEndian.AppendUint64(body, ^uint64(0)) //producer id -1
+	body = binary.BigEndian.AppendUint16(body, ^uint16(0)) //producer epoch -1
+	body = binary.BigEndian.AppendUint32(body, ^uint32(0)) //base sequence -1
+	body = binary.BigEndian.AppendUint32(body, uint32(len(records)))
+	for offset, record := range records {
+		var encoded []byte
+		encoded = append(encoded, 0)                          //attributes
+		encoded = binary.AppendVarint(encoded, 0)             //timestamp delta
+		encoded = binary.AppendVarint(encoded, int64(offset)) //offset delta
+		encoded = binary.AppendVarint(encoded, int64(len(record.key)))
+		encoded = append(encoded, record.key...)
+		encoded = binary.AppendVarint(encoded, int64(len(record.value)))
+		encoded = append(encoded, record.value...)
+		encoded = binary.AppendVarint(encoded, 0) //headers
+		body = binary.AppendVarint(body, int64(len(encoded)))
+		body = append(body, encoded...)
+	}
+
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, 0) //base offset
+	batch = binary.BigEndian.AppendUint32(batch, uint32(4+1+4+len(body)))
+	batch = binary.BigEndian.AppendUint32(batch, ^uint32(0)) //partition leader epoch -1
+	batch = append(batch, 2)                                 //magic
+	batch = binary.BigEndian.AppendUint32(batch, crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)))
+	return append(batch, body...)
+}
+
+//kafkaPartitionFor is the partition the java client's default partitioner picks for a key,
+//the positive murmur2 hash of it modulo the partitions, so consumers see the same keys in
+//the same partitions whoever produced them
+func kafkaPartitionFor(key []byte, partitions int) int {
+	const seed, m = 0x9747b28c, 0x5bd1e995
+	h := uint32(seed) ^ uint32(len(key))
+	for i := 0; i+4 <= len(key); i += 4 {
+		k := binary.LittleEndian.Uint32(key[i : i+4])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := key[len(key)&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int(h&0x7fffffff) % partitions
+}
+
+//kafkaEncoder writes kafka's big endian primitives
+type kafkaEncoder []byte
+
+func (e *kafkaEncoder) int16(value int16) {
+	*e = binary.BigEndian.AppendUint16(*e, uint16(value))
+}
+
+func (e *kafkaEncoder) int32(value int32) {
+	*e = binary.BigEndian.AppendUint32(*e, uint32(value))
+}
+
+func (e *kafkaEncoder) string(value string) {
+	e.int16(int16(len(value)))
+	*e = append(*e, value...)
+}
+
+func (e *kafkaEncoder) bytes(value []byte) {
+	e.int32(int32(len(value)))
+	*e = append(*e, value...)
+}
+
+//kafkaDecoder reads kafka's primitives, remembering the first time it ran out of data so
+//callers only check once
+type kafkaDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil || n < 0 || len(d.data) < n {
+		if d.err == nil {
+			d.err = fmt.Errorf("kafka sent a response shorter than it should be")
+		}
+		return make([]byte, max(n, 8))
+	}
+	taken := d.data[:n]
+	d.data = d.data[n:]
+	return taken
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	return int8(d.take(1)[0])
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	return int16(binary.BigEndian.Uint16(d.take(2)))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	return int32(binary.BigEndian.Uint32(d.take(4)))
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	return int64(binary.BigEndian.Uint64(d.take(8)))
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.take(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() string {
+	length := d.int16()
+	if length < 0 {
+		return ""
+	}
+	return string(d.take(int(length)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	length := d.int32()
+	if length < 0 {
+		return nil
+	}
+	return d.take(int(length))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKafkaProduceRequestBytes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	kafka := &kafkaConn{conn: client, reader: bufio.NewReader(client)}
+
+	//worked out by hand from the kafka protocol guide, the crc with a python crc32c
+	want, _ := hex.DecodeString("" +
+		//size, then the v1 header: produce = 0, version 3, correlation id 1, client id
+		"0000007c" + "0000" + "0003" + "00000001" + "000a" + hex.EncodeToString([]byte("cf-metrics")) +
+		//no transactional id, acks -1, 30s timeout, one topic "runs" with one partition, 0
+		"ffff" + "ffff" + "00007530" + "00000001" + "0004" + hex.EncodeToString([]byte("runs")) + "00000001" + "00000000" +
+		//the 74 byte record batch: base offset, length, leader epoch -1, magic 2, crc32c
+		"0000004a" + "0000000000000000" + "0000003e" + "ffffffff" + "02" + "c3c30f07" +
+		//attributes, last offset delta, first and max timestamp, no producer id, epoch or
+		//sequence, one record
+		"0000" + "00000000" + "0000018bcfe56800" + "0000018bcfe56800" + "ffffffffffffffff" + "ffff" + "ffffffff" + "00000001" +
+		//the record's zigzag varint length, attributes, timestamp and offset deltas, key
+		//"prod", value "{}" and no headers
+		"18" + "00" + "00" + "00" + "08" + hex.EncodeToString([]byte("prod")) + "04" + hex.EncodeToString([]byte("{}")) + "00")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- kafka.produce("runs", map[int32][]kafkaRecord{0: {{key: []byte("prod"), value: []byte("{}")}}}, time.UnixMilli(1700000000000))
+	}()
+	got := make([]byte, len(want))
+	_, err := io.ReadFull(server, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the produce request should be\n% x\ngot\n% x", want, got)
+	}
+
+	//correlation id 1, topic "runs" with partition 0 written without an error
+	var response kafkaEncoder
+	response.int32(1)
+	response.int32(1)
+	response.string("runs")
+	response.int32(1)
+	response.int32(0)
+	response.int16(0)
+	response = binary.BigEndian.AppendUint64(response, 0)
+	response = binary.BigEndian.AppendUint64(response, ^uint64(0))
+	response = append(binary.BigEndian.AppendUint32(nil, uint32(len(response))), response...)
+	_, err = server.Write(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+//newLogger is a text logger on stderr at the named level
+func newLogger(level string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	err := slogLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("unknown -log-level %s, use debug, info, warn or error", level)
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slogLevel})), nil
+}
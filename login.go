@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//loginCredentials are what runLogin authenticates with, whatever the flags and environment
+//didn't give it is asked for on the terminal
+type loginCredentials struct {
+	username string
+	password string
+	passcode string
+	//sso logs in with a passcode instead of a username and password
+	sso bool
+	//clientID saves the secret of this uaa client in the keyring instead of logging a user in
+	clientID     string
+	clientSecret string
+	//keyring keeps the refresh token in the os keyring instead of the file
+	keyring bool
+}
+
+//stdinIsTerminal is whether there's someone at stdin to ask for what's missing
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+//prompt asks for a line on stderr and reads it from in. secret ones aren't echoed, as long as
+//stty is around to turn echo off
+func prompt(in *bufio.Reader, question string, secret bool) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	if secret {
+		quiet := exec.Command("stty", "-echo")
+		quiet.Stdin = os.Stdin
+		if quiet.Run() == nil {
+			defer func() {
+				loud := exec.Command("stty", "echo")
+				loud.Stdin = os.Stdin
+				loud.Run()
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+//fill asks for whatever the credentials are missing, or says what's missing when there's no
+//terminal to ask on
+func (credentials *loginCredentials) fill(login *cfclient.Login) error {
+	if credentials.clientID != "" {
+		if credentials.clientSecret != "" {
+			return nil
+		}
+		if !stdinIsTerminal() {
+			return fmt.Errorf("no secret for -client-id, set $CF_CLIENT_SECRET")
+		}
+		var err error
+		credentials.clientSecret, err = prompt(bufio.NewReader(os.Stdin), "client secret: ", true)
+		return err
+	}
+	missing := (credentials.sso && credentials.passcode == "") || (!credentials.sso && (credentials.username == "" || credentials.password == ""))
+	if !missing {
+		return nil
+	}
+	if !stdinIsTerminal() {
+		if credentials.sso {
+			return fmt.Errorf("no -passcode, get one from %s", login.PasscodeURL())
+		}
+		return fmt.Errorf("login needs -username and -password (or $CF_USERNAME and $CF_PASSWORD), or -sso")
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	var err error
+	if credentials.sso {
+		fmt.Fprintf(os.Stderr, "get a one time passcode from %s\n", login.PasscodeURL())
+		credentials.passcode, err = prompt(in, "passcode: ", true)
+		return err
+	}
+	if credentials.username == "" {
+		credentials.username, err = prompt(in, "username: ", false)
+		if err != nil {
+			return err
+		}
+	}
+	if credentials.password == "" {
+		credentials.password, err = prompt(in, "password: ", true)
+	}
+	return err
+}
+
+//runLogin logs in to the foundation and saves the tokens where the client looks for them
+//when there's no cf cli config. with a client id it checks the client's secret and keeps it
+//in the keyring for -keyring to find instead
+func runLogin(ctx context.Context, config cfclient.LoginConfig, credentials loginCredentials) error {
+	if credentials.clientID != "" && !credentials.keyring {
+		return fmt.Errorf("-client-id only saves the client secret in the keyring, it needs -keyring")
+	}
+	login, err := cfclient.NewLogin(ctx, config)
+	if err != nil {
+		return err
+	}
+	err = credentials.fill(login)
+	if err != nil {
+		return err
+	}
+	if credentials.clientID != "" {
+		err = login.CheckClientCredentials(ctx, credentials.clientID, credentials.clientSecret)
+		if err != nil {
+			return err
+		}
+		err = cfclient.SaveClientSecret(login.Target(), credentials.clientID, credentials.clientSecret)
+		if err != nil {
+			return fmt.Errorf("error saving the client secret in the keyring: %s", err)
+		}
+		slog.Info("saved the client secret in the keyring", "api", login.Target(), "client_id", credentials.clientID)
+		return nil
+	}
+	if credentials.sso {
+		credentials.username = ""
+	}
+	saved, err := login.Authenticate(ctx, credentials.username, credentials.password, credentials.passcode)
+	if err != nil {
+		return err
+	}
+	err = cfclient.SaveLoginConfig(saved, credentials.keyring)
+	if err != nil {
+		return fmt.Errorf("error saving the login to %s: %s", cfclient.LoginConfigPath(), err)
+	}
+	slog.Info("logged in", "api", saved.Target, "uaa", saved.UAAEndpoint, "saved_to", cfclient.LoginConfigPath())
+	return nil
+}
@@ -1,65 +1,190 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/gosuri/uiprogress"
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
 	ansi "github.com/jhunt/go-ansi"
 )
 
-type cfAPIResponse struct {
-	TotalResults int             `json:"total_results"`
-	TotalPages   int             `json:"total_pages"`
-	PrevURL      string          `json:"prev_url"`
-	NextURL      string          `json:"next_url"`
-	Resources    []cfAPIResource `json:"resources"`
-}
+//routeRatioThreshold is how many routes per app a space can have before we call it out
+const routeRatioThreshold = 5
+
+//orgManagerThreshold is how many org managers an org can have before we call it out
+const orgManagerThreshold = 10
+
+//exit codes, so cron can tell a run that got everything from one that got some of it
+//(exitPartial, see partialError) and one that got nothing or couldn't start
+const (
+	exitSuccess = 0
+	exitPartial = 1
+	exitFailure = 2
+)
 
 func main() {
-	var client Client
-	err := client.setup()
-	if err != nil {
-		bailWith("err setting up client: %s", err)
+	args := os.Args[1:]
+	//when the cf cli started us as `cf metrics`, the command and flags come after its own
+	cli, pluginArgs, isPlugin := pluginInvocation(os.Args)
+	if isPlugin {
+		pluginCLI = &cli
+		args = runPluginCommand(cli, pluginArgs)
 	}
 
-	orgs, err := client.getOrgs()
+	cmd, args, err := findCommand(args)
 	if err != nil {
-		bailWith("error getting orgs: %s", err)
+		bailWith("%s", err)
+	}
+	err = cmd.run(args)
+	if partial(err) {
+		ansi.Fprintf(os.Stderr, "@Y{%s}\n", err)
+		os.Exit(exitPartial)
 	}
+	if err != nil {
+		bailWith("%s", err)
+	}
+	os.Exit(exitSuccess)
+}
 
-	//start up ui progress bars
-	uiprogress.Start()
+//partialError is a run that collected and wrote out what it could, but not everything: some
+//orgs, spaces or collectors failed, or some of the foundations or profiles did
+type partialError struct {
+	message string
+}
 
-	//associate app creates with orgs "/v2/events?q=type:audit.app.create&q=organization_guid:"
-	err = client.getEndpointData(orgs, FieldAppCreates, "/v2/events?q=type:audit.app.create&q=organization_guid:", "associating app creates with orgs")
-	if err != nil {
-		bailWith("error associating app creates with orgs: %s", err)
+func (err partialError) Error() string {
+	return err.message
+}
+
+//partial is whether err is only a partialError, or sinks that couldn't be written to
+func partial(err error) bool {
+	switch err.(type) {
+	case partialError, sinkError:
+		return true
 	}
+	return false
+}
 
-	//associate app starts with orgs
-	err = client.getEndpointData(orgs, FieldAppStarts, "/v2/events?q=type:audit.app.start&q=organization_guid:", "associating app starts with orgs")
-	if err != nil {
-		bailWith("error associating app starts with orgs: %s", err)
+//runError is err unless it only says the run didn't get everything, for the health checks
+func runError(err error) error {
+	if _, isPartial := err.(partialError); isPartial {
+		return nil
 	}
+	return err
+}
 
-	//associate app updates with orgs
-	err = client.getEndpointData(orgs, FieldAppUpdates, "/v2/events?q=type:audit.app.update&q=organization_guid:", "associating app updates with orgs")
-	if err != nil {
-		bailWith("error associating app updates with orgs: %s", err)
+//foundationRun is a foundation's client and where its collections get written
+type foundationRun struct {
+	client  *cfclient.Client
+	options outputOptions
+	//health gets how every run went, nil without health checks
+	health *collectorHealth
+	//breaker skips the foundation after repeated failures, nil for none
+	breaker *circuitBreaker
+}
+
+//collectAll runs collectAndWrite against every foundation at once and waits for them all.
+//one foundation failing doesn't stop the others
+func collectAll(ctx context.Context, runs []foundationRun) error {
+	if len(runs) == 1 {
+		err := collectAndWrite(ctx, runs[0].client, runs[0].options)
+		runs[0].health.record(runs[0].options.foundation, runError(err))
+		return err
+	}
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for index, run := range runs {
+		wg.Add(1)
+		go func(index int, run foundationRun) {
+			defer wg.Done()
+			if !run.breaker.allow(time.Now()) {
+				slog.Warn("skipping the foundation until its circuit breaker's cooldown is over", "foundation", run.options.foundation, "retry_at", run.breaker.status(time.Now()).RetryAt)
+				return
+			}
+			errs[index] = collectAndWrite(ctx, run.client, run.options)
+			run.breaker.record(collectionError(errs[index]), time.Now())
+			run.health.record(run.options.foundation, runError(errs[index]))
+		}(index, run)
 	}
+	wg.Wait()
 
-	//associate space creates with orgs
-	err = client.getEndpointData(orgs, FieldSpaceCreates, "/v2/events?q=type:audit.space.create&q=organization_guid:", "associating space creates with orgs")
-	if err != nil {
-		bailWith("error associating space creates with orgs: %s", err)
+	var failed, partly []string
+	for index, err := range errs {
+		if partial(err) {
+			slog.Warn("collection only partly done", "foundation", runs[index].options.foundation, "err", err)
+			partly = append(partly, runs[index].options.foundation)
+		} else if err != nil {
+			slog.Error("collection failed", "foundation", runs[index].options.foundation, "err", err)
+			failed = append(failed, runs[index].options.foundation)
+		}
 	}
+	if len(failed) == len(runs) {
+		return fmt.Errorf("collection failed for every foundation: %s", strings.Join(failed, ", "))
+	}
+	if len(failed) > 0 {
+		return partialError{fmt.Sprintf("collection failed for %d of %d foundations: %s", len(failed), len(runs), strings.Join(failed, ", "))}
+	}
+	if len(partly) > 0 {
+		return partialError{fmt.Sprintf("collection was only partly done for %d of %d foundations: %s", len(partly), len(runs), strings.Join(partly, ", "))}
+	}
+	return nil
+}
+
+//outputMutex serializes the writing out of collections from different foundations so
+//nothing going to stdout gets interleaved
+var outputMutex sync.Mutex
+
+//outputOptions is everything collectAndWrite needs from the flags
+type outputOptions struct {
+	//foundation is the name the run's output is tagged with
+	foundation string
+	//outputDir is where the org, space and foundation files go
+	outputDir  string
+	summaryCSV string
+	sinks      []sink
+	//format is the -output format, empty for none
+	format   string
+	appStats bool
+	//collectionTimeout is the deadline for a whole collection run, 0 for none
+	collectionTimeout time.Duration
+	//lastFull is the foundation's latest whole view, that narrowed runs are merged into
+	//before they go to the sinks. nil when nothing narrows runs
+	lastFull *fullRun
+}
+
+//fullRun is a foundation's last full run, with the narrowed runs since merged in. it's
+//only read and written under outputMutex
+type fullRun struct {
+	result *cfclient.CollectionResult
+}
+
+//collectionContext is ctx with the -collection-timeout deadline on it, if there is one
+func collectionContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
 
-	//associate apps with orgs
-	err = client.getEndpointData(orgs, FieldApps, "/v2/apps?q=organization_guid:", "associating apps with orgs")
+//collectAndWrite runs one collection, writes out the csvs and summary and pushes the counts
+//to every sink. nothing is written if ctx is cancelled or the collection times out. a sink
+//failing doesn't stop the rest, it's returned as a sinkError once everything else is done
+func collectAndWrite(ctx context.Context, client *cfclient.Client, options outputOptions) error {
+	ctx, cancel := collectionContext(ctx, options.collectionTimeout)
+	defer cancel()
+	result, err := client.Collect(ctx, options.appStats)
 	if err != nil {
-		bailWith("error associating apps with orgs: %s", err)
+		return err
 	}
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	log := slog.With("foundation", options.foundation)
+	orgs, spaces := result.Orgs, result.Spaces
 	//some app stuff for later?
 	// for index, org := range orgs {
 	// 	for index, app := range orgs[index].apps {
@@ -70,35 +195,38 @@ func main() {
 	//get all service bindings based on apps by org
 	//todo?
 
-	//grab all the spaces
-	spaces, err := client.getSpaces()
-	if err != nil {
-		bailWith("error getting spaces: %s", err)
+	for _, space := range spaces {
+		for guid, app := range space.AppInstanceStates {
+			if app.States["CRASHED"] > 0 {
+				log.Warn("app has crashed instances", "app", guid, "space", space.Name, "crashed", app.States["CRASHED"], "desired", app.Desired)
+			}
+		}
 	}
 
-	//associate app starts with spaces
-	err = client.getEndpointData(spaces, FieldAppStarts, "/v2/events?q=type:audit.app.start&q=space_guid:", "associating app starts with spaces")
-	if err != nil {
-		bailWith("error associating app starts with spaces: %s", err)
+	for _, space := range spaces {
+		for _, instance := range cfclient.StuckServiceInstances(space.ServiceInstances, time.Now()) {
+			since := instance.LastOperation.UpdatedAt
+			if !instance.InProgressSince.IsZero() {
+				since = instance.InProgressSince
+			}
+			log.Warn("service instance looks stuck", "instance", instance.Name, "space", space.Name, "operation", instance.LastOperation.Type, "state", instance.LastOperation.State, "since", since)
+		}
 	}
 
-	//associate app creates with spaces
-	err = client.getEndpointData(spaces, FieldAppCreates, "/v2/events?q=type:audit.app.create&q=space_guid:", "associating app creates with spaces")
-	if err != nil {
-		bailWith("error associating app creates with spaces: %s", err)
+	for _, org := range orgs {
+		if org.RoleCounts == nil {
+			continue
+		}
+		if org.RoleCounts["managers"] == 0 {
+			log.Warn("org has no managers", "org", org.Name)
+		} else if org.RoleCounts["managers"] > orgManagerThreshold {
+			log.Warn("org has a lot of managers", "org", org.Name, "managers", org.RoleCounts["managers"])
+		}
 	}
 
-	//associate app updates with spaces
-	err = client.getEndpointData(spaces, FieldAppUpdates, "/v2/events?q=type:audit.app.update&q=space_guid:", "associating app updates with spaces")
-	if err != nil {
-		bailWith("error associating app updates with spaces: %s", err)
-	}
-	//get all apps based on spaces
-	err = client.getEndpointData(spaces, FieldApps, "/v2/apps?q=space_guid:", "associating apps with spaces")
-	if err != nil {
-		bailWith("error associating apps with spaces: %s", err)
+	for _, space := range cfclient.SpacesWithHighRouteRatio(spaces, routeRatioThreshold) {
+		log.Warn("space has a lot of routes for its apps", "space", space.Name, "routes", len(space.Routes), "apps", len(space.Apps))
 	}
-	uiprogress.Stop()
 	// get all service bindings based on apps by space
 
 	// fmt.Println(spaces
@@ -107,77 +235,168 @@ func main() {
 	// }
 	// err = printAsJSON("orgs.json", orgs)
 	// if err != nil {
-	// 	bailWith("error writing orgs to file %s", err)
+	// 	return fmt.Errorf("error writing orgs to file %s", err)
 	// }
 	// err = printAsJSON("spaces.json", spaces)
 	// if err != nil {
-	// 	bailWith("error writing spaces to file %s", err)
+	// 	return fmt.Errorf("error writing spaces to file %s", err)
 	// }
 	//fmt.Println("orgs", orgs)
 	//fmt.Println("spaces", spaces)
 
 	//make an output folder
-	if _, err := os.Stat("output"); os.IsNotExist(err) {
-		err = os.MkdirAll("output", 0755)
+	if _, err := os.Stat(options.outputDir); os.IsNotExist(err) {
+		err = os.MkdirAll(options.outputDir, 0755)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("error making the output folder %s", err)
 		}
 	}
 
 	for _, org := range orgs {
-		err = printAsCSV("./output/org-"+org.Name+".csv", org)
+		err = printAsCSV(options.outputDir+"/org-"+org.Name+".csv", org)
 		if err != nil {
-			bailWith("error writing orgs to csv %s", err)
+			return fmt.Errorf("error writing orgs to csv %s", err)
 		}
 	}
 
 	for _, space := range spaces {
-		err = printAsCSV("./output/space-"+space.Name+".csv", space)
+		err = printAsCSV(options.outputDir+"/space-"+space.Name+".csv", space)
 		if err != nil {
-			bailWith("erorr writing spaces to csv %s", err)
+			return fmt.Errorf("erorr writing spaces to csv %s", err)
 		}
 	}
-}
 
-func bailWith(f string, a ...interface{}) {
-	ansi.Fprintf(os.Stderr, fmt.Sprintf("@R{%s}\n", f), a...)
-	os.Exit(1)
-}
+	//a run narrowed down to some orgs, spaces or collectors only has their files to write,
+	//the foundation's would only have its part of the totals
+	if result.Scope.Partial() {
+		return finishWrite(log, client, options, result)
+	}
+
+	var buildpackCounts, stackCounts []map[string]int
+	for _, org := range orgs {
+		buildpackCounts = append(buildpackCounts, org.BuildpackCounts)
+		stackCounts = append(stackCounts, org.StackCounts)
+	}
+	err = printCountsCSV(options.outputDir+"/foundation-buildpacks-stacks.csv", map[string]map[string]int{
+		"BUILDPACKS": cfclient.SumCounts(buildpackCounts...),
+		"STACKS":     cfclient.SumCounts(stackCounts...),
+	})
+	if err != nil {
+		return fmt.Errorf("error writing buildpack and stack counts to csv %s", err)
+	}
+
+	err = printAsJSON(options.outputDir+"/foundation-summary.json", cfclient.SummarizeFoundation(orgs, spaces))
+	if err != nil {
+		return fmt.Errorf("error writing foundation summary %s", err)
+	}
 
-func sanitizeApps(v *cfAPIResource) {
-	m, isMap := v.Entity.(map[string]interface{})
-	if !isMap {
-		panic("entity isn't a map!")
+	err = printAsJSON(options.outputDir+"/foundation-platform.json", result.Platform)
+	if err != nil {
+		return fmt.Errorf("error writing platform info %s", err)
 	}
 
-	delete(m, "environment_json")
+	if contains(result.Collectors, "catalog") {
+		err = printAsJSON(options.outputDir+"/foundation-service-catalog.json", result.ServiceCatalog)
+		if err != nil {
+			return fmt.Errorf("error writing service catalog %s", err)
+		}
+	}
+
+	if options.summaryCSV != "" {
+		err = printSpaceSummaryCSV(options.summaryCSV, options.foundation, orgs, spaces)
+		if err != nil {
+			return fmt.Errorf("error writing space summary csv %s", err)
+		}
+	}
+
+	return finishWrite(log, client, options, result)
 }
 
-func sanitizeEvents(v *cfAPIResource) {
-	m, isMap := v.Entity.(map[string]interface{})
-	if !isMap {
-		panic("entity isn't a map!")
+//finishWrite pushes a run to the sinks, prints its -output report and logs how it went
+func finishWrite(log *slog.Logger, client *cfclient.Client, options outputOptions, result cfclient.CollectionResult) error {
+	//every sink takes a run for the whole foundation: the api store and the metric sinks would
+	//swap it for the part a narrowed run has, the history would log the rest as removed and
+	//pagerduty resolve its incidents. so a narrowed run goes to them merged into the last
+	//full one, and waits for a full one when there hasn't been any yet
+	var sinksErr error
+	switch {
+	case !result.Scope.Partial():
+		if options.lastFull != nil {
+			options.lastFull.result = &result
+		}
+		sinksErr = writeSinks(log, options.sinks, result, time.Now())
+	case options.lastFull == nil || options.lastFull.result == nil:
+		log.Info("not writing a narrowed run to the sinks before a full one", "org", result.Scope.Org, "space", result.Scope.Space, "collectors", strings.Join(result.Scope.Collectors, ","))
+	default:
+		merged := options.lastFull.result.Merge(result)
+		options.lastFull.result = &merged
+		sinksErr = writeSinks(log, options.sinks, merged, time.Now())
 	}
 
-	meta, exists := m["metadata"]
-	if !exists {
-		panic("no metadata in events entity")
+	if options.format != "" {
+		err := writeReport(os.Stdout, options.format, options.foundation, result)
+		if err != nil {
+			return fmt.Errorf("error writing the %s report %s", options.format, err)
+		}
 	}
 
-	metaMap, isMap := meta.(map[string]interface{})
-	if !isMap {
-		panic("metadata isn't a map")
+	stats := client.Stats()
+	log.Info("collection done", "requests", stats.Requests, "retries", stats.Retries, "token_refreshes", stats.Refreshes, "bytes_read", stats.BytesRead, "not_modified", stats.NotModified)
+	for _, endpoint := range result.Requests {
+		log.Debug("api endpoint", "endpoint", endpoint.Endpoint, "requests", endpoint.Requests, "errors", endpoint.Errors, "retries", endpoint.Retries, "mean", endpoint.MeanLatency(), "p95", endpoint.Quantile(0.95))
 	}
 
-	request, exists := metaMap["request"]
-	if !exists {
-		panic("no request in events metadata")
+	if len(result.Forbidden) > 0 {
+		log.Info("left out what the token isn't authorized to read", "count", len(result.Forbidden), "collectors", forbiddenSummary(result))
+		for _, forbidden := range result.Forbidden {
+			log.Debug("not authorized", "collector", forbidden.Collector, "name", forbidden.Name, "guid", forbidden.GUID, "doing", forbidden.Doing)
+		}
+	}
+	if len(result.Failures) > 0 {
+		log.Warn("some orgs/spaces could not be fully collected", "count", len(result.Failures))
+		for _, failure := range result.Failures {
+			log.Warn("collection failure", "collector", failure.Collector, "name", failure.Name, "guid", failure.GUID, "doing", failure.Doing, "err", failure.Err)
+		}
+	}
+	if sinksErr != nil {
+		return sinksErr
+	}
+	if len(result.Failures) > 0 {
+		return partialError{collectorFailureSummary(options.foundation, result)}
 	}
+	return nil
+}
+
+//collectorFailureSummary is how many failures each collector had, e.g. "prod was only
+//partly collected: apps 2, events 1"
+func collectorFailureSummary(foundation string, result cfclient.CollectionResult) string {
+	failures := result.CollectorFailures()
+	var counts []string
+	for _, name := range result.Collectors {
+		if failures[name] > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", name, failures[name]))
+		}
+	}
+	if len(counts) == 0 {
+		return fmt.Sprintf("%s was only partly collected: %d failures", foundation, len(result.Failures))
+	}
+	return foundation + " was only partly collected: " + strings.Join(counts, ", ")
+}
 
-	reqMap, isMap := request.(map[string]interface{})
-	if !isMap {
-		panic("request isn't a map")
+//forbiddenSummary is what each collector of a -scoped run was refused, e.g. "buildpacks 1,
+//quotas 3"
+func forbiddenSummary(result cfclient.CollectionResult) string {
+	forbidden := result.CollectorForbidden()
+	var counts []string
+	for _, name := range result.Collectors {
+		if forbidden[name] > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", name, forbidden[name]))
+		}
 	}
+	return strings.Join(counts, ", ")
+}
 
-	delete(reqMap, "environment_json")
+func bailWith(f string, a ...interface{}) {
+	ansi.Fprintf(os.Stderr, fmt.Sprintf("@R{%s}\n", f), a...)
+	os.Exit(exitFailure)
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//natsSink publishes every run's snapshot, what -output json prints plus a timestamp, to
+//<prefix>.<foundation>. with a jetstream stream set the stream is created when it's missing,
+//capturing <prefix>.>, and every publish waits for the stream to ack it so a snapshot is
+//only counted as written once it's stored
+type natsSink struct {
+	url        string
+	prefix     string
+	stream     string
+	foundation string
+}
+
+func (nats natsSink) name() string {
+	if nats.stream != "" {
+		return "nats jetstream"
+	}
+	return "nats"
+}
+
+func (nats natsSink) subject() string {
+	return nats.prefix + "." + statsdNameUnsafe.ReplaceAllString(nats.foundation, "_")
+}
+
+func (nats natsSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	payload, err := json.Marshal(newTimedReport(nats.foundation, result, timestamp))
+	if err != nil {
+		return err
+	}
+	conn, err := dialNATS(nats.url, time.Minute)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	if len(payload) > conn.maxPayload {
+		return fmt.Errorf("the snapshot is %d bytes, more than the nats server's max_payload of %d", len(payload), conn.maxPayload)
+	}
+
+	if nats.stream == "" {
+		err = conn.publish(nats.subject(), "", payload)
+		if err != nil {
+			return err
+		}
+		return conn.ping()
+	}
+
+	err = conn.ensureStream(nats.stream, nats.prefix+".>")
+	if err != nil {
+		return err
+	}
+	answer, err := conn.request(nats.subject(), payload)
+	if err != nil {
+		return fmt.Errorf("no jetstream ack for %s, is it in a stream? %s", nats.subject(), err)
+	}
+	var ack struct {
+		Stream string         `json:"stream"`
+		Error  *natsJSONError `json:"error"`
+	}
+	err = json.Unmarshal(answer, &ack)
+	if err != nil {
+		return fmt.Errorf("could not read the jetstream ack: %s", err)
+	}
+	if ack.Error != nil {
+		return ack.Error
+	}
+	return nil
+}
+
+//natsJSONError is the error a jetstream api or publish answers with
+type natsJSONError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *natsJSONError) Error() string {
+	return fmt.Sprintf("jetstream error %d: %s", e.Code, e.Description)
+}
+
+//natsConn is the part of the nats client protocol the nats sink needs: tls, user and
+//password or token auth, publishing and request/reply. there's no client vendored
+type natsConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	maxPayload int
+	inbox      string
+}
+
+//dialNATS connects to a nats://[user:password@|token@]host:port url, or tls:// for tls even
+//when the server doesn't require it
+func dialNATS(rawURL string, timeout time.Duration) (*natsConn, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the nats url: %s", err)
+	}
+	if parsed.Scheme != "nats" && parsed.Scheme != "tls" {
+		return nil, fmt.Errorf("the nats url should start with nats:// or tls://, not %s://", parsed.Scheme)
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	nats := &natsConn{conn: conn, reader: bufio.NewReader(conn), maxPayload: 1024 * 1024}
+
+	line, err := nats.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	info, found := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !found {
+		conn.Close()
+		return nil, fmt.Errorf("expected INFO from the nats server, got %q", line)
+	}
+	var server struct {
+		MaxPayload  int  `json:"max_payload"`
+		TLSRequired bool `json:"tls_required"`
+	}
+	err = json.Unmarshal([]byte(info), &server)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not read the nats server's INFO: %s", err)
+	}
+	if server.MaxPayload > 0 {
+		nats.maxPayload = server.MaxPayload
+	}
+	useTLS := server.TLSRequired || parsed.Scheme == "tls"
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: parsed.Hostname()})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake with nats failed: %s", err)
+		}
+		nats.conn = tlsConn
+		nats.reader = bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"name":         "cf-metrics",
+		"lang":         "go",
+		"version":      "1",
+		"protocol":     1,
+		"tls_required": useTLS,
+	}
+	if password, hasPassword := parsed.User.Password(); hasPassword {
+		options["user"], options["pass"] = parsed.User.Username(), password
+	} else if parsed.User != nil {
+		options["auth_token"] = parsed.User.Username()
+	}
+	connect, _ := json.Marshal(options)
+	_, err = nats.conn.Write([]byte("CONNECT " + string(connect) + "\r\n"))
+	if err == nil {
+		err = nats.ping()
+	}
+	if err != nil {
+		nats.conn.Close()
+		return nil, err
+	}
+	return nats, nil
+}
+
+func (nats *natsConn) close() error {
+	return nats.conn.Close()
+}
+
+func (nats *natsConn) publish(subject string, reply string, payload []byte) error {
+	command := "PUB " + subject + " "
+	if reply != "" {
+		command += reply + " "
+	}
+	_, err := nats.conn.Write([]byte(command + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\n"))
+	return err
+}
+
+//ping waits for the server to answer a PING, so everything before it has been taken or
+//rejected with an -ERR
+func (nats *natsConn) ping() error {
+	_, err := nats.conn.Write([]byte("PING\r\n"))
+	if err != nil {
+		return err
+	}
+	for {
+		line, _, err := nats.next()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+//request publishes with a reply subject and waits for the first message to it
+func (nats *natsConn) request(subject string, payload []byte) ([]byte, error) {
+	if nats.inbox == "" {
+		raw := make([]byte, 12)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, err
+		}
+		nats.inbox = "_INBOX." + hex.EncodeToString(raw)
+		_, err = nats.conn.Write([]byte("SUB " + nats.inbox + " 1\r\n"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := nats.publish(subject, nats.inbox, payload)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		line, message, err := nats.next()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "MSG ") {
+			return message, nil
+		}
+	}
+}
+
+//next reads the next thing the server sends, answering its PINGs on the way. a MSG comes
+//with its payload, an -ERR is an error
+func (nats *natsConn) next() (string, []byte, error) {
+	for {
+		line, err := nats.reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			_, err = nats.conn.Write([]byte("PONG\r\n"))
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		case strings.HasPrefix(line, "-ERR"):
+			return "", nil, fmt.Errorf("nats said %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "+OK" || strings.HasPrefix(line, "INFO "):
+			continue
+		case strings.HasPrefix(line, "MSG "):
+			//MSG <subject> <sid> [reply] <size>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return "", nil, fmt.Errorf("nats sent a bad MSG line %q", line)
+			}
+			message := make([]byte, size+2)
+			_, err = io.ReadFull(nats.reader, message)
+			return line, message[:size], err
+		}
+		return line, nil, nil
+	}
+}
+
+//ensureStream creates the jetstream stream with the default limits when it doesn't exist
+func (nats *natsConn) ensureStream(stream string, subject string) error {
+	answer, err := nats.request("$JS.API.STREAM.INFO."+stream, nil)
+	if err != nil {
+		return fmt.Errorf("error asking jetstream about stream %s, is jetstream enabled? %s", stream, err)
+	}
+	var info struct {
+		Error *natsJSONError `json:"error"`
+	}
+	err = json.Unmarshal(answer, &info)
+	if err != nil {
+		return fmt.Errorf("could not read jetstream's stream info: %s", err)
+	}
+	if info.Error == nil {
+		return nil
+	}
+	if info.Error.Code != 404 {
+		return info.Error
+	}
+	config, _ := json.Marshal(map[string]interface{}{"name": stream, "subjects": []string{subject}, "storage": "file", "retention": "limits"})
+	answer, err = nats.request("$JS.API.STREAM.CREATE."+stream, config)
+	if err != nil {
+		return fmt.Errorf("error creating jetstream stream %s: %s", stream, err)
+	}
+	info.Error = nil
+	err = json.Unmarshal(answer, &info)
+	if err != nil {
+		return fmt.Errorf("could not read jetstream's answer to creating %s: %s", stream, err)
+	}
+	if info.Error != nil {
+		return info.Error
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//instanceSink is a sink that can also take the app instance metrics the nozzle hears about
+type instanceSink interface {
+	sink
+	writeInstances(samples []instanceSample, timestamp time.Time) error
+}
+
+//instanceSample is everything the nozzle heard about one app instance since its last flush
+type instanceSample struct {
+	org      string
+	space    string
+	app      string
+	appGUID  string
+	instance string
+	//gauges are the latest value of each, e.g. cpu, memory and disk from the container
+	//metric, counters add up the deltas of each
+	gauges   map[string]float64
+	counters map[string]uint64
+}
+
+//nozzle collects app instance samples off the log stream between flushes
+type nozzle struct {
+	mutex   sync.Mutex
+	samples map[string]*instanceSample
+}
+
+func newNozzle() *nozzle {
+	return &nozzle{samples: map[string]*instanceSample{}}
+}
+
+//add records an envelope. only envelopes about apps are kept, the platform's own components
+//send plenty of gauges and counters too, without app tags
+func (n *nozzle) add(envelope cfclient.Envelope) error {
+	appGUID := envelope.Tags["app_id"]
+	if appGUID == "" {
+		appGUID = envelope.Tags["source_id"]
+	}
+	if envelope.Tags["app_name"] == "" || appGUID == "" {
+		return nil
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	key := appGUID + "/" + envelope.InstanceID
+	sample := n.samples[key]
+	if sample == nil {
+		sample = &instanceSample{
+			org:      envelope.Tags["organization_name"],
+			space:    envelope.Tags["space_name"],
+			app:      envelope.Tags["app_name"],
+			appGUID:  appGUID,
+			instance: envelope.InstanceID,
+			gauges:   map[string]float64{},
+			counters: map[string]uint64{},
+		}
+		n.samples[key] = sample
+	}
+	for name, gauge := range envelope.Gauges {
+		sample.gauges[name] = gauge.Value
+	}
+	if envelope.Counter != nil {
+		sample.counters[envelope.Counter.Name] += envelope.Counter.Delta
+	}
+	return nil
+}
+
+//flush hands back what's been heard since the last flush, by org, space, app and instance
+func (n *nozzle) flush() []instanceSample {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var samples []instanceSample
+	for _, sample := range n.samples {
+		samples = append(samples, *sample)
+	}
+	n.samples = map[string]*instanceSample{}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.org != b.org {
+			return a.org < b.org
+		}
+		if a.space != b.space {
+			return a.space < b.space
+		}
+		if a.app != b.app {
+			return a.app < b.app
+		}
+		return a.instance < b.instance
+	})
+	return samples
+}
+
+//nozzleTarget is a foundation to stream from and the sinks its samples go to
+type nozzleTarget struct {
+	foundation foundation
+	sinks      []instanceSink
+}
+
+//runNozzle streams app instance metrics from every target's log stream gateway and writes
+//them to its sinks every flushInterval until ctx is done, then flushes one last time
+func runNozzle(ctx context.Context, targets []nozzleTarget, shardID string, flushInterval time.Duration) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target nozzleTarget) {
+			defer wg.Done()
+			samples := newNozzle()
+			streamDone := make(chan struct{})
+			go func() {
+				defer close(streamDone)
+				consumeLogStream(ctx, target.foundation, shardID, samples)
+			}()
+
+			ticker := time.NewTicker(flushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					writeInstances(target, samples.flush())
+				case <-streamDone:
+					writeInstances(target, samples.flush())
+					return
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+//consumeLogStream keeps a log stream connection open until ctx is done, reconnecting when
+//the gateway ends one and backing off while it can't connect
+func consumeLogStream(ctx context.Context, target foundation, shardID string, samples *nozzle) {
+	logger := slog.With("foundation", target.name)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := target.client.StreamEnvelopes(ctx, shardID, samples.add)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Warn("log stream disconnected", "err", err, "retry_in", backoff)
+		}
+		//a connection that stayed up a while was healthy, start the backoff over
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func writeInstances(target nozzleTarget, samples []instanceSample) {
+	if len(samples) == 0 {
+		return
+	}
+	timestamp := time.Now()
+	for _, destination := range target.sinks {
+		err := destination.writeInstances(samples, timestamp)
+		if err != nil {
+			slog.Error("error writing app instance metrics to "+destination.name(), "foundation", target.foundation.name, "err", err)
+		}
+	}
+}
+
+//instanceSinks picks out the sinks that can take app instance metrics
+func instanceSinks(sinks []sink) []instanceSink {
+	var found []instanceSink
+	for _, candidate := range sinks {
+		if destination, ok := candidate.(instanceSink); ok {
+			found = append(found, destination)
+		}
+	}
+	return found
+}
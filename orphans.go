@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//orphan is something report orphans prints: a binding to an app that's gone, or a service
+//instance nothing has been bound to in a while
+type orphan struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	//Kind is "binding" or "instance"
+	Kind     string `json:"kind"`
+	Instance string `json:"instance"`
+	Service  string `json:"service"`
+	Plan     string `json:"plan"`
+	//GUID is the binding's guid for a binding, the instance's for an instance
+	GUID    string `json:"guid"`
+	AppGUID string `json:"app_guid,omitempty"`
+	AgeDays int    `json:"age_days"`
+}
+
+type orphanReport struct {
+	Days    int      `json:"days"`
+	Orphans []orphan `json:"orphans"`
+}
+
+//add adds a run's orphans. a binding's app is looked for among every app the run saw first,
+//shared service instances can be bound from other spaces, and only asked the api about when
+//it isn't there since the run may have been filtered down to a few orgs
+func (report *orphanReport) add(ctx context.Context, target foundation, result cfclient.CollectionResult, now time.Time) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	apps := map[string]bool{}
+	for _, space := range result.Spaces {
+		for _, app := range space.Apps {
+			apps[app.Metadata.GUID] = true
+		}
+	}
+	appExists := func(guid string) bool {
+		if exists, known := apps[guid]; known {
+			return exists
+		}
+		exists, err := target.client.AppExists(ctx, guid)
+		if err != nil {
+			slog.Warn("couldn't look up a bound app, leaving its binding out", "foundation", target.name, "app", guid, "err", err)
+			exists = true
+		}
+		apps[guid] = exists
+		return exists
+	}
+
+	cutoff := now.AddDate(0, 0, -report.Days)
+	for _, space := range result.Spaces {
+		for _, instance := range space.ServiceInstances {
+			entry := orphan{
+				Foundation: target.name,
+				Org:        orgNames[space.OrganizationGUID],
+				Space:      space.Name,
+				Instance:   instance.Name,
+				Service:    instance.ServiceName,
+				Plan:       instance.PlanName,
+			}
+			for _, binding := range instance.AppBindings {
+				if appExists(binding.AppGUID) {
+					continue
+				}
+				bindingEntry := entry
+				bindingEntry.Kind = "binding"
+				bindingEntry.GUID = binding.GUID
+				bindingEntry.AppGUID = binding.AppGUID
+				bindingEntry.AgeDays = daysSince(binding.CreatedAt, now)
+				report.Orphans = append(report.Orphans, bindingEntry)
+			}
+			if instance.Bindings == 0 && !instance.CreatedAt.IsZero() && instance.CreatedAt.Before(cutoff) {
+				entry.Kind = "instance"
+				entry.GUID = instance.GUID
+				entry.AgeDays = daysSince(instance.CreatedAt, now)
+				report.Orphans = append(report.Orphans, entry)
+			}
+		}
+	}
+}
+
+func daysSince(then time.Time, now time.Time) int {
+	if then.IsZero() {
+		return 0
+	}
+	return int(now.Sub(then).Hours() / 24)
+}
+
+//sort lists bindings before instances, oldest first
+func (report *orphanReport) sort() {
+	sort.SliceStable(report.Orphans, func(i, j int) bool {
+		a, b := report.Orphans[i], report.Orphans[j]
+		if a.Kind != b.Kind {
+			return a.Kind == "binding"
+		}
+		if a.AgeDays != b.AgeDays {
+			return a.AgeDays > b.AgeDays
+		}
+		return a.Org+"/"+a.Space+"/"+a.Instance < b.Org+"/"+b.Space+"/"+b.Instance
+	})
+	if report.Orphans == nil {
+		report.Orphans = []orphan{}
+	}
+}
+
+func (report orphanReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"kind", "foundation", "org", "space", "instance", "service", "plan", "guid", "app_guid", "age_days"}}
+	for _, entry := range report.Orphans {
+		rows = append(rows, []string{entry.Kind, entry.Foundation, entry.Org, entry.Space, entry.Instance, entry.Service, entry.Plan,
+			entry.GUID, entry.AppGUID, strconv.Itoa(entry.AgeDays)})
+	}
+	return writeListing(out, format, report, rows)
+}
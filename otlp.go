@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//otlpExportPath is the grpc method otlp metrics are exported with, otlpHTTPPath the http one.
+//otlpTraceExportPath is the grpc one for traces
+const (
+	otlpExportPath      = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	otlpHTTPPath        = "/v1/metrics"
+	otlpTraceExportPath = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+)
+
+var otlpProtocols = []string{"grpc", "http/protobuf"}
+
+//otlpSink exports every run to an opentelemetry collector as otlp metrics, over grpc or
+//http/protobuf. every org and space is its own resource, with cf.foundation, cf.org and
+//cf.space attributes, and its counts are gauges named cf.<metric>. there's no otel sdk or
+//grpc vendored, so the protobuf is written by hand and grpc is spoken over net/http's http/2
+type otlpSink struct {
+	endpoint   string
+	protocol   string
+	headers    map[string]string
+	foundation string
+	//collections counts this foundation's runs for the cf.collections counter
+	collections *otlpCounter
+}
+
+//otlpCounter is a cumulative counter and when it started
+type otlpCounter struct {
+	sync.Mutex
+	start time.Time
+	count int64
+}
+
+func (otlp otlpSink) name() string {
+	return "otlp " + otlp.protocol
+}
+
+func (otlp otlpSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	otlp.collections.Lock()
+	if otlp.collections.start.IsZero() {
+		otlp.collections.start = timestamp
+	}
+	otlp.collections.count++
+	start, collections := otlp.collections.start, otlp.collections.count
+	otlp.collections.Unlock()
+
+	request := otlpRequest(otlp.foundation, metricPoints(otlp.foundation, result, timestamp), timestamp, start, collections)
+	if otlp.protocol == "grpc" {
+		return otlp.exportGRPC(otlpExportPath, request)
+	}
+	return otlp.exportHTTP(otlpHTTPPath, request)
+}
+
+//exportGRPC sends the request as a unary grpc call of the method at path. http endpoints get
+//h2c, https ones tls
+func (otlp otlpSink) exportGRPC(path string, request []byte) error {
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(request)))
+	frame = append(frame, request...)
+
+	req, err := otlp.newRequest(path, frame)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Timeout: time.Minute, Transport: &http.Transport{
+		Protocols:       &protocols,
+		TLSClientConfig: &tls.Config{NextProtos: []string{"h2"}},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	//the status is in the trailers, or in the headers when the server answers with nothing else
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("otlp collector returned http %d", resp.StatusCode)
+	}
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		message, _ = url.PathUnescape(message)
+		return fmt.Errorf("otlp collector returned grpc status %s: %s", status, message)
+	}
+	return nil
+}
+
+//exportHTTP posts the request to path under the endpoint
+func (otlp otlpSink) exportHTTP(path string, request []byte) error {
+	req, err := otlp.newRequest(path, request)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("otlp collector returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (otlp otlpSink) newRequest(path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(otlp.endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range otlp.headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+//headerPairs reads a headers flag like -otlp-headers, key=value pairs the way
+//OTEL_EXPORTER_OTLP_HEADERS has them, values url escaped
+func headerPairs(flag string, pairs []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%s wants key=value pairs, not %s", flag, pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("bad %s value for %s: %s", flag, key, err)
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers, nil
+}
+
+//otlpRequest encodes an ExportMetricsServiceRequest with one resource for the foundation,
+//with cf.collections and cf.collection_errors, and one for each org and space
+func otlpRequest(foundation string, points []metricPoint, timestamp time.Time, start time.Time, collections int64) []byte {
+	type resourceKey struct{ org, space string }
+	var order []resourceKey
+	metrics := map[resourceKey][]byte{}
+	for _, point := range points {
+		key := resourceKey{point.tags["org"], point.tags["space"]}
+		if _, seen := metrics[key]; !seen {
+			order = append(order, key)
+		}
+		var dataPoint protoMessage
+		dataPoint.fixed64(3, uint64(point.timestamp.UnixNano()))
+		dataPoint.double(4, point.value)
+		for _, dimension := range point.dimensions() {
+			dataPoint.message(7, otlpAttribute(otlpAttributeName(dimension[0]), dimension[1]))
+		}
+		var gauge protoMessage
+		gauge.message(1, dataPoint)
+		var metric protoMessage
+		metric.string(1, "cf."+point.name)
+		metric.message(5, gauge)
+		metrics[key] = append(metrics[key], protoField(2, metric)...)
+	}
+
+	var counter protoMessage
+	counter.fixed64(2, uint64(start.UnixNano()))
+	counter.fixed64(3, uint64(timestamp.UnixNano()))
+	counter.sfixed64(6, collections)
+	var sum protoMessage
+	sum.message(1, counter)
+	sum.varint(2, 2) //cumulative
+	sum.varint(3, 1) //monotonic
+	var collectionsMetric protoMessage
+	collectionsMetric.string(1, "cf.collections")
+	collectionsMetric.string(2, "collection runs against the foundation since cf-metrics started")
+	collectionsMetric.message(7, sum)
+	foundationKey := resourceKey{}
+	if _, seen := metrics[foundationKey]; !seen {
+		order = append(order, foundationKey)
+	}
+	metrics[foundationKey] = append(metrics[foundationKey], protoField(2, collectionsMetric)...)
+
+	var request protoMessage
+	for _, key := range order {
+		var resource protoMessage
+		resource.message(1, otlpAttribute("service.name", "cf-metrics"))
+		resource.message(1, otlpAttribute("cf.foundation", foundation))
+		if key.org != "" {
+			resource.message(1, otlpAttribute("cf.org", key.org))
+		}
+		if key.space != "" {
+			resource.message(1, otlpAttribute("cf.space", key.space))
+		}
+		var scope protoMessage
+		scope.string(1, "github.com/aanelli/cf-metrics")
+		scopeMetrics := protoField(1, scope)
+		scopeMetrics = append(scopeMetrics, metrics[key]...)
+		var resourceMetrics protoMessage
+		resourceMetrics.message(1, resource)
+		resourceMetrics.message(2, scopeMetrics)
+		request.message(1, resourceMetrics)
+	}
+	return request
+}
+
+//otlpAttributeName is a point tag as a cf. attribute, with the _s as dots the way otel names
+//them, e.g. cf.event.type for event_type
+func otlpAttributeName(tag string) string {
+	return "cf." + strings.Replace(tag, "_", ".", -1)
+}
+
+//otlpAttribute is a KeyValue with a string AnyValue
+func otlpAttribute(key string, value string) protoMessage {
+	var anyValue protoMessage
+	anyValue.string(1, value)
+	var attribute protoMessage
+	attribute.string(1, key)
+	attribute.message(2, anyValue)
+	return attribute
+}
+
+//otlpIntAttribute is a KeyValue with an int AnyValue
+func otlpIntAttribute(key string, value int64) protoMessage {
+	var anyValue protoMessage
+	anyValue.varint(3, uint64(value))
+	var attribute protoMessage
+	attribute.string(1, key)
+	attribute.message(2, anyValue)
+	return attribute
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//otlpTraceSink exports every run's trace to an opentelemetry collector, jaeger or tempo over
+//grpc or http/protobuf: a span for the run, one for listing the orgs and the spaces and for
+//each collector under it, and one for each api request under those. the same trace ids are
+//the exemplars of serve's request latency histogram, and the traceparent the requests were
+//sent with, so a slow bucket leads to the trace and from there to the gorouter's logs
+type otlpTraceSink struct {
+	//url is the whole traces url for http/protobuf, e.g. http://collector:4318/v1/traces, and
+	//the collector's for grpc, e.g. http://collector:4317
+	url        string
+	protocol   string
+	headers    map[string]string
+	foundation string
+}
+
+func (traces otlpTraceSink) name() string {
+	return "otlp traces"
+}
+
+func (traces otlpTraceSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	if len(result.Spans) == 0 {
+		return nil
+	}
+	exporter := otlpSink{endpoint: traces.url, headers: traces.headers}
+	if traces.protocol == "grpc" {
+		return exporter.exportGRPC(otlpTraceExportPath, otlpTraceRequest(traces.foundation, result.Spans))
+	}
+	return exporter.exportHTTP("", otlpTraceRequest(traces.foundation, result.Spans))
+}
+
+//otlpTraceRequest encodes an ExportTraceServiceRequest with the spans as the foundation's
+func otlpTraceRequest(foundation string, spans []cfclient.Span) []byte {
+	var resource protoMessage
+	resource.message(1, otlpAttribute("service.name", "cf-metrics"))
+	resource.message(1, otlpAttribute("cf.foundation", foundation))
+	var scope protoMessage
+	scope.string(1, "github.com/aanelli/cf-metrics")
+	scopeSpans := protoField(1, scope)
+	for _, span := range spans {
+		var encoded protoMessage
+		encoded.string(1, otlpID(span.TraceID))
+		encoded.string(2, otlpID(span.SpanID))
+		if span.ParentID != "" {
+			encoded.string(4, otlpID(span.ParentID))
+		}
+		encoded.string(5, span.Name)
+		//requests are client spans, the run and its steps internal ones
+		kind := uint64(1)
+		if span.Method != "" {
+			kind = 3
+		}
+		encoded.varint(6, kind)
+		encoded.fixed64(7, uint64(span.Start.UnixNano()))
+		encoded.fixed64(8, uint64(span.Start.Add(span.Duration).UnixNano()))
+		if span.Method != "" {
+			encoded.message(9, otlpAttribute("http.request.method", span.Method))
+			path, query, _ := strings.Cut(span.Path, "?")
+			encoded.message(9, otlpAttribute("url.path", path))
+			if query != "" {
+				encoded.message(9, otlpAttribute("url.query", query))
+			}
+			encoded.message(9, otlpAttribute("cf.endpoint", span.Endpoint))
+			encoded.message(9, otlpIntAttribute("cf.page", int64(span.Page)))
+		}
+		if span.Status != 0 {
+			encoded.message(9, otlpIntAttribute("http.response.status_code", int64(span.Status)))
+		}
+		if span.Collector != "" {
+			encoded.message(9, otlpAttribute("cf.collector", span.Collector))
+			encoded.message(9, otlpIntAttribute("cf.failures", int64(span.Failures)))
+		}
+		if span.Error != "" || span.Status >= 400 {
+			var status protoMessage
+			status.string(2, span.Error)
+			status.varint(3, 2) //error
+			encoded.message(15, status)
+		}
+		scopeSpans = append(scopeSpans, protoField(2, encoded)...)
+	}
+	var resourceSpans protoMessage
+	resourceSpans.message(1, resource)
+	resourceSpans.message(2, scopeSpans)
+	var request protoMessage
+	request.message(1, resourceSpans)
+	return request
+}
+
+//otlpID is a hex trace or span id as the bytes otlp has it in
+func otlpID(id string) string {
+	decoded, _ := hex.DecodeString(id)
+	return string(decoded)
+}
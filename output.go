@@ -3,42 +3,50 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
-	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
 	"github.com/jeremywohl/flatten"
 )
 
 func printAsJSON(fileName string, data interface{}) error {
 	output, err := json.Marshal(data)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	file, err := os.Create(fileName)
 	if err != nil {
-		fmt.Println("error creating file", err)
 		return err
 	}
 	defer file.Close()
 
 	bytesWritten, err := file.Write(output)
 	if err != nil {
-		fmt.Println("error writing to file", err)
 		return err
 	}
-	fmt.Printf("Wrote %d bytes.\n", bytesWritten)
+	slog.Debug("wrote json", "file", fileName, "bytes", bytesWritten)
 	return nil
 }
 
 //https://github.com/360EntSecGroup-Skylar/excelize
-func printAsCSV(fileName string, datapoint cfData) error {
+func printAsCSV(fileName string, datapoint cfclient.Data) error {
 	outputCSV := [][]string{}
 
 	outputCSV = append(outputCSV, []string{datapoint.Name, datapoint.GUID, datapoint.OrganizationGUID})
 
+	usage := datapoint.Usage
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"USAGE"})
+	outputCSV = append(outputCSV, []string{"apps", "started_apps", "desired_instances", "running_instances", "reserved_memory_mb", "reserved_disk_mb", "running_memory_mb", "running_disk_mb"})
+	outputCSV = append(outputCSV, []string{strconv.Itoa(usage.Apps), strconv.Itoa(usage.StartedApps), strconv.Itoa(usage.DesiredInstances), strconv.Itoa(usage.RunningInstances), strconv.Itoa(usage.ReservedMemoryMB), strconv.Itoa(usage.ReservedDiskMB), strconv.Itoa(usage.RunningMemoryMB), strconv.Itoa(usage.RunningDiskMB)})
+
 	outputCSV = append(outputCSV, []string{"\n"})
 	outputCSV = append(outputCSV, []string{"APPS"})
 	for _, app := range datapoint.Apps {
@@ -89,6 +97,30 @@ func printAsCSV(fileName string, datapoint cfData) error {
 		outputCSV = append(outputCSV, temp)
 	}
 
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"APP CRASHES"})
+	for _, appCrash := range datapoint.AppCrashes {
+		temp, err := convertCFAPIResourceToCSVString(appCrash)
+		if err != nil {
+			return err
+		}
+		outputCSV = append(outputCSV, temp)
+	}
+
+	//event types from -event-types that don't have a section of their own above
+	var otherEvents [][]string
+	for _, event := range datapoint.Events {
+		if !cfclient.HasEventField(event.Type) {
+			otherEvents = append(otherEvents, []string{event.Type, event.GUID, event.Timestamp.UTC().Format(time.RFC3339), event.ActorName, event.ActeeType, event.ActeeName, event.Actee})
+		}
+	}
+	if len(otherEvents) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"OTHER EVENTS"})
+		outputCSV = append(outputCSV, []string{"type", "guid", "timestamp", "actor_name", "actee_type", "actee_name", "actee"})
+		outputCSV = append(outputCSV, otherEvents...)
+	}
+
 	outputCSV = append(outputCSV, []string{"\n"})
 	outputCSV = append(outputCSV, []string{"SERVICE BINDINGS"})
 	for _, serviceBinding := range datapoint.ServiceBindings {
@@ -99,6 +131,183 @@ func printAsCSV(fileName string, datapoint cfData) error {
 		outputCSV = append(outputCSV, temp)
 	}
 
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"SERVICE INSTANCES"})
+	outputCSV = append(outputCSV, []string{"name", "guid", "space_guid", "service", "plan", "broker", "bindings", "last_operation", "state", "updated_at", "stuck"})
+	for _, instance := range datapoint.ServiceInstances {
+		updatedAt := ""
+		if !instance.LastOperation.UpdatedAt.IsZero() {
+			updatedAt = instance.LastOperation.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		outputCSV = append(outputCSV, []string{instance.Name, instance.GUID, instance.SpaceGUID, instance.ServiceName, instance.PlanName, instance.BrokerName,
+			strconv.Itoa(instance.Bindings), instance.LastOperation.Type, instance.LastOperation.State, updatedAt, strconv.FormatBool(instance.Stuck(time.Now()))})
+	}
+
+	if len(datapoint.UserProvidedServices) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"USER PROVIDED SERVICES"})
+		outputCSV = append(outputCSV, []string{"name", "guid", "space_guid", "syslog_drain_url", "route_service_url", "bindings", "route_apps"})
+		for _, instance := range datapoint.UserProvidedServices {
+			outputCSV = append(outputCSV, []string{instance.Name, instance.GUID, instance.SpaceGUID, instance.SyslogDrainURL, instance.RouteServiceURL,
+				strconv.Itoa(len(instance.AppBindings)), strconv.Itoa(len(instance.RouteApps))})
+		}
+	}
+
+	if len(datapoint.DockerApps) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"DOCKER IMAGES"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "image", "registry"})
+		for _, dockerApp := range datapoint.DockerApps {
+			outputCSV = append(outputCSV, []string{dockerApp.AppName, dockerApp.AppGUID, dockerApp.Image, dockerApp.Registry})
+		}
+	}
+
+	if len(datapoint.Tasks) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"TASKS"})
+		outputCSV = append(outputCSV, []string{"name", "guid", "app_guid", "state", "memory_mb", "disk_mb", "created_at", "duration_seconds", "failure_reason"})
+		for _, task := range datapoint.Tasks {
+			outputCSV = append(outputCSV, []string{task.Name, task.GUID, task.AppGUID, task.State, strconv.Itoa(task.MemoryMB), strconv.Itoa(task.DiskMB),
+				task.CreatedAt.UTC().Format(time.RFC3339), strconv.FormatFloat(task.Duration().Seconds(), 'f', -1, 64), task.FailureReason})
+		}
+	}
+
+	if len(datapoint.Builds) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"BUILDS"})
+		outputCSV = append(outputCSV, []string{"guid", "app_guid", "state", "buildpack", "created_at", "duration_seconds", "error"})
+		for _, build := range datapoint.Builds {
+			outputCSV = append(outputCSV, []string{build.GUID, build.AppGUID, build.State, build.Buildpack,
+				build.CreatedAt.UTC().Format(time.RFC3339), strconv.FormatFloat(build.Duration().Seconds(), 'f', -1, 64), build.Error})
+		}
+	}
+
+	if len(datapoint.Blobs) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"BLOBS"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "droplets", "droplet_bytes", "largest_droplet_bytes", "packages", "package_bytes", "large"})
+		for _, blobs := range datapoint.Blobs {
+			outputCSV = append(outputCSV, []string{blobs.AppName, blobs.AppGUID, strconv.Itoa(blobs.Droplets), strconv.FormatInt(blobs.DropletBytes, 10),
+				strconv.FormatInt(blobs.LargestDropletBytes, 10), strconv.Itoa(blobs.Packages), strconv.FormatInt(blobs.PackageBytes, 10), strconv.FormatBool(blobs.Large)})
+		}
+	}
+
+	if len(datapoint.Deployments) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"DEPLOYMENTS"})
+		outputCSV = append(outputCSV, []string{"guid", "app_guid", "strategy", "status", "reason", "revision", "created_at", "updated_at"})
+		for _, deployment := range datapoint.Deployments {
+			outputCSV = append(outputCSV, []string{deployment.GUID, deployment.AppGUID, deployment.Strategy, deployment.Status, deployment.Reason, strconv.Itoa(deployment.RevisionVersion),
+				deployment.CreatedAt.UTC().Format(time.RFC3339), deployment.UpdatedAt.UTC().Format(time.RFC3339)})
+		}
+	}
+
+	if len(datapoint.Processes) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"PROCESSES"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "type", "guid", "instances", "memory_mb", "disk_mb", "health_check_type", "health_check_timeout", "health_check_invocation_timeout", "health_check_http_endpoint"})
+		for _, process := range datapoint.Processes {
+			outputCSV = append(outputCSV, []string{process.AppName, process.AppGUID, process.Type, process.GUID, strconv.Itoa(process.Instances), strconv.Itoa(process.MemoryMB), strconv.Itoa(process.DiskMB),
+				process.HealthCheckType, strconv.Itoa(process.HealthCheckTimeout), strconv.Itoa(process.InvocationTimeout), process.HealthCheckEndpoint})
+		}
+	}
+
+	if len(datapoint.Sidecars) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"SIDECARS"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "name", "guid", "process_types", "memory_mb", "origin"})
+		for _, sidecar := range datapoint.Sidecars {
+			outputCSV = append(outputCSV, []string{sidecar.AppName, sidecar.AppGUID, sidecar.Name, sidecar.GUID, strings.Join(sidecar.ProcessTypes, " "), strconv.Itoa(sidecar.MemoryMB), sidecar.Origin})
+		}
+	}
+
+	if len(datapoint.EnvVars) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"ENV VAR NAMES"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "env_vars", "suspicious"})
+		for _, env := range datapoint.EnvVars {
+			outputCSV = append(outputCSV, []string{env.AppName, env.AppGUID, strconv.Itoa(len(env.Names)), strings.Join(env.Suspicious, " ")})
+		}
+	}
+
+	if len(datapoint.Revisions) > 0 {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"REVISIONS"})
+		outputCSV = append(outputCSV, []string{"app", "app_guid", "deployed", "latest", "rolled_back_to", "pinned", "created_in_window"})
+		for _, revision := range datapoint.Revisions {
+			var deployed, created []string
+			for _, version := range revision.DeployedVersions {
+				deployed = append(deployed, strconv.Itoa(version))
+			}
+			for _, version := range revision.WindowVersions {
+				created = append(created, strconv.Itoa(version))
+			}
+			outputCSV = append(outputCSV, []string{revision.AppName, revision.AppGUID, strings.Join(deployed, " "), strconv.Itoa(revision.LatestVersion),
+				strconv.Itoa(revision.RolledBackTo), strconv.FormatBool(revision.Pinned()), strings.Join(created, " ")})
+		}
+	}
+
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"ROUTES"})
+	outputCSV = append(outputCSV, []string{"guid", "host", "path", "domain_guid", "protocol", "port", "apps"})
+	for _, r := range datapoint.Routes {
+		outputCSV = append(outputCSV, routeCSVRow(r))
+	}
+
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"APP ROUTE COUNTS"})
+	for _, app := range datapoint.Apps {
+		if routeCount, exists := datapoint.AppRouteCounts[app.Metadata.GUID]; exists {
+			outputCSV = append(outputCSV, []string{cfclient.EntityString(app, "name"), app.Metadata.GUID, strconv.Itoa(routeCount)})
+		}
+	}
+
+	if datapoint.AppInstanceStates != nil {
+		running, desired := cfclient.RunningVsDesired(datapoint.AppInstanceStates)
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"APP INSTANCE STATES", "running", strconv.Itoa(running), "desired", strconv.Itoa(desired)})
+		outputCSV = append(outputCSV, []string{"app_guid", "desired", "RUNNING", "CRASHED", "STARTING", "DOWN", "cpu", "memory_bytes", "memory_quota_bytes", "memory_percent", "disk_bytes", "disk_quota_bytes", "disk_percent"})
+		for _, guid := range sortedKeys(datapoint.AppInstanceStates) {
+			app := datapoint.AppInstanceStates[guid]
+			usage := app.Usage
+			outputCSV = append(outputCSV, []string{guid, strconv.Itoa(app.Desired), strconv.Itoa(app.States["RUNNING"]), strconv.Itoa(app.States["CRASHED"]), strconv.Itoa(app.States["STARTING"]), strconv.Itoa(app.States["DOWN"]),
+				strconv.FormatFloat(usage.CPU, 'f', 4, 64),
+				strconv.FormatInt(usage.MemoryBytes, 10), strconv.FormatInt(usage.MemoryQuotaBytes, 10), strconv.FormatFloat(usage.MemoryPercent(), 'f', 1, 64),
+				strconv.FormatInt(usage.DiskBytes, 10), strconv.FormatInt(usage.DiskQuotaBytes, 10), strconv.FormatFloat(usage.DiskPercent(), 'f', 1, 64)})
+		}
+	}
+
+	if datapoint.Quota != nil {
+		quota := datapoint.Quota
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"QUOTA", quota.Quota.Name, quota.Quota.GUID})
+		outputCSV = append(outputCSV, []string{"resource", "limit", "used", "used_percent"})
+		outputCSV = append(outputCSV, []string{"memory_mb", strconv.Itoa(quota.Quota.MemoryLimitMB), strconv.Itoa(quota.MemoryUsedMB), strconv.FormatFloat(quota.MemoryPercent, 'f', 1, 64)})
+		outputCSV = append(outputCSV, []string{"instances", strconv.Itoa(quota.Quota.InstanceLimit), strconv.Itoa(quota.Instances), strconv.FormatFloat(quota.InstancesPercent, 'f', 1, 64)})
+		outputCSV = append(outputCSV, []string{"routes", strconv.Itoa(quota.Quota.RouteLimit), strconv.Itoa(quota.Routes), strconv.FormatFloat(quota.RoutesPercent, 'f', 1, 64)})
+		outputCSV = append(outputCSV, []string{"service_instances", strconv.Itoa(quota.Quota.ServiceInstanceLimit), strconv.Itoa(quota.ServiceInstances), strconv.FormatFloat(quota.ServiceInstancesPercent, 'f', 1, 64)})
+	}
+
+	if datapoint.AppUsageEvents != nil {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"APP USAGE EVENTS SINCE LAST RUN"})
+		outputCSV = append(outputCSV, countRows(datapoint.AppUsageEvents)...)
+	}
+
+	if datapoint.RoleCounts != nil {
+		outputCSV = append(outputCSV, []string{"\n"})
+		outputCSV = append(outputCSV, []string{"ROLES"})
+		outputCSV = append(outputCSV, countRows(datapoint.RoleCounts)...)
+	}
+
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"BUILDPACKS"})
+	outputCSV = append(outputCSV, countRows(datapoint.BuildpackCounts)...)
+
+	outputCSV = append(outputCSV, []string{"\n"})
+	outputCSV = append(outputCSV, []string{"STACKS"})
+	outputCSV = append(outputCSV, countRows(datapoint.StackCounts)...)
+
 	file, err := os.Create(fileName)
 	if err != nil {
 		return err
@@ -117,7 +326,48 @@ func printAsCSV(fileName string, datapoint cfData) error {
 	return nil
 }
 
-func convertCFAPIResourceToCSVString(resource cfAPIResource) ([]string, error) {
+//printCountsCSV writes titled sections of name,count rows
+func printCountsCSV(fileName string, sections map[string]map[string]int) error {
+	var titles []string
+	for title := range sections {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	for _, title := range titles {
+		rows := append([][]string{{title}}, countRows(sections[title])...)
+		rows = append(rows, []string{"\n"})
+		err = writer.WriteAll(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//countRows turns a map of counts into name,count rows sorted by name
+func countRows(counts map[string]int) [][]string {
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows [][]string
+	for _, name := range names {
+		rows = append(rows, []string{name, strconv.Itoa(counts[name])})
+	}
+	return rows
+}
+
+func convertCFAPIResourceToCSVString(resource cfclient.Resource) ([]string, error) {
 	//turn the interface back into json
 	jsonBytes, err := json.Marshal(resource.Entity)
 	if err != nil {
@@ -142,3 +392,58 @@ func convertCFAPIResourceToCSVString(resource cfAPIResource) ([]string, error) {
 func printProgressBar(iteration int, total int, prefix string, suffix string, decimals int, length int) {
 
 }
+
+//spaceSummaryHeader is the column order for the per-space summary csv. keep it stable,
+//people load this straight into spreadsheets
+var spaceSummaryHeader = []string{"org_name", "org_guid", "space_name", "space_guid", "apps", "instances", "reserved_memory_mb", "foundation"}
+
+//printSpaceSummaryCSV writes one row per space with its org, app count, desired instance
+//count and reserved memory (instances * memory). a fileName of "-" writes to stdout
+func printSpaceSummaryCSV(fileName string, foundation string, orgs []cfclient.Data, spaces []cfclient.Data) error {
+	var out io.Writer = os.Stdout
+	if fileName != "-" {
+		file, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	orgNames := map[string]string{}
+	for _, org := range orgs {
+		orgNames[org.GUID] = org.Name
+	}
+
+	writer := csv.NewWriter(out)
+	err := writer.Write(spaceSummaryHeader)
+	if err != nil {
+		return err
+	}
+	for _, space := range spaces {
+		instances, memory := cfclient.AppTotals(space.Apps)
+		err = writer.Write([]string{
+			orgNames[space.OrganizationGUID],
+			space.OrganizationGUID,
+			space.Name,
+			space.GUID,
+			strconv.Itoa(len(space.Apps)),
+			strconv.Itoa(instances),
+			strconv.Itoa(memory),
+			foundation,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func routeCSVRow(r cfclient.Route) []string {
+	port := ""
+	if r.Port > 0 {
+		port = strconv.Itoa(r.Port)
+	}
+	return []string{r.GUID, r.Host, r.Path, r.DomainGUID, r.Protocol, port, strconv.Itoa(r.Apps)}
+}
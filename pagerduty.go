@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//pagerDutyEvents is the events api v2 endpoint
+const pagerDutyEvents = "https://events.pagerduty.com/v2/enqueue"
+
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+//pagerDutySink triggers an incident for every alert that fires and resolves it with the
+//first run it stops firing in. the dedup key is the alert's rule and what it fired for, so
+//pagerduty keeps one incident per alert however many runs it fires in. which incidents it
+//triggered is only kept in memory, so one that stops firing while cf-metrics isn't running
+//has to be resolved by hand
+type pagerDutySink struct {
+	routingKey string
+	severity   string
+	rules      []alertRule
+	anomalies  crashAnomalies
+	foundation string
+	//triggered is the alerts with an open incident, by dedup key, shared by the runs of a daemon
+	triggered *sync.Map
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string    `json:"summary"`
+	Source        string    `json:"source"`
+	Severity      string    `json:"severity"`
+	Timestamp     time.Time `json:"timestamp"`
+	Component     string    `json:"component,omitempty"`
+	Group         string    `json:"group,omitempty"`
+	Class         string    `json:"class"`
+	CustomDetails alert     `json:"custom_details"`
+}
+
+func (pagerDuty pagerDutySink) name() string {
+	return "pagerduty"
+}
+
+func (pagerDuty pagerDutySink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	firing := map[string]bool{}
+	for _, fired := range evaluateAlerts(pagerDuty.foundation, pagerDuty.rules, pagerDuty.anomalies, result, timestamp) {
+		key := fired.key()
+		firing[key] = true
+		if _, open := pagerDuty.triggered.Load(key); open {
+			continue
+		}
+		err := pagerDuty.send(pagerDutyEvent{
+			RoutingKey:  pagerDuty.routingKey,
+			EventAction: "trigger",
+			DedupKey:    key,
+			Payload: &pagerDutyPayload{
+				Summary:       fired.Text,
+				Source:        pagerDuty.foundation,
+				Severity:      pagerDuty.severity,
+				Timestamp:     fired.FiredAt,
+				Component:     strings.Trim(fired.Space+"/"+fired.App+fired.ServiceInstance, "/"),
+				Group:         fired.Org,
+				Class:         fired.Metric,
+				CustomDetails: fired,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		pagerDuty.triggered.Store(key, true)
+		slog.Warn("pagerduty incident triggered", "rule", fired.Rule, "org", fired.Org, "space", fired.Space, "app", fired.App, "value", fired.Value)
+	}
+
+	var resolved []string
+	pagerDuty.triggered.Range(func(key interface{}, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), pagerDuty.foundation+"|") && !firing[key.(string)] {
+			resolved = append(resolved, key.(string))
+		}
+		return true
+	})
+	for _, key := range resolved {
+		err := pagerDuty.send(pagerDutyEvent{RoutingKey: pagerDuty.routingKey, EventAction: "resolve", DedupKey: key})
+		if err != nil {
+			return err
+		}
+		pagerDuty.triggered.Delete(key)
+		slog.Info("pagerduty incident resolved", "dedup_key", key)
+	}
+	return nil
+}
+
+func (pagerDuty pagerDutySink) send(event pagerDutyEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(pagerDutyEvents, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("pagerduty returned %d to the %s of %s: %s", resp.StatusCode, event.EventAction, event.DedupKey, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//fakePostgres is the server end of a pgConn, for playing the server's side of an exchange
+type fakePostgres struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newFakePostgres(t *testing.T, timeout time.Duration) (*pgConn, *fakePostgres) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return &pgConn{conn: client, reader: bufio.NewReader(client), timeout: timeout}, &fakePostgres{t: t, conn: server, reader: bufio.NewReader(server)}
+}
+
+//readStartup reads the startup message, which has no kind byte
+func (server *fakePostgres) readStartup() {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(server.reader, header)
+	if err == nil {
+		_, err = io.ReadFull(server.reader, make([]byte, binary.BigEndian.Uint32(header)-4))
+	}
+	if err != nil {
+		server.t.Error(err)
+	}
+}
+
+func (server *fakePostgres) read(want byte) []byte {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(server.reader, header)
+	if err != nil {
+		server.t.Error(err)
+		return nil
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:5])-4)
+	_, err = io.ReadFull(server.reader, body)
+	if err != nil {
+		server.t.Error(err)
+	}
+	if header[0] != want {
+		server.t.Errorf("the client should have sent a %q message, it sent %q", want, header[0])
+	}
+	return body
+}
+
+func (server *fakePostgres) send(kind byte, body []byte) {
+	message := binary.BigEndian.AppendUint32([]byte{kind}, uint32(len(body)+4))
+	_, err := server.conn.Write(append(message, body...))
+	if err != nil {
+		server.t.Error(err)
+	}
+}
+
+func (server *fakePostgres) auth(code uint32, data string) {
+	server.send('R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+}
+
+//ready logs the client in and says it's ready for queries
+func (server *fakePostgres) ready() {
+	server.auth(0, "")
+	server.send('Z', []byte{'I'})
+}
+
+func TestPGMD5Auth(t *testing.T) {
+	pg, server := newFakePostgres(t, time.Second)
+	go func() {
+		server.readStartup()
+		server.auth(5, "\xde\xad\xbe\xef")
+		//worked out with python's hashlib, md5 of md5(password + user) in hex plus the salt
+		if got := string(server.read('p')); got != "md53c7ead572d63dd8757e86d8a17a025fb\x00" {
+			t.Errorf("the md5 password should be md53c7ead572d63dd8757e86d8a17a025fb, got %q", got)
+		}
+		server.ready()
+	}()
+	err := pg.startup("cf-metrics", "s3cret", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//scramServer plays the server side of SCRAM-SHA-256 for password, checking the client's
+//proof and signing with forgeSignature in place of the right signature when it's set
+func (server *fakePostgres) scramServer(password string, forgeSignature bool) {
+	server.readStartup()
+	server.auth(10, "SCRAM-SHA-256\x00\x00")
+	initial := server.read('p')
+	mechanism := initial[:bytes.IndexByte(initial, 0)]
+	if string(mechanism) != "SCRAM-SHA-256" {
+		server.t.Errorf("the client should pick SCRAM-SHA-256, it picked %s", mechanism)
+	}
+	clientFirst := string(initial[len(mechanism)+5:])
+	if !strings.HasPrefix(clientFirst, "n,,n=,r=") {
+		server.t.Errorf("the client first message should have no channel binding or user, got %s", clientFirst)
+	}
+	clientFirstBare := strings.TrimPrefix(clientFirst, "n,,")
+	nonce := strings.TrimPrefix(clientFirstBare, "n=,r=") + "server-nonce"
+	salt := []byte("pepper")
+	serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	server.auth(11, serverFirst)
+
+	clientFinal := string(server.read('p'))
+	withoutProof := clientFinal[:strings.Index(clientFinal, ",p=")]
+	if withoutProof != "c=biws,r="+nonce {
+		server.t.Errorf("the client final message should be c=biws,r=%s then the proof, got %s", nonce, clientFinal)
+	}
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[len(withoutProof)+3:])
+	if err != nil {
+		server.t.Error(err)
+	}
+	mac := func(key []byte, message string) []byte {
+		hash := hmac.New(sha256.New, key)
+		hash.Write([]byte(message))
+		return hash.Sum(nil)
+	}
+	salted, _ := pbkdf2.Key(sha256.New, password, salt, 4096, sha256.Size)
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	storedKey := sha256.Sum256(mac(salted, "Client Key"))
+	signature := mac(storedKey[:], authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ signature[i]
+	}
+	if recovered := sha256.Sum256(clientKey); !hmac.Equal(recovered[:], storedKey[:]) {
+		server.t.Error("the client's proof doesn't match the password")
+	}
+
+	serverSignature := mac(mac(salted, "Server Key"), authMessage)
+	if forgeSignature {
+		serverSignature = mac([]byte("not the password"), authMessage)
+	}
+	server.auth(12, "v="+base64.StdEncoding.EncodeToString(serverSignature))
+	if !forgeSignature {
+		server.ready()
+	}
+}
+
+func TestPGScramAuth(t *testing.T) {
+	pg, server := newFakePostgres(t, time.Second)
+	go server.scramServer("pencil", false)
+	err := pg.startup("cf-metrics", "pencil", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPGScramChecksTheServer(t *testing.T) {
+	pg, server := newFakePostgres(t, time.Second)
+	go server.scramServer("pencil", true)
+	err := pg.startup("cf-metrics", "pencil", "metrics")
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("a server that doesn't know the password should fail the login, got %v", err)
+	}
+}
+
+func TestPGBadMessagesAreErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		message []byte
+	}{
+		{"a length shorter than itself", []byte{'Z', 0, 0, 0, 3}},
+		{"a length past the biggest message", []byte{'Z', 0xff, 0xff, 0xff, 0xff}},
+		{"a data row without its column's length", []byte{'D', 0, 0, 0, 7, 0, 1, 0}},
+		{"a data row with a column longer than it", []byte{'D', 0, 0, 0, 12, 0, 1, 0, 0, 0, 9, 'a', 'b'}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pg, server := newFakePostgres(t, time.Second)
+			go func() {
+				server.read('Q')
+				server.conn.Write(test.message)
+			}()
+			_, err := pg.exec("SELECT 1")
+			if err == nil {
+				t.Fatal("the bad message should be an error")
+			}
+		})
+	}
+}
+
+func TestPGEveryStatementGetsTheTimeout(t *testing.T) {
+	pg, server := newFakePostgres(t, 300*time.Millisecond)
+	//what dialPostgres leaves the connection with after logging in
+	pg.conn.SetDeadline(time.Now().Add(pg.timeout))
+	go func() {
+		//each statement takes most of the timeout, both together take longer than it
+		for index := 0; index < 2; index++ {
+			server.read('Q')
+			time.Sleep(200 * time.Millisecond)
+			server.send('D', []byte{0, 1, 0, 0, 0, 1, '1'})
+			server.send('Z', []byte{'I'})
+		}
+	}()
+	for index := 0; index < 2; index++ {
+		values, err := pg.exec("SELECT 1")
+		if err != nil {
+			t.Fatalf("statement %d: %s", index+1, err)
+		}
+		if len(values) != 1 || values[0] != "1" {
+			t.Errorf("statement %d should have come back with 1, got %v", index+1, values)
+		}
+	}
+}
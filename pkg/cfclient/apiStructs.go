@@ -1,4 +1,4 @@
-package main
+package cfclient
 
 //it ended up being easier to use generic interfaces for the json,
 //but this is what the endpoints return should I/you find it useful
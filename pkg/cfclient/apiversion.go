@@ -0,0 +1,299 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	APIV2 = "v2"
+	APIV3 = "v3"
+)
+
+//detectAPIVersion asks the api root which cloud controller apis it serves. v2 is preferred
+//while it's still around since that's what most of the collection is written against
+func (client *Client) detectAPIVersion(ctx context.Context) (string, error) {
+	var root struct {
+		Links map[string]*struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	resp, err := client.doGetRequest(ctx, "/")
+	if err != nil {
+		return "", err
+	}
+	err = decodeBody(resp, &root)
+	if err != nil {
+		return "", err
+	}
+
+	if root.Links["cloud_controller_v2"] != nil {
+		return APIV2, nil
+	}
+	if root.Links["cloud_controller_v3"] != nil {
+		return APIV3, nil
+	}
+	return "", fmt.Errorf("api root at %s doesn't advertise a v2 or v3 cloud controller", client.apiURL)
+}
+
+//v3ListResponse is the shape of every v3 list endpoint
+type v3ListResponse struct {
+	Pagination struct {
+		TotalResults int `json:"total_results"`
+		TotalPages   int `json:"total_pages"`
+		Next         *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []map[string]interface{} `json:"resources"`
+}
+
+//toCFAPIResponse reshapes a v3 list into the v2 style response the rest of the client
+//works with. v3 resources are flat so the whole resource becomes the entity, with the
+//guid and timestamps copied into the metadata
+func (list v3ListResponse) toCFAPIResponse() APIResponse {
+	response := APIResponse{
+		TotalResults: list.Pagination.TotalResults,
+		TotalPages:   list.Pagination.TotalPages,
+	}
+	if list.Pagination.Next != nil {
+		response.NextURL = relativeURL(list.Pagination.Next.Href)
+	}
+	for _, raw := range list.Resources {
+		response.Resources = append(response.Resources, v3Resource(raw))
+	}
+	return response
+}
+
+//v3Resource wraps a single flat v3 resource the same way
+func v3Resource(raw map[string]interface{}) Resource {
+	resource := Resource{Entity: raw}
+	resource.Metadata.GUID, _ = raw["guid"].(string)
+	resource.Metadata.URL = relativeURL(nestedString(raw, "links", "self", "href"))
+	if createdAt, ok := raw["created_at"].(string); ok {
+		resource.Metadata.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	}
+	if updatedAt, ok := raw["updated_at"].(string); ok {
+		resource.Metadata.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	}
+	return resource
+}
+
+//isV3Endpoint is true for anything under /v3, which comes back in the v3 shape no matter
+//which api version the rest of the collection is using
+func isV3Endpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "/v3/")
+}
+
+//eventsEndpoint is the audit event list for one event type, waiting on an org or space guid
+//on the end. scope is "organization" or "space"
+func (client *Client) eventsEndpoint(eventType string, scope string) string {
+	return client.eventsEndpointFrom(eventType, scope, client.eventsSince)
+}
+
+//eventsEndpointFrom is eventsEndpoint with the window starting at since instead
+func (client *Client) eventsEndpointFrom(eventType string, scope string, since time.Time) string {
+	if client.apiVersion == APIV3 {
+		return "/v3/audit_events?types=" + eventType + client.eventWindowQueryFrom(since) + "&" + scope + "_guids="
+	}
+	return "/v2/events?q=type:" + eventType + client.eventWindowQueryFrom(since) + "&q=" + scope + "_guid:"
+}
+
+//eventListEndpoint is the foundation wide audit event list for the given types, every type
+//when there are none, inside the event window
+func (client *Client) eventListEndpoint(eventTypes []string) string {
+	query := client.eventWindowQuery()
+	if client.apiVersion == APIV3 {
+		if len(eventTypes) > 0 {
+			query = "&types=" + strings.Join(eventTypes, ",") + query
+		}
+		return "/v3/audit_events?" + strings.TrimPrefix(query, "&")
+	}
+	if len(eventTypes) > 0 {
+		query = "&q=" + url.QueryEscape("type IN "+strings.Join(eventTypes, ",")) + query
+	}
+	return "/v2/events?" + strings.TrimPrefix(query, "&")
+}
+
+//eventWindowQuery is the timestamp filter for events between client.eventsSince and
+//client.eventsUntil, with a leading & when there's anything to filter on
+func (client *Client) eventWindowQuery() string {
+	return client.eventWindowQueryFrom(client.eventsSince)
+}
+
+//eventWindowQueryFrom is eventWindowQuery with the window starting at from instead
+func (client *Client) eventWindowQueryFrom(from time.Time) string {
+	var query string
+	if !from.IsZero() {
+		since := url.QueryEscape(from.UTC().Format(time.RFC3339))
+		if client.apiVersion == APIV3 {
+			query += "&created_ats[gte]=" + since
+		} else {
+			query += "&q=timestamp>=" + since
+		}
+	}
+	if !client.eventsUntil.IsZero() {
+		until := url.QueryEscape(client.eventsUntil.UTC().Format(time.RFC3339))
+		if client.apiVersion == APIV3 {
+			query += "&created_ats[lt]=" + until
+		} else {
+			query += "&q=timestamp<" + until
+		}
+	}
+	return query
+}
+
+//createdWindowQuery limits a v3 list to what was created inside the event window, for v3
+//only lists like tasks and deployments where eventWindowQuery's v2 filter never applies
+func (client *Client) createdWindowQuery() string {
+	var query string
+	if !client.eventsSince.IsZero() {
+		query += "&created_ats[gte]=" + url.QueryEscape(client.eventsSince.UTC().Format(time.RFC3339))
+	}
+	if !client.eventsUntil.IsZero() {
+		query += "&created_ats[lt]=" + url.QueryEscape(client.eventsUntil.UTC().Format(time.RFC3339))
+	}
+	return query
+}
+
+//appsEndpoint is the app list waiting on an org or space guid on the end
+func (client *Client) appsEndpoint(scope string) string {
+	if client.apiVersion == APIV3 {
+		query := labelSelectorQuery(client.filter.AppLabelSelector)
+		if query != "" {
+			query += "&"
+		}
+		return "/v3/apps?" + query + scope + "_guids="
+	}
+	return "/v2/apps?q=" + scope + "_guid:"
+}
+
+//relativeURL strips the scheme and host off a v3 link so it can be requested like a v2
+//next_url
+func relativeURL(href string) string {
+	parsed, err := url.Parse(href)
+	if err != nil || parsed.Path == "" {
+		return href
+	}
+	return parsed.RequestURI()
+}
+
+//nestedString digs a string out of nested json objects, e.g.
+//relationships.organization.data.guid
+func nestedString(m map[string]interface{}, keys ...string) string {
+	value, _ := nestedValue(m, keys...).(string)
+	return value
+}
+
+//nestedInt is nestedString for numbers, 0 when the path isn't there or is null
+func nestedInt(m map[string]interface{}, keys ...string) int {
+	value, _ := nestedValue(m, keys...).(float64)
+	return int(value)
+}
+
+//nestedValue is nestedString for any type, nil when the path isn't there
+func nestedValue(m map[string]interface{}, keys ...string) interface{} {
+	var current interface{} = m
+	for _, key := range keys {
+		currentMap, isMap := current.(map[string]interface{})
+		if !isMap {
+			return nil
+		}
+		current = currentMap[key]
+	}
+	return current
+}
+
+//namesQuery is the v3 version of nameQuery, v3 filters on a names= list
+func namesQuery(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	for _, name := range names {
+		if guidPattern.MatchString(name) || isPattern(name) || strings.Contains(name, ",") {
+			return ""
+		}
+	}
+	return "?names=" + url.QueryEscape(strings.Join(names, ","))
+}
+
+func (client *Client) getOrgsV3(ctx context.Context) ([]Data, error) {
+	resources, err := client.Resources(ctx, "/v3/organizations"+namesQuery(client.filter.IncludeOrgs))
+	if err != nil {
+		return nil, err
+	}
+	var orgs []Data
+	for _, resource := range resources {
+		name := EntityString(resource, "name")
+		client.lookups.set("org", resource.Metadata.GUID, name)
+		if !client.filter.allowOrg(name, resource.Metadata.GUID) {
+			continue
+		}
+		entity, _ := resource.Entity.(map[string]interface{})
+		orgs = append(orgs, Data{
+			Name:      name,
+			GUID:      resource.Metadata.GUID,
+			QuotaGUID: nestedString(entity, "relationships", "quota", "data", "guid"),
+			Labels:    client.labelTagValues(entity),
+		})
+	}
+	return orgs, nil
+}
+
+//getSpacesV3 lists spaces, query can narrow it down e.g. organization_guids=
+func (client *Client) getSpacesV3(ctx context.Context, query string) ([]Data, error) {
+	endpoint := withQuery("/v3/spaces"+namesQuery(client.filter.IncludeSpaces), query)
+	endpoint = withQuery(endpoint, labelSelectorQuery(client.filter.SpaceLabelSelector))
+	resources, err := client.Resources(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var spaces []Data
+	for _, resource := range resources {
+		name := EntityString(resource, "name")
+		client.lookups.set("space", resource.Metadata.GUID, name)
+		if !client.filter.allowSpace(name) {
+			continue
+		}
+		entity, _ := resource.Entity.(map[string]interface{})
+		spaces = append(spaces, Data{
+			Name:             name,
+			GUID:             resource.Metadata.GUID,
+			OrganizationGUID: nestedString(entity, "relationships", "organization", "data", "guid"),
+			QuotaGUID:        nestedString(entity, "relationships", "quota", "data", "guid"),
+			Labels:           client.labelTagValues(entity),
+		})
+	}
+	return spaces, nil
+}
+
+//labelTagValues are the values of the Config.LabelTags labels a v3 org or space has, nil
+//when it has none of them
+func (client *Client) labelTagValues(entity map[string]interface{}) map[string]string {
+	var labels map[string]string
+	for _, key := range client.labelTags {
+		value, found := nestedValue(entity, "metadata", "labels", key).(string)
+		if !found {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+//Resources fetches every page of a list endpoint
+func (client *Client) Resources(ctx context.Context, endpoint string) ([]Resource, error) {
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, endpoint, &response)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := client.cfResourcesFromResponse(ctx, response)
+	return resources, client.warnIfTruncated(err, "listing "+endpoint)
+}
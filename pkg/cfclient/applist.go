@@ -0,0 +1,69 @@
+package cfclient
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+//SpaceApp is one app of a space the way cf apps shows it, with what it runs on
+type SpaceApp struct {
+	GUID      string `json:"guid"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Instances int    `json:"instances"`
+	MemoryMB  int    `json:"memory_mb"`
+	Stack     string `json:"stack"`
+	Buildpack string `json:"buildpack"`
+	//LastPush is v2's package_updated_at, or when v3's current droplet was staged. the zero
+	//time when the app was never staged
+	LastPush time.Time `json:"last_push"`
+}
+
+//SpaceApps lists the apps of a space, sorted by name. v3 apps don't carry instances and
+//memory, those are the web process's, see foldWebProcesses, and the last push takes a call
+//per app
+func (client *Client) SpaceApps(ctx context.Context, space Data) ([]SpaceApp, error) {
+	resources, err := client.Resources(ctx, client.appsEndpoint("space")+space.GUID)
+	if err != nil {
+		return nil, err
+	}
+	if client.apiVersion == APIV3 {
+		web, err := client.webProcesses(ctx, "space", space.GUID)
+		if err != nil {
+			return nil, err
+		}
+		foldWebProcess(resources, web)
+	}
+
+	var apps []SpaceApp
+	for _, resource := range resources {
+		app := SpaceApp{
+			GUID:      resource.Metadata.GUID,
+			Name:      client.redactor.appName(EntityString(resource, "name")),
+			State:     EntityString(resource, "state"),
+			Instances: EntityInt(resource, "instances"),
+			MemoryMB:  EntityInt(resource, "memory"),
+			Stack:     client.AppStack(ctx, resource),
+			Buildpack: appBuildpack(resource),
+		}
+		if pushed, err := time.Parse(time.RFC3339, EntityString(resource, "package_updated_at")); err == nil {
+			app.LastPush = pushed
+		}
+		if client.apiVersion == APIV3 {
+			if entity, isMap := resource.Entity.(map[string]interface{}); isMap && app.Buildpack == "unknown" {
+				//v3 apps list their buildpacks under lifecycle
+				if names, isList := nestedValue(entity, "lifecycle", "data", "buildpacks").([]interface{}); isList && len(names) > 0 {
+					app.Buildpack, _ = names[0].(string)
+				}
+			}
+			app.LastPush, err = client.AppLastStaged(ctx, app.GUID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		apps = append(apps, app)
+	}
+	sort.SliceStable(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps, nil
+}
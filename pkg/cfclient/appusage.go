@@ -0,0 +1,142 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+//usageCursor is what gets written to the usage cursor file between runs
+type usageCursor struct {
+	Target    string    `json:"target"`
+	AfterGUID string    `json:"after_guid"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func readUsageCursor(path string, target string) (string, bool) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var cursor usageCursor
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil || cursor.Target != target || cursor.AfterGUID == "" {
+		return "", false
+	}
+	return cursor.AfterGUID, true
+}
+
+func writeUsageCursor(path string, target string, guid string) error {
+	raw, err := json.Marshal(usageCursor{Target: target, AfterGUID: guid, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+//usageEvent is the bit of an app usage event we count, from either api version
+type usageEvent struct {
+	state     string
+	spaceGUID string
+	orgGUID   string
+}
+
+func usageEventFromResource(resource Resource) usageEvent {
+	entity, _ := resource.Entity.(map[string]interface{})
+	if _, isV3 := entity["state"].(map[string]interface{}); isV3 {
+		return usageEvent{
+			state:     nestedString(entity, "state", "current"),
+			spaceGUID: nestedString(entity, "space", "guid"),
+			orgGUID:   nestedString(entity, "organization", "guid"),
+		}
+	}
+	return usageEvent{
+		state:     EntityString(resource, "state"),
+		spaceGUID: EntityString(resource, "space_guid"),
+		orgGUID:   EntityString(resource, "org_guid"),
+	}
+}
+
+//getAppUsageEvents counts the app usage events (STARTED, STOPPED, BUILDPACK_SET...) since the
+//last run by state into each org and space, then moves the cursor on. with no cursor yet
+//it only records where the newest event is, so the first run counts nothing instead of
+//paging through the platform's whole history
+func (client *Client) getAppUsageEvents(ctx context.Context, orgs []Data, spaces []Data) []CollectionError {
+	endpoint := "/v2/app_usage_events"
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/app_usage_events"
+	}
+	target := client.apiURL.String()
+	failed := func(doing string, err error) []CollectionError {
+		return []CollectionError{{Name: "foundation", Doing: doing, Err: err}}
+	}
+
+	afterGUID, found := readUsageCursor(client.usageCursorPath, target)
+	if !found {
+		newest, err := client.newestUsageEvent(ctx, endpoint)
+		if err != nil {
+			return failed("finding the newest app usage event", err)
+		}
+		if newest != "" {
+			err = writeUsageCursor(client.usageCursorPath, target, newest)
+			if err != nil {
+				return failed("saving the app usage cursor", err)
+			}
+		}
+		return nil
+	}
+
+	orgIndex := map[string]int{}
+	for index := range orgs {
+		orgs[index].AppUsageEvents = map[string]int{}
+		orgIndex[orgs[index].GUID] = index
+	}
+	spaceIndex := map[string]int{}
+	for index := range spaces {
+		spaces[index].AppUsageEvents = map[string]int{}
+		spaceIndex[spaces[index].GUID] = index
+	}
+
+	lastGUID := afterGUID
+	err := client.EachResource(ctx, endpoint+"?after_guid="+afterGUID, func(resource Resource) error {
+		event := usageEventFromResource(resource)
+		if index, exists := spaceIndex[event.spaceGUID]; exists {
+			spaces[index].AppUsageEvents[event.state]++
+		}
+		if index, exists := orgIndex[event.orgGUID]; exists {
+			orgs[index].AppUsageEvents[event.state]++
+		}
+		lastGUID = resource.Metadata.GUID
+		return nil
+	})
+	if err != nil {
+		return failed("collecting app usage events", err)
+	}
+
+	if lastGUID != afterGUID {
+		err = writeUsageCursor(client.usageCursorPath, target, lastGUID)
+		if err != nil {
+			return failed("saving the app usage cursor", fmt.Errorf("%s, the next run will count these events again", err))
+		}
+	}
+	return nil
+}
+
+//newestUsageEvent is the guid of the most recent app usage event, empty if there are none
+func (client *Client) newestUsageEvent(ctx context.Context, endpoint string) (string, error) {
+	query := "?order-direction=desc&results-per-page=1"
+	if client.apiVersion == APIV3 {
+		query = "?order_by=-created_at&per_page=1"
+	}
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, endpoint+query, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Resources) == 0 {
+		return "", nil
+	}
+	return response.Resources[0].Metadata.GUID, nil
+}
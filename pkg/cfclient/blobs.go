@@ -0,0 +1,190 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//DefaultLargeDropletMB is the droplet size an app is flagged at when Config.LargeDropletMB
+//isn't set
+const DefaultLargeDropletMB = 512
+
+//AppBlobs is what an app keeps in the blobstore: every staged droplet and ready package the
+//cloud controller still has of it, not just the current ones
+type AppBlobs struct {
+	AppGUID             string `json:"app_guid"`
+	AppName             string `json:"app_name"`
+	Droplets            int    `json:"droplets"`
+	DropletBytes        int64  `json:"droplet_bytes"`
+	LargestDropletBytes int64  `json:"largest_droplet_bytes"`
+	Packages            int    `json:"packages"`
+	PackageBytes        int64  `json:"package_bytes"`
+	//Large is set when the largest droplet is over Config.LargeDropletMB
+	Large bool `json:"large"`
+}
+
+//Bytes is everything the app keeps in the blobstore
+func (blobs AppBlobs) Bytes() int64 {
+	return blobs.DropletBytes + blobs.PackageBytes
+}
+
+//BlobstoreFootprint is what some apps add up to in the blobstore
+type BlobstoreFootprint struct {
+	DropletBytes int64 `json:"droplet_bytes"`
+	PackageBytes int64 `json:"package_bytes"`
+	LargeApps    int   `json:"large_droplet_apps"`
+}
+
+//SumBlobs adds up the blobs of every app in spaces
+func SumBlobs(spaces []Data) BlobstoreFootprint {
+	var footprint BlobstoreFootprint
+	for _, space := range spaces {
+		for _, blobs := range space.Blobs {
+			footprint.DropletBytes += blobs.DropletBytes
+			footprint.PackageBytes += blobs.PackageBytes
+			if blobs.Large {
+				footprint.LargeApps++
+			}
+		}
+	}
+	return footprint
+}
+
+//OrgBlobstoreFootprints is SumBlobs per org guid
+func OrgBlobstoreFootprints(spaces []Data) map[string]BlobstoreFootprint {
+	byOrg := map[string][]Data{}
+	for _, space := range spaces {
+		byOrg[space.OrganizationGUID] = append(byOrg[space.OrganizationGUID], space)
+	}
+	footprints := map[string]BlobstoreFootprint{}
+	for guid, orgSpaces := range byOrg {
+		footprints[guid] = SumBlobs(orgSpaces)
+	}
+	return footprints
+}
+
+//getBlobs sizes every staged droplet and ready package in each space. the api doesn't say
+//how big they are, so it's a ranged download of one byte of each, which the blobstore
+//answers with the whole size. that's a call per droplet and package, so it only runs with
+//Config.BlobSizes. a foundation without the v3 api has none
+func (client *Client) getBlobs(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "sizing droplets and packages"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		if len(spaces[index].Apps) == 0 {
+			return
+		}
+		byApp := map[string]*AppBlobs{}
+		for _, app := range spaces[index].Apps {
+			byApp[app.Metadata.GUID] = &AppBlobs{AppGUID: app.Metadata.GUID, AppName: EntityString(app, "name")}
+		}
+		for _, kind := range []string{"droplets", "packages"} {
+			state := "STAGED"
+			if kind == "packages" {
+				state = "READY"
+			}
+			resources, err := client.Resources(ctx, "/v3/"+kind+"?states="+state+"&space_guids="+spaces[index].GUID)
+			if IsNotFound(err) {
+				return
+			}
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+				return
+			}
+			for _, resource := range resources {
+				entity, _ := resource.Entity.(map[string]interface{})
+				blobs := byApp[nestedString(entity, "relationships", "app", "data", "guid")]
+				//docker droplets and packages are an image reference, nothing in the blobstore
+				if blobs == nil || EntityString(resource, "type") == "docker" || nestedString(entity, "lifecycle", "type") == "docker" {
+					continue
+				}
+				size, err := client.downloadSize(ctx, "/v3/"+kind+"/"+resource.Metadata.GUID+"/download")
+				if IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], strings.TrimSpace(whatYoureDoing)+" for app "+blobs.AppName, err))
+					continue
+				}
+				if kind == "droplets" {
+					blobs.Droplets++
+					blobs.DropletBytes += size
+					blobs.LargestDropletBytes = max(blobs.LargestDropletBytes, size)
+				} else {
+					blobs.Packages++
+					blobs.PackageBytes += size
+				}
+			}
+		}
+		var sized []AppBlobs
+		for _, blobs := range byApp {
+			if blobs.Droplets == 0 && blobs.Packages == 0 {
+				continue
+			}
+			blobs.Large = blobs.LargestDropletBytes > client.largeDropletMB*1024*1024
+			if blobs.Large {
+				client.log.Warn("app has a large droplet", "space", spaces[index].Name, "app", blobs.AppName, "droplet_mb", blobs.LargestDropletBytes/(1024*1024))
+			}
+			sized = append(sized, *blobs)
+		}
+		sort.Slice(sized, func(i, j int) bool { return sized[i].Bytes() > sized[j].Bytes() })
+		spaces[index].Blobs = sized
+	})
+	return flattenFailures(perIndex)
+}
+
+//downloadSize is how big the blob a download endpoint redirects to is, asking for only its
+//first byte. the blobstore's Content-Range has the whole size, one that ignores the range
+//and sends it all is cut off after the headers
+func (client *Client) downloadSize(ctx context.Context, endpoint string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.apiURL.String()+endpoint, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error forming http GET request: %s", err)
+	}
+	req.Header.Add("Authorization", client.freshToken(ctx))
+	req.Header.Add("Range", "bytes=0-0")
+	err = client.rateLimit.wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&client.counters.requests, 1)
+	span := startSpan(ctx, req, endpoint)
+	started := time.Now()
+	resp, err := client.httpClient.Do(req)
+	client.traceRequest(req, resp, started, err)
+	client.endpoints.request(endpoint, time.Since(started), err != nil || resp.StatusCode/100 != 2, span.end(resp, err))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, newAPIError(resp, endpoint, nil)
+	}
+	if contentRange := resp.Header.Get("Content-Range"); resp.StatusCode == http.StatusPartialContent && contentRange != "" {
+		size, err := strconv.ParseInt(contentRange[strings.LastIndex(contentRange, "/")+1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("the blobstore's Content-Range %q for %s has no size", contentRange, endpoint)
+		}
+		return size, nil
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("the blobstore didn't say how big %s is", endpoint)
+	}
+	return resp.ContentLength, nil
+}
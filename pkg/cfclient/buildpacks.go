@@ -0,0 +1,221 @@
+package cfclient
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Buildpack is an admin buildpack installed on the foundation
+type Buildpack struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Stack    string `json:"stack,omitempty"`
+	Filename string `json:"filename"`
+	//Version is what the buildpack's filename says it is, e.g. 1.9.2 from
+	//go_buildpack-cached-cflinuxfs4-v1.9.2.zip, empty when it doesn't say
+	Version   string    `json:"version,omitempty"`
+	Position  int       `json:"position"`
+	Enabled   bool      `json:"enabled"`
+	Locked    bool      `json:"locked"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+//buildpackVersionPattern finds a version in a buildpack filename or an app's detected
+//buildpack, e.g. v1.9.2 or java-buildpack=v4.50-https://...
+var buildpackVersionPattern = regexp.MustCompile(`v?(\d+(?:\.\d+)+)`)
+
+func buildpackVersion(s string) string {
+	match := buildpackVersionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+//CompareVersions compares dotted versions numerically, -1 when a is older than b, 1 when it's
+//newer and 0 when they're the same. missing parts count as 0, so 1.2 and 1.2.0 are equal
+func CompareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for index := 0; index < len(aParts) || index < len(bParts); index++ {
+		var aPart, bPart int
+		if index < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[index])
+		}
+		if index < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[index])
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+//getBuildpacks lists the admin buildpacks. v2 and v3 buildpacks have the same fields
+func (client *Client) getBuildpacks(ctx context.Context) ([]Buildpack, error) {
+	endpoint := "/v2/buildpacks"
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/buildpacks"
+	}
+	resources, err := client.Resources(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var buildpacks []Buildpack
+	for _, resource := range resources {
+		entity, _ := resource.Entity.(map[string]interface{})
+		enabled, _ := entity["enabled"].(bool)
+		locked, _ := entity["locked"].(bool)
+		filename := EntityString(resource, "filename")
+		buildpacks = append(buildpacks, Buildpack{
+			GUID:      resource.Metadata.GUID,
+			Name:      EntityString(resource, "name"),
+			Stack:     EntityString(resource, "stack"),
+			Filename:  filename,
+			Version:   buildpackVersion(filename),
+			Position:  EntityInt(resource, "position"),
+			Enabled:   enabled,
+			Locked:    locked,
+			UpdatedAt: resource.Metadata.UpdatedAt,
+		})
+	}
+	return buildpacks, nil
+}
+
+//AppBuildpack is what an app was staged with compared to what's installed now
+type AppBuildpack struct {
+	//Installed is the admin buildpack the app uses, nil for a custom one, e.g. a git url
+	Installed *Buildpack
+	//Version is the version the app was staged with, if the detected buildpack says
+	Version string
+	//Outdated is true when the app was staged with an older version than the installed one,
+	//or, without versions to go by, before the installed one was last updated
+	Outdated bool
+}
+
+//MatchAppBuildpack finds the installed buildpack an app was staged with: by the guid v2
+//records, otherwise by name, preferring one for the app's stack. a buildpack that was
+//deleted and installed again under the same name has a new guid, and every app staged with
+//the old one is outdated. stagedAt is when the app's droplet was staged, the zero time when
+//that isn't known
+func MatchAppBuildpack(app Resource, stack string, stagedAt time.Time, buildpacks []Buildpack) AppBuildpack {
+	var match AppBuildpack
+	detectedGUID := EntityString(app, "detected_buildpack_guid")
+	for index, buildpack := range buildpacks {
+		if detectedGUID != "" && buildpack.GUID == detectedGUID {
+			match.Installed = &buildpacks[index]
+			break
+		}
+	}
+	replaced := false
+	if match.Installed == nil {
+		name := appBuildpack(app)
+		if entity, isMap := app.Entity.(map[string]interface{}); isMap && name == "unknown" {
+			//v3 apps list their buildpacks under lifecycle
+			if names, isList := nestedValue(entity, "lifecycle", "data", "buildpacks").([]interface{}); isList && len(names) > 0 {
+				name, _ = names[0].(string)
+			}
+		}
+		for index, buildpack := range buildpacks {
+			if buildpack.Name == name && (match.Installed == nil || buildpack.Stack == stack) {
+				match.Installed = &buildpacks[index]
+			}
+		}
+		replaced = match.Installed != nil && detectedGUID != ""
+	}
+	if match.Installed == nil {
+		return match
+	}
+
+	match.Version = buildpackVersion(EntityString(app, "detected_buildpack"))
+	if replaced {
+		match.Outdated = true
+	} else if match.Version != "" && match.Installed.Version != "" {
+		match.Outdated = CompareVersions(match.Version, match.Installed.Version) < 0
+	} else if !stagedAt.IsZero() {
+		match.Outdated = stagedAt.Before(match.Installed.UpdatedAt)
+	}
+	return match
+}
+
+//AppStack is the name of the stack an app runs on, "unknown" when it can't be resolved
+func (client *Client) AppStack(ctx context.Context, app Resource) string {
+	stack, err := client.appStack(ctx, app)
+	if err != nil {
+		return "unknown"
+	}
+	return stack
+}
+
+//appBuildpack is the buildpack an app was pushed with, or the one cf detected for it if no
+//buildpack was given
+func appBuildpack(app Resource) string {
+	if buildpack := EntityString(app, "buildpack"); buildpack != "" {
+		return buildpack
+	}
+	if buildpack := EntityString(app, "detected_buildpack"); buildpack != "" {
+		return buildpack
+	}
+	return "unknown"
+}
+
+//appStack resolves an app's stack guid to the stack name (e.g. cflinuxfs3). there are only
+//a handful of stacks so these are almost always cache hits. v3 apps name theirs already
+func (client *Client) appStack(ctx context.Context, app Resource) (string, error) {
+	if entity, isMap := app.Entity.(map[string]interface{}); isMap {
+		if stack := nestedString(entity, "lifecycle", "data", "stack"); stack != "" {
+			return stack, nil
+		}
+	}
+	stackGUID := EntityString(app, "stack_guid")
+	if stackGUID == "" {
+		return "unknown", nil
+	}
+	return client.lookupName("stack", stackGUID, func() (string, error) {
+		stack, err := client.getResource(ctx, "/v2/stacks/"+stackGUID)
+		if err != nil {
+			return "", err
+		}
+		return EntityString(stack, "name"), nil
+	})
+}
+
+//countBuildpacksAndStacks fills in the number of apps per buildpack and per stack for each
+//org/space from its already collected apps. apps whose stack can't be resolved are counted
+//as "unknown"
+func (client *Client) countBuildpacksAndStacks(ctx context.Context, dataList []Data) []CollectionError {
+	var failures []CollectionError
+	for index, datapoint := range dataList {
+		buildpacks := map[string]int{}
+		stacks := map[string]int{}
+		for _, app := range datapoint.Apps {
+			buildpacks[appBuildpack(app)]++
+			stack, err := client.appStack(ctx, app)
+			if err != nil {
+				failures = append(failures, newCollectionError(datapoint, "resolving the stack of app "+EntityString(app, "name"), err))
+				stack = "unknown"
+			}
+			stacks[stack]++
+		}
+		dataList[index].BuildpackCounts = buildpacks
+		dataList[index].StackCounts = stacks
+	}
+	return failures
+}
+
+//SumCounts adds up per org/space counts into foundation wide totals
+func SumCounts(counts ...map[string]int) map[string]int {
+	total := map[string]int{}
+	for _, count := range counts {
+		for key, value := range count {
+			total[key] += value
+		}
+	}
+	return total
+}
@@ -0,0 +1,195 @@
+package cfclient
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//buildBatch is how many app guids go in one /v3/builds?app_guids= query, like
+//deploymentBatch
+const buildBatch = 50
+
+//build states, as v3 has them
+const (
+	BuildStaging = "STAGING"
+	BuildStaged  = "STAGED"
+	BuildFailed  = "FAILED"
+)
+
+//BuildStateList is every build state, in the order a build moves through them
+var BuildStateList = []string{BuildStaging, BuildStaged, BuildFailed}
+
+//Build is a v3 build, one staging of one of an app's packages into a droplet
+type Build struct {
+	GUID    string `json:"guid"`
+	AppGUID string `json:"app_guid"`
+	State   string `json:"state"`
+	//Buildpack is the first buildpack the build asked for, the app's buildpack when it let cf
+	//detect one, docker for docker apps and unknown when neither says
+	Buildpack string    `json:"buildpack"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+//Duration is how long a finished build staged for, from being created to its last update,
+//and 0 for one still staging
+func (build Build) Duration() time.Duration {
+	if build.State != BuildStaged && build.State != BuildFailed {
+		return 0
+	}
+	return build.UpdatedAt.Sub(build.CreatedAt)
+}
+
+//BuildSummary is what some builds came to
+type BuildSummary struct {
+	//States counts the builds in each state
+	States map[string]int `json:"states"`
+	//P50 and P95 are over the finished builds, failed ones included
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+}
+
+//Builds is how many builds there are in every state
+func (summary BuildSummary) Builds() int {
+	total := 0
+	for _, count := range summary.States {
+		total += count
+	}
+	return total
+}
+
+//getBuilds lists the builds of each space's apps created in the event window, a batch of
+//apps at a time. a foundation without the v3 api has no builds
+func (client *Client) getBuilds(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering builds in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		builds, err := client.spaceBuilds(ctx, spaces[index].Apps)
+		if IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+			return
+		}
+		spaces[index].Builds = builds
+	})
+	return flattenFailures(perIndex)
+}
+
+//spaceBuilds lists the builds of apps, naming each one's buildpack
+func (client *Client) spaceBuilds(ctx context.Context, apps []Resource) ([]Build, error) {
+	appBuildpacks := map[string]string{}
+	for _, app := range apps {
+		appBuildpacks[app.Metadata.GUID] = appBuildpack(app)
+		if entity, isMap := app.Entity.(map[string]interface{}); isMap && appBuildpacks[app.Metadata.GUID] == "unknown" {
+			if buildpack := lifecycleBuildpack(entity); buildpack != "" {
+				appBuildpacks[app.Metadata.GUID] = buildpack
+			}
+		}
+	}
+
+	var builds []Build
+	for start := 0; start < len(apps); start += buildBatch {
+		var guids []string
+		for _, app := range apps[start:min(start+buildBatch, len(apps))] {
+			guids = append(guids, app.Metadata.GUID)
+		}
+		resources, err := client.Resources(ctx, "/v3/builds?app_guids="+strings.Join(guids, ",")+client.createdWindowQuery())
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			entity, _ := resource.Entity.(map[string]interface{})
+			build := Build{
+				GUID:      resource.Metadata.GUID,
+				AppGUID:   nestedString(entity, "relationships", "app", "data", "guid"),
+				State:     EntityString(resource, "state"),
+				Buildpack: lifecycleBuildpack(entity),
+				Error:     EntityString(resource, "error"),
+				CreatedAt: resource.Metadata.CreatedAt,
+				UpdatedAt: resource.Metadata.UpdatedAt,
+			}
+			if build.AppGUID == "" {
+				//older cloud controllers link the app instead of relating it
+				build.AppGUID = nestedString(entity, "app", "guid")
+			}
+			if build.Buildpack == "" {
+				build.Buildpack = appBuildpacks[build.AppGUID]
+			}
+			if build.Buildpack == "" {
+				build.Buildpack = "unknown"
+			}
+			builds = append(builds, build)
+		}
+	}
+	return builds, nil
+}
+
+//lifecycleBuildpack is the first buildpack a v3 app or build's lifecycle names, docker for
+//docker ones and "" when it leaves it to cf to detect
+func lifecycleBuildpack(entity map[string]interface{}) string {
+	if nestedString(entity, "lifecycle", "type") == "docker" {
+		return "docker"
+	}
+	names, _ := nestedValue(entity, "lifecycle", "data", "buildpacks").([]interface{})
+	if len(names) == 0 {
+		return ""
+	}
+	name, _ := names[0].(string)
+	return name
+}
+
+//SummarizeBuilds counts builds by state and works out how long the finished ones took
+func SummarizeBuilds(builds []Build) BuildSummary {
+	summary := BuildSummary{States: map[string]int{}}
+	var durations []time.Duration
+	for _, build := range builds {
+		summary.States[build.State]++
+		if build.State == BuildStaged || build.State == BuildFailed {
+			durations = append(durations, build.Duration())
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	summary.P50 = durationPercentile(durations, 0.5)
+	summary.P95 = durationPercentile(durations, 0.95)
+	return summary
+}
+
+//SummarizeBuildsByBuildpack is SummarizeBuilds for each buildpack's builds across spaces
+func SummarizeBuildsByBuildpack(spaces []Data) map[string]BuildSummary {
+	byBuildpack := map[string][]Build{}
+	for _, space := range spaces {
+		for _, build := range space.Builds {
+			byBuildpack[build.Buildpack] = append(byBuildpack[build.Buildpack], build)
+		}
+	}
+	summaries := map[string]BuildSummary{}
+	for buildpack, builds := range byBuildpack {
+		summaries[buildpack] = SummarizeBuilds(builds)
+	}
+	return summaries
+}
+
+//durationPercentile is the nearest rank percentile of sorted durations, 0 for none
+func durationPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
@@ -0,0 +1,70 @@
+package cfclient
+
+import (
+	"sync"
+	"time"
+)
+
+//lookupCache holds guid -> name lookups (orgs, spaces, service plans...) so we don't keep
+//asking the api for the same thing. with no ttl they last the length of a collection run,
+//with one they're kept across runs until they're that old
+type lookupCache struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	names map[string]cachedName
+}
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+func (cache *lookupCache) get(kind string, guid string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cached, exists := cache.names[kind+":"+guid]
+	if !exists || (cache.ttl > 0 && !time.Now().Before(cached.expires)) {
+		return "", false
+	}
+	return cached.name, true
+}
+
+func (cache *lookupCache) set(kind string, guid string, name string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.names == nil {
+		cache.names = map[string]cachedName{}
+	}
+	cache.names[kind+":"+guid] = cachedName{name: name, expires: time.Now().Add(cache.ttl)}
+}
+
+//reset throws away everything cached, or with a ttl just what's expired. this should happen
+//between collection runs
+func (cache *lookupCache) reset() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.ttl <= 0 {
+		cache.names = nil
+		return
+	}
+	now := time.Now()
+	for key, cached := range cache.names {
+		if !now.Before(cached.expires) {
+			delete(cache.names, key)
+		}
+	}
+}
+
+//lookupName returns the cached name for a guid of the given kind, calling fetch and caching
+//its result on a miss
+func (client *Client) lookupName(kind string, guid string, fetch func() (string, error)) (string, error) {
+	if name, cached := client.lookups.get(kind, guid); cached {
+		return name, nil
+	}
+	name, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	client.lookups.set(kind, guid, name)
+	return name, nil
+}
@@ -0,0 +1,46 @@
+package cfclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRepeatedLookupIsCached(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/organizations/org-guid" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt64(&hits, 1)
+		w.Write([]byte(`{"metadata":{"guid":"org-guid"},"entity":{"name":"payments"}}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server, Config{})
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		name, err := client.ResolveOrgName(context.Background(), "org-guid")
+		if err != nil {
+			t.Fatalf("lookup %d: %s", attempt, err)
+		}
+		if name != "payments" {
+			t.Fatalf("lookup %d: got %q, want payments", attempt, name)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("three lookups of the same org made %d requests, want 1", hits)
+	}
+
+	//a new run starts with an empty cache
+	client.lookups.reset()
+	_, err := client.ResolveOrgName(context.Background(), "org-guid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 {
+		t.Fatalf("a lookup after the cache was reset made %d requests in all, want 2", hits)
+	}
+}
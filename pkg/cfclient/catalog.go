@@ -0,0 +1,143 @@
+package cfclient
+
+import (
+	"context"
+	"sort"
+)
+
+//ServiceCatalog is the marketplace: the service brokers and every plan the services they
+//offer have, with how many instances of each the run collected
+type ServiceCatalog struct {
+	Brokers []ServiceBroker `json:"brokers"`
+	Plans   []CatalogPlan   `json:"plans"`
+}
+
+//ServiceBroker is a broker registered on the foundation. only admins can list them, for
+//everyone else there are none and the plans have no broker name
+type ServiceBroker struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+	//SpaceGUID is set for a broker only registered in one space
+	SpaceGUID string `json:"space_guid,omitempty"`
+}
+
+//CatalogPlan is one plan of a service in the marketplace
+type CatalogPlan struct {
+	GUID        string `json:"guid"`
+	Name        string `json:"name"`
+	ServiceGUID string `json:"service_guid"`
+	ServiceName string `json:"service_name"`
+	BrokerName  string `json:"broker_name,omitempty"`
+	//Public plans are visible to every org, the rest only to the orgs they're enabled in
+	Public bool `json:"public"`
+	Active bool `json:"active"`
+	Free   bool `json:"free"`
+	//Instances is how many of the run's service instances are of this plan, of the spaces it
+	//collected when it's filtered
+	Instances int `json:"instances"`
+}
+
+//PublicPlans are the plans every org can see
+func (catalog ServiceCatalog) PublicPlans() []CatalogPlan {
+	var public []CatalogPlan
+	for _, plan := range catalog.Plans {
+		if plan.Public {
+			public = append(public, plan)
+		}
+	}
+	return public
+}
+
+//UnusedPlans are the plans without any instances, candidates for being retired
+func (catalog ServiceCatalog) UnusedPlans() []CatalogPlan {
+	var unused []CatalogPlan
+	for _, plan := range catalog.Plans {
+		if plan.Instances == 0 {
+			unused = append(unused, plan)
+		}
+	}
+	return unused
+}
+
+//getServiceCatalog lists the service brokers, services and plans and counts the instances
+//in spaces against their plans, sorted by service then plan name. v3 calls the services
+//service offerings and keeps what v2 has flat under relationships
+func (client *Client) getServiceCatalog(ctx context.Context, spaces []Data) (ServiceCatalog, error) {
+	v3 := client.apiVersion == APIV3
+	version := func(v2Endpoint string, v3Endpoint string) string {
+		if v3 {
+			return v3Endpoint
+		}
+		return v2Endpoint
+	}
+	var catalog ServiceCatalog
+	brokers, err := client.Resources(ctx, version("/v2/service_brokers", "/v3/service_brokers"))
+	if err != nil && !IsForbidden(err) {
+		return catalog, err
+	}
+	brokerNames := map[string]string{}
+	for _, resource := range brokers {
+		broker := ServiceBroker{GUID: resource.Metadata.GUID, Name: EntityString(resource, "name"), SpaceGUID: EntityString(resource, "space_guid")}
+		if entity, isMap := resource.Entity.(map[string]interface{}); isMap && v3 {
+			broker.SpaceGUID = nestedString(entity, "relationships", "space", "data", "guid")
+		}
+		brokerNames[broker.GUID] = broker.Name
+		catalog.Brokers = append(catalog.Brokers, broker)
+	}
+
+	services, err := client.Resources(ctx, version("/v2/services", "/v3/service_offerings"))
+	if err != nil {
+		return catalog, err
+	}
+	serviceNames := map[string]string{}
+	serviceBrokers := map[string]string{}
+	for _, resource := range services {
+		if v3 {
+			entity, _ := resource.Entity.(map[string]interface{})
+			serviceNames[resource.Metadata.GUID] = EntityString(resource, "name")
+			serviceBrokers[resource.Metadata.GUID] = brokerNames[nestedString(entity, "relationships", "service_broker", "data", "guid")]
+			continue
+		}
+		serviceNames[resource.Metadata.GUID] = EntityString(resource, "label")
+		serviceBrokers[resource.Metadata.GUID] = brokerNames[EntityString(resource, "service_broker_guid")]
+	}
+
+	instances := map[string]int{}
+	for _, space := range spaces {
+		for _, instance := range space.ServiceInstances {
+			instances[instance.ServicePlanGUID]++
+		}
+	}
+
+	plans, err := client.Resources(ctx, version("/v2/service_plans", "/v3/service_plans"))
+	if err != nil {
+		return catalog, err
+	}
+	for _, resource := range plans {
+		entity, _ := resource.Entity.(map[string]interface{})
+		plan := CatalogPlan{
+			GUID:        resource.Metadata.GUID,
+			Name:        EntityString(resource, "name"),
+			ServiceGUID: EntityString(resource, "service_guid"),
+			Instances:   instances[resource.Metadata.GUID],
+		}
+		plan.Public, _ = entity["public"].(bool)
+		plan.Active, _ = entity["active"].(bool)
+		plan.Free, _ = entity["free"].(bool)
+		if v3 {
+			plan.ServiceGUID = nestedString(entity, "relationships", "service_offering", "data", "guid")
+			plan.Public = EntityString(resource, "visibility_type") == "public"
+			plan.Active, _ = entity["available"].(bool)
+		}
+		plan.ServiceName = serviceNames[plan.ServiceGUID]
+		plan.BrokerName = serviceBrokers[plan.ServiceGUID]
+		catalog.Plans = append(catalog.Plans, plan)
+	}
+	sort.SliceStable(catalog.Plans, func(i, j int) bool {
+		if catalog.Plans[i].ServiceName != catalog.Plans[j].ServiceName {
+			return catalog.Plans[i].ServiceName < catalog.Plans[j].ServiceName
+		}
+		return catalog.Plans[i].Name < catalog.Plans[j].Name
+	})
+	return catalog, nil
+}
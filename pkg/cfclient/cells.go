@@ -0,0 +1,68 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//cellCapacityWindow is how far back to look for the cells' capacity gauges. reps send them
+//every minute or so
+const cellCapacityWindow = 5 * time.Minute
+
+//CellCapacity is the memory and disk of the diego cells, in MB, from the capacity gauges
+//every cell's rep sends to the firehose
+type CellCapacity struct {
+	Cells             int `json:"cells"`
+	MemoryMB          int `json:"memory_mb"`
+	DiskMB            int `json:"disk_mb"`
+	RemainingMemoryMB int `json:"remaining_memory_mb"`
+	RemainingDiskMB   int `json:"remaining_disk_mb"`
+}
+
+//cellCapacityGauges are the rep's gauges CellCapacity adds up
+var cellCapacityGauges = []string{"CapacityTotalMemory", "CapacityTotalDisk", "CapacityRemainingMemory", "CapacityRemainingDisk"}
+
+//CellCapacity adds up the latest capacity gauges of every cell log cache has heard from in the
+//last cellCapacityWindow. they're platform metrics, so the user or client needs logs.admin
+func (client *Client) CellCapacity(ctx context.Context) (CellCapacity, error) {
+	envelopes, err := client.readGauges(ctx, "rep", time.Now().Add(-cellCapacityWindow), "^Capacity")
+	if err != nil {
+		return CellCapacity{}, err
+	}
+	//latest is each cell's newest value of each gauge, envelopes come newest first
+	latest := map[string]map[string]float64{}
+	for _, envelope := range envelopes {
+		cell := envelope.Tags["index"]
+		if cell == "" {
+			cell = envelope.Tags["ip"]
+		}
+		if cell == "" {
+			cell = envelope.InstanceID
+		}
+		if latest[cell] == nil {
+			latest[cell] = map[string]float64{}
+		}
+		for _, name := range cellCapacityGauges {
+			gauge, has := envelope.Gauges[name]
+			if _, seen := latest[cell][name]; has && !seen {
+				latest[cell][name] = gauge.Value
+			}
+		}
+	}
+	var capacity CellCapacity
+	for _, gauges := range latest {
+		if _, has := gauges["CapacityTotalMemory"]; !has {
+			continue
+		}
+		capacity.Cells++
+		capacity.MemoryMB += int(gauges["CapacityTotalMemory"])
+		capacity.DiskMB += int(gauges["CapacityTotalDisk"])
+		capacity.RemainingMemoryMB += int(gauges["CapacityRemainingMemory"])
+		capacity.RemainingDiskMB += int(gauges["CapacityRemainingDisk"])
+	}
+	if capacity.Cells == 0 {
+		return CellCapacity{}, fmt.Errorf("log cache has no capacity gauges from the cells' rep in the last %s, reading them needs logs.admin", cellCapacityWindow)
+	}
+	return capacity, nil
+}
@@ -0,0 +1,1244 @@
+package cfclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//Client is a struct containing all of the basic parts to make API requests to the Cloud Foundry API
+type Client struct {
+	authToken    string
+	refreshToken string
+	uaaClient    string
+	uaaSecret    string
+	apiURL       *url.URL
+	uaaURL       *url.URL
+	httpClient   *http.Client
+	//keepDuplicates turns off guid de-duplication of paged resources
+	keepDuplicates bool
+	lookups        lookupCache
+	//operations are the service instance operations seen in progress, see operationTracker
+	operations operationTracker
+	filter     Filter
+	rateLimit  rateLimit
+	//pageConcurrency is how many pages of a list endpoint can be fetched at once
+	pageConcurrency int
+	//concurrency is how many orgs/spaces get collected from at once
+	concurrency int
+	//maxAttempts is how many times a GET is tried before giving up
+	maxAttempts int
+	//requestTimeout bounds every single request
+	requestTimeout time.Duration
+	//maxResponseBytes caps every response body read, see Config.MaxResponseBytes
+	maxResponseBytes  int64
+	skipSSLValidation bool
+	//eventsSince and eventsUntil limit the audit event queries to a window, zero for no limit
+	eventsSince time.Time
+	eventsUntil time.Time
+	//usageCursorPath is where the app usage event cursor lives, no usage events without it
+	usageCursorPath string
+	//incrementalEvents only asks for the audit events since eventCursor, the last run's, which
+	//lives in memory and in eventCursorPath when it's set. a cursor older than
+	//eventCursorMaxAge means a full sync
+	incrementalEvents bool
+	eventCursor       *eventCursor
+	eventCursorPath   string
+	eventCursorMaxAge time.Duration
+	//eventTypeList is Config.EventTypes
+	eventTypeList []string
+	//largeDropletMB is Config.LargeDropletMB
+	largeDropletMB int64
+	//collectors are the names of the collectors runs go through, see enabledCollectors
+	collectors map[string]bool
+	//clientCredentials is set when we authenticate as a uaa client rather than as a user
+	clientCredentials bool
+	//tokenSource replaces uaa as where tokens come from when set
+	tokenSource    func() (string, error)
+	caCertPath     string
+	clientCertPath string
+	clientKeyPath  string
+	//proxyURL is an explicit http(s)/socks5 proxy for api and uaa traffic, or direct for none
+	proxyURL string
+	//noProxy are the hosts reached without proxyURL, see Config.NoProxy
+	noProxy []string
+	//maxPages caps how many pages of a list endpoint get fetched, 0 means no limit
+	maxPages int
+	//pageSize is the results per page asked for on list endpoints, 0 leaves it to the api
+	pageSize int
+	//appStatsSource is AppStatsCC or AppStatsLogCache
+	appStatsSource string
+	logCache       logCacheLink
+	counters       requestCounters
+	endpoints      endpointCounters
+	//responses are the org, space and quota lists kept for conditional requests, nil when
+	//they're off
+	responses *responseCache
+	//labelTags are Config.LabelTags
+	labelTags []string
+	//orgGUID scopes collection to a single org, skipping the org and space listings
+	orgGUID string
+	//scoped is for a token that isn't an admin's, see Config.Scoped
+	scoped bool
+	//apiVersion is APIV2 or APIV3, detected in setup() unless Config.APIVersion sets it
+	apiVersion string
+	//tokenCachePath is where refreshed tokens get saved for later runs, if set
+	tokenCachePath string
+	//keyring is where secrets go instead of files with Config.Keyring, nil without
+	keyring keyring
+	//login is the cf-metrics login the client was set up from, which refreshed tokens are
+	//saved back into. nil when it came from anywhere else
+	login *CLIConfig
+	//trace logs every request to traceLog, traceBodies adds the headers and bodies
+	trace       bool
+	traceBodies bool
+	traceLog    *log.Logger
+	//tracing gives every run a trace and its api requests spans, see Config.Tracing
+	tracing bool
+	//redactor is Config.Redaction, nil when it redacts nothing
+	redactor *redactor
+	//tokenMutex guards authToken and refreshToken
+	tokenMutex sync.RWMutex
+	//progressOut is where Collect draws its progress bars, stdout when nil
+	progressOut io.Writer
+	log         *slog.Logger
+	//progress holds the bars for the collection run in flight
+	progress *uiprogress.Progress
+}
+
+type APIResponse struct {
+	TotalResults int        `json:"total_results"`
+	TotalPages   int        `json:"total_pages"`
+	PrevURL      string     `json:"prev_url"`
+	NextURL      string     `json:"next_url"`
+	Resources    []Resource `json:"resources"`
+}
+
+type Resource struct {
+	Metadata ResourceMetadata `json:"metadata"`
+	Entity   interface{}      `json:"entity"`
+}
+
+type ResourceMetadata struct {
+	GUID      string    `json:"guid"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Data struct {
+	Name             string            `json:"name"`
+	GUID             string            `json:"guid"`
+	OrganizationGUID string            `json:"organization_guid,omitempty"`
+	Apps             []Resource        `json:"apps"`
+	AppCreates       []Resource        `json:"app_creates"`
+	AppStarts        []Resource        `json:"app_starts"`
+	AppUpdates       []Resource        `json:"app_updates"`
+	SpaceCreates     []Resource        `json:"space_creates"`
+	AppCrashes       []Resource        `json:"app_crashes"`
+	ServiceBindings  []Resource        `json:"service_bindings"`
+	ServiceInstances []ServiceInstance `json:"service_instances"`
+	Routes           []Route           `json:"routes"`
+	//AppRouteCounts is the number of routes mapped to each app, keyed by app guid
+	AppRouteCounts map[string]int `json:"app_route_counts"`
+	//BuildpackCounts and StackCounts are the number of apps using each buildpack/stack
+	BuildpackCounts map[string]int `json:"buildpack_counts"`
+	StackCounts     map[string]int `json:"stack_counts"`
+	//IsolationSegment is the isolation segment a space's apps run in, SharedSegment when
+	//it's on the shared cells, or an org's default segment when it has one
+	IsolationSegment string `json:"isolation_segment,omitempty"`
+	//RoleCounts is how many users have each role, e.g. managers or developers, only
+	//collected with Config.Roles
+	RoleCounts map[string]int `json:"role_counts,omitempty"`
+	//DockerApps are the apps in a space pushed as docker images, and their images
+	DockerApps []DockerApp `json:"docker_apps,omitempty"`
+	//Tasks are the v3 tasks created in a space during the event window
+	Tasks []Task `json:"tasks,omitempty"`
+	//Builds are the v3 builds of a space's apps created during the event window
+	Builds []Build `json:"builds,omitempty"`
+	//UserProvidedServices are a space's user provided service instances, which aren't in
+	//ServiceInstances
+	UserProvidedServices []UserProvidedService `json:"user_provided_services,omitempty"`
+	//Deployments and Revisions are a space's v3 deployments and which revision each app
+	//runs, only collected with Config.Deployments
+	Deployments []Deployment `json:"deployments,omitempty"`
+	//Blobs are the space's apps' droplets and packages in the blobstore, biggest first, only
+	//collected with Config.BlobSizes
+	Blobs     []AppBlobs    `json:"blobs,omitempty"`
+	Revisions []AppRevision `json:"revisions,omitempty"`
+	//Processes and Sidecars are a space's v3 processes and its apps' sidecars, only
+	//collected with Config.Processes
+	Processes []Process `json:"processes,omitempty"`
+	Sidecars  []Sidecar `json:"sidecars,omitempty"`
+	//SSHAllowed is whether a space allows ssh into its apps, which v2 spaces always have
+	//and v3 ones only with Config.SSH. SSHApps are the space's apps that can be ssh'd into,
+	//only collected with Config.SSH
+	SSHAllowed *bool    `json:"ssh_allowed,omitempty"`
+	SSHApps    []SSHApp `json:"ssh_apps,omitempty"`
+	//EnvVars are the names of the environment variables each app in a space sets, only
+	//collected with Config.EnvScan
+	EnvVars []AppEnv `json:"env_vars,omitempty"`
+	//AppInstanceStates is only collected with -app-stats, keyed by app guid
+	AppInstanceStates map[string]AppInstanceStates `json:"app_instance_states,omitempty"`
+	//Events is every audit event collected, of every type in Config.EventTypes, sorted by
+	//timestamp
+	Events []Event `json:"events"`
+	//Usage is the app footprint, rolled up from spaces for orgs
+	Usage UsageReport `json:"usage"`
+	//Labels are the values of the Config.LabelTags labels a v3 org or space has
+	Labels map[string]string `json:"labels,omitempty"`
+	//QuotaGUID is the org or space quota definition applied, spaces often don't have one
+	QuotaGUID string `json:"quota_guid,omitempty"`
+	//Quota is how much of that quota is in use
+	Quota *QuotaUsage `json:"quota,omitempty"`
+	//AppUsageEvents counts app usage events since the last run by state, only collected
+	//with a usage cursor
+	AppUsageEvents map[string]int `json:"app_usage_events,omitempty"`
+}
+type DataField int
+
+const (
+	FieldApps DataField = iota
+	FieldAppCreates
+	FieldAppStarts
+	FieldAppUpdates
+	FieldSpaceCreates
+	FieldServiceBindings
+	FieldAppCrashes
+	//FieldEvents only adds the events to Events, for event types without a field
+	FieldEvents
+)
+
+//Config is everything NewClient needs. the zero value reads the cf cli's config.json and
+//collects the whole foundation over whichever api version it detects
+type Config struct {
+	//ConfigPath is a yaml/json file with the target, uaa and tokens to use instead of the
+	//cf cli's config.json
+	ConfigPath string
+	//CFConfigPath is the cf cli's config.json, when it isn't in any of CFConfigPaths
+	CFConfigPath string
+	//APIVersion forces APIV2 or APIV3 instead of detecting it
+	APIVersion string
+	//ProxyURL is an http(s):// or socks5(h):// proxy, HTTP_PROXY/HTTPS_PROXY are used otherwise.
+	//direct turns the proxy off, environment and all
+	ProxyURL string
+	//NoProxy are hosts to reach without ProxyURL, like NO_PROXY for the environment's: host
+	//names, .domains covering their subdomains, ip addresses, cidrs, or * for all of them
+	NoProxy []string
+	//TokenCachePath is a file to save refreshed tokens in and reuse them from
+	TokenCachePath string
+	//Keyring keeps the refresh tokens TokenCachePath would have in the os keyring instead
+	//(the macos keychain, libsecret's secret-tool or the windows credential manager), and
+	//looks the ClientSecret for ClientID up there when it's empty
+	Keyring     bool
+	Trace       bool
+	TraceBodies bool
+	//TraceOut is where Trace logs go, stderr when nil
+	TraceOut io.Writer
+	//Tracing gives every run a w3c trace with a span per api request, sent along with the
+	//request as its traceparent header. the spans come back in CollectionResult.Spans and the
+	//latest span in each latency bucket of an endpoint in its EndpointStats.Exemplars
+	Tracing bool
+	Filter  Filter
+	//LabelTags are v3 metadata label keys whose values are kept on orgs and spaces, and
+	//sent as tags on their metrics
+	LabelTags []string
+	//PageConcurrency is how many pages of a list endpoint to fetch at once
+	PageConcurrency int
+	//Concurrency is how many orgs/spaces to collect from at once
+	Concurrency int
+	//MaxAttempts is how many times to try a GET that fails with a 429, 502, 503 or 504 or
+	//doesn't connect at all, 4 by default. 1 turns retries off
+	MaxAttempts int
+	//RateLimitShare is the fraction of each cloud controller rate limit window, going by its
+	//X-RateLimit-Limit, collection uses at most, so the rest is left for the users sharing
+	//the budget. 0 or 1 lets it use everything down to the last few requests
+	RateLimitShare float64
+	//ConditionalRequests keeps the org, space and quota lists that come with an ETag or
+	//Last-Modified and asks for them again with If-None-Match/If-Modified-Since, so a
+	//client that runs many collections only gets them sent again when they've changed
+	ConditionalRequests bool
+	//LookupTTL keeps the guid to name lookups (orgs, spaces, apps, service plans...) across
+	//collection runs for this long. 0 looks them up again every run
+	LookupTTL time.Duration
+	//Collectors are the only parts of a run to do, by name, see CollectorNames. every one
+	//that isn't opt in runs when it's empty, the opt in ones also run when their own field
+	//is set. SkipCollectors leaves some out either way
+	Collectors     []string
+	SkipCollectors []string
+	//ResolveNames adds org, space, app and user names to the events and app bindings
+	//collected, which otherwise only have the guids the api gives them
+	ResolveNames bool
+	//Redaction hashes or drops user names, email addresses and app names in everything
+	//Collect, Events, SpaceApps and StreamEnvelopes hand back, and in the names
+	//ResolveEventNames fills in
+	Redaction Redaction
+	//Processes collects every space's v3 processes and every app's sidecars, a call per
+	//space and another per app
+	Processes bool
+	//SSH collects which spaces allow ssh and which apps have it on, a call per v3 space and
+	//another per app in the ones that allow it
+	SSH bool
+	//EnvScan collects the names, never the values, of every app's environment variables and
+	//flags the ones that look like secrets. it's a call per app and needs space developer or
+	//admin read
+	EnvScan bool
+	//RequestTimeout bounds each api and uaa request, body included, a minute by default.
+	//a timed out request counts as a failed attempt and is retried
+	RequestTimeout time.Duration
+	//MaxResponseBytes is the biggest response body read from the api, uaa or log cache, 64MB
+	//by default. reading a bigger one stops there with a *ResponseTooLargeError, which fails
+	//whatever it was for like any other error
+	MaxResponseBytes int64
+	//API is the api address to talk to, overriding the target in the config. with client
+	//credentials and no cf cli config it's the only address needed, uaa is found from it
+	API string
+	//UAA is the uaa address, used as is. without it uaa is found from the api, and the one in
+	//the config is only used when that fails
+	UAA string
+	//ClientID and ClientSecret authenticate as a uaa client with the client_credentials
+	//grant, e.g. one with cloud_controller.admin_read_only, instead of as the logged in user
+	ClientID     string
+	ClientSecret string
+	//TokenSource hands out access tokens instead of uaa, e.g. the cf cli's own when running as
+	//a plugin. it's called for the first token and again whenever the api rejects one. with
+	//it and API set no cf cli config is needed
+	TokenSource func() (string, error)
+	//EventsSince and EventsUntil only collect audit events in [EventsSince, EventsUntil),
+	//the zero time leaves that end open
+	EventsSince time.Time
+	EventsUntil time.Time
+	//UsageCursorPath turns on app usage event counting, the guid of the last event seen is
+	//kept in this file so each run only pulls the new ones
+	UsageCursorPath string
+	//IncrementalEvents only asks for the audit events created since the last run and merges
+	//them with the ones it kept, falling back to a full sync on the first run, after a run
+	//that didn't get every list, or once the last run is older than EventCursorMaxAge (no
+	//limit when it's zero). EventCursorPath keeps them in a file as well, and turns it on, so a
+	//restart can carry on too
+	IncrementalEvents bool
+	EventCursorPath   string
+	EventCursorMaxAge time.Duration
+	//EventTypes are the audit event types to collect for each org and space, DefaultEventTypes
+	//and the crash event when nil. the default ones also fill in their own Data fields, e.g.
+	//AppCreates, every type goes in Data.Events
+	EventTypes []string
+	//Roles turns on counting the users in each org and space role, a few calls per org and
+	//space on v2
+	Roles bool
+	//Deployments turns on collecting each space's v3 deployments and which revision every
+	//app runs, a few calls per app
+	Deployments bool
+	//BlobSizes turns on sizing every app's droplets and packages, a call per droplet and
+	//package. apps with a droplet over LargeDropletMB (DefaultLargeDropletMB when 0) are
+	//flagged
+	BlobSizes      bool
+	LargeDropletMB int
+	//SkipSSLValidation turns off certificate checks for the api and uaa
+	SkipSSLValidation bool
+	//CACertPath is a pem bundle to trust on top of the system roots
+	CACertPath string
+	//ClientCertPath and ClientKeyPath are a pem cert and key for foundations that want mtls
+	ClientCertPath string
+	ClientKeyPath  string
+	//MaxPages caps the pages fetched from any one list endpoint, 0 means no limit
+	MaxPages int
+	//AppStatsSource is where app instance stats come from, AppStatsCC (the default) or
+	//AppStatsLogCache
+	AppStatsSource string
+	//PageSize is how many results to ask for per page of a list endpoint, the api's default
+	//of 50 when 0. v2 won't go over 100, so bigger sizes are capped there
+	PageSize int
+	//OrgGUID scopes collection to a single org
+	OrgGUID string
+	//Scoped is for a token that isn't an admin's, e.g. a space developer's. a 403 is taken as
+	//what the token can't see rather than a stale token, and whatever a collector isn't
+	//authorized to read, from the buildpacks and security groups to an org's quota, goes in
+	//CollectionResult.Forbidden instead of Failures
+	Scoped         bool
+	KeepDuplicates bool
+	//Logger gets the client's warnings and debug messages, slog.Default() when nil
+	Logger *slog.Logger
+	//ProgressOut is where the progress bars go, stdout when nil. ioutil.Discard hides them
+	ProgressOut io.Writer
+}
+
+//NewClient sets up a Client that's ready to make requests
+func NewClient(config Config) (*Client, error) {
+	switch config.APIVersion {
+	case "", APIV2, APIV3:
+	default:
+		return nil, fmt.Errorf("unknown api version %s, use %s or %s", config.APIVersion, APIV2, APIV3)
+	}
+	switch config.AppStatsSource {
+	case "", AppStatsCC, AppStatsLogCache:
+	default:
+		return nil, fmt.Errorf("unknown app stats source %s, use %s or %s", config.AppStatsSource, AppStatsCC, AppStatsLogCache)
+	}
+	if config.RateLimitShare < 0 || config.RateLimitShare > 1 {
+		return nil, fmt.Errorf("rate limit share %g is out of range, use a fraction between 0 and 1", config.RateLimitShare)
+	}
+	if config.PageSize < 0 || config.PageSize > maxV3PageSize {
+		return nil, fmt.Errorf("page size %d is out of range, the api takes 1 to %d", config.PageSize, maxV3PageSize)
+	}
+	if config.LargeDropletMB < 0 {
+		return nil, fmt.Errorf("large droplet size %d is negative", config.LargeDropletMB)
+	}
+	if config.LargeDropletMB == 0 {
+		config.LargeDropletMB = DefaultLargeDropletMB
+	}
+
+	client := &Client{
+		clientCredentials: config.ClientID != "",
+		tokenSource:       config.TokenSource,
+		keepDuplicates:    config.KeepDuplicates,
+		filter:            config.Filter,
+		labelTags:         config.LabelTags,
+		pageConcurrency:   config.PageConcurrency,
+		concurrency:       config.Concurrency,
+		maxAttempts:       config.MaxAttempts,
+		requestTimeout:    config.RequestTimeout,
+		maxResponseBytes:  config.MaxResponseBytes,
+		usageCursorPath:   config.UsageCursorPath,
+		incrementalEvents: config.IncrementalEvents || config.EventCursorPath != "",
+		eventCursorPath:   config.EventCursorPath,
+		eventCursorMaxAge: config.EventCursorMaxAge,
+		eventTypeList:     config.EventTypes,
+		largeDropletMB:    int64(config.LargeDropletMB),
+		eventsSince:       config.EventsSince,
+		eventsUntil:       config.EventsUntil,
+		skipSSLValidation: config.SkipSSLValidation,
+		caCertPath:        config.CACertPath,
+		clientCertPath:    config.ClientCertPath,
+		clientKeyPath:     config.ClientKeyPath,
+		proxyURL:          config.ProxyURL,
+		noProxy:           config.NoProxy,
+		maxPages:          config.MaxPages,
+		pageSize:          config.PageSize,
+		appStatsSource:    config.AppStatsSource,
+		orgGUID:           config.OrgGUID,
+		scoped:            config.Scoped,
+		apiVersion:        config.APIVersion,
+		tokenCachePath:    config.TokenCachePath,
+		trace:             config.Trace,
+		traceBodies:       config.TraceBodies,
+		tracing:           config.Tracing,
+		traceLog:          log.New(os.Stderr, "", log.LstdFlags),
+		progressOut:       config.ProgressOut,
+		log:               config.Logger,
+	}
+	//with several workers paging at once, that many requests can go out between us checking
+	//the rate limit and the api answering, so leave room for them
+	client.rateLimit.headroom = max(client.concurrency, 1) * max(client.pageConcurrency, 1)
+	client.rateLimit.share = config.RateLimitShare
+	if client.rateLimit.share == 0 {
+		client.rateLimit.share = 1
+	}
+	client.lookups.ttl = config.LookupTTL
+	if config.ConditionalRequests {
+		client.responses = &responseCache{}
+	}
+	if client.log == nil {
+		client.log = slog.Default()
+	}
+	if config.TraceOut != nil {
+		client.traceLog = log.New(config.TraceOut, "", log.LstdFlags)
+	}
+	if client.requestTimeout <= 0 {
+		client.requestTimeout = defaultRequestTimeout
+	}
+	if client.maxResponseBytes <= 0 {
+		client.maxResponseBytes = defaultMaxResponseBytes
+	}
+	err := client.filter.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Redaction.Validate()
+	if err != nil {
+		return nil, err
+	}
+	client.redactor = newRedactor(config.Redaction)
+	client.collectors, err = enabledCollectors(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Keyring {
+		client.keyring, err = platformKeyring()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !config.EventsSince.IsZero() && !config.EventsUntil.IsZero() && !config.EventsUntil.After(config.EventsSince) {
+		return nil, fmt.Errorf("the end of the event window has to be after the start")
+	}
+	err = client.setup(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+//setup configures the client from the config file at config.ConfigPath, or from the cf
+//cli's config.json when there isn't one (config.CFConfigPath or the first of CFConfigPaths),
+//or the login cf-metrics login saved when there's neither. with client credentials or a token source the cf cli config is optional as long
+//as config.API says where the api is
+func (client *Client) setup(ctx context.Context, config Config) error {
+	var myConf *CLIConfig
+	var err error
+	switch {
+	case config.ConfigPath != "":
+		myConf, err = LoadConfigFile(config.ConfigPath)
+	case config.CFConfigPath != "":
+		myConf, err = LoadCFConfig(config.CFConfigPath)
+	default:
+		myConf, err = GrabCFCLIENV()
+		if err != nil && !client.clientCredentials && client.tokenSource == nil {
+			//a login to another api than config.API asks for is no use
+			if login, loginErr := GrabLoginConfig(); loginErr == nil && (config.API == "" || strings.TrimSuffix(config.API, "/") == login.Target) {
+				myConf, err = login, nil
+				client.login = login
+			}
+		}
+		if err != nil && (client.clientCredentials || client.tokenSource != nil) && config.API != "" {
+			myConf, err = &CLIConfig{}, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if config.API != "" {
+		myConf.Target = config.API
+	}
+	if config.UAA != "" {
+		myConf.UAAEndpoint = config.UAA
+	}
+	if client.clientCredentials && config.ClientSecret == "" && client.keyring != nil {
+		config.ClientSecret, err = client.keyring.get(clientSecretAccount(config.ClientID, myConf.Target))
+		if err != nil {
+			return fmt.Errorf("error reading the secret of %s from the keyring: %s", config.ClientID, err)
+		}
+	}
+	if client.clientCredentials {
+		//a user's tokens have nothing to do with us when we're our own uaa client
+		myConf.UAAClientID = config.ClientID
+		myConf.UAAClientSecret = config.ClientSecret
+		myConf.AccessToken = ""
+		myConf.RefreshToken = ""
+	}
+
+	tmpURL, err := url.Parse(myConf.Target)
+	if err != nil {
+		return fmt.Errorf("error parsing api address %s: %s", myConf.Target, err)
+	}
+	tmp2URL, err := url.Parse(myConf.UAAEndpoint)
+	if err != nil {
+		return fmt.Errorf("error parsing uaa address %s: %s", myConf.UAAEndpoint, err)
+	}
+
+	client.authToken = bearerToken(myConf.AccessToken)
+	client.refreshToken = myConf.RefreshToken
+	client.uaaClient = myConf.UAAClientID
+	client.uaaSecret = myConf.UAAClientSecret
+	client.apiURL = tmpURL
+	client.uaaURL = tmp2URL
+
+	//a still valid token from an earlier run beats whatever the config has
+	if client.tokenCachePath != "" {
+		if token, valid := readTokenCache(client.tokenCachePath, client.apiURL.String()); valid {
+			client.authToken = bearerToken(token.AccessToken)
+			client.refreshToken = token.RefreshToken
+			if token.RefreshTokenInKeyring && client.keyring != nil {
+				client.refreshToken, err = client.keyring.get(keyringAccount("token-cache", client.apiURL.String()))
+				if err != nil {
+					return fmt.Errorf("error reading the cached refresh token from the keyring: %s", err)
+				}
+			}
+		}
+	}
+
+	//an explicit -proxy wins over the config file, which wins over the environment
+	if client.proxyURL == "" {
+		client.proxyURL = myConf.Proxy
+	}
+	if len(client.noProxy) == 0 && myConf.NoProxy != "" {
+		client.noProxy = strings.Split(myConf.NoProxy, ",")
+	}
+	proxy, err := proxyFunc(client.proxyURL, client.noProxy)
+	if err != nil {
+		return err
+	}
+	//certificates are checked unless we're told not to, or the cf cli was pointed at the api
+	//with --skip-ssl-validation
+	tlsSettings, err := tlsConfig(client.skipSSLValidation || myConf.SSLDisabled, client.caCertPath, client.clientCertPath, client.clientKeyPath)
+	if err != nil {
+		return err
+	}
+	client.httpClient = &http.Client{
+		Transport: newTransport(proxy, tlsSettings, max(client.concurrency, 1)*max(client.pageConcurrency, 1)),
+		Timeout:   client.requestTimeout,
+	}
+
+	//a config pointing at the wrong uaa is an easy mistake across foundations, so unless we've
+	//been told exactly where it is we ask the api. a token source has no use for uaa
+	if config.UAA == "" && client.tokenSource == nil {
+		discovered, err := client.discoverUAA(ctx)
+		switch {
+		case err == nil:
+			if myConf.UAAEndpoint != "" && strings.TrimSuffix(myConf.UAAEndpoint, "/") != strings.TrimSuffix(discovered.String(), "/") {
+				client.log.Debug("the api's uaa isn't the one in the config, using the api's", "config", myConf.UAAEndpoint, "api", discovered.String())
+			}
+			client.uaaURL = discovered
+		case myConf.UAAEndpoint != "":
+			client.log.Warn("couldn't find uaa from the api, using the one in the config", "uaa", myConf.UAAEndpoint, "err", err)
+		default:
+			return fmt.Errorf("error finding uaa: %s", err)
+		}
+	}
+
+	if client.clientCredentials {
+		if !validBearerToken(client.authToken) {
+			err = client.RefreshAccessToken(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting a client credentials token: %s", err)
+			}
+		}
+	}
+
+	if client.tokenSource != nil && !client.clientCredentials {
+		err = client.RefreshAccessToken(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting a token: %s", err)
+		}
+	}
+
+	//work out whether to talk v2 or v3 unless we've been told which
+	if client.apiVersion == "" {
+		client.apiVersion, err = client.detectAPIVersion(ctx)
+		if err != nil {
+			client.log.Warn("couldn't detect the api version, assuming v2", "err", err)
+			client.apiVersion = APIV2
+		}
+	}
+	if client.filter.labelSelected() && client.apiVersion != APIV3 {
+		return fmt.Errorf("label selectors need the v3 api")
+	}
+	if len(client.labelTags) > 0 && client.apiVersion != APIV3 {
+		client.log.Warn("v2 orgs and spaces have no labels, there won't be any label tags")
+	}
+	return nil
+}
+
+//proxyFunc returns the transport proxy for an explicitly configured http(s) or socks5 proxy
+//url, falling back to HTTP_PROXY/HTTPS_PROXY from the environment when there isn't one. direct
+//is no proxy at all, and the noProxy hosts skip an explicit one
+func proxyFunc(proxyURL string, noProxy []string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	if proxyURL == "direct" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proxy url: %s", err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q in %s, use http, https or socks5", parsed.Scheme, parsed.Redacted())
+	}
+	for _, entry := range noProxy {
+		if strings.Contains(entry, "/") {
+			_, _, err = net.ParseCIDR(strings.TrimSpace(entry))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing no proxy cidr %s: %s", entry, err)
+			}
+		}
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return parsed, nil
+	}, nil
+}
+
+//bypassProxy is true when host is one of the noProxy entries: the host itself, a domain it's
+//under (with or without the leading dot), a cidr its ip address is in, or *
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		//ip addresses only match themselves, not as a domain
+		domain := strings.TrimPrefix(entry, ".")
+		if entry == "*" || host == entry || (ip == nil && domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))) {
+			return true
+		}
+	}
+	return false
+}
+
+//bearerToken normalizes a token to the "Bearer <token>" form no matter if it was stored
+//as "bearer <token>", "Bearer <token>" or just "<token>"
+func bearerToken(token string) string {
+	token = strings.TrimSpace(token)
+	if len(token) >= 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return ""
+	}
+	return "Bearer " + token
+}
+
+//validBearerToken checks that an authorization value is "Bearer " followed by a single
+//non empty token
+func validBearerToken(header string) bool {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return token != "" && !strings.ContainsAny(token, " \t\r\n")
+}
+
+//RefreshAccessToken gets a new access token from uaa using the refresh token, or the client
+//credentials when the client has its own
+func (client *Client) RefreshAccessToken(ctx context.Context) error {
+	client.tokenMutex.Lock()
+	defer client.tokenMutex.Unlock()
+	return client.requestToken(ctx)
+}
+
+//refreshStaleToken refreshes the access token only if it's still the stale one a request
+//was rejected with. when a bunch of requests hit a 401 at once the first one refreshes and
+//the rest wait on the lock and then just reuse the token it got
+func (client *Client) refreshStaleToken(ctx context.Context, stale string) error {
+	client.tokenMutex.Lock()
+	defer client.tokenMutex.Unlock()
+	if client.authToken != stale {
+		return nil
+	}
+	return client.requestToken(ctx)
+}
+
+//accessToken is the current authorization header value
+func (client *Client) accessToken() string {
+	client.tokenMutex.RLock()
+	defer client.tokenMutex.RUnlock()
+	return client.authToken
+}
+
+//requestToken does the actual refresh against uaa, or asks the token source, the caller
+//must hold tokenMutex
+func (client *Client) requestToken(ctx context.Context) error {
+	if client.tokenSource != nil && !client.clientCredentials {
+		atomic.AddInt64(&client.counters.refreshes, 1)
+		token, err := client.tokenSource()
+		if err != nil {
+			return fmt.Errorf("error getting a token from the token source: %s", err)
+		}
+		client.authToken = bearerToken(token)
+		return nil
+	}
+	req, err := client.tokenRequest(ctx)
+	if err != nil {
+		return fmt.Errorf("error forming uaa token request: %s", err)
+	}
+	atomic.AddInt64(&client.counters.refreshes, 1)
+	started := time.Now()
+	resp, err := client.httpClient.Do(req)
+	client.traceRequest(req, resp, started, err)
+	if err != nil {
+		return fmt.Errorf("error attempting uaa token request: %s", err)
+	}
+	client.limitBody(resp, "/oauth/token")
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error: non 200 response code %d from uaa when attempting to get a token", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't read uaa token response body: %s", err)
+	}
+
+	type refreshResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+
+	contents := refreshResponse{}
+	err = json.Unmarshal(b, &contents)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal uaa token response: %s", err)
+	}
+	client.authToken = bearerToken(contents.AccessToken)
+	client.refreshToken = contents.RefreshToken
+
+	if client.tokenCachePath != "" {
+		token := cachedToken{
+			Target:       client.apiURL.String(),
+			AccessToken:  contents.AccessToken,
+			RefreshToken: contents.RefreshToken,
+			ExpiresAt:    time.Now().Add(time.Duration(contents.ExpiresIn) * time.Second),
+		}
+		if client.keyring != nil && token.RefreshToken != "" {
+			//a refresh token that can't go in the keyring is left out rather than written down
+			err = client.keyring.set(keyringAccount("token-cache", token.Target), token.RefreshToken)
+			if err != nil {
+				client.log.Warn("error keeping the refresh token in the keyring, it's left out of the token cache", "err", err)
+			}
+			token.RefreshToken, token.RefreshTokenInKeyring = "", err == nil
+		}
+		err = writeTokenCache(client.tokenCachePath, token)
+		if err != nil {
+			client.log.Warn("error writing token cache", "path", client.tokenCachePath, "err", err)
+		}
+	}
+	if client.login != nil && contents.RefreshToken != "" {
+		client.login.AccessToken = "bearer " + contents.AccessToken
+		client.login.RefreshToken = contents.RefreshToken
+		err = SaveLoginConfig(client.login, client.login.RefreshTokenInKeyring)
+		if err != nil {
+			client.log.Warn("error saving the refreshed login", "path", LoginConfigPath(), "err", err)
+		}
+	}
+
+	return nil
+}
+
+func (client *Client) Orgs(ctx context.Context) ([]Data, error) {
+	if client.apiVersion == APIV3 {
+		return client.getOrgsV3(ctx)
+	}
+	//walk the list a page at a time, keeping only what a Data needs out of each org
+	var orgs []Data
+	err := client.EachResource(ctx, "/v2/organizations"+nameQuery(client.filter.IncludeOrgs), func(resource Resource) error {
+		name := EntityString(resource, "name")
+		client.lookups.set("org", resource.Metadata.GUID, name)
+		if !client.filter.allowOrg(name, resource.Metadata.GUID) {
+			return nil
+		}
+		orgs = append(orgs, Data{
+			Name:      name,
+			GUID:      resource.Metadata.GUID,
+			QuotaGUID: EntityString(resource, "quota_definition_guid"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func (client *Client) Spaces(ctx context.Context) ([]Data, error) {
+	if client.apiVersion == APIV3 {
+		return client.getSpacesV3(ctx, "")
+	}
+	return client.getSpacesFrom(ctx, "/v2/spaces")
+}
+
+//getSpacesFrom lists the spaces at a space list endpoint, e.g. /v2/spaces or
+///v2/organizations/:guid/spaces
+func (client *Client) getSpacesFrom(ctx context.Context, endpoint string) ([]Data, error) {
+	var spaces []Data
+	err := client.EachResource(ctx, endpoint+nameQuery(client.filter.IncludeSpaces), func(resource Resource) error {
+		name := EntityString(resource, "name")
+		client.lookups.set("space", resource.Metadata.GUID, name)
+		if !client.filter.allowSpace(name) {
+			return nil
+		}
+		spaces = append(spaces, Data{
+			Name:             name,
+			OrganizationGUID: EntityString(resource, "organization_guid"),
+			GUID:             resource.Metadata.GUID,
+			QuotaGUID:        EntityString(resource, "space_quota_definition_guid"),
+			SSHAllowed:       entityBool(resource, "allow_ssh"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spaces, nil
+}
+
+//doGetRequest performs an authenticated GET against the cf api, refreshing the token once
+//on a 401/403 and retrying 429s, 5xx gateway errors and connection failures with backoff.
+//the caller is responsible for reading and closing the body of a successful response,
+//decodeBody does both
+func (client *Client) doGetRequest(ctx context.Context, endpoint string) (*http.Response, error) {
+	return client.retryGet(ctx, endpoint)
+}
+
+//doRequest performs an authenticated request against the cf api, refreshing the token once
+//on a 401/403. the body is kept as bytes rather than a reader so the retry after a refresh
+//can send it again
+func (client *Client) doRequest(ctx context.Context, method string, endpoint string, body []byte, secondAttempt ...bool) (*http.Response, error) {
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, client.apiURL.String()+endpoint, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("error forming http %s request: %s", method, err)
+	}
+	token := client.freshToken(ctx)
+	if !validBearerToken(token) {
+		return nil, errors.New("no valid access token, try logging in again with `cf login`")
+	}
+	req.Header.Add("Authorization", token)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	conditional := method == http.MethodGet && conditionalEndpoint(endpoint)
+	if conditional {
+		client.responses.validate(req, endpoint)
+	}
+
+	err = client.rateLimit.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&client.counters.requests, 1)
+	span := startSpan(ctx, req, endpoint)
+	started := time.Now()
+	resp, err := client.httpClient.Do(req)
+	client.traceRequest(req, resp, started, err)
+	client.endpoints.request(endpoint, time.Since(started), err != nil || (resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotModified), span.end(resp, err))
+	if err != nil {
+		client.log.Debug("request failed", "method", method, "endpoint", endpoint, "err", err)
+		return nil, err
+	}
+	resp.Body = countingBody{ReadCloser: resp.Body, counter: &client.counters.bytesRead}
+	client.limitBody(resp, endpoint)
+	client.rateLimit.update(resp.Header)
+	if conditional {
+		resp, err = client.conditionalGet(endpoint, resp)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", endpoint, err)
+		}
+	}
+
+	//a scoped token gets 403s for everything it isn't allowed to see, a fresh one wouldn't either
+	if (resp.StatusCode == 401 || (resp.StatusCode == 403 && !client.scoped)) && len(secondAttempt) == 0 {
+		atomic.AddInt64(&client.counters.unauthorized, 1)
+		drainAndClose(resp.Body)
+		err = client.refreshStaleToken(ctx, token)
+		if err != nil {
+			return nil, fmt.Errorf("Error refreshing token: %s", err)
+		}
+		atomic.AddInt64(&client.counters.retries, 1)
+		client.endpoints.retry(endpoint)
+		return client.doRequest(ctx, method, endpoint, body, true)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		bodyBytes, _ := readErrorBody(resp)
+		drainAndClose(resp.Body)
+		return nil, newAPIError(resp, endpoint, bodyBytes)
+	}
+
+	return resp, nil
+}
+
+func (client *Client) cfAPIRequest(ctx context.Context, endpoint string, returnStruct *APIResponse) error {
+	endpoint = client.withPageSize(endpoint)
+	resp, err := client.doGetRequest(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if isV3Endpoint(endpoint) {
+		var list v3ListResponse
+		err = decodeBody(resp, &list)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling %s: %s", endpoint, err)
+		}
+		*returnStruct = list.toCFAPIResponse()
+		return nil
+	}
+	err = decodeBody(resp, returnStruct)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling %s: %s", endpoint, err)
+	}
+
+	return nil
+}
+
+//newTransport is the transport for api and uaa traffic. it keeps an idle connection per
+//request we can have in flight, plus one for uaa, so busy collections reuse connections
+//instead of paying for a tcp and tls handshake per request, which is most of the time spent
+//against a foundation far away. responses come gzipped: Go's transport asks for gzip and
+//inflates the body itself as long as we never set Accept-Encoding on a request ourselves
+func newTransport(proxy func(*http.Request) (*url.URL, error), tlsSettings *tls.Config, inFlight int) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 proxy,
+		TLSClientConfig:       tlsSettings,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          inFlight + 1,
+		MaxIdleConnsPerHost:   inFlight + 1,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+//decodeBody decodes a response body into v as it streams in and closes it, so a big page
+//is never held as raw bytes on top of the structs it decodes into. a 204 or an empty body
+//is treated as an empty result and leaves v untouched instead of failing to decode
+func decodeBody(resp *http.Response, v interface{}) error {
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err := json.NewDecoder(resp.Body).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//drainAndClose reads whatever is left of a response body and closes it so the underlying
+//connection can go back into the pool instead of leaking. a body with more than a little
+//left isn't worth the wait, its connection is just closed
+func drainAndClose(body io.ReadCloser) {
+	io.CopyN(ioutil.Discard, body, 256<<10)
+	body.Close()
+}
+
+//getEndpointData hits endpoint+guid for every org/space in dataList and stores the results
+//in the chosen field. an org/space that fails is skipped and reported back instead of
+//stopping the rest of the list
+func (client *Client) getEndpointData(ctx context.Context, dataList []Data, listToUpdate DataField, endpoint string, whatYoureDoing string) []CollectionError {
+	return client.getMergedEndpointData(ctx, dataList, listToUpdate, endpoint, whatYoureDoing, nil)
+}
+
+//getMergedEndpointData is getEndpointData with what comes back for each org/space going
+//through merge, given its guid, before it's stored. a nil merge stores it as is
+func (client *Client) getMergedEndpointData(ctx context.Context, dataList []Data, listToUpdate DataField, endpoint string, whatYoureDoing string, merge func(guid string, resources []Resource) []Resource) []CollectionError {
+	if len(whatYoureDoing) < 36 {
+		//pad length to 36 chars to make it less ugly in the terminal
+		for len(whatYoureDoing) < 36 {
+			whatYoureDoing = whatYoureDoing + " "
+		}
+	}
+	//add in terminal ui progress bars with comments
+	bar := client.progress.AddBar(len(dataList)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	//ping the endpoint of choice for every org/space, client.concurrency at a time
+	perIndex := make([][]CollectionError, len(dataList))
+	client.forEachConcurrently(ctx, len(dataList), func(index int) {
+		datapoint := dataList[index]
+		failures := &perIndex[index]
+		var response APIResponse
+		err := client.cfAPIRequest(ctx, endpoint+datapoint.GUID, &response)
+		if err != nil {
+			*failures = append(*failures, newCollectionError(datapoint, whatYoureDoing, err))
+			bar.Incr()
+			return
+		}
+
+		//grab the data from said endpoint
+		cfResources, err := client.cfResourcesFromResponse(ctx, response)
+		err = client.warnIfTruncated(err, whatYoureDoing)
+		if err != nil {
+			*failures = append(*failures, newCollectionError(datapoint, whatYoureDoing, err))
+			bar.Incr()
+			return
+		}
+		if merge != nil {
+			cfResources = merge(datapoint.GUID, cfResources)
+		}
+
+		//add in the data in the chosen struct field
+		switch listToUpdate {
+		case FieldApps:
+			for _, v := range cfResources {
+				sanitizeApps(&v)
+			}
+			dataList[index].Apps = cfResources
+		case FieldAppCreates:
+			dataList[index].AppCreates = cfResources
+		case FieldAppStarts:
+			dataList[index].AppStarts = cfResources
+		case FieldAppUpdates:
+			for _, v := range cfResources {
+				sanitizeEvents(&v)
+			}
+			dataList[index].AppUpdates = cfResources
+		case FieldServiceBindings:
+			dataList[index].ServiceBindings = cfResources
+		case FieldSpaceCreates:
+			dataList[index].SpaceCreates = cfResources
+		case FieldAppCrashes:
+			dataList[index].AppCrashes = cfResources
+		case FieldEvents:
+			for _, v := range cfResources {
+				sanitizeEvents(&v)
+			}
+		}
+
+		//events also get parsed into the combined, sorted event list
+		switch listToUpdate {
+		case FieldAppCreates, FieldAppStarts, FieldAppUpdates, FieldSpaceCreates, FieldAppCrashes, FieldEvents:
+			dataList[index].Events, err = addEvents(dataList[index].Events, cfResources)
+			if err != nil {
+				*failures = append(*failures, newCollectionError(datapoint, whatYoureDoing, err))
+			}
+		}
+
+		//update the terminal ui
+		bar.Incr()
+	})
+
+	return flattenFailures(perIndex)
+}
+
+//errTruncated is returned alongside the resources collected so far when a list endpoint has
+//more pages than client.maxPages allows
+var errTruncated = errors.New("stopped paging at the max page limit, results are truncated")
+
+//cfResourcesFromResponse is every resource of a list endpoint given its first page, paging
+//through the rest
+func (client *Client) cfResourcesFromResponse(ctx context.Context, response APIResponse) ([]Resource, error) {
+	var resourceList []Resource
+	resourceList = append(resourceList, response.Resources...)
+	truncated := false
+
+	pages := client.newPager(ctx, "")
+	defer pages.stop()
+	err := pages.fetchedPage(response)
+	if err != nil {
+		return nil, err
+	}
+	for pages.more() {
+		resources, err := pages.page()
+		if err == errTruncated {
+			truncated = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		resourceList = append(resourceList, resources...)
+	}
+
+	if !client.keepDuplicates {
+		resourceList = dedupeResources(resourceList)
+	}
+	if truncated {
+		return resourceList, errTruncated
+	}
+	return resourceList, nil
+}
+
+//warnIfTruncated lets truncated results through with a warning, any other error is returned
+func (client *Client) warnIfTruncated(err error, whatYoureDoing string) error {
+	if err == errTruncated {
+		client.log.Warn(err.Error(), "while", strings.TrimSpace(whatYoureDoing))
+		return nil
+	}
+	return err
+}
+
+//dedupeResources drops any resource whose metadata guid has already been seen,
+//keeping the first occurrence. overlapping pages can show up when the platform
+//is busy while we're paging through it
+func dedupeResources(resources []Resource) []Resource {
+	seen := make(map[string]bool, len(resources))
+	uniqueResources := resources[:0]
+	for _, resource := range resources {
+		guid := resource.Metadata.GUID
+		if guid != "" {
+			if seen[guid] {
+				continue
+			}
+			seen[guid] = true
+		}
+		uniqueResources = append(uniqueResources, resource)
+	}
+	return uniqueResources
+}
+
+//sanitizeApps drops app environment variables, they're often secrets
+func sanitizeApps(v *Resource) {
+	m, isMap := v.Entity.(map[string]interface{})
+	if !isMap {
+		return
+	}
+
+	delete(m, "environment_json")
+}
+
+//sanitizeEvents drops the environment variables from the request recorded on an event. an
+//event without a request has nothing to drop
+func sanitizeEvents(v *Resource) {
+	m, isMap := v.Entity.(map[string]interface{})
+	if !isMap {
+		return
+	}
+
+	//v3 audit events keep the request under data instead of metadata
+	if data, isMap := m["data"].(map[string]interface{}); isMap {
+		if reqMap, isMap := data["request"].(map[string]interface{}); isMap {
+			delete(reqMap, "environment_variables")
+			delete(reqMap, "environment_json")
+		}
+		return
+	}
+
+	metaMap, isMap := m["metadata"].(map[string]interface{})
+	if !isMap {
+		return
+	}
+	reqMap, isMap := metaMap["request"].(map[string]interface{})
+	if !isMap {
+		return
+	}
+	delete(reqMap, "environment_json")
+}
+
+//APIURL is the cloud controller the client talks to
+func (client *Client) APIURL() string {
+	return client.apiURL.String()
+}
+
+//UAAURL is the uaa the client gets tokens from
+func (client *Client) UAAURL() string {
+	return client.uaaURL.String()
+}
@@ -0,0 +1,189 @@
+package cfclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//rotatingTokens is a token source handing out tokens[0] first, then each of the rest once
+//per refresh, and the last one from then on
+func rotatingTokens(tokens ...string) func() (string, error) {
+	var mutex sync.Mutex
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		token := tokens[0]
+		if len(tokens) > 1 {
+			tokens = tokens[1:]
+		}
+		return token, nil
+	}
+}
+
+func TestRefreshRetrySendsTheBodyAgain(t *testing.T) {
+	type received struct {
+		method string
+		token  string
+		body   string
+	}
+	var mutex sync.Mutex
+	var requests []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, received{method: r.Method, token: r.Header.Get("Authorization"), body: string(body)})
+		mutex.Unlock()
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		method string
+		body   []byte
+	}{
+		{http.MethodPost, []byte(`{"name":"payments","memory_in_mb":1024}`)},
+		{http.MethodGet, nil},
+	} {
+		requests = nil
+		client := newTestClient(t, server, Config{TokenSource: rotatingTokens("stale", "fresh")})
+		resp, err := client.doRequest(context.Background(), test.method, "/v3/apps", test.body)
+		if err != nil {
+			t.Fatalf("%s: %s", test.method, err)
+		}
+		drainAndClose(resp.Body)
+
+		if len(requests) != 2 {
+			t.Fatalf("%s: the api got %d requests, want the rejected one and its retry", test.method, len(requests))
+		}
+		if requests[0].token != "Bearer stale" || requests[1].token != "Bearer fresh" {
+			t.Fatalf("%s: requests were sent with %s then %s, want the stale token then the fresh one", test.method, requests[0].token, requests[1].token)
+		}
+		for index, request := range requests {
+			if request.method != test.method || request.body != string(test.body) {
+				t.Fatalf("%s: request %d was a %s of %q, want %q", test.method, index+1, request.method, request.body, test.body)
+			}
+		}
+	}
+}
+
+func TestSequentialRequestsReuseTheirConnection(t *testing.T) {
+	var connections int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/apps":
+			writeV2Page(w, "/v2/apps", testResources("app", 0, 50), 1, 1)
+		case "/v2/apps/gone":
+			//an error with a body to read for its message, which has to be drained too
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":100004,"description":"The app could not be found: gone","error_code":"CF-AppNotFound"}`))
+		case "/v2/apps/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"description":"something went wrong"}`))
+		}
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	client := newTestClient(t, server, Config{MaxAttempts: 1})
+
+	for request := 0; request < 100; request++ {
+		switch request % 3 {
+		case 0:
+			_, err := client.Resources(context.Background(), "/v2/apps")
+			if err != nil {
+				t.Fatalf("request %d: %s", request, err)
+			}
+		case 1:
+			_, err := client.getResource(context.Background(), "/v2/apps/gone")
+			if !IsNotFound(err) {
+				t.Fatalf("request %d: got %v, want a not found", request, err)
+			}
+		case 2:
+			_, err := client.getResource(context.Background(), "/v2/apps/broken")
+			if err == nil {
+				t.Fatalf("request %d: a 500 didn't fail", request)
+			}
+		}
+	}
+	if connections != 1 {
+		t.Fatalf("100 requests one after another opened %d connections, want them all on 1", connections)
+	}
+}
+
+func TestConcurrent401sRefreshOnce(t *testing.T) {
+	const goroutines = 20
+	var refreshes int64
+	var arrived sync.WaitGroup
+	arrived.Add(goroutines)
+	allArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrived)
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/oauth/token":
+			//the first token is the one setup gets, every one after that a refresh
+			token := "stale"
+			if atomic.AddInt64(&refreshes, 1) > 1 {
+				token = "fresh"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"` + token + `","expires_in":600}`))
+		case r.Header.Get("Authorization") == "Bearer stale":
+			//hold the 401s back until every goroutine has been sent one, so they all hit it
+			//with the stale token at once
+			arrived.Done()
+			select {
+			case <-allArrived:
+			case <-time.After(5 * time.Second):
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Header.Get("Authorization") == "Bearer fresh":
+			writeV2Page(w, "/v2/apps", testResources("app", 0, 1), 1, 1)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server, Config{ClientID: "metrics", ClientSecret: "secret", MaxAttempts: 1})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for index := 0; index < goroutines; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Resources(context.Background(), "/v2/apps")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt64(&refreshes) - 1; got != 1 {
+		t.Fatalf("%d requests rejected with the same stale token refreshed it %d times, want once", goroutines, got)
+	}
+	if token := client.accessToken(); token != "Bearer fresh" {
+		t.Fatalf("the client ended up with %s, want the fresh token", token)
+	}
+}
@@ -0,0 +1,51 @@
+package cfclient
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+//Stats is how much work the client has done talking to the api and uaa
+type Stats struct {
+	Requests     int64 `json:"requests"`
+	Unauthorized int64 `json:"unauthorized"`
+	Refreshes    int64 `json:"refreshes"`
+	Retries      int64 `json:"retries"`
+	BytesRead    int64 `json:"bytes_read"`
+	//NotModified counts conditional requests answered from the cached response
+	NotModified int64 `json:"not_modified"`
+}
+
+//requestCounters are updated atomically since collection can run requests in parallel
+type requestCounters struct {
+	requests     int64
+	unauthorized int64
+	refreshes    int64
+	retries      int64
+	bytesRead    int64
+	notModified  int64
+}
+
+//Stats returns a snapshot of the client's request counters
+func (client *Client) Stats() Stats {
+	return Stats{
+		Requests:     atomic.LoadInt64(&client.counters.requests),
+		Unauthorized: atomic.LoadInt64(&client.counters.unauthorized),
+		Refreshes:    atomic.LoadInt64(&client.counters.refreshes),
+		Retries:      atomic.LoadInt64(&client.counters.retries),
+		BytesRead:    atomic.LoadInt64(&client.counters.bytesRead),
+		NotModified:  atomic.LoadInt64(&client.counters.notModified),
+	}
+}
+
+//countingBody adds every byte read from a response body to a counter
+type countingBody struct {
+	io.ReadCloser
+	counter *int64
+}
+
+func (body countingBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	atomic.AddInt64(body.counter, int64(n))
+	return n, err
+}
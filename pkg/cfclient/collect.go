@@ -0,0 +1,247 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//CollectionError is a single org or space that we couldn't collect something for
+type CollectionError struct {
+	Name  string
+	GUID  string
+	Doing string
+	Err   error
+	//Collector is the collector that failed, see CollectorNames
+	Collector string
+}
+
+//CollectorForbidden counts what each collector of a Config.Scoped run wasn't authorized to
+//read, only the ones that were refused anything
+func (result CollectionResult) CollectorForbidden() map[string]int {
+	counts := map[string]int{}
+	for _, forbidden := range result.Forbidden {
+		counts[forbidden.Collector]++
+	}
+	return counts
+}
+
+//CollectorFailures counts the failures of each collector that ran, 0 for the ones that had none
+func (result CollectionResult) CollectorFailures() map[string]int {
+	counts := map[string]int{}
+	for _, name := range result.Collectors {
+		counts[name] = 0
+	}
+	for _, failure := range result.Failures {
+		if failure.Collector != "" {
+			counts[failure.Collector]++
+		}
+	}
+	return counts
+}
+
+func newCollectionError(datapoint Data, whatYoureDoing string, err error) CollectionError {
+	return CollectionError{
+		Name:  datapoint.Name,
+		GUID:  datapoint.GUID,
+		Doing: strings.TrimSpace(whatYoureDoing),
+		Err:   err,
+	}
+}
+
+func (e CollectionError) Error() string {
+	return fmt.Sprintf("%s (%s) failed %s: %s", e.Name, e.GUID, e.Doing, e.Err)
+}
+
+func (e CollectionError) Unwrap() error {
+	return e.Err
+}
+
+//skipForbidden drops the orgs or spaces the api wouldn't show us, along with their failures.
+//a space manager's token sees the org listing but gets 403s from the orgs it isn't in, and
+//recording every later step against those as a failure would only bury the real ones
+func (client *Client) skipForbidden(kind string, dataList []Data, failures []CollectionError) ([]Data, []CollectionError) {
+	forbidden := map[string]bool{}
+	var kept []CollectionError
+	for _, failure := range failures {
+		if IsForbidden(failure) {
+			forbidden[failure.GUID] = true
+			continue
+		}
+		kept = append(kept, failure)
+	}
+	if len(forbidden) == 0 {
+		return dataList, failures
+	}
+
+	var visible []Data
+	for _, datapoint := range dataList {
+		if forbidden[datapoint.GUID] {
+			client.log.Warn("skipping "+kind+", not authorized to see it", kind, datapoint.Name, "guid", datapoint.GUID)
+			continue
+		}
+		visible = append(visible, datapoint)
+	}
+	return visible, kept
+}
+
+//runCollector runs one collector. a panic in it, say over a response shaped like nothing we
+//expected, is a failure of that collector and the rest of the run carries on
+func (client *Client) runCollector(ctx context.Context, collector collector, run *collectRun) (failures []CollectionError) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			client.log.Debug("collector panicked", "collector", collector.name, "panic", recovered, "stack", string(debug.Stack()))
+			failures = append(failures, CollectionError{Name: "foundation", Doing: "running the " + collector.name + " collector", Err: fmt.Errorf("panic: %v", recovered)})
+		}
+	}()
+	return collector.run(client, ctx, run)
+}
+
+//CollectionResult is everything a collection run managed to gather, plus every org/space
+//that failed along the way
+type CollectionResult struct {
+	Orgs     []Data
+	Spaces   []Data
+	Failures []CollectionError
+	//Forbidden is what a Config.Scoped run wasn't authorized to read, left out of Failures
+	Forbidden []CollectionError
+	//Domains is every shared and private domain on the foundation
+	Domains []Domain
+	//Buildpacks is every admin buildpack installed on the foundation
+	Buildpacks []Buildpack
+	//IsolationSegments is every isolation segment with its orgs and spaces
+	IsolationSegments []IsolationSegment
+	//SecurityGroups is every application security group with its rules and spaces
+	SecurityGroups []SecurityGroup
+	//ServiceCatalog is the service brokers and the plans of every service in the marketplace
+	ServiceCatalog ServiceCatalog
+	//Platform is the foundation's api version, build and feature flags
+	Platform Platform
+	//Collectors are the collectors that ran, what the others would have filled in is empty
+	Collectors []string
+	//Scope is what the run was narrowed down to with WithRunScope, zero for a full run
+	Scope RunScope
+	//DeploymentsCollected is true when Config.Deployments filled in the spaces' Deployments
+	//and Revisions, so a space without any is a real zero
+	DeploymentsCollected bool
+	//ProcessesCollected is true when Config.Processes filled in the spaces' Processes and
+	//Sidecars
+	ProcessesCollected bool
+	//SSHCollected is true when Config.SSH filled in the spaces' SSHAllowed and SSHApps
+	SSHCollected bool
+	//EnvScanned is true when Config.EnvScan filled in the spaces' EnvVars
+	EnvScanned bool
+	//EventsSince and EventsUntil are the window audit events were collected from. EventsSince
+	//is zero when it's open, EventsUntil is when the run finished when that end is open
+	EventsSince time.Time
+	EventsUntil time.Time
+	//Requests is how the api requests the run made went, by endpoint
+	Requests []EndpointStats
+	//Spans are the run's trace with Config.Tracing, the run's own span first
+	Spans []Span
+}
+
+//Collect runs a full collection against the foundation, or against just one org when
+//client.orgGUID is set. only failing to list the orgs or spaces is fatal, anything that
+//goes wrong for a single org or space is recorded in Failures and the run carries on.
+//if ctx is cancelled or its deadline passes, in-flight requests and pagination are
+//abandoned and whatever was gathered so far comes back along with ctx's error
+func (client *Client) Collect(ctx context.Context, appStats bool) (CollectionResult, error) {
+	before := client.endpoints.snapshot()
+	started := time.Now()
+	ctx, trace := client.startRun(ctx)
+	result, err := client.collect(ctx, appStats)
+	client.redactor.result(&result)
+	result.Requests = client.endpoints.since(before)
+	result.Spans = trace.finish(started, err)
+	return result, err
+}
+
+func (client *Client) collect(ctx context.Context, appStats bool) (CollectionResult, error) {
+	var result CollectionResult
+	//names can change between runs when the client is reused, so they're kept for the lookup
+	//ttl at most
+	client.lookups.reset()
+
+	var orgs []Data
+	var err error
+	listing, listStep := startStep(ctx, "list orgs")
+	if client.orgGUID != "" {
+		orgs, err = client.getOrgByGUID(listing, client.orgGUID)
+	} else {
+		orgs, err = client.Orgs(listing)
+	}
+	listStep.end(err, "", 0)
+	if err != nil {
+		return result, fmt.Errorf("error getting orgs: %s", err)
+	}
+
+	//start up ui progress bars, fresh ones for every run so a reused client doesn't keep
+	//redrawing the last run's
+	client.progress = uiprogress.New()
+	if client.progressOut != nil {
+		client.progress.SetOut(client.progressOut)
+	} else {
+		client.progress.SetOut(os.Stdout)
+	}
+	client.progress.Start()
+	defer client.progress.Stop()
+
+	//grab all the spaces
+	var spaces []Data
+	listing, listStep = startStep(ctx, "list spaces")
+	if client.orgGUID != "" {
+		spaces, err = client.getOrgSpaces(listing, client.orgGUID)
+	} else {
+		spaces, err = client.Spaces(listing)
+	}
+	listStep.end(err, "", 0)
+	if err != nil {
+		return result, fmt.Errorf("error getting spaces: %s", err)
+	}
+	if client.filter.orgsFiltered() {
+		spaces = spacesInOrgs(spaces, orgs)
+	}
+	scope := runScopeFrom(ctx)
+	orgs, spaces, err = scope.scopeOrgsAndSpaces(orgs, spaces)
+	if err != nil {
+		return result, err
+	}
+
+	run := &collectRun{result: &result, orgs: orgs, spaces: spaces, appStats: appStats, scoped: client.scoped, scope: scope}
+	for _, collector := range collectors {
+		if client.collecting(collector.name) && scope.runs(collector.name) {
+			//a collector's failures leave the rest of the run to carry on with what it has
+			run.collector = collector.name
+			collecting, collectorStep := startStep(ctx, "collector "+collector.name)
+			failures := client.runCollector(collecting, collector, run)
+			for index := range failures {
+				failures[index].Collector = collector.name
+			}
+			failures = run.forbidden(failures)
+			collectorStep.end(ctx.Err(), collector.name, len(failures))
+			result.Failures = append(result.Failures, failures...)
+		}
+	}
+	orgs, spaces = run.orgs, run.spaces
+
+	result.Orgs = orgs
+	result.Spaces = spaces
+	for _, name := range client.Collectors() {
+		if scope.runs(name) {
+			result.Collectors = append(result.Collectors, name)
+		}
+	}
+	result.Scope = scope
+	result.EventsSince = client.eventsSince
+	result.EventsUntil = client.eventWindowEnd()
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("collection stopped early: %s", ctx.Err())
+	}
+	return result, nil
+}
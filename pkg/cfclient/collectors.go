@@ -0,0 +1,283 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//collectRun is what a collection run has gathered so far, handed from collector to collector
+type collectRun struct {
+	result   *CollectionResult
+	orgs     []Data
+	spaces   []Data
+	appStats bool
+	//orgsChecked and spacesChecked are true once the orgs or spaces the api won't show us
+	//have been dropped, by whichever collector went through them first
+	orgsChecked   bool
+	spacesChecked bool
+	//scoped and collector are Config.Scoped and the collector that's running, for fail
+	scoped    bool
+	collector string
+	//scope is what the run was narrowed down to, a partial one leaves the event and usage
+	//cursors for the full runs
+	scope RunScope
+}
+
+//fail records a foundation wide failure, or what a scoped run wasn't authorized to read
+func (run *collectRun) fail(doing string, err error) {
+	if err == nil {
+		return
+	}
+	if run.scoped && IsForbidden(err) {
+		run.result.Forbidden = append(run.result.Forbidden, CollectionError{Name: "foundation", Doing: doing, Err: err, Collector: run.collector})
+		return
+	}
+	run.result.Failures = append(run.result.Failures, CollectionError{Name: "foundation", Doing: doing, Err: err})
+}
+
+//forbidden moves the failures a scoped run got a 403 for to its Forbidden, the rest are
+//real failures
+func (run *collectRun) forbidden(failures []CollectionError) []CollectionError {
+	if !run.scoped {
+		return failures
+	}
+	var kept []CollectionError
+	for _, failure := range failures {
+		if IsForbidden(failure) {
+			run.result.Forbidden = append(run.result.Forbidden, failure)
+			continue
+		}
+		kept = append(kept, failure)
+	}
+	return kept
+}
+
+//collector is one part of a run, after the orgs and spaces are listed. every collector is on
+//unless it's optIn, Config.Collectors names which ones to run instead and
+//Config.SkipCollectors turns some off
+type collector struct {
+	name string
+	//needs are collectors this one goes by the results of, e.g. the apps
+	needs []string
+	//optIn collectors cost a call or more per app or space on top of the rest, so they only
+	//run when they're named or their Config field is set
+	optIn bool
+	run   func(client *Client, ctx context.Context, run *collectRun) []CollectionError
+}
+
+//collectors are every part of a run in the order they run
+var collectors = []collector{
+	{name: "events", run: (*Client).collectEvents},
+	{name: "apps", run: (*Client).collectApps},
+	{name: "services", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		failures := client.getServiceInstances(ctx, run.spaces)
+		assignServiceInstancesToOrgs(run.orgs, run.spaces)
+		return failures
+	}},
+	{name: "user-provided-services", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getUserProvidedServices(ctx, run.spaces)
+	}},
+	{name: "catalog", needs: []string{"services"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		var err error
+		run.result.ServiceCatalog, err = client.getServiceCatalog(ctx, run.spaces)
+		run.fail("listing the service catalog", err)
+		return nil
+	}},
+	{name: "routes", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		failures := client.getRoutes(ctx, run.spaces)
+		var err error
+		run.result.Domains, err = client.getDomains(ctx)
+		run.fail("listing domains", err)
+		return failures
+	}},
+	{name: "buildpacks", needs: []string{"apps"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		var err error
+		run.result.Buildpacks, err = client.getBuildpacks(ctx)
+		run.fail("listing buildpacks", err)
+		return append(client.countBuildpacksAndStacks(ctx, run.orgs), client.countBuildpacksAndStacks(ctx, run.spaces)...)
+	}},
+	{name: "roles", optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return append(client.getRoles(ctx, run.orgs, "organization"), client.getRoles(ctx, run.spaces, "space")...)
+	}},
+	{name: "isolation-segments", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		var err error
+		run.result.IsolationSegments, err = client.getIsolationSegments(ctx, run.orgs, run.spaces)
+		run.fail("listing isolation segments", err)
+		return nil
+	}},
+	{name: "docker", needs: []string{"apps"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getDockerImages(ctx, run.spaces)
+	}},
+	{name: "tasks", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getTasks(ctx, run.spaces)
+	}},
+	{name: "builds", needs: []string{"apps"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getBuilds(ctx, run.spaces)
+	}},
+	{name: "deployments", needs: []string{"apps"}, optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		run.result.DeploymentsCollected = true
+		return client.getDeployments(ctx, run.spaces)
+	}},
+	{name: "blobs", needs: []string{"apps"}, optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getBlobs(ctx, run.spaces)
+	}},
+	{name: "processes", needs: []string{"apps"}, optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		run.result.ProcessesCollected = true
+		return client.getProcesses(ctx, run.spaces)
+	}},
+	{name: "ssh", needs: []string{"apps"}, optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		run.result.SSHCollected = true
+		return client.getSSH(ctx, run.spaces)
+	}},
+	{name: "env-scan", needs: []string{"apps"}, optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		run.result.EnvScanned = true
+		return client.getEnvVars(ctx, run.spaces)
+	}},
+	{name: "security-groups", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		var err error
+		run.result.SecurityGroups, err = client.getSecurityGroups(ctx)
+		run.fail("listing security groups", err)
+		return nil
+	}},
+	{name: "platform", run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		var err error
+		run.result.Platform, err = client.getPlatform(ctx)
+		run.fail("reading the platform info and feature flags", err)
+		return nil
+	}},
+	{name: "names", optIn: true, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.enrichNames(ctx, run.orgs, run.spaces)
+	}},
+	{name: "usage", needs: []string{"apps"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		attachUsageReports(run.orgs, run.spaces)
+		if client.usageCursorPath == "" || run.scope.Partial() {
+			return nil
+		}
+		return client.getAppUsageEvents(ctx, run.orgs, run.spaces)
+	}},
+	{name: "quotas", needs: []string{"usage"}, run: func(client *Client, ctx context.Context, run *collectRun) []CollectionError {
+		return client.getQuotaUsage(ctx, run.orgs, run.spaces)
+	}},
+}
+
+//CollectorNames are the names of every collector, for Config.Collectors and SkipCollectors
+func CollectorNames() []string {
+	var names []string
+	for _, collector := range collectors {
+		names = append(names, collector.name)
+	}
+	return names
+}
+
+//collectEvents associates each audit event type with orgs and then spaces. a space's own
+//create event is in its org's, not in the space
+func (client *Client) collectEvents(ctx context.Context, run *collectRun) []CollectionError {
+	if client.incrementalEvents && !run.scope.Partial() {
+		return client.collectIncrementalEvents(ctx, run)
+	}
+	var failures []CollectionError
+	for _, eventType := range client.eventTypes() {
+		failures = append(failures, client.visible(&run.orgs, &run.orgsChecked, "org", client.getEndpointData(ctx, run.orgs, eventField(eventType), client.eventsEndpoint(eventType, "organization"), eventLabel(eventType, "orgs")))...)
+	}
+	for _, eventType := range client.eventTypes() {
+		if eventType == "audit.space.create" {
+			continue
+		}
+		failures = append(failures, client.visible(&run.spaces, &run.spacesChecked, "space", client.getEndpointData(ctx, run.spaces, eventField(eventType), client.eventsEndpoint(eventType, "space"), eventLabel(eventType, "spaces")))...)
+	}
+	return failures
+}
+
+//collectApps associates apps with orgs and spaces, and reads their instance stats with
+//appStats
+func (client *Client) collectApps(ctx context.Context, run *collectRun) []CollectionError {
+	failures := client.visible(&run.orgs, &run.orgsChecked, "org", client.getEndpointData(ctx, run.orgs, FieldApps, client.appsEndpoint("organization"), "associating apps with orgs"))
+	failures = append(failures, client.visible(&run.spaces, &run.spacesChecked, "space", client.getEndpointData(ctx, run.spaces, FieldApps, client.appsEndpoint("space"), "associating apps with spaces"))...)
+	if client.apiVersion == APIV3 {
+		failures = append(failures, client.foldWebProcesses(ctx, run.orgs, run.spaces)...)
+	}
+	if run.appStats {
+		failures = append(failures, client.getAppInstanceStates(ctx, run.spaces)...)
+	}
+	return failures
+}
+
+//visible drops the orgs or spaces the api wouldn't show us the first time they're gone
+//through, see skipForbidden. anything we can't see then is left out from there on
+func (client *Client) visible(dataList *[]Data, checked *bool, kind string, failures []CollectionError) []CollectionError {
+	if *checked {
+		return failures
+	}
+	*checked = true
+	*dataList, failures = client.skipForbidden(kind, *dataList, failures)
+	return failures
+}
+
+//enabledCollectors works out which collectors a client runs from the config: the ones
+//config.Collectors names, every one that isn't opt in when it doesn't name any, plus the opt in
+//ones whose Config field is set, less config.SkipCollectors
+func enabledCollectors(config Config) (map[string]bool, error) {
+	known := map[string]collector{}
+	for _, collector := range collectors {
+		known[collector.name] = collector
+	}
+	for _, name := range append(append([]string{}, config.Collectors...), config.SkipCollectors...) {
+		if _, exists := known[name]; !exists {
+			return nil, fmt.Errorf("there's no %s collector, the collectors are %s", name, strings.Join(CollectorNames(), ", "))
+		}
+	}
+
+	enabled := map[string]bool{}
+	for _, name := range config.Collectors {
+		enabled[name] = true
+	}
+	if len(config.Collectors) == 0 {
+		for _, collector := range collectors {
+			enabled[collector.name] = !collector.optIn
+		}
+	}
+	for name, on := range map[string]bool{"roles": config.Roles, "deployments": config.Deployments, "blobs": config.BlobSizes, "processes": config.Processes, "ssh": config.SSH, "env-scan": config.EnvScan, "names": config.ResolveNames} {
+		if on {
+			enabled[name] = true
+		}
+	}
+	for _, name := range config.SkipCollectors {
+		delete(enabled, name)
+	}
+
+	var missing []string
+	for _, collector := range collectors {
+		if !enabled[collector.name] {
+			delete(enabled, collector.name)
+			continue
+		}
+		for _, need := range collector.needs {
+			if !enabled[need] {
+				missing = append(missing, fmt.Sprintf("%s needs %s", collector.name, need))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("collectors are missing what they go by: %s", strings.Join(missing, ", "))
+	}
+	return enabled, nil
+}
+
+//collecting is whether the client runs the named collector
+func (client *Client) collecting(name string) bool {
+	return client.collectors[name]
+}
+
+//Collectors are the names of the collectors the client runs, in the order they run
+func (client *Client) Collectors() []string {
+	var names []string
+	for _, collector := range collectors {
+		if client.collecting(collector.name) {
+			names = append(names, collector.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,119 @@
+package cfclient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//conditionalLists are the last path segment of the list endpoints whose responses are kept
+//for conditional requests, the org, space and quota inventories that rarely change between
+//runs. everything else changes too often to be worth holding in memory
+var conditionalLists = map[string]bool{
+	"organizations":           true,
+	"spaces":                  true,
+	"quota_definitions":       true,
+	"space_quota_definitions": true,
+	"organization_quotas":     true,
+	"space_quotas":            true,
+}
+
+//cachedResponse is a response body and the validators to ask the api whether it's changed
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+//responseCache keeps the responses of conditionalLists endpoints that came with an ETag or
+//Last-Modified, by endpoint, for as long as the client lives. a request for one of them asks
+//If-None-Match/If-Modified-Since, and a 304 is answered from the cache so the page isn't sent
+//again. a nil cache sends every request as is
+type responseCache struct {
+	mutex     sync.Mutex
+	responses map[string]*cachedResponse
+}
+
+//conditionalEndpoint is whether responses from endpoint are worth keeping
+func conditionalEndpoint(endpoint string) bool {
+	path, _, _ := strings.Cut(endpoint, "?")
+	return conditionalLists[path[strings.LastIndex(path, "/")+1:]]
+}
+
+func (cache *responseCache) get(endpoint string) *cachedResponse {
+	if cache == nil {
+		return nil
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.responses[endpoint]
+}
+
+//validate makes req conditional on what's cached for endpoint, if anything
+func (cache *responseCache) validate(req *http.Request, endpoint string) {
+	cached := cache.get(endpoint)
+	if cached == nil {
+		return
+	}
+	if cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+}
+
+//replay turns a 304 for endpoint into the 200 it stands for, from the cache. it returns resp
+//untouched when there's nothing cached to replay
+func (cache *responseCache) replay(endpoint string, resp *http.Response) *http.Response {
+	cached := cache.get(endpoint)
+	if cached == nil {
+		return resp
+	}
+	drainAndClose(resp.Body)
+	replayed := *resp
+	replayed.Status, replayed.StatusCode = "200 OK", http.StatusOK
+	replayed.Header = cached.header.Clone()
+	replayed.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+	replayed.ContentLength = int64(len(cached.body))
+	return &replayed
+}
+
+//store keeps a successful response for endpoint when it has validators, reading the body
+//into memory and handing back a response that reads from the copy
+func (cache *responseCache) store(endpoint string, resp *http.Response) (*http.Response, error) {
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if cache == nil || !conditionalEndpoint(endpoint) || (etag == "" && lastModified == "") {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.responses == nil {
+		cache.responses = map[string]*cachedResponse{}
+	}
+	cache.responses[endpoint] = &cachedResponse{etag: etag, lastModified: lastModified, header: resp.Header.Clone(), body: body}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+//conditionalGet is doRequest's part in conditional requests on a GET of endpoint: validating
+//req before it goes out, then answering a 304 from the cache and keeping a fresh response
+func (client *Client) conditionalGet(endpoint string, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode == http.StatusNotModified {
+		atomic.AddInt64(&client.counters.notModified, 1)
+		return client.responses.replay(endpoint, resp), nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp, nil
+	}
+	return client.responses.store(endpoint, resp)
+}
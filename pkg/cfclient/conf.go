@@ -0,0 +1,131 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//CLIConfig is the subset of the cf cli's config.json we need to talk to the api and uaa
+type CLIConfig struct {
+	AccessToken     string `json:"AccessToken" yaml:"accessToken"`
+	RefreshToken    string `json:"RefreshToken" yaml:"refreshToken"`
+	Target          string `json:"Target" yaml:"target"`
+	UAAEndpoint     string `json:"UaaEndpoint" yaml:"uaaEndpoint"`
+	UAAClientID     string `json:"UAAOAuthClient" yaml:"uaaClient"`
+	UAAClientSecret string `json:"UAAOAuthClientSecret" yaml:"uaaClientSecret"`
+	//SSLDisabled is set when the cf cli targeted the api with --skip-ssl-validation
+	SSLDisabled bool `json:"SSLDisabled" yaml:"skipSSLValidation"`
+	//Proxy isn't something the cf cli writes, it only comes from a -config file
+	Proxy string `json:"Proxy" yaml:"proxy"`
+	//NoProxy are the hosts, comma separated, that skip Proxy, see Config.NoProxy
+	NoProxy string `json:"NoProxy,omitempty" yaml:"noProxy"`
+	//RefreshTokenInKeyring is set in a cf-metrics login that keeps its refresh token in the
+	//os keyring instead of in the file
+	RefreshTokenInKeyring bool `json:"RefreshTokenInKeyring,omitempty" yaml:"-"`
+}
+
+//GrabCFCLIENV reads the cf cli's config.json from the first of CFConfigPaths that's there
+func GrabCFCLIENV() (*CLIConfig, error) {
+	paths := CFConfigPaths()
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return LoadCFConfig(path)
+		}
+	}
+	return nil, fmt.Errorf("there's no cf cli config in %s", strings.Join(paths, ", "))
+}
+
+//CFConfigPaths are where the cf cli's config.json could be, in the order they're tried:
+//$CF_HOME/.cf, $CF_PLUGIN_HOME/.cf, the home directory's .cf (%USERPROFILE% on windows) and
+//cf under the xdg config directory, $XDG_CONFIG_HOME or ~/.config
+func CFConfigPaths() []string {
+	var dirs []string
+	for _, variable := range []string{"CF_HOME", "CF_PLUGIN_HOME"} {
+		if home := os.Getenv(variable); home != "" {
+			dirs = append(dirs, filepath.Join(home, ".cf"))
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err == nil {
+		dirs = append(dirs, filepath.Join(home, ".cf"))
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		dirs = append(dirs, filepath.Join(xdg, "cf"))
+	} else if err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", "cf"))
+	}
+
+	var paths []string
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "config.json")
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+//LoadCFConfig reads a cf cli config.json from path, e.g. one given with Config.CFConfigPath
+func LoadCFConfig(path string) (*CLIConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config CLIConfig
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the cf cli config (%s): %s", path, err)
+	}
+	return &config, nil
+}
+
+//LoadConfigFile reads the same settings the cf cli keeps in config.json from an explicit
+//file instead. files ending in .json use the cf cli's own keys, anything else is read as yaml
+func LoadConfigFile(path string) (*CLIConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config at `%s': %s", path, err)
+	}
+
+	var config CLIConfig
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		err = json.Unmarshal(raw, &config)
+	} else {
+		err = yaml.Unmarshal(raw, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse config (%s): %s", path, err)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config (%s): %s", path, err)
+	}
+	return &config, nil
+}
+
+//validate checks that everything needed to talk to the api and uaa is filled in
+func (config *CLIConfig) validate() error {
+	var missing []string
+	if config.Target == "" {
+		missing = append(missing, "target")
+	}
+	if config.UAAClientID == "" {
+		missing = append(missing, "uaa client id")
+	}
+	if config.AccessToken == "" && config.RefreshToken == "" {
+		missing = append(missing, "access token or refresh token")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package cfclient
+
+import "time"
+
+//crash event types, v2 and v3 name the same thing differently
+const (
+	crashEventV2 = "app.crash"
+	crashEventV3 = "audit.app.process.crash"
+)
+
+//crashEventType is the audit event an app instance crashing leaves behind
+func (client *Client) crashEventType() string {
+	if client.apiVersion == APIV3 {
+		return crashEventV3
+	}
+	return crashEventV2
+}
+
+//IsCrashEvent is true for either api's app crash event type
+func IsCrashEvent(eventType string) bool {
+	return eventType == crashEventV2 || eventType == crashEventV3
+}
+
+//AppCrashes counts the crash events in events by app guid. v3's crash events are against
+//the crashed process, and a web process has its app's guid
+func AppCrashes(events []Event) map[string]int {
+	crashes := map[string]int{}
+	for _, event := range events {
+		if IsCrashEvent(event.Type) {
+			crashes[event.Actee]++
+		}
+	}
+	return crashes
+}
+
+//CrashesPerHour is crashes spread over the run's event window. without a -since style
+//start there's no window to spread them over and it's 0
+func (result CollectionResult) CrashesPerHour(crashes int) float64 {
+	return result.PerHour(crashes)
+}
+
+//PerHour is any count of things in the event window, e.g. deployments, spread over it. like
+//CrashesPerHour it's 0 without a start to the window
+func (result CollectionResult) PerHour(count int) float64 {
+	if result.EventsSince.IsZero() || !result.EventsUntil.After(result.EventsSince) {
+		return 0
+	}
+	return float64(count) / result.EventsUntil.Sub(result.EventsSince).Hours()
+}
+
+//eventWindowEnd is the end of the event window, now when it's open ended
+func (client *Client) eventWindowEnd() time.Time {
+	if client.eventsUntil.IsZero() {
+		return time.Now()
+	}
+	return client.eventsUntil
+}
@@ -0,0 +1,251 @@
+package cfclient
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//deploymentBatch is how many app guids go in one /v3/deployments?app_guids= query, so the
+//url stays a sane length for spaces with a lot of apps
+const deploymentBatch = 50
+
+//DeploymentActive is the status value of a deployment that's still rolling out
+const DeploymentActive = "ACTIVE"
+
+//Deployment is a v3 deployment, a rolling (or canary) restart of an app onto a new droplet
+//or revision
+type Deployment struct {
+	GUID    string `json:"guid"`
+	AppGUID string `json:"app_guid"`
+	//Strategy is rolling or canary
+	Strategy string `json:"strategy"`
+	//Status is ACTIVE while rolling out and FINALIZED after, Reason says how it ended, e.g.
+	//DEPLOYED, CANCELED or SUPERSEDED
+	Status          string    `json:"status"`
+	Reason          string    `json:"reason"`
+	RevisionVersion int       `json:"revision_version,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+//AppRevision is which of an app's revisions it's running, for apps with revisions turned on
+type AppRevision struct {
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+	//DeployedVersions are the revisions with running instances, more than one mid deploy
+	DeployedVersions []int `json:"deployed_versions"`
+	LatestVersion    int   `json:"latest_version"`
+	//RolledBackTo is the revision the latest one rolled back to, 0 when it wasn't a rollback
+	RolledBackTo int `json:"rolled_back_to,omitempty"`
+	//WindowVersions are the revisions created in the event window, every one an update of
+	//the app but the first
+	WindowVersions []int `json:"window_versions,omitempty"`
+}
+
+//Pinned is true for an app running an older revision than its newest, or whose newest is a
+//rollback to an older one
+func (revision AppRevision) Pinned() bool {
+	if revision.RolledBackTo > 0 {
+		return true
+	}
+	for _, version := range revision.DeployedVersions {
+		if version == revision.LatestVersion {
+			return false
+		}
+	}
+	return len(revision.DeployedVersions) > 0
+}
+
+//DeploymentSummary is what a space's deployments came to
+type DeploymentSummary struct {
+	//Deployments, Rolling and Canceled only count deployments created in the event window
+	Deployments int `json:"deployments"`
+	Rolling     int `json:"rolling"`
+	Canceled    int `json:"canceled"`
+	//Active counts every deployment still rolling out, however long ago it started
+	Active     int `json:"active"`
+	PinnedApps int `json:"pinned_apps"`
+	//RestartUpdates are the app updates in the event window that weren't deployments: new
+	//revisions no deployment rolled out, so a push or restart that stopped the app to start
+	//it again. only apps with revisions turned on have revisions to count
+	RestartUpdates int `json:"restart_updates"`
+}
+
+//AppUpdates are the deployments and the restart updates, every app update the space's
+//revisions and deployments show
+func (summary DeploymentSummary) AppUpdates() int {
+	return summary.Deployments + summary.RestartUpdates
+}
+
+//ZeroDowntimePercent is how many of the app updates were deployments, 0 without any updates
+func (summary DeploymentSummary) ZeroDowntimePercent() float64 {
+	if summary.AppUpdates() == 0 {
+		return 0
+	}
+	return float64(summary.Deployments) / float64(summary.AppUpdates()) * 100
+}
+
+//rollbackPattern picks the revision out of a rollback revision's description, which the
+//cloud controller words like "Rolled back to revision 3."
+var rollbackPattern = regexp.MustCompile(`(?i)rolled back to revision (\d+)`)
+
+//getDeployments lists each space's deployments created in the event window plus any still
+//active, and which revision every app runs. it's two more calls per app for the revisions
+//so it only runs with Config.Deployments. a foundation without the v3 api has neither
+func (client *Client) getDeployments(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering deployments in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		deployments, err := client.spaceDeployments(ctx, spaces[index].Apps)
+		if IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+			return
+		}
+		spaces[index].Deployments = deployments
+
+		var revisions []AppRevision
+		for _, app := range spaces[index].Apps {
+			revision, err := client.appRevision(ctx, app)
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+				continue
+			}
+			if revision.LatestVersion > 0 {
+				revisions = append(revisions, revision)
+			}
+		}
+		spaces[index].Revisions = revisions
+	})
+	return flattenFailures(perIndex)
+}
+
+//spaceDeployments lists the deployments of apps, a batch of apps at a time. active ones are
+//asked for separately since they may have started before the window
+func (client *Client) spaceDeployments(ctx context.Context, apps []Resource) ([]Deployment, error) {
+	var deployments []Deployment
+	seen := map[string]bool{}
+	for start := 0; start < len(apps); start += deploymentBatch {
+		var guids []string
+		for _, app := range apps[start:min(start+deploymentBatch, len(apps))] {
+			guids = append(guids, app.Metadata.GUID)
+		}
+		endpoint := "/v3/deployments?app_guids=" + strings.Join(guids, ",")
+		for _, query := range []string{client.createdWindowQuery(), "&status_values=" + DeploymentActive} {
+			resources, err := client.Resources(ctx, endpoint+query)
+			if err != nil {
+				return nil, err
+			}
+			for _, resource := range resources {
+				if seen[resource.Metadata.GUID] {
+					continue
+				}
+				seen[resource.Metadata.GUID] = true
+				entity, _ := resource.Entity.(map[string]interface{})
+				version, _ := nestedValue(entity, "revision", "version").(float64)
+				deployments = append(deployments, Deployment{
+					GUID:            resource.Metadata.GUID,
+					AppGUID:         nestedString(entity, "relationships", "app", "data", "guid"),
+					Strategy:        EntityString(resource, "strategy"),
+					Status:          nestedString(entity, "status", "value"),
+					Reason:          nestedString(entity, "status", "reason"),
+					RevisionVersion: int(version),
+					CreatedAt:       resource.Metadata.CreatedAt,
+					UpdatedAt:       resource.Metadata.UpdatedAt,
+				})
+			}
+		}
+	}
+	return deployments, nil
+}
+
+//appRevision works out which revision an app runs. an app without revisions, or without
+//any deployed, comes back with no LatestVersion
+func (client *Client) appRevision(ctx context.Context, app Resource) (AppRevision, error) {
+	revision := AppRevision{AppGUID: app.Metadata.GUID, AppName: EntityString(app, "name")}
+
+	//only the newest revision is wanted, so one page of one
+	var latest APIResponse
+	err := client.cfAPIRequest(ctx, "/v3/apps/"+app.Metadata.GUID+"/revisions?order_by=-created_at&per_page=1", &latest)
+	if IsNotFound(err) {
+		return revision, nil
+	}
+	if err != nil {
+		return revision, err
+	}
+	if len(latest.Resources) == 0 {
+		return revision, nil
+	}
+	deployed, err := client.Resources(ctx, "/v3/apps/"+app.Metadata.GUID+"/revisions/deployed")
+	if err != nil {
+		return revision, err
+	}
+
+	revision.LatestVersion = EntityInt(latest.Resources[0], "version")
+	if match := rollbackPattern.FindStringSubmatch(EntityString(latest.Resources[0], "description")); match != nil {
+		revision.RolledBackTo, _ = strconv.Atoi(match[1])
+	}
+	for _, resource := range deployed {
+		revision.DeployedVersions = append(revision.DeployedVersions, EntityInt(resource, "version"))
+	}
+	created, err := client.Resources(ctx, "/v3/apps/"+app.Metadata.GUID+"/revisions?"+strings.TrimPrefix(client.createdWindowQuery(), "&"))
+	if err != nil {
+		return revision, err
+	}
+	for _, resource := range created {
+		revision.WindowVersions = append(revision.WindowVersions, EntityInt(resource, "version"))
+	}
+	return revision, nil
+}
+
+//SummarizeDeployments counts up a space's deployments since the start of the event window,
+//every one when since is zero, its pinned apps and the updates that weren't deployments
+func SummarizeDeployments(space Data, since time.Time) DeploymentSummary {
+	var summary DeploymentSummary
+	deployedRevisions := map[string]bool{}
+	for _, deployment := range space.Deployments {
+		if deployment.Status == DeploymentActive {
+			summary.Active++
+		}
+		if deployment.RevisionVersion > 0 {
+			deployedRevisions[deployment.AppGUID+"/"+strconv.Itoa(deployment.RevisionVersion)] = true
+		}
+		if deployment.CreatedAt.Before(since) {
+			continue
+		}
+		summary.Deployments++
+		if deployment.Strategy == "rolling" {
+			summary.Rolling++
+		}
+		if deployment.Reason == "CANCELED" {
+			summary.Canceled++
+		}
+	}
+	for _, revision := range space.Revisions {
+		if revision.Pinned() {
+			summary.PinnedApps++
+		}
+		//the first revision is the app's first push, not an update
+		for _, version := range revision.WindowVersions {
+			if version > 1 && !deployedRevisions[revision.AppGUID+"/"+strconv.Itoa(version)] {
+				summary.RestartUpdates++
+			}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,97 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//DockerApp is an app pushed as a docker image rather than staged with a buildpack
+type DockerApp struct {
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+	Image   string `json:"image"`
+	//Registry is the registry the image is pulled from, docker.io for a bare image name
+	Registry string `json:"registry"`
+}
+
+//IsDockerApp is true for apps with the docker lifecycle. v2 apps have a docker_image, v3
+//apps a lifecycle type of docker
+func IsDockerApp(app Resource) bool {
+	if EntityString(app, "docker_image") != "" {
+		return true
+	}
+	entity, isMap := app.Entity.(map[string]interface{})
+	return isMap && nestedString(entity, "lifecycle", "type") == "docker"
+}
+
+//ImageRegistry is the registry part of an image reference. like docker, the first part of
+//the path is only a registry when it looks like a host: it has a dot or a port, or is
+//localhost. anything else comes from docker hub
+func ImageRegistry(image string) string {
+	first, _, hasPath := strings.Cut(image, "/")
+	if hasPath && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+//getDockerImages records the image of every docker app in each space. v2 apps carry their
+//image, a v3 app's is on its current droplet so that's a call per docker app
+func (client *Client) getDockerImages(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering docker images in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		var dockerApps []DockerApp
+		for _, app := range spaces[index].Apps {
+			if !IsDockerApp(app) {
+				continue
+			}
+			image, err := client.dockerImage(ctx, app)
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+				continue
+			}
+			dockerApp := DockerApp{AppGUID: app.Metadata.GUID, AppName: EntityString(app, "name"), Image: image}
+			if image != "" {
+				dockerApp.Registry = ImageRegistry(image)
+			}
+			dockerApps = append(dockerApps, dockerApp)
+		}
+		spaces[index].DockerApps = dockerApps
+		bar.Incr()
+	})
+	return flattenFailures(perIndex)
+}
+
+//dockerImage is the image a docker app runs. a v3 app that's never been staged has no
+//droplet and so no image yet
+func (client *Client) dockerImage(ctx context.Context, app Resource) (string, error) {
+	if image := EntityString(app, "docker_image"); image != "" {
+		return image, nil
+	}
+	resp, err := client.doGetRequest(ctx, "/v3/apps/"+app.Metadata.GUID+"/droplets/current")
+	if IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var droplet struct {
+		Image string `json:"image"`
+	}
+	err = decodeBody(resp, &droplet)
+	if err != nil {
+		return "", fmt.Errorf("error unmarshalling the current droplet of %s: %s", app.Metadata.GUID, err)
+	}
+	return droplet.Image, nil
+}
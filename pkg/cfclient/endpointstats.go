@@ -0,0 +1,180 @@
+package cfclient
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//LatencyBuckets are the upper bounds in seconds of the request latency histogram buckets, the
+//last bucket in EndpointStats.Buckets is everything slower
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+//EndpointStats is how the requests to one api endpoint went. Endpoint is the path with any
+//query and guids taken out, e.g. /v3/spaces/:guid/features
+type EndpointStats struct {
+	Endpoint string `json:"endpoint"`
+	Requests int64  `json:"requests"`
+	//Errors are requests that didn't get a response or got a non 2xx one
+	Errors int64 `json:"errors"`
+	//Retries are requests made again after a retryable error or a token refresh
+	Retries int64         `json:"retries"`
+	Latency time.Duration `json:"latency_ns"`
+	//Buckets counts requests by LatencyBuckets, one more than there are bounds
+	Buckets []int64 `json:"buckets"`
+	//Exemplars are the latest traced request in each of Buckets, nil without Config.Tracing
+	Exemplars []*Exemplar `json:"exemplars,omitempty"`
+}
+
+//ErrorRate is the fraction of requests that failed
+func (stats EndpointStats) ErrorRate() float64 {
+	if stats.Requests == 0 {
+		return 0
+	}
+	return float64(stats.Errors) / float64(stats.Requests)
+}
+
+//MeanLatency is the average time a request took
+func (stats EndpointStats) MeanLatency() time.Duration {
+	if stats.Requests == 0 {
+		return 0
+	}
+	return stats.Latency / time.Duration(stats.Requests)
+}
+
+//Quantile estimates the q quantile latency from the buckets the way prometheus'
+//histogram_quantile does, interpolating inside the bucket it falls in. anything in the last
+//bucket is reported as the largest bound
+func (stats EndpointStats) Quantile(q float64) time.Duration {
+	rank := q * float64(stats.Requests)
+	var seen float64
+	lower := 0.0
+	for i, bound := range LatencyBuckets {
+		count := float64(stats.Buckets[i])
+		if count > 0 && seen+count >= rank {
+			seconds := lower + (bound-lower)*(rank-seen)/count
+			return time.Duration(seconds * float64(time.Second))
+		}
+		seen += count
+		lower = bound
+	}
+	return time.Duration(lower * float64(time.Second))
+}
+
+//endpointCounters are the stats of every endpoint the client has called, the zero value is
+//ready to use
+type endpointCounters struct {
+	mutex     sync.Mutex
+	endpoints map[string]*EndpointStats
+}
+
+func (counters *endpointCounters) get(endpoint string) *EndpointStats {
+	if counters.endpoints == nil {
+		counters.endpoints = map[string]*EndpointStats{}
+	}
+	name := endpointName(endpoint)
+	stats, exists := counters.endpoints[name]
+	if !exists {
+		stats = &EndpointStats{Endpoint: name, Buckets: make([]int64, len(LatencyBuckets)+1)}
+		counters.endpoints[name] = stats
+	}
+	return stats
+}
+
+//request records one request to endpoint that took latency, exemplar being its span's when
+//it's traced
+func (counters *endpointCounters) request(endpoint string, latency time.Duration, failed bool, exemplar *Exemplar) {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	stats := counters.get(endpoint)
+	stats.Requests++
+	if failed {
+		stats.Errors++
+	}
+	stats.Latency += latency
+	bucket := sort.SearchFloat64s(LatencyBuckets, latency.Seconds())
+	stats.Buckets[bucket]++
+	if exemplar != nil {
+		if stats.Exemplars == nil {
+			stats.Exemplars = make([]*Exemplar, len(stats.Buckets))
+		}
+		exemplar.Latency = latency.Seconds()
+		stats.Exemplars[bucket] = exemplar
+	}
+}
+
+func (counters *endpointCounters) retry(endpoint string) {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	counters.get(endpoint).Retries++
+}
+
+//snapshot copies every endpoint's stats
+func (counters *endpointCounters) snapshot() map[string]EndpointStats {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	copied := map[string]EndpointStats{}
+	for name, stats := range counters.endpoints {
+		entry := *stats
+		entry.Buckets = append([]int64{}, stats.Buckets...)
+		if stats.Exemplars != nil {
+			entry.Exemplars = append([]*Exemplar{}, stats.Exemplars...)
+		}
+		copied[name] = entry
+	}
+	return copied
+}
+
+//since is what's been recorded after before was taken, by endpoint name
+func (counters *endpointCounters) since(before map[string]EndpointStats) []EndpointStats {
+	var stats []EndpointStats
+	for name, now := range counters.snapshot() {
+		then, existed := before[name]
+		if existed {
+			now.Requests -= then.Requests
+			now.Errors -= then.Errors
+			now.Retries -= then.Retries
+			now.Latency -= then.Latency
+			for i := range now.Buckets {
+				now.Buckets[i] -= then.Buckets[i]
+			}
+			//an exemplar still there from before isn't one of these requests'
+			recent := false
+			for i := range now.Exemplars {
+				if then.Exemplars != nil && now.Exemplars[i] == then.Exemplars[i] {
+					now.Exemplars[i] = nil
+				}
+				recent = recent || now.Exemplars[i] != nil
+			}
+			if !recent {
+				now.Exemplars = nil
+			}
+		}
+		if now.Requests > 0 || now.Retries > 0 {
+			stats = append(stats, now)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Endpoint < stats[j].Endpoint
+	})
+	return stats
+}
+
+//endpointName is endpoint without its query, and with guids swapped for :guid so every org's
+//or space's requests to the same endpoint add up
+func endpointName(endpoint string) string {
+	path, _, _ := strings.Cut(endpoint, "?")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if guidPattern.MatchString(segment) {
+			segments[i] = ":guid"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+//EndpointStats is how every endpoint called since the client was made has done, by endpoint
+func (client *Client) EndpointStats() []EndpointStats {
+	return client.endpoints.since(nil)
+}
@@ -0,0 +1,125 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+)
+
+//collectedNames are the names of the orgs, spaces and apps of a collection run, by guid, so
+//enriching its events and bindings mostly doesn't need any more requests
+type collectedNames struct {
+	orgs   map[string]string
+	spaces map[string]string
+	apps   map[string]string
+}
+
+func newCollectedNames(orgs []Data, spaces []Data) collectedNames {
+	names := collectedNames{orgs: map[string]string{}, spaces: map[string]string{}, apps: map[string]string{}}
+	for _, org := range orgs {
+		names.orgs[org.GUID] = org.Name
+	}
+	for _, space := range spaces {
+		names.spaces[space.GUID] = space.Name
+		for _, app := range space.Apps {
+			names.apps[app.Metadata.GUID] = EntityString(app, "name")
+		}
+	}
+	return names
+}
+
+//nameResolver fills in names by guid, from what's been collected when it can and the api
+//when it can't. it counts the lookups that failed rather than failing, a name is a nicety
+type nameResolver struct {
+	client    *Client
+	collected collectedNames
+	failed    int
+	lastErr   error
+	//redactor redacts the actor and actee names events fills in, for events that were
+	//redacted before their names were resolved. nil for the ones redacted after
+	redactor *redactor
+}
+
+func (resolver *nameResolver) resolve(ctx context.Context, kind string, guid string) string {
+	if guid == "" {
+		return ""
+	}
+	known := map[string]map[string]string{"organization": resolver.collected.orgs, "space": resolver.collected.spaces, "app": resolver.collected.apps}[kind]
+	if name, exists := known[guid]; exists {
+		return name
+	}
+	var name string
+	var err error
+	switch kind {
+	case "organization":
+		name, err = resolver.client.ResolveOrgName(ctx, guid)
+	case "space":
+		name, err = resolver.client.ResolveSpaceName(ctx, guid)
+	case "app":
+		name, err = resolver.client.ResolveAppName(ctx, guid)
+	case "user":
+		name, err = resolver.client.ResolveUserName(ctx, guid)
+	default:
+		return ""
+	}
+	if err != nil {
+		resolver.failed++
+		resolver.lastErr = err
+		return ""
+	}
+	return name
+}
+
+//events adds the org and space names to events, and the actor and actee names the api left
+//out, in place
+func (resolver *nameResolver) events(ctx context.Context, events []Event) {
+	for i := range events {
+		event := &events[i]
+		event.OrganizationName = resolver.resolve(ctx, "organization", event.OrganizationGUID)
+		event.SpaceName = resolver.resolve(ctx, "space", event.SpaceGUID)
+		if event.ActorName == "" {
+			event.ActorName = resolver.redactor.name(event.ActorType, resolver.resolve(ctx, event.ActorType, event.Actor))
+		}
+		if event.ActeeName == "" {
+			event.ActeeName = resolver.redactor.name(event.ActeeType, resolver.resolve(ctx, event.ActeeType, event.Actee))
+		}
+	}
+}
+
+//err is what went wrong looking names up, as one error for however many lookups failed
+func (resolver *nameResolver) err() error {
+	if resolver.failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("could not look up %d names, the last because: %s", resolver.failed, resolver.lastErr)
+}
+
+//enrichNames adds names to the events of every org and space and to the app bindings of their
+//service instances, for Config.ResolveNames
+func (client *Client) enrichNames(ctx context.Context, orgs []Data, spaces []Data) []CollectionError {
+	resolver := nameResolver{client: client, collected: newCollectedNames(orgs, spaces)}
+	for _, datapoints := range [][]Data{orgs, spaces} {
+		for _, datapoint := range datapoints {
+			resolver.events(ctx, datapoint.Events)
+			for _, instance := range datapoint.ServiceInstances {
+				for i := range instance.AppBindings {
+					instance.AppBindings[i].AppName = resolver.resolve(ctx, "app", instance.AppBindings[i].AppGUID)
+				}
+			}
+		}
+	}
+	err := resolver.err()
+	if err != nil {
+		return []CollectionError{{Name: "foundation", Doing: "resolving names", Err: err}}
+	}
+	return nil
+}
+
+//ResolveEventNames adds the org and space names to events, and the actor and actee names the
+//api left out, in place. the names are looked up once each through the lookup cache, a name
+//that can't be looked up is left empty and counted in the error, which comes after the rest
+//are filled in
+func (client *Client) ResolveEventNames(ctx context.Context, events []Event) error {
+	resolver := nameResolver{client: client, redactor: client.redactor}
+	resolver.events(ctx, events)
+	return resolver.err()
+}
@@ -0,0 +1,107 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//suspiciousEnvPattern matches environment variable names that look like they hold a secret,
+//which belongs in a service binding (or credhub) rather than in the app's env
+var suspiciousEnvPattern = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_?KEY|ACCESS_?KEY|CREDENTIAL)`)
+
+//SuspiciousEnvName is true for environment variable names that look like secrets
+func SuspiciousEnvName(name string) bool {
+	return suspiciousEnvPattern.MatchString(name)
+}
+
+//AppEnv is the names of the environment variables an app sets itself, never their values
+type AppEnv struct {
+	AppGUID string   `json:"app_guid"`
+	AppName string   `json:"app_name"`
+	Names   []string `json:"names"`
+	//Suspicious are the Names that look like secrets
+	Suspicious []string `json:"suspicious,omitempty"`
+}
+
+//getEnvVars records the names of every app's user provided environment variables, a call per
+//app that needs space developer or admin read, so it only runs with Config.EnvScan. the values
+//are dropped as soon as the response is decoded
+func (client *Client) getEnvVars(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "scanning app env var names"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		var envs []AppEnv
+		for _, app := range spaces[index].Apps {
+			names, err := client.envNames(ctx, app.Metadata.GUID)
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+				continue
+			}
+			env := AppEnv{AppGUID: app.Metadata.GUID, AppName: EntityString(app, "name"), Names: names}
+			for _, name := range names {
+				if SuspiciousEnvName(name) {
+					env.Suspicious = append(env.Suspicious, name)
+				}
+			}
+			envs = append(envs, env)
+		}
+		spaces[index].EnvVars = envs
+	})
+	return flattenFailures(perIndex)
+}
+
+//envNames are the sorted names of an app's user provided environment variables, from v2's
+//environment_json or v3's var
+func (client *Client) envNames(ctx context.Context, guid string) ([]string, error) {
+	endpoint := "/v2/apps/" + guid + "/env"
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/apps/" + guid + "/environment_variables"
+	}
+	var env struct {
+		V2 map[string]json.RawMessage `json:"environment_json"`
+		V3 map[string]json.RawMessage `json:"var"`
+	}
+	resp, err := client.doGetRequest(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeBody(resp, &env)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling the environment of %s: %s", guid, err)
+	}
+	var names []string
+	for _, vars := range []map[string]json.RawMessage{env.V2, env.V3} {
+		for name := range vars {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//SuspiciousEnvApps counts a space's apps with suspicious environment variable names, and the
+//names across them
+func SuspiciousEnvApps(space Data) (int, int) {
+	apps, names := 0, 0
+	for _, env := range space.EnvVars {
+		if len(env.Suspicious) > 0 {
+			apps++
+			names += len(env.Suspicious)
+		}
+	}
+	return apps, names
+}
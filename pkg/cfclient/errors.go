@@ -0,0 +1,109 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//APIError is returned when the cloud controller answers with a non 2xx status code. when the
+//body is a cloud controller error payload, v2's {code, description, error_code} or v3's
+//{errors: [{code, title, detail}]}, it's parsed into Code, ErrorCode and Description
+type APIError struct {
+	StatusCode int
+	Path       string
+	Body       string
+	//Code is the cloud controller's numeric error code, e.g. 10003
+	Code int
+	//ErrorCode is the cloud controller's error name, e.g. CF-NotAuthorized
+	ErrorCode   string
+	Description string
+	//RetryAfter is how long the api asked us to wait before trying again, if it said
+	RetryAfter time.Duration
+}
+
+type v2ErrorPayload struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+	ErrorCode   string `json:"error_code"`
+}
+
+type v3ErrorPayload struct {
+	Errors []struct {
+		Code   int    `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+func newAPIError(resp *http.Response, endpoint string, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Path: endpoint, Body: string(body), RetryAfter: retryAfter(resp)}
+
+	var v3 v3ErrorPayload
+	if json.Unmarshal(body, &v3) == nil && len(v3.Errors) > 0 {
+		apiErr.Code = v3.Errors[0].Code
+		apiErr.ErrorCode = v3.Errors[0].Title
+		apiErr.Description = v3.Errors[0].Detail
+		return apiErr
+	}
+	var v2 v2ErrorPayload
+	if json.Unmarshal(body, &v2) == nil {
+		apiErr.Code = v2.Code
+		apiErr.ErrorCode = v2.ErrorCode
+		apiErr.Description = v2.Description
+	}
+	return apiErr
+}
+
+//readErrorBody reads the start of an error response's body. error pages from a misbehaving
+//proxy can be huge, the start of one is plenty
+func readErrorBody(resp *http.Response) ([]byte, error) {
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+func (e *APIError) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("%s (%d) from %s: %s", e.ErrorCode, e.StatusCode, e.Path, e.Description)
+	}
+	return fmt.Sprintf("bad response code %d in response from %s, dumping body: %s", e.StatusCode, e.Path, e.Body)
+}
+
+func hasStatus(err error, statusCodes ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, statusCode := range statusCodes {
+		if apiErr.StatusCode == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+//IsNotFound is true when err is, or wraps, a 404 from the cloud controller
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+//IsForbidden is true for a 403, and for a 401 that a fresh token didn't fix. either way the
+//user we're collecting as can't see the resource
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized, http.StatusForbidden)
+}
+
+//IsUnprocessable is true for a 422, the cloud controller understood the request but won't
+//act on it, e.g. a filter on a field it doesn't know
+func IsUnprocessable(err error) bool {
+	return hasStatus(err, http.StatusUnprocessableEntity)
+}
+
+//IsServerError is true for any 5xx, including the ones that were retried until we gave up
+func IsServerError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode/100 == 5
+}
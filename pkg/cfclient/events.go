@@ -0,0 +1,188 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+//Event is a parsed audit event, so consumers don't have to dig through the generic entity
+type Event struct {
+	GUID             string                 `json:"guid"`
+	Type             string                 `json:"type"`
+	Actor            string                 `json:"actor"`
+	ActorType        string                 `json:"actor_type"`
+	ActorName        string                 `json:"actor_name"`
+	Actee            string                 `json:"actee"`
+	ActeeType        string                 `json:"actee_type"`
+	ActeeName        string                 `json:"actee_name"`
+	Timestamp        time.Time              `json:"timestamp"`
+	Metadata         map[string]interface{} `json:"metadata"`
+	SpaceGUID        string                 `json:"space_guid"`
+	OrganizationGUID string                 `json:"organization_guid"`
+	//OrganizationName and SpaceName are only filled in with Config.ResolveNames or by
+	//ResolveEventNames
+	OrganizationName string `json:"organization_name,omitempty"`
+	SpaceName        string `json:"space_name,omitempty"`
+}
+
+//v3AuditEvent is the shape of a /v3/audit_events resource
+type v3AuditEvent struct {
+	Type  string `json:"type"`
+	Actor struct {
+		GUID string `json:"guid"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"actor"`
+	Target struct {
+		GUID string `json:"guid"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"target"`
+	Data  map[string]interface{} `json:"data"`
+	Space struct {
+		GUID string `json:"guid"`
+	} `json:"space"`
+	Organization struct {
+		GUID string `json:"guid"`
+	} `json:"organization"`
+}
+
+//eventFromResource unmarshals an /v2/events or /v3/audit_events resource into an Event
+func eventFromResource(resource Resource) (Event, error) {
+	var event Event
+	if entity, isMap := resource.Entity.(map[string]interface{}); isMap && entity["target"] != nil {
+		return eventFromV3Resource(resource)
+	}
+	raw, err := json.Marshal(resource.Entity)
+	if err != nil {
+		return event, err
+	}
+	err = json.Unmarshal(raw, &event)
+	if err != nil {
+		return event, err
+	}
+	event.GUID = resource.Metadata.GUID
+	return event, nil
+}
+
+//eventFromV3Resource maps a v3 audit event onto the v2 style Event, target becomes actee
+//and data becomes metadata
+func eventFromV3Resource(resource Resource) (Event, error) {
+	var event Event
+	raw, err := json.Marshal(resource.Entity)
+	if err != nil {
+		return event, err
+	}
+	var in v3AuditEvent
+	err = json.Unmarshal(raw, &in)
+	if err != nil {
+		return event, err
+	}
+	return Event{
+		GUID:             resource.Metadata.GUID,
+		Type:             in.Type,
+		Actor:            in.Actor.GUID,
+		ActorType:        in.Actor.Type,
+		ActorName:        in.Actor.Name,
+		Actee:            in.Target.GUID,
+		ActeeType:        in.Target.Type,
+		ActeeName:        in.Target.Name,
+		Timestamp:        resource.Metadata.CreatedAt,
+		Metadata:         in.Data,
+		SpaceGUID:        in.Space.GUID,
+		OrganizationGUID: in.Organization.GUID,
+	}, nil
+}
+
+//addEvents parses the event resources into events and merges them in, keeping the whole
+//list sorted by timestamp
+func addEvents(events []Event, resources []Resource) ([]Event, error) {
+	for _, resource := range resources {
+		event, err := eventFromResource(resource)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
+
+//Events lists the foundation's audit events of the given types, or of every type when there
+//are none, inside the client's event window and oldest first. the org and space filters
+//aren't applied, OrganizationGUID and SpaceGUID are there for callers to match on. they're
+//redacted with Config.Redaction
+func (client *Client) Events(ctx context.Context, eventTypes []string) ([]Event, error) {
+	var resources []Resource
+	err := client.EachResource(ctx, client.eventListEndpoint(eventTypes), func(resource Resource) error {
+		sanitizeEvents(&resource)
+		resources = append(resources, resource)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	events, err := addEvents(nil, resources)
+	client.redactor.events(events)
+	return events, err
+}
+
+//DefaultEventTypes are the audit event types collected for every org and space when
+//Config.EventTypes isn't set, along with the api version's crash event
+var DefaultEventTypes = []string{"audit.app.create", "audit.app.start", "audit.app.update", "audit.space.create"}
+
+//eventFields are the event types kept in a Data field of their own as well as in Events
+var eventFields = map[string]DataField{
+	"audit.app.create":   FieldAppCreates,
+	"audit.app.start":    FieldAppStarts,
+	"audit.app.update":   FieldAppUpdates,
+	"audit.space.create": FieldSpaceCreates,
+	crashEventV2:         FieldAppCrashes,
+	crashEventV3:         FieldAppCrashes,
+}
+
+//eventLabels name the event types with a field of their own on the progress bars
+var eventLabels = map[string]string{
+	"audit.app.create":   "app creates",
+	"audit.app.start":    "app starts",
+	"audit.app.update":   "app updates",
+	"audit.space.create": "space creates",
+	crashEventV2:         "app crashes",
+	crashEventV3:         "app crashes",
+}
+
+//eventTypes are the audit event types a collection gathers for each org and space
+func (client *Client) eventTypes() []string {
+	if client.eventTypeList != nil {
+		return client.eventTypeList
+	}
+	return append(append([]string{}, DefaultEventTypes...), client.crashEventType())
+}
+
+//eventField is where events of eventType go, FieldEvents for the ones only kept in Events
+func eventField(eventType string) DataField {
+	if field, exists := eventFields[eventType]; exists {
+		return field
+	}
+	return FieldEvents
+}
+
+//HasEventField is true for the event types kept in a Data field of their own, like
+//AppCreates, as well as in Events
+func HasEventField(eventType string) bool {
+	_, exists := eventFields[eventType]
+	return exists
+}
+
+//eventLabel is what the progress bar for collecting eventType says it's doing
+func eventLabel(eventType string, kind string) string {
+	label, exists := eventLabels[eventType]
+	if !exists {
+		label = eventType + " events"
+	}
+	return "associating " + label + " with " + kind
+}
@@ -0,0 +1,169 @@
+package cfclient
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+//Filter scopes collection down to a subset of the foundation. org entries can be
+//either org names or org guids. any entry can also be a glob (team-*) or a regex between
+//slashes (/^team-(a|b)$/). excludes always win over includes
+type Filter struct {
+	IncludeOrgs   []string
+	ExcludeOrgs   []string
+	IncludeSpaces []string
+	ExcludeSpaces []string
+	//SpaceLabelSelector and AppLabelSelector are v3 label selectors, e.g. team=payments or
+	//env in (prod,staging), that the api applies to the space and app listings
+	SpaceLabelSelector string
+	AppLabelSelector   string
+	//regexes are the /regex/ entries compiled, filled in by Validate
+	regexes map[string]*regexp.Regexp
+}
+
+func (filter Filter) spacesFiltered() bool {
+	return len(filter.IncludeSpaces) > 0 || len(filter.ExcludeSpaces) > 0 || filter.SpaceLabelSelector != ""
+}
+
+func (filter Filter) labelSelected() bool {
+	return filter.SpaceLabelSelector != "" || filter.AppLabelSelector != ""
+}
+
+func (filter Filter) orgsFiltered() bool {
+	return len(filter.IncludeOrgs) > 0 || len(filter.ExcludeOrgs) > 0
+}
+
+func (filter Filter) allowOrg(name string, guid string) bool {
+	if matchesAny(filter.regexes, filter.ExcludeOrgs, name, guid) {
+		return false
+	}
+	return len(filter.IncludeOrgs) == 0 || matchesAny(filter.regexes, filter.IncludeOrgs, name, guid)
+}
+
+func (filter Filter) allowSpace(name string) bool {
+	if matchesAny(filter.regexes, filter.ExcludeSpaces, name) {
+		return false
+	}
+	return len(filter.IncludeSpaces) == 0 || matchesAny(filter.regexes, filter.IncludeSpaces, name)
+}
+
+func matchesAny(regexes map[string]*regexp.Regexp, list []string, values ...string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if matches(regexes, item, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//isRegex is true for a /regex/ filter entry
+func isRegex(item string) bool {
+	return len(item) > 2 && strings.HasPrefix(item, "/") && strings.HasSuffix(item, "/")
+}
+
+//isPattern is true for entries that have to be matched by us rather than by the api
+func isPattern(item string) bool {
+	return isRegex(item) || strings.ContainsAny(item, "*?[")
+}
+
+//matches checks a value against a filter entry, an exact name/guid, a glob or a /regex/
+//compiled in regexes. entries are checked by Validate up front so bad patterns here just
+//don't match
+func matches(regexes map[string]*regexp.Regexp, item string, value string) bool {
+	if isRegex(item) {
+		pattern := regexes[item]
+		return pattern != nil && pattern.MatchString(value)
+	}
+	if strings.ContainsAny(item, "*?[") {
+		matched, err := path.Match(item, value)
+		return err == nil && matched
+	}
+	return item == value
+}
+
+//Validate checks that every glob and regex in the filter compiles, and keeps the compiled
+//regexes for matching against
+func (filter *Filter) Validate() error {
+	var err error
+	filter.regexes, err = compilePatterns(filter.IncludeOrgs, filter.ExcludeOrgs, filter.IncludeSpaces, filter.ExcludeSpaces)
+	if err != nil {
+		return fmt.Errorf("bad filter %s", err)
+	}
+	return nil
+}
+
+//compilePatterns checks that every glob and /regex/ entry in lists compiles, an exact name
+//always does, and hands back the regexes compiled by entry
+func compilePatterns(lists ...[]string) (map[string]*regexp.Regexp, error) {
+	regexes := map[string]*regexp.Regexp{}
+	for _, list := range lists {
+		for _, item := range list {
+			var err error
+			if isRegex(item) {
+				regexes[item], err = regexp.Compile(item[1 : len(item)-1])
+			} else if isPattern(item) {
+				_, err = path.Match(item, "")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", item, err)
+			}
+		}
+	}
+	return regexes, nil
+}
+
+//nameQuery builds a "?q=name IN a,b" query so the api does the filtering for us. it returns
+//"" when the list can't be expressed that way (guids or patterns in it, or names containing
+//commas) and we have to filter after fetching instead
+func nameQuery(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	for _, name := range names {
+		if guidPattern.MatchString(name) || isPattern(name) || strings.Contains(name, ",") {
+			return ""
+		}
+	}
+	return "?q=" + url.QueryEscape("name IN "+strings.Join(names, ","))
+}
+
+//labelSelectorQuery is a label_selector= query parameter for selector, "" without one
+func labelSelectorQuery(selector string) string {
+	if selector == "" {
+		return ""
+	}
+	return "label_selector=" + url.QueryEscape(selector)
+}
+
+//withQuery adds query to endpoint's query string, if there is one
+func withQuery(endpoint string, query string) string {
+	if query == "" {
+		return endpoint
+	}
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query
+	}
+	return endpoint + "?" + query
+}
+
+//spacesInOrgs drops any space that doesn't belong to one of the given orgs
+func spacesInOrgs(spaces []Data, orgs []Data) []Data {
+	orgGUIDs := map[string]bool{}
+	for _, org := range orgs {
+		orgGUIDs[org.GUID] = true
+	}
+	var kept []Data
+	for _, space := range spaces {
+		if orgGUIDs[space.OrganizationGUID] {
+			kept = append(kept, space)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,47 @@
+package cfclient
+
+import "testing"
+
+func TestFilterMatchesNamesGlobsAndRegexes(t *testing.T) {
+	filter := Filter{
+		IncludeOrgs:   []string{"/^team-(a|b)$/", "ops-*", "5f0c3a8e-1b2d-4c6e-9f7a-0b1c2d3e4f5a"},
+		ExcludeOrgs:   []string{"ops-sandbox"},
+		ExcludeSpaces: []string{"/-scratch$/"},
+	}
+	err := filter.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		org     string
+		guid    string
+		allowed bool
+	}{
+		{"team-a", "", true},
+		{"team-c", "", false},
+		{"ops-prod", "", true},
+		{"ops-sandbox", "", false},
+		{"renamed", "5f0c3a8e-1b2d-4c6e-9f7a-0b1c2d3e4f5a", true},
+		{"team-ab", "", false},
+	} {
+		if got := filter.allowOrg(test.org, test.guid); got != test.allowed {
+			t.Errorf("org %s %s: got allowed %t, want %t", test.org, test.guid, got, test.allowed)
+		}
+	}
+	for space, allowed := range map[string]bool{"dev": true, "dev-scratch": false} {
+		if got := filter.allowSpace(space); got != allowed {
+			t.Errorf("space %s: got allowed %t, want %t", space, got, allowed)
+		}
+	}
+}
+
+func TestFilterValidateTurnsAwayBadPatterns(t *testing.T) {
+	for _, filter := range []Filter{
+		{IncludeOrgs: []string{"/team-(a/"}},
+		{ExcludeSpaces: []string{"dev-["}},
+	} {
+		if err := filter.Validate(); err == nil {
+			t.Errorf("%+v should not validate", filter)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//eventCursorOverlap is how far back of the last run an incremental one starts asking for
+//events again, so the cf api's clock being a bit off ours doesn't lose any. the overlap is
+//deduped by guid
+const eventCursorOverlap = 5 * time.Minute
+
+//eventCursor is the audit events earlier runs collected and the time they're complete up to,
+//so the next run only asks for newer ones. it's written to Config.EventCursorPath between runs
+type eventCursor struct {
+	Target string `json:"target"`
+	//Types and Since are the event types and window start the events were collected for, a
+	//run with different ones starts over with a full sync
+	Types []string  `json:"types"`
+	Since time.Time `json:"since"`
+	//Until is when the run that collected them started listing events
+	Until time.Time `json:"until"`
+	//Lists are the events for every org and space, keyed by eventListKey
+	Lists map[string][]Resource `json:"lists"`
+
+	mutex sync.Mutex
+}
+
+//eventListKey is where the events of one type for one org or space are kept in a cursor
+func eventListKey(eventType string, scope string, guid string) string {
+	return eventType + "/" + scope + "/" + guid
+}
+
+//set keeps the events of one list, from any of the event collection goroutines
+func (cursor *eventCursor) set(key string, resources []Resource) {
+	cursor.mutex.Lock()
+	defer cursor.mutex.Unlock()
+	cursor.Lists[key] = resources
+}
+
+func readEventCursor(path string) *eventCursor {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cursor eventCursor
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil || cursor.Lists == nil {
+		return nil
+	}
+	return &cursor
+}
+
+func writeEventCursor(path string, cursor *eventCursor) error {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
+}
+
+//usableEventCursor is the last run's cursor when this one can carry on from it, nil for a
+//full sync: on the first run, against another foundation, for other event types or a
+//different window, or when it's older than client.eventCursorMaxAge
+func (client *Client) usableEventCursor(now time.Time) *eventCursor {
+	cursor := client.eventCursor
+	if cursor == nil && client.eventCursorPath != "" {
+		cursor = readEventCursor(client.eventCursorPath)
+	}
+	if cursor == nil || cursor.Target != client.apiURL.String() || !cursor.Since.Equal(client.eventsSince) {
+		return nil
+	}
+	if strings.Join(cursor.Types, ",") != strings.Join(client.eventTypes(), ",") {
+		return nil
+	}
+	if client.eventCursorMaxAge > 0 && now.Sub(cursor.Until) > client.eventCursorMaxAge {
+		return nil
+	}
+	return cursor
+}
+
+//incrementalEvents is one run of collectIncrementalEvents, kept is the cursor it carries on
+//from, nil for a full sync, and next is the one it leaves for the run after
+type incrementalEvents struct {
+	client *Client
+	kept   *eventCursor
+	next   *eventCursor
+}
+
+func (client *Client) startIncrementalEvents(now time.Time) *incrementalEvents {
+	incremental := &incrementalEvents{
+		client: client,
+		kept:   client.usableEventCursor(now),
+		next:   &eventCursor{Target: client.apiURL.String(), Types: client.eventTypes(), Since: client.eventsSince, Until: now, Lists: map[string][]Resource{}},
+	}
+	if incremental.kept == nil {
+		client.log.Info("collecting every audit event in the window, there's no cursor to carry on from")
+	}
+	return incremental
+}
+
+//endpoint lists the events of a type for an org or space, only the ones since the cursor
+//when there is one
+func (incremental *incrementalEvents) endpoint(eventType string, scope string) string {
+	client := incremental.client
+	if incremental.kept == nil {
+		return client.eventsEndpoint(eventType, scope)
+	}
+	from := incremental.kept.Until.Add(-eventCursorOverlap)
+	if from.Before(client.eventsSince) {
+		from = client.eventsSince
+	}
+	return client.eventsEndpointFrom(eventType, scope, from)
+}
+
+//merge puts what came back for a list together with what earlier runs kept of it, and keeps
+//that for the next run
+func (incremental *incrementalEvents) merge(eventType string, scope string) func(guid string, resources []Resource) []Resource {
+	return func(guid string, resources []Resource) []Resource {
+		key := eventListKey(eventType, scope, guid)
+		if incremental.kept != nil {
+			resources = dedupeResources(append(append([]Resource{}, incremental.kept.Lists[key]...), resources...))
+		}
+		for index := range resources {
+			sanitizeEvents(&resources[index])
+		}
+		incremental.next.set(key, resources)
+		return resources
+	}
+}
+
+//save moves the cursor on when every list came back. when any didn't it throws the cursor
+//away instead, so the next run is a full sync rather than missing what those lists had
+func (incremental *incrementalEvents) save(failures []CollectionError) []CollectionError {
+	client := incremental.client
+	if len(failures) > 0 {
+		client.eventCursor = nil
+		if client.eventCursorPath != "" {
+			os.Remove(client.eventCursorPath)
+		}
+		return failures
+	}
+	client.eventCursor = incremental.next
+	if client.eventCursorPath == "" {
+		return failures
+	}
+	err := writeEventCursor(client.eventCursorPath, incremental.next)
+	if err != nil {
+		failures = append(failures, CollectionError{Name: "foundation", Doing: "saving the audit event cursor", Err: err})
+	}
+	return failures
+}
+
+//collectIncrementalEvents is collectEvents asking only for the events since the last run
+func (client *Client) collectIncrementalEvents(ctx context.Context, run *collectRun) []CollectionError {
+	incremental := client.startIncrementalEvents(time.Now())
+	var failures []CollectionError
+	for _, eventType := range client.eventTypes() {
+		failures = append(failures, client.visible(&run.orgs, &run.orgsChecked, "org", client.getMergedEndpointData(ctx, run.orgs, eventField(eventType), incremental.endpoint(eventType, "organization"), eventLabel(eventType, "orgs"), incremental.merge(eventType, "organization")))...)
+	}
+	for _, eventType := range client.eventTypes() {
+		if eventType == "audit.space.create" {
+			continue
+		}
+		failures = append(failures, client.visible(&run.spaces, &run.spacesChecked, "space", client.getMergedEndpointData(ctx, run.spaces, eventField(eventType), incremental.endpoint(eventType, "space"), eventLabel(eventType, "spaces"), incremental.merge(eventType, "space")))...)
+	}
+	return incremental.save(failures)
+}
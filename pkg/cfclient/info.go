@@ -0,0 +1,118 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+type Info struct {
+	Name          string `json:"name"`
+	Build         string `json:"build"`
+	APIVersion    string `json:"api_version"`
+	TokenEndpoint string `json:"token_endpoint"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+}
+
+//FeatureFlag is one of the platform's feature flags, e.g. diego_docker or user_org_creation
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+//Platform is what the foundation was running when it was collected from, so changes in
+//the numbers can be lined up with upgrades and flag flips
+type Platform struct {
+	Info Info `json:"info"`
+	//FeatureFlags are sorted by name
+	FeatureFlags []FeatureFlag `json:"feature_flags"`
+}
+
+//Info hits /v2/info, which is about the cheapest call the api has. foundations with v2
+//turned off don't have it, for those the version comes off the api root and the name and
+//build from /v3/info
+func (client *Client) Info(ctx context.Context) (Info, error) {
+	var info Info
+	resp, err := client.doGetRequest(ctx, "/v2/info")
+	if IsNotFound(err) {
+		return client.infoV3(ctx)
+	}
+	if err != nil {
+		return info, err
+	}
+	err = decodeBody(resp, &info)
+	return info, err
+}
+
+func (client *Client) infoV3(ctx context.Context) (Info, error) {
+	var info Info
+	var root struct {
+		Links struct {
+			CloudControllerV3 struct {
+				Meta struct {
+					Version string `json:"version"`
+				} `json:"meta"`
+			} `json:"cloud_controller_v3"`
+		} `json:"links"`
+	}
+	resp, err := client.doGetRequest(ctx, "/")
+	if err != nil {
+		return info, err
+	}
+	err = decodeBody(resp, &root)
+	if err != nil {
+		return info, fmt.Errorf("error unmarshalling the api root: %s", err)
+	}
+	info.APIVersion = root.Links.CloudControllerV3.Meta.Version
+
+	resp, err = client.doGetRequest(ctx, "/v3/info")
+	if err != nil {
+		return info, err
+	}
+	var v3Info struct {
+		Name  string `json:"name"`
+		Build string `json:"build"`
+	}
+	err = decodeBody(resp, &v3Info)
+	if err != nil {
+		return info, fmt.Errorf("error unmarshalling /v3/info: %s", err)
+	}
+	info.Name, info.Build = v3Info.Name, v3Info.Build
+	return info, nil
+}
+
+//getPlatform snapshots the platform's info and feature flags
+func (client *Client) getPlatform(ctx context.Context) (Platform, error) {
+	var platform Platform
+	var err error
+	platform.Info, err = client.Info(ctx)
+	if err != nil {
+		return platform, err
+	}
+
+	if client.apiVersion == APIV3 {
+		resources, err := client.Resources(ctx, "/v3/feature_flags")
+		if err != nil {
+			return platform, err
+		}
+		for _, resource := range resources {
+			entity, _ := resource.Entity.(map[string]interface{})
+			enabled, _ := entity["enabled"].(bool)
+			platform.FeatureFlags = append(platform.FeatureFlags, FeatureFlag{Name: EntityString(resource, "name"), Enabled: enabled})
+		}
+	} else {
+		//v2 hands the flags back as a bare array rather than a paged list
+		resp, err := client.doGetRequest(ctx, "/v2/config/feature_flags")
+		if err != nil {
+			return platform, err
+		}
+		err = decodeBody(resp, &platform.FeatureFlags)
+		if err != nil {
+			return platform, fmt.Errorf("error unmarshalling /v2/config/feature_flags: %s", err)
+		}
+	}
+	sort.Slice(platform.FeatureFlags, func(i, j int) bool {
+		return platform.FeatureFlags[i].Name < platform.FeatureFlags[j].Name
+	})
+	return platform, nil
+}
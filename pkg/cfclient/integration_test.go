@@ -0,0 +1,101 @@
+package cfclient
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/fakecapi"
+)
+
+//the integration tests run the client against the fake cloud controller, which only has v2,
+//so they're v2 runs
+
+//newFakeFoundation is a fake cloud controller and uaa serving a foundation sized by options,
+//and a client logged in to it with client credentials
+func newFakeFoundation(t *testing.T, options fakecapi.Options, config Config) (*httptest.Server, *Client) {
+	t.Helper()
+	server := httptest.NewServer(fakecapi.New(options))
+	t.Cleanup(server.Close)
+	config.ClientID, config.ClientSecret = "metrics", "secret"
+	return server, newTestClient(t, server, config)
+}
+
+func TestCollectPagesThroughTheFoundation(t *testing.T) {
+	options := fakecapi.Options{Orgs: 3, SpacesPerOrg: 4, AppsPerSpace: 7, EventsPerApp: 2}
+	//pages of 5 split every list there is, the orgs, each org's spaces and each space's apps
+	_, client := newFakeFoundation(t, options, Config{PageSize: 5})
+
+	result, err := client.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failures) > 0 {
+		t.Fatalf("the collection failed: %s", result.Failures)
+	}
+	if len(result.Orgs) != options.Orgs || len(result.Spaces) != options.Orgs*options.SpacesPerOrg {
+		t.Fatalf("got %d orgs and %d spaces, want %d and %d", len(result.Orgs), len(result.Spaces), options.Orgs, options.Orgs*options.SpacesPerOrg)
+	}
+	apps := map[string]bool{}
+	for _, space := range result.Spaces {
+		if len(space.Apps) != options.AppsPerSpace {
+			t.Fatalf("space %s has %d apps, want %d", space.Name, len(space.Apps), options.AppsPerSpace)
+		}
+		for _, app := range space.Apps {
+			apps[app.Metadata.GUID] = true
+		}
+	}
+	if len(apps) != options.Orgs*options.SpacesPerOrg*options.AppsPerSpace {
+		t.Fatalf("got %d different apps, want %d, pages overlapped or were skipped", len(apps), options.Orgs*options.SpacesPerOrg*options.AppsPerSpace)
+	}
+	for _, org := range result.Orgs {
+		if len(org.Apps) != options.SpacesPerOrg*options.AppsPerSpace {
+			t.Fatalf("org %s has %d apps, want %d", org.Name, len(org.Apps), options.SpacesPerOrg*options.AppsPerSpace)
+		}
+	}
+}
+
+func TestExpiredTokensAreRefreshed(t *testing.T) {
+	const ttl = time.Second
+	_, client := newFakeFoundation(t, fakecapi.Options{Orgs: 2, SpacesPerOrg: 1, AppsPerSpace: 1, TokenTTL: ttl}, Config{})
+
+	for request := 0; request < 2; request++ {
+		if request > 0 {
+			//the fake goes by whole seconds, so wait for the next one after the token's expiry
+			time.Sleep(ttl + time.Second)
+		}
+		orgs, err := client.Resources(context.Background(), "/v2/organizations")
+		if err != nil {
+			t.Fatalf("request %d: %s", request+1, err)
+		}
+		if len(orgs) != 2 {
+			t.Fatalf("request %d: got %d orgs, want 2", request+1, len(orgs))
+		}
+	}
+	//the first token is the login, the request after it expired needs a new one
+	if refreshes := client.Stats().Refreshes; refreshes < 2 {
+		t.Fatalf("2 requests a token lifetime apart got %d tokens, want a new one for each", refreshes)
+	}
+}
+
+func TestRateLimitedRequestsBackOffAndRetry(t *testing.T) {
+	const limit, requests = 4, 10
+	_, client := newFakeFoundation(t, fakecapi.Options{Orgs: 1, SpacesPerOrg: 1, AppsPerSpace: 1, RateLimit: limit, RateLimitWindow: 500 * time.Millisecond}, Config{MaxAttempts: 5})
+
+	started := time.Now()
+	for request := 0; request < requests; request++ {
+		_, err := client.Resources(context.Background(), "/v2/organizations")
+		if err != nil {
+			t.Fatalf("request %d: %s", request+1, err)
+		}
+	}
+	stats := client.Stats()
+	if stats.Retries == 0 {
+		t.Fatalf("%d requests against a limit of %d per window weren't retried", requests, limit)
+	}
+	//every retry waits out the Retry-After, a second from the fake
+	if elapsed := time.Since(started); elapsed < time.Duration(stats.Retries)*time.Second {
+		t.Fatalf("%d retries took %s, they didn't wait for the Retry-After", stats.Retries, elapsed)
+	}
+}
@@ -0,0 +1,162 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+//SharedSegment is what a space on the platform's shared cells counts under, one with no
+//isolation segment of its own or from its org
+const SharedSegment = "shared"
+
+//IsolationSegment is a v3 isolation segment with the orgs entitled to it and the spaces
+//assigned to it
+type IsolationSegment struct {
+	GUID       string   `json:"guid"`
+	Name       string   `json:"name"`
+	OrgGUIDs   []string `json:"org_guids"`
+	SpaceGUIDs []string `json:"space_guids"`
+}
+
+//SegmentTotals is the footprint of the apps running in one isolation segment
+type SegmentTotals struct {
+	Segment          string `json:"segment"`
+	Orgs             int    `json:"orgs"`
+	Spaces           int    `json:"spaces"`
+	Apps             int    `json:"apps"`
+	Instances        int    `json:"instances"`
+	ReservedMemoryMB int    `json:"reserved_memory_mb"`
+}
+
+//relationshipGUIDs reads a v3 to-many relationship, e.g.
+///v3/isolation_segments/:guid/relationships/organizations, down to its guids
+func (client *Client) relationshipGUIDs(ctx context.Context, endpoint string) ([]string, error) {
+	resp, err := client.doGetRequest(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var relationship struct {
+		Data []struct {
+			GUID string `json:"guid"`
+		} `json:"data"`
+	}
+	err = decodeBody(resp, &relationship)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s: %s", endpoint, err)
+	}
+	var guids []string
+	for _, data := range relationship.Data {
+		guids = append(guids, data.GUID)
+	}
+	return guids, nil
+}
+
+//getIsolationSegments lists the isolation segments with their orgs and spaces, and sets
+//IsolationSegment on every org with a default segment and every space to the segment its
+//apps run in: its own, its org's default, or SharedSegment. a foundation without the v3 api
+//has no segments
+func (client *Client) getIsolationSegments(ctx context.Context, orgs []Data, spaces []Data) ([]IsolationSegment, error) {
+	resources, err := client.Resources(ctx, "/v3/isolation_segments")
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []IsolationSegment
+	names := map[string]string{}
+	spaceSegments := map[string]string{}
+	entitled := map[string]bool{}
+	for _, resource := range resources {
+		segment := IsolationSegment{GUID: resource.Metadata.GUID, Name: EntityString(resource, "name")}
+		names[segment.GUID] = segment.Name
+		segment.OrgGUIDs, err = client.relationshipGUIDs(ctx, "/v3/isolation_segments/"+segment.GUID+"/relationships/organizations")
+		if err != nil {
+			return nil, err
+		}
+		segment.SpaceGUIDs, err = client.relationshipGUIDs(ctx, "/v3/isolation_segments/"+segment.GUID+"/relationships/spaces")
+		if err != nil {
+			return nil, err
+		}
+		for _, guid := range segment.OrgGUIDs {
+			entitled[guid] = true
+		}
+		for _, guid := range segment.SpaceGUIDs {
+			spaceSegments[guid] = segment.Name
+		}
+		segments = append(segments, segment)
+	}
+
+	//only orgs entitled to a segment can have one as their default
+	orgSegments := map[string]string{}
+	for index, org := range orgs {
+		if !entitled[org.GUID] {
+			continue
+		}
+		resp, err := client.doGetRequest(ctx, "/v3/organizations/"+org.GUID+"/relationships/default_isolation_segment")
+		if err != nil {
+			return nil, err
+		}
+		var relationship struct {
+			Data *struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		}
+		err = decodeBody(resp, &relationship)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal the default isolation segment of %s: %s", org.Name, err)
+		}
+		if relationship.Data != nil && names[relationship.Data.GUID] != "" {
+			orgs[index].IsolationSegment = names[relationship.Data.GUID]
+			orgSegments[org.GUID] = orgs[index].IsolationSegment
+		}
+	}
+
+	for index, space := range spaces {
+		segment := spaceSegments[space.GUID]
+		if segment == "" {
+			segment = orgSegments[space.OrganizationGUID]
+		}
+		if segment == "" {
+			segment = SharedSegment
+		}
+		spaces[index].IsolationSegment = segment
+	}
+	return segments, nil
+}
+
+//TotalsBySegment adds up the apps in spaces by the isolation segment they run in, sorted by
+//segment name. spaces collected without segments count as SharedSegment
+func TotalsBySegment(spaces []Data) []SegmentTotals {
+	totals := map[string]*SegmentTotals{}
+	orgs := map[string]map[string]bool{}
+	for _, space := range spaces {
+		name := space.IsolationSegment
+		if name == "" {
+			name = SharedSegment
+		}
+		total := totals[name]
+		if total == nil {
+			total = &SegmentTotals{Segment: name}
+			totals[name] = total
+			orgs[name] = map[string]bool{}
+		}
+		instances, memory := AppTotals(space.Apps)
+		total.Spaces++
+		total.Apps += len(space.Apps)
+		total.Instances += instances
+		total.ReservedMemoryMB += memory
+		orgs[name][space.OrganizationGUID] = true
+	}
+	var sorted []SegmentTotals
+	for name, total := range totals {
+		total.Orgs = len(orgs[name])
+		sorted = append(sorted, *total)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Segment < sorted[j].Segment
+	})
+	return sorted
+}
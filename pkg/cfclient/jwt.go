@@ -0,0 +1,66 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+//tokenExpiry reads the exp claim out of a bearer token's jwt payload. we only need to know
+//when uaa will stop accepting it, so the signature isn't checked. ok is false for tokens
+//that aren't jwts or don't say
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(strings.TrimPrefix(token, "Bearer "), ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+//canRefresh is true when requestToken has something to get a new token with
+func (client *Client) canRefresh() bool {
+	return client.clientCredentials || client.tokenSource != nil || client.refreshToken != ""
+}
+
+//freshToken is the current authorization header value, refreshed first when it's within
+//tokenExpiryMargin of expiring so long collections don't each have to eat a 401 to find
+//out. if the refresh fails the old token is still sent and the usual 401 handling takes over
+func (client *Client) freshToken(ctx context.Context) string {
+	token := client.accessToken()
+	expiresAt, ok := tokenExpiry(token)
+	if !ok || time.Now().Add(tokenExpiryMargin).Before(expiresAt) {
+		return token
+	}
+	client.tokenMutex.RLock()
+	refreshable := client.canRefresh()
+	client.tokenMutex.RUnlock()
+	if !refreshable {
+		return token
+	}
+	err := client.refreshStaleToken(ctx, token)
+	if err != nil {
+		client.log.Debug("error refreshing a token that's about to expire", "err", err)
+	}
+	return client.accessToken()
+}
+
+//TokenStatus is when the current access token expires, with ok false when it doesn't say,
+//and whether the client can get itself a new one when it does
+func (client *Client) TokenStatus() (expiresAt time.Time, ok bool, refreshable bool) {
+	expiresAt, ok = tokenExpiry(client.accessToken())
+	client.tokenMutex.RLock()
+	defer client.tokenMutex.RUnlock()
+	return expiresAt, ok, client.canRefresh()
+}
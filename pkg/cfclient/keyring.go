@@ -0,0 +1,133 @@
+package cfclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+//keyringService is what every secret cf-metrics keeps in the os keyring is filed under
+const keyringService = "cf-metrics"
+
+//errNotInKeyring is a keyring lookup for a secret that isn't there
+var errNotInKeyring = errors.New("not in the keyring")
+
+//keyring keeps secrets in the os keychain instead of in plaintext files. account says which
+//secret, e.g. login:https://api.sys.example.com
+type keyring interface {
+	get(account string) (string, error)
+	set(account string, secret string) error
+}
+
+//platformKeyring is the keyring of the os we're on: the macos keychain through security,
+//libsecret's secret-tool everywhere else but windows, which swaps in the credential manager
+var platformKeyring = func() (keyring, error) {
+	tool := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		tool = "security"
+	}
+	_, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("there's no keyring to use, %s isn't installed", tool)
+	}
+	if runtime.GOOS == "darwin" {
+		return keychain{}, nil
+	}
+	return secretTool{}, nil
+}
+
+//runKeyringTool runs a keyring command with stdin, giving back its trimmed output
+func runKeyringTool(stdin string, name string, args ...string) (string, int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", exitErr.ExitCode(), fmt.Errorf("%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return "", -1, err
+	}
+	return strings.TrimSpace(stdout.String()), 0, nil
+}
+
+//keychain is the macos keychain. secrets are base64 so they can go through security -i's
+//stdin quoted, instead of on a command line ps would show
+type keychain struct{}
+
+func (keychain) get(account string) (string, error) {
+	encoded, code, err := runKeyringTool("", "security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	//44 is errSecItemNotFound
+	if code == 44 {
+		return "", errNotInKeyring
+	}
+	if err != nil {
+		return "", err
+	}
+	return decodeKeyringSecret(encoded)
+}
+
+func (keychain) set(account string, secret string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keyringService, keychainQuote(account), base64.StdEncoding.EncodeToString([]byte(secret)))
+	_, _, err := runKeyringTool(command, "security", "-i")
+	return err
+}
+
+//keychainQuote quotes an account name for security -i
+func keychainQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+//secretTool is libsecret's secret-tool, gnome keyring or kwallet behind the secret service
+type secretTool struct{}
+
+func (secretTool) get(account string) (string, error) {
+	encoded, code, err := runKeyringTool("", "secret-tool", "lookup", "service", keyringService, "account", account)
+	//secret-tool just exits 1 when there's no such secret
+	if code == 1 {
+		return "", errNotInKeyring
+	}
+	if err != nil {
+		return "", err
+	}
+	return decodeKeyringSecret(encoded)
+}
+
+func (secretTool) set(account string, secret string) error {
+	_, _, err := runKeyringTool(base64.StdEncoding.EncodeToString([]byte(secret)), "secret-tool", "store", "--label=cf-metrics "+account, "service", keyringService, "account", account)
+	return err
+}
+
+func decodeKeyringSecret(encoded string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("the keyring has something cf-metrics didn't put there: %s", err)
+	}
+	return string(raw), nil
+}
+
+//clientSecretAccount is where the secret of a uaa client for an api is kept
+func clientSecretAccount(clientID string, target string) string {
+	return keyringAccount("client-secret:"+clientID, target)
+}
+
+//SaveClientSecret keeps the secret of a uaa client for the api at target in the os keyring,
+//where Config.Keyring finds it when there's no Config.ClientSecret
+func SaveClientSecret(target string, clientID string, secret string) error {
+	ring, err := platformKeyring()
+	if err != nil {
+		return err
+	}
+	return ring.set(clientSecretAccount(clientID, target), secret)
+}
+
+//keyringAccount names the secret of one kind for one api, e.g. login:https://api.example.com
+func keyringAccount(kind string, target string) string {
+	return kind + ":" + strings.TrimSuffix(target, "/")
+}
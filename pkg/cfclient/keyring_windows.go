@@ -0,0 +1,89 @@
+package cfclient
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+//winCredential is a CREDENTIALW
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+//windows keeps the secrets in the credential manager, as generic credentials named
+//cf-metrics:<account>
+func init() {
+	platformKeyring = func() (keyring, error) {
+		return credentialManager{}, nil
+	}
+}
+
+type credentialManager struct{}
+
+func (credentialManager) get(account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var credential *winCredential
+	ok, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&credential)))
+	if ok == 0 {
+		if err == errorNotFound {
+			return "", errNotInKeyring
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(credential)))
+	return string(unsafe.Slice(credential.CredentialBlob, credential.CredentialBlobSize)), nil
+}
+
+func (credentialManager) set(account string, secret string) error {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	credential := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		credential.CredentialBlob = &blob[0]
+	}
+	ok, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&credential)), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
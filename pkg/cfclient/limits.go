@@ -0,0 +1,49 @@
+package cfclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+//defaultMaxResponseBytes is the biggest response body read when Config.MaxResponseBytes
+//isn't set. a page of 100 of anything the api lists is well under a megabyte
+const defaultMaxResponseBytes = 64 << 20
+
+//ResponseTooLargeError is a response body that went past Config.MaxResponseBytes, which is
+//where reading it stopped
+type ResponseTooLargeError struct {
+	Endpoint string
+	Limit    int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("the response from %s is over the %d byte limit", e.Endpoint, e.Limit)
+}
+
+//limitedBody fails a read once the body is past its limit, so an endpoint sending something
+//huge can't run the collector out of memory
+type limitedBody struct {
+	io.ReadCloser
+	endpoint  string
+	limit     int64
+	remaining int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	//one byte over the limit is read to tell a body that's exactly the limit from a bigger one
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	if body.remaining < 0 {
+		return n - 1, &ResponseTooLargeError{Endpoint: body.endpoint, Limit: body.limit}
+	}
+	return n, err
+}
+
+//limitBody caps resp's body at the client's Config.MaxResponseBytes
+func (client *Client) limitBody(resp *http.Response, endpoint string) {
+	resp.Body = &limitedBody{ReadCloser: resp.Body, endpoint: endpoint, limit: client.maxResponseBytes, remaining: client.maxResponseBytes}
+}
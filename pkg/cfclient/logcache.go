@@ -0,0 +1,121 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//AppStatsCC asks the cloud controller for app instance stats, one call per app
+	AppStatsCC = "cc"
+	//AppStatsLogCache reads the container metrics log cache has kept for each app instead,
+	//which doesn't keep the cloud controller busy asking the cells
+	AppStatsLogCache = "log-cache"
+)
+
+//logCacheWindow is how far back to look for an app's latest container metrics. diego sends
+//them every 15 seconds or so, an instance that's been quiet for longer isn't running
+const logCacheWindow = 2 * time.Minute
+
+//logCacheLink is where log cache is, looked up from the api root the first time it's needed
+type logCacheLink struct {
+	mutex sync.Mutex
+	url   *url.URL
+}
+
+func (client *Client) logCacheURL(ctx context.Context) (*url.URL, error) {
+	client.logCache.mutex.Lock()
+	defer client.logCache.mutex.Unlock()
+	if client.logCache.url == nil {
+		link, err := client.rootLink(ctx, "log_cache")
+		if err != nil {
+			return nil, err
+		}
+		client.logCache.url = link
+	}
+	return client.logCache.url, nil
+}
+
+//ReadGauges reads the gauge envelopes log cache has for sourceID, an app guid for app
+//metrics, since the given time, newest first
+func (client *Client) ReadGauges(ctx context.Context, sourceID string, since time.Time) ([]Envelope, error) {
+	return client.readGauges(ctx, sourceID, since, "")
+}
+
+//readGauges is ReadGauges only for the metrics nameFilter, a regexp, matches when it's set
+func (client *Client) readGauges(ctx context.Context, sourceID string, since time.Time, nameFilter string) ([]Envelope, error) {
+	logCache, err := client.logCacheURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("envelope_types", "GAUGE")
+	query.Set("start_time", strconv.FormatInt(since.UnixNano(), 10))
+	query.Set("descending", "true")
+	query.Set("limit", "1000")
+	if nameFilter != "" {
+		query.Set("name_filter", nameFilter)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(logCache.String(), "/")+"/api/v1/read/"+url.PathEscape(sourceID)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error forming log cache request: %s", err)
+	}
+	req.Header.Add("Accept", "application/json")
+	resp, err := client.doPlatformRequest(ctx, client.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	client.limitBody(resp, req.URL.Path)
+
+	var in struct {
+		Envelopes struct {
+			Batch []rawEnvelope `json:"batch"`
+		} `json:"envelopes"`
+	}
+	err = decodeBody(resp, &in)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling log cache read for %s: %s", sourceID, err)
+	}
+	var envelopes []Envelope
+	for _, raw := range in.Envelopes.Batch {
+		envelopes = append(envelopes, raw.envelope())
+	}
+	return envelopes, nil
+}
+
+//getLogCacheStats is getAppStats out of log cache: the latest container metric each instance
+//of the web process sent in the last logCacheWindow, like the cloud controller's stats. log
+//cache doesn't know about instance states, so every instance that's reporting counts as
+//RUNNING
+func (client *Client) getLogCacheStats(ctx context.Context, guid string) ([]instanceStats, error) {
+	envelopes, err := client.ReadGauges(ctx, guid, time.Now().Add(-logCacheWindow))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var instances []instanceStats
+	for _, envelope := range envelopes {
+		processType := envelope.Tags["process_type"]
+		if !envelope.IsContainerMetric() || seen[envelope.InstanceID] || (processType != "" && processType != "web") {
+			continue
+		}
+		seen[envelope.InstanceID] = true
+		instances = append(instances, instanceStats{
+			state: "RUNNING",
+			//log cache has cpu as a percentage, the cloud controller as a fraction
+			cpu:       envelope.Gauges["cpu"].Value / 100,
+			mem:       int64(envelope.Gauges["memory"].Value),
+			memQuota:  int64(envelope.Gauges["memory_quota"].Value),
+			disk:      int64(envelope.Gauges["disk"].Value),
+			diskQuota: int64(envelope.Gauges["disk_quota"].Value),
+		})
+	}
+	return instances, nil
+}
@@ -0,0 +1,232 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//loginClientID is the uaa client the cf cli logs users in as, which the refresh grant later
+//goes through as well
+const loginClientID = "cf"
+
+//LoginConfig is where and how to reach the foundation to log in to
+type LoginConfig struct {
+	API string
+	//Origin is the uaa identity provider to log in with, e.g. ldap, empty for uaa's own users
+	Origin            string
+	SkipSSLValidation bool
+	CACertPath        string
+	ProxyURL          string
+	NoProxy           []string
+	RequestTimeout    time.Duration
+}
+
+//Login gets a user token from the uaa of a foundation with the password grant, for when
+//there's no cf cli config to use
+type Login struct {
+	config LoginConfig
+	client *Client
+}
+
+//NewLogin finds the foundation's uaa from the api root
+func NewLogin(ctx context.Context, config LoginConfig) (*Login, error) {
+	if config.API == "" {
+		return nil, fmt.Errorf("there's no api address to log in to")
+	}
+	apiURL, err := url.Parse(strings.TrimSuffix(config.API, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing api address %s: %s", config.API, err)
+	}
+	proxy, err := proxyFunc(config.ProxyURL, config.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+	tlsSettings, err := tlsConfig(config.SkipSSLValidation, config.CACertPath, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+	client := &Client{
+		apiURL:           apiURL,
+		httpClient:       &http.Client{Transport: newTransport(proxy, tlsSettings, 1), Timeout: config.RequestTimeout},
+		maxResponseBytes: defaultMaxResponseBytes,
+	}
+	client.uaaURL, err = client.discoverUAA(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error finding uaa: %s", err)
+	}
+	return &Login{config: config, client: client}, nil
+}
+
+//UAA is the uaa the login goes to
+func (login *Login) UAA() string {
+	return login.client.uaaURL.String()
+}
+
+//PasscodeURL is the page single sign on users get a one time passcode from
+func (login *Login) PasscodeURL() string {
+	return login.UAA() + "/passcode"
+}
+
+//Authenticate logs in with username and password, or with a passcode from PasscodeURL when
+//username is empty, and comes back with the target and tokens in the cf cli's config shape
+func (login *Login) Authenticate(ctx context.Context, username string, password string, passcode string) (*CLIConfig, error) {
+	form := url.Values{}
+	form.Add("grant_type", "password")
+	form.Add("client_id", loginClientID)
+	form.Add("client_secret", "")
+	if username != "" {
+		form.Add("username", username)
+		form.Add("password", password)
+	} else {
+		form.Add("passcode", passcode)
+	}
+	if login.config.Origin != "" {
+		hint, err := json.Marshal(map[string]string{"origin": login.config.Origin})
+		if err != nil {
+			return nil, err
+		}
+		form.Add("login_hint", string(hint))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", login.UAA()+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error forming uaa token request: %s", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	resp, err := login.client.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error attempting uaa token request: %s", err)
+	}
+	login.client.limitBody(resp, "/oauth/token")
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest {
+		return nil, fmt.Errorf("uaa turned the credentials down (%d)", resp.StatusCode)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("error: non 200 response code %d from uaa when attempting to log in", resp.StatusCode)
+	}
+
+	var contents struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&contents)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal uaa token response: %s", err)
+	}
+	return &CLIConfig{
+		AccessToken:  "bearer " + contents.AccessToken,
+		RefreshToken: contents.RefreshToken,
+		Target:       login.client.apiURL.String(),
+		UAAEndpoint:  login.UAA(),
+		UAAClientID:  loginClientID,
+		SSLDisabled:  login.config.SkipSSLValidation,
+		Proxy:        login.config.ProxyURL,
+		NoProxy:      strings.Join(login.config.NoProxy, ","),
+	}, nil
+}
+
+//CheckClientCredentials makes sure uaa gives clientID a token with secret, before it's saved
+//with SaveClientSecret
+func (login *Login) CheckClientCredentials(ctx context.Context, clientID string, secret string) error {
+	form := url.Values{}
+	form.Add("grant_type", "client_credentials")
+	form.Add("client_id", clientID)
+	form.Add("client_secret", secret)
+	req, err := http.NewRequestWithContext(ctx, "POST", login.UAA()+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error forming uaa token request: %s", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	resp, err := login.client.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error attempting uaa token request: %s", err)
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("uaa wouldn't give %s a token (%d)", clientID, resp.StatusCode)
+	}
+	return nil
+}
+
+//Target is the api the login is for
+func (login *Login) Target() string {
+	return login.client.apiURL.String()
+}
+
+//LoginConfigPath is where cf-metrics keeps its own login, config.json in $CF_METRICS_HOME or
+//.cf-metrics in the home directory
+func LoginConfigPath() string {
+	home := os.Getenv("CF_METRICS_HOME")
+	if home == "" {
+		userHome, _ := os.UserHomeDir()
+		home = filepath.Join(userHome, ".cf-metrics")
+	}
+	return filepath.Join(home, "config.json")
+}
+
+//SaveLoginConfig writes a login to LoginConfigPath in the cf cli's config.json format, owner
+//only since it holds the tokens. with useKeyring the refresh token goes in the os keyring
+//instead
+func SaveLoginConfig(config *CLIConfig, useKeyring bool) error {
+	path := LoginConfigPath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	saved := *config
+	if useKeyring {
+		ring, err := platformKeyring()
+		if err != nil {
+			return err
+		}
+		err = ring.set(keyringAccount("login", config.Target), config.RefreshToken)
+		if err != nil {
+			return fmt.Errorf("error keeping the refresh token in the keyring: %s", err)
+		}
+		saved.RefreshToken, saved.RefreshTokenInKeyring = "", true
+	}
+	raw, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(path, raw)
+}
+
+//GrabLoginConfig reads the login cf-metrics login saved, what the client falls back on when
+//there's no cf cli config.json
+func GrabLoginConfig() (*CLIConfig, error) {
+	raw, err := ioutil.ReadFile(LoginConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	var config CLIConfig
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", LoginConfigPath(), err)
+	}
+	if config.RefreshTokenInKeyring {
+		ring, err := platformKeyring()
+		if err != nil {
+			return nil, err
+		}
+		config.RefreshToken, err = ring.get(keyringAccount("login", config.Target))
+		if err != nil {
+			return nil, fmt.Errorf("error reading the login's refresh token from the keyring: %s", err)
+		}
+	}
+	return &config, nil
+}
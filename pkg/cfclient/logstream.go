@@ -0,0 +1,228 @@
+package cfclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Envelope is a loggregator v2 envelope the way the reverse log proxy gateway streams it,
+//gauges and counters only
+type Envelope struct {
+	SourceID   string
+	InstanceID string
+	Timestamp  time.Time
+	Tags       map[string]string
+	//Gauges is set for gauge envelopes, metric name -> value
+	Gauges map[string]GaugeValue
+	//Counter is set for counter envelopes
+	Counter *CounterValue
+}
+
+type GaugeValue struct {
+	Unit  string  `json:"unit"`
+	Value float64 `json:"value"`
+}
+
+type CounterValue struct {
+	Name  string
+	Delta uint64
+	Total uint64
+}
+
+//IsContainerMetric is true for the gauge the diego cells send for every app instance, the
+//v2 version of the firehose's ContainerMetric
+func (e Envelope) IsContainerMetric() bool {
+	_, cpu := e.Gauges["cpu"]
+	_, memory := e.Gauges["memory"]
+	return cpu && memory
+}
+
+//protoNumber is an int64/uint64 envelope field. the gateway's json follows protobuf's and
+//quotes them, but a bare number decodes too
+type protoNumber string
+
+func (n *protoNumber) UnmarshalJSON(raw []byte) error {
+	*n = protoNumber(strings.Trim(string(raw), `"`))
+	return nil
+}
+
+func (n protoNumber) uint64() uint64 {
+	value, _ := strconv.ParseUint(string(n), 10, 64)
+	return value
+}
+
+type rawEnvelope struct {
+	Timestamp  protoNumber       `json:"timestamp"`
+	SourceID   string            `json:"sourceId"`
+	InstanceID string            `json:"instanceId"`
+	Tags       map[string]string `json:"tags"`
+	Gauge      *struct {
+		Metrics map[string]GaugeValue `json:"metrics"`
+	} `json:"gauge"`
+	Counter *struct {
+		Name  string      `json:"name"`
+		Delta protoNumber `json:"delta"`
+		Total protoNumber `json:"total"`
+	} `json:"counter"`
+}
+
+func (raw rawEnvelope) envelope() Envelope {
+	envelope := Envelope{
+		SourceID:   raw.SourceID,
+		InstanceID: raw.InstanceID,
+		Timestamp:  time.Unix(0, int64(raw.Timestamp.uint64())),
+		Tags:       raw.Tags,
+	}
+	if raw.Gauge != nil {
+		envelope.Gauges = raw.Gauge.Metrics
+	}
+	if raw.Counter != nil {
+		envelope.Counter = &CounterValue{Name: raw.Counter.Name, Delta: raw.Counter.Delta.uint64(), Total: raw.Counter.Total.uint64()}
+	}
+	return envelope
+}
+
+//doPlatformRequest sends a bodyless request to one of the platform's apis other than the
+//cloud controller, e.g. the log stream gateway or log cache, with our token. a 401 gets the
+//token refreshed and the request sent once more, any other non 2xx is an *APIError
+func (client *Client) doPlatformRequest(ctx context.Context, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		token := client.freshToken(ctx)
+		attemptReq := req.Clone(ctx)
+		attemptReq.Header.Set("Authorization", token)
+		started := time.Now()
+		atomic.AddInt64(&client.counters.requests, 1)
+		resp, err := httpClient.Do(attemptReq)
+		client.traceRequest(attemptReq, resp, started, err)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 {
+			atomic.AddInt64(&client.counters.unauthorized, 1)
+			drainAndClose(resp.Body)
+			err = client.refreshStaleToken(ctx, token)
+			if err != nil {
+				return nil, fmt.Errorf("Error refreshing token: %s", err)
+			}
+			continue
+		}
+		if resp.StatusCode/100 != 2 {
+			body, _ := readErrorBody(resp)
+			drainAndClose(resp.Body)
+			return nil, newAPIError(resp, req.URL.Path, body)
+		}
+		return resp, nil
+	}
+}
+
+//rootLink is the href the api root has for name, e.g. log_stream or log_cache
+func (client *Client) rootLink(ctx context.Context, name string) (*url.URL, error) {
+	var root struct {
+		Links map[string]*struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	resp, err := client.doGetRequest(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	err = decodeBody(resp, &root)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the api root: %s", err)
+	}
+	link := root.Links[name]
+	if link == nil || link.Href == "" {
+		return nil, fmt.Errorf("api root at %s doesn't link to %s", client.apiURL, name)
+	}
+	return url.Parse(link.Href)
+}
+
+//StreamEnvelopes connects to the reverse log proxy gateway the api root points at and calls
+//fn with every gauge and counter envelope until the gateway ends the stream, which it does
+//every so often, ctx is done or fn returns an error. envelopes are shared out between every
+//connection with the same shardID, so several collectors can split the load. the token needs
+//doppler.firehose or logs.admin
+func (client *Client) StreamEnvelopes(ctx context.Context, shardID string, fn func(Envelope) error) error {
+	logStream, err := client.rootLink(ctx, "log_stream")
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("shard_id", shardID)
+	query.Set("gauge", "")
+	query.Set("counter", "")
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(logStream.String(), "/")+"/v2/read?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("error forming log stream request: %s", err)
+	}
+	req.Header.Add("Accept", "text/event-stream")
+
+	//the stream stays open far longer than any api request is allowed to take
+	stream := &http.Client{Transport: client.httpClient.Transport}
+	resp, err := client.doPlatformRequest(ctx, stream, req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp.Body)
+
+	//server-sent events: data lines up to a blank line make up an event. the gateway's events
+	//are a batch of envelopes, or a heartbeat
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "":
+			err = client.handleStreamEvent(event, data.Bytes(), fn)
+			if err != nil {
+				return err
+			}
+			event = ""
+			data.Reset()
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (client *Client) handleStreamEvent(event string, data []byte, fn func(Envelope) error) error {
+	if len(data) == 0 || (event != "" && event != "message") {
+		return nil
+	}
+	var batch struct {
+		Batch []rawEnvelope `json:"batch"`
+	}
+	err := json.Unmarshal(data, &batch)
+	if err != nil {
+		client.log.Debug("skipping unreadable log stream event", "err", err)
+		return nil
+	}
+	for _, raw := range batch.Batch {
+		envelope := raw.envelope()
+		if name, tagged := envelope.Tags["app_name"]; tagged {
+			envelope.Tags["app_name"] = client.redactor.appName(name)
+		}
+		err = fn(envelope)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cfclient
+
+import (
+	"sync"
+	"time"
+)
+
+//operationTracker remembers when each service instance's in progress operation was first
+//seen, across the runs of a reused client. a broker that hangs mid provision gets polled by
+//the cloud controller all the same, and every poll moves last_operation.updated_at along, so
+//updated_at alone never gets old enough to look stuck
+type operationTracker struct {
+	mutex      sync.Mutex
+	operations map[string]trackedOperation
+}
+
+//trackedOperation is an instance's in progress operation, since when it has been going at
+//least and the space it's in, so only the instances of spaces that were collected are let go
+type trackedOperation struct {
+	operationType string
+	since         time.Time
+	spaceGUID     string
+	seen          time.Time
+}
+
+//observe fills in InProgressSince for the instances with an operation in progress, the
+//earliest updated_at a run saw for the same operation
+func (tracker *operationTracker) observe(instances []ServiceInstance, now time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.operations == nil {
+		tracker.operations = map[string]trackedOperation{}
+	}
+	for index, instance := range instances {
+		if instance.LastOperation.State != "in progress" {
+			delete(tracker.operations, instance.GUID)
+			continue
+		}
+		since := instance.LastOperation.UpdatedAt
+		if since.IsZero() || since.After(now) {
+			since = now
+		}
+		tracked, known := tracker.operations[instance.GUID]
+		if known && tracked.operationType == instance.LastOperation.Type && tracked.since.Before(since) {
+			since = tracked.since
+		}
+		tracker.operations[instance.GUID] = trackedOperation{operationType: instance.LastOperation.Type, since: since, spaceGUID: instance.SpaceGUID, seen: now}
+		instances[index].InProgressSince = since
+	}
+}
+
+//forget drops the operations of instances that are gone from the collected spaces, ones not
+//seen since started
+func (tracker *operationTracker) forget(collected map[string]bool, started time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for guid, tracked := range tracker.operations {
+		if collected[tracked.spaceGUID] && tracked.seen.Before(started) {
+			delete(tracker.operations, guid)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//maxV2PageSize and maxV3PageSize are the most results per page each api will hand out
+const (
+	maxV2PageSize = 100
+	maxV3PageSize = 5000
+)
+
+//pager walks a list endpoint one page at a time by following next_url (or v3's
+//pagination.next.href) until the api stops handing one back. once a page says how many
+//there are it fetches up to client.pageConcurrency of the rest ahead, handing them out in
+//order, so long lists like /v2/events aren't a request at a time but never all in memory
+type pager struct {
+	client *Client
+	next   string
+	//ctx is the caller's, cancelled by stop so the pages fetched ahead don't carry on once
+	//nobody is going to read them
+	ctx    context.Context
+	cancel context.CancelFunc
+	//fetched counts pages, including any the caller fetched before handing over
+	fetched int
+	//ahead are the pages being fetched ahead in order, upcoming the ones still to start
+	ahead    []chan pageResult
+	upcoming []string
+	//capped is set when upcoming stops at client.maxPages short of the last page
+	capped bool
+}
+
+type pageResult struct {
+	resources []Resource
+	err       error
+}
+
+//newPager pages through endpoint with ctx. stop it once done with it
+func (client *Client) newPager(ctx context.Context, endpoint string) *pager {
+	ctx, cancel := context.WithCancel(ctx)
+	return &pager{client: client, next: endpoint, ctx: ctx, cancel: cancel}
+}
+
+//stop gives up on the pages still being fetched ahead, and on the rest of the list
+func (p *pager) stop() {
+	p.next, p.ahead, p.upcoming = "", nil, nil
+	p.cancel()
+}
+
+//more is true while there's another page to fetch
+func (p *pager) more() bool {
+	return p.next != ""
+}
+
+//page fetches the next page. once client.maxPages pages have been fetched it stops with
+//errTruncated instead, and once the pager's ctx is done it stops with ctx's error. either
+//way, or when a page fails, the pager is stopped
+func (p *pager) page() ([]Resource, error) {
+	err := p.ctx.Err()
+	if err != nil {
+		p.stop()
+		return nil, err
+	}
+	if p.client.maxPages > 0 && p.fetched >= p.client.maxPages {
+		p.stop()
+		return nil, errTruncated
+	}
+	if len(p.ahead) > 0 {
+		result := <-p.ahead[0]
+		p.ahead = p.ahead[1:]
+		p.fetched++
+		if result.err != nil {
+			//whatever's still in flight is cancelled, finishes into its buffered channel and
+			//is dropped
+			p.stop()
+			return nil, result.err
+		}
+		p.fetchAhead()
+		//a capped list still has a next page, asking for it gets errTruncated
+		if len(p.ahead) == 0 && !p.capped {
+			p.next = ""
+		}
+		return result.resources, nil
+	}
+
+	var response APIResponse
+	err = p.client.cfAPIRequest(p.ctx, p.next, &response)
+	if err != nil {
+		p.stop()
+		return nil, err
+	}
+	err = p.fetchedPage(response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Resources, nil
+}
+
+//fetchedPage moves the pager past a page fetched without it, and once the page says how
+//many there are starts fetching the rest ahead
+func (p *pager) fetchedPage(response APIResponse) error {
+	p.fetched++
+	p.next = response.NextURL
+	if total := pageCount(response); p.client.pageConcurrency > 1 && total > p.fetched && p.next != "" {
+		if p.client.maxPages > 0 && total > p.client.maxPages {
+			total, p.capped = p.client.maxPages, true
+		}
+		var err error
+		p.upcoming, err = pageEndpoints(p.next, p.fetched+1, total)
+		if err != nil {
+			p.stop()
+			return err
+		}
+		p.fetchAhead()
+	}
+	return nil
+}
+
+//fetchAhead starts fetching upcoming pages until client.pageConcurrency are on their way
+func (p *pager) fetchAhead() {
+	for len(p.ahead) < p.client.pageConcurrency && len(p.upcoming) > 0 {
+		result := make(chan pageResult, 1)
+		go func(endpoint string) {
+			var response APIResponse
+			err := p.client.cfAPIRequest(p.ctx, endpoint, &response)
+			result <- pageResult{resources: response.Resources, err: err}
+		}(p.upcoming[0])
+		p.ahead = append(p.ahead, result)
+		p.upcoming = p.upcoming[1:]
+	}
+}
+
+//pageCount is how many pages a list has going by its first page: total_pages, or
+//total_results over the size of the page when only that's there
+func pageCount(response APIResponse) int {
+	if response.TotalPages > 0 {
+		return response.TotalPages
+	}
+	if response.TotalResults > 0 && len(response.Resources) > 0 {
+		return (response.TotalResults + len(response.Resources) - 1) / len(response.Resources)
+	}
+	return 0
+}
+
+//pageEndpoints is the endpoints of pages from through to, with nextURL (the url of page
+//from) as the template
+func pageEndpoints(nextURL string, from int, to int) ([]string, error) {
+	pageURL, err := url.Parse(nextURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse next_url %s: %s", nextURL, err)
+	}
+	var endpoints []string
+	for page := from; page <= to; page++ {
+		query := pageURL.Query()
+		query.Set("page", strconv.Itoa(page))
+		endpoints = append(endpoints, pageURL.Path+"?"+query.Encode())
+	}
+	return endpoints, nil
+}
+
+//EachResource calls fn with every resource of a list endpoint, a page at a time, so the
+//whole list never has to be held in memory. an error from fn stops the walk and is
+//returned. like Resources, resources already seen are skipped unless duplicates are kept
+func (client *Client) EachResource(ctx context.Context, endpoint string, fn func(Resource) error) error {
+	seen := map[string]bool{}
+	pages := client.newPager(ctx, endpoint)
+	defer pages.stop()
+	for pages.more() {
+		resources, err := pages.page()
+		err = client.warnIfTruncated(err, "listing "+endpoint)
+		if err != nil {
+			return err
+		}
+		for _, resource := range resources {
+			guid := resource.Metadata.GUID
+			if !client.keepDuplicates && guid != "" {
+				if seen[guid] {
+					continue
+				}
+				seen[guid] = true
+			}
+			err = fn(resource)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//withPageSize asks a list endpoint for client.pageSize results per page, unless the endpoint
+//already says how many it wants. next_urls carry the size of the first page along, so only
+//first pages ever need it added
+func (client *Client) withPageSize(endpoint string) string {
+	if client.pageSize == 0 {
+		return endpoint
+	}
+	param := "results-per-page"
+	size := min(client.pageSize, maxV2PageSize)
+	if isV3Endpoint(endpoint) {
+		param = "per_page"
+		size = client.pageSize
+	}
+	if strings.Contains(endpoint, "?"+param+"=") || strings.Contains(endpoint, "&"+param+"=") {
+		return endpoint
+	}
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+	return endpoint + separator + param + "=" + strconv.Itoa(size)
+}
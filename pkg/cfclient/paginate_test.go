@@ -0,0 +1,197 @@
+package cfclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//slowPagedServer serves totalPages pages of perPage apps at /v2/apps, each taking delay
+func slowPagedServer(totalPages int, perPage int, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		time.Sleep(delay)
+		writeV2Page(w, "/v2/apps", testResources("app", (page-1)*perPage, perPage), page, totalPages)
+	}))
+}
+
+//checkPrefix fails the test unless resources are the first want testResources of prefix, in
+//order
+func checkPrefix(t *testing.T, resources []Resource, want int, prefix string) {
+	t.Helper()
+	if len(resources) != want {
+		t.Fatalf("got %d resources, want %d", len(resources), want)
+	}
+	for index, resource := range resources {
+		if got, want := EntityString(resource, "name"), prefix+"-"+strconv.Itoa(index); got != want {
+			t.Fatalf("resource %d is %s, want %s", index, got, want)
+		}
+	}
+}
+
+func TestConcurrentPagesComeBackInOrderAndFaster(t *testing.T) {
+	const totalPages, perPage, delay = 8, 3, 50 * time.Millisecond
+	server := slowPagedServer(totalPages, perPage, delay)
+	defer server.Close()
+
+	elapsed := map[int]time.Duration{}
+	for _, concurrency := range []int{1, 4} {
+		client := newTestClient(t, server, Config{PageConcurrency: concurrency})
+
+		started := time.Now()
+		resources, err := client.Resources(context.Background(), "/v2/apps")
+		elapsed[concurrency] = time.Since(started)
+		if err != nil {
+			t.Fatalf("concurrency %d: %s", concurrency, err)
+		}
+		checkPrefix(t, resources, totalPages*perPage, "app")
+
+		//EachResource reads ahead with its own pager, and has to hand pages out in order too
+		var walked []Resource
+		err = client.EachResource(context.Background(), "/v2/apps", func(resource Resource) error {
+			walked = append(walked, resource)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("concurrency %d: %s", concurrency, err)
+		}
+		checkPrefix(t, walked, totalPages*perPage, "app")
+	}
+
+	//one page at a time takes a delay per page, four at a time should take about half of that
+	//at most, even on a slow machine
+	if elapsed[4] >= elapsed[1]/2 {
+		t.Fatalf("fetching 4 pages at a time took %s, one at a time %s", elapsed[4], elapsed[1])
+	}
+	if elapsed[1] < totalPages*delay {
+		t.Fatalf("fetching one page at a time took %s, less than %d pages of %s", elapsed[1], totalPages, delay)
+	}
+}
+
+func TestMaxPagesStopsPagingAndSaysSo(t *testing.T) {
+	const totalPages, perPage = 10, 3
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		writeV2Page(w, "/v2/events", testResources("event", (page-1)*perPage, perPage), page, totalPages)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		maxPages    int
+		concurrency int
+		wantPages   int
+		truncated   bool
+	}{
+		{maxPages: 3, concurrency: 1, wantPages: 3, truncated: true},
+		{maxPages: 3, concurrency: 4, wantPages: 3, truncated: true},
+		{maxPages: 10, concurrency: 4, wantPages: 10},
+		{maxPages: 0, concurrency: 1, wantPages: 10},
+	} {
+		client := newTestClient(t, server, Config{MaxPages: test.maxPages, PageConcurrency: test.concurrency})
+
+		atomic.StoreInt64(&requests, 0)
+		var first APIResponse
+		err := client.cfAPIRequest(context.Background(), "/v2/events", &first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources, err := client.cfResourcesFromResponse(context.Background(), first)
+		if test.truncated && err != errTruncated {
+			t.Errorf("max pages %d, concurrency %d: got %v, want the results reported as truncated", test.maxPages, test.concurrency, err)
+		}
+		if !test.truncated && err != nil {
+			t.Errorf("max pages %d, concurrency %d: %s", test.maxPages, test.concurrency, err)
+		}
+		checkPrefix(t, resources, test.wantPages*perPage, "event")
+		if got := atomic.LoadInt64(&requests); got != int64(test.wantPages) {
+			t.Errorf("max pages %d, concurrency %d: made %d requests, want %d", test.maxPages, test.concurrency, got, test.wantPages)
+		}
+
+		//walking a list a page at a time stops at the same page
+		atomic.StoreInt64(&requests, 0)
+		pages := client.newPager(context.Background(), "/v2/events")
+		var walked []Resource
+		truncated := false
+		for pages.more() {
+			page, err := pages.page()
+			if err == errTruncated {
+				truncated = true
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			walked = append(walked, page...)
+		}
+		if truncated != test.truncated {
+			t.Errorf("max pages %d, concurrency %d: walking every page reported truncation %t, want %t", test.maxPages, test.concurrency, truncated, test.truncated)
+		}
+		checkPrefix(t, walked, test.wantPages*perPage, "event")
+		if got := atomic.LoadInt64(&requests); got != int64(test.wantPages) {
+			t.Errorf("max pages %d, concurrency %d: walking made %d requests, want %d", test.maxPages, test.concurrency, got, test.wantPages)
+		}
+	}
+}
+
+func TestStoppingEarlyCancelsThePagesFetchedAhead(t *testing.T) {
+	const totalPages, perPage = 10, 3
+	//page 1 is handed out, page 2 of /v2/failing fails and the rest hang until their request is
+	//given up on, or long after the test would have failed
+	var hanging int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		switch {
+		case page == 1:
+			writeV2Page(w, r.URL.Path, testResources("app", 0, perPage), page, totalPages)
+		case page == 2 && r.URL.Path == "/v2/failing":
+			http.Error(w, "no", http.StatusBadRequest)
+		default:
+			atomic.AddInt64(&hanging, 1)
+			defer atomic.AddInt64(&hanging, -1)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+				writeV2Page(w, r.URL.Path, testResources("app", (page-1)*perPage, perPage), page, totalPages)
+			}
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server, Config{PageConcurrency: 4})
+
+	for _, test := range []struct {
+		name     string
+		endpoint string
+		fn       func(Resource) error
+	}{
+		{"a page failing", "/v2/failing", func(Resource) error { return nil }},
+		{"the callback failing", "/v2/apps", func(Resource) error { return errors.New("seen enough") }},
+	} {
+		started := time.Now()
+		err := client.EachResource(context.Background(), test.endpoint, test.fn)
+		if err == nil {
+			t.Fatalf("%s should stop the walk with its error", test.name)
+		}
+		for atomic.LoadInt64(&hanging) > 0 && time.Since(started) < 3*time.Second {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := atomic.LoadInt64(&hanging); got > 0 {
+			t.Errorf("%s should cancel the pages still being fetched ahead, %d are still waiting", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+//defaultAPIPageSize is how many results a page has when we don't ask for a size
+const defaultAPIPageSize = 50
+
+//CollectionPlan is what a collection run would fetch from a foundation, worked out from the
+//first page of each list without collecting anything
+type CollectionPlan struct {
+	APIURL     string `json:"api_url"`
+	APIVersion string `json:"api_version"`
+	//Client is which api version the collection talks, APIV2 or APIV3
+	Client   string     `json:"client"`
+	Orgs     int        `json:"orgs"`
+	Spaces   int        `json:"spaces"`
+	Apps     int        `json:"apps"`
+	PageSize int        `json:"page_size"`
+	Steps    []PlanStep `json:"steps"`
+	//Requests is the estimated requests a run makes, the sum of the steps'
+	Requests int `json:"requests"`
+	//ProbeRequests is how many requests working the plan out took
+	ProbeRequests int64 `json:"probe_requests"`
+	//Collectors are the collectors a run goes through
+	Collectors []string `json:"collectors"`
+	//Filtered is true when org or space filters or an org scope are on. the org and space
+	//counts only take in the filters the api can apply, and everything else is counted across
+	//the foundation, so the estimates are upper bounds
+	Filtered bool `json:"filtered"`
+}
+
+//PlanStep is one part of a run. Lists is how many lists it fetches, one per org, space or app
+//for the per ones. TotalResults is how many results there are across the foundation, from
+//the first page of Endpoint, -1 when there's nothing to probe or it couldn't be read. Requests
+//is at least one per list, more when the results need more pages than that
+type PlanStep struct {
+	Doing        string `json:"doing"`
+	Endpoint     string `json:"endpoint,omitempty"`
+	Per          string `json:"per"`
+	Lists        int    `json:"lists"`
+	TotalResults int    `json:"total_results"`
+	Pages        int    `json:"pages"`
+	Requests     int    `json:"requests"`
+	//UpTo is true when only some of the lists are fetched, e.g. stats for started apps only
+	UpTo  bool   `json:"up_to,omitempty"`
+	Error string `json:"error,omitempty"`
+	//pageSize is how many results a page of Endpoint holds
+	pageSize int
+}
+
+//Plan validates the client's credentials and works out what Collect would fetch, reading
+//total_results off a one result page of each list it walks. only failing to get a token,
+//reach the api or count the orgs and spaces is an error
+func (client *Client) Plan(ctx context.Context, appStats bool) (CollectionPlan, error) {
+	plan := CollectionPlan{APIURL: client.APIURL(), Client: client.apiVersion, PageSize: client.pageSize, Collectors: client.Collectors(), Filtered: client.orgGUID != "" || client.filter.orgsFiltered() || client.filter.spacesFiltered() || client.filter.labelSelected()}
+	if plan.PageSize == 0 {
+		plan.PageSize = defaultAPIPageSize
+	}
+	before := client.Stats().Requests
+
+	err := client.RefreshAccessToken(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("could not get a token: %s", err)
+	}
+	info, err := client.Info(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("could not reach the api: %s", err)
+	}
+	plan.APIVersion = info.APIVersion
+
+	v3 := client.apiVersion == APIV3
+	version := func(v2 string, v3Endpoint string) string {
+		if v3 {
+			return v3Endpoint
+		}
+		return v2
+	}
+
+	//the org and space counts size everything else, so they have to work
+	orgs := client.planStep(ctx, "listing orgs", version("/v2/organizations"+nameQuery(client.filter.IncludeOrgs), "/v3/organizations"+namesQuery(client.filter.IncludeOrgs)), "foundation", 1)
+	spacesEndpoint := version("/v2/spaces"+nameQuery(client.filter.IncludeSpaces), "/v3/spaces"+namesQuery(client.filter.IncludeSpaces))
+	if client.orgGUID != "" {
+		orgs = PlanStep{Doing: "reading the org", Per: "foundation", Lists: 1, TotalResults: 1, Pages: 1, Requests: 1}
+		spacesEndpoint = version("/v2/organizations/"+client.orgGUID+"/spaces", "/v3/spaces?organization_guids="+client.orgGUID)
+	}
+	if v3 {
+		spacesEndpoint = withQuery(spacesEndpoint, labelSelectorQuery(client.filter.SpaceLabelSelector))
+	}
+	if orgs.Error != "" {
+		return plan, fmt.Errorf("could not count the orgs: %s", orgs.Error)
+	}
+	spaces := client.planStep(ctx, "listing spaces", spacesEndpoint, "foundation", 1)
+	if spaces.Error != "" {
+		return plan, fmt.Errorf("could not count the spaces: %s", spaces.Error)
+	}
+	plan.Orgs, plan.Spaces = orgs.TotalResults, spaces.TotalResults
+	plan.Steps = append(plan.Steps, orgs, spaces)
+	add := func(step PlanStep) PlanStep {
+		plan.Steps = append(plan.Steps, step)
+		return step
+	}
+
+	for _, eventType := range client.eventTypes() {
+		if !client.collecting("events") {
+			break
+		}
+		events := add(client.planStep(ctx, eventLabel(eventType, "orgs"), client.eventListEndpoint([]string{eventType}), "org", plan.Orgs))
+		if eventType != "audit.space.create" {
+			add(events.per(eventLabel(eventType, "spaces"), "space", plan.Spaces))
+		}
+	}
+	appsEndpoint := version("/v2/apps", "/v3/apps")
+	if v3 {
+		appsEndpoint = withQuery(appsEndpoint, labelSelectorQuery(client.filter.AppLabelSelector))
+	}
+	if client.orgGUID != "" {
+		appsEndpoint = client.appsEndpoint("organization") + client.orgGUID
+	}
+	//the apps are counted even when they aren't collected, since Apps says how big the
+	//foundation is
+	apps := client.planStep(ctx, "associating apps with orgs", appsEndpoint, "org", plan.Orgs)
+	plan.Apps = max(apps.TotalResults, 0)
+	if client.collecting("apps") {
+		add(apps)
+		add(apps.per("associating apps with spaces", "space", plan.Spaces))
+		if v3 {
+			add(client.planStep(ctx, "reading the apps' web processes", "/v3/processes?types="+WebProcess, "org", plan.Orgs))
+		}
+		if appStats {
+			stats := apps.per("reading app instance stats", "app", plan.Apps)
+			stats.Endpoint, stats.Pages, stats.Requests, stats.UpTo = "", plan.Apps, plan.Apps, true
+			add(stats)
+		}
+	}
+
+	if client.collecting("services") {
+		add(client.planStep(ctx, "listing service instances", version("/v2/service_instances", "/v3/service_instances?type=managed"), "space", plan.Spaces))
+	}
+	if client.collecting("user-provided-services") {
+		add(client.planStep(ctx, "listing user provided services", "/v2/user_provided_service_instances", "space", plan.Spaces))
+	}
+	if client.collecting("catalog") {
+		add(client.planStep(ctx, "listing service brokers", version("/v2/service_brokers", "/v3/service_brokers"), "foundation", 1))
+		add(client.planStep(ctx, "listing services", version("/v2/services", "/v3/service_offerings"), "foundation", 1))
+		add(client.planStep(ctx, "listing service plans", version("/v2/service_plans", "/v3/service_plans"), "foundation", 1))
+	}
+	if client.collecting("routes") {
+		add(client.planStep(ctx, "listing routes", version("/v2/routes", "/v3/routes"), "space", plan.Spaces))
+		if v3 {
+			add(client.planStep(ctx, "listing domains", "/v3/domains", "foundation", 1))
+		} else {
+			add(client.planStep(ctx, "listing shared domains", "/v2/shared_domains", "foundation", 1))
+			add(client.planStep(ctx, "listing private domains", "/v2/private_domains", "foundation", 1))
+		}
+	}
+	if client.collecting("buildpacks") {
+		add(client.planStep(ctx, "listing buildpacks", version("/v2/buildpacks", "/v3/buildpacks"), "foundation", 1))
+		if !v3 {
+			//v2 apps only have a stack guid, each stack's name is looked up once
+			stacks := client.planStep(ctx, "looking up stack names", "/v2/stacks", "stack", 0)
+			stacks.Lists = max(stacks.TotalResults, 0)
+			stacks.Pages, stacks.Requests, stacks.UpTo = stacks.Lists, stacks.Lists, true
+			add(stacks)
+		}
+	}
+	if client.collecting("roles") {
+		roles := add(client.planStep(ctx, "counting org roles", "/v3/roles", "org", plan.Orgs))
+		add(roles.per("counting space roles", "space", plan.Spaces))
+	}
+	if client.collecting("isolation-segments") {
+		segments := add(client.planStep(ctx, "listing isolation segments", "/v3/isolation_segments", "foundation", 1))
+		if segments.TotalResults > 0 {
+			add(PlanStep{Doing: "isolation segment orgs and spaces", Per: "segment", Lists: 2 * segments.TotalResults, TotalResults: -1, Pages: 2 * segments.TotalResults, Requests: 2 * segments.TotalResults})
+			add(PlanStep{Doing: "org default isolation segments", Per: "org", Lists: plan.Orgs, TotalResults: -1, Pages: plan.Orgs, Requests: plan.Orgs, UpTo: true})
+		}
+	}
+	if client.collecting("docker") {
+		docker := client.planStep(ctx, "reading docker images", "/v3/apps?lifecycle_type=docker", "app", 0)
+		docker.Lists = max(docker.TotalResults, 0)
+		docker.Pages, docker.Requests = docker.Lists, docker.Lists
+		add(docker)
+	}
+	if client.collecting("tasks") {
+		add(client.planStep(ctx, "listing tasks", "/v3/tasks?"+strings.TrimPrefix(client.createdWindowQuery(), "&"), "space", plan.Spaces))
+	}
+	if client.collecting("builds") {
+		add(client.planStep(ctx, "listing builds", "/v3/builds?"+strings.TrimPrefix(client.createdWindowQuery(), "&"), "space", plan.Spaces))
+	}
+	if client.collecting("deployments") {
+		add(client.planStep(ctx, "listing deployments", "/v3/deployments?"+strings.TrimPrefix(client.createdWindowQuery(), "&"), "space", plan.Spaces))
+		add(apps.per("reading app revisions", "app", plan.Apps))
+		add(apps.per("listing the app revisions created in the window", "app", plan.Apps))
+	}
+	if client.collecting("blobs") {
+		add(client.planStep(ctx, "listing droplets", "/v3/droplets?states=STAGED", "space", plan.Spaces))
+		add(client.planStep(ctx, "listing packages", "/v3/packages?states=READY", "space", plan.Spaces))
+		//at least a droplet and a package per app, more for the ones with older ones kept
+		add(PlanStep{Doing: "sizing droplets and packages", Per: "app", Lists: plan.Apps, TotalResults: -1, Pages: 2 * plan.Apps, Requests: 2 * plan.Apps})
+	}
+	if client.collecting("processes") {
+		add(client.planStep(ctx, "listing processes", "/v3/processes", "space", plan.Spaces))
+		add(apps.per("listing app sidecars", "app", plan.Apps))
+	}
+	if client.collecting("ssh") && v3 {
+		//v2 spaces and apps carry their ssh settings, v3 ones are a call each
+		add(PlanStep{Doing: "reading space ssh settings", Per: "space", Lists: plan.Spaces, TotalResults: -1, Pages: plan.Spaces, Requests: plan.Spaces})
+		add(PlanStep{Doing: "reading app ssh settings", Per: "app", Lists: plan.Apps, TotalResults: -1, Pages: plan.Apps, Requests: plan.Apps, UpTo: true})
+	}
+	if client.collecting("env-scan") {
+		add(PlanStep{Doing: "scanning app env var names", Per: "app", Lists: plan.Apps, TotalResults: -1, Pages: plan.Apps, Requests: plan.Apps})
+	}
+	if client.collecting("security-groups") {
+		groups := add(client.planStep(ctx, "listing security groups", "/v2/security_groups", "foundation", 1))
+		if groups.TotalResults > 0 {
+			add(PlanStep{Doing: "security group spaces", Per: "group", Lists: 2 * groups.TotalResults, TotalResults: -1, Pages: 2 * groups.TotalResults, Requests: 2 * groups.TotalResults})
+		}
+	}
+	if client.collecting("platform") {
+		add(PlanStep{Doing: "reading the platform info and flags", Per: "foundation", Lists: 3, TotalResults: -1, Pages: 3, Requests: 3})
+	}
+	if client.collecting("usage") && client.usageCursorPath != "" {
+		add(PlanStep{Doing: "reading app usage events", Endpoint: version("/v2/app_usage_events", "/v3/app_usage_events"), Per: "foundation", Lists: 1, TotalResults: -1, Pages: 1, Requests: 1})
+	}
+	if client.collecting("quotas") {
+		add(client.planStep(ctx, "listing org quotas", version("/v2/quota_definitions", "/v3/organization_quotas"), "foundation", 1))
+		add(client.planStep(ctx, "listing space quotas", version("/v2/space_quota_definitions", "/v3/space_quotas"), "foundation", 1))
+	}
+
+	for _, step := range plan.Steps {
+		plan.Requests += step.Requests
+	}
+	plan.ProbeRequests = client.Stats().Requests - before
+	return plan, nil
+}
+
+//planStep probes endpoint for its total results and works out the requests for lists of it,
+//one per org or space when per isn't foundation
+func (client *Client) planStep(ctx context.Context, doing string, endpoint string, per string, lists int) PlanStep {
+	step := PlanStep{Doing: doing, Endpoint: endpointName(endpoint), Per: per, Lists: lists, TotalResults: -1, pageSize: client.planPageSize(endpoint)}
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, onePage(endpoint), &response)
+	if err != nil {
+		step.Error = err.Error()
+		step.Pages, step.Requests = lists, lists
+		return step
+	}
+	step.TotalResults = response.TotalResults
+	return step.per(doing, per, lists)
+}
+
+//per is step's results fetched lists at a time instead, e.g. the apps listed once per org and
+//then again once per space
+func (step PlanStep) per(doing string, per string, lists int) PlanStep {
+	step.Doing, step.Per, step.Lists = doing, per, lists
+	step.Pages = lists
+	if step.TotalResults > 0 && step.pageSize > 0 {
+		step.Pages = max(lists, (step.TotalResults+step.pageSize-1)/step.pageSize)
+	}
+	step.Requests = step.Pages
+	return step
+}
+
+//planPageSize is how many results a page of endpoint holds in a run, see withPageSize
+func (client *Client) planPageSize(endpoint string) int {
+	if client.pageSize == 0 {
+		return defaultAPIPageSize
+	}
+	if isV3Endpoint(endpoint) {
+		return client.pageSize
+	}
+	return min(client.pageSize, maxV2PageSize)
+}
+
+//onePage asks endpoint for a single result, all a plan needs is total_results
+func onePage(endpoint string) string {
+	param := "results-per-page=1"
+	if isV3Endpoint(endpoint) {
+		param = "per_page=1"
+	}
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+		if strings.HasSuffix(endpoint, "?") {
+			separator = ""
+		}
+	}
+	return endpoint + separator + param
+}
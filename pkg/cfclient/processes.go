@@ -0,0 +1,274 @@
+package cfclient
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//WebProcess is the process type every app has, what it was pushed to serve. anything else,
+//e.g. worker, comes from a Procfile or the manifest
+const WebProcess = "web"
+
+//Process is one of an app's v3 processes
+type Process struct {
+	GUID      string `json:"guid"`
+	AppGUID   string `json:"app_guid"`
+	AppName   string `json:"app_name"`
+	Type      string `json:"type"`
+	Instances int    `json:"instances"`
+	MemoryMB  int    `json:"memory_in_mb"`
+	DiskMB    int    `json:"disk_in_mb"`
+	HealthCheck
+}
+
+//health check types, as the api has them. v2 calls none what v3 calls process
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckPort    = "port"
+	HealthCheckProcess = "process"
+	HealthCheckNone    = "none"
+)
+
+//HealthCheck is how a process is checked. timeouts are in seconds, 0 when the platform
+//default applies
+type HealthCheck struct {
+	HealthCheckType string `json:"health_check_type"`
+	//HealthCheckTimeout is how long instances have to start passing the check
+	HealthCheckTimeout int `json:"health_check_timeout,omitempty"`
+	//InvocationTimeout is how long each http or port check has to answer
+	InvocationTimeout   int    `json:"health_check_invocation_timeout,omitempty"`
+	HealthCheckEndpoint string `json:"health_check_http_endpoint,omitempty"`
+}
+
+//Sidecar is a command run alongside some of an app's processes, in the same containers
+type Sidecar struct {
+	GUID         string   `json:"guid"`
+	AppGUID      string   `json:"app_guid"`
+	AppName      string   `json:"app_name"`
+	Name         string   `json:"name"`
+	ProcessTypes []string `json:"process_types"`
+	//MemoryMB is out of the processes' memory, 0 when it isn't limited on its own
+	MemoryMB int `json:"memory_in_mb"`
+	//Origin is user for sidecars from the manifest, buildpack for ones a buildpack added
+	Origin string `json:"origin"`
+}
+
+//ProcessTypeSummary is what a space's processes of one type add up to
+type ProcessTypeSummary struct {
+	Type      string `json:"type"`
+	Apps      int    `json:"apps"`
+	Instances int    `json:"instances"`
+	//MemoryMB is reserved memory, instances times memory
+	MemoryMB int `json:"memory_mb"`
+}
+
+//foldWebProcesses gives v3 apps the instances, memory and disk_quota v2 apps carry, from
+//their web processes, so everything that adds up apps reads them the same on either api.
+//it lists each org's web processes, a call per org, and folds them into the org's apps and
+//those of its spaces
+func (client *Client) foldWebProcesses(ctx context.Context, orgs []Data, spaces []Data) []CollectionError {
+	whatYoureDoing := "reading the apps' web processes"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(orgs)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(orgs))
+	perOrg := make([]map[string]Resource, len(orgs))
+	client.forEachConcurrently(ctx, len(orgs), func(index int) {
+		defer bar.Incr()
+		web, err := client.webProcesses(ctx, "organization", orgs[index].GUID)
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(orgs[index], whatYoureDoing, err))
+			return
+		}
+		perOrg[index] = web
+		foldWebProcess(orgs[index].Apps, web)
+	})
+
+	web := map[string]Resource{}
+	for _, processes := range perOrg {
+		for appGUID, process := range processes {
+			web[appGUID] = process
+		}
+	}
+	for _, space := range spaces {
+		foldWebProcess(space.Apps, web)
+	}
+	return flattenFailures(perIndex)
+}
+
+//webProcesses lists the web processes of an org or space, scope being organization or
+//space, by app guid
+func (client *Client) webProcesses(ctx context.Context, scope string, guid string) (map[string]Resource, error) {
+	resources, err := client.Resources(ctx, "/v3/processes?types="+WebProcess+"&"+scope+"_guids="+guid)
+	if err != nil {
+		return nil, err
+	}
+	web := map[string]Resource{}
+	for _, process := range resources {
+		entity, _ := process.Entity.(map[string]interface{})
+		web[nestedString(entity, "relationships", "app", "data", "guid")] = process
+	}
+	return web, nil
+}
+
+//foldWebProcess sets the v2 instances, memory and disk_quota of each app with a process in
+//web, by app guid. apps without one, e.g. never pushed, are left without them
+func foldWebProcess(apps []Resource, web map[string]Resource) {
+	for _, app := range apps {
+		entity, isMap := app.Entity.(map[string]interface{})
+		process, found := web[app.Metadata.GUID]
+		if !isMap || !found {
+			continue
+		}
+		entity["instances"] = float64(EntityInt(process, "instances"))
+		entity["memory"] = float64(EntityInt(process, "memory_in_mb"))
+		entity["disk_quota"] = float64(EntityInt(process, "disk_in_mb"))
+	}
+}
+
+//getProcesses lists each space's v3 processes, a call per space, and every app's sidecars,
+//a call per app on top, so it only runs with Config.Processes. a foundation without the v3
+//api has neither
+func (client *Client) getProcesses(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering processes in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		names := map[string]string{}
+		for _, app := range spaces[index].Apps {
+			names[app.Metadata.GUID] = EntityString(app, "name")
+		}
+		resources, err := client.Resources(ctx, "/v3/processes?space_guids="+spaces[index].GUID)
+		if IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+			return
+		}
+		var processes []Process
+		for _, resource := range resources {
+			entity, _ := resource.Entity.(map[string]interface{})
+			appGUID := nestedString(entity, "relationships", "app", "data", "guid")
+			processes = append(processes, Process{
+				GUID:      resource.Metadata.GUID,
+				AppGUID:   appGUID,
+				AppName:   names[appGUID],
+				Type:      EntityString(resource, "type"),
+				Instances: EntityInt(resource, "instances"),
+				MemoryMB:  EntityInt(resource, "memory_in_mb"),
+				DiskMB:    EntityInt(resource, "disk_in_mb"),
+				HealthCheck: HealthCheck{
+					HealthCheckType:     nestedString(entity, "health_check", "type"),
+					HealthCheckTimeout:  nestedInt(entity, "health_check", "data", "timeout"),
+					InvocationTimeout:   nestedInt(entity, "health_check", "data", "invocation_timeout"),
+					HealthCheckEndpoint: nestedString(entity, "health_check", "data", "endpoint"),
+				},
+			})
+		}
+		spaces[index].Processes = processes
+
+		var sidecars []Sidecar
+		for _, app := range spaces[index].Apps {
+			resources, err := client.Resources(ctx, "/v3/apps/"+app.Metadata.GUID+"/sidecars")
+			if IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+				continue
+			}
+			for _, resource := range resources {
+				entity, _ := resource.Entity.(map[string]interface{})
+				var processTypes []string
+				types, _ := entity["process_types"].([]interface{})
+				for _, processType := range types {
+					if name, isString := processType.(string); isString {
+						processTypes = append(processTypes, name)
+					}
+				}
+				sidecars = append(sidecars, Sidecar{
+					GUID:         resource.Metadata.GUID,
+					AppGUID:      app.Metadata.GUID,
+					AppName:      EntityString(app, "name"),
+					Name:         EntityString(resource, "name"),
+					ProcessTypes: processTypes,
+					MemoryMB:     EntityInt(resource, "memory_in_mb"),
+					Origin:       EntityString(resource, "origin"),
+				})
+			}
+		}
+		spaces[index].Sidecars = sidecars
+	})
+	return flattenFailures(perIndex)
+}
+
+//HealthChecks are the health checks of a space's processes, or with no processes collected
+//of its apps' web processes, which v2 apps carry themselves. v3 apps don't, so a v3 only
+//collection without Config.Processes has none
+func HealthChecks(space Data) []Process {
+	if len(space.Processes) > 0 {
+		return space.Processes
+	}
+	var processes []Process
+	for _, app := range space.Apps {
+		healthCheck := EntityString(app, "health_check_type")
+		if healthCheck == "" {
+			continue
+		}
+		processes = append(processes, Process{
+			AppGUID:   app.Metadata.GUID,
+			AppName:   EntityString(app, "name"),
+			Type:      WebProcess,
+			Instances: EntityInt(app, "instances"),
+			MemoryMB:  EntityInt(app, "memory"),
+			DiskMB:    EntityInt(app, "disk_quota"),
+			HealthCheck: HealthCheck{
+				HealthCheckType:     healthCheck,
+				HealthCheckTimeout:  EntityInt(app, "health_check_timeout"),
+				HealthCheckEndpoint: EntityString(app, "health_check_http_endpoint"),
+			},
+		})
+	}
+	return processes
+}
+
+//SummarizeProcesses adds a space's processes up by type, sorted by type with web first
+func SummarizeProcesses(space Data) []ProcessTypeSummary {
+	byType := map[string]*ProcessTypeSummary{}
+	for _, process := range space.Processes {
+		summary := byType[process.Type]
+		if summary == nil {
+			summary = &ProcessTypeSummary{Type: process.Type}
+			byType[process.Type] = summary
+		}
+		summary.Apps++
+		summary.Instances += process.Instances
+		summary.MemoryMB += process.Instances * process.MemoryMB
+	}
+	var summaries []ProcessTypeSummary
+	for _, summary := range byType {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if (summaries[i].Type == WebProcess) != (summaries[j].Type == WebProcess) {
+			return summaries[i].Type == WebProcess
+		}
+		return summaries[i].Type < summaries[j].Type
+	})
+	return summaries
+}
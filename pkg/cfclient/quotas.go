@@ -0,0 +1,144 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+)
+
+//unlimited is what the api uses for a quota limit that isn't set
+const unlimited = -1
+
+//Quota is an org or space quota definition. limits of -1 are unlimited
+type Quota struct {
+	Name          string `json:"name"`
+	GUID          string `json:"guid"`
+	MemoryLimitMB int    `json:"memory_limit_mb"`
+	InstanceLimit int    `json:"instance_limit"`
+	RouteLimit    int    `json:"route_limit"`
+	//ServiceInstanceLimit is how many managed service instances there can be, user provided
+	//ones don't count
+	ServiceInstanceLimit int `json:"service_instance_limit"`
+}
+
+//QuotaUsage is how much of its quota an org or space is using. memory and instances only
+//count started apps since that's what the cloud controller enforces. percentages are 0 for
+//unlimited limits
+type QuotaUsage struct {
+	Quota            Quota   `json:"quota"`
+	MemoryUsedMB     int     `json:"memory_used_mb"`
+	Instances        int     `json:"instances"`
+	Routes           int     `json:"routes"`
+	ServiceInstances int     `json:"service_instances"`
+	MemoryPercent    float64 `json:"memory_percent"`
+	InstancesPercent float64 `json:"instances_percent"`
+	RoutesPercent    float64 `json:"routes_percent"`
+	//ServiceInstancesPercent is 0 when the services collector didn't run as well
+	ServiceInstancesPercent float64 `json:"service_instances_percent"`
+}
+
+func percentOf(used int, limit int) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return 100 * float64(used) / float64(limit)
+}
+
+func newQuotaUsage(quota Quota, memory int, instances int, routes int, serviceInstances int) *QuotaUsage {
+	return &QuotaUsage{
+		Quota:                   quota,
+		MemoryUsedMB:            memory,
+		Instances:               instances,
+		Routes:                  routes,
+		ServiceInstances:        serviceInstances,
+		MemoryPercent:           percentOf(memory, quota.MemoryLimitMB),
+		InstancesPercent:        percentOf(instances, quota.InstanceLimit),
+		RoutesPercent:           percentOf(routes, quota.RouteLimit),
+		ServiceInstancesPercent: percentOf(serviceInstances, quota.ServiceInstanceLimit),
+	}
+}
+
+//listQuotas fetches every quota definition at endpoint keyed by guid
+func (client *Client) listQuotas(ctx context.Context, endpoint string) (map[string]Quota, error) {
+	resources, err := client.Resources(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	quotas := map[string]Quota{}
+	for _, resource := range resources {
+		quota := Quota{
+			Name: EntityString(resource, "name"),
+			GUID: resource.Metadata.GUID,
+		}
+		if client.apiVersion == APIV3 {
+			entity, _ := resource.Entity.(map[string]interface{})
+			quota.MemoryLimitMB = nestedLimit(entity, "apps", "total_memory_in_mb")
+			quota.InstanceLimit = nestedLimit(entity, "apps", "total_instances")
+			quota.RouteLimit = nestedLimit(entity, "routes", "total_routes")
+			quota.ServiceInstanceLimit = nestedLimit(entity, "services", "total_service_instances")
+		} else {
+			quota.MemoryLimitMB = EntityInt(resource, "memory_limit")
+			quota.InstanceLimit = EntityInt(resource, "app_instance_limit")
+			quota.RouteLimit = EntityInt(resource, "total_routes")
+			quota.ServiceInstanceLimit = EntityInt(resource, "total_services")
+		}
+		quotas[quota.GUID] = quota
+	}
+	return quotas, nil
+}
+
+//nestedLimit reads a v3 quota limit, where null means unlimited
+func nestedLimit(m map[string]interface{}, section string, key string) int {
+	sectionMap, _ := m[section].(map[string]interface{})
+	value, isNumber := sectionMap[key].(float64)
+	if !isNumber {
+		return unlimited
+	}
+	return int(value)
+}
+
+//getQuotaUsage looks up the org and space quotas and works out how much of them each org and
+//space is using. it has to run after routes, service instances and usage reports are in
+func (client *Client) getQuotaUsage(ctx context.Context, orgs []Data, spaces []Data) []CollectionError {
+	orgEndpoint, spaceEndpoint := "/v2/quota_definitions", "/v2/space_quota_definitions"
+	if client.apiVersion == APIV3 {
+		orgEndpoint, spaceEndpoint = "/v3/organization_quotas", "/v3/space_quotas"
+	}
+
+	var failures []CollectionError
+	orgQuotas, err := client.listQuotas(ctx, orgEndpoint)
+	if err != nil {
+		failures = append(failures, CollectionError{Name: "foundation", Doing: "listing org quotas", Err: err})
+	}
+	spaceQuotas, err := client.listQuotas(ctx, spaceEndpoint)
+	if err != nil {
+		failures = append(failures, CollectionError{Name: "foundation", Doing: "listing space quotas", Err: err})
+	}
+
+	orgRoutes := map[string]int{}
+	for index, space := range spaces {
+		orgRoutes[space.OrganizationGUID] += len(space.Routes)
+		if space.QuotaGUID == "" {
+			continue
+		}
+		quota, exists := spaceQuotas[space.QuotaGUID]
+		if !exists {
+			if spaceQuotas != nil {
+				failures = append(failures, newCollectionError(space, "looking up space quota", fmt.Errorf("no space quota %s", space.QuotaGUID)))
+			}
+			continue
+		}
+		spaces[index].Quota = newQuotaUsage(quota, space.Usage.RunningMemoryMB, space.Usage.RunningInstances, len(space.Routes), len(space.ServiceInstances))
+	}
+
+	for index, org := range orgs {
+		quota, exists := orgQuotas[org.QuotaGUID]
+		if !exists {
+			if orgQuotas != nil && org.QuotaGUID != "" {
+				failures = append(failures, newCollectionError(org, "looking up org quota", fmt.Errorf("no org quota %s", org.QuotaGUID)))
+			}
+			continue
+		}
+		orgs[index].Quota = newQuotaUsage(quota, org.Usage.RunningMemoryMB, org.Usage.RunningInstances, orgRoutes[org.GUID], len(org.ServiceInstances))
+	}
+	return failures
+}
@@ -0,0 +1,118 @@
+package cfclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//rateLimitThreshold is how many requests we like to have left before we stop and wait for
+//the cloud controller's rate limit window to reset
+const rateLimitThreshold = 10
+
+//rateLimit tracks the latest X-RateLimit-* headers the cloud controller sent us
+type rateLimit struct {
+	mutex sync.Mutex
+	known bool
+	//remaining is the number of requests left in the current window, out of limit
+	remaining int
+	limit     int
+	reset     time.Time
+	//headroom is how many requests can be in flight at once, we stop that much earlier
+	headroom int
+	//share is the fraction of each window's limit we let ourselves use, 1 for all of it. below
+	//that, used counts our requests in the window and they're spread out over what's left of it
+	share float64
+	used  int
+	//window is the reset of the window used counts in, next is when the next paced request
+	//can go
+	window time.Time
+	next   time.Time
+}
+
+//update records the rate limit headers of a response, if it has any
+func (limit *rateLimit) update(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+	limit.known = true
+	limit.remaining = remaining
+	limit.reset = time.Unix(reset, 0)
+	if total, err := strconv.Atoi(header.Get("X-RateLimit-Limit")); err == nil {
+		limit.limit = total
+	}
+	if !limit.reset.Equal(limit.window) {
+		limit.window, limit.used = limit.reset, 0
+	}
+}
+
+//wait sleeps until the rate limit window resets if we're nearly out of requests, or have used
+//our share of them, or until ctx is done. with a share, requests are also paced so what's
+//left of it lasts until the reset instead of going in a burst, which is what holds the
+//workers back
+func (limit *rateLimit) wait(ctx context.Context) error {
+	limit.mutex.Lock()
+	if !limit.known {
+		limit.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !now.Before(limit.reset) {
+		//the window is over, the next response says how the new one looks
+		limit.known = false
+		limit.mutex.Unlock()
+		return nil
+	}
+	sleepFor := time.Duration(0)
+	if limit.remaining < rateLimitThreshold+limit.headroom {
+		sleepFor = limit.reset.Sub(now)
+		limit.known = false
+	} else if limit.share < 1 && limit.limit > 0 {
+		allowance := int(limit.share*float64(limit.limit)) - limit.used
+		if allowance <= limit.headroom {
+			sleepFor = limit.reset.Sub(now)
+			limit.known = false
+		} else {
+			slot := limit.next
+			if slot.Before(now) {
+				slot = now
+			}
+			limit.next = slot.Add(limit.reset.Sub(now) / time.Duration(allowance))
+			sleepFor = slot.Sub(now)
+		}
+	}
+	limit.used++
+	limit.mutex.Unlock()
+
+	return sleepContext(ctx, sleepFor)
+}
+
+//RateLimitUsed returns how many requests we've made in the current rate limit window and how
+//many our share of its limit allows, false if the api hasn't told us a limit
+func (client *Client) RateLimitUsed() (int, int, bool) {
+	client.rateLimit.mutex.Lock()
+	defer client.rateLimit.mutex.Unlock()
+	share := client.rateLimit.share
+	if share <= 0 || share > 1 {
+		share = 1
+	}
+	return client.rateLimit.used, int(share * float64(client.rateLimit.limit)), client.rateLimit.known && client.rateLimit.limit > 0
+}
+
+//RateLimitRemaining returns the number of requests left in the current rate limit window
+//and false if the api hasn't told us yet
+func (client *Client) RateLimitRemaining() (int, bool) {
+	client.rateLimit.mutex.Lock()
+	defer client.rateLimit.mutex.Unlock()
+	return client.rateLimit.remaining, client.rateLimit.known
+}
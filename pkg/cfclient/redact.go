@@ -0,0 +1,349 @@
+package cfclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+//what Redaction does with a name it redacts
+const (
+	//RedactHash swaps a name for a salted hash of it, e.g. app-3f2a9c01d4e7, so the same
+	//name still adds up across metrics and runs without being readable
+	RedactHash = "hash"
+	//RedactDrop empties a name out, and swaps an email address inside other text for
+	//[REDACTED]
+	RedactDrop = "drop"
+)
+
+//Redaction hides user names and app names from everything a run hands back, for shipping
+//metrics somewhere that shouldn't see them. the zero Redaction redacts nothing
+type Redaction struct {
+	//Action is RedactHash (the default) or RedactDrop
+	Action string
+	//Users redacts the names of the users events were made by or about, and any email
+	//address in the actor names and metadata of every event
+	Users bool
+	//Apps are the app names to redact, names, globs (pay-*) or a regex between slashes like
+	//the Filter entries. * redacts every app
+	Apps []string
+	//Salt goes into every hash, so a hash can't be matched up with a list of likely names by
+	//hashing those too. the same salt gives the same hashes. RedactHash needs one
+	Salt string
+}
+
+func (redaction Redaction) enabled() bool {
+	return redaction.Users || len(redaction.Apps) > 0
+}
+
+//Validate checks the action, that hashing has a salt, and that every glob and regex in Apps
+//compiles
+func (redaction Redaction) Validate() error {
+	switch redaction.Action {
+	case "", RedactHash, RedactDrop:
+	default:
+		return fmt.Errorf("unknown redaction %s, use %s or %s", redaction.Action, RedactHash, RedactDrop)
+	}
+	//an unsalted hash of a user name or email address is only a lookup away from the name
+	if redaction.enabled() && redaction.Action != RedactDrop && redaction.Salt == "" {
+		return fmt.Errorf("hashing redacted names needs a salt to keep them from being looked up, set one or use %s", RedactDrop)
+	}
+	_, err := compilePatterns(redaction.Apps)
+	if err != nil {
+		return fmt.Errorf("bad app redaction %s", err)
+	}
+	return nil
+}
+
+//emailPattern is close enough to an email address to catch the ones uaa usernames and
+//client names are made of
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+//userKeys are the event metadata keys that hold a user name
+var userKeys = map[string]bool{"username": true, "user_name": true, "actor_username": true}
+
+//redactor applies a client's Redaction. a nil redactor redacts nothing
+type redactor struct {
+	Redaction
+	//apps remembers whether each app name matched, the nozzle asks about the same few over
+	//and over
+	mutex sync.Mutex
+	apps  map[string]bool
+	//regexes are the /regex/ entries of Apps compiled
+	regexes map[string]*regexp.Regexp
+}
+
+func newRedactor(redaction Redaction) *redactor {
+	if !redaction.enabled() {
+		return nil
+	}
+	if redaction.Action == "" {
+		redaction.Action = RedactHash
+	}
+	//Validate has already turned away any that don't compile
+	regexes, _ := compilePatterns(redaction.Apps)
+	return &redactor{Redaction: redaction, apps: map[string]bool{}, regexes: regexes}
+}
+
+//value is what a redacted name of kind (user or app) becomes
+func (redactor *redactor) value(kind string, name string) string {
+	if name == "" || redactor.Action == RedactDrop {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(redactor.Salt + "\x00" + name))
+	return kind + "-" + hex.EncodeToString(sum[:6])
+}
+
+func (redactor *redactor) redactsApp(name string) bool {
+	redactor.mutex.Lock()
+	defer redactor.mutex.Unlock()
+	matched, seen := redactor.apps[name]
+	if !seen {
+		matched = matchesAny(redactor.regexes, redactor.Apps, name)
+		redactor.apps[name] = matched
+	}
+	return matched
+}
+
+//appName is name, or what it's redacted to when it matches Apps
+func (redactor *redactor) appName(name string) string {
+	if redactor == nil || name == "" || !redactor.redactsApp(name) {
+		return name
+	}
+	return redactor.value("app", name)
+}
+
+//userName is name redacted with Users, and name without it
+func (redactor *redactor) userName(name string) string {
+	if redactor == nil || !redactor.Users {
+		return name
+	}
+	return redactor.value("user", name)
+}
+
+//emails redacts the email addresses in text with Users. one hashes the way the same user's
+//name does, since uaa usernames are usually their email address
+func (redactor *redactor) emails(text string) string {
+	if redactor == nil || !redactor.Users {
+		return text
+	}
+	return emailPattern.ReplaceAllStringFunc(text, func(email string) string {
+		if redactor.Action == RedactDrop {
+			return "[REDACTED]"
+		}
+		return redactor.value("user", email)
+	})
+}
+
+//name redacts an event's actor or actee name by the type of thing it names. a user's name
+//goes whole, anything else only loses the email addresses in it, or is an app's name
+func (redactor *redactor) name(kind string, name string) string {
+	switch kind {
+	case "user":
+		return redactor.userName(name)
+	case "app":
+		return redactor.appName(name)
+	}
+	return redactor.emails(name)
+}
+
+//metadata copies an event's metadata with the user names and email addresses in it
+//redacted, and on an app's event the app names under a name key
+func (redactor *redactor) metadata(value interface{}, key string, appEvent bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			copied[k] = redactor.metadata(v, k, appEvent)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, v := range value {
+			copied[i] = redactor.metadata(v, key, appEvent)
+		}
+		return copied
+	case string:
+		if userKeys[key] {
+			return redactor.userName(value)
+		}
+		if key == "name" && appEvent {
+			value = redactor.appName(value)
+		}
+		return redactor.emails(value)
+	}
+	return value
+}
+
+//event redacts an event's actor and actee names and its metadata
+func (redactor *redactor) event(event Event) Event {
+	event.ActorName = redactor.name(event.ActorType, event.ActorName)
+	event.ActeeName = redactor.name(event.ActeeType, event.ActeeName)
+	if event.Metadata != nil {
+		event.Metadata, _ = redactor.metadata(event.Metadata, "", event.ActeeType == "app").(map[string]interface{})
+	}
+	return event
+}
+
+//events redacts events in place
+func (redactor *redactor) events(events []Event) {
+	if redactor == nil {
+		return
+	}
+	for i := range events {
+		events[i] = redactor.event(events[i])
+	}
+}
+
+//eventResource redacts an /v2/events or /v3/audit_events resource the way event does an
+//Event. the entity is copied, the incremental event cursor keeps the same resources
+func (redactor *redactor) eventResource(resource Resource) Resource {
+	entity, isMap := resource.Entity.(map[string]interface{})
+	if !isMap {
+		return resource
+	}
+	copied := make(map[string]interface{}, len(entity))
+	for key, value := range entity {
+		copied[key] = value
+	}
+
+	//v3 audit events keep the actor and the actee (their target) in maps of their own
+	target, _ := copied["target"].(map[string]interface{})
+	if target != nil {
+		appEvent := stringValue(target["type"]) == "app"
+		for _, key := range []string{"actor", "target"} {
+			party, isMap := copied[key].(map[string]interface{})
+			if !isMap {
+				continue
+			}
+			redacted := make(map[string]interface{}, len(party))
+			for k, v := range party {
+				redacted[k] = v
+			}
+			if name, isString := party["name"].(string); isString {
+				redacted["name"] = redactor.name(stringValue(party["type"]), name)
+			}
+			copied[key] = redacted
+		}
+		if data, isMap := copied["data"].(map[string]interface{}); isMap {
+			copied["data"] = redactor.metadata(data, "", appEvent)
+		}
+	} else {
+		appEvent := stringValue(copied["actee_type"]) == "app"
+		if name, isString := copied["actor_name"].(string); isString {
+			copied["actor_name"] = redactor.name(stringValue(copied["actor_type"]), name)
+		}
+		if name, isString := copied["actee_name"].(string); isString {
+			copied["actee_name"] = redactor.name(stringValue(copied["actee_type"]), name)
+		}
+		if name, isString := copied["actor_username"].(string); isString {
+			copied["actor_username"] = redactor.userName(name)
+		}
+		if metadata, isMap := copied["metadata"].(map[string]interface{}); isMap {
+			copied["metadata"] = redactor.metadata(metadata, "", appEvent)
+		}
+	}
+	resource.Entity = copied
+	return resource
+}
+
+func stringValue(value interface{}) string {
+	text, _ := value.(string)
+	return text
+}
+
+//eventResources redacts a list of event resources into a new list
+func (redactor *redactor) eventResources(resources []Resource) []Resource {
+	if resources == nil {
+		return nil
+	}
+	redacted := make([]Resource, len(resources))
+	for i, resource := range resources {
+		redacted[i] = redactor.eventResource(resource)
+	}
+	return redacted
+}
+
+//appResources redacts the names of app resources into a new list, copying the entities
+func (redactor *redactor) appResources(apps []Resource) []Resource {
+	if apps == nil {
+		return nil
+	}
+	redacted := make([]Resource, len(apps))
+	for i, app := range apps {
+		redacted[i] = app
+		entity, isMap := app.Entity.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		name, _ := entity["name"].(string)
+		if redactedName := redactor.appName(name); redactedName != name {
+			copied := make(map[string]interface{}, len(entity))
+			for key, value := range entity {
+				copied[key] = value
+			}
+			copied["name"] = redactedName
+			redacted[i].Entity = copied
+		}
+	}
+	return redacted
+}
+
+//data redacts the user and app names in an org or space
+func (redactor *redactor) data(datapoint *Data) {
+	datapoint.Apps = redactor.appResources(datapoint.Apps)
+	for _, resources := range []*[]Resource{&datapoint.AppCreates, &datapoint.AppStarts, &datapoint.AppUpdates, &datapoint.SpaceCreates, &datapoint.AppCrashes} {
+		*resources = redactor.eventResources(*resources)
+	}
+	if datapoint.Events != nil {
+		events := make([]Event, len(datapoint.Events))
+		copy(events, datapoint.Events)
+		redactor.events(events)
+		datapoint.Events = events
+	}
+	for _, instance := range datapoint.ServiceInstances {
+		for i := range instance.AppBindings {
+			instance.AppBindings[i].AppName = redactor.appName(instance.AppBindings[i].AppName)
+		}
+	}
+	for _, instance := range datapoint.UserProvidedServices {
+		for i := range instance.AppBindings {
+			instance.AppBindings[i].AppName = redactor.appName(instance.AppBindings[i].AppName)
+		}
+	}
+	for i := range datapoint.DockerApps {
+		datapoint.DockerApps[i].AppName = redactor.appName(datapoint.DockerApps[i].AppName)
+	}
+	for i := range datapoint.Revisions {
+		datapoint.Revisions[i].AppName = redactor.appName(datapoint.Revisions[i].AppName)
+	}
+	for i := range datapoint.Blobs {
+		datapoint.Blobs[i].AppName = redactor.appName(datapoint.Blobs[i].AppName)
+	}
+	for i := range datapoint.Processes {
+		datapoint.Processes[i].AppName = redactor.appName(datapoint.Processes[i].AppName)
+	}
+	for i := range datapoint.Sidecars {
+		datapoint.Sidecars[i].AppName = redactor.appName(datapoint.Sidecars[i].AppName)
+	}
+	for i := range datapoint.SSHApps {
+		datapoint.SSHApps[i].AppName = redactor.appName(datapoint.SSHApps[i].AppName)
+	}
+	for i := range datapoint.EnvVars {
+		datapoint.EnvVars[i].AppName = redactor.appName(datapoint.EnvVars[i].AppName)
+	}
+}
+
+//result redacts every org and space of a run, after the collectors are done with the names
+func (redactor *redactor) result(result *CollectionResult) {
+	if redactor == nil {
+		return
+	}
+	for _, datapoints := range [][]Data{result.Orgs, result.Spaces} {
+		for i := range datapoints {
+			redactor.data(&datapoints[i])
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package cfclient
+
+import "testing"
+
+func TestHashingNeedsASalt(t *testing.T) {
+	for _, test := range []struct {
+		redaction Redaction
+		valid     bool
+	}{
+		{Redaction{Users: true}, false},
+		{Redaction{Action: RedactHash, Apps: []string{"pay-*"}}, false},
+		{Redaction{Users: true, Salt: "secret"}, true},
+		{Redaction{Action: RedactDrop, Users: true}, true},
+		//nothing to redact, nothing to hash
+		{Redaction{}, true},
+	} {
+		err := test.redaction.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: got %v, want valid %t", test.redaction, err, test.valid)
+		}
+	}
+}
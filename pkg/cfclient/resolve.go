@@ -0,0 +1,58 @@
+package cfclient
+
+import (
+	"context"
+)
+
+//deletedName is what the resolvers return for a guid that no longer exists
+const deletedName = "<deleted>"
+
+func (client *Client) ResolveOrgName(ctx context.Context, guid string) (string, error) {
+	return client.resolveName(ctx, "org", client.resourceEndpoint("organizations"), guid)
+}
+
+func (client *Client) ResolveSpaceName(ctx context.Context, guid string) (string, error) {
+	return client.resolveName(ctx, "space", client.resourceEndpoint("spaces"), guid)
+}
+
+func (client *Client) ResolveAppName(ctx context.Context, guid string) (string, error) {
+	return client.resolveName(ctx, "app", client.resourceEndpoint("apps"), guid)
+}
+
+//ResolveUserName is a user's username. uaa clients have no user, and only admins can read
+//other users
+func (client *Client) ResolveUserName(ctx context.Context, guid string) (string, error) {
+	return client.lookupName("user", guid, func() (string, error) {
+		resource, err := client.getResource(ctx, client.resourceEndpoint("users")+guid)
+		if IsNotFound(err) {
+			return deletedName, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return EntityString(resource, "username"), nil
+	})
+}
+
+//resourceEndpoint is where a single org/space/app lives in the api version we're using
+func (client *Client) resourceEndpoint(kind string) string {
+	if client.apiVersion == APIV3 {
+		return "/v3/" + kind + "/"
+	}
+	return "/v2/" + kind + "/"
+}
+
+//resolveName turns a guid into the name of the resource at endpoint+guid, going through the
+//lookup cache. a resource that 404s resolves (and is cached) as deletedName
+func (client *Client) resolveName(ctx context.Context, kind string, endpoint string, guid string) (string, error) {
+	return client.lookupName(kind, guid, func() (string, error) {
+		resource, err := client.getResource(ctx, endpoint+guid)
+		if IsNotFound(err) {
+			return deletedName, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return EntityString(resource, "name"), nil
+	})
+}
@@ -0,0 +1,38 @@
+package cfclient
+
+//AppTotals sums the desired instances and reserved memory in MB (instances * memory) of a
+//list of apps
+func AppTotals(apps []Resource) (int, int) {
+	instances, memory := 0, 0
+	for _, app := range apps {
+		appInstances := EntityInt(app, "instances")
+		instances += appInstances
+		memory += appInstances * EntityInt(app, "memory")
+	}
+	return instances, memory
+}
+
+//EntityInt pulls a numeric field out of a generic resource entity, returning 0 if it's
+//missing or not a number
+func EntityInt(resource Resource, key string) int {
+	m, isMap := resource.Entity.(map[string]interface{})
+	if !isMap {
+		return 0
+	}
+	value, isNumber := m[key].(float64)
+	if !isNumber {
+		return 0
+	}
+	return int(value)
+}
+
+//EntityString pulls a string field out of a generic resource entity, returning "" if it's
+//missing or not a string
+func EntityString(resource Resource, key string) string {
+	m, isMap := resource.Entity.(map[string]interface{})
+	if !isMap {
+		return ""
+	}
+	value, _ := m[key].(string)
+	return value
+}
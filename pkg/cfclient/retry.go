@@ -0,0 +1,140 @@
+package cfclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//defaultMaxAttempts is how many times a GET is tried when Config.MaxAttempts isn't set
+	defaultMaxAttempts = 4
+	//defaultRequestTimeout is how long a single request gets when Config.RequestTimeout isn't set
+	defaultRequestTimeout = time.Minute
+	retryBaseDelay        = 500 * time.Millisecond
+	retryMaxDelay         = 30 * time.Second
+)
+
+//RetriesExhaustedError is returned when a GET still failed after every attempt. Err is the
+//last attempt's error, usually an *APIError
+type RetriesExhaustedError struct {
+	Path     string
+	Attempts int
+	Err      error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	var apiErr *APIError
+	if errors.As(e.Err, &apiErr) {
+		return fmt.Sprintf("gave up on %s after %d attempts, last response code %d", e.Path, e.Attempts, apiErr.StatusCode)
+	}
+	return fmt.Sprintf("gave up on %s after %d attempts: %s", e.Path, e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+//retryable is true for errors worth another go: connection failures, 429s and the 502/503/504s
+//gorouter hands out while apps and cloud controllers come and go
+func retryable(err error) bool {
+	//a cancelled or timed out run isn't coming back no matter how often we ask
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//retryDelay is how long to wait before the next attempt. the api's Retry-After, or for a 429
+//its X-RateLimit-Reset, wins over exponential backoff with jitter
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	delay := retryBaseDelay << uint(attempt-1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	//somewhere between half and one and a half times the delay so a pool of workers that all failed together doesn't come back together
+	return time.Duration(rand.Int63n(int64(delay))) + delay/2
+}
+
+//retryAfter reads Retry-After (seconds or an http date) or X-RateLimit-Reset off a response
+func retryAfter(resp *http.Response) time.Duration {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return time.Until(at)
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0))
+		}
+	}
+	return 0
+}
+
+//retryGet runs doRequest for a GET until it works, fails with something not worth retrying,
+//or runs out of attempts
+func (client *Client) retryGet(ctx context.Context, endpoint string) (*http.Response, error) {
+	maxAttempts := client.maxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := client.doRequest(ctx, "GET", endpoint, nil)
+		if err == nil || !retryable(err) {
+			return resp, err
+		}
+		if attempt >= maxAttempts {
+			if maxAttempts == 1 {
+				return nil, err
+			}
+			return nil, &RetriesExhaustedError{Path: endpoint, Attempts: attempt, Err: err}
+		}
+		atomic.AddInt64(&client.counters.retries, 1)
+		client.endpoints.retry(endpoint)
+		sleepErr := sleepContext(ctx, retryDelay(err, attempt))
+		if sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+//sleepContext sleeps for d or until ctx is done, whichever comes first, and returns ctx's
+//error if it was the latter
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,87 @@
+package cfclient
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//the roles orgs and spaces are counted by. v2 lists the users in each role at its own
+//endpoint, v3 lists every role with a type like organization_manager
+var (
+	orgRoles   = []string{"users", "managers", "auditors", "billing_managers"}
+	spaceRoles = []string{"developers", "managers", "auditors"}
+)
+
+//roleName turns a v3 role type into the v2 endpoint's name for it, e.g. organization_manager
+//becomes managers and space_supporter supporters
+func roleName(roleType string) string {
+	roleType = strings.TrimPrefix(strings.TrimPrefix(roleType, "organization_"), "space_")
+	return roleType + "s"
+}
+
+//getRoles counts the users in each role of every org or space. scope is "organization" or
+//"space". a user with several roles is counted in each of them
+func (client *Client) getRoles(ctx context.Context, dataList []Data, scope string) []CollectionError {
+	kind := "spaces"
+	if scope == "organization" {
+		kind = "orgs"
+	}
+	whatYoureDoing := "counting user roles in " + kind
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(dataList)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(dataList))
+	client.forEachConcurrently(ctx, len(dataList), func(index int) {
+		counts, err := client.countRoles(ctx, dataList[index].GUID, scope)
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(dataList[index], whatYoureDoing, err))
+		} else {
+			dataList[index].RoleCounts = counts
+		}
+		bar.Incr()
+	})
+	return flattenFailures(perIndex)
+}
+
+func (client *Client) countRoles(ctx context.Context, guid string, scope string) (map[string]int, error) {
+	roles := orgRoles
+	if scope == "space" {
+		roles = spaceRoles
+	}
+	counts := map[string]int{}
+	for _, role := range roles {
+		counts[role] = 0
+	}
+
+	if client.apiVersion == APIV3 {
+		resources, err := client.Resources(ctx, "/v3/roles?"+scope+"_guids="+guid)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			counts[roleName(EntityString(resource, "type"))]++
+		}
+		return counts, nil
+	}
+
+	//v2 only needs the total of each list, not the users on it
+	v2Scope := "organizations"
+	if scope == "space" {
+		v2Scope = "spaces"
+	}
+	for _, role := range roles {
+		var response APIResponse
+		err := client.cfAPIRequest(ctx, "/v2/"+v2Scope+"/"+guid+"/"+role, &response)
+		if err != nil {
+			return nil, err
+		}
+		counts[role] = response.TotalResults
+	}
+	return counts, nil
+}
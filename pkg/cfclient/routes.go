@@ -0,0 +1,252 @@
+package cfclient
+
+import (
+	"context"
+
+	"github.com/gosuri/uiprogress"
+)
+
+type Route struct {
+	GUID       string `json:"guid"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+	DomainGUID string `json:"domain_guid"`
+	//Protocol is "tcp" for routes with a port reserved on a tcp domain, "http" otherwise
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port,omitempty"`
+	//Apps is how many apps the route is mapped to, 0 for an orphaned route
+	Apps int `json:"apps"`
+}
+
+//Domain is a shared domain, or a private domain owned by OrganizationGUID
+type Domain struct {
+	GUID             string `json:"guid"`
+	Name             string `json:"name"`
+	Shared           bool   `json:"shared"`
+	OrganizationGUID string `json:"organization_guid,omitempty"`
+}
+
+//getRoutes grabs every route in each space, plus a count of mapped routes for each of the
+//space's apps. apps with no routes are recorded with a count of 0. a route can only be
+//mapped to apps in its own space, so the apps' routes are also what counts each route's apps
+func (client *Client) getRoutes(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering routes in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		err := client.getSpaceRoutes(ctx, &spaces[index], whatYoureDoing)
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+		}
+		bar.Incr()
+	})
+	return flattenFailures(perIndex)
+}
+
+func (client *Client) getSpaceRoutes(ctx context.Context, space *Data, whatYoureDoing string) error {
+	if client.apiVersion == APIV3 {
+		return client.getSpaceRoutesV3(ctx, space, whatYoureDoing)
+	}
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, "/v2/spaces/"+space.GUID+"/routes", &response)
+	if err != nil {
+		return err
+	}
+	cfResources, err := client.cfResourcesFromResponse(ctx, response)
+	err = client.warnIfTruncated(err, whatYoureDoing)
+	if err != nil {
+		return err
+	}
+
+	appRouteCounts := map[string]int{}
+	routeAppCounts := map[string]int{}
+	for _, app := range space.Apps {
+		appRoutes, err := client.Resources(ctx, "/v2/apps/"+app.Metadata.GUID+"/routes")
+		if err != nil {
+			return err
+		}
+		appRouteCounts[app.Metadata.GUID] = len(appRoutes)
+		for _, route := range appRoutes {
+			routeAppCounts[route.Metadata.GUID]++
+		}
+	}
+
+	var routes []Route
+	for _, resource := range cfResources {
+		route := routeFromResource(resource)
+		route.Apps = routeAppCounts[route.GUID]
+		routes = append(routes, route)
+	}
+
+	space.Routes = routes
+	space.AppRouteCounts = appRouteCounts
+	return nil
+}
+
+//getSpaceRoutesV3 is getSpaceRoutes for v3, where each route lists the apps it's mapped to
+//as its destinations, so it takes a single list instead of a call per app
+func (client *Client) getSpaceRoutesV3(ctx context.Context, space *Data, whatYoureDoing string) error {
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, "/v3/routes?space_guids="+space.GUID, &response)
+	if err != nil {
+		return err
+	}
+	cfResources, err := client.cfResourcesFromResponse(ctx, response)
+	err = client.warnIfTruncated(err, whatYoureDoing)
+	if err != nil {
+		return err
+	}
+
+	appRouteCounts := map[string]int{}
+	for _, app := range space.Apps {
+		appRouteCounts[app.Metadata.GUID] = 0
+	}
+	var routes []Route
+	for _, resource := range cfResources {
+		entity, _ := resource.Entity.(map[string]interface{})
+		route := Route{
+			GUID:       resource.Metadata.GUID,
+			Host:       EntityString(resource, "host"),
+			Path:       EntityString(resource, "path"),
+			DomainGUID: nestedString(entity, "relationships", "domain", "data", "guid"),
+			Port:       EntityInt(resource, "port"),
+			Protocol:   "http",
+		}
+		if route.Port > 0 {
+			route.Protocol = "tcp"
+		}
+		//an app mapped to the route on several ports or process types is one destination each
+		mapped := map[string]bool{}
+		destinations, _ := entity["destinations"].([]interface{})
+		for _, destination := range destinations {
+			destinationMap, _ := destination.(map[string]interface{})
+			appGUID := nestedString(destinationMap, "app", "guid")
+			if appGUID == "" || mapped[appGUID] {
+				continue
+			}
+			mapped[appGUID] = true
+			if _, collected := appRouteCounts[appGUID]; collected {
+				appRouteCounts[appGUID]++
+			}
+		}
+		route.Apps = len(mapped)
+		routes = append(routes, route)
+	}
+
+	space.Routes = routes
+	space.AppRouteCounts = appRouteCounts
+	return nil
+}
+
+func routeFromResource(resource Resource) Route {
+	r := Route{
+		GUID:       resource.Metadata.GUID,
+		Host:       EntityString(resource, "host"),
+		Path:       EntityString(resource, "path"),
+		DomainGUID: EntityString(resource, "domain_guid"),
+		Port:       EntityInt(resource, "port"),
+		Protocol:   "http",
+	}
+	if r.Port > 0 {
+		r.Protocol = "tcp"
+	}
+	return r
+}
+
+//getDomains lists the foundation's shared and private domains. v3 has them in one list,
+//where a private domain is the one with an owning org
+func (client *Client) getDomains(ctx context.Context) ([]Domain, error) {
+	if client.apiVersion == APIV3 {
+		resources, err := client.Resources(ctx, "/v3/domains")
+		if err != nil {
+			return nil, err
+		}
+		var domains []Domain
+		for _, resource := range resources {
+			entity, _ := resource.Entity.(map[string]interface{})
+			owner := nestedString(entity, "relationships", "organization", "data", "guid")
+			domains = append(domains, Domain{
+				GUID:             resource.Metadata.GUID,
+				Name:             EntityString(resource, "name"),
+				Shared:           owner == "",
+				OrganizationGUID: owner,
+			})
+		}
+		return domains, nil
+	}
+
+	var domains []Domain
+	for _, endpoint := range []string{"/v2/shared_domains", "/v2/private_domains"} {
+		resources, err := client.Resources(ctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			domains = append(domains, Domain{
+				GUID:             resource.Metadata.GUID,
+				Name:             EntityString(resource, "name"),
+				Shared:           endpoint == "/v2/shared_domains",
+				OrganizationGUID: EntityString(resource, "owning_organization_guid"),
+			})
+		}
+	}
+	return domains, nil
+}
+
+//OrphanedRoutes are the routes that aren't mapped to any app. they still count against the
+//org's route quota and hold on to their host, which is usually why someone goes looking
+func OrphanedRoutes(routes []Route) []Route {
+	var orphaned []Route
+	for _, route := range routes {
+		if route.Apps == 0 {
+			orphaned = append(orphaned, route)
+		}
+	}
+	return orphaned
+}
+
+//RoutesPerDomain counts the routes in spaces by domain name. a route on a domain that isn't
+//in domains, e.g. one made after they were listed, is counted under its domain guid
+func RoutesPerDomain(spaces []Data, domains []Domain) map[string]int {
+	names := map[string]string{}
+	for _, domain := range domains {
+		names[domain.GUID] = domain.Name
+	}
+	counts := map[string]int{}
+	for _, space := range spaces {
+		for _, route := range space.Routes {
+			name := names[route.DomainGUID]
+			if name == "" {
+				name = route.DomainGUID
+			}
+			counts[name]++
+		}
+	}
+	return counts
+}
+
+//RouteToAppRatio is the number of routes in a space per app in it. a space with routes but
+//no apps reports its route count
+func RouteToAppRatio(space Data) float64 {
+	if len(space.Apps) == 0 {
+		return float64(len(space.Routes))
+	}
+	return float64(len(space.Routes)) / float64(len(space.Apps))
+}
+
+//SpacesWithHighRouteRatio returns the spaces whose route to app ratio is above the threshold
+func SpacesWithHighRouteRatio(spaces []Data, threshold float64) []Data {
+	var flagged []Data
+	for _, space := range spaces {
+		if RouteToAppRatio(space) > threshold {
+			flagged = append(flagged, space)
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,266 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+)
+
+//RunScope narrows one run down from what the client collects, e.g. a run asked for right
+//after a deploy that only needs the one space. the zero RunScope is a full run
+type RunScope struct {
+	//Org and Space are the name or guid of the org and space to collect, every one when empty
+	Org   string `json:"org,omitempty"`
+	Space string `json:"space,omitempty"`
+	//Collectors are the collectors to run, of the ones the client runs, along with the ones
+	//they need. every one of the client's when empty
+	Collectors []string `json:"collectors,omitempty"`
+}
+
+//Partial is true for a scope that leaves anything out
+func (scope RunScope) Partial() bool {
+	return scope.Org != "" || scope.Space != "" || len(scope.Collectors) > 0
+}
+
+type runScopeKey struct{}
+
+//WithRunScope has the runs Collect makes with ctx only collect what scope says
+func WithRunScope(ctx context.Context, scope RunScope) context.Context {
+	return context.WithValue(ctx, runScopeKey{}, scope)
+}
+
+func runScopeFrom(ctx context.Context) RunScope {
+	scope, _ := ctx.Value(runScopeKey{}).(RunScope)
+	return scope
+}
+
+//runs is whether the scope runs the named collector, because it's named or one that's named
+//needs it
+func (scope RunScope) runs(name string) bool {
+	if len(scope.Collectors) == 0 {
+		return true
+	}
+	needed := map[string]bool{}
+	for _, named := range scope.Collectors {
+		needed[named] = true
+	}
+	//collectors only need ones before them, so going backwards picks up needs of needs
+	for index := len(collectors) - 1; index >= 0; index-- {
+		if needed[collectors[index].name] {
+			for _, need := range collectors[index].needs {
+				needed[need] = true
+			}
+		}
+	}
+	return needed[name]
+}
+
+//scopeOrgsAndSpaces keeps the org and the space the scope names, and the orgs the space is
+//in when it only names a space. naming one that isn't there is an error
+func (scope RunScope) scopeOrgsAndSpaces(orgs []Data, spaces []Data) ([]Data, []Data, error) {
+	if scope.Org != "" {
+		var kept []Data
+		for _, org := range orgs {
+			if org.Name == scope.Org || org.GUID == scope.Org {
+				kept = append(kept, org)
+			}
+		}
+		if len(kept) == 0 {
+			return nil, nil, fmt.Errorf("there's no org %s", scope.Org)
+		}
+		orgs = kept
+		spaces = spacesInOrgs(spaces, orgs)
+	}
+	if scope.Space != "" {
+		var kept []Data
+		spaceOrgs := map[string]bool{}
+		for _, space := range spaces {
+			if space.Name == scope.Space || space.GUID == scope.Space {
+				kept = append(kept, space)
+				spaceOrgs[space.OrganizationGUID] = true
+			}
+		}
+		if len(kept) == 0 {
+			return nil, nil, fmt.Errorf("there's no space %s", scope.Space)
+		}
+		spaces = kept
+		var keptOrgs []Data
+		for _, org := range orgs {
+			if spaceOrgs[org.GUID] {
+				keptOrgs = append(keptOrgs, org)
+			}
+		}
+		orgs = keptOrgs
+	}
+	return orgs, spaces, nil
+}
+
+//collectedFields copy what each collector fills in on an org or space, for Merge
+var collectedFields = map[string]func(to *Data, from Data){
+	"events": func(to *Data, from Data) {
+		to.AppCreates, to.AppStarts, to.AppUpdates, to.SpaceCreates = from.AppCreates, from.AppStarts, from.AppUpdates, from.SpaceCreates
+		to.AppCrashes, to.ServiceBindings, to.Events = from.AppCrashes, from.ServiceBindings, from.Events
+	},
+	"apps": func(to *Data, from Data) {
+		to.Apps, to.AppInstanceStates = from.Apps, from.AppInstanceStates
+	},
+	"services": func(to *Data, from Data) {
+		to.ServiceInstances = from.ServiceInstances
+	},
+	"user-provided-services": func(to *Data, from Data) {
+		to.UserProvidedServices = from.UserProvidedServices
+	},
+	"routes": func(to *Data, from Data) {
+		to.Routes, to.AppRouteCounts = from.Routes, from.AppRouteCounts
+	},
+	"buildpacks": func(to *Data, from Data) {
+		to.BuildpackCounts, to.StackCounts = from.BuildpackCounts, from.StackCounts
+	},
+	"roles": func(to *Data, from Data) {
+		to.RoleCounts = from.RoleCounts
+	},
+	"isolation-segments": func(to *Data, from Data) {
+		to.IsolationSegment = from.IsolationSegment
+	},
+	"docker": func(to *Data, from Data) {
+		to.DockerApps = from.DockerApps
+	},
+	"tasks": func(to *Data, from Data) {
+		to.Tasks = from.Tasks
+	},
+	"builds": func(to *Data, from Data) {
+		to.Builds = from.Builds
+	},
+	"deployments": func(to *Data, from Data) {
+		to.Deployments, to.Revisions = from.Deployments, from.Revisions
+	},
+	"blobs": func(to *Data, from Data) {
+		to.Blobs = from.Blobs
+	},
+	"processes": func(to *Data, from Data) {
+		to.Processes, to.Sidecars = from.Processes, from.Sidecars
+	},
+	"ssh": func(to *Data, from Data) {
+		to.SSHAllowed, to.SSHApps = from.SSHAllowed, from.SSHApps
+	},
+	"env-scan": func(to *Data, from Data) {
+		to.EnvVars = from.EnvVars
+	},
+	//a narrowed run leaves the usage cursor alone, so AppUsageEvents stay the full run's
+	"usage": func(to *Data, from Data) {
+		to.Usage = from.Usage
+	},
+	"quotas": func(to *Data, from Data) {
+		to.Quota = from.Quota
+	},
+}
+
+//collectedResultFields copy what each collector fills in on the run's result, for Merge
+var collectedResultFields = map[string]func(to *CollectionResult, from CollectionResult){
+	"catalog": func(to *CollectionResult, from CollectionResult) {
+		to.ServiceCatalog = from.ServiceCatalog
+	},
+	"routes": func(to *CollectionResult, from CollectionResult) {
+		to.Domains = from.Domains
+	},
+	"buildpacks": func(to *CollectionResult, from CollectionResult) {
+		to.Buildpacks = from.Buildpacks
+	},
+	"isolation-segments": func(to *CollectionResult, from CollectionResult) {
+		to.IsolationSegments = from.IsolationSegments
+	},
+	"security-groups": func(to *CollectionResult, from CollectionResult) {
+		to.SecurityGroups = from.SecurityGroups
+	},
+	"platform": func(to *CollectionResult, from CollectionResult) {
+		to.Platform = from.Platform
+	},
+}
+
+//Merge is full, the foundation's last full run, brought up to date with a narrowed run: the
+//orgs and spaces narrowed went through get what its collectors collected and keep the rest
+//from full, and the org rollups of their spaces are worked out again. it's a whole
+//foundation's worth for the sinks, which would otherwise swap the foundation for the part
+//narrowed has. a narrowed run that isn't Partial is its own merge
+func (full CollectionResult) Merge(narrowed CollectionResult) CollectionResult {
+	if !narrowed.Scope.Partial() {
+		return narrowed
+	}
+	merged := full
+	merged.Failures, merged.Forbidden = narrowed.Failures, narrowed.Forbidden
+	merged.Requests, merged.Spans = narrowed.Requests, narrowed.Spans
+	merged.EventsUntil = narrowed.EventsUntil
+	for _, name := range narrowed.Collectors {
+		if fill, exists := collectedResultFields[name]; exists {
+			fill(&merged, narrowed)
+		}
+	}
+	merged.Orgs = mergeData(full.Orgs, narrowed.Orgs, narrowed.Collectors, nil)
+	//a named org has every one of its spaces in narrowed, unless a space is named too, so the
+	//ones missing are gone
+	var gone map[string]bool
+	if narrowed.Scope.Org != "" && narrowed.Scope.Space == "" {
+		gone = map[string]bool{}
+		for _, org := range narrowed.Orgs {
+			gone[org.GUID] = true
+		}
+	}
+	merged.Spaces = mergeData(full.Spaces, narrowed.Spaces, narrowed.Collectors, gone)
+
+	//an org narrowed down to one space has only that space rolled up into it
+	ran := map[string]bool{}
+	for _, name := range narrowed.Collectors {
+		ran[name] = true
+	}
+	if ran["usage"] {
+		attachUsageReports(merged.Orgs, merged.Spaces)
+	}
+	if ran["services"] {
+		assignServiceInstancesToOrgs(merged.Orgs, merged.Spaces)
+	}
+	if ran["quotas"] {
+		orgRoutes := map[string]int{}
+		for _, space := range merged.Spaces {
+			orgRoutes[space.OrganizationGUID] += len(space.Routes)
+		}
+		for index, org := range merged.Orgs {
+			if org.Quota != nil {
+				merged.Orgs[index].Quota = newQuotaUsage(org.Quota.Quota, org.Usage.RunningMemoryMB, org.Usage.RunningInstances, orgRoutes[org.GUID], len(org.ServiceInstances))
+			}
+		}
+	}
+	return merged
+}
+
+//mergeData is full with each of narrowed's orgs or spaces in place of the one with its guid,
+//keeping what the collectors that didn't run had. the spaces of the orgs in gone that
+//narrowed doesn't have are dropped, and new ones go on the end
+func mergeData(full []Data, narrowed []Data, ran []string, gone map[string]bool) []Data {
+	byGUID := map[string]Data{}
+	for _, data := range narrowed {
+		byGUID[data.GUID] = data
+	}
+	var merged []Data
+	for _, data := range full {
+		fresh, exists := byGUID[data.GUID]
+		if !exists {
+			if !gone[data.OrganizationGUID] {
+				merged = append(merged, data)
+			}
+			continue
+		}
+		delete(byGUID, data.GUID)
+		data.Name, data.OrganizationGUID, data.Labels, data.QuotaGUID = fresh.Name, fresh.OrganizationGUID, fresh.Labels, fresh.QuotaGUID
+		for _, name := range ran {
+			if fill, exists := collectedFields[name]; exists {
+				fill(&data, fresh)
+			}
+		}
+		merged = append(merged, data)
+	}
+	for _, data := range narrowed {
+		if _, exists := byGUID[data.GUID]; exists {
+			merged = append(merged, data)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,72 @@
+package cfclient
+
+import (
+	"testing"
+)
+
+func TestMergeKeepsWhatANarrowedRunLeftOut(t *testing.T) {
+	full := CollectionResult{
+		Collectors: []string{"apps", "routes", "usage"},
+		Domains:    []Domain{{Name: "apps.example.com"}},
+		Orgs:       []Data{{Name: "payments", GUID: "org-a"}, {Name: "search", GUID: "org-b"}},
+		Spaces: []Data{
+			{Name: "prod", GUID: "space-a", OrganizationGUID: "org-a", Apps: []Resource{testApp("api", "STARTED", 2, 1024, 1024)}, Routes: []Route{{Host: "api"}}},
+			{Name: "dev", GUID: "space-b", OrganizationGUID: "org-a", Apps: []Resource{testApp("api", "STARTED", 1, 512, 512)}},
+			{Name: "prod", GUID: "space-c", OrganizationGUID: "org-b", Apps: []Resource{testApp("search", "STARTED", 1, 256, 256)}},
+		},
+	}
+	attachUsageReports(full.Orgs, full.Spaces)
+
+	//a deploy to payments/prod scaled api up, and only the apps were collected again
+	narrowed := CollectionResult{
+		Scope:      RunScope{Org: "payments", Space: "prod", Collectors: []string{"usage"}},
+		Collectors: []string{"apps", "usage"},
+		Orgs:       []Data{{Name: "payments", GUID: "org-a"}},
+		Spaces:     []Data{{Name: "prod", GUID: "space-a", OrganizationGUID: "org-a", Apps: []Resource{testApp("api", "STARTED", 4, 1024, 1024)}}},
+	}
+	attachUsageReports(narrowed.Orgs, narrowed.Spaces)
+
+	merged := full.Merge(narrowed)
+	if merged.Scope.Partial() || len(merged.Orgs) != 2 || len(merged.Spaces) != 3 {
+		t.Fatalf("the merge should be the whole foundation, got scope %+v with %d orgs and %d spaces", merged.Scope, len(merged.Orgs), len(merged.Spaces))
+	}
+	if len(merged.Domains) != 1 {
+		t.Errorf("the domains should be kept from the full run, got %v", merged.Domains)
+	}
+	prod := merged.Spaces[0]
+	if prod.Usage.RunningInstances != 4 {
+		t.Errorf("prod should have the narrowed run's 4 instances, got %d", prod.Usage.RunningInstances)
+	}
+	if len(prod.Routes) != 1 {
+		t.Errorf("prod should keep its routes from the full run, the routes collector didn't run, got %v", prod.Routes)
+	}
+	//payments is prod's 4 and dev's 1, not just the space the run was narrowed to
+	if got := merged.Orgs[0].Usage.RunningInstances; got != 5 {
+		t.Errorf("payments should roll up both its spaces to 5 instances, got %d", got)
+	}
+	if got := merged.Orgs[1].Usage.RunningInstances; got != 1 {
+		t.Errorf("search should be left alone with 1 instance, got %d", got)
+	}
+	if full.Spaces[0].Usage.RunningInstances != 2 {
+		t.Errorf("merging shouldn't change the full run, prod has %d instances", full.Spaces[0].Usage.RunningInstances)
+	}
+}
+
+func TestMergeDropsTheSpacesANamedOrgNoLongerHas(t *testing.T) {
+	full := CollectionResult{
+		Orgs:   []Data{{Name: "payments", GUID: "org-a"}},
+		Spaces: []Data{{Name: "prod", GUID: "space-a", OrganizationGUID: "org-a"}, {Name: "old", GUID: "space-b", OrganizationGUID: "org-a"}},
+	}
+	narrowed := CollectionResult{
+		Scope:  RunScope{Org: "payments"},
+		Orgs:   []Data{{Name: "payments", GUID: "org-a"}},
+		Spaces: []Data{{Name: "prod", GUID: "space-a", OrganizationGUID: "org-a"}, {Name: "new", GUID: "space-c", OrganizationGUID: "org-a"}},
+	}
+	var names []string
+	for _, space := range full.Merge(narrowed).Spaces {
+		names = append(names, space.Name)
+	}
+	if len(names) != 2 || names[0] != "prod" || names[1] != "new" {
+		t.Errorf("the spaces should be prod and new, got %v", names)
+	}
+}
@@ -0,0 +1,33 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+)
+
+//getOrgByGUID fetches exactly one org instead of listing the whole foundation
+func (client *Client) getOrgByGUID(ctx context.Context, guid string) ([]Data, error) {
+	resource, err := client.getResource(ctx, client.resourceEndpoint("organizations")+guid)
+	if IsNotFound(err) {
+		return nil, fmt.Errorf("org %s does not exist", guid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := EntityString(resource, "name")
+	client.lookups.set("org", guid, name)
+	org := Data{Name: name, GUID: guid, QuotaGUID: EntityString(resource, "quota_definition_guid")}
+	if entity, isMap := resource.Entity.(map[string]interface{}); isMap && client.apiVersion == APIV3 {
+		org.QuotaGUID = nestedString(entity, "relationships", "quota", "data", "guid")
+		org.Labels = client.labelTagValues(entity)
+	}
+	return []Data{org}, nil
+}
+
+//getOrgSpaces lists only the spaces in one org
+func (client *Client) getOrgSpaces(ctx context.Context, guid string) ([]Data, error) {
+	if client.apiVersion == APIV3 {
+		return client.getSpacesV3(ctx, "organization_guids="+guid)
+	}
+	return client.getSpacesFrom(ctx, "/v2/organizations/"+guid+"/spaces")
+}
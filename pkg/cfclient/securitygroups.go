@@ -0,0 +1,154 @@
+package cfclient
+
+import (
+	"context"
+	"strings"
+)
+
+//SecurityGroup is an application security group, the egress rules app containers get
+//while running and/or staging, either everywhere (a default group) or in the spaces it's
+//bound to
+type SecurityGroup struct {
+	GUID  string         `json:"guid"`
+	Name  string         `json:"name"`
+	Rules []SecurityRule `json:"rules"`
+	//RunningDefault and StagingDefault apply the group to every space
+	RunningDefault    bool     `json:"running_default"`
+	StagingDefault    bool     `json:"staging_default"`
+	RunningSpaceGUIDs []string `json:"running_space_guids"`
+	StagingSpaceGUIDs []string `json:"staging_space_guids"`
+}
+
+//SecurityRule is one egress rule of a security group
+type SecurityRule struct {
+	Protocol    string `json:"protocol"`
+	Destination string `json:"destination"`
+	Ports       string `json:"ports,omitempty"`
+}
+
+//anywhere is every way a destination can say the whole ipv4 internet
+var anywhere = []string{"0.0.0.0/0", "0.0.0.0-255.255.255.255"}
+
+//WideOpen is true for a rule that lets traffic out to anywhere on every port: protocol all,
+//or tcp or udp without ports or with 1-65535
+func (rule SecurityRule) WideOpen() bool {
+	everywhere := false
+	for _, destination := range strings.Split(rule.Destination, ",") {
+		for _, open := range anywhere {
+			everywhere = everywhere || strings.TrimSpace(destination) == open
+		}
+	}
+	if !everywhere {
+		return false
+	}
+	switch rule.Protocol {
+	case "all":
+		return true
+	case "tcp", "udp":
+		ports := strings.ReplaceAll(rule.Ports, " ", "")
+		return ports == "" || ports == "1-65535" || ports == "0-65535"
+	}
+	return false
+}
+
+//WideOpenRules are a group's rules that let anything out
+func (group SecurityGroup) WideOpenRules() []SecurityRule {
+	var wideOpen []SecurityRule
+	for _, rule := range group.Rules {
+		if rule.WideOpen() {
+			wideOpen = append(wideOpen, rule)
+		}
+	}
+	return wideOpen
+}
+
+//getSecurityGroups lists the application security groups with their rules and the spaces
+//they're bound to. on v2 the spaces are two more lists per group
+func (client *Client) getSecurityGroups(ctx context.Context) ([]SecurityGroup, error) {
+	if client.apiVersion == APIV3 {
+		return client.getSecurityGroupsV3(ctx)
+	}
+	resources, err := client.Resources(ctx, "/v2/security_groups")
+	if err != nil {
+		return nil, err
+	}
+	var groups []SecurityGroup
+	for _, resource := range resources {
+		entity, _ := resource.Entity.(map[string]interface{})
+		group := SecurityGroup{
+			GUID:  resource.Metadata.GUID,
+			Name:  EntityString(resource, "name"),
+			Rules: securityRules(entity["rules"]),
+		}
+		group.RunningDefault, _ = entity["running_default"].(bool)
+		group.StagingDefault, _ = entity["staging_default"].(bool)
+		running, err := client.Resources(ctx, "/v2/security_groups/"+group.GUID+"/spaces")
+		if err != nil {
+			return nil, err
+		}
+		for _, space := range running {
+			group.RunningSpaceGUIDs = append(group.RunningSpaceGUIDs, space.Metadata.GUID)
+		}
+		staging, err := client.Resources(ctx, "/v2/security_groups/"+group.GUID+"/staging_spaces")
+		if err != nil {
+			return nil, err
+		}
+		for _, space := range staging {
+			group.StagingSpaceGUIDs = append(group.StagingSpaceGUIDs, space.Metadata.GUID)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+//getSecurityGroupsV3 has the spaces of each group in its relationships
+func (client *Client) getSecurityGroupsV3(ctx context.Context) ([]SecurityGroup, error) {
+	resources, err := client.Resources(ctx, "/v3/security_groups")
+	if err != nil {
+		return nil, err
+	}
+	var groups []SecurityGroup
+	for _, resource := range resources {
+		entity, _ := resource.Entity.(map[string]interface{})
+		group := SecurityGroup{
+			GUID:              resource.Metadata.GUID,
+			Name:              EntityString(resource, "name"),
+			Rules:             securityRules(entity["rules"]),
+			RunningSpaceGUIDs: relationshipDataGUIDs(entity, "running_spaces"),
+			StagingSpaceGUIDs: relationshipDataGUIDs(entity, "staging_spaces"),
+		}
+		group.RunningDefault, _ = nestedValue(entity, "globally_enabled", "running").(bool)
+		group.StagingDefault, _ = nestedValue(entity, "globally_enabled", "staging").(bool)
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+//securityRules reads a group's rules out of its decoded json
+func securityRules(raw interface{}) []SecurityRule {
+	list, _ := raw.([]interface{})
+	var rules []SecurityRule
+	for _, item := range list {
+		rule, _ := item.(map[string]interface{})
+		rules = append(rules, SecurityRule{
+			Protocol:    nestedString(rule, "protocol"),
+			Destination: nestedString(rule, "destination"),
+			Ports:       nestedString(rule, "ports"),
+		})
+	}
+	return rules
+}
+
+//relationshipDataGUIDs reads the guids of an inline v3 to-many relationship, e.g.
+//relationships.running_spaces.data
+func relationshipDataGUIDs(entity map[string]interface{}, relationship string) []string {
+	data, _ := nestedValue(entity, "relationships", relationship, "data").([]interface{})
+	var guids []string
+	for _, item := range data {
+		related, _ := item.(map[string]interface{})
+		if guid := nestedString(related, "guid"); guid != "" {
+			guids = append(guids, guid)
+		}
+	}
+	return guids
+}
@@ -0,0 +1,64 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//newTestClient is a client for the api at server, with config on top. it talks v2 unless
+//config says otherwise, and without client credentials a token source stands in for uaa
+func newTestClient(t *testing.T, server *httptest.Server, config Config) *Client {
+	t.Helper()
+	//keep the cf cli config and login of whoever runs the tests out of it
+	t.Setenv("CF_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	config.API = server.URL
+	if config.UAA == "" {
+		config.UAA = server.URL
+	}
+	if config.APIVersion == "" {
+		config.APIVersion = APIV2
+	}
+	if config.ClientID == "" && config.TokenSource == nil {
+		config.TokenSource = func() (string, error) { return "test-token", nil }
+	}
+	config.ProgressOut = ioutil.Discard
+	config.Logger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("error setting up the client: %s", err)
+	}
+	return client
+}
+
+//testResources are count resources with guids and names made from prefix and their index
+func testResources(prefix string, from int, count int) []Resource {
+	var resources []Resource
+	for index := from; index < from+count; index++ {
+		name := fmt.Sprintf("%s-%d", prefix, index)
+		resources = append(resources, Resource{
+			Metadata: ResourceMetadata{GUID: name + "-guid"},
+			Entity:   map[string]interface{}{"name": name},
+		})
+	}
+	return resources
+}
+
+//writeV2Page answers with page of totalPages of a v2 list at path, with a next_url to the
+//page after it
+func writeV2Page(w http.ResponseWriter, path string, resources []Resource, page int, totalPages int) {
+	response := APIResponse{TotalPages: totalPages, TotalResults: totalPages * len(resources), Resources: resources}
+	if response.Resources == nil {
+		response.Resources = []Resource{}
+	}
+	if page < totalPages {
+		response.NextURL = fmt.Sprintf("%s?page=%d&results-per-page=%d", path, page+1, len(resources))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
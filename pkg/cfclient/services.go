@@ -0,0 +1,368 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+type ServiceInstance struct {
+	Name            string `json:"name"`
+	GUID            string `json:"guid"`
+	SpaceGUID       string `json:"space_guid"`
+	ServicePlanGUID string `json:"service_plan_guid"`
+	ServiceName     string `json:"service_name"`
+	PlanName        string `json:"plan_name"`
+	//BrokerName is empty when the token isn't allowed to see the broker, only admins are
+	BrokerName string `json:"broker_name,omitempty"`
+	Bindings   int    `json:"bindings"`
+	//AppBindings are the bindings themselves, Bindings of them
+	AppBindings []ServiceBinding `json:"app_bindings"`
+	CreatedAt   time.Time        `json:"created_at"`
+	//LastOperation is the last thing the broker was asked to do with the instance
+	LastOperation LastOperation `json:"last_operation"`
+	//InProgressSince is when the last operation was first seen in progress, by this run or
+	//an earlier one of the same client. zero when it isn't in progress
+	InProgressSince time.Time `json:"in_progress_since,omitempty"`
+}
+
+//ServiceBinding is a service instance bound to an app
+type ServiceBinding struct {
+	GUID      string    `json:"guid"`
+	AppGUID   string    `json:"app_guid"`
+	CreatedAt time.Time `json:"created_at"`
+	//AppName is only filled in with Config.ResolveNames
+	AppName string `json:"app_name,omitempty"`
+}
+
+//LastOperation is a service instance's last broker operation, e.g. create/in progress or
+//delete/failed
+type LastOperation struct {
+	Type        string    `json:"type"`
+	State       string    `json:"state"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+//stuckOperationAge is how long a broker operation can stay in progress before the instance
+//counts as stuck. brokers provisioning a database can take a while, an hour is plenty
+const stuckOperationAge = time.Hour
+
+//Stuck is true for an instance whose last operation failed, e.g. a delete the broker
+//couldn't do, or has been in progress for longer than stuckOperationAge
+func (instance ServiceInstance) Stuck(now time.Time) bool {
+	switch instance.LastOperation.State {
+	case "failed":
+		return true
+	case "in progress":
+		return instance.OperationDuration(now) > stuckOperationAge
+	}
+	return false
+}
+
+//OperationDuration is how long the instance's last operation has been in progress, 0 when
+//it isn't. it goes by InProgressSince, updated_at for an instance collected without it
+func (instance ServiceInstance) OperationDuration(now time.Time) time.Duration {
+	if instance.LastOperation.State != "in progress" {
+		return 0
+	}
+	since := instance.InProgressSince
+	if since.IsZero() {
+		since = instance.LastOperation.UpdatedAt
+	}
+	if since.IsZero() || since.After(now) {
+		return 0
+	}
+	return now.Sub(since)
+}
+
+//StuckServiceInstances picks out the instances that are Stuck
+func StuckServiceInstances(instances []ServiceInstance, now time.Time) []ServiceInstance {
+	var stuck []ServiceInstance
+	for _, instance := range instances {
+		if instance.Stuck(now) {
+			stuck = append(stuck, instance)
+		}
+	}
+	return stuck
+}
+
+//PlanCount is how many instances of one service plan there are, and how many are stuck
+type PlanCount struct {
+	ServiceName string
+	PlanName    string
+	BrokerName  string
+	Instances   int
+	Bindings    int
+	Stuck       int
+}
+
+//CountByPlan counts instances per service plan, sorted by service then plan name
+func CountByPlan(instances []ServiceInstance, now time.Time) []PlanCount {
+	counts := map[string]*PlanCount{}
+	var keys []string
+	for _, instance := range instances {
+		key := instance.ServiceName + "/" + instance.PlanName
+		count := counts[key]
+		if count == nil {
+			count = &PlanCount{ServiceName: instance.ServiceName, PlanName: instance.PlanName, BrokerName: instance.BrokerName}
+			counts[key] = count
+			keys = append(keys, key)
+		}
+		count.Instances++
+		count.Bindings += instance.Bindings
+		if instance.Stuck(now) {
+			count.Stuck++
+		}
+	}
+	sort.Strings(keys)
+	var sorted []PlanCount
+	for _, key := range keys {
+		sorted = append(sorted, *counts[key])
+	}
+	return sorted
+}
+
+//AppExists is false when the api has no app with the given guid, e.g. one a binding still
+//points at after the app was deleted
+func (client *Client) AppExists(ctx context.Context, guid string) (bool, error) {
+	endpoint := "/v2/apps/" + guid
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/apps/" + guid
+	}
+	_, err := client.getResource(ctx, endpoint)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type servicePlan struct {
+	Name        string
+	ServiceName string
+	BrokerName  string
+}
+
+//getServiceInstances pulls every service instance in each space, resolves its plan to a
+//human readable service/plan name and counts how many apps are bound to it
+func (client *Client) getServiceInstances(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering service instances in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	started := time.Now()
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		instances, err := client.getSpaceServiceInstances(ctx, spaces[index], whatYoureDoing)
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+		} else {
+			client.operations.observe(instances, time.Now())
+			spaces[index].ServiceInstances = instances
+		}
+		bar.Incr()
+	})
+	collected := map[string]bool{}
+	for index, space := range spaces {
+		if len(perIndex[index]) == 0 {
+			collected[space.GUID] = true
+		}
+	}
+	client.operations.forget(collected, started)
+	return flattenFailures(perIndex)
+}
+
+func (client *Client) getSpaceServiceInstances(ctx context.Context, space Data, whatYoureDoing string) ([]ServiceInstance, error) {
+	//v3 lists user provided services with the rest, v2 has them on their own endpoint
+	endpoint := "/v2/service_instances?q=space_guid:"
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/service_instances?type=managed&space_guids="
+	}
+	var response APIResponse
+	err := client.cfAPIRequest(ctx, endpoint+space.GUID, &response)
+	if err != nil {
+		return nil, err
+	}
+	cfResources, err := client.cfResourcesFromResponse(ctx, response)
+	err = client.warnIfTruncated(err, whatYoureDoing)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []ServiceInstance
+	for _, resource := range cfResources {
+		instance := ServiceInstance{
+			Name:            EntityString(resource, "name"),
+			GUID:            resource.Metadata.GUID,
+			SpaceGUID:       space.GUID,
+			ServicePlanGUID: EntityString(resource, "service_plan_guid"),
+			CreatedAt:       resource.Metadata.CreatedAt,
+		}
+		if entity, isMap := resource.Entity.(map[string]interface{}); isMap {
+			if client.apiVersion == APIV3 {
+				instance.ServicePlanGUID = nestedString(entity, "relationships", "service_plan", "data", "guid")
+			}
+			instance.LastOperation = LastOperation{
+				Type:        nestedString(entity, "last_operation", "type"),
+				State:       nestedString(entity, "last_operation", "state"),
+				Description: nestedString(entity, "last_operation", "description"),
+			}
+			instance.LastOperation.UpdatedAt, _ = time.Parse(time.RFC3339, nestedString(entity, "last_operation", "updated_at"))
+		}
+
+		//user provided services don't have a plan
+		if instance.ServicePlanGUID != "" {
+			plan, err := client.getServicePlan(ctx, instance.ServicePlanGUID)
+			if err != nil {
+				return nil, err
+			}
+			instance.PlanName = plan.Name
+			instance.ServiceName = plan.ServiceName
+			instance.BrokerName = plan.BrokerName
+		}
+
+		bindingsEndpoint := "/v2/service_instances/" + instance.GUID + "/service_bindings"
+		if client.apiVersion == APIV3 {
+			bindingsEndpoint = "/v3/service_credential_bindings?type=app&service_instance_guids=" + instance.GUID
+		}
+		bindings, err := client.Resources(ctx, bindingsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range bindings {
+			appGUID := EntityString(binding, "app_guid")
+			if entity, isMap := binding.Entity.(map[string]interface{}); isMap && client.apiVersion == APIV3 {
+				appGUID = nestedString(entity, "relationships", "app", "data", "guid")
+			}
+			instance.AppBindings = append(instance.AppBindings, ServiceBinding{
+				GUID:      binding.Metadata.GUID,
+				AppGUID:   appGUID,
+				CreatedAt: binding.Metadata.CreatedAt,
+			})
+		}
+		instance.Bindings = len(instance.AppBindings)
+
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+//getServicePlan resolves a plan guid into its plan, service and broker names. plans are
+//shared by a lot of instances so every lookup goes through the client's lookup cache. v3
+//calls services service offerings, and names them name instead of label
+func (client *Client) getServicePlan(ctx context.Context, guid string) (servicePlan, error) {
+	v3 := client.apiVersion == APIV3
+	planName, err := client.lookupName("service_plan", guid, func() (string, error) {
+		if v3 {
+			planResource, err := client.getResource(ctx, "/v3/service_plans/"+guid)
+			if err != nil {
+				return "", err
+			}
+			entity, _ := planResource.Entity.(map[string]interface{})
+			client.lookups.set("service_plan_service", guid, nestedString(entity, "relationships", "service_offering", "data", "guid"))
+			return EntityString(planResource, "name"), nil
+		}
+		planResource, err := client.getResource(ctx, "/v2/service_plans/"+guid)
+		if err != nil {
+			return "", err
+		}
+		client.lookups.set("service_plan_service", guid, EntityString(planResource, "service_guid"))
+		return EntityString(planResource, "name"), nil
+	})
+	if err != nil {
+		return servicePlan{}, err
+	}
+
+	serviceGUID, _ := client.lookups.get("service_plan_service", guid)
+	serviceName, err := client.lookupName("service", serviceGUID, func() (string, error) {
+		if v3 {
+			serviceResource, err := client.getResource(ctx, "/v3/service_offerings/"+serviceGUID)
+			if err != nil {
+				return "", err
+			}
+			entity, _ := serviceResource.Entity.(map[string]interface{})
+			client.lookups.set("service_broker_guid", serviceGUID, nestedString(entity, "relationships", "service_broker", "data", "guid"))
+			return EntityString(serviceResource, "name"), nil
+		}
+		serviceResource, err := client.getResource(ctx, "/v2/services/"+serviceGUID)
+		if err != nil {
+			return "", err
+		}
+		client.lookups.set("service_broker_guid", serviceGUID, EntityString(serviceResource, "service_broker_guid"))
+		return EntityString(serviceResource, "label"), nil
+	})
+	if err != nil {
+		return servicePlan{}, err
+	}
+
+	//only admins can read brokers, everyone else just doesn't get a broker name. the empty
+	//name is cached too so that's a single 403 per broker
+	brokerGUID, _ := client.lookups.get("service_broker_guid", serviceGUID)
+	brokerName, err := client.lookupName("service_broker", brokerGUID, func() (string, error) {
+		if brokerGUID == "" {
+			return "", nil
+		}
+		brokerEndpoint := "/v2/service_brokers/"
+		if v3 {
+			brokerEndpoint = "/v3/service_brokers/"
+		}
+		brokerResource, err := client.getResource(ctx, brokerEndpoint+brokerGUID)
+		if IsForbidden(err) || IsNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return EntityString(brokerResource, "name"), nil
+	})
+	if err != nil {
+		return servicePlan{}, err
+	}
+
+	return servicePlan{Name: planName, ServiceName: serviceName, BrokerName: brokerName}, nil
+}
+
+//assignServiceInstancesToOrgs rolls the service instances of each space up into its org
+func assignServiceInstancesToOrgs(orgs []Data, spaces []Data) {
+	for index, org := range orgs {
+		orgs[index].ServiceInstances = nil
+		for _, space := range spaces {
+			if space.OrganizationGUID == org.GUID {
+				orgs[index].ServiceInstances = append(orgs[index].ServiceInstances, space.ServiceInstances...)
+			}
+		}
+	}
+}
+
+//getResource fetches a single resource endpoint like /v2/service_plans/:guid or
+///v3/apps/:guid
+func (client *Client) getResource(ctx context.Context, endpoint string) (Resource, error) {
+	var resource Resource
+	resp, err := client.doGetRequest(ctx, endpoint)
+	if err != nil {
+		return resource, err
+	}
+	if isV3Endpoint(endpoint) {
+		var raw map[string]interface{}
+		err = decodeBody(resp, &raw)
+		if err != nil {
+			return resource, fmt.Errorf("could not unmarshal %s: %s", endpoint, err)
+		}
+		return v3Resource(raw), nil
+	}
+	err = decodeBody(resp, &resource)
+	if err != nil {
+		return resource, fmt.Errorf("could not unmarshal %s: %s", endpoint, err)
+	}
+	return resource, nil
+}
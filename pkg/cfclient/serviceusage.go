@@ -0,0 +1,162 @@
+package cfclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+//ServiceConsumption is one line of the monthly service consumption report, the instances of
+//one plan from one broker in one org
+type ServiceConsumption struct {
+	OrgGUID string `json:"org_guid"`
+	OrgName string `json:"org_name"`
+	Broker  string `json:"broker"`
+	Service string `json:"service"`
+	Plan    string `json:"plan"`
+	Creates int    `json:"creates"`
+	Deletes int    `json:"deletes"`
+	//InstanceHours is how long instances of the plan existed inside the window, added up
+	InstanceHours float64 `json:"instance_hours"`
+}
+
+//serviceUsageEvent is the bit of a service usage event the report needs, from either api
+//version
+type serviceUsageEvent struct {
+	state        string
+	createdAt    time.Time
+	instanceGUID string
+	instanceType string
+	orgGUID      string
+	broker       string
+	service      string
+	plan         string
+}
+
+func serviceUsageEventFromResource(resource Resource) serviceUsageEvent {
+	entity, _ := resource.Entity.(map[string]interface{})
+	event := serviceUsageEvent{createdAt: resource.Metadata.CreatedAt}
+	if _, isV3 := entity["service_instance"].(map[string]interface{}); isV3 {
+		event.state = EntityString(resource, "state")
+		event.instanceGUID = nestedString(entity, "service_instance", "guid")
+		event.instanceType = nestedString(entity, "service_instance", "type")
+		event.orgGUID = nestedString(entity, "organization", "guid")
+		event.broker = nestedString(entity, "service_broker", "name")
+		event.service = nestedString(entity, "service_offering", "name")
+		event.plan = nestedString(entity, "service_plan", "name")
+		return event
+	}
+	event.state = EntityString(resource, "state")
+	event.instanceGUID = EntityString(resource, "service_instance_guid")
+	event.instanceType = EntityString(resource, "service_instance_type")
+	event.orgGUID = EntityString(resource, "org_guid")
+	event.broker = EntityString(resource, "service_broker_name")
+	event.service = EntityString(resource, "service_label")
+	event.plan = EntityString(resource, "service_plan_name")
+	return event
+}
+
+//errPastWindow stops the walk through service usage events once they're newer than the window
+var errPastWindow = errors.New("past the end of the window")
+
+//ServiceConsumption walks the service usage events from the start of the platform's history
+//up to until and reports, per org, broker and plan, the instances created and deleted between
+//from and until and how many instance hours they used in that window. user provided service
+//instances aren't brokered so they're left out
+func (client *Client) ServiceConsumption(ctx context.Context, from time.Time, until time.Time) ([]ServiceConsumption, error) {
+	endpoint := "/v2/service_usage_events"
+	if client.apiVersion == APIV3 {
+		endpoint = "/v3/service_usage_events?order_by=created_at"
+	}
+
+	type key struct{ org, broker, service, plan string }
+	lines := map[key]*ServiceConsumption{}
+	line := func(event serviceUsageEvent) *ServiceConsumption {
+		k := key{event.orgGUID, event.broker, event.service, event.plan}
+		if lines[k] == nil {
+			lines[k] = &ServiceConsumption{OrgGUID: event.orgGUID, Broker: event.broker, Service: event.service, Plan: event.plan}
+		}
+		return lines[k]
+	}
+	//hours adds the part of [start, end) that's inside the window
+	hours := func(start time.Time, end time.Time) float64 {
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			return 0
+		}
+		return end.Sub(start).Hours()
+	}
+
+	//live is every instance that exists at the point we've walked up to, and since when
+	live := map[string]serviceUsageEvent{}
+	err := client.EachResource(ctx, endpoint, func(resource Resource) error {
+		event := serviceUsageEventFromResource(resource)
+		if !event.createdAt.Before(until) {
+			return errPastWindow
+		}
+		if event.instanceType == "user_provided_service_instance" {
+			return nil
+		}
+		inWindow := !event.createdAt.Before(from)
+		switch event.state {
+		case "CREATED":
+			live[event.instanceGUID] = event
+			if inWindow {
+				line(event).Creates++
+			}
+		case "UPDATED":
+			//a plan change closes out the old plan's hours and starts the new one's
+			if created, exists := live[event.instanceGUID]; exists {
+				line(created).InstanceHours += hours(created.createdAt, event.createdAt)
+			}
+			live[event.instanceGUID] = event
+		case "DELETED":
+			if created, exists := live[event.instanceGUID]; exists {
+				line(created).InstanceHours += hours(created.createdAt, event.createdAt)
+				delete(live, event.instanceGUID)
+			}
+			if inWindow {
+				line(event).Deletes++
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errPastWindow {
+		return nil, err
+	}
+	for _, created := range live {
+		line(created).InstanceHours += hours(created.createdAt, until)
+	}
+
+	var report []ServiceConsumption
+	for _, consumption := range lines {
+		if consumption.Creates == 0 && consumption.Deletes == 0 && consumption.InstanceHours == 0 {
+			continue
+		}
+		consumption.OrgName, err = client.ResolveOrgName(ctx, consumption.OrgGUID)
+		if err != nil {
+			consumption.OrgName = consumption.OrgGUID
+		}
+		report = append(report, *consumption)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.OrgName != b.OrgName {
+			return a.OrgName < b.OrgName
+		}
+		if a.Broker != b.Broker {
+			return a.Broker < b.Broker
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Plan < b.Plan
+	})
+	return report, nil
+}
@@ -0,0 +1,169 @@
+package cfclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Span is one api request of a traced run, one of its steps (listing the orgs or spaces, or a
+//collector) or the run itself, in the w3c trace context sense. ids are hex, a run's root
+//span has no ParentID, a request's parent is the step it was made in
+type Span struct {
+	TraceID  string        `json:"trace_id"`
+	SpanID   string        `json:"span_id"`
+	ParentID string        `json:"parent_id,omitempty"`
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	//Method, Endpoint, Path, Page and Status are a request's. Endpoint is the one its
+	//EndpointStats are for, Path what was asked for with the query. Page is the page of a
+	//list, 1 for its first request, and Status 0 when there was no response
+	Method   string `json:"method,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	//Collector and Failures are a collector step's, the orgs and spaces it couldn't do
+	Collector string `json:"collector,omitempty"`
+	Failures  int    `json:"failures,omitempty"`
+}
+
+//Exemplar is the latest traced request to land in a latency bucket, so a slow bucket can be
+//followed to the request that made it slow
+type Exemplar struct {
+	TraceID string    `json:"trace_id"`
+	SpanID  string    `json:"span_id"`
+	Latency float64   `json:"latency_seconds"`
+	At      time.Time `json:"at"`
+}
+
+//runTrace is a traced run's trace and the spans that have finished so far
+type runTrace struct {
+	traceID string
+	rootID  string
+	mutex   sync.Mutex
+	spans   []Span
+}
+
+func (trace *runTrace) add(span Span) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	trace.spans = append(trace.spans, span)
+}
+
+//spanParent is what a run's context carries to its requests: the trace and the span they're
+//made in
+type spanParent struct {
+	trace *runTrace
+	id    string
+}
+
+type spanParentKey struct{}
+
+//spanID is a random id of n bytes in hex
+func spanID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//startRun starts the run's trace in its context when the client traces, ctx as it is when it
+//doesn't
+func (client *Client) startRun(ctx context.Context) (context.Context, *runTrace) {
+	if !client.tracing {
+		return ctx, nil
+	}
+	trace := &runTrace{traceID: spanID(16), rootID: spanID(8)}
+	return context.WithValue(ctx, spanParentKey{}, spanParent{trace: trace, id: trace.rootID}), trace
+}
+
+//step is a span for a part of a traced run, the parent of the requests made with the context
+//it returns. end takes the step's error, if any
+type step struct {
+	parent spanParent
+	span   Span
+}
+
+//startStep starts a step named name under whatever span ctx is in, nothing outside a traced run
+func startStep(ctx context.Context, name string) (context.Context, *step) {
+	parent, traced := ctx.Value(spanParentKey{}).(spanParent)
+	if !traced {
+		return ctx, nil
+	}
+	span := Span{TraceID: parent.trace.traceID, SpanID: spanID(8), ParentID: parent.id, Name: name, Start: time.Now()}
+	return context.WithValue(ctx, spanParentKey{}, spanParent{trace: parent.trace, id: span.SpanID}), &step{parent: parent, span: span}
+}
+
+//end finishes a step, with the collector's failures when it's a collector's
+func (current *step) end(err error, collector string, failures int) {
+	if current == nil {
+		return
+	}
+	span := current.span
+	span.Duration = time.Since(span.Start)
+	span.Collector = collector
+	span.Failures = failures
+	if err != nil {
+		span.Error = err.Error()
+	}
+	current.parent.trace.add(span)
+}
+
+//finish is every span of the run, the root one spanning started to now first
+func (trace *runTrace) finish(started time.Time, err error) []Span {
+	if trace == nil {
+		return nil
+	}
+	root := Span{TraceID: trace.traceID, SpanID: trace.rootID, Name: "collect", Start: started, Duration: time.Since(started)}
+	if err != nil {
+		root.Error = err.Error()
+	}
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	return append([]Span{root}, trace.spans...)
+}
+
+//requestSpan is a request's span in the making, nil outside a traced run
+type requestSpan struct {
+	trace *runTrace
+	span  Span
+}
+
+//startSpan gives a request of a traced run its span, sending it on as the traceparent header
+//so the gorouter and the cloud controller's logs can be matched up with it
+func startSpan(ctx context.Context, req *http.Request, endpoint string) *requestSpan {
+	parent, traced := ctx.Value(spanParentKey{}).(spanParent)
+	if !traced {
+		return nil
+	}
+	span := Span{TraceID: parent.trace.traceID, SpanID: spanID(8), ParentID: parent.id, Name: req.Method + " " + endpointName(endpoint),
+		Method: req.Method, Endpoint: endpointName(endpoint), Path: endpoint, Page: 1, Start: time.Now()}
+	if page, err := strconv.Atoi(req.URL.Query().Get("page")); err == nil {
+		span.Page = page
+	}
+	req.Header.Set("traceparent", "00-"+span.TraceID+"-"+span.SpanID+"-01")
+	return &requestSpan{trace: parent.trace, span: span}
+}
+
+//end finishes the span with the request's outcome, and is its latency bucket's exemplar
+func (request *requestSpan) end(resp *http.Response, err error) *Exemplar {
+	if request == nil {
+		return nil
+	}
+	span := request.span
+	span.Duration = time.Since(span.Start)
+	if resp != nil {
+		span.Status = resp.StatusCode
+	}
+	if err != nil {
+		span.Error = err.Error()
+	}
+	request.trace.add(span)
+	return &Exemplar{TraceID: span.TraceID, SpanID: span.SpanID, Latency: span.Duration.Seconds(), At: span.Start.Add(span.Duration)}
+}
@@ -0,0 +1,97 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//SSHApp is an app that can be ssh'd into: ssh is on for it, its space and the platform
+type SSHApp struct {
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+}
+
+//sshEnabled is the shape of v3's /v3/spaces/:guid/features/ssh and /v3/apps/:guid/ssh_enabled
+type sshEnabled struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+//entityBool pulls a boolean field out of a generic resource entity, nil when it's missing
+//or not a boolean
+func entityBool(resource Resource, key string) *bool {
+	m, isMap := resource.Entity.(map[string]interface{})
+	if !isMap {
+		return nil
+	}
+	value, isBool := m[key].(bool)
+	if !isBool {
+		return nil
+	}
+	return &value
+}
+
+//getSSH works out which spaces allow ssh and which of their apps can be ssh'd into. v2
+//spaces and apps carry allow_ssh and enable_ssh, the v3 ones take a call per space and one
+//per app in the spaces that allow it, so it only runs with Config.SSH
+func (client *Client) getSSH(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering ssh settings in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		space := &spaces[index]
+		if space.SSHAllowed == nil {
+			var feature sshEnabled
+			err := client.getJSON(ctx, "/v3/spaces/"+space.GUID+"/features/ssh", &feature)
+			if err != nil {
+				perIndex[index] = append(perIndex[index], newCollectionError(*space, whatYoureDoing, err))
+				return
+			}
+			space.SSHAllowed = &feature.Enabled
+		}
+		var apps []SSHApp
+		for _, app := range space.Apps {
+			if !*space.SSHAllowed {
+				break
+			}
+			enabled := entityBool(app, "enable_ssh")
+			if enabled == nil {
+				var appSSH sshEnabled
+				err := client.getJSON(ctx, "/v3/apps/"+app.Metadata.GUID+"/ssh_enabled", &appSSH)
+				if err != nil {
+					perIndex[index] = append(perIndex[index], newCollectionError(*space, strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+					continue
+				}
+				enabled = &appSSH.Enabled
+			}
+			if *enabled {
+				apps = append(apps, SSHApp{AppGUID: app.Metadata.GUID, AppName: EntityString(app, "name")})
+			}
+		}
+		space.SSHApps = apps
+	})
+	return flattenFailures(perIndex)
+}
+
+//getJSON decodes the json at endpoint into v
+func (client *Client) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	resp, err := client.doGetRequest(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	err = decodeBody(resp, v)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling %s: %s", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//AppLastActivity is the last time anyone did anything to an app that we can see without
+//another call: the app's own updated_at, v2's package_updated_at from the last push, and any
+//of its create, start or update audit events. crashes don't count, an app nobody looks after
+//can crash all it likes
+func AppLastActivity(app Resource, events []Event) time.Time {
+	last := app.Metadata.UpdatedAt
+	if last.IsZero() {
+		last = app.Metadata.CreatedAt
+	}
+	if pushed, err := time.Parse(time.RFC3339, EntityString(app, "package_updated_at")); err == nil && pushed.After(last) {
+		last = pushed
+	}
+	for _, event := range events {
+		if event.Actee != app.Metadata.GUID || IsCrashEvent(event.Type) {
+			continue
+		}
+		if event.Timestamp.After(last) {
+			last = event.Timestamp
+		}
+	}
+	return last
+}
+
+//AppLastStaged is when the droplet an app is running was staged, the v3 stand in for v2's
+//package_updated_at. it's a call per app, so it's only worth asking about apps that look
+//stale already. an app without a current droplet has the zero time
+func (client *Client) AppLastStaged(ctx context.Context, guid string) (time.Time, error) {
+	if client.apiVersion != APIV3 {
+		return time.Time{}, nil
+	}
+	resp, err := client.doGetRequest(ctx, "/v3/apps/"+guid+"/droplets/current")
+	if IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var droplet struct {
+		CreatedAt time.Time `json:"created_at"`
+	}
+	err = decodeBody(resp, &droplet)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error unmarshalling the current droplet of %s: %s", guid, err)
+	}
+	return droplet.CreatedAt, nil
+}
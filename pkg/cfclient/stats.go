@@ -0,0 +1,208 @@
+package cfclient
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//AppInstanceStates is how many of an app's desired instances are in each state
+//(RUNNING, CRASHED, STARTING, DOWN), plus what the instances are actually using
+type AppInstanceStates struct {
+	Desired int            `json:"desired"`
+	States  map[string]int `json:"states"`
+	Usage   AppUsage       `json:"usage"`
+}
+
+//AppUsage is the resource usage of an app summed across the instances that reported it.
+//CPU is a fraction of a core per instance added up, so 2 instances at half a core is 1.0
+type AppUsage struct {
+	CPU              float64 `json:"cpu"`
+	MemoryBytes      int64   `json:"memory_bytes"`
+	MemoryQuotaBytes int64   `json:"memory_quota_bytes"`
+	DiskBytes        int64   `json:"disk_bytes"`
+	DiskQuotaBytes   int64   `json:"disk_quota_bytes"`
+}
+
+//MemoryPercent is memory used as a percentage of the memory quota, 0 without a quota
+func (usage AppUsage) MemoryPercent() float64 {
+	if usage.MemoryQuotaBytes == 0 {
+		return 0
+	}
+	return 100 * float64(usage.MemoryBytes) / float64(usage.MemoryQuotaBytes)
+}
+
+//DiskPercent is disk used as a percentage of the disk quota, 0 without a quota
+func (usage AppUsage) DiskPercent() float64 {
+	if usage.DiskQuotaBytes == 0 {
+		return 0
+	}
+	return 100 * float64(usage.DiskBytes) / float64(usage.DiskQuotaBytes)
+}
+
+//instanceStats is one instance's state and usage, both api versions come down to this
+type instanceStats struct {
+	state     string
+	cpu       float64
+	mem       int64
+	memQuota  int64
+	disk      int64
+	diskQuota int64
+}
+
+func (usage *AppUsage) add(instance instanceStats) {
+	usage.CPU += instance.cpu
+	usage.MemoryBytes += instance.mem
+	usage.MemoryQuotaBytes += instance.memQuota
+	usage.DiskBytes += instance.disk
+	usage.DiskQuotaBytes += instance.diskQuota
+}
+
+//getAppInstanceStates asks /v2/apps/:guid/stats (or the v3 web process stats, or log cache)
+//for the actual state and usage of every instance of each started app in each space. it's a
+//call per app so it's opt in
+func (client *Client) getAppInstanceStates(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering app instance states"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	var failures []CollectionError
+	for index, space := range spaces {
+		instanceStates := map[string]AppInstanceStates{}
+		for _, app := range space.Apps {
+			if EntityString(app, "state") != "STARTED" {
+				continue
+			}
+			states, usage, err := client.getAppStats(ctx, app.Metadata.GUID)
+			if err != nil {
+				failures = append(failures, newCollectionError(space, strings.TrimSpace(whatYoureDoing)+" for app "+EntityString(app, "name"), err))
+				continue
+			}
+			instanceStates[app.Metadata.GUID] = AppInstanceStates{
+				Desired: EntityInt(app, "instances"),
+				States:  states,
+				Usage:   usage,
+			}
+		}
+		spaces[index].AppInstanceStates = instanceStates
+		bar.Incr()
+	}
+	return failures
+}
+
+//getAppStats counts an app's instances by state and adds up their usage. cf answers with a
+//400 when an app stopped between listing it and asking for its stats, that just means no
+//instances
+func (client *Client) getAppStats(ctx context.Context, guid string) (map[string]int, AppUsage, error) {
+	states := map[string]int{}
+	var usage AppUsage
+
+	var instances []instanceStats
+	var err error
+	if client.appStatsSource == AppStatsLogCache {
+		instances, err = client.getLogCacheStats(ctx, guid)
+	} else if client.apiVersion == APIV3 {
+		instances, err = client.getProcessStatsV3(ctx, guid)
+	} else {
+		instances, err = client.getAppStatsV2(ctx, guid)
+	}
+	if hasStatus(err, http.StatusBadRequest) {
+		return states, usage, nil
+	}
+	if err != nil {
+		return nil, usage, err
+	}
+
+	for _, instance := range instances {
+		states[instance.state]++
+		usage.add(instance)
+	}
+	return states, usage, nil
+}
+
+func (client *Client) getAppStatsV2(ctx context.Context, guid string) ([]instanceStats, error) {
+	resp, err := client.doGetRequest(ctx, "/v2/apps/"+guid+"/stats")
+	if err != nil {
+		return nil, err
+	}
+	var in map[string]struct {
+		State string `json:"state"`
+		Stats struct {
+			MemQuota  int64 `json:"mem_quota"`
+			DiskQuota int64 `json:"disk_quota"`
+			Usage     struct {
+				CPU  float64 `json:"cpu"`
+				Mem  int64   `json:"mem"`
+				Disk int64   `json:"disk"`
+			} `json:"usage"`
+		} `json:"stats"`
+	}
+	err = decodeBody(resp, &in)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instanceStats
+	for _, instance := range in {
+		instances = append(instances, instanceStats{
+			state:     instance.State,
+			cpu:       instance.Stats.Usage.CPU,
+			mem:       instance.Stats.Usage.Mem,
+			memQuota:  instance.Stats.MemQuota,
+			disk:      instance.Stats.Usage.Disk,
+			diskQuota: instance.Stats.DiskQuota,
+		})
+	}
+	return instances, nil
+}
+
+//getProcessStatsV3 reads the stats of an app's web process
+func (client *Client) getProcessStatsV3(ctx context.Context, guid string) ([]instanceStats, error) {
+	resp, err := client.doGetRequest(ctx, "/v3/apps/"+guid+"/processes/web/stats")
+	if err != nil {
+		return nil, err
+	}
+	var in struct {
+		Resources []struct {
+			State     string `json:"state"`
+			MemQuota  int64  `json:"mem_quota"`
+			DiskQuota int64  `json:"disk_quota"`
+			Usage     struct {
+				CPU  float64 `json:"cpu"`
+				Mem  int64   `json:"mem"`
+				Disk int64   `json:"disk"`
+			} `json:"usage"`
+		} `json:"resources"`
+	}
+	err = decodeBody(resp, &in)
+	if err != nil {
+		return nil, err
+	}
+	var instances []instanceStats
+	for _, instance := range in.Resources {
+		instances = append(instances, instanceStats{
+			state:     instance.State,
+			cpu:       instance.Usage.CPU,
+			mem:       instance.Usage.Mem,
+			memQuota:  instance.MemQuota,
+			disk:      instance.Usage.Disk,
+			diskQuota: instance.DiskQuota,
+		})
+	}
+	return instances, nil
+}
+
+//RunningVsDesired totals up the running and desired instances of the apps we have stats for
+func RunningVsDesired(instanceStates map[string]AppInstanceStates) (int, int) {
+	running, desired := 0, 0
+	for _, app := range instanceStates {
+		running += app.States["RUNNING"]
+		desired += app.Desired
+	}
+	return running, desired
+}
@@ -0,0 +1,44 @@
+package cfclient
+
+//FoundationSummary is the foundation wide rollup of a collection run
+type FoundationSummary struct {
+	Orgs             int            `json:"orgs"`
+	Spaces           int            `json:"spaces"`
+	Apps             int            `json:"apps"`
+	DesiredInstances int            `json:"desired_instances"`
+	RunningInstances int            `json:"running_instances"`
+	ReservedMemoryMB int            `json:"reserved_memory_mb"`
+	ServiceInstances int            `json:"service_instances"`
+	EventsByType     map[string]int `json:"events_by_type"`
+}
+
+//SummarizeFoundation adds up already collected orgs and spaces, it makes no api calls.
+//apps and instances are counted from spaces since every app lives in exactly one, events
+//are counted from orgs since the org queries already cover every space in them
+func SummarizeFoundation(orgs []Data, spaces []Data) FoundationSummary {
+	summary := FoundationSummary{
+		Orgs:         len(orgs),
+		Spaces:       len(spaces),
+		EventsByType: map[string]int{},
+	}
+
+	for _, space := range spaces {
+		summary.Apps += len(space.Apps)
+		summary.ServiceInstances += len(space.ServiceInstances)
+		instances, memory := AppTotals(space.Apps)
+		summary.DesiredInstances += instances
+		summary.ReservedMemoryMB += memory
+		for _, app := range space.Apps {
+			if EntityString(app, "state") == "STARTED" {
+				summary.RunningInstances += EntityInt(app, "instances")
+			}
+		}
+	}
+
+	for _, org := range orgs {
+		for _, event := range org.Events {
+			summary.EventsByType[event.Type]++
+		}
+	}
+	return summary
+}
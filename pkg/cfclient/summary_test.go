@@ -0,0 +1,71 @@
+package cfclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+//testApp is an app resource the way v2 lists them, with memory and disk in MB
+func testApp(name string, state string, instances int, memoryMB int, diskMB int) Resource {
+	return Resource{
+		Metadata: ResourceMetadata{GUID: name + "-guid"},
+		Entity: map[string]interface{}{
+			"name":       name,
+			"state":      state,
+			"instances":  float64(instances),
+			"memory":     float64(memoryMB),
+			"disk_quota": float64(diskMB),
+		},
+	}
+}
+
+func TestSummarizeFoundation(t *testing.T) {
+	orgs := []Data{
+		{Name: "payments", GUID: "org-a", Events: []Event{{Type: "audit.app.create"}, {Type: "audit.app.start"}, {Type: "audit.app.start"}}},
+		{Name: "search", GUID: "org-b", Events: []Event{{Type: "audit.app.update"}}},
+		{Name: "empty", GUID: "org-c"},
+	}
+	spaces := []Data{
+		{
+			Name: "prod", OrganizationGUID: "org-a",
+			Apps: []Resource{
+				testApp("api", "STARTED", 3, 1024, 2048),
+				testApp("worker", "STARTED", 2, 512, 1024),
+			},
+			ServiceInstances: []ServiceInstance{{Name: "db"}, {Name: "cache"}},
+		},
+		{
+			Name: "dev", OrganizationGUID: "org-a",
+			//a stopped app still reserves its memory, it just doesn't run
+			Apps: []Resource{testApp("api", "STOPPED", 1, 256, 512)},
+		},
+		{
+			Name: "prod", OrganizationGUID: "org-b",
+			Apps:             []Resource{testApp("indexer", "STARTED", 4, 2048, 4096)},
+			ServiceInstances: []ServiceInstance{{Name: "search"}},
+		},
+		{Name: "sandbox", OrganizationGUID: "org-c"},
+	}
+
+	got := SummarizeFoundation(orgs, spaces)
+	want := FoundationSummary{
+		Orgs:             3,
+		Spaces:           4,
+		Apps:             4,
+		DesiredInstances: 3 + 2 + 1 + 4,
+		RunningInstances: 3 + 2 + 4,
+		ReservedMemoryMB: 3*1024 + 2*512 + 1*256 + 4*2048,
+		ServiceInstances: 3,
+		EventsByType:     map[string]int{"audit.app.create": 1, "audit.app.start": 2, "audit.app.update": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSummarizeNothing(t *testing.T) {
+	got := SummarizeFoundation(nil, nil)
+	if !reflect.DeepEqual(got, FoundationSummary{EventsByType: map[string]int{}}) {
+		t.Fatalf("an empty foundation came to %+v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package cfclient
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//task states, as v3 has them
+const (
+	TaskPending   = "PENDING"
+	TaskRunning   = "RUNNING"
+	TaskCanceling = "CANCELING"
+	TaskSucceeded = "SUCCEEDED"
+	TaskFailed    = "FAILED"
+)
+
+//TaskStateList is every task state, in the order a task moves through them
+var TaskStateList = []string{TaskPending, TaskRunning, TaskCanceling, TaskSucceeded, TaskFailed}
+
+//Task is a one off or scheduled command run against an app's droplet
+type Task struct {
+	GUID          string    `json:"guid"`
+	Name          string    `json:"name"`
+	AppGUID       string    `json:"app_guid"`
+	State         string    `json:"state"`
+	MemoryMB      int       `json:"memory_in_mb"`
+	DiskMB        int       `json:"disk_in_mb"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+}
+
+//Duration is how long a finished task ran for, from being created to its last update, and
+//0 for a task that hasn't finished
+func (task Task) Duration() time.Duration {
+	if task.State != TaskSucceeded && task.State != TaskFailed {
+		return 0
+	}
+	return task.UpdatedAt.Sub(task.CreatedAt)
+}
+
+//AppTaskSummary is what an app's tasks in the event window came to
+type AppTaskSummary struct {
+	AppGUID string `json:"app_guid"`
+	AppName string `json:"app_name"`
+	//States counts the tasks in each state
+	States map[string]int `json:"states"`
+	//MeanDuration and MaxDuration are over the finished tasks
+	MeanDuration time.Duration `json:"mean_duration"`
+	MaxDuration  time.Duration `json:"max_duration"`
+}
+
+//getTasks lists the tasks created in each space during the event window. a foundation
+//without the v3 api has no tasks
+func (client *Client) getTasks(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering tasks in spaces"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		defer bar.Incr()
+		resources, err := client.Resources(ctx, "/v3/tasks?space_guids="+spaces[index].GUID+client.createdWindowQuery())
+		if IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+			return
+		}
+		var tasks []Task
+		for _, resource := range resources {
+			entity, _ := resource.Entity.(map[string]interface{})
+			tasks = append(tasks, Task{
+				GUID:          resource.Metadata.GUID,
+				Name:          EntityString(resource, "name"),
+				AppGUID:       nestedString(entity, "relationships", "app", "data", "guid"),
+				State:         EntityString(resource, "state"),
+				MemoryMB:      EntityInt(resource, "memory_in_mb"),
+				DiskMB:        EntityInt(resource, "disk_in_mb"),
+				CreatedAt:     resource.Metadata.CreatedAt,
+				UpdatedAt:     resource.Metadata.UpdatedAt,
+				FailureReason: nestedString(entity, "result", "failure_reason"),
+			})
+		}
+		spaces[index].Tasks = tasks
+	})
+	return flattenFailures(perIndex)
+}
+
+//SummarizeTasks rolls a space's tasks up per app, sorted by app name. apps that have been
+//deleted since are named by their guid
+func SummarizeTasks(space Data) []AppTaskSummary {
+	names := map[string]string{}
+	for _, app := range space.Apps {
+		names[app.Metadata.GUID] = EntityString(app, "name")
+	}
+	summaries := map[string]*AppTaskSummary{}
+	finished := map[string]int{}
+	total := map[string]time.Duration{}
+	for _, task := range space.Tasks {
+		summary := summaries[task.AppGUID]
+		if summary == nil {
+			name := names[task.AppGUID]
+			if name == "" {
+				name = task.AppGUID
+			}
+			summary = &AppTaskSummary{AppGUID: task.AppGUID, AppName: name, States: map[string]int{}}
+			summaries[task.AppGUID] = summary
+		}
+		summary.States[task.State]++
+		if task.State == TaskSucceeded || task.State == TaskFailed {
+			finished[task.AppGUID]++
+			total[task.AppGUID] += task.Duration()
+			summary.MaxDuration = max(summary.MaxDuration, task.Duration())
+		}
+	}
+	var sorted []AppTaskSummary
+	for guid, summary := range summaries {
+		if finished[guid] > 0 {
+			summary.MeanDuration = total[guid] / time.Duration(finished[guid])
+		}
+		sorted = append(sorted, *summary)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].AppName < sorted[j].AppName
+	})
+	return sorted
+}
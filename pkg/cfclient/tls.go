@@ -0,0 +1,43 @@
+package cfclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+//tlsConfig builds the tls settings for api and uaa traffic. certificates are verified unless
+//skipVerify is set, against the system roots plus caCertPath when it's given. a client cert
+//and key are presented when both paths are set
+func tlsConfig(skipVerify bool, caCertPath string, clientCertPath string, clientKeyPath string) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: skipVerify}
+
+	if caCertPath != "" {
+		pem, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca cert %s: %s", caCertPath, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertPath)
+		}
+		config.RootCAs = pool
+	}
+
+	if (clientCertPath == "") != (clientKeyPath == "") {
+		return nil, fmt.Errorf("a client cert and a client key have to be given together")
+	}
+	if clientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client cert %s: %s", clientCertPath, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,63 @@
+package cfclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//cachedToken is what gets written to the token cache file between runs
+type cachedToken struct {
+	Target       string    `json:"target"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	//RefreshTokenInKeyring is set when Config.Keyring kept the refresh token there instead
+	RefreshTokenInKeyring bool `json:"refresh_token_in_keyring,omitempty"`
+}
+
+//tokenExpiryMargin is how long before expiry a cached token stops being worth reusing
+const tokenExpiryMargin = time.Minute
+
+//readTokenCache returns the cached token for target if there is one and it hasn't expired
+func readTokenCache(path string, target string) (*cachedToken, bool) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var token cachedToken
+	err = json.Unmarshal(raw, &token)
+	if err != nil {
+		return nil, false
+	}
+	if token.Target != target || time.Now().Add(tokenExpiryMargin).After(token.ExpiresAt) {
+		return nil, false
+	}
+	return &token, true
+}
+
+//writeTokenCache saves the token with owner only permissions since it's a credential
+func writeTokenCache(path string, token cachedToken) error {
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(path, raw)
+}
+
+//writePrivateFile writes raw to path readable by its owner only, for credentials
+func writePrivateFile(path string, raw []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	//OpenFile only applies the mode to new files, tighten up one that was already there
+	err = file.Chmod(0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(raw)
+	return err
+}
@@ -0,0 +1,128 @@
+package cfclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+//redactedQueryParams never show up in trace output
+var redactedQueryParams = []string{"access_token", "refresh_token", "client_secret", "password"}
+
+//redactedHeaders are the request and response headers traced as [REDACTED]
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+//privateKeys are the json keys whose values a traced body hides, matched lower cased anywhere in
+//the key, the way the cf cli's CF_TRACE hides them
+var privateKeys = []string{"token", "password", "secret", "credentials", "passcode", "private_key"}
+
+//traceRequest logs a request we just made when tracing is on. the Authorization header is
+//never logged and token/secret query params are redacted. with traceBodies the headers and
+//the response body are logged too, private values hidden, and the body put back so the
+//caller can still read it
+func (client *Client) traceRequest(req *http.Request, resp *http.Response, started time.Time, err error) {
+	if !client.trace {
+		return
+	}
+	duration := time.Since(started)
+	if err != nil {
+		client.traceLog.Printf("trace method=%s url=%s duration=%s error=%q", req.Method, redactURL(req.URL), duration, err)
+		return
+	}
+	client.traceLog.Printf("trace method=%s url=%s status=%d duration=%s", req.Method, redactURL(req.URL), resp.StatusCode, duration)
+
+	if client.traceBodies {
+		client.traceLog.Printf("trace url=%s request_headers=%s response_headers=%s", redactURL(req.URL), redactHeaders(req.Header), redactHeaders(resp.Header))
+		//a stream never ends, reading it here would hang the caller
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		body, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if readErr != nil {
+			client.traceLog.Printf("trace url=%s body_error=%q", redactURL(req.URL), readErr)
+			return
+		}
+		if req.URL.Path == "/oauth/token" {
+			body = []byte("[REDACTED]")
+		}
+		client.traceLog.Printf("trace url=%s body=%s", redactURL(req.URL), sanitizeBody(body))
+	}
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for _, param := range redactedQueryParams {
+		if query.Get(param) != "" {
+			query.Set(param, "[REDACTED]")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+//redactHeaders is headers as name=value pairs sorted by name, the redacted ones' values
+//[REDACTED]
+func redactHeaders(headers http.Header) string {
+	var pairs []string
+	for name, values := range headers {
+		value := strings.Join(values, ",")
+		for _, redacted := range redactedHeaders {
+			if strings.EqualFold(name, redacted) {
+				value = "[REDACTED]"
+			}
+		}
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return "[" + strings.Join(pairs, " ") + "]"
+}
+
+//sanitizeBody hides the values of private keys in a json body. anything that isn't json is
+//traced as it is
+func sanitizeBody(body []byte) []byte {
+	var parsed interface{}
+	if json.Unmarshal(body, &parsed) != nil {
+		return body
+	}
+	sanitized, err := json.Marshal(hidePrivate(parsed))
+	if err != nil {
+		return body
+	}
+	return sanitized
+}
+
+func hidePrivate(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range typed {
+			if privateKey(key) {
+				typed[key] = "[PRIVATE DATA HIDDEN]"
+				continue
+			}
+			typed[key] = hidePrivate(nested)
+		}
+	case []interface{}:
+		for index, nested := range typed {
+			typed[index] = hidePrivate(nested)
+		}
+	}
+	return value
+}
+
+func privateKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, private := range privateKeys {
+		if strings.Contains(key, private) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//tokenRequest builds the uaa token request. with a client id and secret of our own we use
+//the client_credentials grant, otherwise we refresh the user token the cf cli left us. either
+//way it's a form post, so the secret and refresh token stay out of the url where proxies and
+//access logs would keep them
+func (client *Client) tokenRequest(ctx context.Context) (*http.Request, error) {
+	form := url.Values{}
+	if client.clientCredentials {
+		form.Add("grant_type", "client_credentials")
+	} else {
+		form.Add("grant_type", "refresh_token")
+		form.Add("refresh_token", client.refreshToken)
+	}
+	form.Add("client_id", client.uaaClient)
+	form.Add("client_secret", client.uaaSecret)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", client.uaaURL.String()+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	return req, nil
+}
+
+//discoverUAA asks the api where uaa lives: the api root's links, or the token_endpoint of
+///v2/info on older foundations whose root doesn't have them. neither needs a token
+func (client *Client) discoverUAA(ctx context.Context) (*url.URL, error) {
+	var root struct {
+		Links map[string]*struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	rootErr := client.getUnauthenticated(ctx, "/", &root)
+	if rootErr == nil {
+		for _, key := range []string{"uaa", "login"} {
+			if link := root.Links[key]; link != nil && link.Href != "" {
+				return url.Parse(link.Href)
+			}
+		}
+		rootErr = fmt.Errorf("api root at %s doesn't link to uaa", client.apiURL)
+	}
+
+	var info struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	infoErr := client.getUnauthenticated(ctx, "/v2/info", &info)
+	if infoErr == nil && info.TokenEndpoint != "" {
+		return url.Parse(info.TokenEndpoint)
+	}
+	if infoErr == nil {
+		infoErr = fmt.Errorf("/v2/info at %s has no token_endpoint", client.apiURL)
+	}
+	return nil, fmt.Errorf("%s, and %s", rootErr, infoErr)
+}
+
+//getUnauthenticated decodes an api endpoint that doesn't need a token into into
+func (client *Client) getUnauthenticated(ctx context.Context, endpoint string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", client.apiURL.String()+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	client.limitBody(resp, endpoint)
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s at %s returned %d", endpoint, client.apiURL, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(into)
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %s", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package cfclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenRequestsAreFormPosts(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	for _, test := range []struct {
+		clientCredentials bool
+		want              map[string]string
+	}{
+		{true, map[string]string{"grant_type": "client_credentials", "client_id": "metrics", "client_secret": "secret"}},
+		{false, map[string]string{"grant_type": "refresh_token", "refresh_token": "refresh-me", "client_id": "metrics", "client_secret": "secret"}},
+	} {
+		client := newTestClient(t, server, Config{})
+		client.clientCredentials = test.clientCredentials
+		client.uaaClient, client.uaaSecret, client.refreshToken = "metrics", "secret", "refresh-me"
+
+		req, err := client.tokenRequest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Fatalf("%s: got a %s of %s, want a form post", test.want["grant_type"], req.Method, req.Header.Get("Content-Type"))
+		}
+		if req.URL.RawQuery != "" {
+			t.Fatalf("%s: the url has a query of %s, the grant belongs in the body", test.want["grant_type"], req.URL.RawQuery)
+		}
+		err = req.ParseForm()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range test.want {
+			if got := req.PostForm.Get(key); got != value {
+				t.Fatalf("%s: the body has %s=%s, want %s", test.want["grant_type"], key, got, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package cfclient
+
+//UsageReport is the app footprint of a space or org. memory and disk are in MB, same as the
+//memory and disk_quota fields on apps. the running totals only count started apps
+type UsageReport struct {
+	Apps             int `json:"apps"`
+	StartedApps      int `json:"started_apps"`
+	DesiredInstances int `json:"desired_instances"`
+	RunningInstances int `json:"running_instances"`
+	ReservedMemoryMB int `json:"reserved_memory_mb"`
+	ReservedDiskMB   int `json:"reserved_disk_mb"`
+	RunningMemoryMB  int `json:"running_memory_mb"`
+	RunningDiskMB    int `json:"running_disk_mb"`
+}
+
+func usageForApps(apps []Resource) UsageReport {
+	var report UsageReport
+	for _, app := range apps {
+		instances := EntityInt(app, "instances")
+		memory := instances * EntityInt(app, "memory")
+		disk := instances * EntityInt(app, "disk_quota")
+
+		report.Apps++
+		report.DesiredInstances += instances
+		report.ReservedMemoryMB += memory
+		report.ReservedDiskMB += disk
+		if EntityString(app, "state") == "STARTED" {
+			report.StartedApps++
+			report.RunningInstances += instances
+			report.RunningMemoryMB += memory
+			report.RunningDiskMB += disk
+		}
+	}
+	return report
+}
+
+func (report *UsageReport) add(other UsageReport) {
+	report.Apps += other.Apps
+	report.StartedApps += other.StartedApps
+	report.DesiredInstances += other.DesiredInstances
+	report.RunningInstances += other.RunningInstances
+	report.ReservedMemoryMB += other.ReservedMemoryMB
+	report.ReservedDiskMB += other.ReservedDiskMB
+	report.RunningMemoryMB += other.RunningMemoryMB
+	report.RunningDiskMB += other.RunningDiskMB
+}
+
+//attachUsageReports works out the usage of each space from its apps, and of each org by
+//summing up its spaces
+func attachUsageReports(orgs []Data, spaces []Data) {
+	orgUsage := map[string]*UsageReport{}
+	for index := range orgs {
+		orgs[index].Usage = UsageReport{}
+		orgUsage[orgs[index].GUID] = &orgs[index].Usage
+	}
+	for index, space := range spaces {
+		spaces[index].Usage = usageForApps(space.Apps)
+		if usage, exists := orgUsage[space.OrganizationGUID]; exists {
+			usage.add(spaces[index].Usage)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cfclient
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gosuri/uiprogress"
+)
+
+func TestUsageRollups(t *testing.T) {
+	orgs := []Data{{Name: "payments", GUID: "org-a"}, {Name: "search", GUID: "org-b"}}
+	spaces := []Data{
+		{
+			Name: "prod", OrganizationGUID: "org-a",
+			Apps: []Resource{
+				testApp("api", "STARTED", 3, 1024, 2048),
+				testApp("worker", "STARTED", 2, 512, 1024),
+				//stopped apps still reserve what they'd need, but don't count as running
+				testApp("batch", "STOPPED", 4, 256, 512),
+			},
+		},
+		{
+			Name: "dev", OrganizationGUID: "org-a",
+			Apps: []Resource{testApp("api", "STOPPED", 1, 2048, 1024)},
+		},
+		{Name: "empty", OrganizationGUID: "org-b"},
+	}
+	attachUsageReports(orgs, spaces)
+
+	for _, test := range []struct {
+		name string
+		got  UsageReport
+		want UsageReport
+	}{
+		{"prod space", spaces[0].Usage, UsageReport{
+			Apps:             3,
+			StartedApps:      2,
+			DesiredInstances: 3 + 2 + 4,
+			RunningInstances: 3 + 2,
+			ReservedMemoryMB: 3*1024 + 2*512 + 4*256,
+			ReservedDiskMB:   3*2048 + 2*1024 + 4*512,
+			RunningMemoryMB:  3*1024 + 2*512,
+			RunningDiskMB:    3*2048 + 2*1024,
+		}},
+		{"dev space", spaces[1].Usage, UsageReport{
+			Apps:             1,
+			DesiredInstances: 1,
+			ReservedMemoryMB: 2048,
+			ReservedDiskMB:   1024,
+		}},
+		{"org of both", orgs[0].Usage, UsageReport{
+			Apps:             4,
+			StartedApps:      2,
+			DesiredInstances: 3 + 2 + 4 + 1,
+			RunningInstances: 3 + 2,
+			ReservedMemoryMB: 3*1024 + 2*512 + 4*256 + 2048,
+			ReservedDiskMB:   3*2048 + 2*1024 + 4*512 + 1024,
+			RunningMemoryMB:  3*1024 + 2*512,
+			RunningDiskMB:    3*2048 + 2*1024,
+		}},
+		{"empty space", spaces[2].Usage, UsageReport{}},
+		{"org of an empty space", orgs[1].Usage, UsageReport{}},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %+v\nwant %+v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestV3AppsUseTheirWebProcess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/processes" || r.URL.Query().Get("types") != WebProcess || r.URL.Query().Get("organization_guids") != "org-a" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		web := func(app string, instances int, memoryMB int, diskMB int) map[string]interface{} {
+			return map[string]interface{}{
+				"guid": app + "-web", "type": WebProcess, "instances": instances, "memory_in_mb": memoryMB, "disk_in_mb": diskMB,
+				"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]interface{}{"guid": app + "-guid"}}},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pagination": map[string]interface{}{"total_results": 2, "total_pages": 1},
+			"resources":  []interface{}{web("api", 3, 1024, 2048), web("batch", 2, 256, 512)},
+		})
+	}))
+	defer server.Close()
+	client := newTestClient(t, server, Config{APIVersion: APIV3})
+	//Collect sets up the progress bars the collectors draw
+	client.progress = uiprogress.New()
+	client.progress.SetOut(ioutil.Discard)
+
+	//v3 apps are just a name and a state, the rest is the web process's
+	v3App := func(name string, state string) Resource {
+		return Resource{Metadata: ResourceMetadata{GUID: name + "-guid"}, Entity: map[string]interface{}{"name": name, "state": state}}
+	}
+	orgs := []Data{{Name: "payments", GUID: "org-a", Apps: []Resource{v3App("api", "STARTED"), v3App("batch", "STOPPED")}}}
+	spaces := []Data{{Name: "prod", OrganizationGUID: "org-a", Apps: []Resource{v3App("api", "STARTED"), v3App("batch", "STOPPED"), v3App("unpushed", "STOPPED")}}}
+	failures := client.foldWebProcesses(context.Background(), orgs, spaces)
+	if len(failures) > 0 {
+		t.Fatal(failures)
+	}
+	attachUsageReports(orgs, spaces)
+
+	want := UsageReport{
+		Apps:             3,
+		StartedApps:      1,
+		DesiredInstances: 3 + 2,
+		RunningInstances: 3,
+		ReservedMemoryMB: 3*1024 + 2*256,
+		ReservedDiskMB:   3*2048 + 2*512,
+		RunningMemoryMB:  3 * 1024,
+		RunningDiskMB:    3 * 2048,
+	}
+	if spaces[0].Usage != want {
+		t.Fatalf("got %+v\nwant %+v", spaces[0].Usage, want)
+	}
+	if got := EntityInt(orgs[0].Apps[0], "memory"); got != 1024 {
+		t.Fatalf("the org's copy of the app has %d MB of memory, want its web process's 1024", got)
+	}
+}
@@ -0,0 +1,148 @@
+package cfclient
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+)
+
+//UserProvidedService is a user provided service instance, `cf cups`. they aren't in the
+///v2/service_instances the services collector lists, and the ones with a syslog drain or a
+//route service send an app's logs or requests somewhere outside the foundation
+type UserProvidedService struct {
+	Name      string `json:"name"`
+	GUID      string `json:"guid"`
+	SpaceGUID string `json:"space_guid"`
+	//SyslogDrainURL and RouteServiceURL are kept without their credentials and query, see
+	//withoutCredentials
+	SyslogDrainURL  string           `json:"syslog_drain_url,omitempty"`
+	RouteServiceURL string           `json:"route_service_url,omitempty"`
+	AppBindings     []ServiceBinding `json:"app_bindings"`
+	//RouteApps are the apps mapped to the routes bound to a route service, so every request
+	//to them goes through it
+	RouteApps []string  `json:"route_apps,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//UserProvidedSummary counts a space's user provided services and the apps they take logs or
+//requests from
+type UserProvidedSummary struct {
+	Instances     int `json:"instances"`
+	SyslogDrains  int `json:"syslog_drains"`
+	RouteServices int `json:"route_services"`
+	//DrainedApps and RouteServiceApps are distinct apps, one bound to two drains counts once
+	DrainedApps      int `json:"drained_apps"`
+	RouteServiceApps int `json:"route_service_apps"`
+}
+
+//SummarizeUserProvided counts up a space's user provided services, it makes no api calls
+func SummarizeUserProvided(space Data) UserProvidedSummary {
+	summary := UserProvidedSummary{Instances: len(space.UserProvidedServices)}
+	drained := map[string]bool{}
+	routed := map[string]bool{}
+	for _, instance := range space.UserProvidedServices {
+		if instance.SyslogDrainURL != "" {
+			summary.SyslogDrains++
+			for _, binding := range instance.AppBindings {
+				drained[binding.AppGUID] = true
+			}
+		}
+		if instance.RouteServiceURL != "" {
+			summary.RouteServices++
+			for _, app := range instance.RouteApps {
+				routed[app] = true
+			}
+		}
+	}
+	summary.DrainedApps, summary.RouteServiceApps = len(drained), len(routed)
+	return summary
+}
+
+//withoutCredentials drops the user info and query from a drain or route service url, which
+//is where the token of a log vendor usually goes. one that doesn't parse is [REDACTED] whole
+func withoutCredentials(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	parsed.User, parsed.RawQuery, parsed.Fragment = nil, "", ""
+	return parsed.String()
+}
+
+//getUserProvidedServices pulls every user provided service instance in each space with the
+//apps bound to it, and for route services the apps behind the routes bound to them
+func (client *Client) getUserProvidedServices(ctx context.Context, spaces []Data) []CollectionError {
+	whatYoureDoing := "gathering user provided services"
+	for len(whatYoureDoing) < 36 {
+		whatYoureDoing = whatYoureDoing + " "
+	}
+	bar := client.progress.AddBar(len(spaces)).AppendCompleted().PrependElapsed().PrependFunc(func(b *uiprogress.Bar) string {
+		return whatYoureDoing
+	})
+
+	perIndex := make([][]CollectionError, len(spaces))
+	client.forEachConcurrently(ctx, len(spaces), func(index int) {
+		instances, err := client.getSpaceUserProvidedServices(ctx, spaces[index])
+		if err != nil {
+			perIndex[index] = append(perIndex[index], newCollectionError(spaces[index], whatYoureDoing, err))
+		} else {
+			spaces[index].UserProvidedServices = instances
+		}
+		bar.Incr()
+	})
+	return flattenFailures(perIndex)
+}
+
+func (client *Client) getSpaceUserProvidedServices(ctx context.Context, space Data) ([]UserProvidedService, error) {
+	resources, err := client.Resources(ctx, "/v2/user_provided_service_instances?q=space_guid:"+space.GUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []UserProvidedService
+	for _, resource := range resources {
+		instance := UserProvidedService{
+			Name:            EntityString(resource, "name"),
+			GUID:            resource.Metadata.GUID,
+			SpaceGUID:       space.GUID,
+			SyslogDrainURL:  withoutCredentials(EntityString(resource, "syslog_drain_url")),
+			RouteServiceURL: withoutCredentials(EntityString(resource, "route_service_url")),
+			CreatedAt:       resource.Metadata.CreatedAt,
+		}
+		bindings, err := client.Resources(ctx, "/v2/user_provided_service_instances/"+instance.GUID+"/service_bindings")
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range bindings {
+			instance.AppBindings = append(instance.AppBindings, ServiceBinding{
+				GUID:      binding.Metadata.GUID,
+				AppGUID:   EntityString(binding, "app_guid"),
+				CreatedAt: binding.Metadata.CreatedAt,
+			})
+		}
+
+		//only a route service has routes bound to it
+		if instance.RouteServiceURL != "" {
+			routes, err := client.Resources(ctx, "/v2/user_provided_service_instances/"+instance.GUID+"/routes")
+			if err != nil {
+				return nil, err
+			}
+			for _, route := range routes {
+				apps, err := client.Resources(ctx, "/v2/routes/"+route.Metadata.GUID+"/apps")
+				if err != nil {
+					return nil, err
+				}
+				for _, app := range apps {
+					instance.RouteApps = append(instance.RouteApps, app.Metadata.GUID)
+				}
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
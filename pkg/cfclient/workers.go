@@ -0,0 +1,58 @@
+package cfclient
+
+import (
+	"context"
+	"sync"
+)
+
+//forEachConcurrently calls fn with every index below count from at most client.concurrency
+//goroutines at once and waits for all of them. fn must only touch its own index of whatever
+//it's filling in. once ctx is done no more indexes are handed out. a panic in fn is passed on
+//to the caller once the rest are done, where the collector running it can recover it
+func (client *Client) forEachConcurrently(ctx context.Context, count int, fn func(index int)) {
+	workers := client.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var panicked sync.Once
+	var recovered interface{}
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							panicked.Do(func() { recovered = r })
+						}
+					}()
+					fn(index)
+				}()
+			}
+		}()
+	}
+	for index := 0; index < count && ctx.Err() == nil; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	if recovered != nil {
+		panic(recovered)
+	}
+}
+
+//flattenFailures joins per org/space failures back together in list order
+func flattenFailures(perIndex [][]CollectionError) []CollectionError {
+	var failures []CollectionError
+	for _, indexFailures := range perIndex {
+		failures = append(failures, indexFailures...)
+	}
+	return failures
+}
@@ -0,0 +1,519 @@
+//Package fakecapi is a stand in cloud controller, and the uaa in front of it, for developing
+//sinks and dashboards without a foundation. it serves the v2 api over a made up foundation,
+//paginated and filtered the way the real one is, hands out tokens that expire and answers
+//429 once a rate limit runs out. it's an http.Handler, so httptest.NewServer(fakecapi.New(...))
+//is a cloud controller for a test, and cf-metrics fake-server runs one on a port
+package fakecapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Options size the made up foundation and say how strict the server is
+type Options struct {
+	Orgs         int
+	SpacesPerOrg int
+	AppsPerSpace int
+	EventsPerApp int
+	//TokenTTL is how long the tokens it hands out last, 10m when it's 0
+	TokenTTL time.Duration
+	//RateLimit is how many api requests are let through every RateLimitWindow before the rest
+	//get 429s, 0 for no limit
+	RateLimit       int
+	RateLimitWindow time.Duration
+	//Now is when the foundation's events run up to, time.Now() when it's zero
+	Now time.Time
+}
+
+//Resource is a v2 resource, the way the cloud controller lists them and fixtures hold them
+type Resource struct {
+	Metadata Metadata               `json:"metadata"`
+	Entity   map[string]interface{} `json:"entity"`
+}
+
+//Metadata is a v2 resource's metadata
+type Metadata struct {
+	GUID      string    `json:"guid"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+//Server is the fake cloud controller and uaa
+type Server struct {
+	options Options
+	mutex   sync.Mutex
+	//collections are the resources of every /v2/<collection>
+	collections map[string][]Resource
+	//windowStart and requests are the current rate limit window and what's been asked in it
+	windowStart time.Time
+	requests    int
+	tokens      int
+}
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 100
+	apiVersion     = "2.250.0"
+)
+
+//New makes a server with a foundation sized by options. the same options always make the
+//same foundation, guids and all, apart from the event timestamps following Now
+func New(options Options) *Server {
+	if options.TokenTTL <= 0 {
+		options.TokenTTL = 10 * time.Minute
+	}
+	if options.RateLimitWindow <= 0 {
+		options.RateLimitWindow = time.Hour
+	}
+	if options.Now.IsZero() {
+		options.Now = time.Now()
+	}
+	return &Server{options: options, collections: generate(options)}
+}
+
+//Collections is the name of every collection the server has
+func (server *Server) Collections() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	var names []string
+	for name := range server.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//LoadFixtures replaces collections with the ones in dir, a <collection>.json array of
+//resources each, like WriteFixtures writes. the collections without a file are left alone
+func (server *Server) LoadFixtures(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("there are no .json fixtures in %s", dir)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var resources []Resource
+		err = json.Unmarshal(content, &resources)
+		if err != nil {
+			return fmt.Errorf("invalid fixture %s: %s", path, err)
+		}
+		server.collections[strings.TrimSuffix(filepath.Base(path), ".json")] = resources
+	}
+	return nil
+}
+
+//WriteFixtures writes every collection to dir as <collection>.json, to edit and load back
+func (server *Server) WriteFixtures(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for name, resources := range server.collections {
+		content, err := json.MarshalIndent(resources, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name+".json"), append(content, '\n'), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == "":
+		link := func(href string) map[string]interface{} { return map[string]interface{}{"href": href} }
+		writeJSON(w, http.StatusOK, map[string]interface{}{"links": map[string]interface{}{
+			"self":                link(base),
+			"cloud_controller_v2": map[string]interface{}{"href": base + "/v2", "meta": map[string]string{"version": apiVersion}},
+			"uaa":                 link(base),
+			"login":               link(base),
+		}})
+	case r.URL.Path == "/v2/info":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":                   "fakecapi",
+			"build":                  "fake",
+			"description":            "a fake cloud controller",
+			"version":                0,
+			"api_version":            apiVersion,
+			"min_cli_version":        nil,
+			"authorization_endpoint": base,
+			"token_endpoint":         base,
+		})
+	case r.URL.Path == "/oauth/token":
+		server.serveToken(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v2/"):
+		if !server.authorized(w, r) || !server.allowed(w) {
+			return
+		}
+		server.serveV2(w, r, base)
+	default:
+		//no v3, the client falls back to v2 for whatever it would ask v3 for
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []map[string]interface{}{{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request"}}})
+	}
+}
+
+//serveToken is uaa's token endpoint. any client and any grant gets a token, a refresh
+//token gets a new one
+func (server *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	grant := r.Form.Get("grant_type")
+	if grant == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "a grant_type is required"})
+		return
+	}
+	server.mutex.Lock()
+	server.tokens++
+	issued := server.tokens
+	server.mutex.Unlock()
+	expires := time.Now().Add(server.options.TokenTTL)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken(issued, expires),
+		"token_type":    "bearer",
+		"refresh_token": "fakecapi-refresh-token",
+		"expires_in":    int(server.options.TokenTTL.Seconds()),
+		"scope":         "cloud_controller.admin cloud_controller.read",
+		"jti":           fmt.Sprintf("fakecapi-%d", issued),
+	})
+}
+
+//accessToken is an unsigned jwt with the claims the client looks at
+func accessToken(issued int, expires time.Time) string {
+	encode := func(value interface{}) string {
+		content, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+	claims := map[string]interface{}{"jti": fmt.Sprintf("fakecapi-%d", issued), "iss": "fakecapi", "exp": expires.Unix(), "scope": []string{"cloud_controller.admin"}}
+	return encode(map[string]string{"alg": "none", "typ": "JWT"}) + "." + encode(claims) + ".fakecapi"
+}
+
+//authorized checks the request has a token of ours that hasn't expired, answering 401 the
+//way the cloud controller does when it hasn't
+func (server *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "), "Bearer ")
+	parts := strings.Split(token, ".")
+	var claims struct {
+		Issuer string `json:"iss"`
+		Exp    int64  `json:"exp"`
+	}
+	if len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err == nil {
+			json.Unmarshal(payload, &claims)
+		}
+	}
+	if claims.Issuer != "fakecapi" {
+		writeV2Error(w, http.StatusUnauthorized, 1000, "CF-InvalidAuthToken", "Invalid Auth Token")
+		return false
+	}
+	if time.Now().Unix() >= claims.Exp {
+		writeV2Error(w, http.StatusUnauthorized, 1000, "CF-InvalidAuthToken", "Invalid Auth Token: the token has expired")
+		return false
+	}
+	return true
+}
+
+//allowed counts the request against the rate limit and sets the X-RateLimit headers,
+//answering 429 with a Retry-After once the window's requests are used up
+func (server *Server) allowed(w http.ResponseWriter) bool {
+	if server.options.RateLimit <= 0 {
+		return true
+	}
+	server.mutex.Lock()
+	now := time.Now()
+	if now.Sub(server.windowStart) >= server.options.RateLimitWindow {
+		server.windowStart, server.requests = now, 0
+	}
+	reset := server.windowStart.Add(server.options.RateLimitWindow)
+	server.requests++
+	remaining := server.options.RateLimit - server.requests
+	server.mutex.Unlock()
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(server.options.RateLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if remaining < 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+		writeV2Error(w, http.StatusTooManyRequests, 10013, "CF-RateLimitExceeded", "Rate Limit Exceeded")
+		return false
+	}
+	return true
+}
+
+//serveV2 is /v2/<collection>, /v2/<collection>/<guid>, /v2/<parent>/<guid>/<collection> and
+///v2/apps/<guid>/stats
+func (server *Server) serveV2(w http.ResponseWriter, r *http.Request, base string) {
+	if r.Method != http.MethodGet {
+		writeV2Error(w, http.StatusMethodNotAllowed, 10000, "CF-NotAuthorized", "the fake cloud controller is read only")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/"), "/"), "/")
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	switch {
+	case r.URL.Path == "/v2/config/feature_flags":
+		//a bare array, not a paged list
+		var flags []map[string]interface{}
+		for _, name := range featureFlags {
+			flags = append(flags, map[string]interface{}{"name": name, "enabled": name != "diego_docker", "error_message": nil, "url": "/v2/config/feature_flags/" + name})
+		}
+		writeJSON(w, http.StatusOK, flags)
+	case len(parts) == 1:
+		server.serveList(w, r, base, parts[0], server.collections[parts[0]])
+	case len(parts) == 2:
+		for _, resource := range server.collections[parts[0]] {
+			if resource.Metadata.GUID == parts[1] {
+				writeJSON(w, http.StatusOK, resource)
+				return
+			}
+		}
+		writeV2Error(w, http.StatusNotFound, 10000, "CF-NotFound", "Unknown request")
+	case len(parts) == 3 && parts[0] == "apps" && parts[2] == "stats":
+		for _, app := range server.collections["apps"] {
+			if app.Metadata.GUID == parts[1] {
+				server.serveStats(w, app)
+				return
+			}
+		}
+		writeV2Error(w, http.StatusNotFound, 100004, "CF-AppNotFound", "The app could not be found: "+parts[1])
+	case len(parts) == 3:
+		//the children are the ones with the parent's guid, e.g. a space's routes have its
+		//space_guid
+		key := strings.TrimSuffix(parts[0], "s")
+		if parts[0] == "quota_definitions" {
+			key = "quota_definition"
+		}
+		var children []Resource
+		for _, child := range server.collections[parts[2]] {
+			if child.Entity[key+"_guid"] == parts[1] || containsString(child.Entity[key+"_guids"], parts[1]) {
+				children = append(children, child)
+			}
+		}
+		server.serveList(w, r, base, parts[2], children)
+	default:
+		writeV2Error(w, http.StatusNotFound, 10000, "CF-NotFound", "Unknown request")
+	}
+}
+
+//serveList pages through resources the q filters match, results-per-page at a time
+func (server *Server) serveList(w http.ResponseWriter, r *http.Request, base string, collection string, resources []Resource) {
+	query := r.URL.Query()
+	perPage := defaultPerPage
+	if value := query.Get("results-per-page"); value != "" {
+		var err error
+		perPage, err = strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			writeV2Error(w, http.StatusBadRequest, 1001, "CF-MessageParseError", fmt.Sprintf("results-per-page must be between 1 and %d", maxPerPage))
+			return
+		}
+	}
+	page := 1
+	if value := query.Get("page"); value != "" {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			writeV2Error(w, http.StatusBadRequest, 1001, "CF-MessageParseError", "page must be 1 or more")
+			return
+		}
+	}
+
+	//apps and the rest of what's in a space filter on their space's org, like the cloud
+	//controller's do
+	spaceOrgs := map[string]string{}
+	for _, space := range server.collections["spaces"] {
+		spaceOrgs[space.Metadata.GUID], _ = space.Entity["organization_guid"].(string)
+	}
+	var matched []Resource
+	for _, resource := range resources {
+		if matches(resource, query["q"], spaceOrgs) {
+			matched = append(matched, resource)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Metadata.CreatedAt.Before(matched[j].Metadata.CreatedAt)
+	})
+	if query.Get("order-direction") == "desc" {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	totalPages := (len(matched) + perPage - 1) / perPage
+	pageURL := func(number int) interface{} {
+		if number < 1 || number > totalPages {
+			return nil
+		}
+		linked := url.Values{}
+		for key, values := range query {
+			linked[key] = values
+		}
+		linked.Set("page", strconv.Itoa(number))
+		linked.Set("results-per-page", strconv.Itoa(perPage))
+		return r.URL.Path + "?" + linked.Encode()
+	}
+	start := min((page-1)*perPage, len(matched))
+	end := min(start+perPage, len(matched))
+	listed := make([]Resource, 0, end-start)
+	for _, resource := range matched[start:end] {
+		resource.Metadata.URL = "/v2/" + collection + "/" + resource.Metadata.GUID
+		listed = append(listed, resource)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total_results": len(matched),
+		"total_pages":   totalPages,
+		"prev_url":      pageURL(page - 1),
+		"next_url":      pageURL(page + 1),
+		"resources":     listed,
+	})
+}
+
+//featureFlags are the feature flags the foundation has, all on but docker
+var featureFlags = []string{"app_bits_upload", "app_scaling", "diego_docker", "route_creation", "service_instance_creation", "set_roles_by_username", "task_creation", "user_org_creation"}
+
+//qOperators are the filter operators, longest first so >= isn't read as >
+var qOperators = []string{">=", "<=", " IN ", ":", ">", "<"}
+
+//matches is whether a resource passes every q filter: field:value, field IN a,b or field
+//compared with >=, <=, > or <. timestamps compare as the rfc3339 strings they are, and a
+//resource in a space without an org of its own is in spaceOrgs' org of it
+func matches(resource Resource, filters []string, spaceOrgs map[string]string) bool {
+	for _, filter := range filters {
+		at, operator := -1, ""
+		for _, candidate := range qOperators {
+			index := strings.Index(filter, candidate)
+			if index >= 0 && (at < 0 || index < at) {
+				at, operator = index, candidate
+			}
+		}
+		if at < 0 {
+			continue
+		}
+		field, want := filter[:at], filter[at+len(operator):]
+		have := fmt.Sprint(resource.Entity[field])
+		switch field {
+		case "guid":
+			have = resource.Metadata.GUID
+		case "created_at":
+			have = resource.Metadata.CreatedAt.UTC().Format(time.RFC3339)
+		case "organization_guid":
+			if spaceGUID, inSpace := resource.Entity["space_guid"].(string); inSpace && resource.Entity[field] == nil {
+				have = spaceOrgs[spaceGUID]
+			}
+		}
+		var passes bool
+		switch operator {
+		case ":":
+			passes = have == want
+		case " IN ":
+			passes = contains(strings.Split(want, ","), have)
+		case ">=":
+			passes = have >= want
+		case "<=":
+			passes = have <= want
+		case ">":
+			passes = have > want
+		case "<":
+			passes = have < want
+		}
+		if !passes {
+			return false
+		}
+	}
+	return true
+}
+
+//serveStats is an app's instance stats, running instances using some of their quota. a
+//stopped app has none
+func (server *Server) serveStats(w http.ResponseWriter, app Resource) {
+	stats := map[string]interface{}{}
+	if app.Entity["state"] == "STARTED" {
+		memoryMB, diskMB := number(app.Entity["memory"]), number(app.Entity["disk_quota"])
+		instances := number(app.Entity["instances"])
+		seed := hash(app.Metadata.GUID)
+		for index := 0; index < int(instances); index++ {
+			share := float64((seed+uint32(index)*7919)%60+20) / 100
+			stats[strconv.Itoa(index)] = map[string]interface{}{
+				"state": "RUNNING",
+				"stats": map[string]interface{}{
+					"name":       app.Entity["name"],
+					"uptime":     3600 * (index + 1),
+					"mem_quota":  int64(memoryMB) * 1024 * 1024,
+					"disk_quota": int64(diskMB) * 1024 * 1024,
+					"usage": map[string]interface{}{
+						"time": time.Now().UTC().Format(time.RFC3339),
+						"cpu":  share / 4,
+						"mem":  int64(share * memoryMB * 1024 * 1024),
+						"disk": int64(share / 2 * diskMB * 1024 * 1024),
+					},
+				},
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+}
+
+func writeV2Error(w http.ResponseWriter, status int, code int, errorCode string, description string) {
+	writeJSON(w, status, map[string]interface{}{"code": code, "description": description, "error_code": errorCode})
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+//containsString is whether a list in an entity, which has come out of json when it's a
+//fixture, has value
+func containsString(list interface{}, value string) bool {
+	switch typed := list.(type) {
+	case []string:
+		return contains(typed, value)
+	case []interface{}:
+		for _, candidate := range typed {
+			if candidate == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package fakecapi
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+var (
+	orgNames   = []string{"acme", "globex", "initech", "umbrella", "hooli", "wonka", "stark", "tyrell"}
+	spaceNames = []string{"dev", "test", "staging", "prod", "sandbox"}
+	appNames   = []string{"web", "api", "worker", "billing", "search", "auth", "reports", "gateway"}
+	buildpacks = []string{"java_buildpack", "nodejs_buildpack", "go_buildpack", "python_buildpack", "staticfile_buildpack"}
+	appMemory  = []int{256, 512, 1024, 2048}
+	//appEvents are the app event types the events of each app cycle through
+	appEvents = []string{"audit.app.create", "audit.app.update", "audit.app.start", "audit.app.restage", "app.crash", "audit.app.stop", "audit.app.ssh-authorized", "audit.app.delete-request"}
+)
+
+//generate makes up a foundation: the stacks, buildpacks, quotas, domains and services every
+//foundation has and options' orgs, spaces, apps, routes, service instances and events
+func generate(options Options) map[string][]Resource {
+	//everything but the events was created about a year ago, an hour apart
+	created := time.Date(options.Now.Year()-1, options.Now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	count := 0
+	newResource := func(kind string, name string, entity map[string]interface{}) Resource {
+		count++
+		at := created.Add(time.Duration(count) * time.Hour)
+		return Resource{Metadata: Metadata{GUID: guid(kind, name), CreatedAt: at, UpdatedAt: at}, Entity: entity}
+	}
+	collections := map[string][]Resource{}
+	add := func(collection string, resource Resource) Resource {
+		collections[collection] = append(collections[collection], resource)
+		return resource
+	}
+
+	stacks := map[string]string{}
+	for _, name := range []string{"cflinuxfs3", "cflinuxfs4"} {
+		stack := add("stacks", newResource("stack", name, map[string]interface{}{"name": name, "description": "Cloud Foundry Linux-based filesystem"}))
+		stacks[name] = stack.Metadata.GUID
+	}
+	for position, name := range buildpacks {
+		add("buildpacks", newResource("buildpack", name, map[string]interface{}{"name": name, "stack": "cflinuxfs4", "position": position + 1, "enabled": true, "locked": false, "filename": name + "-cflinuxfs4-v1.0.0.zip"}))
+	}
+	quota := add("quota_definitions", newResource("quota", "default", map[string]interface{}{"name": "default", "non_basic_services_allowed": true, "total_services": 100, "total_routes": 1000, "memory_limit": 102400, "instance_memory_limit": -1, "app_instance_limit": -1}))
+	domain := add("shared_domains", newResource("domain", "apps.fake.example.com", map[string]interface{}{"name": "apps.fake.example.com", "internal": false}))
+	broker := add("service_brokers", newResource("broker", "fake-broker", map[string]interface{}{"name": "fake-broker", "broker_url": "https://broker.fake.example.com"}))
+	var plans []Resource
+	for _, label := range []string{"fake-db", "fake-cache"} {
+		service := add("services", newResource("service", label, map[string]interface{}{"label": label, "description": "a fake " + label, "active": true, "bindable": true, "service_broker_guid": broker.Metadata.GUID}))
+		for _, size := range []string{"small", "large"} {
+			plans = append(plans, add("service_plans", newResource("plan", label+size, map[string]interface{}{"name": size, "free": size == "small", "public": true, "active": true, "service_guid": service.Metadata.GUID, "unique_id": label + "-" + size})))
+		}
+	}
+
+	for orgIndex := 0; orgIndex < options.Orgs; orgIndex++ {
+		orgName := pick(orgNames, orgIndex)
+		org := add("organizations", newResource("org", orgName, map[string]interface{}{"name": orgName, "status": "active", "billing_enabled": false, "quota_definition_guid": quota.Metadata.GUID}))
+		for spaceIndex := 0; spaceIndex < options.SpacesPerOrg; spaceIndex++ {
+			spaceName := pick(spaceNames, spaceIndex)
+			space := add("spaces", newResource("space", orgName+"/"+spaceName, map[string]interface{}{"name": spaceName, "organization_guid": org.Metadata.GUID, "allow_ssh": true}))
+			spaceEntity := func(entity map[string]interface{}) map[string]interface{} {
+				entity["space_guid"] = space.Metadata.GUID
+				return entity
+			}
+			for instanceIndex := 0; instanceIndex < (spaceIndex%2)+1; instanceIndex++ {
+				plan := plans[(orgIndex+spaceIndex+instanceIndex)%len(plans)]
+				name := fmt.Sprintf("%s-%s-%d", spaceName, plan.Entity["unique_id"], instanceIndex+1)
+				add("service_instances", newResource("service_instance", orgName+"/"+name, spaceEntity(map[string]interface{}{
+					"name":              name,
+					"type":              "managed_service_instance",
+					"service_plan_guid": plan.Metadata.GUID,
+					"service_guid":      plan.Entity["service_guid"],
+					"last_operation":    map[string]interface{}{"type": "create", "state": "succeeded", "updated_at": created.Format(time.RFC3339)},
+				})))
+			}
+			for appIndex := 0; appIndex < options.AppsPerSpace; appIndex++ {
+				appName := pick(appNames, appIndex)
+				//most apps are started, and the older ones on cflinuxfs3
+				state := "STARTED"
+				if (orgIndex+spaceIndex+appIndex)%5 == 4 {
+					state = "STOPPED"
+				}
+				stack := "cflinuxfs4"
+				if appIndex%3 == 2 {
+					stack = "cflinuxfs3"
+				}
+				app := add("apps", newResource("app", orgName+"/"+spaceName+"/"+appName, spaceEntity(map[string]interface{}{
+					"name":               appName,
+					"state":              state,
+					"instances":          appIndex%4 + 1,
+					"memory":             appMemory[(spaceIndex+appIndex)%len(appMemory)],
+					"disk_quota":         1024,
+					"buildpack":          nil,
+					"detected_buildpack": buildpacks[(orgIndex+appIndex)%len(buildpacks)],
+					"stack_guid":         stacks[stack],
+					"health_check_type":  "port",
+					"package_state":      "STAGED",
+					"package_updated_at": created.Format(time.RFC3339),
+					"diego":              true,
+				})))
+				host := appName + "-" + spaceName + "-" + orgName
+				add("routes", newResource("route", host, spaceEntity(map[string]interface{}{"host": host, "path": "", "domain_guid": domain.Metadata.GUID, "app_guids": []string{app.Metadata.GUID}})))
+				//a space's events are spread evenly over the last week
+				step := 7 * 24 * time.Hour / time.Duration(options.EventsPerApp*options.AppsPerSpace+1)
+				for eventIndex := 0; eventIndex < options.EventsPerApp; eventIndex++ {
+					at := options.Now.UTC().Add(-time.Duration(eventIndex*options.AppsPerSpace+appIndex+1) * step).Truncate(time.Second)
+					eventType := appEvents[(eventIndex+appIndex)%len(appEvents)]
+					event := newResource("event", fmt.Sprintf("%s/%d", app.Metadata.GUID, eventIndex), spaceEntity(map[string]interface{}{
+						"type":              eventType,
+						"actor":             guid("user", orgName),
+						"actor_type":        "user",
+						"actor_name":        "developer@" + orgName + ".example.com",
+						"actee":             app.Metadata.GUID,
+						"actee_type":        "app",
+						"actee_name":        appName,
+						"timestamp":         at.Format(time.RFC3339),
+						"organization_guid": org.Metadata.GUID,
+						"metadata":          map[string]interface{}{},
+					}))
+					event.Metadata.CreatedAt, event.Metadata.UpdatedAt = at, at
+					add("events", event)
+				}
+			}
+		}
+	}
+	return collections
+}
+
+//pick is the index'th name, numbered once the names run out
+func pick(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return fmt.Sprintf("%s-%d", names[index%len(names)], index/len(names)+1)
+}
+
+//guid is a made up guid for the kind of resource named name, the same one every time
+func guid(kind string, name string) string {
+	sum := fnv.New128a()
+	sum.Write([]byte(kind + "/" + name))
+	bytes := sum.Sum(nil)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bytes[0:4], bytes[4:6], bytes[6:8], bytes[8:10], bytes[10:16])
+}
+
+func hash(value string) uint32 {
+	sum := fnv.New32a()
+	sum.Write([]byte(value))
+	return sum.Sum32()
+}
+
+//number is an entity's number, an int when it's generated and a float64 out of a fixture
+func number(value interface{}) float64 {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed)
+	case float64:
+		return typed
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+//Package snapshot is the json cf-metrics writes about a run: the Report collect -output json
+//prints, the Timed reports the api, grpc stream and message sinks (kafka, nats, s3...) send, and
+//the Records the -history store keeps a line each of. every one has a schema_version.
+//
+//within a version fields are only ever added, so a consumer should ignore the ones it doesn't
+//know. renaming, removing or changing what a field means takes a new SchemaVersion and a
+//migration from the one before, which Decode applies to anything written by an older
+//cf-metrics, so a history store or an archived report always reads as the current version
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//SchemaVersion is the version of everything written now
+const SchemaVersion = 3
+
+//migrations take a document from the version it was written in to the next one, the first
+//from version 1 to 2. there's one for every version before SchemaVersion
+var migrations = []func(document map[string]interface{}){
+	//version 1 is everything written before there was a schema_version, it has the same fields
+	func(document map[string]interface{}) {},
+	//version 2 records have every app every time, version 3 ones have every app once a day and
+	//the apps that crashed in crashed_apps
+	func(document map[string]interface{}) {
+		apps, _ := document["apps"].([]interface{})
+		var crashed []interface{}
+		for _, app := range apps {
+			if fields, isApp := app.(map[string]interface{}); isApp && fields["new_crashes"] != nil {
+				crashed = append(crashed, app)
+			}
+		}
+		if crashed != nil {
+			document["crashed_apps"] = crashed
+		}
+	},
+}
+
+//Report is the end of run rollup, one entry per org and space
+type Report struct {
+	SchemaVersion int `json:"schema_version"`
+	//Name is the foundation the run was against
+	Name       string                     `json:"name"`
+	Foundation cfclient.FoundationSummary `json:"foundation"`
+	Orgs       []Row                      `json:"orgs"`
+	Spaces     []Row                      `json:"spaces"`
+	Failures   []string                   `json:"failures"`
+	//Collectors are the parts of a run that were done, see -collectors
+	Collectors []string `json:"collectors"`
+	//CollectorFailures are how many failures each of them had
+	CollectorFailures map[string]int `json:"collector_failures"`
+	//CollectorForbidden are what each of them wasn't authorized to read in a -scoped run
+	CollectorForbidden map[string]int `json:"collector_forbidden,omitempty"`
+}
+
+//Timed is the rollup with when the run was, for the sinks that publish it as a message
+type Timed struct {
+	Timestamp time.Time `json:"timestamp"`
+	Report
+}
+
+//Row is an org's or a space's totals. Space is empty for an org
+type Row struct {
+	Org              string         `json:"org"`
+	Space            string         `json:"space,omitempty"`
+	GUID             string         `json:"guid"`
+	Apps             int            `json:"apps"`
+	Instances        int            `json:"instances"`
+	ReservedMemoryMB int            `json:"reserved_memory_mb"`
+	ServiceInstances int            `json:"service_instances"`
+	Routes           int            `json:"routes"`
+	Events           map[string]int `json:"events"`
+}
+
+//Record is what the history store keeps of one run against one foundation: the org and
+//space counts, the apps that crashed, and every app in the first record of each day
+type Record struct {
+	SchemaVersion int       `json:"schema_version"`
+	Foundation    string    `json:"foundation"`
+	Timestamp     time.Time `json:"timestamp"`
+	Orgs          []Row     `json:"orgs"`
+	Spaces        []Row     `json:"spaces"`
+	//Apps is every app, only in the foundation's first record of each utc day so the store
+	//doesn't keep the whole foundation's apps every run
+	Apps []App `json:"apps,omitempty"`
+	//CrashedApps are the apps with NewCrashes, in every record
+	CrashedApps []App `json:"crashed_apps,omitempty"`
+	//AppsAdded, AppsRemoved and NewEvents are against the foundation's previous record,
+	//0 for the first one
+	AppsAdded   int `json:"apps_added"`
+	AppsRemoved int `json:"apps_removed"`
+	NewEvents   int `json:"new_events"`
+	//NewEventTypes are the new events by type, for report growth
+	NewEventTypes map[string]int `json:"new_event_types,omitempty"`
+	//RunningMemoryMB and RunningDiskMB are what the started apps reserve on the cells, the
+	//trend report headroom projects
+	RunningMemoryMB int `json:"running_memory_mb,omitempty"`
+	RunningDiskMB   int `json:"running_disk_mb,omitempty"`
+}
+
+//App is an app as of a Record
+type App struct {
+	GUID  string `json:"guid"`
+	Name  string `json:"name"`
+	Org   string `json:"org"`
+	Space string `json:"space"`
+	//NewCrashes is the app's crash events since the previous record, the crash history
+	//app.crash_anomaly alerts are measured against
+	NewCrashes int `json:"new_crashes,omitempty"`
+}
+
+//Totals adds up a record's orgs
+func (record Record) Totals() (apps int, instances int, memory int) {
+	for _, org := range record.Orgs {
+		apps += org.Apps
+		instances += org.Instances
+		memory += org.ReservedMemoryMB
+	}
+	return apps, instances, memory
+}
+
+//Decode reads a Report, Timed or Record, or anything with them embedded, of any version up
+//to SchemaVersion into into, migrated to SchemaVersion. one without a schema_version is
+//version 1
+func Decode(raw []byte, into interface{}) error {
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(&document)
+	if err != nil {
+		return err
+	}
+	version := 1
+	if number, isNumber := document["schema_version"].(json.Number); isNumber {
+		parsed, err := number.Int64()
+		if err != nil {
+			return fmt.Errorf("bad schema_version %s", number)
+		}
+		version = int(parsed)
+	}
+	if version < 1 || version > SchemaVersion {
+		return fmt.Errorf("schema_version %d isn't one this cf-metrics knows, it reads up to %d", version, SchemaVersion)
+	}
+	if version < SchemaVersion {
+		for _, migrate := range migrations[version-1:] {
+			migrate(document)
+		}
+		document["schema_version"] = SchemaVersion
+		raw, err = json.Marshal(document)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(raw, into)
+}
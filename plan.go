@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//plannedFoundation is what collect -dry-run prints for a foundation
+type plannedFoundation struct {
+	Foundation string `json:"foundation"`
+	cfclient.CollectionPlan
+	//RequestsPerHour is the plan's requests at -interval, when there is one
+	RequestsPerHour int `json:"requests_per_hour,omitempty"`
+}
+
+//runDryRun works out the plan of a run against every foundation and prints them instead of
+//collecting. a foundation whose plan fails is logged and makes it return an error, after the
+//rest are printed
+func runDryRun(out io.Writer, format string, foundations []foundation, appStats bool, interval time.Duration) error {
+	var plans []plannedFoundation
+	failed := 0
+	for _, target := range foundations {
+		plan, err := target.client.Plan(context.Background(), appStats)
+		if err != nil {
+			slog.Error("could not plan a run", "foundation", target.name, "err", err)
+			failed++
+			continue
+		}
+		planned := plannedFoundation{Foundation: target.name, CollectionPlan: plan}
+		if interval > 0 {
+			planned.RequestsPerHour = int(float64(plan.Requests) * float64(time.Hour) / float64(interval))
+		}
+		for _, step := range plan.Steps {
+			if step.Error != "" {
+				slog.Warn("could not count what a step fetches, it's estimated at a request per list", "foundation", target.name, "doing", step.Doing, "err", step.Error)
+			}
+		}
+		slog.Info("planned a run", "foundation", target.name, "api_version", plan.APIVersion, "orgs", plan.Orgs, "spaces", plan.Spaces, "apps", plan.Apps, "requests", plan.Requests, "requests_per_hour", planned.RequestsPerHour, "probe_requests", plan.ProbeRequests)
+		if plan.Filtered {
+			slog.Warn("filters are on, everything but the org and space counts is of the whole foundation", "foundation", target.name)
+		}
+		plans = append(plans, planned)
+	}
+
+	err := writeListing(out, format, plans, planRows(plans))
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not plan a run against %d of %d foundations", failed, len(foundations))
+	}
+	return nil
+}
+
+//planRows is a row per step of every plan and a total per foundation
+func planRows(plans []plannedFoundation) [][]string {
+	rows := [][]string{{"FOUNDATION", "DOING", "PER", "LISTS", "RESULTS", "REQUESTS", "ENDPOINT"}}
+	for _, plan := range plans {
+		for _, step := range plan.Steps {
+			results := strconv.Itoa(step.TotalResults)
+			if step.TotalResults < 0 {
+				results = "-"
+			}
+			requests := strconv.Itoa(step.Requests)
+			if step.UpTo {
+				requests = "up to " + requests
+			}
+			rows = append(rows, []string{plan.Foundation, step.Doing, step.Per, strconv.Itoa(step.Lists), results, requests, step.Endpoint})
+		}
+		rows = append(rows, []string{plan.Foundation, "total", "", "", "", strconv.Itoa(plan.Requests), ""})
+	}
+	return rows
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//the cf cli runs a plugin as `<binary> <rpc port> <command> <args...>` and answers calls
+//like CliRpcCmd.AccessToken on that port. it's plain net/rpc, so instead of pulling in the
+//cli's plugin package we speak it directly. the types below only have to match the cli's
+//plugin.PluginMetadata field for field, gob doesn't care about the type names
+
+type pluginVersion struct {
+	Major int
+	Minor int
+	Build int
+}
+
+type pluginUsage struct {
+	Usage   string
+	Options map[string]string
+}
+
+type pluginCommand struct {
+	Name         string
+	Alias        string
+	HelpText     string
+	UsageDetails pluginUsage
+}
+
+type pluginMetadata struct {
+	Name          string
+	Version       pluginVersion
+	MinCliVersion pluginVersion
+	Commands      []pluginCommand
+}
+
+//pluginCommandName is what users type after cf, `cf metrics orgs -output json`
+const pluginCommandName = "metrics"
+
+//cfPluginMetadata is what `cf install-plugin` registers
+var cfPluginMetadata = pluginMetadata{
+	Name:    "cf-metrics",
+	Version: pluginVersion{Major: 1},
+	Commands: []pluginCommand{{
+		Name:     pluginCommandName,
+		HelpText: "Collect org, space and app metrics from the targeted foundation",
+		UsageDetails: pluginUsage{
+			Usage: "cf metrics [collect|serve|nozzle|report|orgs|spaces|events|check] [flags, see cf metrics <command> -h]",
+		},
+	}},
+}
+
+//cliConnection is the cf cli rpc server a plugin was started against
+type cliConnection struct {
+	port string
+}
+
+//pluginInvocation is true when we've been started by the cf cli rather than from a shell:
+//the first argument is the cli's rpc port and it's listening. args is the command and its
+//arguments
+func pluginInvocation(osArgs []string) (cli cliConnection, args []string, isPlugin bool) {
+	if len(osArgs) < 3 {
+		return cli, nil, false
+	}
+	if _, err := strconv.Atoi(osArgs[1]); err != nil {
+		return cli, nil, false
+	}
+	cli = cliConnection{port: osArgs[1]}
+	//the cli may still be getting its server up, give it a moment like its own plugin shim does
+	for attempt := 0; attempt < 5; attempt++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+cli.port)
+		if err == nil {
+			conn.Close()
+			return cli, osArgs[2:], true
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return cli, nil, false
+}
+
+//call makes a single CliRpcCmd call, the cli expects a fresh connection for each
+func (cli cliConnection) call(method string, args interface{}, reply interface{}) error {
+	client, err := rpc.Dial("tcp", "127.0.0.1:"+cli.port)
+	if err != nil {
+		return fmt.Errorf("error connecting to the cf cli: %s", err)
+	}
+	defer client.Close()
+	return client.Call("CliRpcCmd."+method, args, reply)
+}
+
+//accessToken asks the cli for its access token, which it refreshes first if it has to
+func (cli cliConnection) accessToken() (string, error) {
+	var token string
+	err := cli.call("AccessToken", "", &token)
+	return token, err
+}
+
+func (cli cliConnection) apiEndpoint() (string, error) {
+	var endpoint string
+	err := cli.call("ApiEndpoint", "", &endpoint)
+	return endpoint, err
+}
+
+func (cli cliConnection) sslDisabled() (bool, error) {
+	var disabled bool
+	err := cli.call("IsSSLDisabled", "", &disabled)
+	return disabled, err
+}
+
+//usePluginTarget points config at the cli's target and has the client get its tokens from
+//the cli, so config.json never gets read
+func usePluginTarget(cli cliConnection, config *cfclient.Config) error {
+	endpoint, err := cli.apiEndpoint()
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		return fmt.Errorf("no api targeted, run cf api and cf login first")
+	}
+	if config.API == "" {
+		config.API = endpoint
+	}
+	disabled, err := cli.sslDisabled()
+	if err != nil {
+		return err
+	}
+	config.SkipSSLValidation = config.SkipSSLValidation || disabled
+	config.TokenSource = cli.accessToken
+	return nil
+}
+
+//runPluginCommand handles the cli's own messages to the plugin and exits. for our command
+//it returns the arguments for the usual flag parsing
+func runPluginCommand(cli cliConnection, args []string) []string {
+	switch args[0] {
+	case "SendMetadata":
+		var ok bool
+		err := cli.call("SetPluginMetadata", cfPluginMetadata, &ok)
+		if err != nil {
+			bailWith("error registering with the cf cli: %s", err)
+		}
+		os.Exit(0)
+	case "CLI-MESSAGE-UNINSTALL":
+		os.Exit(0)
+	case pluginCommandName:
+		return args[1:]
+	}
+	bailWith("unknown command %s, try cf %s -h", args[0], pluginCommandName)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//postgresBatch is how many rows go in one insert statement
+const postgresBatch = 1000
+
+//postgresMigrations are the schema changes the sink makes, in order. the version of each is
+//its index plus one, and the versions applied are kept in cf_metrics_migrations. only ever
+//append to this
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS cf_metrics (
+		collected_at timestamptz NOT NULL,
+		foundation text NOT NULL,
+		org text NOT NULL DEFAULT '',
+		space text NOT NULL DEFAULT '',
+		metric text NOT NULL,
+		value double precision NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS cf_metrics_foundation_metric_time ON cf_metrics (foundation, metric, collected_at)`,
+	`ALTER TABLE cf_metrics ADD COLUMN IF NOT EXISTS tags jsonb NOT NULL DEFAULT '{}'`,
+}
+
+//postgresSink writes every run as normalized rows, one per foundation, org, space and metric,
+//for dashboards over the long term. the schema is created and migrated on the first write
+type postgresSink struct {
+	url        string
+	foundation string
+}
+
+func (postgres postgresSink) name() string {
+	return "postgres"
+}
+
+func (postgres postgresSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	pg, err := dialPostgres(postgres.url, time.Minute)
+	if err != nil {
+		return err
+	}
+	defer pg.close()
+
+	err = migratePostgres(pg)
+	if err != nil {
+		return err
+	}
+
+	//a run goes in whole or not at all, so a dashboard never sums up half of one
+	_, err = pg.exec("BEGIN")
+	if err != nil {
+		return err
+	}
+	err = postgres.insert(pg, metricPoints(postgres.foundation, result, timestamp))
+	if err != nil {
+		pg.exec("ROLLBACK")
+		return err
+	}
+	_, err = pg.exec("COMMIT")
+	return err
+}
+
+//insert adds points to cf_metrics postgresBatch rows per statement, every value a bind
+//parameter so names with quotes in them, or NaN and infinite values, go in as they are
+func (postgres postgresSink) insert(pg *pgConn, points []metricPoint) error {
+	for start := 0; start < len(points); start += postgresBatch {
+		var rows []string
+		var params []string
+		for _, point := range points[start:min(start+postgresBatch, len(points))] {
+			tags := map[string]string{}
+			for _, dimension := range point.dimensions() {
+				tags[dimension[0]] = dimension[1]
+			}
+			encoded, err := json.Marshal(tags)
+			if err != nil {
+				return err
+			}
+			first := len(params) + 1
+			rows = append(rows, fmt.Sprintf("($%d::timestamptz, $%d, $%d, $%d, $%d, $%d::double precision, $%d::jsonb)",
+				first, first+1, first+2, first+3, first+4, first+5, first+6))
+			params = append(params, point.timestamp.UTC().Format(time.RFC3339Nano), postgres.foundation, point.tags["org"], point.tags["space"],
+				point.flatName(), pgFloat(point.value), string(encoded))
+		}
+		err := pg.execParams("INSERT INTO cf_metrics (collected_at, foundation, org, space, metric, value, tags) VALUES "+strings.Join(rows, ", "), params)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//migratePostgres applies the migrations the database hasn't had yet, each in its own
+//transaction along with recording it
+func migratePostgres(pg *pgConn) error {
+	_, err := pg.exec("CREATE TABLE IF NOT EXISTS cf_metrics_migrations (version integer PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now())")
+	if err != nil {
+		return err
+	}
+	versions, err := pg.exec("SELECT coalesce(max(version), 0) FROM cf_metrics_migrations")
+	if err != nil {
+		return err
+	}
+	applied := 0
+	if len(versions) > 0 {
+		applied, _ = strconv.Atoi(versions[0])
+	}
+	for index := applied; index < len(postgresMigrations); index++ {
+		_, err = pg.exec(fmt.Sprintf("BEGIN; %s; INSERT INTO cf_metrics_migrations (version) VALUES (%d); COMMIT", postgresMigrations[index], index+1))
+		if err != nil {
+			return fmt.Errorf("error migrating the postgres schema to version %d: %s", index+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//processRow is a process or sidecar report processes prints
+type processRow struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	App        string `json:"app"`
+	AppGUID    string `json:"app_guid"`
+	//Kind is process or sidecar, Name the process type or the sidecar's name
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Instances int    `json:"instances"`
+	MemoryMB  int    `json:"memory_mb"`
+	//ProcessTypes and Origin are only there for sidecars
+	ProcessTypes []string `json:"process_types,omitempty"`
+	Origin       string   `json:"origin,omitempty"`
+}
+
+type processReport struct {
+	Processes []processRow `json:"processes"`
+	//Sidecars counts the apps running each sidecar, by name, across every foundation
+	Sidecars map[string]int `json:"sidecars"`
+}
+
+//add adds a run's processes that aren't web and have instances, and its sidecars. all adds
+//the web and scaled down processes too
+func (report *processReport) add(foundation string, result cfclient.CollectionResult, all bool) {
+	if report.Sidecars == nil {
+		report.Sidecars = map[string]int{}
+	}
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, process := range space.Processes {
+			if !all && (process.Type == cfclient.WebProcess || process.Instances == 0) {
+				continue
+			}
+			report.Processes = append(report.Processes, processRow{
+				Foundation: foundation,
+				Org:        orgNames[space.OrganizationGUID],
+				Space:      space.Name,
+				App:        process.AppName,
+				AppGUID:    process.AppGUID,
+				Kind:       "process",
+				Name:       process.Type,
+				Instances:  process.Instances,
+				MemoryMB:   process.Instances * process.MemoryMB,
+			})
+		}
+		for _, sidecar := range space.Sidecars {
+			report.Sidecars[sidecar.Name]++
+			report.Processes = append(report.Processes, processRow{
+				Foundation:   foundation,
+				Org:          orgNames[space.OrganizationGUID],
+				Space:        space.Name,
+				App:          sidecar.AppName,
+				AppGUID:      sidecar.AppGUID,
+				Kind:         "sidecar",
+				Name:         sidecar.Name,
+				MemoryMB:     sidecar.MemoryMB,
+				ProcessTypes: sidecar.ProcessTypes,
+				Origin:       sidecar.Origin,
+			})
+		}
+	}
+}
+
+//sort lists the processes by foundation, org, space and app, processes before sidecars
+func (report *processReport) sort() {
+	sort.SliceStable(report.Processes, func(i, j int) bool {
+		a, b := report.Processes[i], report.Processes[j]
+		if a.Foundation+"/"+a.Org+"/"+a.Space+"/"+a.App != b.Foundation+"/"+b.Org+"/"+b.Space+"/"+b.App {
+			return a.Foundation+"/"+a.Org+"/"+a.Space+"/"+a.App < b.Foundation+"/"+b.Org+"/"+b.Space+"/"+b.App
+		}
+		if a.Kind != b.Kind {
+			return a.Kind == "process"
+		}
+		return a.Name < b.Name
+	})
+	if report.Processes == nil {
+		report.Processes = []processRow{}
+	}
+}
+
+func (report processReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "app", "kind", "name", "instances", "memory_mb", "process_types", "origin"}}
+	for _, process := range report.Processes {
+		instances := strconv.Itoa(process.Instances)
+		if process.Kind == "sidecar" {
+			instances = "-"
+		}
+		rows = append(rows, []string{process.Foundation, process.Org, process.Space, process.App, process.Kind, process.Name, instances,
+			strconv.Itoa(process.MemoryMB), strings.Join(process.ProcessTypes, " "), process.Origin})
+	}
+	return writeListing(out, format, report, rows)
+}
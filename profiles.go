@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//collectionProfile is a named set of collectors and when the daemon runs them, see -profile
+type collectionProfile struct {
+	name       string
+	collectors []string
+	skip       []string
+	//schedule is when the daemon collects the profile, the -interval or -schedule when it's nil
+	schedule daemonSchedule
+}
+
+//builtinProfiles are there without a -config file. quick is just the org, space and app counts,
+//full everything a run does by default
+var builtinProfiles = []collectionProfile{
+	{name: "quick", collectors: []string{"apps"}, schedule: intervalSchedule(time.Minute)},
+	{name: "full", schedule: intervalSchedule(30 * time.Minute)},
+}
+
+//profileSettings are the keys a profile can have under profiles: in a -config file
+var profileSettings = []string{"skip-collectors", "collectors", "interval", "schedule"}
+
+//loadProfiles is the built in profiles and the -config file's, like
+//
+//	profiles:
+//	  quick: {collectors: [apps, routes], interval: 2m}
+//	  audit: {collectors: [events], schedule: "0 2 * * *"}
+//
+//a file profile named like a built in one only changes the settings it has, an interval or a
+//schedule replacing the built in interval
+func loadProfiles(configPath string) ([]collectionProfile, error) {
+	profiles := append([]collectionProfile{}, builtinProfiles...)
+	if configPath == "" {
+		return profiles, nil
+	}
+	settings, err := loadSettings(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	timed := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "profiles-") {
+			continue
+		}
+		values := settings[key]
+		name, setting := "", ""
+		for _, candidate := range profileSettings {
+			if strings.HasSuffix(key, "-"+candidate) {
+				name, setting = strings.TrimSuffix(strings.TrimPrefix(key, "profiles-"), "-"+candidate), candidate
+				break
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid config %s: %s isn't a profile setting, a profile has %s", configPath, key, strings.Join(profileSettings, ", "))
+		}
+		index := -1
+		for candidate := range profiles {
+			if profiles[candidate].name == name {
+				index = candidate
+			}
+		}
+		if index < 0 {
+			profiles = append(profiles, collectionProfile{name: name})
+			index = len(profiles) - 1
+		}
+		var list listFlag
+		for _, value := range values {
+			list.Set(value)
+		}
+		switch setting {
+		case "collectors":
+			profiles[index].collectors = list
+		case "skip-collectors":
+			profiles[index].skip = list
+		case "interval", "schedule":
+			if timed[name] {
+				return nil, fmt.Errorf("invalid config %s: profile %s has an interval and a schedule, give it one of them", configPath, name)
+			}
+			timed[name] = true
+			profiles[index].schedule, err = profileSchedule(setting, values[len(values)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid config %s: profile %s: %s", configPath, name, err)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+//profileSchedule is a profile's interval or schedule setting as its schedule
+func profileSchedule(setting string, value string) (daemonSchedule, error) {
+	if setting == "schedule" {
+		return parseSchedule(value)
+	}
+	every, err := time.ParseDuration(value)
+	if err != nil || every <= 0 {
+		return nil, fmt.Errorf("invalid interval %q", value)
+	}
+	return intervalSchedule(every), nil
+}
+
+//pickProfiles is the -profile profiles in the order they're given, nil without any. the
+//-skip-collectors leave collectors out of every one of them
+func (collectors *collectorFlags) pickProfiles(configPath string) ([]collectionProfile, error) {
+	if len(collectors.profiles) == 0 {
+		return nil, nil
+	}
+	if len(collectors.only) > 0 {
+		return nil, fmt.Errorf("-collectors and -profile both say which collectors to run, use one of them")
+	}
+	profiles, err := loadProfiles(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, profile := range profiles {
+		names = append(names, profile.name)
+	}
+	var picked []collectionProfile
+	for _, name := range collectors.profiles {
+		index := -1
+		for candidate, profile := range profiles {
+			if profile.name == name {
+				index = candidate
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("there's no %s profile, the profiles are %s", name, strings.Join(names, ", "))
+		}
+		for _, already := range picked {
+			if already.name == name {
+				return nil, fmt.Errorf("the %s profile is given twice", name)
+			}
+		}
+		profile := profiles[index]
+		profile.skip = append(append([]string{}, profile.skip...), collectors.skip...)
+		picked = append(picked, profile)
+	}
+	return picked, nil
+}
+
+//apply makes the config's collectors the profile's
+func (profile collectionProfile) apply(config *cfclient.Config) {
+	config.Collectors = profile.collectors
+	config.SkipCollectors = profile.skip
+}
+
+//collectProfiles collects every profile once, one after the other
+func collectProfiles(ctx context.Context, profiles []collectionProfile, profileRuns [][]foundationRun) error {
+	if len(profiles) == 1 {
+		return collectAll(ctx, profileRuns[0])
+	}
+	var failed, partly []string
+	for index, profile := range profiles {
+		err := collectAll(ctx, profileRuns[index])
+		if partial(err) {
+			slog.Warn("collection only partly done", "profile", profile.name, "err", err)
+			partly = append(partly, profile.name)
+		} else if err != nil {
+			slog.Error("collection failed", "profile", profile.name, "err", err)
+			failed = append(failed, profile.name)
+		}
+	}
+	if len(failed) == len(profiles) {
+		return fmt.Errorf("collection failed for every profile: %s", strings.Join(failed, ", "))
+	}
+	if len(failed)+len(partly) > 0 {
+		return partialError{fmt.Sprintf("collection failed for %d and was only partly done for %d of %d profiles", len(failed), len(partly), len(profiles))}
+	}
+	return nil
+}
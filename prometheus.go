@@ -0,0 +1,738 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//promLabelNameUnsafe is what a label name can't have, label keys like example.com/team do
+var promLabelNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+//promLabelEscaper escapes label values per the exposition format rules
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//promMetric is one metric family, samples are keyed by their rendered label set. owned
+//holds the sample sets replaced wholesale after a collection, keyed by foundation.
+//exemplars are the histogram buckets' latest traced request, keyed like samples
+type promMetric struct {
+	name      string
+	help      string
+	kind      string
+	samples   map[string]float64
+	owned     map[string]map[string]float64
+	exemplars map[string]*cfclient.Exemplar
+}
+
+//promRegistry holds every metric we expose on /metrics. each foundation's gauges are
+//replaced wholesale after its collection so orgs and spaces that go away stop being
+//reported, counters only go up
+type promRegistry struct {
+	mutex   sync.Mutex
+	metrics map[string]*promMetric
+}
+
+func newPromRegistry() *promRegistry {
+	return &promRegistry{metrics: map[string]*promMetric{}}
+}
+
+//promLabels renders label pairs (name, value, name, value...) as {name="value",...}
+func promLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], promLabelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+//promLabelTags are -label-tags labels as label_<key> pairs for promLabels, with anything a
+//prometheus label name can't have in the key swapped for _
+func promLabelTags(labels map[string]string) []string {
+	var pairs []string
+	for _, key := range sortedKeys(labels) {
+		pairs = append(pairs, "label_"+promLabelNameUnsafe.ReplaceAllString(key, "_"), labels[key])
+	}
+	return pairs
+}
+
+func (registry *promRegistry) family(name string, help string, kind string) *promMetric {
+	metric, exists := registry.metrics[name]
+	if !exists {
+		metric = &promMetric{name: name, help: help, kind: kind, samples: map[string]float64{}, owned: map[string]map[string]float64{}, exemplars: map[string]*cfclient.Exemplar{}}
+		registry.metrics[name] = metric
+	}
+	return metric
+}
+
+//replaceGauges swaps out every sample owner set for the gauges in samples at once, leaving
+//other owners' alone. samples is keyed by metric name then by rendered labels
+func (registry *promRegistry) replaceGauges(owner string, help map[string]string, samples map[string]map[string]float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for name, values := range samples {
+		registry.family(name, help[name], "gauge").owned[owner] = values
+	}
+}
+
+func (registry *promRegistry) setGauge(name string, help string, labels string, value float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.family(name, help, "gauge").samples[labels] = value
+}
+
+//addHistogram adds a run's observations to a histogram, counts being how many fell in each of
+//bounds' buckets plus one for those above them all. the _bucket, _sum and _count suffixes are
+//kept in front of the labels so they render as one family. exemplars, when there are any,
+//replace the buckets' ones
+func (registry *promRegistry) addHistogram(name string, help string, labels []string, bounds []float64, counts []int64, sum float64, exemplars []*cfclient.Exemplar) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	metric := registry.family(name, help, "histogram")
+	var cumulative int64
+	for i, count := range counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'f', -1, 64)
+		}
+		bucket := "_bucket" + promLabels(append(append([]string{}, labels...), "le", le)...)
+		metric.samples[bucket] += float64(cumulative)
+		if i < len(exemplars) && exemplars[i] != nil {
+			metric.exemplars[bucket] = exemplars[i]
+		}
+	}
+	metric.samples["_sum"+promLabels(labels...)] += sum
+	metric.samples["_count"+promLabels(labels...)] += float64(cumulative)
+}
+
+func (registry *promRegistry) addCounter(name string, help string, labels string, delta float64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.family(name, help, "counter").samples[labels] += delta
+}
+
+//write renders the registry in the prometheus text exposition format, sorted so scrapes are
+//stable
+func (registry *promRegistry) write(w io.Writer) error {
+	return registry.writeFormat(w, false)
+}
+
+//writeFormat is write, or with openMetrics the OpenMetrics text format: counter families are
+//named without their _total, buckets carry their exemplar and it ends with # EOF
+func (registry *promRegistry) writeFormat(w io.Writer, openMetrics bool) error {
+	for _, metric := range registry.families() {
+		family := metric.name
+		if openMetrics && metric.kind == "counter" {
+			family = strings.TrimSuffix(family, "_total")
+		}
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family, metric.help, family, metric.kind)
+		if err != nil {
+			return err
+		}
+		name := metric.name
+		if openMetrics && metric.kind == "counter" {
+			name = family + "_total"
+		}
+		samples := metric.samples
+		var labelSets []string
+		for labels := range samples {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		if metric.kind == "histogram" {
+			//each series' buckets smallest first then its _count and _sum, together the way
+			//OpenMetrics wants them
+			order := map[string]int{"_bucket": 0, "_count": 1, "_sum": 2}
+			sort.SliceStable(labelSets, func(i, j int) bool {
+				suffixI, labelsI := promSampleSuffix(metric.kind, labelSets[i])
+				suffixJ, labelsJ := promSampleSuffix(metric.kind, labelSets[j])
+				seriesI, leI := histogramBucket(labelsI)
+				seriesJ, leJ := histogramBucket(labelsJ)
+				seriesI, seriesJ = strings.TrimSuffix(seriesI, "}"), strings.TrimSuffix(seriesJ, "}")
+				if seriesI != seriesJ {
+					return seriesI < seriesJ
+				}
+				if suffixI != suffixJ {
+					return order[suffixI] < order[suffixJ]
+				}
+				return leI < leJ
+			})
+		}
+		for _, labels := range labelSets {
+			exemplar := ""
+			if sample := metric.exemplars[labels]; openMetrics && sample != nil {
+				exemplar = fmt.Sprintf(" # %s %g %.3f", promLabels("trace_id", sample.TraceID, "span_id", sample.SpanID), sample.Latency, float64(sample.At.UnixMilli())/1000)
+			}
+			_, err = fmt.Fprintf(w, "%s%s %g%s\n", name, labels, samples[labels], exemplar)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if openMetrics {
+		_, err := fmt.Fprint(w, "# EOF\n")
+		return err
+	}
+	return nil
+}
+
+//merged is every sample of the family, its own and every owner's
+func (metric *promMetric) merged() map[string]float64 {
+	samples := map[string]float64{}
+	for labels, value := range metric.samples {
+		samples[labels] = value
+	}
+	for _, owned := range metric.owned {
+		for labels, value := range owned {
+			samples[labels] = value
+		}
+	}
+	return samples
+}
+
+//promSample is one sample of a registry, for sinks that send them as something other than
+//the text format. name has a histogram's _bucket, _sum or _count suffix
+type promSample struct {
+	family *promMetric
+	name   string
+	labels []string
+	value  float64
+}
+
+//families are a copy of the registry's metric families sorted by name, with every owner's
+//samples merged in and the -metric-rule rules applied. samples a rule leaves the same as
+//another are added up
+func (registry *promRegistry) families() []*promMetric {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	relabeled := map[string]*promMetric{}
+	for _, metric := range registry.metrics {
+		merged := metric.merged()
+		if len(metricRules) == 0 {
+			exemplars := map[string]*cfclient.Exemplar{}
+			for key, exemplar := range metric.exemplars {
+				exemplars[key] = exemplar
+			}
+			relabeled[metric.name] = &promMetric{name: metric.name, help: metric.help, kind: metric.kind, samples: merged, exemplars: exemplars}
+			continue
+		}
+		for key, value := range merged {
+			suffix, labels := promSampleSuffix(metric.kind, key)
+			name, pairs, keep := metricRules.apply(metric.name, parsePromLabels(labels))
+			if !keep {
+				continue
+			}
+			family := relabeled[name]
+			if family == nil {
+				family = &promMetric{name: name, help: metric.help, kind: metric.kind, samples: map[string]float64{}, exemplars: map[string]*cfclient.Exemplar{}}
+				relabeled[name] = family
+			}
+			family.samples[suffix+promLabels(pairs...)] += value
+			if exemplar := metric.exemplars[key]; exemplar != nil {
+				if kept := family.exemplars[suffix+promLabels(pairs...)]; kept == nil || exemplar.At.After(kept.At) {
+					family.exemplars[suffix+promLabels(pairs...)] = exemplar
+				}
+			}
+		}
+	}
+	var families []*promMetric
+	for _, name := range sortedKeys(relabeled) {
+		families = append(families, relabeled[name])
+	}
+	return families
+}
+
+//promSampleSuffix splits a histogram's _bucket, _sum or _count suffix off a sample's key.
+//other kinds have none
+func promSampleSuffix(kind string, key string) (string, string) {
+	if kind != "histogram" {
+		return "", key
+	}
+	start := strings.Index(key, "{")
+	if start < 0 {
+		return key, ""
+	}
+	return key[:start], key[start:]
+}
+
+//samples is every sample in the registry, labels as name, value pairs
+func (registry *promRegistry) samples() []promSample {
+	var samples []promSample
+	for _, metric := range registry.families() {
+		for _, key := range sortedKeys(metric.samples) {
+			suffix, labels := promSampleSuffix(metric.kind, key)
+			samples = append(samples, promSample{family: metric, name: metric.name + suffix, labels: parsePromLabels(labels), value: metric.samples[key]})
+		}
+	}
+	return samples
+}
+
+//parsePromLabels reads a label set promLabels rendered back into its pairs
+func parsePromLabels(labels string) []string {
+	var pairs []string
+	rest := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+	for rest != "" {
+		name, quoted, found := strings.Cut(rest, `="`)
+		if !found {
+			break
+		}
+		var value strings.Builder
+		index := 0
+		for ; index < len(quoted) && quoted[index] != '"'; index++ {
+			if quoted[index] == '\\' && index+1 < len(quoted) {
+				index++
+				if quoted[index] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(quoted[index])
+		}
+		pairs = append(pairs, name, value.String())
+		rest = strings.TrimPrefix(quoted[min(index+1, len(quoted)):], ",")
+	}
+	return pairs
+}
+
+//histogramBucket splits a histogram's _bucket sample into its labels without le, and le, so
+//buckets can be listed smallest first. other samples have an le of 0
+func histogramBucket(labels string) (string, float64) {
+	start := strings.Index(labels, `,le="`)
+	if start < 0 {
+		return labels, 0
+	}
+	le, err := strconv.ParseFloat(strings.TrimSuffix(labels[start+len(`,le="`):], `"}`), 64)
+	if err != nil {
+		return labels, 0
+	}
+	return labels[:start], le
+}
+
+//ServeHTTP serves the OpenMetrics format to scrapers that ask for it, which is how prometheus
+//gets the exemplars, and the prometheus text format to everything else
+func (registry *promRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		registry.writeFormat(w, true)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registry.write(w)
+}
+
+var promHelp = map[string]string{
+	"cf_org_apps":                             "apps in the org",
+	"cf_org_labels":                           "always 1, labelled with the org's -label-tags labels",
+	"cf_space_labels":                         "always 1, labelled with the space's -label-tags labels",
+	"cf_org_instances":                        "desired app instances in the org",
+	"cf_org_reserved_memory_mb":               "memory reserved by apps in the org",
+	"cf_org_reserved_disk_mb":                 "disk reserved by apps in the org",
+	"cf_org_running_instances":                "desired instances of the started apps in the org",
+	"cf_org_running_memory_mb":                "memory reserved by the started apps in the org",
+	"cf_org_events":                           "audit events in the org by type",
+	"cf_space_apps":                           "apps in the space",
+	"cf_space_instances":                      "desired app instances in the space",
+	"cf_space_reserved_memory_mb":             "memory reserved by apps in the space",
+	"cf_space_reserved_disk_mb":               "disk reserved by apps in the space",
+	"cf_space_running_instances":              "desired instances of the started apps in the space",
+	"cf_space_running_memory_mb":              "memory reserved by the started apps in the space",
+	"cf_space_events":                         "audit events in the space by type",
+	"cf_space_service_instances":              "service instances in the space",
+	"cf_space_routes":                         "routes in the space",
+	"cf_space_orphaned_routes":                "routes in the space that aren't mapped to any app",
+	"cf_domain_routes":                        "routes on the domain",
+	"cf_isolation_segment_apps":               "apps running in the isolation segment, shared for the shared cells",
+	"cf_isolation_segment_instances":          "desired app instances in the isolation segment",
+	"cf_isolation_segment_reserved_memory_mb": "memory reserved by apps in the isolation segment",
+	"cf_org_role_users":                       "users with the role in the org, only with -roles",
+	"cf_space_role_users":                     "users with the role in the space, only with -roles",
+	"cf_org_stack_apps":                       "apps in the org on the stack",
+	"cf_space_stack_apps":                     "apps in the space on the stack",
+	"cf_service_plan_instances":               "service instances of the plan in the org",
+	"cf_service_plan_stuck":                   "service instances of the plan in the org whose last operation failed or has been in progress for over an hour",
+	"cf_service_instance_operation_seconds":   "how long the service instance's last operation has been in progress, only instances with one in progress",
+	"cf_app_crashes":                          "times the app crashed during the event window",
+	"cf_app_crashes_per_hour":                 "app crashes per hour over the event window, 0 without a -since",
+	"cf_app_tasks":                            "tasks the app created during the event window by state",
+	"cf_app_task_mean_duration_seconds":       "mean run time of the app's finished tasks in the event window",
+	"cf_app_task_max_duration_seconds":        "longest run time of the app's finished tasks in the event window",
+	"cf_space_builds":                         "builds of the space's apps created during the event window by state",
+	"cf_space_build_duration_seconds":         "p50 and p95 staging time of the space's finished builds in the event window",
+	"cf_buildpack_builds":                     "builds with the buildpack created during the event window by state",
+	"cf_buildpack_build_duration_seconds":     "p50 and p95 staging time of the finished builds with the buildpack in the event window",
+	"cf_org_blobstore_bytes":                  "bytes the org's apps keep in the blobstore by kind, droplet or package, only with -blob-sizes",
+	"cf_org_large_droplet_apps":               "apps in the org with a droplet over -large-droplet-mb, only with -blob-sizes",
+	"cf_app_droplet_bytes":                    "bytes of every droplet the app keeps in the blobstore, only with -blob-sizes",
+	"cf_app_largest_droplet_bytes":            "bytes of the app's biggest droplet, only with -blob-sizes",
+	"cf_app_package_bytes":                    "bytes of every package the app keeps in the blobstore, only with -blob-sizes",
+	"cf_space_deployments":                    "deployments created in the space during the event window by strategy, only with -deployments",
+	"cf_space_active_deployments":             "deployments in the space still rolling out, only with -deployments",
+	"cf_space_app_updates":                    "app updates in the space during the event window by method, deployment or a restart that stopped the app, only with -deployments",
+	"cf_space_zero_downtime_percent":          "how many of the space's app updates in the event window were deployments, only with -deployments",
+	"cf_space_pinned_apps":                    "apps in the space running an older revision than their newest or a rollback, only with -deployments",
+	"cf_space_process_apps":                   "apps in the space with a process of the type, only with -processes",
+	"cf_space_process_instances":              "instances of the processes of the type in the space, only with -processes",
+	"cf_space_process_memory_mb":              "memory reserved by the processes of the type in the space, only with -processes",
+	"cf_space_sidecars":                       "sidecars of the apps in the space, only with -processes",
+	"cf_sidecar_apps":                         "apps across the foundation running the sidecar, by name and origin, only with -processes",
+	"cf_space_ssh_allowed":                    "1 when the space allows ssh into its apps, 0 when it doesn't, only with -ssh",
+	"cf_space_user_provided_services":         "user provided service instances in the space by kind, syslog_drain, route_service or credentials",
+	"cf_space_syslog_drain_apps":              "apps in the space bound to a user provided syslog drain, sending their logs off the foundation",
+	"cf_space_route_service_apps":             "apps in the space mapped to a route bound to a user provided route service",
+	"cf_space_ssh_apps":                       "apps in the space that can be ssh'd into, only with -ssh",
+	"cf_space_env_vars":                       "environment variables set by the apps in the space, only with -env-scan",
+	"cf_space_suspicious_env_vars":            "environment variables in the space whose names look like secrets, only with -env-scan",
+	"cf_space_suspicious_env_apps":            "apps in the space with environment variables whose names look like secrets, only with -env-scan",
+	"cf_platform_info":                        "always 1, labelled with the foundation's api version and build",
+	"cf_feature_flag_enabled":                 "1 when the platform feature flag is on, 0 when it's off",
+	"cf_security_group_rules":                 "egress rules in the application security group",
+	"cf_security_group_wide_open_rules":       "rules in the security group allowing 0.0.0.0/0 on every port",
+	"cf_security_group_spaces":                "spaces the security group is bound to by lifecycle (running or staging), not counting default groups",
+	"cf_catalog_plan_instances":               "service instances of the marketplace plan, 0 for plans nobody uses, labelled with whether it's public",
+	"cf_catalog_plans":                        "plans in the marketplace",
+	"cf_catalog_public_plans":                 "plans in the marketplace every org can see",
+	"cf_catalog_unused_plans":                 "plans in the marketplace without any service instances",
+	"cf_service_brokers":                      "service brokers registered on the foundation, 0 without admin access",
+	"cf_quota_limit":                          "the org or space quota limit by resource (memory_mb, instances, routes), -1 is unlimited",
+	"cf_quota_used_percent":                   "percent of the org or space quota limit in use by resource",
+}
+
+//recordQuota adds a quota's limits and utilization to samples under labels (without the
+//trailing resource label)
+func recordQuota(samples map[string]map[string]float64, quota *cfclient.QuotaUsage, labels ...string) {
+	if quota == nil {
+		return
+	}
+	limits := []struct {
+		resource string
+		limit    int
+		percent  float64
+	}{
+		{"memory_mb", quota.Quota.MemoryLimitMB, quota.MemoryPercent},
+		{"instances", quota.Quota.InstanceLimit, quota.InstancesPercent},
+		{"routes", quota.Quota.RouteLimit, quota.RoutesPercent},
+		{"service_instances", quota.Quota.ServiceInstanceLimit, quota.ServiceInstancesPercent},
+	}
+	for _, limit := range limits {
+		resourceLabels := promLabels(append(labels, "quota", quota.Quota.Name, "resource", limit.resource)...)
+		samples["cf_quota_limit"][resourceLabels] = float64(limit.limit)
+		samples["cf_quota_used_percent"][resourceLabels] = limit.percent
+	}
+}
+
+//recordCollection turns a collection result into the foundation's org and space gauges
+func (registry *promRegistry) recordCollection(foundation string, result cfclient.CollectionResult) {
+	samples := map[string]map[string]float64{}
+	for name := range promHelp {
+		samples[name] = map[string]float64{}
+	}
+
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		labels := promLabels("foundation", foundation, "org", org.Name)
+		instances, memory := cfclient.AppTotals(org.Apps)
+		samples["cf_org_apps"][labels] = float64(len(org.Apps))
+		if len(org.Labels) > 0 {
+			samples["cf_org_labels"][promLabels(append([]string{"foundation", foundation, "org", org.Name}, promLabelTags(org.Labels)...)...)] = 1
+		}
+		samples["cf_org_instances"][labels] = float64(instances)
+		samples["cf_org_reserved_memory_mb"][labels] = float64(memory)
+		samples["cf_org_reserved_disk_mb"][labels] = float64(org.Usage.ReservedDiskMB)
+		samples["cf_org_running_instances"][labels] = float64(org.Usage.RunningInstances)
+		samples["cf_org_running_memory_mb"][labels] = float64(org.Usage.RunningMemoryMB)
+		for eventType, count := range eventCounts(org.Events) {
+			samples["cf_org_events"][promLabels("foundation", foundation, "org", org.Name, "type", eventType)] = float64(count)
+		}
+		recordQuota(samples, org.Quota, "foundation", foundation, "org", org.Name)
+		for role, count := range org.RoleCounts {
+			samples["cf_org_role_users"][promLabels("foundation", foundation, "org", org.Name, "role", role)] = float64(count)
+		}
+		for stack, count := range org.StackCounts {
+			samples["cf_org_stack_apps"][promLabels("foundation", foundation, "org", org.Name, "stack", stack)] = float64(count)
+		}
+		for _, plan := range cfclient.CountByPlan(org.ServiceInstances, time.Now()) {
+			planLabels := promLabels("foundation", foundation, "org", org.Name, "service", tagValue(plan.ServiceName), "plan", tagValue(plan.PlanName), "broker", tagValue(plan.BrokerName))
+			samples["cf_service_plan_instances"][planLabels] = float64(plan.Instances)
+			samples["cf_service_plan_stuck"][planLabels] = float64(plan.Stuck)
+		}
+	}
+	for _, space := range result.Spaces {
+		orgName := tagValue(orgNames[space.OrganizationGUID])
+		labels := promLabels("foundation", foundation, "org", orgName, "space", space.Name)
+		instances, memory := cfclient.AppTotals(space.Apps)
+		samples["cf_space_apps"][labels] = float64(len(space.Apps))
+		if len(space.Labels) > 0 {
+			samples["cf_space_labels"][promLabels(append([]string{"foundation", foundation, "org", orgName, "space", space.Name}, promLabelTags(space.Labels)...)...)] = 1
+		}
+		samples["cf_space_instances"][labels] = float64(instances)
+		samples["cf_space_reserved_memory_mb"][labels] = float64(memory)
+		samples["cf_space_reserved_disk_mb"][labels] = float64(space.Usage.ReservedDiskMB)
+		samples["cf_space_running_instances"][labels] = float64(space.Usage.RunningInstances)
+		samples["cf_space_running_memory_mb"][labels] = float64(space.Usage.RunningMemoryMB)
+		samples["cf_space_service_instances"][labels] = float64(len(space.ServiceInstances))
+		for _, instance := range space.ServiceInstances {
+			if instance.LastOperation.State == "in progress" {
+				instanceLabels := promLabels("foundation", foundation, "org", orgName, "space", space.Name, "instance", instance.Name, "operation", tagValue(instance.LastOperation.Type))
+				samples["cf_service_instance_operation_seconds"][instanceLabels] = instance.OperationDuration(time.Now()).Seconds()
+			}
+		}
+		samples["cf_space_routes"][labels] = float64(len(space.Routes))
+		samples["cf_space_orphaned_routes"][labels] = float64(len(cfclient.OrphanedRoutes(space.Routes)))
+		for eventType, count := range eventCounts(space.Events) {
+			samples["cf_space_events"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "type", eventType)] = float64(count)
+		}
+		recordQuota(samples, space.Quota, "foundation", foundation, "org", orgName, "space", space.Name)
+		for role, count := range space.RoleCounts {
+			samples["cf_space_role_users"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "role", role)] = float64(count)
+		}
+		for stack, count := range space.StackCounts {
+			samples["cf_space_stack_apps"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "stack", stack)] = float64(count)
+		}
+		if result.DeploymentsCollected {
+			summary := cfclient.SummarizeDeployments(space, result.EventsSince)
+			samples["cf_space_deployments"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "strategy", "rolling")] = float64(summary.Rolling)
+			samples["cf_space_deployments"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "strategy", "other")] = float64(summary.Deployments - summary.Rolling)
+			samples["cf_space_active_deployments"][labels] = float64(summary.Active)
+			samples["cf_space_app_updates"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "method", "deployment")] = float64(summary.Deployments)
+			samples["cf_space_app_updates"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "method", "restart")] = float64(summary.RestartUpdates)
+			samples["cf_space_zero_downtime_percent"][labels] = summary.ZeroDowntimePercent()
+			samples["cf_space_pinned_apps"][labels] = float64(summary.PinnedApps)
+		}
+		if result.EnvScanned {
+			apps, suspicious := cfclient.SuspiciousEnvApps(space)
+			samples["cf_space_env_vars"][labels] = float64(envVarCount(space))
+			samples["cf_space_suspicious_env_vars"][labels] = float64(suspicious)
+			samples["cf_space_suspicious_env_apps"][labels] = float64(apps)
+		}
+		if result.SSHCollected && space.SSHAllowed != nil {
+			samples["cf_space_ssh_allowed"][labels] = float64(boolInt(*space.SSHAllowed))
+			samples["cf_space_ssh_apps"][labels] = float64(len(space.SSHApps))
+		}
+		if contains(result.Collectors, "user-provided-services") {
+			summary := cfclient.SummarizeUserProvided(space)
+			samples["cf_space_user_provided_services"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "kind", "syslog_drain")] = float64(summary.SyslogDrains)
+			samples["cf_space_user_provided_services"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "kind", "route_service")] = float64(summary.RouteServices)
+			samples["cf_space_user_provided_services"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "kind", "credentials")] = float64(summary.Instances - summary.SyslogDrains - summary.RouteServices)
+			samples["cf_space_syslog_drain_apps"][labels] = float64(summary.DrainedApps)
+			samples["cf_space_route_service_apps"][labels] = float64(summary.RouteServiceApps)
+		}
+		if result.ProcessesCollected {
+			for _, summary := range cfclient.SummarizeProcesses(space) {
+				typeLabels := promLabels("foundation", foundation, "org", orgName, "space", space.Name, "type", tagValue(summary.Type))
+				samples["cf_space_process_apps"][typeLabels] = float64(summary.Apps)
+				samples["cf_space_process_instances"][typeLabels] = float64(summary.Instances)
+				samples["cf_space_process_memory_mb"][typeLabels] = float64(summary.MemoryMB)
+			}
+			samples["cf_space_sidecars"][labels] = float64(len(space.Sidecars))
+			for _, sidecar := range space.Sidecars {
+				samples["cf_sidecar_apps"][promLabels("foundation", foundation, "sidecar", tagValue(sidecar.Name), "origin", tagValue(sidecar.Origin))]++
+			}
+		}
+		for _, summary := range cfclient.SummarizeTasks(space) {
+			for _, state := range cfclient.TaskStateList {
+				samples["cf_app_tasks"][promLabels("foundation", foundation, "org", orgName, "space", space.Name, "app", tagValue(summary.AppName), "app_guid", summary.AppGUID, "state", state)] = float64(summary.States[state])
+			}
+			appLabels := promLabels("foundation", foundation, "org", orgName, "space", space.Name, "app", tagValue(summary.AppName), "app_guid", summary.AppGUID)
+			samples["cf_app_task_mean_duration_seconds"][appLabels] = summary.MeanDuration.Seconds()
+			samples["cf_app_task_max_duration_seconds"][appLabels] = summary.MaxDuration.Seconds()
+		}
+		for _, crash := range spaceAppCrashes(space) {
+			appLabels := promLabels("foundation", foundation, "org", orgName, "space", space.Name, "app", tagValue(crash.name), "app_guid", crash.guid)
+			samples["cf_app_crashes"][appLabels] = float64(crash.crashes)
+			samples["cf_app_crashes_per_hour"][appLabels] = result.CrashesPerHour(crash.crashes)
+		}
+	}
+	for _, total := range cfclient.TotalsBySegment(result.Spaces) {
+		segmentLabels := promLabels("foundation", foundation, "segment", total.Segment)
+		samples["cf_isolation_segment_apps"][segmentLabels] = float64(total.Apps)
+		samples["cf_isolation_segment_instances"][segmentLabels] = float64(total.Instances)
+		samples["cf_isolation_segment_reserved_memory_mb"][segmentLabels] = float64(total.ReservedMemoryMB)
+	}
+	routes := cfclient.RoutesPerDomain(result.Spaces, result.Domains)
+	for _, domain := range result.Domains {
+		samples["cf_domain_routes"][promLabels("foundation", foundation, "domain", domain.Name, "shared", strconv.FormatBool(domain.Shared))] = float64(routes[domain.Name])
+	}
+
+	for _, group := range result.SecurityGroups {
+		groupLabels := promLabels("foundation", foundation, "group", group.Name)
+		samples["cf_security_group_rules"][groupLabels] = float64(len(group.Rules))
+		samples["cf_security_group_wide_open_rules"][groupLabels] = float64(len(group.WideOpenRules()))
+		samples["cf_security_group_spaces"][promLabels("foundation", foundation, "group", group.Name, "lifecycle", "running")] = float64(len(group.RunningSpaceGUIDs))
+		samples["cf_security_group_spaces"][promLabels("foundation", foundation, "group", group.Name, "lifecycle", "staging")] = float64(len(group.StagingSpaceGUIDs))
+	}
+	if contains(result.Collectors, "blobs") {
+		footprints := cfclient.OrgBlobstoreFootprints(result.Spaces)
+		for _, org := range result.Orgs {
+			samples["cf_org_blobstore_bytes"][promLabels("foundation", foundation, "org", org.Name, "kind", "droplet")] = float64(footprints[org.GUID].DropletBytes)
+			samples["cf_org_blobstore_bytes"][promLabels("foundation", foundation, "org", org.Name, "kind", "package")] = float64(footprints[org.GUID].PackageBytes)
+			samples["cf_org_large_droplet_apps"][promLabels("foundation", foundation, "org", org.Name)] = float64(footprints[org.GUID].LargeApps)
+		}
+		for _, space := range result.Spaces {
+			for _, blobs := range space.Blobs {
+				appLabels := promLabels("foundation", foundation, "org", tagValue(orgNames[space.OrganizationGUID]), "space", space.Name, "app", tagValue(blobs.AppName), "app_guid", blobs.AppGUID, "large", strconv.FormatBool(blobs.Large))
+				samples["cf_app_droplet_bytes"][appLabels] = float64(blobs.DropletBytes)
+				samples["cf_app_largest_droplet_bytes"][appLabels] = float64(blobs.LargestDropletBytes)
+				samples["cf_app_package_bytes"][appLabels] = float64(blobs.PackageBytes)
+			}
+		}
+	}
+	if contains(result.Collectors, "builds") {
+		recordBuilds := func(name string, summary cfclient.BuildSummary, labels ...string) {
+			for _, state := range cfclient.BuildStateList {
+				samples[name+"_builds"][promLabels(append(append([]string{}, labels...), "state", state)...)] = float64(summary.States[state])
+			}
+			samples[name+"_build_duration_seconds"][promLabels(append(append([]string{}, labels...), "quantile", "0.5")...)] = summary.P50.Seconds()
+			samples[name+"_build_duration_seconds"][promLabels(append(append([]string{}, labels...), "quantile", "0.95")...)] = summary.P95.Seconds()
+		}
+		for _, space := range result.Spaces {
+			recordBuilds("cf_space", cfclient.SummarizeBuilds(space.Builds), "foundation", foundation, "org", tagValue(orgNames[space.OrganizationGUID]), "space", space.Name)
+		}
+		for buildpack, summary := range cfclient.SummarizeBuildsByBuildpack(result.Spaces) {
+			recordBuilds("cf_buildpack", summary, "foundation", foundation, "buildpack", tagValue(buildpack))
+		}
+	}
+	if contains(result.Collectors, "catalog") {
+		catalog := result.ServiceCatalog
+		for _, plan := range catalog.Plans {
+			samples["cf_catalog_plan_instances"][promLabels("foundation", foundation, "broker", plan.BrokerName, "service", plan.ServiceName, "plan", plan.Name, "plan_guid", plan.GUID, "public", strconv.FormatBool(plan.Public))] = float64(plan.Instances)
+		}
+		samples["cf_catalog_plans"][promLabels("foundation", foundation)] = float64(len(catalog.Plans))
+		samples["cf_catalog_public_plans"][promLabels("foundation", foundation)] = float64(len(catalog.PublicPlans()))
+		samples["cf_catalog_unused_plans"][promLabels("foundation", foundation)] = float64(len(catalog.UnusedPlans()))
+		samples["cf_service_brokers"][promLabels("foundation", foundation)] = float64(len(catalog.Brokers))
+	}
+	if result.Platform.Info.APIVersion != "" {
+		samples["cf_platform_info"][promLabels("foundation", foundation, "api_version", result.Platform.Info.APIVersion, "build", result.Platform.Info.Build)] = 1
+	}
+	for _, flag := range result.Platform.FeatureFlags {
+		samples["cf_feature_flag_enabled"][promLabels("foundation", foundation, "flag", flag.Name)] = float64(boolInt(flag.Enabled))
+	}
+
+	registry.replaceGauges(foundation, promHelp, samples)
+}
+
+func eventCounts(events []cfclient.Event) map[string]int {
+	counts := map[string]int{}
+	for _, event := range events {
+		counts[event.Type]++
+	}
+	return counts
+}
+
+//recordRequests adds a run's cloud controller requests to the per endpoint counters and
+//latency histogram, whether or not the run worked
+func (registry *promRegistry) recordRequests(foundation string, endpoints []cfclient.EndpointStats) {
+	for _, endpoint := range endpoints {
+		labels := []string{"foundation", foundation, "endpoint", endpoint.Endpoint}
+		registry.addCounter("cf_api_requests_total", "cloud controller requests by endpoint", promLabels(labels...), float64(endpoint.Requests))
+		registry.addCounter("cf_api_request_errors_total", "cloud controller requests that failed or got a non 2xx response", promLabels(labels...), float64(endpoint.Errors))
+		registry.addCounter("cf_api_request_retries_total", "cloud controller requests made again after an error or a token refresh", promLabels(labels...), float64(endpoint.Retries))
+		registry.addHistogram("cf_api_request_duration_seconds", "how long cloud controller requests took", labels, cfclient.LatencyBuckets, endpoint.Buckets, endpoint.Latency.Seconds(), endpoint.Exemplars)
+	}
+}
+
+//recordBreaker sets the breaker gauges of the foundation labels are for, nothing when it has
+//no breaker
+func (registry *promRegistry) recordBreaker(labels string, breaker *circuitBreaker) {
+	if breaker == nil {
+		return
+	}
+	status := breaker.status(time.Now())
+	registry.setGauge("cf_collection_breaker_open", "1 while the foundation's circuit breaker is skipping its collections", labels, float64(boolInt(status.State == "open")))
+	registry.setGauge("cf_collection_consecutive_failures", "collection runs in a row that failed", labels, float64(status.ConsecutiveFailures))
+}
+
+//recordCollectorFailures sets how many failures each collector of the run had
+func (registry *promRegistry) recordCollectorFailures(foundation string, result cfclient.CollectionResult) {
+	for name, count := range result.CollectorFailures() {
+		registry.setGauge("cf_collector_errors", "orgs/spaces each collector couldn't collect in the last collection", promLabels("foundation", foundation, "collector", name), float64(count))
+	}
+}
+
+//scrapeLoop collects from a foundation on the schedule forever, updating the registry after
+//each run. a run that fails outright is counted and the last good numbers are left in place.
+//while breaker is open the foundation isn't collected from at all, and the times a run ran
+//past are skipped rather than overlapping it. a traced run's trace goes to traces when it's set
+func scrapeLoop(target foundation, registry *promRegistry, health *collectorHealth, breaker *circuitBreaker, schedule daemonSchedule, timeout time.Duration, appStats bool, traces sink) {
+	labels := promLabels("foundation", target.name)
+	due := schedule.first(time.Now())
+	for {
+		time.Sleep(time.Until(due))
+		var skipped int
+		started := time.Now()
+		if !breaker.allow(started) {
+			registry.addCounter("cf_collections_skipped_total", "collection runs skipped while the foundation's circuit breaker was open", labels, 1)
+			registry.recordBreaker(labels, breaker)
+			due, _ = nextDue(schedule, due, time.Now())
+			continue
+		}
+		ctx, cancel := collectionContext(context.Background(), timeout)
+		result, err := target.client.Collect(ctx, appStats)
+		cancel()
+		breaker.record(err, time.Now())
+		registry.recordBreaker(labels, breaker)
+		health.record(target.name, err)
+		registry.recordRequests(target.name, result.Requests)
+		if traces != nil {
+			traceErr := traces.write(result, time.Now())
+			if traceErr != nil {
+				slog.Warn("couldn't export the collection's trace", "foundation", target.name, "err", traceErr)
+			}
+		}
+		registry.setGauge("cf_collection_duration_seconds", "how long the last collection took", labels, time.Since(started).Seconds())
+		registry.addCounter("cf_collections_total", "collection runs", labels, 1)
+		if err != nil {
+			slog.Error("collection failed", "foundation", target.name, "err", err)
+			registry.addCounter("cf_collection_failures_total", "collection runs that failed outright", labels, 1)
+		} else {
+			registry.recordCollection(target.name, result)
+			registry.setGauge("cf_collection_errors", "orgs/spaces the last collection couldn't fully collect", labels, float64(len(result.Failures)))
+			registry.recordCollectorFailures(target.name, result)
+			registry.setGauge("cf_collection_last_success_timestamp_seconds", "when the last collection finished", labels, float64(time.Now().Unix()))
+		}
+		due, skipped = nextDue(schedule, due, time.Now())
+		if skipped > 0 {
+			slog.Warn("the collection ran past the next ones, skipping them", "foundation", target.name, "schedule", schedule.String(), "skipped", skipped)
+		}
+	}
+}
+
+//serveMetrics starts a scrape loop per foundation in the background and serves the registry
+//on /metrics, and the health checks on /healthz and /readyz, at address until the server fails.
+//with several foundations each gets a circuit breaker opening after breakerFailures failed runs
+func serveMetrics(foundations []foundation, address string, schedule daemonSchedule, timeout time.Duration, appStats bool, breakerFailures int, breakerCooldown time.Duration, traces *otlpTracesFlags) error {
+	registry := newPromRegistry()
+	health := newCollectorHealth(foundations, 3*scheduleGap(schedule, time.Now())+timeout)
+	for _, target := range foundations {
+		var breaker *circuitBreaker
+		if len(foundations) > 1 {
+			breaker = newCircuitBreaker(breakerFailures, breakerCooldown)
+			health.watchBreaker(target.name, breaker)
+		}
+		var traceSink sink
+		if exporter, configured := traces.sink(target.name); configured {
+			traceSink = exporter
+		}
+		go scrapeLoop(target, registry, health, breaker, schedule, timeout, appStats, traceSink)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	health.handle(mux)
+	slog.Info("serving metrics", "address", address, "path", "/metrics")
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+//protoMessage is an encoded protobuf message, appended to a field at a time
+type protoMessage []byte
+
+func (m *protoMessage) varint(field int, value uint64) {
+	*m = binary.AppendUvarint(binary.AppendUvarint(*m, uint64(field)<<3), value)
+}
+
+func (m *protoMessage) fixed64(field int, value uint64) {
+	*m = binary.LittleEndian.AppendUint64(binary.AppendUvarint(*m, uint64(field)<<3|1), value)
+}
+
+func (m *protoMessage) sfixed64(field int, value int64) {
+	m.fixed64(field, uint64(value))
+}
+
+func (m *protoMessage) double(field int, value float64) {
+	m.fixed64(field, math.Float64bits(value))
+}
+
+func (m *protoMessage) string(field int, value string) {
+	m.message(field, protoMessage(value))
+}
+
+func (m *protoMessage) message(field int, value protoMessage) {
+	*m = append(*m, protoField(field, value)...)
+}
+
+//protoField is a length delimited field on its own, for building repeated fields up apart
+//from the message they end up in
+func protoField(field int, value protoMessage) protoMessage {
+	encoded := binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field)<<3|2), uint64(len(value)))
+	return append(encoded, value...)
+}
+
+//protoValue is a field read back out of a message. varints are in number, length delimited
+//fields in bytes, fixed ones aren't needed by anything that reads protobuf here
+type protoValue struct {
+	field  int
+	number uint64
+	bytes  []byte
+}
+
+//protoFields splits a message into its fields, in the order they're in
+func protoFields(message []byte) ([]protoValue, error) {
+	var values []protoValue
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad protobuf field key")
+		}
+		message = message[n:]
+		value := protoValue{field: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			value.number, n = binary.Uvarint(message)
+			if n <= 0 {
+				return nil, fmt.Errorf("bad protobuf varint in field %d", value.field)
+			}
+			message = message[n:]
+		case 2:
+			size, n := binary.Uvarint(message)
+			if n <= 0 || size > uint64(len(message)-n) {
+				return nil, fmt.Errorf("bad protobuf length in field %d", value.field)
+			}
+			value.bytes = message[n : n+int(size)]
+			message = message[n+int(size):]
+		case 1:
+			if len(message) < 8 {
+				return nil, fmt.Errorf("short protobuf fixed64 in field %d", value.field)
+			}
+			message = message[8:]
+		case 5:
+			if len(message) < 4 {
+				return nil, fmt.Errorf("short protobuf fixed32 in field %d", value.field)
+			}
+			message = message[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d in field %d", key&7, value.field)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
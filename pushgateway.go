@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//pushgatewaySink pushes every run's gauges, the ones serve exports, to a prometheus
+//pushgateway, for cron and ci runs that are gone before anything could scrape them. each
+//foundation is its own group under job and instance, and a push replaces the group's last one
+type pushgatewaySink struct {
+	url string
+	job string
+	//instance is the instance grouping label, the foundation when it's empty. with several
+	//foundations and an instance the foundation goes in the grouping key too
+	instance   string
+	foundation string
+	multiple   bool
+}
+
+func (pushgateway pushgatewaySink) name() string {
+	return "pushgateway"
+}
+
+func (pushgateway pushgatewaySink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	var body bytes.Buffer
+	err := runRegistry(pushgateway.foundation, result, timestamp).write(&body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, pushgateway.groupURL(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the pushgateway returned %d: %s", resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+//runRegistry is a registry with just the one run's metrics, for the sinks that push what
+//serve would have had scraped
+func runRegistry(foundation string, result cfclient.CollectionResult, timestamp time.Time) *promRegistry {
+	registry := newPromRegistry()
+	registry.recordCollection(foundation, result)
+	registry.recordRequests(foundation, result.Requests)
+	labels := promLabels("foundation", foundation)
+	registry.setGauge("cf_collection_errors", "orgs/spaces the last collection couldn't fully collect", labels, float64(len(result.Failures)))
+	registry.recordCollectorFailures(foundation, result)
+	registry.setGauge("cf_collection_last_success_timestamp_seconds", "when the last collection finished", labels, float64(timestamp.Unix()))
+	return registry
+}
+
+//groupURL is where the group's metrics go, /metrics/job/<job>/instance/<instance>
+func (pushgateway pushgatewaySink) groupURL() string {
+	group := strings.TrimSuffix(pushgateway.url, "/") + "/metrics/" + groupingPair("job", pushgateway.job)
+	if pushgateway.instance == "" {
+		return group + "/" + groupingPair("instance", pushgateway.foundation)
+	}
+	group += "/" + groupingPair("instance", pushgateway.instance)
+	if pushgateway.multiple {
+		group += "/" + groupingPair("foundation", pushgateway.foundation)
+	}
+	return group
+}
+
+//groupingPair is a grouping label and its value as path segments. values with a slash, or
+//empty ones, go base64 encoded, which the pushgateway takes as label@base64 (= for empty)
+func groupingPair(label string, value string) string {
+	if value == "" {
+		return label + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return label + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return label + "/" + url.PathEscape(value)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//metricRule is one -metric-rule, renaming, prefixing or dropping metrics or their labels the
+//way a metric_relabel_configs would, before any sink sees them
+type metricRule struct {
+	text   string
+	action string
+	//on is the metrics the rule is for, every one when it's nil
+	on *regexp.Regexp
+	//pattern and replacement are a rename's
+	pattern     *regexp.Regexp
+	replacement string
+	//labels are what a drop-label, keep-labels or rename-label is about
+	labels []string
+}
+
+//metricRuleActions are what a rule can do, and what it takes after the action
+var metricRuleActions = map[string]string{
+	"rename":       "<regexp> <replacement>",
+	"prefix":       "<prefix> [on <regexp>]",
+	"drop":         "<regexp>",
+	"drop-label":   "<label> [<label>...] [on <regexp>]",
+	"keep-labels":  "<label> [<label>...] [on <regexp>]",
+	"rename-label": "<label> <new label> [on <regexp>]",
+}
+
+//metricRules are the -metric-rule rules. they're the same for every sink of every
+//foundation, so they're kept here rather than handed down to each sink
+var metricRules metricRulesFlag
+
+//parseMetricRule reads a rule like "rename cf_org_(.*) acme_org_$1", "prefix acme_",
+//"drop cf_cc_api_.*", "drop-label app_guid on cf_app_.*" or "keep-labels foundation org".
+//the regexps match whole metric names
+func parseMetricRule(text string) (metricRule, error) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return metricRule{}, fmt.Errorf("empty metric rule")
+	}
+	rule := metricRule{text: strings.TrimSpace(text), action: words[0]}
+	usage, known := metricRuleActions[rule.action]
+	if !known {
+		var actions []string
+		for action := range metricRuleActions {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		return metricRule{}, fmt.Errorf("metric rule %q: unknown action %s, use one of %s", text, rule.action, strings.Join(actions, ", "))
+	}
+	args := words[1:]
+	if len(args) >= 2 && args[len(args)-2] == "on" && rule.action != "rename" && rule.action != "drop" {
+		var err error
+		rule.on, err = anchoredRegexp(args[len(args)-1])
+		if err != nil {
+			return metricRule{}, fmt.Errorf("metric rule %q: %s", text, err)
+		}
+		args = args[:len(args)-2]
+	}
+	wrong := fmt.Errorf("metric rule %q: %s takes %s", text, rule.action, usage)
+	var err error
+	switch rule.action {
+	case "rename":
+		if len(args) != 2 {
+			return metricRule{}, wrong
+		}
+		rule.pattern, err = anchoredRegexp(args[0])
+		rule.replacement = args[1]
+	case "drop":
+		if len(args) != 1 {
+			return metricRule{}, wrong
+		}
+		rule.pattern, err = anchoredRegexp(args[0])
+	case "prefix":
+		if len(args) != 1 {
+			return metricRule{}, wrong
+		}
+		rule.replacement = args[0]
+	case "rename-label":
+		if len(args) != 2 {
+			return metricRule{}, wrong
+		}
+		rule.labels = args
+	default:
+		if len(args) == 0 {
+			return metricRule{}, wrong
+		}
+		rule.labels = args
+	}
+	if err != nil {
+		return metricRule{}, fmt.Errorf("metric rule %q: %s", text, err)
+	}
+	return rule, nil
+}
+
+func anchoredRegexp(pattern string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("bad regexp %s: %s", pattern, err)
+	}
+	return compiled, nil
+}
+
+//metricRulesFlag is -metric-rule, parsed as they're given so a bad one fails before
+//anything is collected. it's repeated rather than comma separated, a regexp can have commas
+type metricRulesFlag []metricRule
+
+func (rules *metricRulesFlag) String() string {
+	var texts []string
+	for _, rule := range *rules {
+		texts = append(texts, rule.text)
+	}
+	return strings.Join(texts, "; ")
+}
+
+func (rules *metricRulesFlag) Set(value string) error {
+	rule, err := parseMetricRule(value)
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+//apply runs the rules in order over a metric's name and its labels, name, value pairs.
+//false is for a metric a drop rule took out
+func (rules metricRulesFlag) apply(name string, labels []string) (string, []string, bool) {
+	if len(rules) == 0 {
+		return name, labels, true
+	}
+	labels = append([]string{}, labels...)
+	for _, rule := range rules {
+		if rule.on != nil && !rule.on.MatchString(name) {
+			continue
+		}
+		switch rule.action {
+		case "rename":
+			if rule.pattern.MatchString(name) {
+				name = rule.pattern.ReplaceAllString(name, rule.replacement)
+			}
+		case "drop":
+			if rule.pattern.MatchString(name) {
+				return name, nil, false
+			}
+		case "prefix":
+			name = rule.replacement + name
+		case "rename-label":
+			for index := 0; index+1 < len(labels); index += 2 {
+				if labels[index] == rule.labels[0] {
+					labels[index] = rule.labels[1]
+				}
+			}
+		case "drop-label", "keep-labels":
+			var kept []string
+			for index := 0; index+1 < len(labels); index += 2 {
+				if contains(rule.labels, labels[index]) == (rule.action == "keep-labels") {
+					kept = append(kept, labels[index], labels[index+1])
+				}
+			}
+			labels = kept
+		}
+	}
+	return name, labels, true
+}
+
+//applyTags is apply for a tags map
+func (rules metricRulesFlag) applyTags(name string, tags map[string]string) (string, map[string]string, bool) {
+	if len(rules) == 0 {
+		return name, tags, true
+	}
+	var pairs []string
+	for _, key := range sortedKeys(tags) {
+		pairs = append(pairs, key, tags[key])
+	}
+	name, pairs, keep := rules.apply(name, pairs)
+	relabeled := map[string]string{}
+	for index := 0; index+1 < len(pairs); index += 2 {
+		relabeled[pairs[index]] = pairs[index+1]
+	}
+	return name, relabeled, keep
+}
+
+//relabelInfluxLine applies the rules to a line protocol line, the measurement as the name and
+//its tags as the labels. the tags are matched still escaped, the way they're in the line
+func (rules metricRulesFlag) relabelInfluxLine(line string) (string, bool) {
+	if len(rules) == 0 {
+		return line, true
+	}
+	end := influxUnescaped(line, ' ', 0)
+	parts := influxSplit(line[:end], ',')
+	var pairs []string
+	for _, part := range parts[1:] {
+		equals := influxUnescaped(part, '=', 0)
+		if equals == len(part) {
+			continue
+		}
+		pairs = append(pairs, part[:equals], part[equals+1:])
+	}
+	measurement, pairs, keep := rules.apply(parts[0], pairs)
+	if !keep {
+		return "", false
+	}
+	relabeled := strings.NewReplacer(",", `\,`, " ", `\ `).Replace(strings.NewReplacer(`\,`, ",", `\ `, " ").Replace(measurement))
+	for index := 0; index+1 < len(pairs); index += 2 {
+		relabeled += "," + pairs[index] + "=" + pairs[index+1]
+	}
+	return relabeled + line[end:], true
+}
+
+//influxUnescaped is the index of the first separator from start that isn't escaped with a
+//backslash, the end of line when there's none
+func influxUnescaped(line string, separator byte, start int) int {
+	for index := start; index < len(line); index++ {
+		if line[index] == '\\' {
+			index++
+			continue
+		}
+		if line[index] == separator {
+			return index
+		}
+	}
+	return len(line)
+}
+
+func influxSplit(line string, separator byte) []string {
+	var parts []string
+	for start := 0; start <= len(line); {
+		end := influxUnescaped(line, separator, start)
+		parts = append(parts, line[start:end])
+		start = end + 1
+	}
+	return parts
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//remoteWriteSink sends every run's metrics, the ones serve exports, to a prometheus
+//remote_write endpoint like cortex, mimir, thanos receive or victoriametrics, for batch runs
+//with nothing to scrape them. the WriteRequest protobuf and its snappy compression are
+//written by hand, neither is vendored
+type remoteWriteSink struct {
+	url      string
+	headers  map[string]string
+	username string
+	password string
+	//job is the job label every series gets, the way a scrape would have added it
+	job        string
+	foundation string
+}
+
+//remoteWriteAttempts is how many times a push that gets a 5xx or a 429 is tried, which the
+//remote_write spec says are worth retrying. anything else is the request's fault
+const remoteWriteAttempts = 3
+
+//remoteWriteTypes are the MetricMetadata type numbers of the registry's kinds
+var remoteWriteTypes = map[string]uint64{"counter": 1, "gauge": 2, "histogram": 3}
+
+func (remoteWrite remoteWriteSink) name() string {
+	return "remote write"
+}
+
+func (remoteWrite remoteWriteSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	body := snappyEncode(remoteWriteRequest(runRegistry(remoteWrite.foundation, result, timestamp), remoteWrite.job, timestamp))
+	var err error
+	for attempt := 1; attempt <= remoteWriteAttempts; attempt++ {
+		var retry bool
+		retry, err = remoteWrite.push(body)
+		if err == nil || !retry {
+			return err
+		}
+		if attempt < remoteWriteAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	return err
+}
+
+//push sends one WriteRequest, saying whether it's worth sending again when it fails
+func (remoteWrite remoteWriteSink) push(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, remoteWrite.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "cf-metrics")
+	if remoteWrite.username != "" {
+		req.SetBasicAuth(remoteWrite.username, remoteWrite.password)
+	}
+	for key, value := range remoteWrite.headers {
+		req.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := ioutil.ReadAll(resp.Body)
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("remote write endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+	return false, nil
+}
+
+//remoteWriteRequest encodes a WriteRequest with a time series for every sample in the
+//registry, all at timestamp, and the metadata of every family
+func remoteWriteRequest(registry *promRegistry, job string, timestamp time.Time) []byte {
+	var request protoMessage
+	families := map[*promMetric]bool{}
+	var order []*promMetric
+	for _, sample := range registry.samples() {
+		if !families[sample.family] {
+			families[sample.family] = true
+			order = append(order, sample.family)
+		}
+		pairs := append([]string{"__name__", sample.name}, sample.labels...)
+		if job != "" {
+			pairs = append(pairs, "job", job)
+		}
+		var series protoMessage
+		for _, pair := range remoteWriteLabels(pairs) {
+			var label protoMessage
+			label.string(1, pair[0])
+			label.string(2, pair[1])
+			series.message(1, label)
+		}
+		var point protoMessage
+		point.double(1, sample.value)
+		point.varint(2, uint64(timestamp.UnixMilli()))
+		series.message(2, point)
+		request.message(1, series)
+	}
+	for _, family := range order {
+		var metadata protoMessage
+		metadata.varint(1, remoteWriteTypes[family.kind])
+		metadata.string(2, family.name)
+		metadata.string(4, family.help)
+		request.message(3, metadata)
+	}
+	return request
+}
+
+//remoteWriteLabels are name, value pairs sorted by name, which remote_write insists on. a
+//label given twice keeps the first value
+func remoteWriteLabels(pairs []string) [][2]string {
+	var labels [][2]string
+	seen := map[string]bool{}
+	for index := 0; index+1 < len(pairs); index += 2 {
+		if seen[pairs[index]] || pairs[index+1] == "" {
+			continue
+		}
+		seen[pairs[index]] = true
+		labels = append(labels, [2]string{pairs[index], pairs[index+1]})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	return labels
+}
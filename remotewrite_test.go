@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestRemoteWriteRequestBytes(t *testing.T) {
+	registry := newPromRegistry()
+	registry.setGauge("cf_apps", "apps per org", promLabels("org", "payments"), 3)
+	got := remoteWriteRequest(registry, "cf-metrics", time.UnixMilli(1700000000000))
+
+	//worked out by hand from remote.proto and types.proto
+	want, _ := hex.DecodeString("" +
+		//timeseries = 1, 75 bytes
+		"0a4b" +
+		//labels = 1, sorted by name: __name__="cf_apps", job="cf-metrics", org="payments"
+		"0a13" + "0a08" + hex.EncodeToString([]byte("__name__")) + "1207" + hex.EncodeToString([]byte("cf_apps")) +
+		"0a11" + "0a03" + hex.EncodeToString([]byte("job")) + "120a" + hex.EncodeToString([]byte("cf-metrics")) +
+		"0a0f" + "0a03" + hex.EncodeToString([]byte("org")) + "1208" + hex.EncodeToString([]byte("payments")) +
+		//samples = 2: value = 1 as a little endian double 3, timestamp = 2 as a varint
+		"1210" + "09" + "0000000000000840" + "10" + "80d095ffbc31" +
+		//metadata = 3: type = 1 gauge, metric_family_name = 2, help = 4
+		"1a19" + "0802" + "1207" + hex.EncodeToString([]byte("cf_apps")) + "220c" + hex.EncodeToString([]byte("apps per org")))
+	if !bytes.Equal(got, want) {
+		t.Errorf("the write request should be\n% x\ngot\n% x", want, got)
+	}
+
+	fields, err := protoFields(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || fields[0].field != 1 || fields[1].field != 3 {
+		t.Errorf("the write request should read back as a time series and its metadata, got %v", fields)
+	}
+}
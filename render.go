@@ -0,0 +1,250 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+var renderFormats = []string{"markdown", "html"}
+
+//renderData is what report render templates get: every foundation collected, in -foundations
+//order. user templates can use anything in it
+type renderData struct {
+	GeneratedAt time.Time
+	Foundations []renderFoundation
+}
+
+type renderFoundation struct {
+	Name    string
+	Summary cfclient.FoundationSummary
+	//Orgs are biggest reserved memory first
+	Orgs []renderOrg
+	//TopApps are the -n apps reserving the most memory
+	TopApps []rankedApp
+	//EventTypes are every audit event type in Events, Events a row per day of the event
+	//window that had any, oldest first
+	EventTypes []string
+	Events     []renderEventDay
+	Failures   []string
+}
+
+type renderOrg struct {
+	Name             string
+	Spaces           int
+	Apps             int
+	Instances        int
+	ReservedMemoryMB int
+	//Quota is nil when the org has no quota
+	Quota *cfclient.QuotaUsage
+}
+
+type renderEventDay struct {
+	Day    string
+	Counts map[string]int
+	Total  int
+}
+
+func newRenderFoundation(foundation string, result cfclient.CollectionResult, n int) renderFoundation {
+	rendered := renderFoundation{
+		Name:     foundation,
+		Summary:  cfclient.SummarizeFoundation(result.Orgs, result.Spaces),
+		Failures: []string{},
+	}
+	spaces := map[string]int{}
+	for _, space := range result.Spaces {
+		spaces[space.OrganizationGUID]++
+	}
+	days := map[string]*renderEventDay{}
+	types := map[string]bool{}
+	for _, org := range result.Orgs {
+		instances, memory := cfclient.AppTotals(org.Apps)
+		rendered.Orgs = append(rendered.Orgs, renderOrg{
+			Name:             org.Name,
+			Spaces:           spaces[org.GUID],
+			Apps:             len(org.Apps),
+			Instances:        instances,
+			ReservedMemoryMB: memory,
+			Quota:            org.Quota,
+		})
+		//org event queries cover their spaces' events too
+		for _, event := range org.Events {
+			day := event.Timestamp.UTC().Format("2006-01-02")
+			if days[day] == nil {
+				days[day] = &renderEventDay{Day: day, Counts: map[string]int{}}
+			}
+			days[day].Counts[event.Type]++
+			days[day].Total++
+			types[event.Type] = true
+		}
+	}
+	sort.SliceStable(rendered.Orgs, func(i, j int) bool {
+		if rendered.Orgs[i].ReservedMemoryMB != rendered.Orgs[j].ReservedMemoryMB {
+			return rendered.Orgs[i].ReservedMemoryMB > rendered.Orgs[j].ReservedMemoryMB
+		}
+		return rendered.Orgs[i].Name < rendered.Orgs[j].Name
+	})
+	for eventType := range types {
+		rendered.EventTypes = append(rendered.EventTypes, eventType)
+	}
+	sort.Strings(rendered.EventTypes)
+	for _, day := range days {
+		rendered.Events = append(rendered.Events, *day)
+	}
+	sort.Slice(rendered.Events, func(i, j int) bool {
+		return rendered.Events[i].Day < rendered.Events[j].Day
+	})
+
+	top := topReport{By: "memory"}
+	top.add(foundation, result)
+	top.rank(n)
+	rendered.TopApps = top.Apps
+	for _, failure := range result.Failures {
+		rendered.Failures = append(rendered.Failures, failure.Error())
+	}
+	return rendered
+}
+
+//renderFuncs are the functions templates can call besides the built in ones
+var renderFuncs = map[string]interface{}{
+	"percent": func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 1, 64) + "%"
+	},
+	"gb": func(mb int) string {
+		return strconv.FormatFloat(float64(mb)/1024, 'f', 1, 64)
+	},
+	"limit": func(limit int) string {
+		if limit < 0 {
+			return "unlimited"
+		}
+		return strconv.Itoa(limit)
+	},
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"join": strings.Join,
+}
+
+//renderReport fills in the markdown or html template, the built in one unless templatePath
+//is set. html templates escape what they print, markdown ones don't
+func renderReport(out io.Writer, format string, templatePath string, data renderData) error {
+	text := markdownReportTemplate
+	name := "report"
+	if format == "html" {
+		text = htmlReportTemplate
+	}
+	if templatePath != "" {
+		contents, err := ioutil.ReadFile(templatePath)
+		if err != nil {
+			return err
+		}
+		text, name = string(contents), filepath.Base(templatePath)
+	}
+	if format == "html" {
+		tmpl, err := htmltemplate.New(name).Funcs(renderFuncs).Parse(text)
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(out, data)
+	}
+	tmpl, err := texttemplate.New(name).Funcs(renderFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(out, data)
+}
+
+const markdownReportTemplate = `# capacity report
+
+generated {{date .GeneratedAt}}
+{{range .Foundations}}
+## {{.Name}}
+
+| orgs | spaces | apps | instances | reserved memory (GB) | service instances |
+|---:|---:|---:|---:|---:|---:|
+| {{.Summary.Orgs}} | {{.Summary.Spaces}} | {{.Summary.Apps}} | {{.Summary.DesiredInstances}} | {{gb .Summary.ReservedMemoryMB}} | {{.Summary.ServiceInstances}} |
+
+### orgs and quota utilization
+
+| org | spaces | apps | instances | reserved memory (GB) | quota | memory used | instances used | routes used |
+|---|---:|---:|---:|---:|---|---:|---:|---:|
+{{range .Orgs}}| {{.Name}} | {{.Spaces}} | {{.Apps}} | {{.Instances}} | {{gb .ReservedMemoryMB}} | {{if .Quota}}{{.Quota.Quota.Name}} | {{percent .Quota.MemoryPercent}} of {{limit .Quota.Quota.MemoryLimitMB}} MB | {{percent .Quota.InstancesPercent}} of {{limit .Quota.Quota.InstanceLimit}} | {{percent .Quota.RoutesPercent}} of {{limit .Quota.Quota.RouteLimit}}{{else}} | | | {{end}} |
+{{end}}
+### top apps by reserved memory
+
+| org | space | app | reserved memory (MB) |
+|---|---|---|---:|
+{{range .TopApps}}| {{.Org}} | {{.Space}} | {{.Name}} | {{.Value}} |
+{{end}}
+### audit events per day
+{{if .Events}}
+| day | total |{{range .EventTypes}} {{.}} |{{end}}
+|---|---:|{{range .EventTypes}}---:|{{end}}
+{{$types := .EventTypes}}{{range .Events}}{{$day := .}}| {{.Day}} | {{.Total}} |{{range $types}} {{index $day.Counts .}} |{{end}}
+{{end}}{{else}}
+no audit events in the window.
+{{end}}{{if .Failures}}
+### collection failures
+
+these parts of the foundation couldn't be collected, so the numbers above may be low:
+{{range .Failures}}
+- {{.}}{{end}}
+{{end}}{{end}}`
+
+const htmlReportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>capacity report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; }
+th { background: #f0f0f0; text-align: left; }
+td.n { text-align: right; }
+.hot { color: #b00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>capacity report</h1>
+<p>generated {{date .GeneratedAt}}</p>
+{{range .Foundations}}
+<h2>{{.Name}}</h2>
+<table>
+<tr><th>orgs</th><th>spaces</th><th>apps</th><th>instances</th><th>reserved memory (GB)</th><th>service instances</th></tr>
+<tr><td class="n">{{.Summary.Orgs}}</td><td class="n">{{.Summary.Spaces}}</td><td class="n">{{.Summary.Apps}}</td><td class="n">{{.Summary.DesiredInstances}}</td><td class="n">{{gb .Summary.ReservedMemoryMB}}</td><td class="n">{{.Summary.ServiceInstances}}</td></tr>
+</table>
+
+<h3>orgs and quota utilization</h3>
+<table>
+<tr><th>org</th><th>spaces</th><th>apps</th><th>instances</th><th>reserved memory (GB)</th><th>quota</th><th>memory used</th><th>instances used</th><th>routes used</th></tr>
+{{range .Orgs}}<tr><td>{{.Name}}</td><td class="n">{{.Spaces}}</td><td class="n">{{.Apps}}</td><td class="n">{{.Instances}}</td><td class="n">{{gb .ReservedMemoryMB}}</td>
+{{if .Quota}}<td>{{.Quota.Quota.Name}}</td><td class="n{{if ge .Quota.MemoryPercent 90.0}} hot{{end}}">{{percent .Quota.MemoryPercent}} of {{limit .Quota.Quota.MemoryLimitMB}} MB</td><td class="n{{if ge .Quota.InstancesPercent 90.0}} hot{{end}}">{{percent .Quota.InstancesPercent}} of {{limit .Quota.Quota.InstanceLimit}}</td><td class="n{{if ge .Quota.RoutesPercent 90.0}} hot{{end}}">{{percent .Quota.RoutesPercent}} of {{limit .Quota.Quota.RouteLimit}}</td>{{else}}<td></td><td></td><td></td><td></td>{{end}}</tr>
+{{end}}</table>
+
+<h3>top apps by reserved memory</h3>
+<table>
+<tr><th>org</th><th>space</th><th>app</th><th>reserved memory (MB)</th></tr>
+{{range .TopApps}}<tr><td>{{.Org}}</td><td>{{.Space}}</td><td>{{.Name}}</td><td class="n">{{.Value}}</td></tr>
+{{end}}</table>
+
+<h3>audit events per day</h3>
+{{if .Events}}<table>
+<tr><th>day</th><th>total</th>{{range .EventTypes}}<th>{{.}}</th>{{end}}</tr>
+{{$types := .EventTypes}}{{range .Events}}{{$day := .}}<tr><td>{{.Day}}</td><td class="n">{{.Total}}</td>{{range $types}}<td class="n">{{index $day.Counts .}}</td>{{end}}</tr>
+{{end}}</table>{{else}}<p>no audit events in the window.</p>{{end}}
+{{if .Failures}}<h3>collection failures</h3>
+<p>these parts of the foundation couldn't be collected, so the numbers above may be low:</p>
+<ul>{{range .Failures}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{end}}
+</body>
+</html>
+`
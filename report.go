@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+	"github.com/aanelli/cf-metrics/pkg/snapshot"
+)
+
+//outputFormats are the formats -output knows how to write
+var outputFormats = []string{"json", "csv", "table"}
+
+func newTimedReport(foundation string, result cfclient.CollectionResult, timestamp time.Time) snapshot.Timed {
+	return snapshot.Timed{Timestamp: timestamp, Report: newRunReport(foundation, result)}
+}
+
+func newReportRow(org string, space string, datapoint cfclient.Data) snapshot.Row {
+	instances, memory := cfclient.AppTotals(datapoint.Apps)
+	return snapshot.Row{
+		Org:              org,
+		Space:            space,
+		GUID:             datapoint.GUID,
+		Apps:             len(datapoint.Apps),
+		Instances:        instances,
+		ReservedMemoryMB: memory,
+		ServiceInstances: len(datapoint.ServiceInstances),
+		Routes:           len(datapoint.Routes),
+		Events:           eventCounts(datapoint.Events),
+	}
+}
+
+func newRunReport(foundation string, result cfclient.CollectionResult) snapshot.Report {
+	report := snapshot.Report{
+		SchemaVersion:     snapshot.SchemaVersion,
+		Name:              foundation,
+		Foundation:        cfclient.SummarizeFoundation(result.Orgs, result.Spaces),
+		Failures:          []string{},
+		Collectors:        result.Collectors,
+		CollectorFailures: result.CollectorFailures(),
+	}
+	if len(result.Forbidden) > 0 {
+		report.CollectorForbidden = result.CollectorForbidden()
+	}
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		report.Orgs = append(report.Orgs, newReportRow(org.Name, "", org))
+	}
+	for _, space := range result.Spaces {
+		report.Spaces = append(report.Spaces, newReportRow(orgNames[space.OrganizationGUID], space.Name, space))
+	}
+	for _, failure := range result.Failures {
+		report.Failures = append(report.Failures, failure.Error())
+	}
+	return report
+}
+
+//writeReport writes the rollup of a run against foundation to out as json, csv or a text table
+func writeReport(out io.Writer, format string, foundation string, result cfclient.CollectionResult) error {
+	report := newRunReport(foundation, result)
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return writeRows(out, format, reportRows(report))
+}
+
+//writeRows writes a header and rows as csv or a text table
+func writeRows(out io.Writer, format string, rows [][]string) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(out)
+		for _, row := range rows {
+			err := writer.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "table":
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+	return fmt.Errorf("unknown output format %s, use one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+//listedOrg, listedSpace and listedEvent are what the orgs, spaces and events commands print
+type listedOrg struct {
+	Foundation string `json:"foundation"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+}
+
+type listedSpace struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	OrgGUID    string `json:"org_guid"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+}
+
+type listedApp struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	cfclient.SpaceApp
+}
+
+type listedEvent struct {
+	Foundation string `json:"foundation"`
+	cfclient.Event
+}
+
+//writeListing prints list as json, or its rows as csv or a table
+func writeListing(out io.Writer, format string, list interface{}, rows [][]string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	}
+	return writeRows(out, format, rows)
+}
+
+//reportRows flattens the orgs and spaces into a header plus one row each, with a column per
+//event type seen anywhere in the run
+func reportRows(report snapshot.Report) [][]string {
+	eventTypes := map[string]bool{}
+	for _, row := range append(append([]snapshot.Row{}, report.Orgs...), report.Spaces...) {
+		for eventType := range row.Events {
+			eventTypes[eventType] = true
+		}
+	}
+	var sortedTypes []string
+	for eventType := range eventTypes {
+		sortedTypes = append(sortedTypes, eventType)
+	}
+	sort.Strings(sortedTypes)
+
+	rows := [][]string{append([]string{"foundation", "kind", "org", "space", "guid", "apps", "instances", "reserved_memory_mb", "service_instances", "routes"}, sortedTypes...)}
+	addRows := func(kind string, reportRows []snapshot.Row) {
+		for _, row := range reportRows {
+			line := []string{report.Name, kind, row.Org, row.Space, row.GUID,
+				strconv.Itoa(row.Apps), strconv.Itoa(row.Instances), strconv.Itoa(row.ReservedMemoryMB),
+				strconv.Itoa(row.ServiceInstances), strconv.Itoa(row.Routes)}
+			for _, eventType := range sortedTypes {
+				line = append(line, strconv.Itoa(row.Events[eventType]))
+			}
+			rows = append(rows, line)
+		}
+	}
+	addRows("org", report.Orgs)
+	addRows("space", report.Spaces)
+	return rows
+}
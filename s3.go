@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+var s3Formats = []string{"json", "csv"}
+
+//s3Sink writes every run's snapshot as an object to an s3 compatible bucket, at
+//<prefix>/<foundation>/<timestamp>.<format>. json is what -output json prints plus a
+//timestamp, csv what -output csv prints. objects are only ever added, never overwritten
+type s3Sink struct {
+	bucket     string
+	prefix     string
+	formats    []string
+	foundation string
+	//endpoint is empty for aws, which gets virtual hosted style urls. anything else, e.g.
+	//minio or gcs, gets path style ones
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func (s3 s3Sink) name() string {
+	return "s3 bucket " + s3.bucket
+}
+
+func (s3 s3Sink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	for _, format := range s3.formats {
+		var body bytes.Buffer
+		contentType := "text/csv"
+		if format == "json" {
+			contentType = "application/json"
+			encoder := json.NewEncoder(&body)
+			encoder.SetIndent("", "  ")
+			err := encoder.Encode(newTimedReport(s3.foundation, result, timestamp))
+			if err != nil {
+				return err
+			}
+		} else {
+			err := writeReport(&body, "csv", s3.foundation, result)
+			if err != nil {
+				return err
+			}
+		}
+		key := strings.Trim(s3.prefix+"/"+s3.foundation+"/"+timestamp.UTC().Format("20060102T150405Z")+"."+format, "/")
+		err := s3.put(key, contentType, body.Bytes(), timestamp)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+func (s3 s3Sink) put(key string, contentType string, body []byte, now time.Time) error {
+	host := s3.bucket + ".s3." + s3.region + ".amazonaws.com"
+	path := "/" + key
+	scheme := "https"
+	if s3.endpoint != "" {
+		endpoint, err := url.Parse(s3.endpoint)
+		if err != nil {
+			return fmt.Errorf("could not parse the s3 endpoint: %s", err)
+		}
+		scheme, host = endpoint.Scheme, endpoint.Host
+		path = strings.TrimSuffix(endpoint.Path, "/") + "/" + s3.bucket + "/" + key
+	}
+	req, err := http.NewRequest(http.MethodPut, scheme+"://"+host+s3EscapePath(path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"host": host, "content-type": contentType}
+	if s3.sessionToken != "" {
+		headers["x-amz-security-token"] = s3.sessionToken
+	}
+	s3.sign(req, headers, body, now)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("s3 returned %d: %s", resp.StatusCode, string(answer))
+	}
+	return nil
+}
+
+//sign adds the headers and an aws signature v4 authorization for them to req
+func (s3 s3Sink) sign(req *http.Request, headers map[string]string, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	headers["x-amz-content-sha256"] = hex.EncodeToString(payloadHash[:])
+	headers["x-amz-date"] = now.UTC().Format("20060102T150405Z")
+	date := now.UTC().Format("20060102")
+	scope := date + "/" + s3.region + "/s3/aws4_request"
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, headers["x-amz-content-sha256"]}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + headers["x-amz-date"] + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + s3.secretKey)
+	for _, part := range []string{date, s3.region, "s3", "aws4_request"} {
+		key = hmacSum(key, part)
+	}
+	signature := hex.EncodeToString(hmacSum(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSum(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+//s3EscapePath escapes everything in a path but its slashes and what aws calls unreserved
+//characters, which is how signature v4 wants s3 object keys
+func s3EscapePath(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if b == '/' || b == '-' || b == '_' || b == '.' || b == '~' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//daemonSchedule is when a daemon collects: every so often, or at the times of a cron expression
+type daemonSchedule interface {
+	//first is when the first collection is due for a daemon starting at now
+	first(now time.Time) time.Time
+	//next is when the collection after the one due at due is
+	next(due time.Time) time.Time
+	String() string
+}
+
+//intervalSchedule collects right away and then every interval after the last one was due
+type intervalSchedule time.Duration
+
+func (every intervalSchedule) first(now time.Time) time.Time {
+	return now
+}
+
+func (every intervalSchedule) next(due time.Time) time.Time {
+	return due.Add(time.Duration(every))
+}
+
+func (every intervalSchedule) String() string {
+	return "every " + time.Duration(every).String()
+}
+
+//cronSchedule collects at the minutes matching a cron expression, in the local time zone ($TZ).
+//each field is a bitset of the values it matches
+type cronSchedule struct {
+	spec                              string
+	minute, hour, day, month, weekday uint64
+	//anyDay and anyWeekday are a day of the month or week starting with *, like * or */2. with
+	//neither cron runs on the days matching either, like it does
+	anyDay, anyWeekday bool
+}
+
+//cronDescriptors are the @ shorthands cron has
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var cronWeekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+//parseSchedule reads a cron expression, minute hour day-of-month month day-of-week, e.g.
+//"0 2 * * *" for 02:00 every day, one of the @daily style shorthands or "@every 5m"
+func parseSchedule(spec string) (daemonSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q, @every takes a positive duration like 5m", spec)
+		}
+		return intervalSchedule(every), nil
+	}
+	expression := spec
+	if descriptor, found := cronDescriptors[strings.ToLower(spec)]; found {
+		expression = descriptor
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, a cron expression is minute hour day-of-month month day-of-week", spec)
+	}
+	schedule := cronSchedule{spec: spec, anyDay: strings.HasPrefix(fields[2], "*"), anyWeekday: strings.HasPrefix(fields[4], "*")}
+	var err error
+	for index, field := range []struct {
+		bits     *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&schedule.minute, 0, 59, nil},
+		{&schedule.hour, 0, 23, nil},
+		{&schedule.day, 1, 31, nil},
+		{&schedule.month, 1, 12, cronMonths},
+		{&schedule.weekday, 0, 7, cronWeekdays},
+	} {
+		*field.bits, err = cronField(fields[index], field.min, field.max, field.names)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+	}
+	//7 is sunday as well as 0
+	if schedule.weekday&(1<<7) != 0 {
+		schedule.weekday |= 1
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q, it never comes round", spec)
+	}
+	return schedule, nil
+}
+
+//cronField is the values a field's comma separated *, n, n-m, names and /steps match
+func cronField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("%q has no step", part)
+			}
+			part = part[:slash]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = cronValue(bounds[0], names)
+			if err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = cronValue(bounds[1], names)
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				//n/step is every step from n on
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q isn't within %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(value string, names map[string]int) (int, error) {
+	if number, found := names[strings.ToLower(value)]; found {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a number", value)
+	}
+	return number, nil
+}
+
+func (schedule cronSchedule) first(now time.Time) time.Time {
+	return schedule.next(now)
+}
+
+//next is the first matching minute after due, the zero time when there's none in the next
+//five years (a 30th of february)
+func (schedule cronSchedule) next(due time.Time) time.Time {
+	at := due.Truncate(time.Minute).Add(time.Minute)
+	limit := due.AddDate(5, 0, 0)
+	for at.Before(limit) {
+		switch {
+		case schedule.month&(1<<uint(at.Month())) == 0:
+			at = time.Date(at.Year(), at.Month()+1, 1, 0, 0, 0, 0, at.Location())
+		case !schedule.matchesDay(at):
+			at = time.Date(at.Year(), at.Month(), at.Day()+1, 0, 0, 0, 0, at.Location())
+		case schedule.hour&(1<<uint(at.Hour())) == 0:
+			at = time.Date(at.Year(), at.Month(), at.Day(), at.Hour()+1, 0, 0, 0, at.Location())
+		case schedule.minute&(1<<uint(at.Minute())) == 0:
+			at = at.Add(time.Minute)
+		default:
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+func (schedule cronSchedule) matchesDay(at time.Time) bool {
+	day := schedule.day&(1<<uint(at.Day())) != 0
+	weekday := schedule.weekday&(1<<uint(at.Weekday())) != 0
+	if schedule.anyDay || schedule.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func (schedule cronSchedule) String() string {
+	return schedule.spec
+}
+
+//nextDue is when the collection after the one due at due should run. the times a collection
+//ran past are skipped rather than run back to back, and counted
+func nextDue(schedule daemonSchedule, due time.Time, now time.Time) (time.Time, int) {
+	due = schedule.next(due)
+	skipped := 0
+	for !due.IsZero() && !due.After(now) {
+		due = schedule.next(due)
+		skipped++
+	}
+	return due, skipped
+}
+
+//scheduleGap is about the longest a schedule goes between collections, over its next few
+func scheduleGap(schedule daemonSchedule, now time.Time) time.Duration {
+	if schedule == nil {
+		return 0
+	}
+	longest := time.Duration(0)
+	due := schedule.first(now)
+	for count := 0; count < 8; count++ {
+		next := schedule.next(due)
+		longest = max(longest, next.Sub(due))
+		due = next
+	}
+	return longest
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRefusesBadSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"61 * * * *",
+		"* 24 * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * smarch *",
+		"0 0 30 2 *",
+		"@every",
+		"@every -5m",
+		"@fortnightly",
+	} {
+		_, err := parseSchedule(spec)
+		if err == nil {
+			t.Errorf("%q should be refused", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	//a monday
+	monday := time.Date(2026, 3, 2, 10, 7, 0, 0, time.UTC)
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		spec string
+		due  time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", monday, at(2, 10, 15)},
+		{"5/20 * * * *", at(2, 10, 30), at(2, 10, 45)},
+		{"0,30 9-17/4 * * *", monday, at(2, 13, 0)},
+		{"0 9 * * mon-fri", time.Date(2026, 3, 6, 10, 0, 0, 0, time.UTC), at(9, 9, 0)},
+		{"0 0 1 JAN *", monday, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", monday, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", monday, at(2, 11, 0)},
+		//7 is sunday as well as 0
+		{"0 0 * * 7", monday, at(8, 0, 0)},
+		//with both restricted a day matching either will do: the next friday comes before the 13th
+		{"0 0 13 * fri", at(14, 0, 0), at(20, 0, 0)},
+		//a day of the month starting with * only narrows the weekdays: the odd days that are
+		//mondays, not every odd day and every monday
+		{"0 0 */2 * mon", monday, at(9, 0, 0)},
+		{"0 0 1-31 * mon", monday, at(3, 0, 0)},
+		{"0 0 * * */2", monday, at(3, 0, 0)},
+		{"@every 5m", monday, at(2, 10, 12)},
+	} {
+		schedule, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		if got := schedule.next(test.due); !got.Equal(test.want) {
+			t.Errorf("%q after %s should be %s, got %s", test.spec, test.due, test.want, got)
+		}
+	}
+}
+
+func TestNextDueSkipsWhatARunOverran(t *testing.T) {
+	due := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		spec    string
+		now     time.Time
+		want    time.Time
+		skipped int
+	}{
+		{"*/5 * * * *", due.Add(2 * time.Minute), due.Add(5 * time.Minute), 0},
+		{"*/5 * * * *", due.Add(17 * time.Minute), due.Add(20 * time.Minute), 3},
+		//one that finishes right as the next is due runs it, not the one after
+		{"*/5 * * * *", due.Add(5*time.Minute - time.Second), due.Add(5 * time.Minute), 0},
+		{"@every 10m", due.Add(25 * time.Minute), due.Add(30 * time.Minute), 2},
+	} {
+		schedule, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, skipped := nextDue(schedule, due, test.now)
+		if !got.Equal(test.want) || skipped != test.skipped {
+			t.Errorf("%q with a run finishing at %s should be due at %s skipping %d, got %s skipping %d", test.spec, test.now, test.want, test.skipped, got, skipped)
+		}
+	}
+}
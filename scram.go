@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+//scramClient is the client side of a SCRAM-SHA-256 or SCRAM-SHA-512 exchange (rfc 5802 and
+//7677), for postgres and kafka. postgres ignores the user name in it, it already has it from
+//the startup message
+type scramClient struct {
+	user            string
+	password        string
+	hash            func() hash.Hash
+	nonce           string
+	clientFirstBare string
+	authMessage     string
+	saltedPassword  []byte
+}
+
+func newScramClient(user string, password string, hash func() hash.Hash) (*scramClient, error) {
+	raw := make([]byte, 18)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &scramClient{user: user, password: password, hash: hash, nonce: base64.RawStdEncoding.EncodeToString(raw)}, nil
+}
+
+func (scram *scramClient) clientFirst() string {
+	//, and = are the only characters a scram user name escapes
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(scram.user)
+	scram.clientFirstBare = "n=" + user + ",r=" + scram.nonce
+	return "n,," + scram.clientFirstBare
+}
+
+//clientFinal answers the server's first message, r=<nonce>,s=<salt>,i=<iterations>
+func (scram *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attribute := range strings.Split(serverFirst, ",") {
+		switch {
+		case strings.HasPrefix(attribute, "r="):
+			nonce = attribute[2:]
+		case strings.HasPrefix(attribute, "s="):
+			salt = attribute[2:]
+		case strings.HasPrefix(attribute, "i="):
+			iterations, _ = strconv.Atoi(attribute[2:])
+		}
+	}
+	if !strings.HasPrefix(nonce, scram.nonce) || iterations <= 0 {
+		return "", fmt.Errorf("the server sent a bad scram challenge")
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("the server sent a bad scram salt: %s", err)
+	}
+	scram.saltedPassword, err = pbkdf2.Key(scram.hash, scram.password, saltBytes, iterations, scram.hash().Size())
+	if err != nil {
+		return "", err
+	}
+
+	withoutProof := "c=biws,r=" + nonce
+	scram.authMessage = scram.clientFirstBare + "," + serverFirst + "," + withoutProof
+	clientKey := scram.hmac(scram.saltedPassword, "Client Key")
+	storedKey := scram.hash()
+	storedKey.Write(clientKey)
+	signature := scram.hmac(storedKey.Sum(nil), scram.authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+//verifyServer checks the server's signature, v=<signature>, so we know it had the password too
+func (scram *scramClient) verifyServer(serverFinal string) error {
+	if scram == nil {
+		return fmt.Errorf("the server finished a scram exchange that never started")
+	}
+	serverKey := scram.hmac(scram.saltedPassword, "Server Key")
+	expected := base64.StdEncoding.EncodeToString(scram.hmac(serverKey, scram.authMessage))
+	if strings.TrimPrefix(serverFinal, "v=") != expected {
+		return fmt.Errorf("the server's scram signature doesn't match, it may not be who it says it is")
+	}
+	return nil
+}
+
+func (scram *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(scram.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
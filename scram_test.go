@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+//the SCRAM-SHA-256 exchange in rfc 7677 section 3
+func TestScramRFC7677(t *testing.T) {
+	scram := &scramClient{user: "user", password: "pencil", hash: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if first := scram.clientFirst(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("the client first message should be n,,n=user,r=rOprNGfwEbeRWgbNEkqO, got %s", first)
+	}
+	final, err := scram.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != want {
+		t.Errorf("the client final message should be\n%s\ngot\n%s", want, final)
+	}
+	err = scram.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	if err != nil {
+		t.Errorf("the rfc's server signature should check out, got %s", err)
+	}
+	err = scram.verifyServer("v=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+	if err == nil {
+		t.Error("a server signature that isn't the rfc's should fail")
+	}
+}
+
+func TestScramRefusesAChallengeForAnotherNonce(t *testing.T) {
+	scram := &scramClient{user: "user", password: "pencil", hash: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	scram.clientFirst()
+	_, err := scram.clientFinal("r=someoneElsesNonce%hvYDpWUa2RaTCAfuxFIlj,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err == nil {
+		t.Error("a server nonce that doesn't start with ours should be refused")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//runServiceReport writes the service consumption report for month (YYYY-MM) to
+//./output/service-consumption-<month>.csv. with several foundations each gets its own report,
+//./output/service-consumption-<month>-<foundation>.csv, and one failing doesn't stop the rest
+func runServiceReport(foundations []foundation, month string) error {
+	from, err := time.Parse("2006-01", month)
+	if err != nil {
+		return fmt.Errorf("-service-report wants a month like 2006-01, got %s", month)
+	}
+	until := from.AddDate(0, 1, 0)
+
+	err = os.MkdirAll("output", 0755)
+	if err != nil {
+		return fmt.Errorf("error making the output folder %s", err)
+	}
+	var failed []string
+	for _, target := range foundations {
+		slog.Info("walking service usage events", "foundation", target.name, "month", month)
+		report, err := target.client.ServiceConsumption(context.Background(), from, until)
+		if err != nil {
+			slog.Error("error collecting service usage events", "foundation", target.name, "err", err)
+			failed = append(failed, target.name)
+			continue
+		}
+
+		fileName := "./output/service-consumption-" + month + ".csv"
+		if len(foundations) > 1 {
+			fileName = foundationPath(fileName, target.name)
+		}
+		err = printServiceConsumptionCSV(fileName, target.name, report)
+		if err != nil {
+			return fmt.Errorf("error writing service consumption csv %s", err)
+		}
+		slog.Info("wrote the service consumption report", "foundation", target.name, "file", fileName, "lines", len(report))
+	}
+	if len(failed) == len(foundations) {
+		return fmt.Errorf("could not collect the service usage events of %s", strings.Join(failed, ", "))
+	}
+	if len(failed) > 0 {
+		return partialError{fmt.Sprintf("service consumption reports for %d of %d foundations were not written: %s", len(failed), len(foundations), strings.Join(failed, ", "))}
+	}
+	return nil
+}
+
+func printServiceConsumptionCSV(fileName string, foundation string, report []cfclient.ServiceConsumption) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"org_name", "org_guid", "broker", "service", "plan", "creates", "deletes", "instance_hours", "foundation"})
+	if err != nil {
+		return err
+	}
+	for _, line := range report {
+		err = writer.Write([]string{
+			line.OrgName,
+			line.OrgGUID,
+			line.Broker,
+			line.Service,
+			line.Plan,
+			strconv.Itoa(line.Creates),
+			strconv.Itoa(line.Deletes),
+			strconv.FormatFloat(line.InstanceHours, 'f', 2, 64),
+			foundation,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
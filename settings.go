@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//settingsEnvPrefix is what every flag's environment variable starts with, -influx-url is
+//CF_METRICS_INFLUX_URL
+const settingsEnvPrefix = "CF_METRICS_"
+
+//cliConfigKeys are the keys of a cf cli style -config file (see cfclient.CLIConfig), in yaml
+//and in the cli's own json spelling, lower cased
+var cliConfigKeys = []string{
+	"target", "uaaendpoint", "uaaclient", "uaaoauthclient", "uaaclientsecret", "uaaoauthclientsecret",
+	"accesstoken", "refreshtoken", "skipsslvalidation", "ssldisabled",
+}
+
+//parseFlags parses a command's flags from, in order of precedence, the command line, the
+//CF_METRICS_* environment and the -config file. a flag set in one of them is left alone by
+//the ones after it
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		envName := settingsEnvPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		value, found := os.LookupEnv(envName)
+		if err != nil || set[f.Name] || !found {
+			return
+		}
+		set[f.Name] = true
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %s", value, envName, setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	configFlag := flags.Lookup("config")
+	if configFlag == nil || configFlag.Value.String() == "" {
+		return nil
+	}
+	path := configFlag.Value.String()
+	settings, err := loadSettings(path)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := flags.Lookup(key)
+		//one file serves every command, so keys for other commands' flags are fine
+		if f == nil || set[key] || key == "config" {
+			continue
+		}
+		for _, value := range settings[key] {
+			err = f.Value.Set(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s in %s: %s", value, key, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+//loadSettings reads a -config file into flag name -> values. nested keys are joined with
+//dashes, so influx: {url: ...} is -influx-url, and lists give a value per item. files ending
+//in .json are json, anything else is read as yaml
+func loadSettings(path string) (map[string][]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config %s: %s", path, err)
+	}
+	var parsed interface{}
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		err = json.Unmarshal(raw, &parsed)
+	} else {
+		err = yaml.Unmarshal(raw, &parsed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config %s: %s", path, err)
+	}
+	settings := map[string][]string{}
+	err = flattenSettings(settings, "", parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", path, err)
+	}
+	return settings, nil
+}
+
+func flattenSettings(settings map[string][]string, prefix string, value interface{}) error {
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case map[interface{}]interface{}:
+		for key, nested := range typed {
+			err := flattenSettings(settings, joinSettingKey(prefix, fmt.Sprint(key)), nested)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for key, nested := range typed {
+			err := flattenSettings(settings, joinSettingKey(prefix, key), nested)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for _, item := range typed {
+			switch item.(type) {
+			case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+				//no flag takes these, e.g. the PluginRepos of a cf cli config.json
+				continue
+			}
+			settings[prefix] = append(settings[prefix], settingValue(item))
+		}
+		return nil
+	}
+	if prefix == "" {
+		return fmt.Errorf("expected a map of settings")
+	}
+	settings[prefix] = append(settings[prefix], settingValue(value))
+	return nil
+}
+
+//settingValue is a plain value the way it would be typed as a flag. json numbers are all
+//float64, which fmt would print as 1e+06
+func settingValue(value interface{}) string {
+	if number, isFloat := value.(float64); isFloat {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func joinSettingKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "-" + key
+}
+
+//hasCLIConfig is true when a -config file also has cf cli style target and token settings
+//that the client should load, rather than only flag settings
+func hasCLIConfig(path string) bool {
+	settings, err := loadSettings(path)
+	if err != nil {
+		return true
+	}
+	for key := range settings {
+		if contains(cliConfigKeys, strings.ToLower(key)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//sink is somewhere the counts from a collection run get pushed to after the files are written.
+//any number of them can be on at once, every run goes to each in turn
+type sink interface {
+	//name is what to call the sink in error messages
+	name() string
+	write(result cfclient.CollectionResult, timestamp time.Time) error
+}
+
+//closingSink is a sink that keeps something going between runs, e.g. graphite resending the
+//latest run, and has to be flushed and stopped when cf-metrics is done with it
+type closingSink interface {
+	sink
+	//flush sends whatever the sink is holding on to now
+	flush() error
+	close() error
+}
+
+//sinkError is the sinks a run couldn't be written to. the collection itself worked, so it
+//doesn't count against the foundation's circuit breaker
+type sinkError struct {
+	failed []string
+}
+
+func (err sinkError) Error() string {
+	return "could not write to " + strings.Join(err.failed, ", ")
+}
+
+//collectionError is err unless all that failed was writing to sinks, or some orgs and spaces
+func collectionError(err error) error {
+	if partial(err) {
+		return nil
+	}
+	return err
+}
+
+//writeSinks writes a run to every sink. one failing is logged and doesn't stop the rest from
+//getting the run, the error only says which failed
+func writeSinks(log *slog.Logger, sinks []sink, result cfclient.CollectionResult, timestamp time.Time) error {
+	var failed []string
+	for _, out := range sinks {
+		err := out.write(result, timestamp)
+		if err != nil {
+			log.Error("error writing to "+out.name(), "err", err)
+			failed = append(failed, out.name())
+		}
+	}
+	if len(failed) > 0 {
+		return sinkError{failed: failed}
+	}
+	return nil
+}
+
+//closeSinks flushes and closes every sink that needs it, logging the ones that fail
+func closeSinks(runs []foundationRun) {
+	for _, run := range runs {
+		for _, out := range run.options.sinks {
+			closing, isClosing := out.(closingSink)
+			if !isClosing {
+				continue
+			}
+			err := closing.flush()
+			if err == nil {
+				err = closing.close()
+			}
+			if err != nil {
+				slog.Warn("error closing "+out.name(), "foundation", run.options.foundation, "err", err)
+			}
+		}
+	}
+}
+
+//appCrash is how often one app crashed during a run's event window
+type appCrash struct {
+	guid    string
+	name    string
+	crashes int
+}
+
+//spaceAppCrashes lists the apps in space that crashed, most crashes first
+func spaceAppCrashes(space cfclient.Data) []appCrash {
+	names := map[string]string{}
+	for _, app := range space.Apps {
+		names[app.Metadata.GUID] = cfclient.EntityString(app, "name")
+	}
+	//an app deleted since it crashed is only named in its events
+	for _, event := range space.Events {
+		if cfclient.IsCrashEvent(event.Type) && names[event.Actee] == "" {
+			names[event.Actee] = event.ActeeName
+		}
+	}
+	var crashes []appCrash
+	for guid, count := range cfclient.AppCrashes(space.Events) {
+		crashes = append(crashes, appCrash{guid: guid, name: names[guid], crashes: count})
+	}
+	sort.Slice(crashes, func(i, j int) bool {
+		if crashes[i].crashes != crashes[j].crashes {
+			return crashes[i].crashes > crashes[j].crashes
+		}
+		return crashes[i].name < crashes[j].name
+	})
+	return crashes
+}
+
+//envVarCount is how many environment variables a space's apps set between them
+func envVarCount(space cfclient.Data) int {
+	count := 0
+	for _, env := range space.EnvVars {
+		count += len(env.Names)
+	}
+	return count
+}
+
+//foundationName is the default foundation tag, the api host with any "api." taken off
+func foundationName(apiURL string) string {
+	parsed, err := url.Parse(apiURL)
+	if err != nil || parsed.Hostname() == "" {
+		return apiURL
+	}
+	return strings.TrimPrefix(parsed.Hostname(), "api.")
+}
+
+//scopeTags are the tags that say whose a metric point is. a point has foundation, and org and
+//space for an org's or space's metrics
+var scopeTags = map[string]bool{"foundation": true, "org": true, "space": true}
+
+//seriesTags tell apart the series of one metric of an org or space, e.g. the event type of
+//events. the rest of the tags, like isolation_segment, describe the org or space, and the
+//sinks that name series by path alone leave them out. in the order they go in a flat name
+var seriesTags = []string{"event_type", "stack", "buildpack"}
+
+//metricPoint is one value from a run: a metric name, the tags that say what it's of, and when
+//it was collected. every sink that stores its metrics the same way renders these, so a new
+//dimension only has to be added here
+type metricPoint struct {
+	name      string
+	tags      map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+//scope is whose point it is, space, org or foundation
+func (point metricPoint) scope() string {
+	switch {
+	case point.tags["space"] != "":
+		return "space"
+	case point.tags["org"] != "":
+		return "org"
+	}
+	return "foundation"
+}
+
+//flatName is the name with the values of any series tags after it, e.g. events.audit.app.create
+//or stack_apps.cflinuxfs4, for the sinks that have no tags
+func (point metricPoint) flatName() string {
+	return strings.Join(append([]string{point.name}, point.series()...), ".")
+}
+
+//series are the values of the point's series tags, in seriesTags order
+func (point metricPoint) series() []string {
+	var values []string
+	for _, key := range seriesTags {
+		if value, tagged := point.tags[key]; tagged {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+//dimensions are the point's tags besides foundation, org and space, sorted by key
+func (point metricPoint) dimensions() [][2]string {
+	var dimensions [][2]string
+	for _, key := range sortedKeys(point.tags) {
+		if !scopeTags[key] {
+			dimensions = append(dimensions, [2]string{key, point.tags[key]})
+		}
+	}
+	return dimensions
+}
+
+//metricPoints flattens a run into the org and space counts the other sinks send, plus the
+//foundation's collection errors, api requests and service catalog totals, for the sinks that store every metric the
+//same way. org and space points are tagged with their isolation segment and -label-tags
+//labels, and audit events, stacks and buildpacks are a point per event type, stack or
+//buildpack
+func metricPoints(foundation string, result cfclient.CollectionResult, timestamp time.Time) []metricPoint {
+	var points []metricPoint
+	add := func(tags map[string]string, name string, value float64) {
+		merged := map[string]string{"foundation": foundation}
+		for key, value := range tags {
+			if value != "" {
+				merged[key] = value
+			}
+		}
+		points = append(points, metricPoint{name: name, tags: merged, value: value, timestamp: timestamp})
+	}
+	addCounts := func(tags map[string]string, counts map[string]int) {
+		for _, name := range sortedKeys(counts) {
+			add(tags, name, float64(counts[name]))
+		}
+	}
+	addDimension := func(tags map[string]string, name string, key string, counts map[string]int) {
+		for _, value := range sortedKeys(counts) {
+			dimensionTags := map[string]string{key: value}
+			for tag, tagValue := range tags {
+				dimensionTags[tag] = tagValue
+			}
+			add(dimensionTags, name, float64(counts[value]))
+		}
+	}
+	describe := func(tags map[string]string, data cfclient.Data) map[string]string {
+		tags["isolation_segment"] = data.IsolationSegment
+		for key, value := range data.Labels {
+			tags["label_"+key] = value
+		}
+		return tags
+	}
+
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		tags := describe(map[string]string{"org": org.Name}, org)
+		instances, memory := cfclient.AppTotals(org.Apps)
+		addCounts(tags, map[string]int{
+			"apps":               len(org.Apps),
+			"instances":          instances,
+			"reserved_memory_mb": memory,
+			"reserved_disk_mb":   org.Usage.ReservedDiskMB,
+			"running_instances":  org.Usage.RunningInstances,
+			"running_memory_mb":  org.Usage.RunningMemoryMB,
+			"app_creates":        len(org.AppCreates),
+			"app_starts":         len(org.AppStarts),
+			"app_updates":        len(org.AppUpdates),
+			"space_creates":      len(org.SpaceCreates),
+			"app_crashes":        len(org.AppCrashes),
+			"service_instances":  len(org.ServiceInstances),
+		})
+		addDimension(tags, "events", "event_type", eventCounts(org.Events))
+		addDimension(tags, "stack_apps", "stack", org.StackCounts)
+		addDimension(tags, "buildpack_apps", "buildpack", org.BuildpackCounts)
+	}
+	for _, space := range result.Spaces {
+		tags := describe(map[string]string{"org": orgNames[space.OrganizationGUID], "space": space.Name}, space)
+		instances, memory := cfclient.AppTotals(space.Apps)
+		counts := map[string]int{
+			"apps":               len(space.Apps),
+			"instances":          instances,
+			"reserved_memory_mb": memory,
+			"reserved_disk_mb":   space.Usage.ReservedDiskMB,
+			"running_instances":  space.Usage.RunningInstances,
+			"running_memory_mb":  space.Usage.RunningMemoryMB,
+			"app_creates":        len(space.AppCreates),
+			"app_starts":         len(space.AppStarts),
+			"app_updates":        len(space.AppUpdates),
+			"app_crashes":        len(space.AppCrashes),
+			"service_instances":  len(space.ServiceInstances),
+			"service_bindings":   serviceBindings(space),
+			"routes":             len(space.Routes),
+			"orphaned_routes":    len(cfclient.OrphanedRoutes(space.Routes)),
+		}
+		if result.EnvScanned {
+			apps, suspicious := cfclient.SuspiciousEnvApps(space)
+			counts["env_vars"] = envVarCount(space)
+			counts["suspicious_env_vars"] = suspicious
+			counts["suspicious_env_apps"] = apps
+		}
+		if result.SSHCollected && space.SSHAllowed != nil {
+			counts["ssh_allowed"] = boolInt(*space.SSHAllowed)
+			counts["ssh_apps"] = len(space.SSHApps)
+		}
+		if contains(result.Collectors, "user-provided-services") {
+			summary := cfclient.SummarizeUserProvided(space)
+			counts["user_provided_services"] = summary.Instances
+			counts["syslog_drain_apps"] = summary.DrainedApps
+			counts["route_service_apps"] = summary.RouteServiceApps
+		}
+		if result.ProcessesCollected {
+			for _, summary := range cfclient.SummarizeProcesses(space) {
+				counts["processes."+summary.Type+".instances"] = summary.Instances
+				counts["processes."+summary.Type+".memory_mb"] = summary.MemoryMB
+			}
+			counts["sidecars"] = len(space.Sidecars)
+		}
+		addCounts(tags, counts)
+		addDimension(tags, "events", "event_type", eventCounts(space.Events))
+		addDimension(tags, "stack_apps", "stack", space.StackCounts)
+		addDimension(tags, "buildpack_apps", "buildpack", space.BuildpackCounts)
+	}
+	add(nil, "collection_errors", float64(len(result.Failures)))
+	if contains(result.Collectors, "catalog") {
+		add(nil, "catalog.plans", float64(len(result.ServiceCatalog.Plans)))
+		add(nil, "catalog.public_plans", float64(len(result.ServiceCatalog.PublicPlans())))
+		add(nil, "catalog.unused_plans", float64(len(result.ServiceCatalog.UnusedPlans())))
+		add(nil, "catalog.service_brokers", float64(len(result.ServiceCatalog.Brokers)))
+	}
+	for _, endpoint := range result.Requests {
+		prefix := "cc_api." + endpointMetricName(endpoint.Endpoint) + "."
+		add(nil, prefix+"requests", float64(endpoint.Requests))
+		add(nil, prefix+"errors", float64(endpoint.Errors))
+		add(nil, prefix+"retries", float64(endpoint.Retries))
+		add(nil, prefix+"error_rate", endpoint.ErrorRate())
+		add(nil, prefix+"latency_mean_seconds", endpoint.MeanLatency().Seconds())
+		add(nil, prefix+"latency_p95_seconds", endpoint.Quantile(0.95).Seconds())
+	}
+	var relabeled []metricPoint
+	for _, point := range points {
+		var keep bool
+		point.name, point.tags, keep = metricRules.applyTags(point.name, point.tags)
+		if keep {
+			relabeled = append(relabeled, point)
+		}
+	}
+	return relabeled
+}
+
+//endpointMetricName is an api endpoint as one name segment, e.g. v3_spaces_guid_features for
+///v3/spaces/:guid/features
+func endpointMetricName(endpoint string) string {
+	name := strings.NewReplacer("/", "_", ":", "").Replace(strings.Trim(endpoint, "/"))
+	if name == "" {
+		return "root"
+	}
+	return name
+}
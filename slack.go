@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//slackPostMessage is the web api method a bot token posts with
+const slackPostMessage = "https://slack.com/api/chat.postMessage"
+
+//slackDigestOrgs is how many of the biggest orgs the digest lists
+const slackDigestOrgs = 5
+
+//slackClient posts to slack through an incoming webhook, or as a bot with a token and a
+//channel. an incoming webhook already knows its channel
+type slackClient struct {
+	webhook string
+	token   string
+	channel string
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color,omitempty"`
+	Title    string       `json:"title"`
+	Fallback string       `json:"fallback"`
+	Fields   []slackField `json:"fields"`
+	Footer   string       `json:"footer,omitempty"`
+	Ts       int64        `json:"ts,omitempty"`
+	//MrkdwnIn are the parts of the attachment slack formats *bold* and `code` in
+	MrkdwnIn []string `json:"mrkdwn_in,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (slack slackClient) name() string {
+	return "slack"
+}
+
+//send posts an alert as a red attachment with what fired and where
+func (slack slackClient) send(fired alert) error {
+	fields := []slackField{{Title: "foundation", Value: fired.Foundation, Short: true}}
+	for _, field := range [][2]string{{"org", fired.Org}, {"space", fired.Space}, {"app", fired.App}, {"service instance", fired.ServiceInstance}} {
+		if field[1] != "" {
+			fields = append(fields, slackField{Title: field[0], Value: field[1], Short: true})
+		}
+	}
+	fields = append(fields,
+		slackField{Title: fired.Metric, Value: strconv.FormatFloat(fired.Value, 'f', -1, 64), Short: true},
+		slackField{Title: "rule", Value: "`" + fired.Rule + "`", Short: true})
+	return slack.post(slackMessage{
+		Text: ":rotating_light: " + fired.Text,
+		Attachments: []slackAttachment{{
+			Color:    "danger",
+			Title:    "alert " + fired.Rule + " fired",
+			Fallback: fired.Text,
+			Fields:   fields,
+			Footer:   "cf-metrics",
+			Ts:       fired.FiredAt.Unix(),
+			MrkdwnIn: []string{"fields"},
+		}},
+	})
+}
+
+func (slack slackClient) post(message slackMessage) error {
+	address := slack.webhook
+	if address == "" {
+		address = slackPostMessage
+		message.Channel = slack.channel
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if slack.webhook == "" {
+		req.Header.Set("Authorization", "Bearer "+slack.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("slack returned %d: %s", resp.StatusCode, string(body))
+	}
+	//the web api always answers 200 and says in the body whether it worked, a webhook just
+	//answers ok
+	if slack.webhook == "" {
+		var answer struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		err = json.Unmarshal(body, &answer)
+		if err != nil {
+			return fmt.Errorf("could not read slack's answer: %s", err)
+		}
+		if !answer.OK {
+			return fmt.Errorf("slack refused the message: %s", answer.Error)
+		}
+	}
+	return nil
+}
+
+//slackDigestSink posts a summary of a foundation to slack once a day, with the first run
+//at or after the time of day it's set to. when it last posted is only kept in memory, so
+//a collect that isn't on -interval posts every time it runs after that time
+type slackDigestSink struct {
+	client     slackClient
+	at         clockFlag
+	foundation string
+	//posted is the day each foundation's digest last went out
+	posted *sync.Map
+}
+
+func (digest slackDigestSink) name() string {
+	return "slack digest"
+}
+
+func (digest slackDigestSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	due := digest.at.on(timestamp)
+	if timestamp.Before(due) {
+		return nil
+	}
+	if last, posted := digest.posted.Load(digest.foundation); posted && !last.(time.Time).Before(due) {
+		return nil
+	}
+	err := digest.client.post(slackDigest(digest.foundation, result, timestamp))
+	if err != nil {
+		return err
+	}
+	digest.posted.Store(digest.foundation, timestamp)
+	return nil
+}
+
+//slackDigest is the foundation's totals and its biggest orgs by reserved memory
+func slackDigest(foundation string, result cfclient.CollectionResult, timestamp time.Time) slackMessage {
+	summary := cfclient.SummarizeFoundation(result.Orgs, result.Spaces)
+	crashes := 0
+	for _, org := range result.Orgs {
+		crashes += len(org.AppCrashes)
+	}
+	short := func(title string, value int) slackField {
+		return slackField{Title: title, Value: strconv.Itoa(value), Short: true}
+	}
+	totals := slackAttachment{
+		Color:    "good",
+		Title:    "totals",
+		Fallback: fmt.Sprintf("%d orgs, %d spaces, %d apps, %d instances, %d MB reserved", summary.Orgs, summary.Spaces, summary.Apps, summary.DesiredInstances, summary.ReservedMemoryMB),
+		Fields: []slackField{
+			short("orgs", summary.Orgs),
+			short("spaces", summary.Spaces),
+			short("apps", summary.Apps),
+			short("instances", summary.DesiredInstances),
+			short("reserved memory mb", summary.ReservedMemoryMB),
+			short("service instances", summary.ServiceInstances),
+			short("app crashes", crashes),
+			short("collection errors", len(result.Failures)),
+		},
+		Footer: "cf-metrics",
+		Ts:     timestamp.Unix(),
+	}
+	if len(result.Failures) > 0 {
+		totals.Color = "warning"
+	}
+
+	orgs := newRunReport(foundation, result).Orgs
+	sort.SliceStable(orgs, func(i, j int) bool {
+		return orgs[i].ReservedMemoryMB > orgs[j].ReservedMemoryMB
+	})
+	var lines []string
+	for _, org := range orgs[:min(slackDigestOrgs, len(orgs))] {
+		lines = append(lines, fmt.Sprintf("*%s* %d MB, %d apps, %d instances", org.Org, org.ReservedMemoryMB, org.Apps, org.Instances))
+	}
+	message := slackMessage{
+		Text:        fmt.Sprintf(":bar_chart: daily cf-metrics digest for *%s*", foundation),
+		Attachments: []slackAttachment{totals},
+	}
+	if len(lines) > 0 {
+		message.Attachments = append(message.Attachments, slackAttachment{
+			Title:    "biggest orgs by reserved memory",
+			Fallback: strings.Join(lines, "\n"),
+			Fields:   []slackField{{Value: strings.Join(lines, "\n")}},
+			MrkdwnIn: []string{"fields"},
+		})
+	}
+	return message
+}
@@ -0,0 +1,60 @@
+package main
+
+import "encoding/binary"
+
+//snappyEncode compresses src in snappy's block format, the one remote_write bodies are in
+//(not the framed stream format). there's no snappy vendored, so it's a plain greedy matcher
+//over 4 byte hashes: nowhere near as quick as the real thing, but a run's request is small
+//and the labels repeat enough that it still squeezes them a lot
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	//table is where each hash of 4 bytes was last seen, plus one so 0 is nowhere
+	var table [1 << 14]int
+	literal := 0
+	for at := 0; at+4 <= len(src); {
+		hash := (binary.LittleEndian.Uint32(src[at:]) * 0x1e35a7bd) >> 18
+		candidate := table[hash] - 1
+		table[hash] = at + 1
+		//copies reach back at most 64k, the most a two byte offset holds
+		if candidate < 0 || at-candidate > 0xffff || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[at:]) {
+			at++
+			continue
+		}
+		length := 4
+		for at+length < len(src) && src[candidate+length] == src[at+length] {
+			length++
+		}
+		dst = snappyLiteral(dst, src[literal:at])
+		for remaining := length; remaining > 0; {
+			//a copy is at most 64 long
+			chunk := min(remaining, 64)
+			dst = append(dst, byte(chunk-1)<<2|2, byte(at-candidate), byte((at-candidate)>>8))
+			remaining -= chunk
+		}
+		at += length
+		literal = at
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+//snappyLiteral appends bytes as they are, the length in the tag when it's under 61 and in
+//the bytes after it otherwise
+func snappyLiteral(dst []byte, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	length := len(literal) - 1
+	switch {
+	case length < 60:
+		dst = append(dst, byte(length)<<2)
+	case length < 1<<8:
+		dst = append(dst, 60<<2, byte(length))
+	case length < 1<<16:
+		dst = append(dst, 61<<2, byte(length), byte(length>>8))
+	case length < 1<<24:
+		dst = append(dst, 62<<2, byte(length), byte(length>>8), byte(length>>16))
+	default:
+		dst = append(dst, 63<<2, byte(length), byte(length>>8), byte(length>>16), byte(length>>24))
+	}
+	return append(dst, literal...)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// snappyDecode is the reference block format decoder from snappy's format_description.txt,
+// every tag snappyEncode could write and the 1 and 4 byte offset copies it doesn't
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, fmt.Errorf("bad length")
+	}
+	src = src[n:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case 0:
+			size := int(tag>>2) + 1
+			src = src[1:]
+			if extra := int(tag>>2) - 59; extra > 0 {
+				if len(src) < extra {
+					return nil, fmt.Errorf("short literal length")
+				}
+				size = 1
+				for index := 0; index < extra; index++ {
+					size += int(src[index]) << (8 * index)
+				}
+				src = src[extra:]
+			}
+			if len(src) < size {
+				return nil, fmt.Errorf("short literal")
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		}
+		var size, offset int
+		switch tag & 3 {
+		case 1:
+			if len(src) < 2 {
+				return nil, fmt.Errorf("short copy")
+			}
+			size, offset = int(tag>>2&7)+4, int(tag>>5)<<8|int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, fmt.Errorf("short copy")
+			}
+			size, offset = int(tag>>2)+1, int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, fmt.Errorf("short copy")
+			}
+			size, offset = int(tag>>2)+1, int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, fmt.Errorf("copy from %d back with %d bytes written", offset, len(dst))
+		}
+		//a copy can overlap what it writes, so it goes a byte at a time
+		for index := 0; index < size; index++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("decoded %d bytes, the header says %d", len(dst), length)
+	}
+	return dst, nil
+}
+
+func TestSnappyKnownBlocks(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []byte
+	}{
+		{"", []byte{0}},
+		{"hello", []byte{5, 4 << 2, 'h', 'e', 'l', 'l', 'o'}},
+		//a literal of the first 4, then a copy of the 16 after it from 4 back
+		{"abcdabcdabcdabcdabcd", []byte{20, 3 << 2, 'a', 'b', 'c', 'd', 15<<2 | 2, 4, 0}},
+	} {
+		got := snappyEncode([]byte(test.in))
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%q should encode to % x, got % x", test.in, test.want, got)
+		}
+	}
+}
+
+func TestSnappyRoundTrips(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 100000)
+	random.Read(noise)
+	labels := strings.Repeat(`cf_app_instances{foundation="prod",org="payments",space="prod",app="api"} 3`+"\n", 2000)
+	for name, in := range map[string][]byte{
+		"empty":                nil,
+		"short":                []byte("abc"),
+		"noise":                noise,
+		"repeating labels":     []byte(labels),
+		"a run longer than 64": bytes.Repeat([]byte("x"), 1000),
+		"a literal over 64k":   noise[:70000],
+		"copies past 64k back": append(append(append([]byte{}, noise[:200]...), noise[:70000]...), noise[:200]...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded := snappyEncode(in)
+			decoded, err := snappyDecode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, in) {
+				t.Fatalf("%d bytes came back as %d different ones", len(in), len(decoded))
+			}
+		})
+	}
+	if encoded := snappyEncode([]byte(labels)); len(encoded) > len(labels)/10 {
+		t.Errorf("repeating labels should squeeze to a tenth at most, %d bytes went to %d", len(labels), len(encoded))
+	}
+}
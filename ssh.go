@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//sshRow is a space report ssh prints, or one of its apps when App is set
+type sshRow struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	SpaceGUID  string `json:"space_guid"`
+	App        string `json:"app,omitempty"`
+	AppGUID    string `json:"app_guid,omitempty"`
+	//Allowed is whether the space allows ssh, for an app row it's always true
+	Allowed bool `json:"ssh_allowed"`
+	//Apps is how many of a space's apps can be ssh'd into
+	Apps int `json:"ssh_apps"`
+}
+
+type sshReport struct {
+	Rows []sshRow `json:"rows"`
+	//SpacesAllowed and Apps are the totals across every foundation
+	SpacesAllowed int `json:"spaces_allowed"`
+	Spaces        int `json:"spaces"`
+	Apps          int `json:"apps"`
+}
+
+//add adds a run's spaces that allow ssh and their apps that have it on, every space with all
+func (report *sshReport) add(foundation string, result cfclient.CollectionResult, all bool) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		if space.SSHAllowed == nil {
+			continue
+		}
+		report.Spaces++
+		report.Apps += len(space.SSHApps)
+		if *space.SSHAllowed {
+			report.SpacesAllowed++
+		} else if !all {
+			continue
+		}
+		row := sshRow{Foundation: foundation, Org: orgNames[space.OrganizationGUID], Space: space.Name, SpaceGUID: space.GUID, Allowed: *space.SSHAllowed, Apps: len(space.SSHApps)}
+		report.Rows = append(report.Rows, row)
+		for _, app := range space.SSHApps {
+			appRow := row
+			appRow.App, appRow.AppGUID, appRow.Apps = app.AppName, app.AppGUID, 0
+			report.Rows = append(report.Rows, appRow)
+		}
+	}
+}
+
+//sort lists the rows by foundation, org and space, each space before its apps
+func (report *sshReport) sort() {
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Foundation+"/"+a.Org+"/"+a.Space != b.Foundation+"/"+b.Org+"/"+b.Space {
+			return a.Foundation+"/"+a.Org+"/"+a.Space < b.Foundation+"/"+b.Org+"/"+b.Space
+		}
+		return a.App < b.App
+	})
+	if report.Rows == nil {
+		report.Rows = []sshRow{}
+	}
+}
+
+func (report sshReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "app", "ssh_allowed", "ssh_apps"}}
+	for _, row := range report.Rows {
+		apps := strconv.Itoa(row.Apps)
+		if row.App != "" {
+			apps = ""
+		}
+		rows = append(rows, []string{row.Foundation, row.Org, row.Space, row.App, strconv.FormatBool(row.Allowed), apps})
+	}
+	return writeListing(out, format, report, rows)
+}
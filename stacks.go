@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//stackRow is how many apps of an org, space or whole foundation run on one stack
+type stackRow struct {
+	Foundation string `json:"foundation"`
+	//Kind is foundation, org or space
+	Kind  string `json:"kind"`
+	Org   string `json:"org,omitempty"`
+	Space string `json:"space,omitempty"`
+	Stack string `json:"stack"`
+	Apps  int    `json:"apps"`
+	//Percent is the share of the org, space or foundation's apps on the stack
+	Percent float64 `json:"percent"`
+}
+
+type stackReport struct {
+	Stacks []stackRow `json:"stacks"`
+}
+
+//add adds a run's stack counts, only for stack when it isn't empty. orgs and spaces without
+//any apps on the stack are left out
+func (report *stackReport) add(foundation string, result cfclient.CollectionResult, stack string) {
+	addCounts := func(kind string, org string, space string, counts map[string]int) {
+		total := 0
+		for _, count := range counts {
+			total += count
+		}
+		var stacks []string
+		for name := range counts {
+			stacks = append(stacks, name)
+		}
+		sort.Strings(stacks)
+		for _, name := range stacks {
+			if (stack != "" && name != stack) || counts[name] == 0 {
+				continue
+			}
+			report.Stacks = append(report.Stacks, stackRow{
+				Foundation: foundation,
+				Kind:       kind,
+				Org:        org,
+				Space:      space,
+				Stack:      name,
+				Apps:       counts[name],
+				Percent:    100 * float64(counts[name]) / float64(total),
+			})
+		}
+	}
+
+	var orgCounts []map[string]int
+	for _, org := range result.Orgs {
+		orgCounts = append(orgCounts, org.StackCounts)
+	}
+	addCounts("foundation", "", "", cfclient.SumCounts(orgCounts...))
+	orgNames := map[string]string{}
+	for _, org := range sortedByName(result.Orgs) {
+		orgNames[org.GUID] = org.Name
+		addCounts("org", org.Name, "", org.StackCounts)
+	}
+	for _, space := range sortedByName(result.Spaces) {
+		addCounts("space", orgNames[space.OrganizationGUID], space.Name, space.StackCounts)
+	}
+	if report.Stacks == nil {
+		report.Stacks = []stackRow{}
+	}
+}
+
+func sortedByName(dataList []cfclient.Data) []cfclient.Data {
+	sorted := append([]cfclient.Data{}, dataList...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func (report stackReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "kind", "org", "space", "stack", "apps", "percent"}}
+	for _, row := range report.Stacks {
+		rows = append(rows, []string{row.Foundation, row.Kind, row.Org, row.Space, row.Stack, strconv.Itoa(row.Apps), strconv.FormatFloat(row.Percent, 'f', 1, 64)})
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//staleApp is an app report stale prints, one nobody has pushed, restarted or changed lately
+type staleApp struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+	State      string `json:"state"`
+	Instances  int    `json:"instances"`
+	//ReservedMemoryMB is what the app is holding of its org's quota, instances * memory
+	ReservedMemoryMB int       `json:"reserved_memory_mb"`
+	LastActivity     time.Time `json:"last_activity"`
+	DaysIdle         int       `json:"days_idle"`
+}
+
+type staleReport struct {
+	Days int        `json:"days"`
+	Apps []staleApp `json:"apps"`
+}
+
+//add adds the apps of a run nothing has happened to since cutoff. on v3 an app that looks
+//stale gets one more call for its droplet, since v3 apps don't say when they were last pushed
+func (report *staleReport) add(ctx context.Context, target foundation, result cfclient.CollectionResult, cutoff time.Time) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		for _, app := range space.Apps {
+			last := cfclient.AppLastActivity(app, space.Events)
+			if !last.Before(cutoff) {
+				continue
+			}
+			staged, err := target.client.AppLastStaged(ctx, app.Metadata.GUID)
+			if err != nil {
+				slog.Warn("couldn't get an app's droplet, going by its other timestamps", "foundation", target.name, "app", cfclient.EntityString(app, "name"), "err", err)
+			}
+			if staged.After(last) {
+				last = staged
+			}
+			if !last.Before(cutoff) {
+				continue
+			}
+			report.Apps = append(report.Apps, staleApp{
+				Foundation:       target.name,
+				Org:              orgNames[space.OrganizationGUID],
+				Space:            space.Name,
+				Name:             cfclient.EntityString(app, "name"),
+				GUID:             app.Metadata.GUID,
+				State:            cfclient.EntityString(app, "state"),
+				Instances:        cfclient.EntityInt(app, "instances"),
+				ReservedMemoryMB: cfclient.EntityInt(app, "instances") * cfclient.EntityInt(app, "memory"),
+				LastActivity:     last,
+				DaysIdle:         int(time.Since(last).Hours() / 24),
+			})
+		}
+	}
+}
+
+//sort puts the longest idle apps first, ties by name so the same foundation lists the same
+//way every time
+func (report *staleReport) sort() {
+	sort.SliceStable(report.Apps, func(i, j int) bool {
+		a, b := report.Apps[i], report.Apps[j]
+		if !a.LastActivity.Equal(b.LastActivity) {
+			return a.LastActivity.Before(b.LastActivity)
+		}
+		return a.Org+"/"+a.Space+"/"+a.Name < b.Org+"/"+b.Space+"/"+b.Name
+	})
+	if report.Apps == nil {
+		report.Apps = []staleApp{}
+	}
+}
+
+func (report staleReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "app", "state", "instances", "reserved_memory_mb", "last_activity", "days_idle"}}
+	for _, app := range report.Apps {
+		rows = append(rows, []string{app.Foundation, app.Org, app.Space, app.Name, app.State,
+			strconv.Itoa(app.Instances), strconv.Itoa(app.ReservedMemoryMB),
+			app.LastActivity.UTC().Format(time.RFC3339), strconv.Itoa(app.DaysIdle)})
+	}
+	return writeListing(out, format, report, rows)
+}
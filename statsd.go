@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//statsdPacketSize keeps packets under a typical ethernet mtu so they don't get fragmented
+const statsdPacketSize = 1432
+
+//statsdNameUnsafe matches everything that can't go in a statsd metric name segment
+var statsdNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+//statsdSink sends org and space counts as gauges, and a counter per run and per failure,
+//over udp. with datadog tags on, org and space are tags, otherwise they're part of the name
+//e.g. cf_metrics.space.<org>.<space>.apps
+type statsdSink struct {
+	address    string
+	prefix     string
+	datadog    bool
+	foundation string
+	//foundationInName puts the foundation right after the prefix when it isn't a tag, so
+	//several foundations sending to the same statsd don't collide
+	foundationInName bool
+}
+
+func (statsd statsdSink) name() string {
+	return "statsd"
+}
+
+func (statsd statsdSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	return statsd.send(statsd.lines(result))
+}
+
+//writeTrend sends the growth trend as gauges, growth.<day|week>.orgs_created etc.
+func (statsd statsdSink) writeTrend(trend growthTrend, timestamp time.Time) error {
+	var lines []string
+	for _, window := range growthWindows {
+		fields := trend.window(window).fields()
+		for _, name := range sortedKeys(fields) {
+			lines = append(lines, statsd.line([]string{"growth", window}, name, fields[name], "g"))
+		}
+	}
+	return statsd.send(lines)
+}
+
+//buildLines are a space's or buildpack's builds per state and p50 and p95 staging times
+func (statsd statsdSink) buildLines(scope []string, summary cfclient.BuildSummary) []string {
+	var lines []string
+	for _, state := range cfclient.BuildStateList {
+		lines = append(lines, statsd.line(append(append([]string{}, scope...), "builds"), strings.ToLower(state), summary.States[state], "g"))
+	}
+	return append(lines,
+		statsd.valueLine(scope, "build_p50_seconds", strconv.FormatFloat(summary.P50.Seconds(), 'f', -1, 64), "g"),
+		statsd.valueLine(scope, "build_p95_seconds", strconv.FormatFloat(summary.P95.Seconds(), 'f', -1, 64), "g"),
+	)
+}
+
+//send writes lines out, as many to a packet as fit
+func (statsd statsdSink) send(lines []string) error {
+	conn, err := net.Dial("udp", statsd.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet []string
+	size := 0
+	for _, line := range lines {
+		//a -metric-rule dropped it
+		if line == "" {
+			continue
+		}
+		if size+len(line)+1 > statsdPacketSize && len(packet) > 0 {
+			_, err = conn.Write([]byte(strings.Join(packet, "\n")))
+			if err != nil {
+				return err
+			}
+			packet, size = nil, 0
+		}
+		packet = append(packet, line)
+		size += len(line) + 1
+	}
+	if len(packet) > 0 {
+		_, err = conn.Write([]byte(strings.Join(packet, "\n")))
+	}
+	return err
+}
+
+//lines renders every metric in the statsd wire format
+func (statsd statsdSink) lines(result cfclient.CollectionResult) []string {
+	var lines []string
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+		instances, memory := cfclient.AppTotals(org.Apps)
+		scope := []string{"org", org.Name}
+		lines = append(lines,
+			statsd.line(scope, "apps", len(org.Apps), "g"),
+			statsd.line(scope, "instances", instances, "g"),
+			statsd.line(scope, "reserved_memory_mb", memory, "g"),
+			statsd.line(scope, "reserved_disk_mb", org.Usage.ReservedDiskMB, "g"),
+			statsd.line(scope, "running_instances", org.Usage.RunningInstances, "g"),
+			statsd.line(scope, "running_memory_mb", org.Usage.RunningMemoryMB, "g"),
+			statsd.line(scope, "app_creates", len(org.AppCreates), "g"),
+			statsd.line(scope, "app_starts", len(org.AppStarts), "g"),
+			statsd.line(scope, "app_updates", len(org.AppUpdates), "g"),
+			statsd.line(scope, "space_creates", len(org.SpaceCreates), "g"),
+			statsd.line(scope, "app_crashes", len(org.AppCrashes), "g"),
+		)
+		orgEvents := eventCounts(org.Events)
+		for _, eventType := range sortedKeys(orgEvents) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "event", eventType), "events", orgEvents[eventType], "g"))
+		}
+		for _, role := range sortedKeys(org.RoleCounts) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "role", role), "users", org.RoleCounts[role], "g"))
+		}
+		for _, stack := range sortedKeys(org.StackCounts) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "stack", stack), "apps", org.StackCounts[stack], "g"))
+		}
+		for _, plan := range cfclient.CountByPlan(org.ServiceInstances, time.Now()) {
+			planScope := append(append([]string{}, scope...), "service", tagValue(plan.ServiceName), "plan", tagValue(plan.PlanName))
+			lines = append(lines,
+				statsd.line(planScope, "instances", plan.Instances, "g"),
+				statsd.line(planScope, "bindings", plan.Bindings, "g"),
+				statsd.line(planScope, "stuck", plan.Stuck, "g"),
+			)
+		}
+	}
+	for _, space := range result.Spaces {
+		instances, memory := cfclient.AppTotals(space.Apps)
+		scope := []string{"org", tagValue(orgNames[space.OrganizationGUID]), "space", space.Name}
+		lines = append(lines,
+			statsd.line(scope, "apps", len(space.Apps), "g"),
+			statsd.line(scope, "instances", instances, "g"),
+			statsd.line(scope, "reserved_memory_mb", memory, "g"),
+			statsd.line(scope, "reserved_disk_mb", space.Usage.ReservedDiskMB, "g"),
+			statsd.line(scope, "running_instances", space.Usage.RunningInstances, "g"),
+			statsd.line(scope, "running_memory_mb", space.Usage.RunningMemoryMB, "g"),
+			statsd.line(scope, "app_creates", len(space.AppCreates), "g"),
+			statsd.line(scope, "app_starts", len(space.AppStarts), "g"),
+			statsd.line(scope, "app_updates", len(space.AppUpdates), "g"),
+			statsd.line(scope, "service_bindings", serviceBindings(space), "g"),
+			statsd.line(scope, "app_crashes", len(space.AppCrashes), "g"),
+			statsd.line(scope, "routes", len(space.Routes), "g"),
+			statsd.line(scope, "orphaned_routes", len(cfclient.OrphanedRoutes(space.Routes)), "g"),
+		)
+		spaceEvents := eventCounts(space.Events)
+		for _, eventType := range sortedKeys(spaceEvents) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "event", eventType), "events", spaceEvents[eventType], "g"))
+		}
+		for _, role := range sortedKeys(space.RoleCounts) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "role", role), "users", space.RoleCounts[role], "g"))
+		}
+		for _, stack := range sortedKeys(space.StackCounts) {
+			lines = append(lines, statsd.line(append(append([]string{}, scope...), "stack", stack), "apps", space.StackCounts[stack], "g"))
+		}
+		if result.DeploymentsCollected {
+			summary := cfclient.SummarizeDeployments(space, result.EventsSince)
+			lines = append(lines,
+				statsd.line(scope, "deployments", summary.Deployments, "g"),
+				statsd.line(scope, "rolling_deployments", summary.Rolling, "g"),
+				statsd.line(scope, "canceled_deployments", summary.Canceled, "g"),
+				statsd.line(scope, "active_deployments", summary.Active, "g"),
+				statsd.line(scope, "pinned_apps", summary.PinnedApps, "g"),
+				statsd.line(scope, "restart_updates", summary.RestartUpdates, "g"),
+				statsd.valueLine(scope, "zero_downtime_percent", strconv.FormatFloat(summary.ZeroDowntimePercent(), 'f', -1, 64), "g"),
+			)
+		}
+		if result.EnvScanned {
+			apps, suspicious := cfclient.SuspiciousEnvApps(space)
+			lines = append(lines,
+				statsd.line(scope, "env_vars", envVarCount(space), "g"),
+				statsd.line(scope, "suspicious_env_vars", suspicious, "g"),
+				statsd.line(scope, "suspicious_env_apps", apps, "g"),
+			)
+		}
+		if result.SSHCollected && space.SSHAllowed != nil {
+			lines = append(lines,
+				statsd.line(scope, "ssh_allowed", boolInt(*space.SSHAllowed), "g"),
+				statsd.line(scope, "ssh_apps", len(space.SSHApps), "g"),
+			)
+		}
+		if contains(result.Collectors, "user-provided-services") {
+			summary := cfclient.SummarizeUserProvided(space)
+			lines = append(lines,
+				statsd.line(scope, "user_provided_services", summary.Instances, "g"),
+				statsd.line(scope, "syslog_drains", summary.SyslogDrains, "g"),
+				statsd.line(scope, "route_services", summary.RouteServices, "g"),
+				statsd.line(scope, "syslog_drain_apps", summary.DrainedApps, "g"),
+				statsd.line(scope, "route_service_apps", summary.RouteServiceApps, "g"),
+			)
+		}
+		if result.ProcessesCollected {
+			for _, summary := range cfclient.SummarizeProcesses(space) {
+				processScope := append(append([]string{}, scope...), "process", tagValue(summary.Type))
+				lines = append(lines,
+					statsd.line(processScope, "apps", summary.Apps, "g"),
+					statsd.line(processScope, "instances", summary.Instances, "g"),
+					statsd.line(processScope, "memory_mb", summary.MemoryMB, "g"),
+				)
+			}
+			lines = append(lines, statsd.line(scope, "sidecars", len(space.Sidecars), "g"))
+		}
+		for _, summary := range cfclient.SummarizeTasks(space) {
+			appScope := append(append([]string{}, scope...), "app", tagValue(summary.AppName))
+			for _, state := range cfclient.TaskStateList {
+				lines = append(lines, statsd.line(append(append([]string{}, appScope...), "tasks"), strings.ToLower(state), summary.States[state], "g"))
+			}
+			lines = append(lines,
+				statsd.valueLine(appScope, "task_mean_duration_seconds", strconv.FormatFloat(summary.MeanDuration.Seconds(), 'f', -1, 64), "g"),
+				statsd.valueLine(appScope, "task_max_duration_seconds", strconv.FormatFloat(summary.MaxDuration.Seconds(), 'f', -1, 64), "g"),
+			)
+		}
+		for _, crash := range spaceAppCrashes(space) {
+			appScope := append(append([]string{}, scope...), "app", tagValue(crash.name))
+			lines = append(lines,
+				statsd.line(appScope, "crashes", crash.crashes, "g"),
+				statsd.valueLine(appScope, "crashes_per_hour", strconv.FormatFloat(result.CrashesPerHour(crash.crashes), 'f', -1, 64), "g"),
+			)
+		}
+	}
+	for _, total := range cfclient.TotalsBySegment(result.Spaces) {
+		scope := []string{"segment", total.Segment}
+		lines = append(lines,
+			statsd.line(scope, "orgs", total.Orgs, "g"),
+			statsd.line(scope, "spaces", total.Spaces, "g"),
+			statsd.line(scope, "apps", total.Apps, "g"),
+			statsd.line(scope, "instances", total.Instances, "g"),
+			statsd.line(scope, "reserved_memory_mb", total.ReservedMemoryMB, "g"),
+		)
+	}
+	routes := cfclient.RoutesPerDomain(result.Spaces, result.Domains)
+	for _, domain := range result.Domains {
+		lines = append(lines, statsd.line([]string{"domain", domain.Name}, "routes", routes[domain.Name], "g"))
+	}
+	for _, group := range result.SecurityGroups {
+		scope := []string{"security_group", group.Name}
+		lines = append(lines,
+			statsd.line(scope, "rules", len(group.Rules), "g"),
+			statsd.line(scope, "wide_open_rules", len(group.WideOpenRules()), "g"),
+			statsd.line(scope, "running_spaces", len(group.RunningSpaceGUIDs), "g"),
+			statsd.line(scope, "staging_spaces", len(group.StagingSpaceGUIDs), "g"),
+		)
+	}
+	if contains(result.Collectors, "builds") {
+		for _, space := range result.Spaces {
+			lines = append(lines, statsd.buildLines([]string{"org", tagValue(orgNames[space.OrganizationGUID]), "space", space.Name}, cfclient.SummarizeBuilds(space.Builds))...)
+		}
+		byBuildpack := cfclient.SummarizeBuildsByBuildpack(result.Spaces)
+		for _, buildpack := range sortedBuildpacks(byBuildpack) {
+			lines = append(lines, statsd.buildLines([]string{"buildpack", tagValue(buildpack)}, byBuildpack[buildpack])...)
+		}
+	}
+	if contains(result.Collectors, "blobs") {
+		footprints := cfclient.OrgBlobstoreFootprints(result.Spaces)
+		for _, org := range result.Orgs {
+			scope := []string{"org", org.Name}
+			lines = append(lines,
+				statsd.valueLine(scope, "blobstore_droplet_bytes", strconv.FormatInt(footprints[org.GUID].DropletBytes, 10), "g"),
+				statsd.valueLine(scope, "blobstore_package_bytes", strconv.FormatInt(footprints[org.GUID].PackageBytes, 10), "g"),
+				statsd.line(scope, "large_droplet_apps", footprints[org.GUID].LargeApps, "g"),
+			)
+		}
+		for _, space := range result.Spaces {
+			for _, blobs := range space.Blobs {
+				appScope := []string{"org", tagValue(orgNames[space.OrganizationGUID]), "space", space.Name, "app", tagValue(blobs.AppName)}
+				lines = append(lines,
+					statsd.valueLine(appScope, "droplet_bytes", strconv.FormatInt(blobs.DropletBytes, 10), "g"),
+					statsd.valueLine(appScope, "largest_droplet_bytes", strconv.FormatInt(blobs.LargestDropletBytes, 10), "g"),
+					statsd.valueLine(appScope, "package_bytes", strconv.FormatInt(blobs.PackageBytes, 10), "g"),
+				)
+			}
+		}
+	}
+	if contains(result.Collectors, "catalog") {
+		catalog := result.ServiceCatalog
+		for _, plan := range catalog.Plans {
+			lines = append(lines, statsd.line([]string{"catalog", "service", plan.ServiceName, "plan", plan.Name}, "instances", plan.Instances, "g"))
+		}
+		lines = append(lines,
+			statsd.line([]string{"catalog"}, "plans", len(catalog.Plans), "g"),
+			statsd.line([]string{"catalog"}, "public_plans", len(catalog.PublicPlans()), "g"),
+			statsd.line([]string{"catalog"}, "unused_plans", len(catalog.UnusedPlans()), "g"),
+			statsd.line([]string{"catalog"}, "service_brokers", len(catalog.Brokers), "g"),
+		)
+	}
+	for _, flag := range result.Platform.FeatureFlags {
+		lines = append(lines, statsd.line([]string{"feature_flag", flag.Name}, "enabled", boolInt(flag.Enabled), "g"))
+	}
+	for _, endpoint := range result.Requests {
+		scope := []string{"endpoint", endpointMetricName(endpoint.Endpoint)}
+		lines = append(lines,
+			statsd.line(scope, "requests", int(endpoint.Requests), "c"),
+			statsd.line(scope, "errors", int(endpoint.Errors), "c"),
+			statsd.line(scope, "retries", int(endpoint.Retries), "c"),
+			statsd.valueLine(scope, "latency_mean_seconds", strconv.FormatFloat(endpoint.MeanLatency().Seconds(), 'f', -1, 64), "g"),
+			statsd.valueLine(scope, "latency_p95_seconds", strconv.FormatFloat(endpoint.Quantile(0.95).Seconds(), 'f', -1, 64), "g"),
+		)
+	}
+	lines = append(lines,
+		statsd.line(nil, "collections", 1, "c"),
+		statsd.line(nil, "collection_errors", len(result.Failures), "c"),
+	)
+	return lines
+}
+
+//writeInstances sends what the nozzle heard as gauges and counters per app instance, e.g.
+//cf_metrics.instance.<org>.<space>.<app>.<index>.cpu
+func (statsd statsdSink) writeInstances(samples []instanceSample, timestamp time.Time) error {
+	var lines []string
+	for _, sample := range samples {
+		scope := []string{"org", tagValue(sample.org), "space", tagValue(sample.space), "app", tagValue(sample.app), "instance", tagValue(sample.instance)}
+		for _, name := range sortedKeys(sample.gauges) {
+			lines = append(lines, statsd.valueLine(scope, name, strconv.FormatFloat(sample.gauges[name], 'f', -1, 64), "g"))
+		}
+		for _, name := range sortedKeys(sample.counters) {
+			lines = append(lines, statsd.valueLine(scope, name, strconv.FormatUint(sample.counters[name], 10), "c"))
+		}
+	}
+	return statsd.send(lines)
+}
+
+//line is a single metric. scope is label pairs, e.g. org, <name>, space, <name>
+func (statsd statsdSink) line(scope []string, metric string, value int, kind string) string {
+	return statsd.valueLine(scope, metric, strconv.Itoa(value), kind)
+}
+
+func (statsd statsdSink) valueLine(scope []string, metric string, value string, kind string) string {
+	name := strings.TrimSuffix(statsd.prefix, ".")
+	if statsd.foundationInName && !statsd.datadog {
+		name += "." + statsdNameUnsafe.ReplaceAllString(statsd.foundation, "_")
+	}
+	//tags are name, value pairs
+	var tags []string
+	if statsd.foundation != "" {
+		tags = append(tags, "foundation", statsd.foundation)
+	}
+
+	if len(scope) > 0 {
+		//the last label names the level, e.g. org or space
+		name += "." + scope[len(scope)-2]
+	}
+	for i := 0; i+1 < len(scope); i += 2 {
+		if statsd.datadog {
+			tags = append(tags, scope[i], strings.Replace(scope[i+1], ",", "_", -1))
+		} else {
+			name += "." + statsdNameUnsafe.ReplaceAllString(scope[i+1], "_")
+		}
+	}
+	name, tags, keep := metricRules.apply(strings.TrimPrefix(name+"."+metric, "."), tags)
+	if !keep {
+		return ""
+	}
+
+	line := fmt.Sprintf("%s:%s|%s", name, value, kind)
+	if statsd.datadog && len(tags) > 0 {
+		var rendered []string
+		for i := 0; i+1 < len(tags); i += 2 {
+			rendered = append(rendered, tags[i]+":"+tags[i+1])
+		}
+		line += "|#" + strings.Join(rendered, ",")
+	}
+	return line
+}
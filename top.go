@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//topColumns are what report top can rank apps by, and the column each ranking prints as
+var topColumns = map[string]string{
+	"memory":    "reserved_memory_mb",
+	"instances": "instances",
+	"crashes":   "crashes",
+}
+
+//rankedApp and rankedSpace are the entries report top prints
+type rankedApp struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+	//Value is what the apps were ranked by: reserved memory in mb, instances or crashes
+	Value int `json:"value"`
+}
+
+type rankedSpace struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Name       string `json:"name"`
+	GUID       string `json:"guid"`
+	Apps       int    `json:"apps"`
+}
+
+type topReport struct {
+	By     string        `json:"by"`
+	Apps   []rankedApp   `json:"apps"`
+	Spaces []rankedSpace `json:"spaces"`
+}
+
+//appRankValue is an app's reserved memory, instances or crash events in the event window
+func appRankValue(by string, app cfclient.Resource, crashes map[string]int) int {
+	switch by {
+	case "instances":
+		return cfclient.EntityInt(app, "instances")
+	case "crashes":
+		return crashes[app.Metadata.GUID]
+	}
+	return cfclient.EntityInt(app, "instances") * cfclient.EntityInt(app, "memory")
+}
+
+//add adds every app and space of a run to the report, unranked
+func (report *topReport) add(foundation string, result cfclient.CollectionResult) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		org := orgNames[space.OrganizationGUID]
+		report.Spaces = append(report.Spaces, rankedSpace{
+			Foundation: foundation,
+			Org:        org,
+			Name:       space.Name,
+			GUID:       space.GUID,
+			Apps:       len(space.Apps),
+		})
+		crashes := cfclient.AppCrashes(space.Events)
+		for _, app := range space.Apps {
+			report.Apps = append(report.Apps, rankedApp{
+				Foundation: foundation,
+				Org:        org,
+				Space:      space.Name,
+				Name:       cfclient.EntityString(app, "name"),
+				GUID:       app.Metadata.GUID,
+				Value:      appRankValue(report.By, app, crashes),
+			})
+		}
+	}
+}
+
+//rank sorts the apps and spaces biggest first and keeps the top n of each. ties go by name
+//so the same foundation ranks the same way every time
+func (report *topReport) rank(n int) {
+	sort.SliceStable(report.Apps, func(i, j int) bool {
+		a, b := report.Apps[i], report.Apps[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		return a.Org+"/"+a.Space+"/"+a.Name < b.Org+"/"+b.Space+"/"+b.Name
+	})
+	sort.SliceStable(report.Spaces, func(i, j int) bool {
+		a, b := report.Spaces[i], report.Spaces[j]
+		if a.Apps != b.Apps {
+			return a.Apps > b.Apps
+		}
+		return a.Org+"/"+a.Name < b.Org+"/"+b.Name
+	})
+	//apps with nothing to rank them by, e.g. none crashing, aren't worth listing
+	for len(report.Apps) > 0 && report.Apps[len(report.Apps)-1].Value == 0 {
+		report.Apps = report.Apps[:len(report.Apps)-1]
+	}
+	if len(report.Apps) > n {
+		report.Apps = report.Apps[:n]
+	}
+	if len(report.Spaces) > n {
+		report.Spaces = report.Spaces[:n]
+	}
+	if report.Apps == nil {
+		report.Apps = []rankedApp{}
+	}
+	if report.Spaces == nil {
+		report.Spaces = []rankedSpace{}
+	}
+}
+
+//write prints the report as json, or as one table of apps then spaces
+func (report topReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"rank", "kind", "foundation", "org", "space", "app", topColumns[report.By], "apps"}}
+	for index, app := range report.Apps {
+		rows = append(rows, []string{strconv.Itoa(index + 1), "app", app.Foundation, app.Org, app.Space, app.Name, strconv.Itoa(app.Value), ""})
+	}
+	for index, space := range report.Spaces {
+		rows = append(rows, []string{strconv.Itoa(index + 1), "space", space.Foundation, space.Org, space.Name, "", "", strconv.Itoa(space.Apps)})
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//collectTrigger is a collection asked for with POST /api/v1/collect, run as soon as the
+//daemon is between runs instead of at the next scheduled time
+type collectTrigger struct {
+	//foundation is the only foundation to collect, every one when empty
+	foundation string
+	scope      cfclient.RunScope
+}
+
+//runs are the runs of a daemon the trigger is for: the foundation it names, and clients that
+//run at least one of the collectors it names
+func (trigger collectTrigger) runs(runs []foundationRun) []foundationRun {
+	var picked []foundationRun
+	for _, run := range runs {
+		if trigger.foundation != "" && run.options.foundation != trigger.foundation {
+			continue
+		}
+		if len(trigger.scope.Collectors) > 0 && !containsAny(run.client.Collectors(), trigger.scope.Collectors) {
+			continue
+		}
+		picked = append(picked, run)
+	}
+	return picked
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+	return false
+}
+
+//collectTriggers hands a trigger to every daemon, one per profile. each holds on to one
+//trigger at most, a daemon already holding one runs that
+type collectTriggers struct {
+	queues []chan collectTrigger
+}
+
+func newCollectTriggers(daemons int) *collectTriggers {
+	triggers := &collectTriggers{}
+	for index := 0; index < daemons; index++ {
+		triggers.queues = append(triggers.queues, make(chan collectTrigger, 1))
+	}
+	return triggers
+}
+
+//queue is the daemon's triggers, nil when nothing can trigger a run
+func (triggers *collectTriggers) queue(index int) <-chan collectTrigger {
+	if triggers == nil {
+		return nil
+	}
+	return triggers.queues[index]
+}
+
+//trigger queues trigger for every daemon that isn't holding one already, false when they
+//all were
+func (triggers *collectTriggers) trigger(trigger collectTrigger) bool {
+	queued := false
+	for _, queue := range triggers.queues {
+		select {
+		case queue <- trigger:
+			queued = true
+		default:
+		}
+	}
+	return queued
+}
+
+//collectHandler answers POST /api/v1/collect, queueing a run of every foundation or the
+//?foundation= one, narrowed down to an ?org= and ?space= and the ?collector= ones (comma
+//separated) when they're given. it only triggers runs with an -api-token to check
+func collectHandler(token string, foundations []string, triggers *collectTriggers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST to trigger a collection"})
+			return
+		}
+		if token == "" {
+			writeAPIJSON(w, http.StatusForbidden, map[string]string{"error": "triggering a collection needs an -api-token"})
+			return
+		}
+		query := r.URL.Query()
+		trigger := collectTrigger{foundation: query.Get("foundation"), scope: cfclient.RunScope{Org: query.Get("org"), Space: query.Get("space")}}
+		if trigger.foundation != "" && !contains(foundations, trigger.foundation) {
+			writeAPIJSON(w, http.StatusBadRequest, map[string]string{"error": "there's no foundation " + trigger.foundation + ", the foundations are " + strings.Join(foundations, ", ")})
+			return
+		}
+		for _, names := range query["collector"] {
+			for _, name := range strings.Split(names, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					continue
+				}
+				if !contains(cfclient.CollectorNames(), name) {
+					writeAPIJSON(w, http.StatusBadRequest, map[string]string{"error": "there's no " + name + " collector, the collectors are " + strings.Join(cfclient.CollectorNames(), ", ")})
+					return
+				}
+				trigger.scope.Collectors = append(trigger.scope.Collectors, name)
+			}
+		}
+		if !triggers.trigger(trigger) {
+			writeAPIJSON(w, http.StatusConflict, map[string]string{"error": "a triggered collection is already waiting to run"})
+			return
+		}
+		writeAPIJSON(w, http.StatusAccepted, map[string]interface{}{"status": "queued", "foundation": trigger.foundation, "scope": trigger.scope})
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+var topSorts = []string{"memory", "apps", "instances", "crashes", "name"}
+
+//topKeys are what cf-metrics top does with a key press
+var topKeys = map[byte]string{
+	'm': "memory",
+	'a': "apps",
+	'i': "instances",
+	'c': "crashes",
+	'n': "name",
+}
+
+//topView is what cf-metrics top shows: the latest collection of every foundation, and which
+//table it is showing sorted how. the collector and the screen share it
+type topView struct {
+	mu          sync.Mutex
+	foundations []string
+	results     map[string]cfclient.CollectionResult
+	collected   map[string]time.Time
+	errors      map[string]string
+	collecting  string
+	since       time.Time
+	spaces      bool
+	sortBy      string
+	reverse     bool
+}
+
+//topRow is an org, or a space when the view is showing spaces
+type topRow struct {
+	foundation string
+	org        string
+	space      string
+	apps       int
+	instances  int
+	memoryMB   int
+	//quota is the percent of its memory quota in use, -1 without one
+	quota   float64
+	crashes int
+}
+
+//topCrash is a crash event from the latest collection
+type topCrash struct {
+	foundation string
+	org        string
+	space      string
+	app        string
+	at         time.Time
+}
+
+func newTopView(foundations []string, since time.Time, spaces bool, sortBy string) *topView {
+	return &topView{
+		foundations: foundations,
+		results:     map[string]cfclient.CollectionResult{},
+		collected:   map[string]time.Time{},
+		errors:      map[string]string{},
+		since:       since,
+		spaces:      spaces,
+		sortBy:      sortBy,
+	}
+}
+
+//key handles a key press, false means quit
+func (view *topView) key(key byte) bool {
+	view.mu.Lock()
+	defer view.mu.Unlock()
+	switch key {
+	case 'q', 'Q':
+		return false
+	case 'o':
+		view.spaces = false
+	case 's':
+		view.spaces = true
+	default:
+		sortBy, known := topKeys[key]
+		if !known {
+			break
+		}
+		//the same sort again flips it
+		view.reverse = sortBy == view.sortBy && !view.reverse
+		view.sortBy = sortBy
+	}
+	return true
+}
+
+func (view *topView) rows() []topRow {
+	var rows []topRow
+	for _, foundation := range view.foundations {
+		result, collected := view.results[foundation]
+		if !collected {
+			continue
+		}
+		orgNames := map[string]string{}
+		for _, org := range result.Orgs {
+			orgNames[org.GUID] = org.Name
+		}
+		list := result.Orgs
+		if view.spaces {
+			list = result.Spaces
+		}
+		for _, data := range list {
+			instances, memory := cfclient.AppTotals(data.Apps)
+			row := topRow{foundation: foundation, org: data.Name, apps: len(data.Apps), instances: instances, memoryMB: memory, quota: -1}
+			if view.spaces {
+				row.org, row.space = orgNames[data.OrganizationGUID], data.Name
+			}
+			if data.Quota != nil && data.Quota.Quota.MemoryLimitMB >= 0 {
+				row.quota = data.Quota.MemoryPercent
+			}
+			for _, count := range cfclient.AppCrashes(data.Events) {
+				row.crashes += count
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	value := func(row topRow) int {
+		switch view.sortBy {
+		case "apps":
+			return row.apps
+		case "instances":
+			return row.instances
+		case "crashes":
+			return row.crashes
+		}
+		return row.memoryMB
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if view.reverse {
+			a, b = b, a
+		}
+		name := a.foundation+"/"+a.org+"/"+a.space < b.foundation+"/"+b.org+"/"+b.space
+		if view.sortBy == "name" || value(a) == value(b) {
+			return name
+		}
+		return value(a) > value(b)
+	})
+	return rows
+}
+
+//crashes are the crash events of the latest collections, newest first
+func (view *topView) crashes() []topCrash {
+	var crashes []topCrash
+	for _, foundation := range view.foundations {
+		result := view.results[foundation]
+		orgNames := map[string]string{}
+		for _, org := range result.Orgs {
+			orgNames[org.GUID] = org.Name
+		}
+		for _, space := range result.Spaces {
+			for _, event := range space.Events {
+				if cfclient.IsCrashEvent(event.Type) {
+					crashes = append(crashes, topCrash{foundation: foundation, org: orgNames[space.OrganizationGUID], space: space.Name, app: event.ActeeName, at: event.Timestamp})
+				}
+			}
+		}
+	}
+	sort.SliceStable(crashes, func(i, j int) bool {
+		return crashes[i].at.After(crashes[j].at)
+	})
+	return crashes
+}
+
+//render draws the whole screen at width by height
+func (view *topView) render(width int, height int, now time.Time) string {
+	view.mu.Lock()
+	defer view.mu.Unlock()
+
+	var status []string
+	for _, foundation := range view.foundations {
+		switch {
+		case view.collecting == foundation:
+			status = append(status, foundation+" collecting...")
+		case view.errors[foundation] != "":
+			status = append(status, foundation+" failed: "+view.errors[foundation])
+		case view.collected[foundation].IsZero():
+			status = append(status, foundation+" waiting")
+		default:
+			status = append(status, foundation+" "+now.Sub(view.collected[foundation]).Truncate(time.Second).String()+" ago")
+		}
+	}
+	lines := []string{
+		"cf-metrics top  " + strings.Join(status, ", "),
+		"[o]rgs [s]paces  sort by [m]emory [a]pps [i]nstances [c]rashes [n]ame (again to reverse)  [r]efresh  [q]uit",
+		"",
+	}
+
+	crashes := view.crashes()
+	crashLines := 0
+	if len(crashes) > 0 {
+		crashLines = min(len(crashes)+2, max(height/3, 4))
+	}
+	multiple := len(view.foundations) > 1
+	columns := []struct {
+		title string
+		sort  string
+	}{{"FOUNDATION", "name"}, {"ORG", "name"}, {"SPACE", "name"}, {"APPS", "apps"}, {"INSTANCES", "instances"}, {"MEMORY MB", "memory"}, {"QUOTA %", ""}, {"CRASHES", "crashes"}}
+	var header []string
+	for _, column := range columns {
+		if (column.title == "FOUNDATION" && !multiple) || (column.title == "SPACE" && !view.spaces) {
+			continue
+		}
+		title := column.title
+		if column.sort == view.sortBy && (view.sortBy != "name" || column.title == "ORG") {
+			title += map[bool]string{false: " v", true: " ^"}[view.reverse]
+		}
+		header = append(header, title)
+	}
+
+	rows := view.rows()
+	table := [][]string{header}
+	for _, row := range rows {
+		var cells []string
+		if multiple {
+			cells = append(cells, row.foundation)
+		}
+		cells = append(cells, row.org)
+		if view.spaces {
+			cells = append(cells, row.space)
+		}
+		quota := ""
+		if row.quota >= 0 {
+			quota = strconv.FormatFloat(row.quota, 'f', 1, 64)
+		}
+		cells = append(cells, strconv.Itoa(row.apps), strconv.Itoa(row.instances), strconv.Itoa(row.memoryMB), quota, strconv.Itoa(row.crashes))
+		table = append(table, cells)
+	}
+	tableLines := alignColumns(table, len(header)-5)
+	room := height - len(lines) - crashLines
+	for index, line := range tableLines {
+		if index >= room {
+			break
+		}
+		if index == 0 {
+			line = "\033[7m" + padRight(line, width) + "\033[0m"
+		}
+		lines = append(lines, line)
+	}
+	if len(rows) == 0 && room > 1 {
+		lines = append(lines, "nothing collected yet")
+	}
+
+	if crashLines > 0 {
+		for len(lines) < height-crashLines {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "", "\033[7m"+padRight("RECENT CRASHES since "+view.since.Local().Format("Jan 2 15:04"), width)+"\033[0m")
+		for _, crash := range crashes[:crashLines-2] {
+			where := crash.org + "/" + crash.space + "/" + crash.app
+			if multiple {
+				where = crash.foundation + " " + where
+			}
+			lines = append(lines, crash.at.Local().Format("15:04:05")+"  "+where)
+		}
+	}
+
+	var screen strings.Builder
+	for index, line := range lines {
+		if index >= height {
+			break
+		}
+		if index > 0 {
+			screen.WriteString("\r\n")
+		}
+		screen.WriteString(fitWidth(line, width) + "\033[K")
+	}
+	return screen.String()
+}
+
+//alignColumns pads table's cells into columns, left aligned for the first names columns and
+//right aligned for the numbers after them
+func alignColumns(table [][]string, names int) []string {
+	widths := make([]int, len(table[0]))
+	for _, row := range table {
+		for index, cell := range row {
+			widths[index] = max(widths[index], utf8.RuneCountInString(cell))
+		}
+	}
+	var lines []string
+	for _, row := range table {
+		cells := make([]string, len(row))
+		for index, cell := range row {
+			if index < names {
+				cells[index] = padRight(cell, widths[index])
+			} else {
+				cells[index] = strings.Repeat(" ", widths[index]-utf8.RuneCountInString(cell)) + cell
+			}
+		}
+		lines = append(lines, strings.Join(cells, "  "))
+	}
+	return lines
+}
+
+func padRight(text string, width int) string {
+	return text + strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0))
+}
+
+//fitWidth cuts a line down to width characters, leaving the escape codes the header and
+//crash titles start and end with alone
+func fitWidth(line string, width int) string {
+	visible, inEscape := 0, false
+	for index, r := range line {
+		switch {
+		case r == '\033':
+			inEscape = true
+		case inEscape:
+			inEscape = r < '@' || r > '~'
+		default:
+			visible++
+			if visible > width {
+				cut := line[:index]
+				if strings.Contains(cut, "\033[7m") {
+					cut += "\033[0m"
+				}
+				return cut
+			}
+		}
+	}
+	return line
+}
+
+//collect runs a collection of every foundation in turn, forever, every interval or when
+//refresh is sent, and tells updated each time the view changes
+func (view *topView) collect(ctx context.Context, foundations []foundation, interval time.Duration, refresh <-chan struct{}, updated chan<- struct{}) {
+	notify := func() {
+		select {
+		case updated <- struct{}{}:
+		default:
+		}
+	}
+	for {
+		for _, target := range foundations {
+			view.mu.Lock()
+			view.collecting = target.name
+			view.mu.Unlock()
+			notify()
+			result, err := target.client.Collect(ctx, false)
+			if ctx.Err() != nil {
+				return
+			}
+			view.mu.Lock()
+			view.collecting = ""
+			if err != nil {
+				view.errors[target.name] = err.Error()
+			} else {
+				delete(view.errors, target.name)
+				view.results[target.name] = result
+				view.collected[target.name] = time.Now()
+			}
+			view.mu.Unlock()
+			notify()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh:
+		case <-time.After(interval):
+		}
+	}
+}
+
+//terminalMode puts the terminal in cbreak mode, so every key press is read as it's typed and
+//not echoed while ctrl-c still interrupts, and returns what puts it back. there's no terminal
+//package vendored, so it's stty
+func terminalMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("could not read the terminal settings: %s", err)
+	}
+	_, err = stty("-icanon", "-echo", "min", "1")
+	if err != nil {
+		return nil, fmt.Errorf("could not set up the terminal: %s", err)
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+//terminalSize is the terminal's width and height, 80x24 when stty can't say
+func terminalSize() (int, int) {
+	size, err := stty("size")
+	if err == nil {
+		var rows, columns int
+		_, err = fmt.Sscan(size, &rows, &columns)
+		if err == nil && rows > 0 && columns > 0 {
+			return columns, rows
+		}
+	}
+	return 80, 24
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+//runTop shows the view full screen until q or ctx is done, collecting in the background
+func runTop(ctx context.Context, foundations []foundation, view *topView, interval time.Duration) error {
+	restore, err := terminalMode()
+	if err != nil {
+		return err
+	}
+	defer restore()
+	//the alternate screen, so the shell's scrollback is back as it was after
+	fmt.Print("\033[?1049h\033[?25l")
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	refresh := make(chan struct{}, 1)
+	updated := make(chan struct{}, 1)
+	go view.collect(ctx, foundations, interval, refresh, updated)
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		width, height := terminalSize()
+		fmt.Print("\033[H" + view.render(width, height, time.Now()) + "\033[J")
+		select {
+		case <-ctx.Done():
+			return nil
+		case key, open := <-keys:
+			if !open || !view.key(key) {
+				return nil
+			}
+			if key == 'r' {
+				select {
+				case refresh <- struct{}{}:
+				default:
+				}
+			}
+		case <-updated:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//userProvidedRow is a space report user-provided prints, or one of its instances when Instance
+//is set
+type userProvidedRow struct {
+	Foundation string `json:"foundation"`
+	Org        string `json:"org"`
+	Space      string `json:"space"`
+	SpaceGUID  string `json:"space_guid"`
+	Instance   string `json:"instance,omitempty"`
+	//SyslogDrainURL and RouteServiceURL are an instance's, without their credentials
+	SyslogDrainURL  string `json:"syslog_drain_url,omitempty"`
+	RouteServiceURL string `json:"route_service_url,omitempty"`
+	//Summary is the space's counts, for an instance row only its own bindings and route apps
+	Summary cfclient.UserProvidedSummary `json:"summary"`
+}
+
+type userProvidedReport struct {
+	Rows []userProvidedRow `json:"rows"`
+	//Instances, DrainedApps and RouteServiceApps are the totals across every foundation
+	Instances        int `json:"instances"`
+	DrainedApps      int `json:"drained_apps"`
+	RouteServiceApps int `json:"route_service_apps"`
+}
+
+//add adds a run's spaces that have user provided services, each followed by its instances
+func (report *userProvidedReport) add(foundation string, result cfclient.CollectionResult) {
+	orgNames := map[string]string{}
+	for _, org := range result.Orgs {
+		orgNames[org.GUID] = org.Name
+	}
+	for _, space := range result.Spaces {
+		summary := cfclient.SummarizeUserProvided(space)
+		if summary.Instances == 0 {
+			continue
+		}
+		report.Instances += summary.Instances
+		report.DrainedApps += summary.DrainedApps
+		report.RouteServiceApps += summary.RouteServiceApps
+		row := userProvidedRow{Foundation: foundation, Org: orgNames[space.OrganizationGUID], Space: space.Name, SpaceGUID: space.GUID, Summary: summary}
+		report.Rows = append(report.Rows, row)
+		for _, instance := range space.UserProvidedServices {
+			instanceRow := row
+			instanceRow.Instance, instanceRow.SyslogDrainURL, instanceRow.RouteServiceURL = instance.Name, instance.SyslogDrainURL, instance.RouteServiceURL
+			instanceRow.Summary = cfclient.SummarizeUserProvided(cfclient.Data{UserProvidedServices: []cfclient.UserProvidedService{instance}})
+			report.Rows = append(report.Rows, instanceRow)
+		}
+	}
+}
+
+//sort lists the rows by foundation, org and space, each space before its instances
+func (report *userProvidedReport) sort() {
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Foundation+"/"+a.Org+"/"+a.Space != b.Foundation+"/"+b.Org+"/"+b.Space {
+			return a.Foundation+"/"+a.Org+"/"+a.Space < b.Foundation+"/"+b.Org+"/"+b.Space
+		}
+		return a.Instance < b.Instance
+	})
+	if report.Rows == nil {
+		report.Rows = []userProvidedRow{}
+	}
+}
+
+func (report userProvidedReport) write(out io.Writer, format string) error {
+	rows := [][]string{{"foundation", "org", "space", "instance", "syslog_drain_url", "route_service_url", "instances", "syslog_drain_apps", "route_service_apps"}}
+	for _, row := range report.Rows {
+		instances := strconv.Itoa(row.Summary.Instances)
+		if row.Instance != "" {
+			instances = ""
+		}
+		rows = append(rows, []string{row.Foundation, row.Org, row.Space, row.Instance, row.SyslogDrainURL, row.RouteServiceURL, instances,
+			strconv.Itoa(row.Summary.DrainedApps), strconv.Itoa(row.Summary.RouteServiceApps)})
+	}
+	return writeListing(out, format, report, rows)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aanelli/cf-metrics/pkg/cfclient"
+)
+
+//wavefrontNameUnsafe matches everything that can't go in a wavefront metric name
+var wavefrontNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.,/-]`)
+
+//wavefrontSink sends every run in the wavefront data format, either straight to a tanzu
+//observability instance with an api token or to a wavefront proxy over tcp. the source is
+//the foundation and org and space are point tags, e.g.
+//cf.space.apps 12 1700000000 source="prod" org="acme" space="dev"
+type wavefrontSink struct {
+	//url is the instance for direct ingestion, proxy a host:port, only one is set
+	url        string
+	token      string
+	proxy      string
+	prefix     string
+	foundation string
+}
+
+func (wavefront wavefrontSink) name() string {
+	if wavefront.proxy != "" {
+		return "wavefront proxy"
+	}
+	return "wavefront"
+}
+
+func (wavefront wavefrontSink) write(result cfclient.CollectionResult, timestamp time.Time) error {
+	body := []byte(strings.Join(wavefront.lines(result, timestamp), "\n") + "\n")
+	if wavefront.proxy != "" {
+		conn, err := net.DialTimeout("tcp", wavefront.proxy, time.Minute)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Minute))
+		_, err = conn.Write(body)
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(wavefront.url, "/")+"/report?f=wavefront", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+wavefront.token)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		answer, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("wavefront returned %d: %s", resp.StatusCode, string(answer))
+	}
+	return nil
+}
+
+//lines renders the same counts the postgres sink stores. metrics are named after what they
+//count, cf.org.*, cf.space.* or cf.foundation.*, and audit events are cf.<scope>.events
+//with an event_type tag
+func (wavefront wavefrontSink) lines(result cfclient.CollectionResult, timestamp time.Time) []string {
+	var lines []string
+	for _, point := range metricPoints(wavefront.foundation, result, timestamp) {
+		tags := [][2]string{}
+		for _, key := range []string{"org", "space"} {
+			if point.tags[key] != "" {
+				tags = append(tags, [2]string{key, point.tags[key]})
+			}
+		}
+		tags = append(tags, point.dimensions()...)
+		line := fmt.Sprintf("%s %s %d source=%s", wavefrontNameUnsafe.ReplaceAllString(wavefront.prefix+"."+point.scope()+"."+point.name, "_"),
+			strconv.FormatFloat(point.value, 'f', -1, 64), point.timestamp.Unix(), wavefrontQuote(wavefront.foundation))
+		for _, tag := range tags {
+			line += " " + wavefrontNameUnsafe.ReplaceAllString(tag[0], "_") + "=" + wavefrontQuote(tag[1])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+//wavefrontQuote double quotes a source or point tag value, escaping the quotes in it
+func wavefrontQuote(value string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+}